@@ -0,0 +1,238 @@
+package batcher
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+type AdaptiveResource interface {
+	RateLimiter
+	WithIncrease(val uint32) AdaptiveResource
+	WithDecrease(factor float64) AdaptiveResource
+	WithDecreaseInterval(val time.Duration) AdaptiveResource
+	WithMinCapacity(val uint32) AdaptiveResource
+	ReportThrottle()
+	ReportSuccess()
+}
+
+// adaptiveResource wraps a RateLimiter and applies AIMD (additive increase, multiplicative decrease) to its capacity based on the
+// throttling reported by the datastore. The limit starts unset (so the wrapped RateLimiter's capacity is used) and is only set once
+// a throttle is reported.
+type adaptiveResource struct {
+	EventerBase
+
+	// configuration items that should not change after Start()
+	limiter          RateLimiter
+	increase         uint32
+	decrease         float64
+	decreaseInterval time.Duration
+	minCapacity      uint32
+
+	// manage the phase
+	phaseMutex sync.Mutex
+	phase      int
+
+	// the limit is math.MaxUint32 when there is none; changed is closed (and replaced) whenever the limit changes
+	limitMutex   sync.Mutex
+	limit        uint32
+	lastDecrease time.Time
+	changed      chan struct{}
+	reservations reservations
+}
+
+// This method creates a new AdaptiveResource that limits Batcher to the capacity of the provided rate limiter, less any reduction
+// caused by ReportThrottle(). Call ReportThrottle() when the datastore throttles a request (for instance, an HTTP 429 or 503) and
+// ReportSuccess() when a request succeeds; the capacity is multiplied by the decrease on each throttle and recovers by the increase on
+// each success until it reaches the capacity of the wrapped rate limiter again.
+func NewAdaptiveResource(limiter RateLimiter) AdaptiveResource {
+	return &adaptiveResource{
+		limiter:          limiter,
+		decrease:         0.5,
+		decreaseInterval: 1 * time.Second,
+		limit:            math.MaxUint32,
+	}
+}
+
+// This determines how much capacity is recovered for each ReportSuccess(). If not provided, it is 1% of the wrapped rate limiter's
+// MaxCapacity (at least 1).
+func (r *adaptiveResource) WithIncrease(val uint32) AdaptiveResource {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.phase != phaseUninitialized {
+		panic(InitializationOnlyError)
+	}
+	r.increase = val
+	return r
+}
+
+// This determines what the capacity is multiplied by for each ReportThrottle(). The default is `0.5`. The factor must be greater than
+// 0 and less than 1; other values are ignored.
+func (r *adaptiveResource) WithDecrease(factor float64) AdaptiveResource {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.phase != phaseUninitialized {
+		panic(InitializationOnlyError)
+	}
+	if factor > 0 && factor < 1 {
+		r.decrease = factor
+	}
+	return r
+}
+
+// A burst of requests is commonly throttled together, so after the capacity is decreased, any ReportThrottle() within this interval is
+// ignored. The default is `1s`.
+func (r *adaptiveResource) WithDecreaseInterval(val time.Duration) AdaptiveResource {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.phase != phaseUninitialized {
+		panic(InitializationOnlyError)
+	}
+	r.decreaseInterval = val
+	return r
+}
+
+// This determines the capacity that ReportThrottle() will never decrease below. The default is `0`, though the capacity is always
+// decreased by at least 1 until it reaches 0.
+func (r *adaptiveResource) WithMinCapacity(val uint32) AdaptiveResource {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.phase != phaseUninitialized {
+		panic(InitializationOnlyError)
+	}
+	r.minCapacity = val
+	return r
+}
+
+// Call this method when the datastore throttles a request. The capacity is multiplied by the decrease (unless it was already decreased
+// within the DecreaseInterval).
+func (r *adaptiveResource) ReportThrottle() {
+	r.limitMutex.Lock()
+	now := time.Now()
+	if !r.lastDecrease.IsZero() && now.Sub(r.lastDecrease) < r.decreaseInterval {
+		r.limitMutex.Unlock()
+		return
+	}
+	r.lastDecrease = now
+	current := r.capacityWithLimit(r.limit)
+	next := uint32(float64(current) * r.decrease)
+	if next >= current && current > 0 {
+		next = current - 1
+	}
+	if next < r.minCapacity {
+		next = r.minCapacity
+	}
+	r.setLimit(next)
+	r.limitMutex.Unlock()
+	r.Emit(CapacityEvent, int(next), "", nil)
+}
+
+// Call this method when the datastore accepts a request. The capacity is increased by the increase until it reaches the capacity of
+// the wrapped rate limiter, at which point there is no longer any limit.
+func (r *adaptiveResource) ReportSuccess() {
+	r.limitMutex.Lock()
+	if r.limit == math.MaxUint32 {
+		r.limitMutex.Unlock()
+		return
+	}
+	increase := r.increase
+	if increase == 0 {
+		increase = r.limiter.MaxCapacity() / 100
+		if increase == 0 {
+			increase = 1
+		}
+	}
+	next := r.limit + increase
+	if next < r.limit || next >= r.limiter.Capacity() {
+		next = math.MaxUint32
+	}
+	r.setLimit(next)
+	r.limitMutex.Unlock()
+	r.Emit(CapacityEvent, int(r.Capacity()), "", nil)
+}
+
+// This sets the limit and wakes anything waiting for capacity. The limitMutex must be held.
+func (r *adaptiveResource) setLimit(val uint32) {
+	r.limit = val
+	if r.changed != nil {
+		close(r.changed)
+		r.changed = nil
+	}
+}
+
+func (r *adaptiveResource) capacityWithLimit(limit uint32) uint32 {
+	capacity := r.limiter.Capacity()
+	if limit < capacity {
+		return limit
+	}
+	return capacity
+}
+
+// This returns the MaxCapacity of the wrapped rate limiter. It is not reduced by throttling, so an Operation that is enqueued is not
+// rejected just because the datastore is currently throttling.
+func (r *adaptiveResource) MaxCapacity() uint32 {
+	return r.limiter.MaxCapacity()
+}
+
+// This returns the capacity of the wrapped rate limiter, less any reduction caused by ReportThrottle().
+func (r *adaptiveResource) Capacity() uint32 {
+	r.limitMutex.Lock()
+	defer r.limitMutex.Unlock()
+	return r.capacityWithLimit(r.limit)
+}
+
+// The wrapped rate limiter is asked for the target.
+func (r *adaptiveResource) GiveMe(target uint32) {
+	r.limiter.GiveMe(target)
+}
+
+// This reserves the cost if it fits within the reduced capacity (see SharedResource.Reserve() for how capacity is apportioned over the
+// ttl) and then from the wrapped rate limiter.
+func (r *adaptiveResource) Reserve(cost uint32, ttl time.Duration) (ReservationHandle, error) {
+	own, err := r.reservations.reserve(r.Capacity(), cost, ttl)
+	if err != nil {
+		return nil, err
+	}
+	inner, err := r.limiter.Reserve(cost, ttl)
+	if err != nil {
+		own.Release()
+		return nil, err
+	}
+	return compositeReservation{own, inner}, nil
+}
+
+// This blocks until the wrapped rate limiter has the capacity and it is not reduced below the cost by ReportThrottle().
+func (r *adaptiveResource) WaitForCapacity(ctx context.Context, cost uint32) error {
+	for {
+		if err := r.limiter.WaitForCapacity(ctx, cost); err != nil {
+			return err
+		}
+		r.limitMutex.Lock()
+		if r.limit >= cost {
+			r.limitMutex.Unlock()
+			return nil
+		}
+		if r.changed == nil {
+			r.changed = make(chan struct{})
+		}
+		changed := r.changed
+		r.limitMutex.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// This starts the wrapped rate limiter, so you should not start it yourself.
+func (r *adaptiveResource) Start(ctx context.Context) error {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.phase != phaseUninitialized {
+		return ImproperOrderError
+	}
+	r.phase = phaseStarted
+	return r.limiter.Start(ctx)
+}
@@ -0,0 +1,88 @@
+package batcher_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdaptiveResource_ThrottleDecreasesAndSuccessIncreases(t *testing.T) {
+	res := gobatcher.NewAdaptiveResource(gobatcher.NewTokenBucketLimiter(1000, 1000)).
+		WithIncrease(100).
+		WithDecreaseInterval(0)
+	assert.Equal(t, uint32(1000), res.Capacity(), "expecting the wrapped capacity before any throttle")
+	res.ReportThrottle()
+	assert.Equal(t, uint32(500), res.Capacity(), "expecting a throttle to halve the capacity")
+	res.ReportThrottle()
+	assert.Equal(t, uint32(250), res.Capacity(), "expecting another throttle to halve the capacity again")
+	res.ReportSuccess()
+	assert.Equal(t, uint32(350), res.Capacity(), "expecting a success to add the increase")
+	for i := 0; i < 10; i++ {
+		res.ReportSuccess()
+	}
+	assert.Equal(t, uint32(1000), res.Capacity(), "expecting the capacity to never exceed the wrapped capacity")
+	assert.Equal(t, uint32(1000), res.MaxCapacity(), "expecting the max capacity to not be affected")
+}
+
+func TestAdaptiveResource_ThrottlesWithinTheDecreaseIntervalAreIgnored(t *testing.T) {
+	res := gobatcher.NewAdaptiveResource(gobatcher.NewTokenBucketLimiter(1000, 1000)).
+		WithMinCapacity(400)
+	res.ReportThrottle()
+	res.ReportThrottle()
+	assert.Equal(t, uint32(500), res.Capacity(), "expecting only the first throttle in the interval to decrease the capacity")
+	res = gobatcher.NewAdaptiveResource(gobatcher.NewTokenBucketLimiter(1000, 1000)).
+		WithMinCapacity(400).
+		WithDecreaseInterval(0)
+	res.ReportThrottle()
+	res.ReportThrottle()
+	assert.Equal(t, uint32(400), res.Capacity(), "expecting the capacity to never decrease below the min")
+}
+
+func TestAdaptiveResource_ReserveIsLimitedByTheDecreasedCapacity(t *testing.T) {
+	res := gobatcher.NewAdaptiveResource(gobatcher.NewTokenBucketLimiter(1000, 1000))
+	res.ReportThrottle()
+	_, err := res.Reserve(50, 100*time.Millisecond)
+	assert.NoError(t, err, "expecting the reservation to fit in the decreased capacity")
+	_, err = res.Reserve(50, 100*time.Millisecond)
+	assert.Equal(t, gobatcher.InsufficientCapacityError, err, "expecting 50 to be all that the decreased capacity allows over 100ms")
+}
+
+func TestAdaptiveResource_WaitForCapacityWaitsForRecovery(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	res := gobatcher.NewAdaptiveResource(gobatcher.NewTokenBucketLimiter(1000, 1000)).
+		WithIncrease(500)
+	res.ReportThrottle()
+	waited := make(chan error, 1)
+	go func() {
+		waited <- res.WaitForCapacity(ctx, 800)
+	}()
+	select {
+	case <-waited:
+		assert.FailNow(t, "not expecting the wait to end while the capacity is decreased")
+	case <-time.After(20 * time.Millisecond):
+	}
+	res.ReportSuccess()
+	select {
+	case err := <-waited:
+		assert.NoError(t, err, "expecting the wait to end once the capacity recovered")
+	case <-time.After(1 * time.Second):
+		assert.Fail(t, "expecting the wait to end once the capacity recovered")
+	}
+}
+
+func TestAdaptiveResource_Start_InitializationAfterStartCausesPanic(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	res := gobatcher.NewAdaptiveResource(gobatcher.NewTokenBucketLimiter(1000, 1000))
+	err := res.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	assert.Equal(t, gobatcher.ImproperOrderError, res.Start(ctx), "expecting start to only be allowed once")
+	assert.PanicsWithError(t, gobatcher.InitializationOnlyError.Error(), func() { res.WithIncrease(1) })
+	assert.PanicsWithError(t, gobatcher.InitializationOnlyError.Error(), func() { res.WithDecrease(0.7) })
+	assert.PanicsWithError(t, gobatcher.InitializationOnlyError.Error(), func() { res.WithDecreaseInterval(time.Second) })
+	assert.PanicsWithError(t, gobatcher.InitializationOnlyError.Error(), func() { res.WithMinCapacity(1) })
+}
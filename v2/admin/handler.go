@@ -0,0 +1,236 @@
+// Package admin exposes an http.Handler that lets operators inspect and control a running Batcher (and its rate limiters). There is no
+// authentication, so only serve it on an internal port or behind your own middleware.
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+)
+
+// The paths are relative to where the Handler is mounted; if it is not mounted at the root, use http.StripPrefix().
+const (
+	StatsPath         = "/stats"
+	PausePath         = "/pause"
+	ResumePath        = "/resume"
+	FlushPath         = "/flush"
+	FlushIntervalPath = "/flush-interval"
+	RateLimitersPath  = "/rate-limiters"
+)
+
+var (
+	InvalidDurationError = errors.New("the duration must be a positive value such as 500ms or 30s.")
+)
+
+// RateLimiterStatus describes a rate limiter in the response of RateLimitersPath. The partition details are only provided for a
+// SharedResource.
+type RateLimiterStatus struct {
+	Name          string     `json:"name"`
+	Capacity      uint32     `json:"capacity"`
+	MaxCapacity   uint32     `json:"maxCapacity"`
+	Phase         string     `json:"phase,omitempty"`
+	Partitions    *uint32    `json:"partitions,omitempty"`
+	Target        *uint32    `json:"target,omitempty"`
+	Healthy       *bool      `json:"healthy,omitempty"`
+	LastLease     *time.Time `json:"lastLease,omitempty"`
+	LastError     string     `json:"lastError,omitempty"`
+	LastErrorTime *time.Time `json:"lastErrorTime,omitempty"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Handler is an http.Handler that serves the following endpoints:
+//
+//   - GET /stats: the Batcher's Stats() as JSON.
+//   - POST /pause: pauses the Batcher for its PauseTime, or for the "duration" query parameter (for instance, ?duration=30s).
+//   - POST /resume: resumes a paused Batcher.
+//   - POST /flush: flushes the Batcher now rather than waiting for the FlushInterval.
+//   - GET /flush-interval: the current FlushInterval; POST /flush-interval?duration=50ms changes it.
+//   - GET /rate-limiters: the capacity of each rate limiter provided to WithRateLimiter() and, for a SharedResource, its partitions.
+type Handler struct {
+	batcher gobatcher.Batcher
+	mux     *http.ServeMux
+
+	mutex         sync.RWMutex
+	flushInterval time.Duration
+	limiters      map[string]gobatcher.RateLimiter
+}
+
+// This method creates a new Handler for the provided Batcher. Provide the same FlushInterval the Batcher was configured with if it is not
+// the default, since the Batcher does not expose it.
+func NewHandler(batcher gobatcher.Batcher) *Handler {
+	h := &Handler{
+		batcher:       batcher,
+		mux:           http.NewServeMux(),
+		flushInterval: 100 * time.Millisecond,
+		limiters:      make(map[string]gobatcher.RateLimiter),
+	}
+	h.mux.HandleFunc(StatsPath, h.handleStats)
+	h.mux.HandleFunc(PausePath, h.handlePause)
+	h.mux.HandleFunc(ResumePath, h.handleResume)
+	h.mux.HandleFunc(FlushPath, h.handleFlush)
+	h.mux.HandleFunc(FlushIntervalPath, h.handleFlushInterval)
+	h.mux.HandleFunc(RateLimitersPath, h.handleRateLimiters)
+	return h
+}
+
+// This tells the Handler what FlushInterval the Batcher was configured with, so GET /flush-interval reports it correctly.
+func (h *Handler) WithFlushInterval(val time.Duration) *Handler {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.flushInterval = val
+	return h
+}
+
+// This registers a rate limiter under a name so it is reported by GET /rate-limiters. You would typically provide each rate limiter
+// given to the Batcher.
+func (h *Handler) WithRateLimiter(name string, rl gobatcher.RateLimiter) *Handler {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.limiters[name] = rl
+	return h
+}
+
+// This is called by the http.Server.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *Handler) handleStats(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	writeJSON(w, http.StatusOK, h.batcher.Stats())
+}
+
+func (h *Handler) handlePause(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodPost) {
+		return
+	}
+	if r.URL.Query().Get("duration") == "" {
+		h.batcher.Pause()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	duration, err := durationFrom(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	h.batcher.PauseFor(duration)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) handleResume(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodPost) {
+		return
+	}
+	h.batcher.Resume()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) handleFlush(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodPost) {
+		return
+	}
+	h.batcher.Flush()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) handleFlushInterval(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+	if r.Method == http.MethodPost {
+		duration, err := durationFrom(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		h.batcher.WithFlushInterval(duration)
+		h.mutex.Lock()
+		h.flushInterval = duration
+		h.mutex.Unlock()
+	}
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	writeJSON(w, http.StatusOK, map[string]string{"flushInterval": h.flushInterval.String()})
+}
+
+func (h *Handler) handleRateLimiters(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	h.mutex.RLock()
+	statuses := make([]RateLimiterStatus, 0, len(h.limiters))
+	for name, rl := range h.limiters {
+		statuses = append(statuses, statusOf(name, rl))
+	}
+	h.mutex.RUnlock()
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+func statusOf(name string, rl gobatcher.RateLimiter) RateLimiterStatus {
+	status := RateLimiterStatus{
+		Name:        name,
+		Capacity:    rl.Capacity(),
+		MaxCapacity: rl.MaxCapacity(),
+	}
+	if res, ok := rl.(gobatcher.SharedResource); ok {
+		s := res.Status()
+		healthy := res.Healthy()
+		status.Phase = s.Phase
+		status.Partitions = &s.Partitions
+		status.Target = &s.Target
+		status.Healthy = &healthy
+		if !s.LastLease.IsZero() {
+			status.LastLease = &s.LastLease
+		}
+		if s.LastError != nil {
+			status.LastError = s.LastError.Error()
+			status.LastErrorTime = &s.LastErrorTime
+		}
+	}
+	return status
+}
+
+// This returns the "duration" query parameter, which must be positive.
+func durationFrom(r *http.Request) (time.Duration, error) {
+	duration, err := time.ParseDuration(r.URL.Query().Get("duration"))
+	if err != nil || duration <= 0 {
+		return 0, InvalidDurationError
+	}
+	return duration, nil
+}
+
+// This responds with 405 (Method Not Allowed) and returns false unless the request uses one of the methods.
+func allow(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	for _, method := range methods {
+		w.Header().Add("Allow", method)
+	}
+	writeError(w, http.StatusMethodNotAllowed, errors.New(http.StatusText(http.StatusMethodNotAllowed)))
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
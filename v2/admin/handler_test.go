@@ -0,0 +1,98 @@
+package admin_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+	"github.com/plasne/go-batcher/v2/admin"
+	"github.com/stretchr/testify/assert"
+)
+
+func post(t *testing.T, url string) *http.Response {
+	resp, err := http.Post(url, "application/json", nil)
+	if !assert.NoError(t, err, "not expecting a request error") {
+		t.FailNow()
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestHandler_ControlsTheBatcher(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Hour)
+	processed := make(chan struct{}, 1)
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		processed <- struct{}{}
+	})
+	server := httptest.NewServer(admin.NewHandler(batcher).WithFlushInterval(1 * time.Hour))
+	defer server.Close()
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+
+	// pause, then check the stats
+	resp := post(t, server.URL+admin.PausePath+"?duration=1h")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	err = batcher.Enqueue(gobatcher.NewOperation(watcher, 0, struct{}{}, false))
+	assert.NoError(t, err, "not expecting an enqueue error")
+	getResp, err := http.Get(server.URL + admin.StatsPath)
+	if assert.NoError(t, err) {
+		defer getResp.Body.Close()
+		var stats gobatcher.BatcherStats
+		assert.NoError(t, json.NewDecoder(getResp.Body).Decode(&stats))
+		assert.Equal(t, uint32(1), stats.OperationsInBuffer, "expecting the operation to wait in the buffer")
+	}
+
+	// resume and flush
+	resp = post(t, server.URL+admin.ResumePath)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	time.Sleep(10 * time.Millisecond)
+	resp = post(t, server.URL+admin.FlushPath)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	select {
+	case <-processed:
+	case <-time.After(1 * time.Second):
+		assert.Fail(t, "expected the flush to raise the batch")
+	}
+
+	// change the flush interval
+	resp = post(t, server.URL+admin.FlushIntervalPath+"?duration=0s")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "expecting a positive duration")
+	resp = post(t, server.URL+admin.FlushIntervalPath+"?duration=1ms")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	err = batcher.Enqueue(gobatcher.NewOperation(watcher, 0, struct{}{}, false))
+	assert.NoError(t, err, "not expecting an enqueue error")
+	select {
+	case <-processed:
+	case <-time.After(1 * time.Second):
+		assert.Fail(t, "expected the new flush interval to raise the batch")
+	}
+}
+
+func TestHandler_ReportsRateLimiters(t *testing.T) {
+	res := gobatcher.NewSharedResource().
+		WithReservedCapacity(1000)
+	server := httptest.NewServer(admin.NewHandler(gobatcher.NewBatcher()).
+		WithRateLimiter("cosmos", res))
+	defer server.Close()
+	resp, err := http.Get(server.URL + admin.RateLimitersPath)
+	if assert.NoError(t, err) {
+		defer resp.Body.Close()
+		var statuses []admin.RateLimiterStatus
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&statuses))
+		if assert.Len(t, statuses, 1) {
+			assert.Equal(t, "cosmos", statuses[0].Name)
+			assert.Equal(t, uint32(1000), statuses[0].MaxCapacity)
+			assert.Equal(t, "uninitialized", statuses[0].Phase)
+			assert.NotNil(t, statuses[0].Partitions, "expecting the partitions of a SharedResource")
+		}
+	}
+	resp = post(t, server.URL+admin.RateLimitersPath)
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
@@ -0,0 +1,70 @@
+package batcher
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+type queuedEvent struct {
+	event    string
+	val      int
+	msg      string
+	metadata interface{}
+}
+
+// This switches the Eventer to dispatching events asynchronously through a buffer of the provided size and starts the goroutine that
+// raises them. That goroutine runs for as long as the Eventer, so emitting an event never starts a goroutine or takes a lock.
+func (r *EventerBase) enableAsyncEvents(bufferSize uint32) {
+	if bufferSize < 1 {
+		bufferSize = 1
+	}
+	r.queue = make(chan queuedEvent, bufferSize)
+	r.pendingCond = sync.NewCond(&r.pendingMutex)
+	go r.dispatchQueue(r.queue)
+}
+
+// This queues the event for the dispatch goroutine or counts it as dropped if the buffer is full.
+func (r *EventerBase) emitAsync(e queuedEvent) {
+	atomic.AddInt64(&r.pending, 1)
+	select {
+	case r.queue <- e:
+	default:
+		atomic.AddUint64(&r.dropped, 1)
+		r.eventDone()
+	}
+}
+
+// The dispatch goroutine raises the queued events in order.
+func (r *EventerBase) dispatchQueue(queue <-chan queuedEvent) {
+	for e := range queue {
+		r.dispatch(e.event, e.val, e.msg, e.metadata)
+		r.eventDone()
+	}
+}
+
+// The lock is only taken when the last pending event is done so that waitForEvents() can be woken.
+func (r *EventerBase) eventDone() {
+	if atomic.AddInt64(&r.pending, -1) == 0 {
+		r.pendingMutex.Lock()
+		r.pendingCond.Broadcast()
+		r.pendingMutex.Unlock()
+	}
+}
+
+// This blocks until every queued event has been raised to the listeners. It returns immediately if events are not asynchronous.
+func (r *EventerBase) waitForEvents() {
+	if r.queue == nil {
+		return
+	}
+	r.pendingMutex.Lock()
+	defer r.pendingMutex.Unlock()
+	for atomic.LoadInt64(&r.pending) > 0 {
+		r.pendingCond.Wait()
+	}
+}
+
+// This returns the number of events that were not raised because the buffer provided to WithAsyncEvents() was full. It is always 0 if
+// events are raised synchronously.
+func (r *EventerBase) DroppedEvents() uint64 {
+	return atomic.LoadUint64(&r.dropped)
+}
@@ -4,17 +4,61 @@ import (
 	"context"
 	"io"
 
-	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/lease"
 )
 
 // This interface describes an Azure Storage Container that can be mocked.
 type azureContainer interface {
-	Create(context.Context, azblob.Metadata, azblob.PublicAccessType) (*azblob.ContainerCreateResponse, error)
-	NewBlockBlobURL(string) azblob.BlockBlobURL
+	Create(context.Context, *container.CreateOptions) (container.CreateResponse, error)
+	NewBlockBlobClient(string) *blockblob.Client
+	NewListBlobsFlatPager(*container.ListBlobsFlatOptions) *runtime.Pager[container.ListBlobsFlatResponse]
 }
 
-// This interface describes an Azure Storage Blob that can be mocked.
+// This interface describes an Azure Storage Blob that can be mocked. The lease methods act on the lease with the provided ID.
 type azureBlob interface {
-	Upload(context.Context, io.ReadSeeker, azblob.BlobHTTPHeaders, azblob.Metadata, azblob.BlobAccessConditions, azblob.AccessTierType, azblob.BlobTagsMap, azblob.ClientProvidedKeyOptions) (*azblob.BlockBlobUploadResponse, error)
-	AcquireLease(context.Context, string, int32, azblob.ModifiedAccessConditions) (*azblob.BlobAcquireLeaseResponse, error)
+	Upload(context.Context, io.ReadSeekCloser, *blockblob.UploadOptions) (blockblob.UploadResponse, error)
+	AcquireLease(context.Context, string, int32) (lease.BlobAcquireResponse, error)
+	RenewLease(context.Context, string) (lease.BlobRenewResponse, error)
+	ReleaseLease(context.Context, string) (lease.BlobReleaseResponse, error)
+}
+
+// A blockBlob is the azureBlob for a blob in the container. The storage SDK binds a lease client to a single lease ID, so one is created
+// for each lease operation.
+type blockBlob struct {
+	client *blockblob.Client
+}
+
+func (b blockBlob) Upload(ctx context.Context, body io.ReadSeekCloser, opts *blockblob.UploadOptions) (blockblob.UploadResponse, error) {
+	return b.client.Upload(ctx, body, opts)
+}
+
+func (b blockBlob) AcquireLease(ctx context.Context, id string, duration int32) (lease.BlobAcquireResponse, error) {
+	client, err := b.lease(id)
+	if err != nil {
+		return lease.BlobAcquireResponse{}, err
+	}
+	return client.AcquireLease(ctx, duration, nil)
+}
+
+func (b blockBlob) RenewLease(ctx context.Context, id string) (lease.BlobRenewResponse, error) {
+	client, err := b.lease(id)
+	if err != nil {
+		return lease.BlobRenewResponse{}, err
+	}
+	return client.RenewLease(ctx, nil)
+}
+
+func (b blockBlob) ReleaseLease(ctx context.Context, id string) (lease.BlobReleaseResponse, error) {
+	client, err := b.lease(id)
+	if err != nil {
+		return lease.BlobReleaseResponse{}, err
+	}
+	return client.ReleaseLease(ctx, nil)
+}
+
+func (b blockBlob) lease(id string) (*lease.BlobClient, error) {
+	return lease.NewBlobClient(b.client, &lease.BlobClientOptions{LeaseID: &id})
 }
@@ -4,19 +4,104 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
 )
 
+const (
+	demandBlobPrefix  = "demand/"
+	demandMetadataKey = "target"
+	lentBlobPrefix    = "lent/"
+	lentMetadataKey   = "lent"
+	policyBlobName    = "policy"
+	minPartitionsKey  = "minpartitions"
+	storageScope      = "https://storage.azure.com/.default"
+)
+
+// TokenCredential obtains Azure AD tokens for AzureBlobLeaseManager. It has the same shape as azcore.TokenCredential (without
+// depending on azcore) so any azidentity credential (managed identity, workload identity, service principal, etc.) can be adapted
+// with TokenCredentialFunc...
+//
+//	gobatcher.TokenCredentialFunc(func(ctx context.Context, scopes []string) (string, time.Time, error) {
+//	    tok, err := cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: scopes})
+//	    return tok.Token, tok.ExpiresOn, err
+//	})
+type TokenCredential interface {
+	GetToken(ctx context.Context, scopes []string) (token string, expiresOn time.Time, err error)
+}
+
+// TokenCredentialFunc allows a function to be used as a TokenCredential.
+type TokenCredentialFunc func(ctx context.Context, scopes []string) (token string, expiresOn time.Time, err error)
+
+func (f TokenCredentialFunc) GetToken(ctx context.Context, scopes []string) (string, time.Time, error) {
+	return f(ctx, scopes)
+}
+
+// AzureBlobLeaseManager is implemented by the LeaseManager returned by each of the NewAzureBlobLeaseManager functions (which also
+// implements DemandStore, LendingStore, and PolicyStore), so you can configure it...
+//
+//	mgr := gobatcher.NewAzureBlobLeaseManager(accountName, containerName, masterKey)
+//	mgr.(gobatcher.AzureBlobLeaseManager).WithStorageRetryOptions(opts)
+type AzureBlobLeaseManager interface {
+	LeaseManager
+	WithStorageRetryOptions(opts StorageRetryOptions) AzureBlobLeaseManager
+}
+
+// StorageRetryOptions determines how AzureBlobLeaseManager sends requests to Azure Storage. Retries use an exponential backoff with
+// jitter. Any field left as zero uses the default of the storage SDK (4 tries, no TryTimeout, a 4s RetryDelay, and a 60s MaxRetryDelay).
+type StorageRetryOptions struct {
+	MaxTries      int32              // the maximum number of attempts for a request; 1 means no retries
+	TryTimeout    time.Duration      // the maximum time allowed for any single attempt
+	RetryDelay    time.Duration      // the delay before the first retry, which doubles for each retry after
+	MaxRetryDelay time.Duration      // the maximum delay before any retry
+	Policies      []policy.Policy    // additional pipeline policies, which run on every attempt
+	Transport     policy.Transporter // sends the requests; the default is an http.Client from the storage SDK
+}
+
+// This converts the options into those of the storage SDK, which counts retries rather than attempts.
+func (o StorageRetryOptions) clientOptions() azcore.ClientOptions {
+	opts := azcore.ClientOptions{
+		Retry: policy.RetryOptions{
+			TryTimeout:    o.TryTimeout,
+			RetryDelay:    o.RetryDelay,
+			MaxRetryDelay: o.MaxRetryDelay,
+		},
+		PerRetryPolicies: o.Policies,
+		Transport:        o.Transport,
+	}
+	switch {
+	case o.MaxTries == 1:
+		opts.Retry.MaxRetries = -1
+	case o.MaxTries > 1:
+		opts.Retry.MaxRetries = o.MaxTries - 1
+	}
+	return opts
+}
+
 type azureBlobLeaseManager struct {
 
 	// configuration items that should not change after Provision()
 	eventer       Eventer
+	endpoint      *string
 	accountName   *string
 	masterKey     *string
+	sasToken      *string
+	connString    *string
 	containerName *string
+	credential    TokenCredential
+	retryOptions  StorageRetryOptions
 
 	// internal properties
 	container azureContainer
@@ -34,94 +119,230 @@ func NewAzureBlobLeaseManager(accountName, containerName, masterKey string) Leas
 	return mgr
 }
 
+// This method creates a new AzureBlobLeaseManager that talks to a blob service at a custom endpoint rather than
+// https://accountName.blob.core.windows.net. This is useful for sovereign clouds, private endpoints, or the Azurite emulator
+// (for instance, "http://127.0.0.1:10000/devstoreaccount1").
+func NewAzureBlobLeaseManagerWithEndpoint(endpoint, accountName, containerName, masterKey string) LeaseManager {
+	mgr := &azureBlobLeaseManager{
+		endpoint:      &endpoint,
+		accountName:   &accountName,
+		containerName: &containerName,
+		masterKey:     &masterKey,
+	}
+	return mgr
+}
+
+// This method creates a new AzureBlobLeaseManager that authenticates to Azure Storage with Azure AD tokens from the provided credential
+// rather than the account master key. The identity must have the "Storage Blob Data Contributor" role (or equivalent) on the container.
+// The token is refreshed before it expires for as long as the SharedResource is running.
+func NewAzureBlobLeaseManagerWithTokenCredential(accountName, containerName string, credential TokenCredential) LeaseManager {
+	mgr := &azureBlobLeaseManager{
+		accountName:   &accountName,
+		containerName: &containerName,
+		credential:    credential,
+	}
+	return mgr
+}
+
+// This method creates a new AzureBlobLeaseManager that authenticates to Azure Storage with a shared access signature (SAS) rather than
+// the account master key. The SAS (with or without the leading "?") must allow reading, writing, creating, and listing blobs in the
+// container (and creating the container unless it already exists). Since a SAS expires, you should create a new SharedResource with a
+// new SAS before it does.
+func NewAzureBlobLeaseManagerWithSASToken(accountName, containerName, sasToken string) LeaseManager {
+	mgr := &azureBlobLeaseManager{
+		accountName:   &accountName,
+		containerName: &containerName,
+		sasToken:      &sasToken,
+	}
+	return mgr
+}
+
+// This method creates a new AzureBlobLeaseManager from an Azure Storage connection string, which may contain either an AccountKey or a
+// SharedAccessSignature and may specify a BlobEndpoint (or DefaultEndpointsProtocol and EndpointSuffix). The connection string is
+// parsed by Provision(), which returns InvalidConnectionStringError if it cannot be used.
+func NewAzureBlobLeaseManagerWithConnectionString(connectionString, containerName string) LeaseManager {
+	mgr := &azureBlobLeaseManager{
+		connString:    &connectionString,
+		containerName: &containerName,
+	}
+	return mgr
+}
+
+// Setting this option changes how requests to Azure Storage are sent and retried. In particular, you may want a TryTimeout shorter than
+// the 15s lease so that a hung request does not hold up obtaining capacity. It must be set before the SharedResource is started.
+func (m *azureBlobLeaseManager) WithStorageRetryOptions(opts StorageRetryOptions) AzureBlobLeaseManager {
+	m.retryOptions = opts
+	return m
+}
+
 // Events raised by AzureBlobLeaseManager must be raised to an Eventer. Specifically the SharedResource it is associated with
 // will be used as the Eventer. This method is called in SharedResource.WithSharedCapacity().
 func (m *azureBlobLeaseManager) RaiseEventsTo(e Eventer) {
 	m.eventer = e
 }
 
-// This is called by SharedResource when the Azure Blob Storage Container should be created or verified.
+// This is called by SharedResource when the Azure Blob Storage Container should be created or verified. Any error returned is
+// a *LeaseError.
 func (m *azureBlobLeaseManager) Provision(ctx context.Context) (err error) {
-
-	// choose the appropriate credential
-	var credential azblob.Credential
-	if m.masterKey != nil {
-		credential, err = azblob.NewSharedKeyCredential(*m.accountName, *m.masterKey)
+	defer func() {
 		if err != nil {
+			err = newLeaseError(LeaseOperationProvision, -1, err)
+		}
+	}()
+
+	// apply the connection string
+	if m.connString != nil {
+		if err = m.applyConnectionString(*m.connString); err != nil {
 			return
 		}
 	}
 
-	// NOTE: managed identity or AAD tokens could be used this way; tested
-	//credential := azblob.NewTokenCredential("-access-token-goes-here-", nil)
+	// the container URL is validated here since the storage SDK only parses it when a request is sent
+	ref := fmt.Sprintf("https://%s.blob.core.windows.net/%s", *m.accountName, *m.containerName)
+	if m.endpoint != nil {
+		ref = fmt.Sprintf("%s/%s", strings.TrimSuffix(*m.endpoint, "/"), *m.containerName)
+	}
+	if _, err = url.Parse(ref); err != nil {
+		return
+	}
 
-	// create pipeline and container reference
+	// create the container client with the appropriate credential
 	// NOTE: we only check for a mock container at the end to improve code-coverage
-	ref := fmt.Sprintf("https://%s.blob.core.windows.net/%s", *m.accountName, *m.containerName)
-	pipeline := azblob.NewPipeline(credential, azblob.PipelineOptions{})
-	var url *url.URL
-	url, err = url.Parse(ref)
+	opts := &container.ClientOptions{ClientOptions: m.retryOptions.clientOptions()}
+	var client *container.Client
+	switch {
+	case m.sasToken != nil:
+		// the SAS is only added to the URL of the client so that it is not raised in events
+		client, err = container.NewClientWithNoCredential(ref+"?"+strings.TrimPrefix(*m.sasToken, "?"), opts)
+	case m.credential != nil:
+		// get the first token so that Provision() fails if a token cannot be obtained
+		if _, _, err = m.credential.GetToken(ctx, []string{storageScope}); err != nil {
+			return
+		}
+		client, err = container.NewClient(ref, &storageTokenCredential{credential: m.credential, eventer: m.eventer}, opts)
+	case m.masterKey != nil:
+		var credential *container.SharedKeyCredential
+		if credential, err = container.NewSharedKeyCredential(*m.accountName, *m.masterKey); err != nil {
+			return
+		}
+		client, err = container.NewClientWithSharedKeyCredential(ref, credential, opts)
+	default:
+		client, err = container.NewClientWithNoCredential(ref, opts)
+	}
 	if err != nil {
 		return
 	}
 	if m.container == nil {
-		m.container = azblob.NewContainerURL(*url, pipeline)
+		m.container = client
 	}
 
 	// create the container if it doesn't exist
-	_, err = m.container.Create(ctx, nil, azblob.PublicAccessNone)
-	if err != nil {
-		if serr, ok := err.(azblob.StorageError); ok {
-			switch serr.ServiceCode() {
-			case azblob.ServiceCodeContainerAlreadyExists:
-				err = nil // this is a legit condition
-				m.eventer.Emit(VerifiedContainerEvent, 0, ref, nil)
-			default:
-				return
-			}
-		} else {
-			return
-		}
-	} else {
+	_, err = m.container.Create(ctx, nil)
+	switch {
+	case bloberror.HasCode(err, bloberror.ContainerAlreadyExists):
+		err = nil // this is a legit condition
+		m.eventer.Emit(VerifiedContainerEvent, 0, ref, nil)
+	case err != nil:
+		return
+	default:
 		m.eventer.Emit(CreatedContainerEvent, 0, ref, nil)
 	}
 
 	return
 }
 
+// A storageTokenCredential is the azcore.TokenCredential used by the storage SDK, which caches the token and gets a new one before it
+// expires. If getting a new one fails, an ErrorEvent is raised (and the request that needed it fails).
+type storageTokenCredential struct {
+	credential TokenCredential
+	eventer    Eventer
+}
+
+func (c *storageTokenCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	token, expiresOn, err := c.credential.GetToken(ctx, opts.Scopes)
+	if err != nil {
+		c.eventer.Emit(ErrorEvent, 0, "refreshing the token raised an error", newLeaseError(LeaseOperationRefreshToken, -1, err))
+		return azcore.AccessToken{}, err
+	}
+	return azcore.AccessToken{Token: token, ExpiresOn: expiresOn}, nil
+}
+
+// This sets the endpoint, accountName, and either the masterKey or sasToken from a connection string such as
+// "DefaultEndpointsProtocol=https;AccountName=name;AccountKey=key;EndpointSuffix=core.windows.net".
+func (m *azureBlobLeaseManager) applyConnectionString(cs string) error {
+	settings := make(map[string]string)
+	for _, part := range strings.Split(cs, ";") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return InvalidConnectionStringError
+		}
+		settings[strings.ToLower(kv[0])] = kv[1]
+	}
+	accountName := settings["accountname"]
+	endpoint := settings["blobendpoint"]
+	if endpoint == "" {
+		if accountName == "" {
+			return InvalidConnectionStringError
+		}
+		protocol, suffix := settings["defaultendpointsprotocol"], settings["endpointsuffix"]
+		if protocol == "" {
+			protocol = "https"
+		}
+		if suffix == "" {
+			suffix = "core.windows.net"
+		}
+		endpoint = fmt.Sprintf("%s://%s.blob.%s", protocol, accountName, suffix)
+	}
+	m.endpoint = &endpoint
+	m.accountName = &accountName
+	switch {
+	case settings["sharedaccesssignature"] != "":
+		sas := settings["sharedaccesssignature"]
+		m.sasToken = &sas
+	case settings["accountkey"] != "" && accountName != "":
+		key := settings["accountkey"]
+		m.masterKey = &key
+	default:
+		return InvalidConnectionStringError
+	}
+	return nil
+}
+
 func (m *azureBlobLeaseManager) getBlob(index int) azureBlob {
+	return m.getNamedBlob(fmt.Sprint(index))
+}
+
+func (m *azureBlobLeaseManager) getNamedBlob(name string) azureBlob {
 	if m.blob != nil {
 		return m.blob
 	} else {
 		// NOTE: m.container only exists after provision()
-		return m.container.NewBlockBlobURL(fmt.Sprint(index))
+		return blockBlob{client: m.container.NewBlockBlobClient(name)}
 	}
 }
 
 // This is called by SharedResource when the Azure Blob Storage blobs (partitions) should be created or verified.
 func (m *azureBlobLeaseManager) CreatePartitions(ctx context.Context, count int) {
 	for i := 0; i < count; i++ {
-		blob := m.getBlob(i)
-		var empty []byte
-		reader := bytes.NewReader(empty)
-		cond := azblob.BlobAccessConditions{
-			ModifiedAccessConditions: azblob.ModifiedAccessConditions{
-				IfNoneMatch: "*",
+		partition := m.getBlob(i)
+		opts := &blockblob.UploadOptions{
+			Tier: to.Ptr(blob.AccessTierHot),
+			AccessConditions: &blob.AccessConditions{
+				ModifiedAccessConditions: &blob.ModifiedAccessConditions{
+					IfNoneMatch: to.Ptr(azcore.ETagAny),
+				},
 			},
 		}
-		_, err := blob.Upload(ctx, reader, azblob.BlobHTTPHeaders{}, nil, cond, azblob.AccessTierHot, nil, azblob.ClientProvidedKeyOptions{})
-		if err != nil {
-			if serr, ok := err.(azblob.StorageError); ok {
-				switch serr.ServiceCode() {
-				case azblob.ServiceCodeBlobAlreadyExists, azblob.ServiceCodeLeaseIDMissing:
-					m.eventer.Emit(VerifiedBlobEvent, i, "", nil)
-				default:
-					m.eventer.Emit(ErrorEvent, 0, "creating partitions raised an error", serr)
-				}
-			} else {
-				m.eventer.Emit(ErrorEvent, 0, "creating partitions raised an error", err)
-			}
-		} else {
+		_, err := partition.Upload(ctx, emptyBody(), opts)
+		switch {
+		case bloberror.HasCode(err, bloberror.BlobAlreadyExists, bloberror.LeaseIDMissing):
+			m.eventer.Emit(VerifiedBlobEvent, i, "", nil)
+		case err != nil:
+			m.eventer.Emit(ErrorEvent, 0, "creating partitions raised an error", newLeaseError(LeaseOperationCreatePartition, i, err))
+		default:
 			m.eventer.Emit(CreatedBlobEvent, i, "", nil)
 		}
 	}
@@ -132,23 +353,48 @@ func (m *azureBlobLeaseManager) LeasePartition(ctx context.Context, id string, i
 	secondsToLease := 15
 
 	// attempt to allocate the partition
-	blob := m.getBlob(int(index))
-	_, err := blob.AcquireLease(ctx, id, int32(secondsToLease), azblob.ModifiedAccessConditions{})
-	if err != nil {
-		if serr, ok := err.(azblob.StorageError); ok {
-			switch serr.ServiceCode() {
-			case azblob.ServiceCodeLeaseAlreadyPresent:
-				// you cannot allocate a lease that is already assigned; try again in a bit
-				m.eventer.Emit(FailedEvent, int(index), "", nil)
-				return
-			default:
-				m.eventer.Emit(ErrorEvent, 0, err.Error(), nil)
-				return
-			}
-		} else {
-			m.eventer.Emit(ErrorEvent, 0, err.Error(), nil)
-			return
-		}
+	partition := m.getBlob(int(index))
+	sent := time.Now()
+	resp, err := partition.AcquireLease(ctx, id, int32(secondsToLease))
+	switch {
+	case bloberror.HasCode(err, bloberror.LeaseAlreadyPresent):
+		// you cannot allocate a lease that is already assigned; try again in a bit
+		m.eventer.Emit(FailedEvent, int(index), "", nil)
+		return
+	case err != nil:
+		lerr := newLeaseError(LeaseOperationAcquireLease, int(index), err)
+		m.eventer.Emit(ErrorEvent, 0, lerr.Error(), lerr)
+		return
+	}
+
+	// measure how far the service clock is from the local clock
+	if skew, ok := measureClockSkew(resp.Date, sent, time.Now()); ok {
+		m.eventer.Emit(ClockSkewEvent, int(skew.Milliseconds()), "", skew)
+	}
+
+	// return the lease time
+	leaseTime = time.Duration(secondsToLease) * time.Second
+
+	return
+}
+
+// This is called by SharedResource to renew the lease on a partition it still needs. The lease is renewed for the same 15 seconds it was
+// acquired for.
+func (m *azureBlobLeaseManager) RenewPartition(ctx context.Context, id string, index uint32) (leaseTime time.Duration) {
+	secondsToLease := 15
+
+	// attempt to renew the lease
+	partition := m.getBlob(int(index))
+	_, err := partition.RenewLease(ctx, id)
+	switch {
+	case bloberror.HasCode(err, bloberror.LeaseIDMismatchWithLeaseOperation, bloberror.LeaseLost, bloberror.LeaseIsBrokenAndCannotBeRenewed):
+		// the lease was lost; it can be leased again once it is available
+		m.eventer.Emit(FailedEvent, int(index), "", nil)
+		return
+	case err != nil:
+		lerr := newLeaseError(LeaseOperationRenewLease, int(index), err)
+		m.eventer.Emit(ErrorEvent, 0, lerr.Error(), lerr)
+		return
 	}
 
 	// return the lease time
@@ -156,3 +402,144 @@ func (m *azureBlobLeaseManager) LeasePartition(ctx context.Context, id string, i
 
 	return
 }
+
+// This is called by SharedResource to release the lease on a partition it no longer needs so that another instance can lease it without
+// waiting for the lease to expire. If the lease was already lost, there is nothing to release.
+func (m *azureBlobLeaseManager) ReleasePartition(ctx context.Context, id string, index uint32) {
+	partition := m.getBlob(int(index))
+	_, err := partition.ReleaseLease(ctx, id)
+	switch {
+	case bloberror.HasCode(err, bloberror.LeaseIDMismatchWithLeaseOperation, bloberror.LeaseLost, bloberror.LeaseNotPresentWithLeaseOperation):
+		return
+	case err != nil:
+		lerr := newLeaseError(LeaseOperationReleaseLease, int(index), err)
+		m.eventer.Emit(ErrorEvent, 0, lerr.Error(), lerr)
+	}
+}
+
+// This is called by SharedResource to publish the shared capacity this instance is requesting. The demand is stored as metadata
+// on a blob named "demand/<instance>" in the same container as the partitions.
+func (m *azureBlobLeaseManager) WriteDemand(ctx context.Context, instance string, target uint32) error {
+	return m.writeInstanceValue(ctx, demandBlobPrefix, demandMetadataKey, instance, target)
+}
+
+// This is called by SharedResource to read the shared capacity requested by every instance that has published its demand
+// since the provided time.
+func (m *azureBlobLeaseManager) ReadDemand(ctx context.Context, since time.Time) (map[string]uint32, error) {
+	return m.readInstanceValues(ctx, demandBlobPrefix, demandMetadataKey, since)
+}
+
+// This is called by SharedResource to publish the reserved capacity this instance is lending to the shared pool. It is stored as
+// metadata on a blob named "lent/<instance>" in the same container as the partitions.
+func (m *azureBlobLeaseManager) WriteLent(ctx context.Context, instance string, lent uint32) error {
+	return m.writeInstanceValue(ctx, lentBlobPrefix, lentMetadataKey, instance, lent)
+}
+
+// This is called by SharedResource to read the reserved capacity lent by every instance that has published since the provided time.
+func (m *azureBlobLeaseManager) ReadLent(ctx context.Context, since time.Time) (map[string]uint32, error) {
+	return m.readInstanceValues(ctx, lentBlobPrefix, lentMetadataKey, since)
+}
+
+// This is called by SharedResource to publish the FleetPolicy. It is stored as metadata on a blob named "policy" in the same container
+// as the partitions.
+func (m *azureBlobLeaseManager) WritePolicy(ctx context.Context, policy FleetPolicy) error {
+	return m.writeMetadata(ctx, policyBlobName, minPartitionsKey, policy.MinPartitionsPerInstance)
+}
+
+// This is called by SharedResource to read the FleetPolicy. If no policy was written, the zero FleetPolicy is returned.
+func (m *azureBlobLeaseManager) ReadPolicy(ctx context.Context) (FleetPolicy, error) {
+	var policy FleetPolicy
+	prefix := policyBlobName
+	pager := m.container.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+		Prefix:  &prefix,
+		Include: container.ListBlobsInclude{Metadata: true},
+	})
+	for pager.More() {
+		resp, err := pager.NextPage(ctx)
+		if err != nil {
+			return policy, err
+		}
+		for _, item := range blobItems(resp) {
+			if item.Name == nil || *item.Name != policyBlobName {
+				continue
+			}
+			if val, err := strconv.ParseUint(metadataValue(item.Metadata, minPartitionsKey), 10, 32); err == nil {
+				policy.MinPartitionsPerInstance = uint32(val)
+			}
+		}
+	}
+	return policy, nil
+}
+
+func (m *azureBlobLeaseManager) writeInstanceValue(ctx context.Context, prefix, key, instance string, val uint32) error {
+	return m.writeMetadata(ctx, prefix+instance, key, val)
+}
+
+// This uploads an empty blob with the value as its only metadata.
+func (m *azureBlobLeaseManager) writeMetadata(ctx context.Context, name, key string, val uint32) error {
+	opts := &blockblob.UploadOptions{
+		Tier:     to.Ptr(blob.AccessTierHot),
+		Metadata: map[string]*string{key: to.Ptr(strconv.FormatUint(uint64(val), 10))},
+	}
+	_, err := m.getNamedBlob(name).Upload(ctx, emptyBody(), opts)
+	return err
+}
+
+func (m *azureBlobLeaseManager) readInstanceValues(ctx context.Context, prefix, key string, since time.Time) (map[string]uint32, error) {
+	values := make(map[string]uint32)
+	pager := m.container.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+		Prefix:  &prefix,
+		Include: container.ListBlobsInclude{Metadata: true},
+	})
+	for pager.More() {
+		resp, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range blobItems(resp) {
+			if item.Name == nil || item.Properties == nil || item.Properties.LastModified == nil {
+				continue
+			}
+			if item.Properties.LastModified.Before(since) {
+				continue // the instance is no longer publishing
+			}
+			val, err := strconv.ParseUint(metadataValue(item.Metadata, key), 10, 32)
+			if err != nil {
+				continue // the blob was not written by this lease manager
+			}
+			values[strings.TrimPrefix(*item.Name, prefix)] = uint32(val)
+		}
+	}
+	return values, nil
+}
+
+func blobItems(resp container.ListBlobsFlatResponse) []*container.BlobItem {
+	if resp.Segment == nil {
+		return nil
+	}
+	return resp.Segment.BlobItems
+}
+
+// The storage service may return metadata keys with different casing than they were written with.
+func metadataValue(metadata map[string]*string, key string) string {
+	for k, v := range metadata {
+		if strings.EqualFold(k, key) && v != nil {
+			return *v
+		}
+	}
+	return ""
+}
+
+func emptyBody() io.ReadSeekCloser {
+	return streaming.NopCloser(bytes.NewReader(nil))
+}
+
+// This compares the Date header of a response (the service clock) to the local time halfway through the request. The Date header only
+// has a resolution of 1 second, so the result is only accurate to about a second. A positive skew means the service clock is ahead.
+func measureClockSkew(date *time.Time, sent, received time.Time) (time.Duration, bool) {
+	if date == nil || date.IsZero() {
+		return 0, false
+	}
+	local := sent.Add(received.Sub(sent) / 2)
+	return date.Sub(local), true
+}
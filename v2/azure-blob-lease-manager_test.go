@@ -6,10 +6,19 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
-	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/lease"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -19,35 +28,66 @@ type mockBlob struct {
 	mock.Mock
 }
 
-func (b *mockBlob) Upload(ctx context.Context, reader io.ReadSeeker, headers azblob.BlobHTTPHeaders, metadata azblob.Metadata, conditions azblob.BlobAccessConditions, accessTier azblob.AccessTierType, tags azblob.BlobTagsMap, clientKeyOpts azblob.ClientProvidedKeyOptions) (*azblob.BlockBlobUploadResponse, error) {
-	args := b.Called(ctx, reader, headers, metadata, conditions, accessTier, tags, clientKeyOpts)
-	return nil, args.Error(1)
+func (b *mockBlob) Upload(ctx context.Context, body io.ReadSeekCloser, opts *blockblob.UploadOptions) (blockblob.UploadResponse, error) {
+	args := b.Called(ctx, body, opts)
+	return blockblob.UploadResponse{}, args.Error(1)
 }
 
-func (b *mockBlob) AcquireLease(ctx context.Context, proposedId string, duration int32, conditions azblob.ModifiedAccessConditions) (*azblob.BlobAcquireLeaseResponse, error) {
-	args := b.Called(ctx, proposedId, duration, conditions)
-	return nil, args.Error(1)
+func (b *mockBlob) AcquireLease(ctx context.Context, proposedId string, duration int32) (lease.BlobAcquireResponse, error) {
+	args := b.Called(ctx, proposedId, duration)
+	resp, _ := args.Get(0).(lease.BlobAcquireResponse)
+	return resp, args.Error(1)
+}
+
+func (b *mockBlob) RenewLease(ctx context.Context, leaseId string) (lease.BlobRenewResponse, error) {
+	args := b.Called(ctx, leaseId)
+	return lease.BlobRenewResponse{}, args.Error(1)
+}
+
+func (b *mockBlob) ReleaseLease(ctx context.Context, leaseId string) (lease.BlobReleaseResponse, error) {
+	args := b.Called(ctx, leaseId)
+	return lease.BlobReleaseResponse{}, args.Error(1)
 }
 
 type mockContainer struct {
 	mock.Mock
 }
 
-func (c *mockContainer) Create(ctx context.Context, metadata azblob.Metadata, publicAccessType azblob.PublicAccessType) (*azblob.ContainerCreateResponse, error) {
-	args := c.Called(ctx, metadata, publicAccessType)
-	return nil, args.Error(1)
+func (c *mockContainer) Create(ctx context.Context, opts *container.CreateOptions) (container.CreateResponse, error) {
+	args := c.Called(ctx, opts)
+	return container.CreateResponse{}, args.Error(1)
 }
 
-func (c *mockContainer) NewBlockBlobURL(url string) azblob.BlockBlobURL {
-	_ = c.Called(url)
-	return azblob.BlockBlobURL{}
+func (c *mockContainer) NewBlockBlobClient(name string) *blockblob.Client {
+	_ = c.Called(name)
+	return nil
+}
+
+// The pager returns each page provided to ListBlobsFlat, or the error.
+func (c *mockContainer) NewListBlobsFlatPager(opts *container.ListBlobsFlatOptions) *runtime.Pager[container.ListBlobsFlatResponse] {
+	args := c.Called(opts)
+	pages, _ := args.Get(0).([]container.ListBlobsFlatResponse)
+	err := args.Error(1)
+	next := 0
+	return runtime.NewPager(runtime.PagingHandler[container.ListBlobsFlatResponse]{
+		More: func(container.ListBlobsFlatResponse) bool {
+			return next < len(pages)
+		},
+		Fetcher: func(context.Context, *container.ListBlobsFlatResponse) (container.ListBlobsFlatResponse, error) {
+			if err != nil {
+				return container.ListBlobsFlatResponse{}, err
+			}
+			next++
+			return pages[next-1], nil
+		},
+	})
 }
 
 type mockEventer struct {
 	mock.Mock
 }
 
-func (sr *mockEventer) AddListener(fn func(event string, val int, msg string, metadata interface{})) uuid.UUID {
+func (sr *mockEventer) AddListener(fn func(event string, val int, msg string, metadata interface{}), opts ...ListenerOption) uuid.UUID {
 	args := sr.Called(fn)
 	return args.Get(0).(uuid.UUID)
 }
@@ -60,28 +100,34 @@ func (sr *mockEventer) Emit(event string, val int, msg string, metadata interfac
 	sr.Called(event, val, msg, metadata)
 }
 
-type StorageError struct {
-	serviceCode azblob.ServiceCodeType
-}
-
-func (e StorageError) ServiceCode() azblob.ServiceCodeType {
-	return e.serviceCode
-}
-
-func (e StorageError) Error() string {
-	return "this is a mock error"
-}
-
-func (e StorageError) Timeout() bool {
-	return false
+// This returns the error the storage SDK returns when the service responds with the provided error code.
+func storageError(code bloberror.Code, status int, requestID string) error {
+	req, _ := http.NewRequest(http.MethodPut, "https://accountName.blob.core.windows.net/containerName/0", nil)
+	resp := &http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Header:     http.Header{},
+		Body:       io.NopCloser(strings.NewReader("")),
+		Request:    req,
+	}
+	resp.Header.Set("x-ms-error-code", string(code))
+	if requestID != "" {
+		resp.Header.Set("x-ms-request-id", requestID)
+	}
+	return runtime.NewResponseError(resp)
 }
 
-func (e StorageError) Temporary() bool {
-	return false
+func listPage(items ...*container.BlobItem) container.ListBlobsFlatResponse {
+	var page container.ListBlobsFlatResponse
+	page.Segment = &container.BlobFlatListSegment{BlobItems: items}
+	return page
 }
 
-func (e StorageError) Response() *http.Response {
-	return nil
+// This matches UploadOptions with only the provided metadata.
+func withMetadata(key, val string) interface{} {
+	return mock.MatchedBy(func(opts *blockblob.UploadOptions) bool {
+		return len(opts.Metadata) == 1 && opts.Metadata[key] != nil && *opts.Metadata[key] == val
+	})
 }
 
 func TestAzureBlobLeaseManager_Provision_ContainerIsCreated(t *testing.T) {
@@ -90,7 +136,7 @@ func TestAzureBlobLeaseManager_Provision_ContainerIsCreated(t *testing.T) {
 	e := &mockEventer{}
 	e.On("Emit", CreatedContainerEvent, mock.Anything, "https://accountName.blob.core.windows.net/containerName", mock.Anything)
 	container := &mockContainer{}
-	container.On("Create", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil).Once()
+	container.On("Create", mock.Anything, mock.Anything).Return(nil, nil).Once()
 	accountName := "accountName"
 	containerName := "containerName"
 	mgr := &azureBlobLeaseManager{
@@ -111,8 +157,7 @@ func TestAzureBlobLeaseManager_Provision_ContainerIsVerified(t *testing.T) {
 	e := &mockEventer{}
 	e.On("Emit", VerifiedContainerEvent, mock.Anything, "https://accountName.blob.core.windows.net/containerName", mock.Anything)
 	container := &mockContainer{}
-	var serr azblob.StorageError = StorageError{serviceCode: azblob.ServiceCodeContainerAlreadyExists}
-	container.On("Create", mock.Anything, mock.Anything, mock.Anything).Return(nil, serr).Once()
+	container.On("Create", mock.Anything, mock.Anything).Return(nil, storageError(bloberror.ContainerAlreadyExists, http.StatusConflict, "")).Once()
 	accountName := "accountName"
 	containerName := "containerName"
 	mgr := &azureBlobLeaseManager{
@@ -131,7 +176,7 @@ func TestAzureBlobLeaseManager_Provision_Errors(t *testing.T) {
 	testCases := map[string]struct {
 		err error
 	}{
-		"unknown":     {err: StorageError{serviceCode: azblob.ServiceCodeAccountIsDisabled}},
+		"unknown":     {err: storageError(bloberror.AccountIsDisabled, http.StatusForbidden, "")},
 		"non-storage": {err: errors.New("non-storage error")},
 	}
 	for testName, testCase := range testCases {
@@ -139,7 +184,7 @@ func TestAzureBlobLeaseManager_Provision_Errors(t *testing.T) {
 			ctx, cancel := context.WithCancel(context.Background())
 			defer cancel()
 			container := &mockContainer{}
-			container.On("Create", mock.Anything, mock.Anything, mock.Anything).Return(nil, testCase.err)
+			container.On("Create", mock.Anything, mock.Anything).Return(nil, testCase.err)
 			accountName := "accountName"
 			containerName := "containerName"
 			mgr := &azureBlobLeaseManager{
@@ -148,7 +193,11 @@ func TestAzureBlobLeaseManager_Provision_Errors(t *testing.T) {
 				container:     container,
 			}
 			err := mgr.Provision(ctx)
-			assert.Equal(t, testCase.err, err)
+			assert.ErrorIs(t, err, testCase.err)
+			var lerr *LeaseError
+			if assert.ErrorAs(t, err, &lerr) {
+				assert.Equal(t, LeaseOperationProvision, lerr.Operation)
+			}
 			container.AssertNumberOfCalls(t, "Create", 1)
 		})
 	}
@@ -169,6 +218,176 @@ func TestAzureBlobLeaseManager_Provision_InvalidMasterKey(t *testing.T) {
 	assert.Contains(t, err.Error(), "illegal base64 data")
 }
 
+func TestAzureBlobLeaseManager_Provision_TokenCredentialIsUsed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	e := &mockEventer{}
+	e.On("Emit", CreatedContainerEvent, mock.Anything, mock.Anything, mock.Anything)
+	container := &mockContainer{}
+	container.On("Create", mock.Anything, mock.Anything).Return(nil, nil).Once()
+	var requested [][]string
+	credential := TokenCredentialFunc(func(ctx context.Context, scopes []string) (string, time.Time, error) {
+		requested = append(requested, scopes)
+		return "token", time.Now().Add(1 * time.Hour), nil
+	})
+	mgr := NewAzureBlobLeaseManagerWithTokenCredential("accountName", "containerName", credential).(*azureBlobLeaseManager)
+	mgr.container = container
+	mgr.RaiseEventsTo(e)
+	err := mgr.Provision(ctx)
+	assert.NoError(t, err, "expecting no provision error")
+	assert.Equal(t, [][]string{{"https://storage.azure.com/.default"}}, requested, "expecting one token for storage")
+}
+
+func TestAzureBlobLeaseManager_Provision_TokenCredentialErrorIsReturned(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	failure := errors.New("no managed identity")
+	credential := TokenCredentialFunc(func(ctx context.Context, scopes []string) (string, time.Time, error) {
+		return "", time.Time{}, failure
+	})
+	mgr := NewAzureBlobLeaseManagerWithTokenCredential("accountName", "containerName", credential)
+	err := mgr.Provision(ctx)
+	var lerr *LeaseError
+	if assert.ErrorAs(t, err, &lerr) {
+		assert.Equal(t, LeaseOperationProvision, lerr.Operation)
+	}
+	assert.ErrorIs(t, err, failure)
+}
+
+func TestAzureBlobLeaseManager_TokenRefreshErrorIsRaised(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	failure := errors.New("token endpoint unavailable")
+	e := &mockEventer{}
+	e.On("Emit", ErrorEvent, mock.Anything, mock.Anything, mock.MatchedBy(func(lerr *LeaseError) bool {
+		return lerr.Operation == LeaseOperationRefreshToken && errors.Is(lerr, failure)
+	})).Once()
+	credential := &storageTokenCredential{
+		credential: TokenCredentialFunc(func(ctx context.Context, scopes []string) (string, time.Time, error) {
+			return "", time.Time{}, failure
+		}),
+		eventer: e,
+	}
+	_, err := credential.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{storageScope}})
+	assert.ErrorIs(t, err, failure)
+	e.AssertExpectations(t)
+}
+
+func TestAzureBlobLeaseManager_Provision_ConnectionStringIsApplied(t *testing.T) {
+	testCases := map[string]struct {
+		cs       string
+		ref      string
+		key, sas string
+	}{
+		"account key": {
+			cs:  "DefaultEndpointsProtocol=https;AccountName=accountName;AccountKey=a2V5;EndpointSuffix=core.chinacloudapi.cn",
+			ref: "https://accountName.blob.core.chinacloudapi.cn/containerName",
+			key: "a2V5",
+		},
+		"sas": {
+			cs:  "BlobEndpoint=http://127.0.0.1:10000/devstoreaccount1/;SharedAccessSignature=sv=2020-08-04&sig=abc%3D",
+			ref: "http://127.0.0.1:10000/devstoreaccount1/containerName",
+			sas: "sv=2020-08-04&sig=abc%3D",
+		},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			e := &mockEventer{}
+			e.On("Emit", CreatedContainerEvent, mock.Anything, testCase.ref, mock.Anything)
+			container := &mockContainer{}
+			container.On("Create", mock.Anything, mock.Anything).Return(nil, nil).Once()
+			mgr := NewAzureBlobLeaseManagerWithConnectionString(testCase.cs, "containerName").(*azureBlobLeaseManager)
+			mgr.container = container
+			mgr.RaiseEventsTo(e)
+			err := mgr.Provision(ctx)
+			assert.NoError(t, err, "expecting no provision error")
+			e.AssertNumberOfCalls(t, "Emit", 1)
+			if testCase.key != "" {
+				assert.Equal(t, testCase.key, *mgr.masterKey)
+			}
+			if testCase.sas != "" {
+				assert.Equal(t, testCase.sas, *mgr.sasToken)
+			}
+		})
+	}
+}
+
+func TestAzureBlobLeaseManager_Provision_InvalidConnectionString(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mgr := NewAzureBlobLeaseManagerWithConnectionString("AccountName=accountName", "containerName")
+	err := mgr.Provision(ctx)
+	assert.ErrorIs(t, err, InvalidConnectionStringError)
+}
+
+func TestAzureBlobLeaseManager_Provision_SASTokenIsNotRaised(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	e := &mockEventer{}
+	e.On("Emit", CreatedContainerEvent, mock.Anything, "https://accountName.blob.core.windows.net/containerName", mock.Anything)
+	container := &mockContainer{}
+	container.On("Create", mock.Anything, mock.Anything).Return(nil, nil).Once()
+	mgr := NewAzureBlobLeaseManagerWithSASToken("accountName", "containerName", "?sv=2020-08-04&sig=abc").(*azureBlobLeaseManager)
+	mgr.container = container
+	mgr.RaiseEventsTo(e)
+	err := mgr.Provision(ctx)
+	assert.NoError(t, err, "expecting no provision error")
+	e.AssertNumberOfCalls(t, "Emit", 1)
+}
+
+func TestAzureBlobLeaseManager_Provision_StorageRetryOptionsAreUsed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	var attempts int32
+	counter := policyFunc(func(req *policy.Request) (*http.Response, error) {
+		atomic.AddInt32(&attempts, 1)
+		return req.Next()
+	})
+	mgr := NewAzureBlobLeaseManagerWithEndpoint(server.URL+"/accountName", "accountName", "containerName", "a2V5").(AzureBlobLeaseManager).
+		WithStorageRetryOptions(StorageRetryOptions{
+			MaxTries:      3,
+			RetryDelay:    1 * time.Millisecond,
+			MaxRetryDelay: 5 * time.Millisecond,
+			Policies:      []policy.Policy{counter},
+		})
+	mgr.RaiseEventsTo(&mockEventer{})
+	err := mgr.Provision(ctx)
+	assert.Error(t, err, "expecting the provision to fail after the retries")
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests), "expecting MaxTries requests")
+	assert.Equal(t, int32(3), atomic.LoadInt32(&attempts), "expecting the policy to run on every attempt")
+}
+
+func TestAzureBlobLeaseManager_Provision_NoRetriesWithMaxTriesOfOne(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	mgr := NewAzureBlobLeaseManagerWithEndpoint(server.URL+"/accountName", "accountName", "containerName", "a2V5").(AzureBlobLeaseManager).
+		WithStorageRetryOptions(StorageRetryOptions{MaxTries: 1})
+	mgr.RaiseEventsTo(&mockEventer{})
+	err := mgr.Provision(ctx)
+	assert.Error(t, err, "expecting the provision to fail")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests), "expecting a single request")
+}
+
+type policyFunc func(req *policy.Request) (*http.Response, error)
+
+func (f policyFunc) Do(req *policy.Request) (*http.Response, error) {
+	return f(req)
+}
+
 func TestAzureBlobLeaseManager_Provision_InvalidUrl(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -188,7 +407,7 @@ func TestAzureBlobLeaseManager_CreatePartitions_CorrectNumberCreated(t *testing.
 	e := &mockEventer{}
 	e.On("Emit", CreatedBlobEvent, mock.Anything, mock.Anything, mock.Anything)
 	blob := &mockBlob{}
-	blob.On("Upload", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+	blob.On("Upload", mock.Anything, mock.Anything, mock.Anything).
 		Return(nil, nil).Times(5)
 	mgr := &azureBlobLeaseManager{
 		blob: blob,
@@ -200,9 +419,9 @@ func TestAzureBlobLeaseManager_CreatePartitions_CorrectNumberCreated(t *testing.
 }
 
 func TestAzureBlobLeaseManager_CreatePartitions_BlobIsVerified(t *testing.T) {
-	testCases := map[string]azblob.StorageError{
-		"exists": StorageError{serviceCode: azblob.ServiceCodeBlobAlreadyExists},
-		"leased": StorageError{serviceCode: azblob.ServiceCodeLeaseIDMissing},
+	testCases := map[string]error{
+		"exists": storageError(bloberror.BlobAlreadyExists, http.StatusConflict, ""),
+		"leased": storageError(bloberror.LeaseIDMissing, http.StatusPreconditionFailed, ""),
 	}
 	for testName, serr := range testCases {
 		t.Run(testName, func(t *testing.T) {
@@ -211,7 +430,7 @@ func TestAzureBlobLeaseManager_CreatePartitions_BlobIsVerified(t *testing.T) {
 			e := &mockEventer{}
 			e.On("Emit", VerifiedBlobEvent, mock.Anything, mock.Anything, mock.Anything)
 			blob := &mockBlob{}
-			blob.On("Upload", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+			blob.On("Upload", mock.Anything, mock.Anything, mock.Anything).
 				Return(nil, serr).Once()
 			mgr := &azureBlobLeaseManager{
 				blob: blob,
@@ -226,7 +445,7 @@ func TestAzureBlobLeaseManager_CreatePartitions_BlobIsVerified(t *testing.T) {
 
 func TestAzureBlobLeaseManager_CreatePartitions_BlobErrors(t *testing.T) {
 	testCases := map[string]error{
-		"unknown":     StorageError{serviceCode: azblob.ServiceCodeAuthenticationFailed},
+		"unknown":     storageError(bloberror.AuthenticationFailed, http.StatusForbidden, ""),
 		"non-storage": errors.New("non-storage error"),
 	}
 	for testName, serr := range testCases {
@@ -234,9 +453,11 @@ func TestAzureBlobLeaseManager_CreatePartitions_BlobErrors(t *testing.T) {
 			ctx, cancel := context.WithCancel(context.Background())
 			defer cancel()
 			e := &mockEventer{}
-			e.On("Emit", ErrorEvent, mock.Anything, mock.Anything, serr)
+			e.On("Emit", ErrorEvent, mock.Anything, mock.Anything, mock.MatchedBy(func(lerr *LeaseError) bool {
+				return lerr.Operation == LeaseOperationCreatePartition && lerr.Index == 0 && errors.Is(lerr, serr)
+			}))
 			blob := &mockBlob{}
-			blob.On("Upload", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+			blob.On("Upload", mock.Anything, mock.Anything, mock.Anything).
 				Return(nil, serr).Once()
 			mgr := &azureBlobLeaseManager{
 				blob: blob,
@@ -253,7 +474,7 @@ func TestAzureBlobLeaseManager_LeasePartition_Success(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	blob := &mockBlob{}
-	blob.On("AcquireLease", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil, nil)
+	blob.On("AcquireLease", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil)
 	mgr := &azureBlobLeaseManager{
 		blob: blob,
 	}
@@ -267,8 +488,8 @@ func TestAzureBlobLeaseManager_LeasePartition_Failures(t *testing.T) {
 		event string
 		err   error
 	}{
-		"failed to obtain lease": {event: FailedEvent, err: StorageError{serviceCode: azblob.ServiceCodeLeaseAlreadyPresent}},
-		"unknown":                {event: ErrorEvent, err: StorageError{serviceCode: azblob.ServiceCodeBlobAlreadyExists}},
+		"failed to obtain lease": {event: FailedEvent, err: storageError(bloberror.LeaseAlreadyPresent, http.StatusConflict, "")},
+		"unknown":                {event: ErrorEvent, err: storageError(bloberror.BlobAlreadyExists, http.StatusConflict, "")},
 		"non-storage":            {event: ErrorEvent, err: fmt.Errorf("unknown mocked error")},
 	}
 	for testName, testCase := range testCases {
@@ -278,7 +499,7 @@ func TestAzureBlobLeaseManager_LeasePartition_Failures(t *testing.T) {
 			e := &mockEventer{}
 			e.On("Emit", testCase.event, mock.Anything, mock.Anything, mock.Anything)
 			blob := &mockBlob{}
-			blob.On("AcquireLease", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil, testCase.err)
+			blob.On("AcquireLease", mock.Anything, mock.Anything, mock.Anything).Return(nil, testCase.err)
 			mgr := &azureBlobLeaseManager{
 				blob: blob,
 			}
@@ -290,3 +511,229 @@ func TestAzureBlobLeaseManager_LeasePartition_Failures(t *testing.T) {
 		})
 	}
 }
+
+func TestAzureBlobLeaseManager_RenewPartition_Success(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	blob := &mockBlob{}
+	blob.On("RenewLease", mock.Anything, "my-lease-id").Return(nil, nil)
+	mgr := &azureBlobLeaseManager{
+		blob: blob,
+	}
+	dur := mgr.RenewPartition(ctx, "my-lease-id", 0)
+	assert.Equal(t, 15*time.Second, dur)
+	blob.AssertNumberOfCalls(t, "RenewLease", 1)
+}
+
+func TestAzureBlobLeaseManager_RenewPartition_Failures(t *testing.T) {
+	testCases := map[string]struct {
+		event string
+		err   error
+	}{
+		"lease lost":     {event: FailedEvent, err: storageError(bloberror.LeaseLost, http.StatusPreconditionFailed, "")},
+		"lease mismatch": {event: FailedEvent, err: storageError(bloberror.LeaseIDMismatchWithLeaseOperation, http.StatusConflict, "")},
+		"unknown":        {event: ErrorEvent, err: storageError(bloberror.BlobNotFound, http.StatusNotFound, "")},
+		"non-storage":    {event: ErrorEvent, err: fmt.Errorf("unknown mocked error")},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			e := &mockEventer{}
+			e.On("Emit", testCase.event, mock.Anything, mock.Anything, mock.Anything)
+			blob := &mockBlob{}
+			blob.On("RenewLease", mock.Anything, mock.Anything).Return(nil, testCase.err)
+			mgr := &azureBlobLeaseManager{
+				blob: blob,
+			}
+			mgr.RaiseEventsTo(e)
+			dur := mgr.RenewPartition(ctx, "my-lease-id", 0)
+			assert.Equal(t, 0*time.Second, dur)
+			blob.AssertNumberOfCalls(t, "RenewLease", 1)
+			e.AssertNumberOfCalls(t, "Emit", 1)
+		})
+	}
+}
+
+func TestAzureBlobLeaseManager_ReleasePartition(t *testing.T) {
+	testCases := map[string]struct {
+		err    error
+		events int
+	}{
+		"released":   {err: nil, events: 0},
+		"lease lost": {err: storageError(bloberror.LeaseIDMismatchWithLeaseOperation, http.StatusConflict, ""), events: 0},
+		"other":      {err: fmt.Errorf("other"), events: 1},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			ctx := context.Background()
+			e := &mockEventer{}
+			e.On("Emit", ErrorEvent, mock.Anything, mock.Anything, mock.Anything)
+			blob := &mockBlob{}
+			blob.On("ReleaseLease", mock.Anything, "my-lease-id").Return(nil, testCase.err)
+			mgr := &azureBlobLeaseManager{
+				blob: blob,
+			}
+			mgr.RaiseEventsTo(e)
+			mgr.ReleasePartition(ctx, "my-lease-id", 0)
+			blob.AssertNumberOfCalls(t, "ReleaseLease", 1)
+			e.AssertNumberOfCalls(t, "Emit", testCase.events)
+		})
+	}
+}
+
+func TestAzureBlobLeaseManager_LeasePartition_ErrorsIncludeResponseDetails(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	serr := storageError(bloberror.AuthenticationFailed, http.StatusForbidden, "my-request-id")
+	var raised *LeaseError
+	e := &mockEventer{}
+	e.On("Emit", ErrorEvent, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		raised = args.Get(3).(*LeaseError)
+	})
+	blob := &mockBlob{}
+	blob.On("AcquireLease", mock.Anything, mock.Anything, mock.Anything).Return(nil, serr)
+	mgr := &azureBlobLeaseManager{
+		blob: blob,
+	}
+	mgr.RaiseEventsTo(e)
+	_ = mgr.LeasePartition(ctx, "my-lease-id", 7)
+	if assert.NotNil(t, raised, "expecting a LeaseError to be raised as metadata") {
+		assert.Equal(t, LeaseOperationAcquireLease, raised.Operation)
+		assert.Equal(t, 7, raised.Index)
+		assert.Equal(t, http.StatusForbidden, raised.StatusCode)
+		assert.Equal(t, "my-request-id", raised.RequestID)
+		assert.Equal(t, string(bloberror.AuthenticationFailed), raised.ServiceCode)
+		assert.True(t, strings.HasPrefix(raised.Error(), "lease acquire-lease failed on partition 7 (status 403) (request-id my-request-id): "))
+	}
+}
+
+func TestAzureBlobLeaseManager_Provision_CustomEndpoint(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	e := &mockEventer{}
+	e.On("Emit", CreatedContainerEvent, mock.Anything, "http://127.0.0.1:10000/devstoreaccount1/containerName", mock.Anything)
+	container := &mockContainer{}
+	container.On("Create", mock.Anything, mock.Anything).Return(nil, nil).Once()
+	mgr := NewAzureBlobLeaseManagerWithEndpoint("http://127.0.0.1:10000/devstoreaccount1/", "devstoreaccount1", "containerName", "").(*azureBlobLeaseManager)
+	mgr.masterKey = nil
+	mgr.container = container
+	mgr.RaiseEventsTo(e)
+	err := mgr.Provision(ctx)
+	assert.NoError(t, err, "expecting no provision error")
+	e.AssertNumberOfCalls(t, "Emit", 1)
+}
+
+func TestAzureBlobLeaseManager_WriteDemand_UploadsTargetAsMetadata(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	blob := &mockBlob{}
+	blob.On("Upload", mock.Anything, mock.Anything, withMetadata("target", "3000")).
+		Return(nil, nil).Once()
+	mgr := NewAzureBlobLeaseManager("accountName", "containerName", "masterKey").(*azureBlobLeaseManager)
+	mgr.blob = blob
+	err := mgr.WriteDemand(ctx, "instance", 3000)
+	assert.NoError(t, err)
+	blob.AssertExpectations(t)
+}
+
+func TestAzureBlobLeaseManager_WriteLent_UploadsLentAsMetadata(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	blob := &mockBlob{}
+	blob.On("Upload", mock.Anything, mock.Anything, withMetadata("lent", "2000")).
+		Return(nil, nil).Once()
+	mgr := NewAzureBlobLeaseManager("accountName", "containerName", "masterKey").(*azureBlobLeaseManager)
+	mgr.blob = blob
+	err := mgr.WriteLent(ctx, "instance", 2000)
+	assert.NoError(t, err)
+	blob.AssertExpectations(t)
+}
+
+func TestAzureBlobLeaseManager_WritePolicy_UploadsMinPartitionsAsMetadata(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	blob := &mockBlob{}
+	blob.On("Upload", mock.Anything, mock.Anything, withMetadata("minpartitions", "4")).
+		Return(nil, nil).Once()
+	mgr := NewAzureBlobLeaseManager("accountName", "containerName", "masterKey").(*azureBlobLeaseManager)
+	mgr.blob = blob
+	err := mgr.WritePolicy(ctx, FleetPolicy{MinPartitionsPerInstance: 4})
+	assert.NoError(t, err)
+	blob.AssertExpectations(t)
+}
+
+func TestAzureBlobLeaseManager_ReadPolicy_IgnoresOtherBlobs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	page := listPage(
+		&container.BlobItem{Name: to.Ptr("policy-old"), Metadata: map[string]*string{"minpartitions": to.Ptr("9")}},
+		&container.BlobItem{Name: to.Ptr("policy"), Metadata: map[string]*string{"Minpartitions": to.Ptr("4")}},
+	)
+	pages := []container.ListBlobsFlatResponse{page}
+	container := &mockContainer{}
+	container.On("NewListBlobsFlatPager", mock.Anything).Return(pages, nil).Once()
+	mgr := NewAzureBlobLeaseManager("accountName", "containerName", "masterKey").(*azureBlobLeaseManager)
+	mgr.container = container
+	policy, err := mgr.ReadPolicy(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, FleetPolicy{MinPartitionsPerInstance: 4}, policy)
+}
+
+func TestAzureBlobLeaseManager_ReadDemand_IgnoresStaleAndUnrelatedBlobs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	now := time.Now()
+	item := func(name string, modified time.Time, target string) *container.BlobItem {
+		item := &container.BlobItem{Name: to.Ptr(name), Properties: &container.BlobProperties{LastModified: to.Ptr(modified)}}
+		if target != "" {
+			item.Metadata = map[string]*string{"target": to.Ptr(target)}
+		}
+		return item
+	}
+	pages := []container.ListBlobsFlatResponse{
+		listPage(item("demand/a", now, "3000"), item("demand/stale", now.Add(-time.Hour), "9999")),
+		listPage(item("demand/b", now, "1500"), item("demand/bad", now, "")),
+	}
+	container := &mockContainer{}
+	container.On("NewListBlobsFlatPager", mock.Anything).Return(pages, nil).Once()
+	mgr := NewAzureBlobLeaseManager("accountName", "containerName", "masterKey").(*azureBlobLeaseManager)
+	mgr.container = container
+	demands, err := mgr.ReadDemand(ctx, now.Add(-time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]uint32{"a": 3000, "b": 1500}, demands)
+}
+
+func TestAzureBlobLeaseManager_ReadDemand_ReturnsListError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pages := []container.ListBlobsFlatResponse{{}}
+	container := &mockContainer{}
+	container.On("NewListBlobsFlatPager", mock.Anything).Return(pages, errors.New("list failed")).Once()
+	mgr := NewAzureBlobLeaseManager("accountName", "containerName", "masterKey").(*azureBlobLeaseManager)
+	mgr.container = container
+	_, err := mgr.ReadDemand(ctx, time.Now())
+	assert.EqualError(t, err, "list failed")
+}
+
+func TestMeasureClockSkew(t *testing.T) {
+	sent := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	received := sent.Add(2 * time.Second)
+	testCases := map[string]struct {
+		date     *time.Time
+		ok       bool
+		expected time.Duration
+	}{
+		"service is ahead":  {date: to.Ptr(sent.Add(6 * time.Second)), ok: true, expected: 5 * time.Second},
+		"service is behind": {date: to.Ptr(sent.Add(-2 * time.Second)), ok: true, expected: -3 * time.Second},
+		"no date":           {date: nil, ok: false},
+		"zero date":         {date: &time.Time{}, ok: false},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			skew, ok := measureClockSkew(testCase.date, sent, received)
+			assert.Equal(t, testCase.ok, ok)
+			assert.Equal(t, testCase.expected, skew)
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package batcher
+
+import (
+	"math"
+	"sort"
+	"sync/atomic"
+)
+
+// the number of recent batches used for the batch size stats
+const batchSizeWindow = 256
+
+// batchSizes keeps the sizes of the most recent batches so Stats() can report their average and 95th percentile without taking a lock.
+// A slot that was claimed but not yet written may be read as 0 (or its previous size), which is acceptable for a snapshot.
+type batchSizes struct {
+	next  uint64
+	sizes [batchSizeWindow]uint32
+}
+
+func (w *batchSizes) add(size int) {
+	i := atomic.AddUint64(&w.next, 1) - 1
+	atomic.StoreUint32(&w.sizes[i%batchSizeWindow], uint32(size))
+}
+
+// This returns the average and 95th percentile of the recent batch sizes (both 0 if there have not been any batches).
+func (w *batchSizes) stats() (average float64, p95 uint32) {
+	sizes := make([]uint32, 0, batchSizeWindow)
+	for i := range w.sizes {
+		if size := atomic.LoadUint32(&w.sizes[i]); size > 0 {
+			sizes = append(sizes, size)
+		}
+	}
+	if len(sizes) == 0 {
+		return 0, 0
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+	var total uint64
+	for _, size := range sizes {
+		total += uint64(size)
+	}
+	rank := int(math.Ceil(0.95*float64(len(sizes)))) - 1
+	return float64(total) / float64(len(sizes)), sizes[rank]
+}
@@ -0,0 +1,94 @@
+package batcher
+
+import (
+	"sync"
+	"time"
+)
+
+// Batch is provided to a Watcher created with NewBatchWatcher(). In addition to the Operations, it allows the Watcher to put the whole
+// batch back as a unit (for instance, during a transient downstream outage) rather than enqueuing each Operation again.
+type Batch interface {
+	Operations() []Operation
+	RequeueAll(delay time.Duration) error
+}
+
+// BatchedOperation describes an Operation in a batch without its payload. A slice of these is the metadata of the batch event when
+// WithEmitBatch() is used.
+type BatchedOperation struct {
+	ID         uint64
+	ExternalID string
+	Cost       uint32
+	Key        string
+	Watcher    string
+}
+
+func describeBatch(watcher Watcher, ops []Operation) []BatchedOperation {
+	described := make([]BatchedOperation, len(ops))
+	for i, op := range ops {
+		described[i] = BatchedOperation{ID: op.ID(), ExternalID: op.ExternalID(), Cost: op.Cost(), Key: op.Key(), Watcher: watcher.Label()}
+	}
+	return described
+}
+
+type batch struct {
+	mutex       sync.Mutex
+	batcher     *batcher
+	watcher     Watcher
+	ops         []Operation
+	reservation ReservationHandle
+	requeued    bool
+	finished    bool
+}
+
+// This returns the Operations in the batch.
+func (b *batch) Operations() []Operation {
+	return b.ops
+}
+
+// This method puts the entire batch back into the Batcher as a unit. After the delay, the same Operations will be raised together to
+// the Watcher again on the next flush that has capacity (and a concurrency slot) for them; they are never split up or combined with
+// other Operations. Attempts are preserved, so if any Operation has already reached MaxAttempts, `TooManyAttemptsError` is returned
+// and nothing is requeued. This can only be called once and only while the Watcher is processing the batch; otherwise,
+// `BatchNotRequeueableError` is returned. Once requeued, the Operations do not get a Result for this attempt.
+func (b *batch) RequeueAll(delay time.Duration) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.batcher == nil || b.requeued || b.finished {
+		return BatchNotRequeueableError
+	}
+	maxAttempts := b.watcher.MaxAttempts()
+	for _, op := range b.ops {
+		if maxAttempts > 0 && op.Attempt() >= maxAttempts {
+			return TooManyAttemptsError
+		}
+	}
+	b.requeued = true
+
+	// the capacity will not be used by this attempt
+	if b.reservation != nil {
+		b.reservation.Release()
+	}
+
+	b.batcher.schedule(b.watcher, b.ops, delay)
+	return nil
+}
+
+// This is called when the batch is done (or abandoned) and returns true if the batch was requeued.
+func (b *batch) finish() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.finished = true
+	return b.requeued
+}
+
+// Batches are built per Watcher and, if the Watcher groups by key, per key.
+type batchKey struct {
+	watcher Watcher
+	key     string
+}
+
+type scheduledBatch struct {
+	watcher Watcher
+	ops     []Operation
+	due     time.Time
+}
@@ -2,37 +2,99 @@ package batcher
 
 import (
 	"context"
+	"fmt"
+	"math"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// ReportActualCost() moves the cost scale this much of the way towards each report, which is limited to this range
+	costScaleWeight = 0.1
+	minCostScale    = 0.1
+	maxCostScale    = 10.0
 )
 
+// Healthy() returns AuditFailingError once this many audits in a row have failed
+const unhealthyAuditFailures = 3
+
 const (
 	phaseUninitialized = iota
 	phaseStarted
 	phasePaused
+	phaseDraining
 	phaseStopped
 )
 
 type Batcher interface {
 	Eventer
+	batcherLogging
 	WithRateLimiter(rl RateLimiter) Batcher
+	WithRateLimiters(limiters ...RateLimiter) Batcher
 	WithFlushInterval(val time.Duration) Batcher
 	WithCapacityInterval(val time.Duration) Batcher
 	WithAuditInterval(val time.Duration) Batcher
 	WithMaxOperationTime(val time.Duration) Batcher
 	WithPauseTime(val time.Duration) Batcher
 	WithErrorOnFullBuffer() Batcher
+	WithFullBufferPolicy(policy FullBufferPolicy) Batcher
+	WithMemoryWatermarks(high, low uint64) Batcher
+	WithBufferImplementation(impl BufferImplementation) Batcher
+	WithOverflowFile(path string, codec PayloadCodec) Batcher
+	WithBufferStore(store BufferStore, codec PayloadCodec, watchers ...Watcher) Batcher
 	WithEmitBatch() Batcher
+	WithBatchPooling() Batcher
+	WithEmitBatchOperations() Batcher
+	WithEmitOperations() Batcher
 	WithEmitFlush() Batcher
 	WithEmitRequest() Batcher
 	WithMaxConcurrentBatches(val uint32) Batcher
+	WithWorkers(val uint32) Batcher
+	WithMaxBatchesPerFlush(val uint32) Batcher
+	WithZeroCostOpsPerSecond(val uint32) Batcher
+	WithAlignToRenewal() Batcher
+	WithClearListenersOnShutdown() Batcher
+	WithDeadlineFirst() Batcher
+	WithDeduplication(merge func(buffered, incoming Operation) Operation) Batcher
+	WithOrderedKeys() Batcher
+	WithCaptureStackOnTimeout() Batcher
+	WithSummaryInterval(val time.Duration) Batcher
+	WithRequireStarted() Batcher
+	WithFlushOnCost(val uint32) Batcher
+	WithFlushOnSize() Batcher
+	WithTracerProvider(tp trace.TracerProvider) Batcher
+	WithAsyncEvents(bufferSize uint32) Batcher
+	WithClock(clock Clock) Batcher
+	ListenerCount() int
+	RemoveAllListeners()
+	DroppedEvents() uint64
 	Enqueue(op Operation) error
+	EnqueueWithContext(ctx context.Context, op Operation) error
+	EnqueueMany(ops []Operation) error
+	Cancel(op Operation) bool
+	InspectBuffer(fn func(op Operation) bool)
+	Purge(predicate func(op Operation) bool) int
 	Pause()
+	PauseFor(val time.Duration)
+	Resume()
+	PauseWatcher(watcher Watcher, val time.Duration)
+	ResumeWatcher(watcher Watcher)
 	Flush()
+	FlushWatcher(watcher Watcher)
 	Inflight() uint32
+	Stats() BatcherStats
+	ApplyConfig(cfg Config) error
+	Healthy() error
 	OperationsInBuffer() uint32
 	NeedsCapacity() uint32
+	ReportActualCost(op Operation, actual uint32)
 	Start(ctx context.Context) (err error)
+	Shutdown(ctx context.Context) error
 }
 
 type batcher struct {
@@ -40,31 +102,110 @@ type batcher struct {
 
 	// configuration items that should not change after Start()
 	ratelimiter          RateLimiter
-	flushInterval        time.Duration
-	capacityInterval     time.Duration
 	auditInterval        time.Duration
 	maxOperationTime     time.Duration
 	pauseTime            time.Duration
-	errorOnFullBuffer    bool
 	emitBatch            bool
+	emitBatchOperations  bool
+	poolBatches          bool
+	workerCount          uint32
+	workers              *workerPool // set by Start() if WithWorkers() was used
+	batchPool            sync.Pool
+	emitOperations       bool
 	emitFlush            bool
 	emitRequest          bool
-	maxConcurrentBatches uint32
+	maxBatchesPerFlush   uint32
+	zeroCostOpsPerSecond uint32
+	alignToRenewal       bool
+	clearListeners       bool
+	captureStacks        bool
+	summaryInterval      time.Duration
+	requireStarted       bool
+	tracer               trace.Tracer
+	clock                Clock
+	ordered              *orderedKeys // keeps Operations with the same key in order (if WithOrderedKeys)
 
 	// used for internal operations
-	buffer               ibuffer       // operations that are in the queue
-	pause                chan struct{} // contains a record if batcher is paused
-	flush                chan struct{} // contains a record if batcher should flush
-	inflight             chan struct{} // tracks the number of inflight batches
-	lastFlushWithRecords time.Time     // tracks the last time records were flushed
+	buffer               ibuffer             // operations that are in the queue
+	pause                chan time.Duration  // contains a record (of how long) if batcher is paused
+	resumeNow            chan struct{}       // contains a record if a pause should end early
+	flush                chan struct{}       // contains a record if batcher should flush
+	reconfigured         chan struct{}       // contains a record if an interval was changed after Start()
+	inflight             int32               // tracks the number of inflight batches
+	lastFlushWithRecords time.Time           // tracks the last time records were flushed
+	reservations         []ReservationHandle // capacity reserved by the current flush
+	zeroCostAllowance    float64             // zero-cost operations that may still be dispatched
+	scheduledMutex       sync.Mutex          // protects scheduled
+	scheduled            []scheduledBatch    // batches that were requeued as a unit
+	summary              *summarizer         // aggregates the summary event (if there is a SummaryInterval)
+	preStartOnce         sync.Once           // ensures the pre-start-enqueue event is only raised once
+	running              int32               // the number of batches the watchers have not finished with
+	lingering            int32               // set once an Operation is enqueued for a Watcher with a MinBatchSize
+	expiring             int32               // set once an Operation with an expiry is enqueued
+	weighted             int32               // set once an Operation is enqueued for a Watcher with a weight
+	highWatermark        uint64              // the estimated memory of the buffer that raises the high-watermark event
+	lowWatermark         uint64              // the estimated memory of the buffer that raises the low-watermark event
+	aboveWatermark       int32               // set from the high-watermark event until the low-watermark event
+	store                *bufferStore        // set if enqueued operations are persisted
+	waiting              int32               // set while waiting for a rate limiter to grant capacity
+	cancel               context.CancelFunc  // stops the processing loop
+	stopped              chan struct{}       // closed when the processing loop has stopped
 
 	// manage the phase
 	phaseMutex sync.Mutex
 	phase      int
 
-	// target needs to be threadsafe and changes frequently
-	targetMutex sync.RWMutex
-	target      uint32
+	// configuration items that may change after Start(); they are only accessed with atomics
+	flushInterval        int64 // a time.Duration
+	capacityInterval     int64 // a time.Duration
+	maxConcurrentBatches uint32
+	errorOnFullBuffer    uint32 // 1 if Enqueue() should return BufferFullError
+
+	// target needs to be threadsafe and changes frequently; it is only accessed with atomics so reading it never contends
+	target uint32
+
+	// the portion of the target for each rate limiter provided by Watcher.WithRateLimiter() (RateLimiter -> *uint32)
+	limiterTargets sync.Map
+
+	// how much Operations actually cost compared to their estimated cost per ReportActualCost() (float64 bits; 0 means 1)
+	costScale uint64
+
+	// the target of each DimensionalRateLimiter in its own dimension (RateLimiter -> *uint32)
+	dimensionTargets sync.Map
+
+	// cumulative counts for Stats(); they are only accessed with atomics
+	enqueued      uint64
+	batches       uint64
+	processed     uint64
+	failed        uint64
+	auditFailures uint64
+	expired       uint64
+	cancelled     uint64
+	purged        uint64
+	coalesced     uint64
+	dropped       uint64
+	flushLatency  int64 // a time.Duration
+	startedAt     int64 // unix nanoseconds (0 until Start())
+	recentSizes   batchSizes
+
+	// the number of audits in a row that have failed (for Healthy()); it is only accessed with atomics
+	consecutiveAuditFailures uint32
+
+	// watchers that may not be raised another batch until the time because a batch failed
+	cooldownMutex sync.Mutex
+	cooldowns     map[Watcher]time.Time
+
+	// when the processing loop first held back each batch for MinBatchSize; only used by the processing loop
+	lingerSince map[batchKey]time.Time
+
+	// watchers that may not be raised another batch until the time (or until ResumeWatcher() if the time is zero)
+	watcherPauseMutex sync.Mutex
+	watcherPauses     map[Watcher]time.Time
+
+	// a flush requested by Flush() includes every Watcher; otherwise it only includes those requested by FlushWatcher()
+	flushRequestMutex sync.Mutex
+	flushEverything   bool
+	flushWatchers     map[Watcher]bool
 }
 
 // This method creates a new Batcher with a buffer that can contain up to 10,000 Operations. Generally you should have 1 Batcher per datastore.
@@ -77,10 +218,29 @@ func NewBatcher() Batcher {
 // Batcher per datastore. Commonly after calling NewBatcherWithBuffer() you will chain some WithXXXX methods, for instance...
 // `NewBatcherWithBuffer().WithRateLimiter(limiter)`.
 func NewBatcherWithBuffer(maxBufferSize uint32) Batcher {
+	return newBatcher(newBuffer(maxBufferSize))
+}
+
+// This method creates a new Batcher with a buffer that is never full, so Enqueue() never blocks or returns BufferFullError (and
+// WithErrorOnFullBuffer and WithFullBufferPolicy have no effect). The buffer grows as long as Operations are enqueued faster than they
+// are processed, so you should watch its estimated memory (see WithMemoryWatermarks and Stats()).
+func NewBatcherWithUnboundedBuffer() Batcher {
+	return newBatcher(newUnboundedBuffer())
+}
+
+func newBatcher(buffer ibuffer) *batcher {
 	r := &batcher{}
-	r.buffer = newBuffer(maxBufferSize)
-	r.pause = make(chan struct{}, 1)
+	r.buffer = buffer
+	r.pause = make(chan time.Duration, 1)
+	r.resumeNow = make(chan struct{}, 1)
 	r.flush = make(chan struct{}, 1)
+	r.reconfigured = make(chan struct{}, 1)
+	r.cooldowns = make(map[Watcher]time.Time)
+	r.lingerSince = make(map[batchKey]time.Time)
+	r.watcherPauses = make(map[Watcher]time.Time)
+	r.flushWatchers = make(map[Watcher]bool)
+	r.stopped = make(chan struct{})
+	r.clock = realClock{}
 	return r
 }
 
@@ -96,31 +256,57 @@ func (r *batcher) WithRateLimiter(rl RateLimiter) Batcher {
 	return r
 }
 
+// Some datastores enforce more than one quota at the same time (for instance, request units and requests per second). Setting this option
+// limits the Batcher by all of the provided rate limiters; a batch is only dispatched if every one of them grants its cost. Each flush
+// uses the smallest Capacity and the MaxCapacity for an Operation is the smallest MaxCapacity. A rate limiter bound to a dimension with
+// NewDimensionalRateLimiter() is only charged the cost of each Operation in that dimension. This replaces any rate limiter provided by
+// WithRateLimiter().
+func (r *batcher) WithRateLimiters(limiters ...RateLimiter) Batcher {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.phase != phaseUninitialized {
+		panic(InitializationOnlyError)
+	}
+	switch len(limiters) {
+	case 0:
+		r.ratelimiter = nil
+	case 1:
+		r.ratelimiter = limiters[0]
+	default:
+		r.ratelimiter = newCompositeRateLimiter(limiters...)
+	}
+	return r
+}
+
 // The FlushInterval determines how often the processing loop attempts to flush buffered Operations. The default is `100ms`. If a rate limiter
 // is being used, the interval determines the capacity that each flush has to work with. For instance, with the default 100ms and 10,000
 // available capacity, there would be 10 flushes per second, each dispatching one or more batches of Operations that aim for 1,000 total
-// capacity. If no rate limiter is used, each flush will attempt to empty the buffer.
+// capacity. If no rate limiter is used, each flush will attempt to empty the buffer. This may be changed after Start(); the next flush
+// happens one new interval after the change.
 func (r *batcher) WithFlushInterval(val time.Duration) Batcher {
 	r.phaseMutex.Lock()
 	defer r.phaseMutex.Unlock()
-	if r.phase != phaseUninitialized {
-		panic(InitializationOnlyError)
+	if r.phase != phaseUninitialized && val <= 0 {
+		val = 100 * time.Millisecond
 	}
-	r.flushInterval = val
+	atomic.StoreInt64(&r.flushInterval, int64(val))
+	r.reconfigure()
 	return r
 }
 
 // The CapacityInterval determines how often the processing loop asks the rate limiter for capacity by calling GiveMe(). The default is
 // `100ms`. The Batcher asks for capacity equal to every Operation's cost that has not been marked done. In other words, when you Enqueue()
 // an Operation it increments a target based on cost. When you call done() on a batch (or the MaxOperationTime is exceeded), the target is
-// decremented by the cost of all Operations in the batch. If there is no rate limiter attached, this interval does nothing.
+// decremented by the cost of all Operations in the batch. If there is no rate limiter attached, this interval does nothing. This may be
+// changed after Start().
 func (r *batcher) WithCapacityInterval(val time.Duration) Batcher {
 	r.phaseMutex.Lock()
 	defer r.phaseMutex.Unlock()
-	if r.phase != phaseUninitialized {
-		panic(InitializationOnlyError)
+	if r.phase != phaseUninitialized && val <= 0 {
+		val = 100 * time.Millisecond
 	}
-	r.capacityInterval = val
+	atomic.StoreInt64(&r.capacityInterval, int64(val))
+	r.reconfigure()
 	return r
 }
 
@@ -173,255 +359,1783 @@ func (r *batcher) WithErrorOnFullBuffer() Batcher {
 	if r.phase != phaseUninitialized {
 		panic(InitializationOnlyError)
 	}
-	r.errorOnFullBuffer = true
+	atomic.StoreUint32(&r.errorOnFullBuffer, 1)
 	return r
 }
 
-// DO NOT SET THIS IN PRODUCTION. For unit tests, it may be beneficial to raise an event for each batch of operations.
-func (r *batcher) WithEmitBatch() Batcher {
+// Setting this option determines what Enqueue() does when the buffer is full. BlockWhenFull (the default) and ErrorWhenFull are the
+// same as without and with WithErrorOnFullBuffer. When you would rather shed load than block producers, the drop policies keep
+// Enqueue() from ever blocking or returning BufferFullError: DropNewest drops the Operation being enqueued, DropOldest drops the
+// Operation at the head of the buffer (the one enqueued first, unless WithDeadlineFirst is used), and DropLowestPriority drops the
+// Operation with the lowest priority (see Operation.WithPriority()), preferring the one closest to the head when there is a tie; the
+// Operation being enqueued is only dropped if its priority is lower than every Operation in the buffer. The dropped Operation is
+// completed with a Result whose Status is ResultDropped and whose Err is OperationDroppedError and a dropped event is raised for it.
+// Operations that the processing loop is considering at that moment are never dropped.
+func (r *batcher) WithFullBufferPolicy(policy FullBufferPolicy) Batcher {
 	r.phaseMutex.Lock()
 	defer r.phaseMutex.Unlock()
 	if r.phase != phaseUninitialized {
 		panic(InitializationOnlyError)
 	}
-	r.emitBatch = true
+	if policy == ErrorWhenFull {
+		atomic.StoreUint32(&r.errorOnFullBuffer, 1)
+	} else {
+		atomic.StoreUint32(&r.errorOnFullBuffer, 0)
+	}
+	r.buffer.dropWhenFull(policy)
 	return r
 }
 
-// Generally you do not want this setting for production, but it can be helpful for unit tests to raise an event every time
-// a flush is started and completed.
-func (r *batcher) WithEmitFlush() Batcher {
-	r.emitFlush = true
+// Under many goroutines enqueuing at the same time (for instance, 50 or more), the lock of the buffer can become the bottleneck. Setting
+// this option to ShardedBuffer spreads Enqueue() and EnqueueMany() over several shards (one for each processor) that are each protected
+// by their own lock and reserve room against their own share of the buffer; the shards are moved into the buffer (in the order the
+// Operations were enqueued) whenever the processing loop looks at it, which also hands out the room left to the shards, so Operations
+// are still flushed, cancelled, and inspected as usual. The buffer is
+// still bounded by its max and Enqueue() still blocks (or returns BufferFullError) when it is full. WithDeduplication,
+// WithFullBufferPolicy (with a drop policy), WithOverflowFile, WithFlushOnCost, and WithFlushOnSize must see the buffer when each
+// Operation is enqueued, so if any of them is used, Operations are enqueued while holding the lock as with LockedBuffer (the default).
+func (r *batcher) WithBufferImplementation(impl BufferImplementation) Batcher {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.phase != phaseUninitialized {
+		panic(InitializationOnlyError)
+	}
+	switch current := r.buffer.(type) {
+	case *buffer:
+		if impl == ShardedBuffer {
+			r.buffer = newShardedBuffer(current)
+		}
+	case *shardedBuffer:
+		if impl != ShardedBuffer {
+			current.collectShards()
+			r.buffer = current.buffer
+		}
+	}
 	return r
 }
 
-// Generally you do not want this setting for production, but it can be helpful for unit tests to raise an event every time
-// a request is made for capacity.
-func (r *batcher) WithEmitRequest() Batcher {
-	r.emitRequest = true
+// Setting this option raises the high-watermark event when the estimated memory of the buffer (see BatcherStats.EstimatedMemory) rises
+// to high bytes and the low-watermark event when it then falls to low bytes, so you can see a backlog growing before it becomes a
+// problem (most commonly with NewBatcherWithUnboundedBuffer()). Each is only raised once until the other is raised, so low should be
+// well below high. The estimate is checked after each Operation is enqueued and after each flush.
+func (r *batcher) WithMemoryWatermarks(high, low uint64) Batcher {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.phase != phaseUninitialized {
+		panic(InitializationOnlyError)
+	}
+	r.highWatermark = high
+	r.lowWatermark = low
 	return r
 }
 
-// Setting this option limits the number of batches that can be processed at a time to the provided value.
-func (r *batcher) WithMaxConcurrentBatches(val uint32) Batcher {
-	r.maxConcurrentBatches = val
-	r.inflight = make(chan struct{}, val)
+// Bursts beyond the buffer otherwise force a choice between blocking producers and dropping Operations. Setting this option adds the
+// Operations that are enqueued while the buffer is full to an overflow instead; their payloads are converted to bytes by the codec and
+// appended to the file at path (which is created the first time it is needed), and they are replayed into the buffer (in the order they
+// were enqueued) at the start of each flush as room frees up. The Operations themselves stay in memory so that their callbacks and Done()
+// still work; only their payloads are written, so the memory of each Operation without its payload is still held. Once there are
+// Operations in the overflow, Operations are added to it until it has been replayed so that they stay in order. If the codec or the
+// file returns an error, Enqueue() returns it; if a payload cannot be read back, the Operation is completed with a Result whose Status is
+// ResultFailed and an error event is raised. The overflow is not durable: the file is truncated once every Operation has been replayed
+// and is removed on shutdown. This takes precedence over WithErrorOnFullBuffer and WithFullBufferPolicy. Operations in the overflow are
+// not considered by WithDeduplication.
+func (r *batcher) WithOverflowFile(path string, codec PayloadCodec) Batcher {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.phase != phaseUninitialized {
+		panic(InitializationOnlyError)
+	}
+	r.buffer.overflowTo(path, codec)
 	return r
 }
 
-func (r *batcher) applyDefaults() {
-	if r.flushInterval <= 0 {
-		r.flushInterval = 100 * time.Millisecond
-	}
-	if r.capacityInterval <= 0 {
-		r.capacityInterval = 100 * time.Millisecond
-	}
-	if r.auditInterval <= 0 {
-		r.auditInterval = 10 * time.Second
-	}
-	if r.maxOperationTime <= 0 {
-		r.maxOperationTime = 1 * time.Minute
-	}
-	if r.pauseTime <= 0 {
-		r.pauseTime = 500 * time.Millisecond
+// Losing the Operations in the buffer when the process crashes may not be acceptable. Setting this option saves each Operation to the
+// store when it is enqueued (with its payload encoded by the codec) and deletes it once it has a final Result, so the Operations that
+// were saved by a previous process and never finished are loaded and put back at the head of the buffer when Start() is called. The
+// Operations are identified by the label of their Watcher, so every Watcher must have a label (see Watcher.WithLabel()) and you must
+// provide the Watchers whose Operations should be loaded; Operations for any other Watcher (or whose payload cannot be decoded) are left
+// in the store and an error event is raised for each. Since an Operation is only deleted once it has a final Result, an Operation may
+// be raised again after a crash even if the Watcher finished it (at-least-once). If the Operation cannot be saved, Enqueue() returns the
+// error. The metadata, callbacks, and span context of an Operation are not stored. See NewFileBufferStore() for a store that uses a
+// local file.
+func (r *batcher) WithBufferStore(store BufferStore, codec PayloadCodec, watchers ...Watcher) Batcher {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.phase != phaseUninitialized {
+		panic(InitializationOnlyError)
 	}
+	r.store = newBufferStore(store, codec, watchers, r)
+	return r
 }
 
-// Call this method to add an Operation into the buffer.
-func (r *batcher) Enqueue(op Operation) error {
+// DO NOT SET THIS IN PRODUCTION. For unit tests, it may be beneficial to raise an event for each batch of operations. The metadata of
+// the event only describes each Operation (see BatchedOperation) so that listeners do not keep payloads alive or mutate Operations.
+func (r *batcher) WithEmitBatch() Batcher {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.phase != phaseUninitialized {
+		panic(InitializationOnlyError)
+	}
+	r.emitBatch = true
+	return r
+}
 
-	// ensure an operation was provided
-	if op == nil {
-		return NoOperationError
+// DO NOT SET THIS IN PRODUCTION. This is the same as WithEmitBatch() except that the metadata of the event is the slice of Operations in
+// the batch (including their payloads) rather than a description of each.
+func (r *batcher) WithEmitBatchOperations() Batcher {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.phase != phaseUninitialized {
+		panic(InitializationOnlyError)
 	}
+	r.emitBatch = true
+	r.emitBatchOperations = true
+	return r
+}
 
-	// ensure there is a watcher associated with the call
-	watcher := op.Watcher()
-	if op.Watcher() == nil {
-		return NoWatcherError
+// At high flush rates, allocating a new slice for each batch puts pressure on the garbage collector. Setting this option reuses the slice
+// that holds the Operations of a batch once the Watcher has returned and the batch is complete. The slice passed to ProcessBatch() (or
+// returned by Batch.Operations()) is only yours until the Watcher returns; a Watcher must not keep it after ProcessBatch() returns, since
+// it is then cleared and handed to another batch, so copy it if you need it later. The slices of batches that exceed MaxOperationTime,
+// fail with an error (and so are the metadata of the batch-failed event), or are requeued with RequeueAll(), and the batches returned by
+// a Splitter, are never reused, and nothing is reused with WithEmitBatchOperations.
+func (r *batcher) WithBatchPooling() Batcher {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.phase != phaseUninitialized {
+		panic(InitializationOnlyError)
 	}
+	r.poolBatches = true
+	return r
+}
 
-	// ensure the cost doesn't exceed max capacity
-	if r.ratelimiter != nil && op.Cost() > r.ratelimiter.MaxCapacity() {
-		return TooExpensiveError
+// DO NOT SET THIS IN PRODUCTION UNLESS YOU NEED IT. Setting this option raises an event at each milestone of every Operation: when it is
+// enqueued (operation-enqueued), when it is dispatched in a batch (operation-batched), when an attempt is completed (operation-completed),
+// and when it is dropped from the buffer by shutdown (operation-dropped). The metadata of each event is the Operation, so you can use its
+// ID to measure the latency of each record. This raises several events per Operation and the metadata includes the payload, so it has
+// the same concerns as WithEmitBatchOperations().
+func (r *batcher) WithEmitOperations() Batcher {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.phase != phaseUninitialized {
+		panic(InitializationOnlyError)
 	}
+	r.emitOperations = true
+	return r
+}
 
-	// ensure there are not too many attempts
-	maxAttempts := watcher.MaxAttempts()
-	if maxAttempts > 0 && op.Attempt() >= maxAttempts {
-		return TooManyAttemptsError
+// This raises an event for a milestone of an Operation if WithEmitOperations is set.
+func (r *batcher) emitOperation(event string, val int, msg string, op Operation) {
+	if r.emitOperations {
+		r.Emit(event, val, msg, op)
 	}
+}
 
-	// increment the target
-	r.incTarget(int(op.Cost()))
+// Generally you do not want this setting for production, but it can be helpful for unit tests to raise an event every time
+// a flush is started and completed.
+func (r *batcher) WithEmitFlush() Batcher {
+	r.emitFlush = true
+	return r
+}
 
-	// put into the buffer
-	return r.buffer.enqueue(op, r.errorOnFullBuffer)
+// Generally you do not want this setting for production, but it can be helpful for unit tests to raise an event every time
+// a request is made for capacity.
+func (r *batcher) WithEmitRequest() Batcher {
+	r.emitRequest = true
+	return r
 }
 
-// Call this method when your datastore is throwing transient errors. This pauses the processing loop to ensure that you are not flooding
-// the datastore with additional data it cannot process making the situation worse.
-func (r *batcher) Pause() {
+// Setting this option limits the number of batches that can be processed at a time to the provided value. This may be changed after
+// Start(); lowering it does not affect batches that are already inflight, but no new batches are raised until enough of them are done.
+// Setting it to 0 removes the limit.
+func (r *batcher) WithMaxConcurrentBatches(val uint32) Batcher {
+	atomic.StoreUint32(&r.maxConcurrentBatches, val)
+	return r
+}
 
-	// ensure pausing only happens when it is running
+// Normally each batch is processed on a new goroutine. Setting this option processes the batches on the provided number of long-lived
+// workers instead, which avoids starting goroutines at high flush rates and makes how many batches run at the same time predictable. If
+// every worker is busy, a batch waits (in the order it was dispatched) for one to be free; it counts as running (see Stats()) while it
+// waits, but MaxOperationTime only starts once a worker picks it up. At most one batch waits for each worker; once that many are
+// waiting, no more batches are started (as if MaxConcurrentBatches were reached), so a slow Watcher applies backpressure (the
+// Operations stay in the buffer) rather than letting batches pile up or blocking the processing loop. A Watcher that exceeds
+// MaxOperationTime keeps its worker busy until it returns. Since batches that are waiting still hold a slot provided by
+// WithMaxConcurrentBatches, you will generally want at least that many workers. Setting it to 0 (the default) starts a goroutine for
+// each batch.
+func (r *batcher) WithWorkers(val uint32) Batcher {
 	r.phaseMutex.Lock()
 	defer r.phaseMutex.Unlock()
-	if r.phase != phaseStarted {
-		// simply ignore an invalid pause
-		return
+	if r.phase != phaseUninitialized {
+		panic(InitializationOnlyError)
 	}
+	r.workerCount = val
+	return r
+}
 
-	// pause
-	select {
-	case r.pause <- struct{}{}:
-		// successfully set the pause
-	default:
-		// pause was already set
+// Setting this option limits the number of batches that a single flush can dispatch to the provided value. This is independent of
+// MaxConcurrentBatches and ensures that a deep buffer does not turn a single flush into a massive burst when capacity suddenly becomes
+// available (for example, right after partitions are leased). Operations that do not fit are left in the buffer for the next flush.
+func (r *batcher) WithMaxBatchesPerFlush(val uint32) Batcher {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.phase != phaseUninitialized {
+		panic(InitializationOnlyError)
 	}
-
-	// switch to paused phase
-	r.phase = phasePaused
-
+	r.maxBatchesPerFlush = val
+	return r
 }
 
-func (r *batcher) resume() {
+// Operations with a cost of 0 are normally only limited by MaxBatchSize. If those "free" Operations still consume something downstream
+// (for instance, a request quota), setting this option limits how many of them can be dispatched per second. Zero-cost Operations over
+// the limit are left in the buffer for the next flush while other Operations continue to be dispatched.
+func (r *batcher) WithZeroCostOpsPerSecond(val uint32) Batcher {
 	r.phaseMutex.Lock()
 	defer r.phaseMutex.Unlock()
-	if r.phase == phasePaused {
-		r.phase = phaseStarted
+	if r.phase != phaseUninitialized {
+		panic(InitializationOnlyError)
 	}
+	r.zeroCostOpsPerSecond = val
+	return r
 }
 
-// Call this method to manually flush as if the flushInterval were triggered.
-func (r *batcher) Flush() {
-
-	// flush
-	select {
-	case r.flush <- struct{}{}:
-		// successfully set the flush
-	default:
-		// flush was already set
+// The capacity of a rate limiter typically renews every second, but the CapacityInterval and FlushInterval start whenever Start() is
+// called, so the two beat against each other and throughput oscillates. If the rate limiter implements RenewingRateLimiter, setting
+// this option restarts both intervals at every renewal boundary so that capacity requests and flushes line up with the renewal. This
+// works best when the intervals evenly divide the renewal period (for example, 100ms or 250ms for a 1 second renewal).
+func (r *batcher) WithAlignToRenewal() Batcher {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.phase != phaseUninitialized {
+		panic(InitializationOnlyError)
 	}
-
+	r.alignToRenewal = true
+	return r
 }
 
-// This tells you how many operations are still in the buffer. This does not include operations that have been sent back to the Watcher as part
-// of a batch for processing.
-func (r *batcher) OperationsInBuffer() uint32 {
-	return r.buffer.size()
+// Listeners often reference dependencies (loggers, metrics, channels, etc.) that are torn down when the application shuts down.
+// Setting this option removes every listener once the shutdown event has been raised so that no stale callback can be raised
+// afterwards. Listeners on the rate limiter are not affected.
+func (r *batcher) WithClearListenersOnShutdown() Batcher {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.phase != phaseUninitialized {
+		panic(InitializationOnlyError)
+	}
+	r.clearListeners = true
+	return r
 }
 
-// This tells you how much capacity the Batcher believes it needs to process everything outstanding. Outstanding operations include those in
-// the buffer and operations and any that have been sent as a batch but not marked done yet.
-func (r *batcher) NeedsCapacity() uint32 {
-	r.targetMutex.RLock()
-	defer r.targetMutex.RUnlock()
-	return r.target
+// Normally Operations are dispatched in the order they were enqueued. Setting this option orders the buffer by the deadline provided
+// by Operation.WithDeadline() so that when there is not enough capacity to dispatch everything, the most time-critical Operations are
+// dispatched first. Operations without a deadline are dispatched after those with one. Regardless of this option, a deadline-miss event
+// is raised for every Operation dispatched after its deadline.
+func (r *batcher) WithDeadlineFirst() Batcher {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.phase != phaseUninitialized {
+		panic(InitializationOnlyError)
+	}
+	r.buffer.orderByDeadline()
+	return r
 }
 
-func (r *batcher) confirmTargetIsZero() bool {
-	r.targetMutex.Lock()
-	defer r.targetMutex.Unlock()
-	if r.target > 0 {
-		r.target = 0
-		return false
-	} else {
-		return true
+// Upstream retries can enqueue the same write more than once. Setting this option prevents an Operation from being buffered while an
+// Operation with the same dedup key (see Operation.WithDedupKey()) for the same Watcher is still in the buffer. If merge is nil, the
+// duplicate is rejected with DuplicateOperationError. Otherwise, merge is called with both Operations and the Operation it returns
+// (which may be either of them or a new Operation for the same Watcher, for instance, with a combined payload) replaces the buffered
+// Operation in its position; the Operations that were replaced are completed with the final Result of that Operation and a coalesced
+// event is raised. If merge returns nil, the duplicate is rejected. merge is called while the buffer is locked, so it must be quick and
+// must not call back into the Batcher. Operations without a dedup key and Operations that have already been dispatched are never
+// considered duplicates.
+func (r *batcher) WithDeduplication(merge func(buffered, incoming Operation) Operation) Batcher {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.phase != phaseUninitialized {
+		panic(InitializationOnlyError)
 	}
+	r.buffer.deduplicate(merge)
+	return r
 }
 
-func (r *batcher) incTarget(val int) {
-	r.targetMutex.Lock()
-	defer r.targetMutex.Unlock()
-	if val < 0 && r.target >= uint32(-val) {
-		r.target += uint32(val)
-	} else if val < 0 {
-		r.target = 0
-	} else if val > 0 {
-		r.target += uint32(val)
-	} // else is val=0, do nothing
+// Normally Operations with the same key (see Operation.WithKey()) may be split across batches that are processed concurrently, so a
+// Watcher may apply them out of order. Setting this option guarantees that Operations for the same Watcher with the same key are
+// raised in the order they are in the buffer and that a batch is not raised with an Operation whose key is in a batch the Watcher has
+// not finished with (even if that batch timed out), regardless of MaxConcurrentBatches. Operations with a key wait in the buffer while an
+// earlier Operation with that key is waiting or being processed, so a slow key does not hold back other keys. Operations that are put
+// back at the head of the buffer (such as those retried after a panic or requeued with RequeueAll()) stay ahead of later Operations, but
+// an Operation that the Watcher enqueues again goes to the tail. Since WithDeadlineFirst() reorders the buffer, do not use both. If the
+// Watcher has a Splitter, it must not reorder the Operations it is provided.
+func (r *batcher) WithOrderedKeys() Batcher {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.phase != phaseUninitialized {
+		panic(InitializationOnlyError)
+	}
+	r.ordered = newOrderedKeys()
+	return r
 }
 
-func (r *batcher) tryReserveBatchSlot() bool {
-	if r.maxConcurrentBatches == 0 {
-		return true
-	}
-	select {
-	case r.inflight <- struct{}{}:
-		return true
-	default:
-		return false
+// When a batch exceeds MaxOperationTime, a timeout event is raised. Setting this option captures the stack of the goroutine that is
+// running the Watcher and provides it as the msg of that event so you can see what the Watcher was blocked on. Capturing the stack
+// requires a dump of every goroutine, so this is only done on timeout, but it does briefly stop the world.
+func (r *batcher) WithCaptureStackOnTimeout() Batcher {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.phase != phaseUninitialized {
+		panic(InitializationOnlyError)
 	}
+	r.captureStacks = true
+	return r
 }
 
-func (r *batcher) releaseBatchSlot() {
-	if r.maxConcurrentBatches > 0 {
-		<-r.inflight
+// Setting this option raises a summary event at the provided interval (for instance, every minute) with a Summary of the batches,
+// Operations, failures, latency, capacity, and utilization over the interval. This is useful for services that want a single log entry
+// per interval rather than handling the stream of individual events.
+func (r *batcher) WithSummaryInterval(val time.Duration) Batcher {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.phase != phaseUninitialized {
+		panic(InitializationOnlyError)
 	}
+	r.summaryInterval = val
+	return r
 }
 
-func (r *batcher) confirmInflightIsZero() bool {
-	isZero := true
-	for {
-		select {
-		case <-r.inflight:
-			isZero = false
-		default:
-			return isZero
-		}
+// Normally Operations can be enqueued before Start() is called; they simply wait in the buffer. Some consider this a source of bugs
+// (for instance, forgetting to call Start() at all). Setting this option causes Enqueue() (and its variants) to return NotStartedError
+// until Start() is called. Without this option, a pre-start-enqueue event is raised the first time an Operation is enqueued before
+// Start().
+func (r *batcher) WithRequireStarted() Batcher {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.phase != phaseUninitialized {
+		panic(InitializationOnlyError)
 	}
+	r.requireStarted = true
+	return r
 }
 
-func (r *batcher) Inflight() uint32 {
-	return uint32(len(r.inflight))
+// Normally Operations wait in the buffer until the next FlushInterval. Setting this option triggers a flush as soon as an enqueue brings
+// the total cost of the Operations in the buffer to at least the provided value, so a large batch that is already waiting does not pay
+// up to a full FlushInterval of latency. It only triggers again after the buffered cost drops below the value. The flush is still
+// limited by the capacity of the rate limiter.
+func (r *batcher) WithFlushOnCost(val uint32) Batcher {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.phase != phaseUninitialized {
+		panic(InitializationOnlyError)
+	}
+	r.buffer.flushOnCost(val, r.Flush)
+	return r
 }
 
-func (r *batcher) processBatch(watcher Watcher, batch []Operation) {
-	if len(batch) == 0 {
-		return
+// Normally Operations wait in the buffer until the next FlushInterval. Setting this option triggers a flush of a Watcher (see
+// FlushWatcher()) as soon as an enqueue brings the batchable Operations for it (for a key, if it groups by key) to its MaxBatchSize or
+// MaxBatchBytes, so a full batch does not wait for the interval. It only triggers again after that batch has been dispatched (or the
+// Operations otherwise leave the buffer). Watchers without a MaxBatchSize or MaxBatchBytes are not affected. The flush is still limited
+// by the capacity of the rate limiter.
+func (r *batcher) WithFlushOnSize() Batcher {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.phase != phaseUninitialized {
+		panic(InitializationOnlyError)
 	}
-	r.lastFlushWithRecords = time.Now()
+	r.buffer.flushOnSize(r.FlushWatcher)
+	return r
+}
 
-	// raise event
-	if r.emitBatch {
-		r.Emit(BatchEvent, len(batch), "", batch)
+// If you provide a TracerProvider, Batcher creates an OpenTelemetry span for each call to EnqueueWithContext() (as a child of the span
+// in the context) and for each batch (from when it is raised to the Watcher until it is completed). The span for a batch is the root of
+// its own trace and is linked to the span context of each Operation in it (see Operation.WithSpanContext()), so you can find the
+// requests that enqueued a slow batch. A ContextWatcher receives a context containing the span for the batch so the calls it makes to
+// the datastore are children of it.
+func (r *batcher) WithTracerProvider(tp trace.TracerProvider) Batcher {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.phase != phaseUninitialized {
+		panic(InitializationOnlyError)
+	}
+	r.tracer = tp.Tracer(tracerName)
+	return r
+}
+
+// Normally each event is raised to the listeners on the goroutine that raised it, so a slow listener can stall the processing loop.
+// Setting this option queues events in a buffer of the provided size instead, and a single long-lived goroutine (started by this option)
+// raises them to the listeners in the order they were raised. If the buffer is full, the event is dropped and counted by
+// DroppedEvents(). Shutdown() does not return until the queued events have been raised. Since listeners are called later, any
+// Operations in the metadata may have changed by then.
+func (r *batcher) WithAsyncEvents(bufferSize uint32) Batcher {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.phase != phaseUninitialized {
+		panic(InitializationOnlyError)
+	}
+	r.enableAsyncEvents(bufferSize)
+	return r
+}
+
+// Normally the Batcher uses the time package for its intervals, timeouts, and timestamps. Setting this option uses the provided Clock
+// instead, which is intended for tests that need to control time (see testutil.FakeClock). Deadlines provided by Operation.WithDeadline()
+// are compared with the Clock.
+func (r *batcher) WithClock(clock Clock) Batcher {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.phase != phaseUninitialized {
+		panic(InitializationOnlyError)
+	}
+	r.clock = clock
+	return r
+}
+
+// This asks each rate limiter for the capacity needed. The rate limiters provided by Watcher.WithRateLimiter() are asked for the capacity
+// needed by the Operations for those Watchers and the Batcher's rate limiter (if there is one) is asked for the rest.
+func (r *batcher) requestCapacity() {
+	request, scale := r.NeedsCapacity(), r.loadCostScale()
+	r.limiterTargets.Range(func(key, value interface{}) bool {
+		rl, target := key.(RateLimiter), atomic.LoadUint32(value.(*uint32))
+		give := scaleCost(r.dimensionTarget(rl, target), scale)
+		if r.emitRequest {
+			r.Emit(RequestEvent, int(give), "", rl)
+		}
+		rl.GiveMe(give)
+		if target < request {
+			request -= target
+		} else {
+			request = 0
+		}
+		return true
+	})
+	if r.ratelimiter != nil {
+		if r.emitRequest {
+			r.Emit(RequestEvent, int(scaleCost(request, scale)), "", nil)
+		}
+		for _, part := range limiterParts(r.ratelimiter) {
+			part.GiveMe(scaleCost(r.dimensionTarget(part, request), scale))
+		}
+	}
+}
+
+// A DimensionalRateLimiter is asked for the capacity needed in its own dimension rather than the provided target.
+func (r *batcher) dimensionTarget(rl RateLimiter, target uint32) uint32 {
+	if _, ok := rl.(DimensionalRateLimiter); !ok {
+		return target
+	}
+	if value, ok := r.dimensionTargets.Load(rl); ok {
+		return atomic.LoadUint32(value.(*uint32))
+	}
+	return 0
+}
+
+// This waits (in another goroutine) for the rate limiter to grant enough capacity for the cost and for a flush to have some of it, then
+// flushes rather than waiting for the next FlushInterval. Only one wait happens at a time.
+func (r *batcher) waitForCapacity(ctx context.Context, rl RateLimiter, cost uint32) {
+	if !atomic.CompareAndSwapInt32(&r.waiting, 0, 1) {
+		return
+	}
+	if ms := r.loadFlushInterval().Milliseconds(); ms > 0 {
+		if perFlush := uint32(math.Ceil(1000.0 / float64(ms))); perFlush > cost {
+			cost = perFlush
+		}
 	}
-
 	go func() {
+		defer atomic.StoreInt32(&r.waiting, 0)
+		if err := rl.WaitForCapacity(ctx, cost); err == nil {
+			r.Flush()
+		}
+	}()
+}
+
+// This returns the rate limiter that Operations for the Watcher draw capacity from or nil if there is none.
+func (r *batcher) limiterFor(watcher Watcher) RateLimiter {
+	if watcher != nil {
+		if rl := watcher.RateLimiter(); rl != nil {
+			return rl
+		}
+	}
+	return r.ratelimiter
+}
+
+// This tells the processing loop (if it is running) to restart its timers since an interval changed.
+func (r *batcher) reconfigure() {
+	select {
+	case r.reconfigured <- struct{}{}:
+	default:
+		// a reconfigure is already pending
+	}
+}
+
+func (r *batcher) loadFlushInterval() time.Duration {
+	return time.Duration(atomic.LoadInt64(&r.flushInterval))
+}
+
+func (r *batcher) loadCapacityInterval() time.Duration {
+	return time.Duration(atomic.LoadInt64(&r.capacityInterval))
+}
+
+func (r *batcher) loadErrorOnFullBuffer() bool {
+	return atomic.LoadUint32(&r.errorOnFullBuffer) == 1
+}
+
+func (r *batcher) applyDefaults() {
+	if r.loadFlushInterval() <= 0 {
+		atomic.StoreInt64(&r.flushInterval, int64(100*time.Millisecond))
+	}
+	if r.loadCapacityInterval() <= 0 {
+		atomic.StoreInt64(&r.capacityInterval, int64(100*time.Millisecond))
+	}
+	if r.auditInterval <= 0 {
+		r.auditInterval = 10 * time.Second
+	}
+	if r.maxOperationTime <= 0 {
+		r.maxOperationTime = 1 * time.Minute
+	}
+	if r.pauseTime <= 0 {
+		r.pauseTime = 500 * time.Millisecond
+	}
+}
+
+// Call this method to add an Operation into the buffer.
+func (r *batcher) Enqueue(op Operation) error {
+	return r.EnqueueWithContext(context.Background(), op)
+}
+
+// This method is the same as Enqueue() except that if the buffer is full (and WithErrorOnFullBuffer is not set), the call stops blocking
+// and returns the context's error when the context is cancelled or times out. Use this so that a stalled processing loop cannot block
+// the caller forever.
+func (r *batcher) EnqueueWithContext(ctx context.Context, op Operation) error {
+	var span trace.Span
+	if r.tracer != nil && op != nil {
+		ctx, span = r.tracer.Start(ctx, "gobatcher.enqueue", trace.WithAttributes(
+			attribute.Int64("gobatcher.operation.id", int64(op.ID())),
+			attribute.Int64("gobatcher.operation.cost", int64(op.Cost())),
+		))
+		defer span.End()
+		if id := op.ExternalID(); id != "" {
+			span.SetAttributes(attribute.String("gobatcher.operation.external_id", id))
+		}
+		if !op.SpanContext().IsValid() {
+			op.WithSpanContext(span.SpanContext())
+		}
+	}
+	err := r.enqueue(ctx, op)
+	if err != nil {
+		r.emitEnqueueError(op, err)
+		if span != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+	}
+	return err
+}
+
+func (r *batcher) enqueue(ctx context.Context, op Operation) error {
+
+	// validate
+	if err := r.checkStarted(); err != nil {
+		return err
+	}
+	if fragments := r.split(op); fragments != nil {
+		return r.enqueueFragments(ctx, fragments)
+	}
+	if err := r.validate(op); err != nil {
+		return err
+	}
+
+	markEnqueued(op, r.clock.Now())
+
+	// persist (if requested) before the operation can be dispatched
+	if r.store != nil {
+		if err := r.store.save(op); err != nil {
+			return err
+		}
+	}
+
+	// increment the target
+	r.incTarget(op.Watcher(), 1, op)
+
+	// put into the buffer; the target is restored if the operation could not be added
+	if err := r.buffer.enqueueWithContext(ctx, op, r.loadErrorOnFullBuffer()); err != nil {
+		r.incTarget(op.Watcher(), -1, op)
+		if coalesced, ok := err.(*coalescedError); ok {
+			r.onCoalesced(op, coalesced)
+			return nil
+		}
+		if dropped, ok := err.(*droppedError); ok {
+			r.onDropped(op, dropped)
+			return nil
+		}
+		if r.store != nil {
+			r.store.forget(op)
+		}
+		return err
+	}
+	atomic.AddUint64(&r.enqueued, 1)
+	r.emitOperation(OperationEnqueuedEvent, int(op.Cost()), "", op)
+	r.checkWatermarks()
+
+	return nil
+}
+
+// This method adds several Operations into the buffer (in the order provided) while only acquiring the buffer's lock once, which is
+// much cheaper than calling Enqueue() for each when there are many. Each Operation is validated the same as with Enqueue() and if the
+// buffer is full, this blocks until there is room (or, with WithErrorOnFullBuffer, fails the remaining Operations). The Operations that
+// can be enqueued are enqueued even if others cannot; in that case an *EnqueueManyError is returned with the error for each Operation.
+func (r *batcher) EnqueueMany(ops []Operation) error {
+
+	// validate
+	if err := r.checkStarted(); err != nil {
+		for _, op := range ops {
+			r.emitEnqueueError(op, err)
+		}
+		return err
+	}
+	errs := make([]error, len(ops))
+	valid := make([]Operation, 0, len(ops))
+	index := make([]int, 0, len(ops))
+	failed := false
+	for i, op := range ops {
+		if fragments := r.split(op); fragments != nil {
+			if err := r.enqueueFragments(context.Background(), fragments); err != nil {
+				errs[i] = err
+				failed = true
+			}
+			continue
+		}
+		if err := r.validate(op); err != nil {
+			errs[i] = err
+			failed = true
+			continue
+		}
+		markEnqueued(op, r.clock.Now())
+		if r.store != nil {
+			if err := r.store.save(op); err != nil {
+				errs[i] = err
+				failed = true
+				continue
+			}
+		}
+		valid = append(valid, op)
+		index = append(index, i)
+	}
+
+	// increment the target
+	for _, op := range valid {
+		r.incTarget(op.Watcher(), 1, op)
+	}
+
+	// put into the buffer; the target is restored for any operation that could not be added
+	bufferErrs := r.buffer.enqueueMany(valid, r.loadErrorOnFullBuffer())
+	for i, op := range valid {
+		if bufferErrs != nil {
+			if coalesced, ok := bufferErrs[i].(*coalescedError); ok {
+				r.incTarget(op.Watcher(), -1, op)
+				r.onCoalesced(op, coalesced)
+				continue
+			}
+			if dropped, ok := bufferErrs[i].(*droppedError); ok {
+				r.incTarget(op.Watcher(), -1, op)
+				r.onDropped(op, dropped)
+				continue
+			}
+		}
+		if bufferErrs != nil && bufferErrs[i] != nil {
+			errs[index[i]] = bufferErrs[i]
+			failed = true
+			r.incTarget(op.Watcher(), -1, op)
+			if r.store != nil {
+				r.store.forget(op)
+			}
+			continue
+		}
+		atomic.AddUint64(&r.enqueued, 1)
+		r.emitOperation(OperationEnqueuedEvent, int(op.Cost()), "", op)
+	}
+	r.checkWatermarks()
+
+	if failed {
+		for i, err := range errs {
+			if err != nil {
+				r.emitEnqueueError(ops[i], err)
+			}
+		}
+		return &EnqueueManyError{Errors: errs}
+	}
+	return nil
+}
+
+// This moves the target from the buffered Operation to the Operation that replaced it and raises the coalesced event for the Operation
+// that was merged into it.
+func (r *batcher) onCoalesced(op Operation, coalesced *coalescedError) {
+	r.incTarget(coalesced.buffered.Watcher(), -1, coalesced.buffered)
+	r.incTarget(coalesced.merged.Watcher(), 1, coalesced.merged)
+	atomic.AddUint64(&r.coalesced, 1)
+	r.Emit(CoalescedEvent, int(op.Cost()), "", op)
+}
+
+// This completes the Operation that was dropped because the buffer was full and raises the dropped event for it. If that was not the
+// Operation being enqueued, the Operation being enqueued was added in its place and the target is moved to it.
+func (r *batcher) onDropped(op Operation, dropped *droppedError) {
+	if dropped.dropped != op {
+		r.incTarget(op.Watcher(), 1, op)
+		r.incTarget(dropped.dropped.Watcher(), -1, dropped.dropped)
+		atomic.AddUint64(&r.enqueued, 1)
+		r.emitOperation(OperationEnqueuedEvent, int(op.Cost()), "", op)
+	}
+	dropped.dropped.Complete(Result{Status: ResultDropped, Err: OperationDroppedError, Attempt: dropped.dropped.Attempt()}, true)
+	atomic.AddUint64(&r.dropped, 1)
+	r.Emit(DroppedEvent, int(dropped.dropped.Cost()), "", dropped.dropped)
+}
+
+// This replays the Operations in the overflow into the buffer. Any whose payload could not be read back are failed.
+func (r *batcher) replayOverflow() {
+	for op, err := range r.buffer.replay() {
+		r.incTarget(op.Watcher(), -1, op)
+		op.Complete(Result{Status: ResultFailed, Err: err, Attempt: op.Attempt()}, true)
+		r.Emit(ErrorEvent, 0, err.Error(), err)
+	}
+}
+
+// This raises the high-watermark event if the estimated memory of the buffer has risen to the high watermark and the low-watermark event
+// if it has since fallen to the low watermark.
+func (r *batcher) checkWatermarks() {
+	if r.highWatermark == 0 {
+		return
+	}
+	memory := r.buffer.estimatedMemory()
+	switch {
+	case memory >= r.highWatermark && atomic.CompareAndSwapInt32(&r.aboveWatermark, 0, 1):
+		r.Emit(HighWatermarkEvent, int(memory), "", nil)
+	case memory <= r.lowWatermark && atomic.CompareAndSwapInt32(&r.aboveWatermark, 1, 0):
+		r.Emit(LowWatermarkEvent, int(memory), "", nil)
+	}
+}
+
+// This raises the enqueue-error event for an Operation that could not be enqueued.
+func (r *batcher) emitEnqueueError(op Operation, err error) {
+	var cost int
+	if op != nil {
+		cost = int(op.Cost())
+	}
+	r.Emit(EnqueueErrorEvent, cost, err.Error(), err)
+}
+
+// This returns NotStartedError if Start() has not been called and WithRequireStarted is set. Otherwise, it raises the pre-start-enqueue
+// event the first time an Operation is enqueued before Start().
+func (r *batcher) checkStarted() error {
+	r.phaseMutex.Lock()
+	phase := r.phase
+	r.phaseMutex.Unlock()
+	switch {
+	case phase != phaseUninitialized:
+		return nil
+	case r.requireStarted:
+		return NotStartedError
+	default:
+		r.preStartOnce.Do(func() {
+			r.Emit(PreStartEnqueueEvent, 0, "", nil)
+		})
+		return nil
+	}
+}
+
+// This ensures the Operation can be enqueued.
+// Operations that cost more than the rate limiter's MaxCapacity are split (if they support it) rather than rejected. This returns nil
+// if the Operation does not need to be split or cannot be.
+func (r *batcher) split(op Operation) []Operation {
+	if op == nil {
+		return nil
+	}
+	rl := r.limiterFor(op.Watcher())
+	if rl == nil {
+		return nil
+	}
+	if fitsMaxCapacity(rl, op) {
+		return nil
+	}
+	return op.Split(rl.MaxCapacity())
+}
+
+// This enqueues the fragments of a split Operation. If any fragment is still too expensive, none are enqueued. If a fragment cannot be
+// enqueued, it and the fragments after it are failed so that the split Operation is still completed.
+func (r *batcher) enqueueFragments(ctx context.Context, fragments []Operation) error {
+	for _, fragment := range fragments {
+		if fragment == nil {
+			return NoOperationError
+		}
+		if rl := r.limiterFor(fragment.Watcher()); rl != nil && !fitsMaxCapacity(rl, fragment) {
+			return TooExpensiveError
+		}
+	}
+	for i, fragment := range fragments {
+		if err := r.enqueue(ctx, fragment); err != nil {
+			for _, remaining := range fragments[i:] {
+				remaining.Complete(Failed(err), true)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *batcher) validate(op Operation) error {
+
+	// ensure an operation was provided
+	if op == nil {
+		return NoOperationError
+	}
+
+	// ensure there is a watcher associated with the call
+	watcher := op.Watcher()
+	if op.Watcher() == nil {
+		return NoWatcherError
+	}
+
+	// ensure the cost doesn't exceed max capacity
+	if rl := r.limiterFor(watcher); rl != nil && !fitsMaxCapacity(rl, op) {
+		return TooExpensiveError
+	}
+
+	// ensure there are not too many attempts
+	maxAttempts := watcher.MaxAttempts()
+	if maxAttempts > 0 && op.Attempt() >= maxAttempts {
+		return TooManyAttemptsError
+	}
+
+	// ensure the operation has not already expired; the processing loop only looks for expired operations once there might be some
+	if expiry := op.Expiry(); !expiry.IsZero() {
+		if !r.clock.Now().Before(expiry) {
+			return OperationExpiredError
+		}
+		atomic.StoreInt32(&r.expiring, 1)
+	}
+
+	// while Shutdown() is draining, only Operations that are being attempted again are accepted
+	if op.Attempt() == 0 && r.isDraining() {
+		return BufferIsShutdown
+	}
+
+	// the processing loop only looks for batches to hold back once there might be some
+	if watcher.MinBatchSize() > 0 {
+		atomic.StoreInt32(&r.lingering, 1)
+	}
+
+	// the processing loop only divides the capacity among the watchers once one has a weight
+	if watcher.Weight() > 0 {
+		atomic.StoreInt32(&r.weighted, 1)
+	}
+
+	return nil
+}
+
+// Call this method when your datastore is throwing transient errors. This pauses the processing loop for the PauseTime to ensure that you
+// are not flooding the datastore with additional data it cannot process making the situation worse.
+func (r *batcher) Pause() {
+	r.PauseFor(r.pauseTime)
+}
+
+// This is the same as Pause() except that the processing loop is paused for the provided duration instead of the PauseTime. If the
+// duration is 0 or less, the processing loop is paused until Resume() is called, which allows an operator to hold processing during an
+// incident. Calling this while already paused has no effect.
+func (r *batcher) PauseFor(val time.Duration) {
+
+	// ensure pausing only happens when it is running
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.phase != phaseStarted {
+		// simply ignore an invalid pause
+		return
+	}
+
+	// pause
+	select {
+	case r.pause <- val:
+		// successfully set the pause
+	default:
+		// pause was already set
+	}
+
+	// switch to paused phase
+	r.phase = phasePaused
+
+}
+
+// Call this method to end a pause immediately rather than waiting for it to expire. This is the only way to end a pause of indefinite
+// duration (see PauseFor()). Calling this when not paused has no effect.
+func (r *batcher) Resume() {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.phase == phasePaused {
+		r.endPause()
+	}
+}
+
+// This asks the processing loop to end the current pause early. The phaseMutex must be held.
+func (r *batcher) endPause() {
+	select {
+	case r.resumeNow <- struct{}{}:
+		// successfully set the resume
+	default:
+		// resume was already set
+	}
+}
+
+func (r *batcher) resume() {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.phase == phasePaused {
+		r.phase = phaseStarted
+	}
+
+	// discard a request to end this pause early that arrived after it ended
+	select {
+	case <-r.resumeNow:
+	default:
+	}
+}
+
+// This method stops batches from being raised to the Watcher for the provided duration without affecting other Watchers (for instance,
+// while the datastore behind it is being deployed). Its Operations stay in the buffer and keep their capacity target. If the duration
+// is 0 or less, the Watcher is paused until ResumeWatcher() is called. Calling this while the Watcher is paused replaces the duration.
+// A pause event is raised with the Watcher as the metadata; a resume event is raised when the pause is over. This has no effect once
+// Shutdown() has been called.
+func (r *batcher) PauseWatcher(watcher Watcher, val time.Duration) {
+	if watcher == nil {
+		return
+	}
+	var until time.Time
+	if val > 0 {
+		until = r.clock.Now().Add(val)
+	} else {
+		val = 0
+	}
+
+	// the phase is checked while holding the lock so the pause cannot be added after Shutdown() resumed every Watcher
+	r.watcherPauseMutex.Lock()
+	if r.isDraining() {
+		r.watcherPauseMutex.Unlock()
+		return
+	}
+	r.watcherPauses[watcher] = until
+	r.watcherPauseMutex.Unlock()
+	r.Emit(PauseEvent, int(val.Milliseconds()), "", watcher)
+}
+
+// Call this method to end a pause of the Watcher (see PauseWatcher()) immediately. Calling this when the Watcher is not paused has no
+// effect.
+func (r *batcher) ResumeWatcher(watcher Watcher) {
+	r.watcherPauseMutex.Lock()
+	_, paused := r.watcherPauses[watcher]
+	delete(r.watcherPauses, watcher)
+	r.watcherPauseMutex.Unlock()
+	if paused {
+		r.Emit(ResumeEvent, 0, "", watcher)
+		r.FlushWatcher(watcher)
+	}
+}
+
+// This ends the pause of every Watcher so its Operations can be drained by Shutdown(). A resume event is raised for each.
+func (r *batcher) resumeAllWatchers() {
+	r.watcherPauseMutex.Lock()
+	resumed := make([]Watcher, 0, len(r.watcherPauses))
+	for watcher := range r.watcherPauses {
+		resumed = append(resumed, watcher)
+	}
+	r.watcherPauses = make(map[Watcher]time.Time)
+	r.watcherPauseMutex.Unlock()
+	for _, watcher := range resumed {
+		r.Emit(ResumeEvent, 0, "", watcher)
+	}
+}
+
+// This returns the Watchers that are paused (or nil if there are none) so a flush can skip their Operations. A resume event is raised
+// for each pause that has expired.
+func (r *batcher) pausedWatchers() map[Watcher]bool {
+	r.watcherPauseMutex.Lock()
+	var paused map[Watcher]bool
+	var resumed []Watcher
+	now := r.clock.Now()
+	for watcher, until := range r.watcherPauses {
+		if until.IsZero() || now.Before(until) {
+			if paused == nil {
+				paused = make(map[Watcher]bool)
+			}
+			paused[watcher] = true
+		} else {
+			delete(r.watcherPauses, watcher)
+			resumed = append(resumed, watcher)
+		}
+	}
+	r.watcherPauseMutex.Unlock()
+	for _, watcher := range resumed {
+		r.Emit(ResumeEvent, 0, "", watcher)
+	}
+	return paused
+}
+
+// Call this method to manually flush as if the flushInterval were triggered.
+func (r *batcher) Flush() {
+	r.flushRequestMutex.Lock()
+	r.flushEverything = true
+	r.flushRequestMutex.Unlock()
+	r.requestFlush()
+}
+
+// This method flushes only the Operations for the Watcher (for instance, to drain it before a downstream deployment) so the capacity
+// of the flush is not spent on other Watchers. Its Operations are not held back for MinBatchSize, but the flush is still limited by
+// the capacity of the rate limiter and nothing is raised to a Watcher that is paused or cooling down. If a flush of every Watcher is
+// also pending, this has no additional effect.
+func (r *batcher) FlushWatcher(watcher Watcher) {
+	if watcher == nil {
+		return
+	}
+	r.flushRequestMutex.Lock()
+	r.flushWatchers[watcher] = true
+	r.flushRequestMutex.Unlock()
+	r.requestFlush()
+}
+
+// This asks the processing loop to flush.
+func (r *batcher) requestFlush() {
+	select {
+	case r.flush <- struct{}{}:
+		// successfully set the flush
+	default:
+		// flush was already set
+	}
+}
+
+// This returns the Watchers to include in the flush that is starting, or nil if it includes every Watcher.
+func (r *batcher) takeFlushRequest() map[Watcher]bool {
+	r.flushRequestMutex.Lock()
+	defer r.flushRequestMutex.Unlock()
+	only := r.flushWatchers
+	r.flushWatchers = make(map[Watcher]bool)
+	if r.flushEverything || len(only) == 0 {
+		r.flushEverything = false
+		return nil
+	}
+	return only
+}
+
+// This tells you how many operations are still in the buffer. This does not include operations that have been sent back to the Watcher as part
+// of a batch for processing.
+func (r *batcher) OperationsInBuffer() uint32 {
+	return r.buffer.size()
+}
+
+// This tells you how much capacity the Batcher believes it needs to process everything outstanding. Outstanding operations include those in
+// the buffer and operations and any that have been sent as a batch but not marked done yet.
+func (r *batcher) NeedsCapacity() uint32 {
+	return atomic.LoadUint32(&r.target)
+}
+
+// When the real cost of an Operation is known (for instance, the request charge returned by Cosmos), you may report it with this method.
+// Batcher keeps a moving average of how much Operations actually cost compared to their estimated cost (see Operation.WithCostFunc())
+// and scales the capacity it requests of the rate limiter, and the capacity it consumes when dispatching, by that ratio. This corrects
+// estimates that are consistently too high or too low. The cost of a single report is limited to between a tenth and ten times the
+// estimate so that one outlier cannot swing the average.
+func (r *batcher) ReportActualCost(op Operation, actual uint32) {
+	if op == nil || op.Cost() == 0 {
+		return
+	}
+	sample := math.Min(math.Max(float64(actual)/float64(op.Cost()), minCostScale), maxCostScale)
+	for {
+		bits := atomic.LoadUint64(&r.costScale)
+		current := 1.0
+		if bits != 0 {
+			current = math.Float64frombits(bits)
+		}
+		next := current + costScaleWeight*(sample-current)
+		if atomic.CompareAndSwapUint64(&r.costScale, bits, math.Float64bits(next)) {
+			return
+		}
+	}
+}
+
+func (r *batcher) loadCostScale() float64 {
+	if bits := atomic.LoadUint64(&r.costScale); bits != 0 {
+		return math.Float64frombits(bits)
+	}
+	return 1
+}
+
+// This returns the cost multiplied by the scale (rounded up).
+func scaleCost(cost uint32, scale float64) uint32 {
+	if scale == 1 {
+		return cost
+	}
+	return uint32(math.Min(math.Ceil(float64(cost)*scale), math.MaxUint32))
+}
+
+func (r *batcher) confirmTargetIsZero() bool {
+	reset := func(_, value interface{}) bool {
+		atomic.StoreUint32(value.(*uint32), 0)
+		return true
+	}
+	r.limiterTargets.Range(reset)
+	r.dimensionTargets.Range(reset)
+	return atomic.SwapUint32(&r.target, 0) == 0
+}
+
+// This changes the target by val. If the Watcher has its own rate limiter, the portion of the target for that rate limiter is changed
+// as well.
+func (r *batcher) incTarget(watcher Watcher, sign int, ops ...Operation) {
+	var val int
+	for _, op := range ops {
+		val += sign * int(op.Cost())
+	}
+	if val == 0 {
+		return
+	}
+	addToTarget(&r.target, val)
+	if watcher != nil {
+		if rl := watcher.RateLimiter(); rl != nil && rl != r.ratelimiter {
+			target, _ := r.limiterTargets.LoadOrStore(rl, new(uint32))
+			addToTarget(target.(*uint32), val)
+		}
+	}
+
+	// a DimensionalRateLimiter needs the capacity in its own dimension
+	rl := r.limiterFor(watcher)
+	if rl == nil {
+		return
+	}
+	for _, part := range limiterParts(rl) {
+		if d, ok := part.(DimensionalRateLimiter); ok {
+			var cost int
+			for _, op := range ops {
+				cost += sign * int(op.CostOf(d.Dimension()))
+			}
+			target, _ := r.dimensionTargets.LoadOrStore(part, new(uint32))
+			addToTarget(target.(*uint32), cost)
+		}
+	}
+}
+
+// This adds val to the target without letting it go below 0.
+func addToTarget(target *uint32, val int) {
+	for {
+		current := atomic.LoadUint32(target)
+		next := current + uint32(val)
+		if val < 0 && current < uint32(-val) {
+			next = 0
+		}
+		if atomic.CompareAndSwapUint32(target, current, next) {
+			return
+		}
+	}
+}
+
+// Every batch holds a slot (even if there is no MaxConcurrentBatches) so that the limit is accurate if it is set after Start().
+func (r *batcher) tryReserveBatchSlot() bool {
+	for {
+		current := atomic.LoadInt32(&r.inflight)
+		if max := atomic.LoadUint32(&r.maxConcurrentBatches); max > 0 && uint32(current) >= max {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&r.inflight, current, current+1) {
+			return true
+		}
+	}
+}
+
+// The slot may have already been reclaimed by the audit, so inflight never goes below 0.
+func (r *batcher) releaseBatchSlot() {
+	for {
+		current := atomic.LoadInt32(&r.inflight)
+		if current <= 0 {
+			return
+		}
+		if atomic.CompareAndSwapInt32(&r.inflight, current, current-1) {
+			return
+		}
+	}
+}
+
+// This releases the slot (and the place reserved in the worker queue) of a batch that was started but could not be dispatched.
+func (r *batcher) abandonBatchSlot() {
+	r.releaseBatchSlot()
+	if r.workers != nil {
+		r.workers.unreserve()
+	}
+}
+
+func (r *batcher) confirmInflightIsZero() bool {
+	inflight := atomic.SwapInt32(&r.inflight, 0)
+	return inflight == 0 || atomic.LoadUint32(&r.maxConcurrentBatches) == 0
+}
+
+// This tells you how many batches are holding one of the slots provided by WithMaxConcurrentBatches(). It is always 0 if that option is
+// not set.
+func (r *batcher) Inflight() uint32 {
+	if atomic.LoadUint32(&r.maxConcurrentBatches) == 0 {
+		return 0
+	}
+	return uint32(atomic.LoadInt32(&r.inflight))
+}
+
+// This returns a point-in-time snapshot of the Batcher: OperationsInBuffer(), NeedsCapacity() (the current target), Inflight(), the
+// number of batches the Watchers have not finished with, cumulative counts since the Batcher was created, the average and 95th
+// percentile size of the recent batches, how long the last flush took, and the time since Start(). None of these take a lock, so they
+// are safe to call as often as you like (for instance, from a metrics scraper or an autoscaler) without slowing down Enqueue() or the
+// processing loop. Since each is read independently, they may not be consistent with each other.
+func (r *batcher) Stats() BatcherStats {
+	averageBatchSize, p95BatchSize := r.recentSizes.stats()
+	var uptime time.Duration
+	if started := atomic.LoadInt64(&r.startedAt); started > 0 {
+		uptime = r.clock.Now().Sub(time.Unix(0, started))
+	}
+	return BatcherStats{
+		OperationsInBuffer: r.buffer.size(),
+		Overflowed:         r.buffer.overflowed(),
+		EstimatedMemory:    r.buffer.estimatedMemory(),
+		NeedsCapacity:      atomic.LoadUint32(&r.target),
+		Inflight:           r.Inflight(),
+		Running:            uint32(atomic.LoadInt32(&r.running)),
+		Enqueued:           atomic.LoadUint64(&r.enqueued),
+		Batches:            atomic.LoadUint64(&r.batches),
+		Operations:         atomic.LoadUint64(&r.processed),
+		Failed:             atomic.LoadUint64(&r.failed),
+		AuditFailures:      atomic.LoadUint64(&r.auditFailures),
+		Expired:            atomic.LoadUint64(&r.expired),
+		Cancelled:          atomic.LoadUint64(&r.cancelled),
+		Purged:             atomic.LoadUint64(&r.purged),
+		Coalesced:          atomic.LoadUint64(&r.coalesced),
+		Dropped:            atomic.LoadUint64(&r.dropped),
+		AverageBatchSize:   averageBatchSize,
+		P95BatchSize:       p95BatchSize,
+		FlushLatency:       time.Duration(atomic.LoadInt64(&r.flushLatency)),
+		Uptime:             uptime,
+	}
+}
+
+// This returns nil if the Batcher is healthy, which is suitable for a liveness probe (see ProbeHandler()). It returns NotRunningError
+// unless the processing loop is running (a paused or draining Batcher is still running) and AuditFailingError if 3 audits in a row
+// have failed (skipped audits do not count), which means batches keep leaking the target or inflight count.
+func (r *batcher) Healthy() error {
+	r.phaseMutex.Lock()
+	phase := r.phase
+	r.phaseMutex.Unlock()
+	if phase == phaseUninitialized || phase == phaseStopped {
+		return NotRunningError
+	}
+	if atomic.LoadUint32(&r.consecutiveAuditFailures) >= unhealthyAuditFailures {
+		return AuditFailingError
+	}
+	return nil
+}
+
+// Each batch reserves its cost from the rate limiter for the flush interval it was dispatched in. This ensures that another consumer
+// of the same rate limiter cannot spend the same capacity. The reservations are released when the next flush starts.
+func (r *batcher) reserveCapacity(watcher Watcher, batch []Operation) (ReservationHandle, bool) {
+	rl := r.limiterFor(watcher)
+	if rl == nil {
+		return nil, true
+	}
+	reservation, err := reserveFor(rl, batch, r.loadFlushInterval(), r.loadCostScale())
+	if err != nil {
+		return nil, false
+	}
+	r.reservations = append(r.reservations, reservation)
+	return reservation, true
+}
+
+// This is called by Batch.RequeueAll() to raise the same batch again after the delay.
+func (r *batcher) schedule(watcher Watcher, batch []Operation, delay time.Duration) {
+	r.incTarget(watcher, 1, batch...)
+	r.scheduledMutex.Lock()
+	defer r.scheduledMutex.Unlock()
+	r.scheduled = append(r.scheduled, scheduledBatch{watcher: watcher, ops: batch, due: r.clock.Now().Add(delay)})
+}
+
+func (r *batcher) scheduledSize() int {
+	r.scheduledMutex.Lock()
+	defer r.scheduledMutex.Unlock()
+	return len(r.scheduled)
+}
+
+func (r *batcher) releaseReservations() {
+	for _, reservation := range r.reservations {
+		reservation.Release()
+	}
+	r.reservations = r.reservations[:0]
+}
 
-		// increment an attempt
+// The slice of a pooled batch came from newBatch() and is released once the batch is done with it.
+func (r *batcher) processBatch(watcher Watcher, batch []Operation, pooled bool) {
+	if len(batch) == 0 {
+		return
+	}
+
+	// reserve the capacity; if another consumer of the rate limiter spent it first, put the batch back for the next flush
+	if !r.dispatchBatch(watcher, batch, pooled) {
+		r.buffer.requeue(batch)
+		r.abandonBatchSlot()
+		if r.ordered != nil {
+			r.ordered.block(batch...)
+		}
+		if pooled {
+			r.releaseBatch(batch)
+		}
+	}
+}
+
+// This returns an empty slice to hold the Operations of a batch, which is one that was released if WithBatchPooling() was used.
+func (r *batcher) newBatch() []Operation {
+	if r.poolBatches {
+		if pooled, ok := r.batchPool.Get().(*[]Operation); ok {
+			return (*pooled)[:0]
+		}
+	}
+	return nil
+}
+
+// This makes the slice of a batch available to newBatch() once nothing refers to it. The Operations are cleared so that the slice does
+// not keep them from being garbage collected. Since the slice is the metadata of the batch event with WithEmitBatchOperations(), it is
+// never reused in that case.
+func (r *batcher) releaseBatch(ops []Operation) {
+	if !r.poolBatches || r.emitBatchOperations || cap(ops) == 0 {
+		return
+	}
+	for i := range ops {
+		ops[i] = nil
+	}
+	ops = ops[:0]
+	r.batchPool.Put(&ops)
+}
+
+// This leaves the Operation in the buffer and returns the next Operation. With WithOrderedKeys(), the Operations after it with the
+// same key are also left in the buffer by the current flush.
+func (r *batcher) skip(op Operation) Operation {
+	if r.ordered != nil {
+		r.ordered.block(op)
+	}
+	return r.buffer.skip()
+}
+
+// This raises the batches returned by the Watcher's Splitter. The first batch uses the slot reserved when the Operations were collected.
+// Any batch that cannot start (and any Operation the Splitter did not return) is put back in the buffer. This returns the Operations
+// that were put back.
+func (r *batcher) processSplit(watcher Watcher, ops []Operation, stats *FlushStats, tryStartBatch func() bool) []Operation {
+	returned := make(map[Operation]bool, len(ops))
+	var requeue []Operation
+	first := true
+	for _, batch := range watcher.Splitter()(ops) {
+		if len(batch) == 0 {
+			continue
+		}
 		for _, op := range batch {
-			op.MakeAttempt()
+			returned[op] = true
+		}
+		if r.ordered != nil && r.ordered.anyMustWait(batch) {
+			r.ordered.block(batch...)
+			requeue = append(requeue, batch...)
+			continue
+		}
+		if !first && !tryStartBatch() {
+			requeue = append(requeue, batch...)
+			continue
+		}
+		first = false
+		for _, op := range batch {
+			r.countDispatched(op, stats)
+		}
+		r.processBatch(watcher, batch, false)
+	}
+	if first {
+		r.abandonBatchSlot()
+	}
+	for _, op := range ops {
+		if !returned[op] {
+			requeue = append(requeue, op)
 		}
+	}
+	r.buffer.requeue(requeue)
+	return requeue
+}
 
-		// process the batch
-		waitForDone := make(chan struct{})
-		go func() {
-			defer close(waitForDone)
-			watcher.ProcessBatch(batch)
-		}()
+func (r *batcher) dispatchBatch(watcher Watcher, ops []Operation, pooled bool) bool {
+	reservation, ok := r.reserveCapacity(watcher, ops)
+	if !ok {
+		return false
+	}
+	batch := &batch{batcher: r, watcher: watcher, ops: ops, reservation: reservation}
+	var keys []batchKey
+	if r.ordered != nil {
+		keys = r.ordered.acquire(ops)
+	}
+
+	r.lastFlushWithRecords = r.clock.Now()
+	atomic.AddUint64(&r.batches, 1)
+	r.recentSizes.add(len(ops))
+
+	// raise event
+	switch {
+	case r.emitBatchOperations:
+		r.Emit(BatchEvent, len(ops), "", ops)
+	case r.emitBatch:
+		r.Emit(BatchEvent, len(ops), "", describeBatch(watcher, ops))
+	}
+	for _, op := range ops {
+		r.emitOperation(OperationBatchedEvent, len(ops), "", op)
+	}
+
+	atomic.AddInt32(&r.running, 1)
+	process := func(spawn func(fn func())) {
+		defer atomic.AddInt32(&r.running, -1)
+		spanCtx, span := r.startBatchSpan(watcher, ops)
+
+		// increment an attempt
+		for _, op := range ops {
+			op.MakeAttempt()
+		}
 
 		// the batch is "done" when the ProcessBatch func() finishes or the maxOperationTime is exceeded
 		maxOperationTime := r.maxOperationTime
 		if watcher.MaxOperationTime() > 0 {
 			maxOperationTime = watcher.MaxOperationTime()
 		}
+
+		// process the batch; a panic in the watcher is recovered so it can be mapped to a failure of each operation
+		started := r.clock.Now()
+		waitForDone := make(chan struct{})
+		var panicked error
+		var failed bool
+		var goroutine uint64
+		spawn(func() {
+			defer close(waitForDone)
+			if r.captureStacks {
+				atomic.StoreUint64(&goroutine, currentGoroutineID())
+			}
+			defer func() {
+				if p := recover(); p != nil {
+					panicked = fmt.Errorf("%w: %v", WatcherPanicError, p)
+					r.Emit(PanicEvent, len(ops), panicked.Error(), p)
+				}
+			}()
+			for _, op := range ops {
+				op.Payload() // produce any deferred payloads before the watcher sees the batch
+			}
+			switch w := watcher.(type) {
+			case ContextWatcher:
+				ctx, cancel := context.WithTimeout(spanCtx, maxOperationTime)
+				defer cancel()
+				if err := w.ProcessBatchWithContext(ctx, batch); err != nil {
+					r.failBatch(ops, err)
+					failed = true
+				}
+			case BatchWatcher:
+				w.ProcessWholeBatch(batch)
+			default:
+				watcher.ProcessBatch(ops)
+			}
+		})
+
+		completed := true
+		var err error
 		select {
 		case <-waitForDone:
-		case <-time.After(maxOperationTime):
+			err = panicked
+		case <-r.clock.After(maxOperationTime):
+			completed = false
+			var stack string
+			if r.captureStacks {
+				stack = goroutineStack(atomic.LoadUint64(&goroutine))
+			}
+			r.Emit(TimeoutEvent, len(ops), stack, ops)
 		}
 
-		// decrement target
-		var total int = 0
-		for _, op := range batch {
-			total += int(op.Cost())
+		// record the results unless the batch was requeued
+		duration := r.clock.Now().Sub(started)
+		var failures int
+		requeued := batch.finish()
+		if !requeued {
+			failures = r.completeBatch(watcher, ops, completed, err, duration)
+			atomic.AddUint64(&r.processed, uint64(len(ops)))
+			atomic.AddUint64(&r.failed, uint64(failures))
+		}
+		endBatchSpan(span, completed, requeued, err, failures)
+		if r.summary != nil {
+			r.summary.batchDone(len(ops), failures, duration)
+		}
+		if failures > 0 {
+			r.startCooldown(watcher)
 		}
-		r.incTarget(-total)
+
+		// operations with the same keys may be raised once the watcher has returned, even if the batch timed out
+		if len(keys) > 0 {
+			if completed {
+				r.ordered.release(keys)
+			} else {
+				go func() {
+					<-waitForDone
+					r.ordered.release(keys)
+				}()
+			}
+		}
+
+		// decrement target
+		r.incTarget(watcher, -1, ops...)
 
 		// remove from inflight
 		r.releaseBatchSlot()
 
-	}()
+		// the slice can only be reused if the watcher has returned and nothing else was given it
+		if pooled && completed && !requeued && !failed {
+			r.releaseBatch(ops)
+		}
+
+	}
+	if r.workers != nil {
+		r.workers.submit(process)
+	} else {
+		go process(func(fn func()) {
+			go fn()
+		})
+	}
+
+	return true
+}
+
+// This method calls fn for each Operation waiting in the buffer (from the head), followed by any in the overflow (see WithOverflowFile),
+// until fn returns false, so you can see what is pending (for instance, the count for each Watcher, the oldest EnqueuedAt(), or the
+// distribution of costs) without draining the buffer. fn is called with a snapshot taken when this is called, so it may call back into
+// the Batcher, but the Operations may be dispatched while it runs. The Operations are the ones that were enqueued, so fn must not modify
+// them.
+func (r *batcher) InspectBuffer(fn func(op Operation) bool) {
+	for _, op := range r.buffer.snapshot() {
+		if !fn(op) {
+			return
+		}
+	}
+}
+
+// This method removes an Operation that is still waiting in the buffer (for instance, because the client that requested it has
+// disconnected) so that it does not consume capacity. It returns true if the Operation was cancelled in time, in which case it is
+// completed with a Result whose Status is ResultCancelled and whose Err is OperationCancelledError (so the completion callback is called)
+// and a cancelled event is raised. It returns false if the Operation is not in the buffer, which includes when it has already been
+// dispatched (or is being considered by a flush at that moment). This checks each Operation in the buffer, so it is much slower than
+// Enqueue().
+func (r *batcher) Cancel(op Operation) bool {
+	if op == nil || !r.buffer.cancel(op) {
+		return false
+	}
+	r.incTarget(op.Watcher(), -1, op)
+	op.Complete(Result{Status: ResultCancelled, Err: OperationCancelledError, Attempt: op.Attempt()}, true)
+	atomic.AddUint64(&r.cancelled, 1)
+	r.Emit(CancelledEvent, int(op.Cost()), "", op)
+	return true
+}
+
+// This method removes every Operation waiting in the buffer (or the overflow) for which predicate returns true (for instance, every
+// Operation for a tenant that was just deleted) and returns how many were removed. Each one is completed with a Result whose Status is
+// ResultPurged and whose Err is OperationPurgedError (so the completion callback is called) and a purged event is raised for it. Like
+// Cancel(), Operations that have been dispatched (or are being considered by a flush at that moment) are not removed. predicate is
+// called with a snapshot of the buffer, so it may call back into the Batcher, but it must not modify the Operations.
+func (r *batcher) Purge(predicate func(op Operation) bool) int {
+	matched := make(map[Operation]bool)
+	for _, op := range r.buffer.snapshot() {
+		if predicate(op) {
+			matched[op] = true
+		}
+	}
+	if len(matched) == 0 {
+		return 0
+	}
+	removed := r.buffer.purge(matched)
+	for _, op := range removed {
+		r.incTarget(op.Watcher(), -1, op)
+		op.Complete(Result{Status: ResultPurged, Err: OperationPurgedError, Attempt: op.Attempt()}, true)
+		atomic.AddUint64(&r.purged, 1)
+		r.Emit(PurgedEvent, int(op.Cost()), "", op)
+	}
+	r.checkWatermarks()
+	return len(removed)
+}
+
+// This removes every Operation that has expired from the buffer, completes it with ResultExpired, and returns how many there were. It
+// must only be called by the processing loop since it moves the buffer cursor.
+func (r *batcher) removeExpired() int {
+	var count int
+	now := r.clock.Now()
+	op := r.buffer.top()
+	for op != nil {
+		expiry := op.Expiry()
+		if expiry.IsZero() || now.Before(expiry) {
+			op = r.buffer.skip()
+			continue
+		}
+		expired := op
+		op = r.buffer.remove()
+		r.incTarget(expired.Watcher(), -1, expired)
+		expired.Complete(Result{Status: ResultExpired, Err: OperationExpiredError, Attempt: expired.Attempt()}, true)
+		atomic.AddUint64(&r.expired, 1)
+		r.Emit(ExpiredEvent, int(now.Sub(expiry).Milliseconds()), "", expired)
+		count++
+	}
+	return count
+}
+
+// This fails every Operation in the batch that does not already have a Result with the error returned by the Watcher.
+func (r *batcher) failBatch(ops []Operation, err error) {
+	r.Emit(BatchFailedEvent, len(ops), err.Error(), ops)
+	for _, op := range ops {
+		if op.Result().Status == ResultPending {
+			op.SetResult(Failed(err))
+		}
+	}
+}
+
+// This returns the batches that should not be raised yet because they have fewer than MinBatchSize Operations and have not lingered for
+// MaxLinger. Nothing is held back while Shutdown() is draining the buffer.
+func (r *batcher) holdForMinBatchSize() map[batchKey]bool {
+	if atomic.LoadInt32(&r.lingering) == 0 {
+		return nil
+	}
+	counts := make(map[batchKey]uint32)
+	r.buffer.each(func(op Operation) {
+		watcher := op.Watcher()
+		if !op.IsBatchable() || watcher.MinBatchSize() == 0 {
+			return
+		}
+		key := batchKey{watcher: watcher}
+		if watcher.GroupByKey() {
+			key.key = op.Key()
+		}
+		counts[key]++
+	})
+	draining := r.isDraining()
+	held := make(map[batchKey]bool)
+	for key, count := range counts {
+		since, ok := r.lingerSince[key]
+		if !ok {
+			since = r.clock.Now()
+		}
+		linger := key.watcher.MaxLinger()
+		if draining || count >= key.watcher.MinBatchSize() || (linger > 0 && r.clock.Now().Sub(since) >= linger) {
+			delete(r.lingerSince, key)
+			continue
+		}
+		r.lingerSince[key] = since
+		held[key] = true
+	}
+	for key := range r.lingerSince {
+		if _, ok := counts[key]; !ok {
+			delete(r.lingerSince, key)
+		}
+	}
+	return held
+}
+
+// This holds back further batches to the Watcher for its Cooldown (if it has one) after a batch failed.
+func (r *batcher) startCooldown(watcher Watcher) {
+	cooldown := watcher.Cooldown()
+	if cooldown <= 0 {
+		return
+	}
+	r.cooldownMutex.Lock()
+	r.cooldowns[watcher] = r.clock.Now().Add(cooldown)
+	r.cooldownMutex.Unlock()
+	r.Emit(CooldownEvent, int(cooldown.Milliseconds()), "", watcher)
+}
+
+// This returns the Watchers that are still cooling down (or nil if there are none) so a flush can skip their Operations.
+func (r *batcher) coolingDown() map[Watcher]bool {
+	r.cooldownMutex.Lock()
+	defer r.cooldownMutex.Unlock()
+	var cooling map[Watcher]bool
+	now := r.clock.Now()
+	for watcher, until := range r.cooldowns {
+		if now.Before(until) {
+			if cooling == nil {
+				cooling = make(map[Watcher]bool)
+			}
+			cooling[watcher] = true
+		} else {
+			delete(r.cooldowns, watcher)
+		}
+	}
+	return cooling
+}
+
+func (r *batcher) countDispatched(op Operation, stats *FlushStats) {
+	stats.Operations++
+	if op.Cost() == 0 {
+		stats.ZeroCostOperations++
+		if r.zeroCostOpsPerSecond > 0 {
+			r.zeroCostAllowance--
+		}
+	}
+	if deadline := op.Deadline(); !deadline.IsZero() {
+		if late := r.clock.Now().Sub(deadline); late > 0 {
+			stats.DeadlineMisses++
+			r.Emit(DeadlineMissEvent, int(late.Milliseconds()), "", op)
+		}
+	}
+}
+
+// If the watcher panicked (panicked is not nil), every Operation that does not already have a Result is failed with that error. Since
+// the watcher never had a chance to enqueue them again, those Operations are put back at the head of the buffer until they reach
+// MaxAttempts. Without MaxAttempts there is nothing to stop a poison Operation from panicking forever, so it is final immediately. This
+// returns the number of Operations that failed or were abandoned.
+func (r *batcher) completeBatch(watcher Watcher, batch []Operation, completed bool, panicked error, duration time.Duration) (failures int) {
+	maxAttempts := watcher.MaxAttempts()
+	var retry []Operation
+	for _, op := range batch {
+
+		// fill in anything the watcher did not provide
+		result := op.Result()
+		failedByPanic := false
+		if result.Status == ResultPending {
+			switch {
+			case panicked != nil:
+				result.Status = ResultFailed
+				result.Err = panicked
+				failedByPanic = true
+			case completed:
+				result.Status = ResultSucceeded
+			default:
+				result.Status = ResultAbandoned
+			}
+		}
+		if result.ActualCost == 0 {
+			result.ActualCost = op.Cost()
+		}
+		result.Duration = duration
+		result.Attempt = op.Attempt()
+		if result.Status != ResultSucceeded {
+			failures++
+		}
+
+		// the result is final if it succeeded or there are no more attempts
+		final := result.Status == ResultSucceeded || (maxAttempts > 0 && result.Attempt >= maxAttempts)
+		if failedByPanic && maxAttempts == 0 {
+			final = true
+		}
+		op.Complete(result, final)
+		r.emitOperation(OperationCompletedEvent, int(result.Duration.Milliseconds()), result.Status.String(), op)
+		if failedByPanic && !final {
+			retry = append(retry, op)
+		}
+
+		// raise dead-letter
+		if final && result.Status != ResultSucceeded {
+			msg := result.Status.String()
+			if result.Err != nil {
+				msg = result.Err.Error()
+			}
+			r.Emit(DeadLetterEvent, int(result.Attempt), msg, op)
+		}
+
+	}
+
+	// retry the operations that failed because of the panic
+	if len(retry) > 0 {
+		r.incTarget(watcher, 1, retry...)
+		r.buffer.requeue(retry)
+	}
+
+	return
 }
 
 // Call this method to start the processing loop. The processing loop requests capacity at the CapacityInterval, organizes operations into
@@ -439,10 +2153,62 @@ func (r *batcher) Start(ctx context.Context) (err error) {
 	// apply defaults
 	r.applyDefaults()
 
-	// start the timers
-	capacityTimer := time.NewTicker(r.capacityInterval)
-	flushTimer := time.NewTicker(r.flushInterval)
-	auditTimer := time.NewTicker(r.auditInterval)
+	// put the operations that a previous process did not finish back at the head of the buffer
+	if r.store != nil {
+		var recovered []Operation
+		if recovered, err = r.store.load(); err != nil {
+			return
+		}
+		for _, op := range recovered {
+			r.incTarget(op.Watcher(), 1, op)
+		}
+		r.buffer.requeue(recovered)
+		if len(recovered) > 0 {
+			r.Emit(RecoveredEvent, len(recovered), "", nil)
+		}
+	}
+
+	// the processing loop can also be stopped by Shutdown()
+	ctx, r.cancel = context.WithCancel(ctx)
+
+	// announce how many listeners are attached
+	r.Emit(ListenersEvent, r.ListenerCount(), "", nil)
+
+	// start the timers; any interval that was changed before Start() is already reflected, so a pending reconfigure is discarded
+	select {
+	case <-r.reconfigured:
+	default:
+	}
+	capacityTimer := r.clock.NewTicker(r.loadCapacityInterval())
+	flushTimer := r.clock.NewTicker(r.loadFlushInterval())
+	auditTimer := r.clock.NewTicker(r.auditInterval)
+
+	// align to the renewal of the rate limiter (if requested and supported)
+	var renewal RenewingRateLimiter
+	var renewalTimer <-chan time.Time
+	if rl, ok := r.ratelimiter.(RenewingRateLimiter); ok && r.alignToRenewal {
+		renewal = rl
+		renewalTimer = r.clock.After(renewal.NextRenewal().Sub(r.clock.Now()))
+	}
+
+	// summarize (if requested)
+	var summaryTicker Ticker
+	var summaryTimer <-chan time.Time
+	if r.summaryInterval > 0 {
+		summaryTicker = r.clock.NewTicker(r.summaryInterval)
+		summaryTimer = summaryTicker.C()
+		r.summary = newSummarizer(r.clock)
+	}
+
+	// process batches on the workers (if requested)
+	if r.workerCount > 0 {
+		r.workers = newWorkerPool(r.workerCount)
+	}
+
+	// the maps that pack the Operations of each flush into batches are reused by every flush
+	batches := make(map[batchKey][]Operation)
+	bytes := make(map[batchKey]uint32)
+	splitting := make(map[Watcher][]Operation)
 
 	// process
 	go func() {
@@ -456,21 +2222,51 @@ func (r *batcher) Start(ctx context.Context) (err error) {
 				capacityTimer.Stop()
 				flushTimer.Stop()
 				auditTimer.Stop()
+				if summaryTicker != nil {
+					summaryTicker.Stop()
+				}
+				if r.workers != nil {
+					r.workers.stop()
+				}
 				r.shutdown()
 				return
 
-			case <-r.pause:
+			case duration := <-r.pause:
 				// pause; typically this is requested because there is too much pressure on the datastore
-				r.Emit(PauseEvent, int(r.pauseTime.Milliseconds()), "", nil)
-				time.Sleep(r.pauseTime)
+				var timer Timer
+				var expired <-chan time.Time
+				if duration > 0 {
+					timer = r.clock.NewTimer(duration)
+					expired = timer.C()
+				} else {
+					duration = 0
+				}
+				r.Emit(PauseEvent, int(duration.Milliseconds()), "", nil)
+
+				// a pause of 0 or less lasts until Resume() since a nil channel is never ready
+				select {
+				case <-expired:
+				case <-r.resumeNow:
+				case <-ctx.Done():
+				}
+				if timer != nil {
+					timer.Stop()
+				}
 				r.resume()
 				r.Emit(ResumeEvent, 0, "", nil)
 
-			case <-auditTimer.C:
+			case <-auditTimer.C():
 				// ensure that if the buffer is empty and everything should have been flushed, that target is set to 0
-				if r.buffer.size() == 0 && time.Since(r.lastFlushWithRecords) > r.maxOperationTime {
+				if r.buffer.size() == 0 && r.buffer.overflowed() == 0 && r.scheduledSize() == 0 &&
+					r.clock.Now().Sub(r.lastFlushWithRecords) > r.maxOperationTime {
 					targetIsZero := r.confirmTargetIsZero()
 					inflightIsZero := r.confirmInflightIsZero()
+					if !targetIsZero || !inflightIsZero {
+						atomic.AddUint64(&r.auditFailures, 1)
+						atomic.AddUint32(&r.consecutiveAuditFailures, 1)
+					} else {
+						atomic.StoreUint32(&r.consecutiveAuditFailures, 0)
+					}
 					switch {
 					case !targetIsZero && !inflightIsZero:
 						r.Emit(AuditFailEvent, 0, AuditMsgFailureOnTargetAndInflight, nil)
@@ -485,35 +2281,168 @@ func (r *batcher) Start(ctx context.Context) (err error) {
 					r.Emit(AuditSkipEvent, 0, "", nil)
 				}
 
-			case <-capacityTimer.C:
-				// ask for capacity
-				if r.ratelimiter != nil {
-					request := r.NeedsCapacity()
-					if r.emitRequest {
-						r.Emit(RequestEvent, int(request), "", nil)
-					}
-					r.ratelimiter.GiveMe(request)
-				}
+			case <-capacityTimer.C():
+				r.requestCapacity()
 
-			case <-flushTimer.C:
+			case <-r.reconfigured:
+				// an interval was changed after Start()
+				capacityTimer.Reset(r.loadCapacityInterval())
+				flushTimer.Reset(r.loadFlushInterval())
+
+			case <-summaryTimer:
+				summary := r.summary.reset()
+				r.Emit(SummaryEvent, summary.Operations, "", summary)
+
+			case <-renewalTimer:
+				// restart the intervals on the renewal boundary, then request capacity and flush for the new window
+				capacityTimer.Reset(r.loadCapacityInterval())
+				flushTimer.Reset(r.loadFlushInterval())
+				renewalTimer = r.clock.After(renewal.NextRenewal().Sub(r.clock.Now()))
+				r.requestCapacity()
+				r.Flush()
+
+			case <-flushTimer.C():
 				r.Flush()
 
 			case <-r.flush:
 				// flush a percentage of the capacity (by default 10%)
+				flushStarted := r.clock.Now()
 				if r.emitFlush {
 					r.Emit(FlushStartEvent, 0, "", nil)
 				}
 
-				// determine the capacity
+				// the previous flush's window is over
+				r.releaseReservations()
+
+				// operations that overflowed to disk take the room that has been freed up
+				r.replayOverflow()
+
+				// a flush requested by FlushWatcher() only includes the operations for those watchers
+				only := r.takeFlushRequest()
+
+				// determine the capacity of each rate limiter; if the Batcher has one, every Operation is limited
 				enforceCapacity := r.ratelimiter != nil
-				var capacity uint32
-				if enforceCapacity {
-					capacity += uint32(float64(r.ratelimiter.Capacity()) / 1000.0 * float64(r.flushInterval.Milliseconds()))
+				budget := newFlushBudget(r.loadFlushInterval(), r.loadCostScale(), r.ratelimiter)
+				r.limiterTargets.Range(func(key, _ interface{}) bool {
+					budget.add(key.(RateLimiter))
+					return true
+				})
+
+				// determine how many zero-cost operations can be dispatched; the allowance carries over so that low rates are honored
+				enforceZeroCost := r.zeroCostOpsPerSecond > 0
+				var zeroCostLimit uint32
+				if enforceZeroCost {
+					perFlush := float64(r.zeroCostOpsPerSecond) * r.loadFlushInterval().Seconds()
+					r.zeroCostAllowance = math.Min(r.zeroCostAllowance+perFlush, math.Max(perFlush, 1))
+					zeroCostLimit = uint32(r.zeroCostAllowance)
 				}
 
 				// if there are operations in the buffer, go up to the capacity
-				batches := make(map[Watcher][]Operation)
-				var consumed uint32 = 0
+				for key := range batches {
+					delete(batches, key)
+				}
+				for key := range bytes {
+					delete(bytes, key)
+				}
+				for watcher := range splitting {
+					delete(splitting, watcher)
+				}
+				stats := FlushStats{Capacity: budget.totalCapacity(), ZeroCostLimit: zeroCostLimit}
+
+				// operations for watchers that are cooling down after a failure (or are paused) are left in the buffer
+				cooling := r.coolingDown()
+				for watcher := range r.pausedWatchers() {
+					if cooling == nil {
+						cooling = make(map[Watcher]bool)
+					}
+					cooling[watcher] = true
+				}
+
+				// batchable operations are left in the buffer until there are MinBatchSize of them or they have lingered long enough
+				held := r.holdForMinBatchSize()
+				for key := range held {
+					if only[key.watcher] {
+						delete(held, key)
+					}
+				}
+
+				// operations with a key must not be raised ahead of an earlier operation with the same key
+				if r.ordered != nil {
+					r.ordered.startFlush()
+				}
+
+				// if a rate limiter with no capacity holds back the flush, the next flush can happen as soon as it grants some
+				var starved RateLimiter
+				var starvedCost uint32
+				noteStarved := func(rl RateLimiter, op Operation) {
+					if starved == nil {
+						starved, starvedCost = budget.starved(rl, op)
+					}
+				}
+
+				// a new batch can only be started if the flush has not hit its limit and there is a slot available (and a place in the
+				// worker queue, if WithWorkers is used, so the loop never blocks on a busy pool)
+				var started uint32 = 0
+				tryStartBatch := func() bool {
+					if r.maxBatchesPerFlush > 0 && started >= r.maxBatchesPerFlush {
+						return false
+					}
+					if !r.tryReserveBatchSlot() {
+						return false
+					}
+					if r.workers != nil && !r.workers.tryReserve() {
+						r.releaseBatchSlot()
+						return false
+					}
+					started++
+					return true
+				}
+
+				// batches that were requeued as a unit are dispatched first (once due) without being split up
+				r.scheduledMutex.Lock()
+				waiting := r.scheduled[:0]
+				for _, scheduled := range r.scheduled {
+					if r.clock.Now().Before(scheduled.due) || (only != nil && !only[scheduled.watcher]) || cooling[scheduled.watcher] || budget.spent(r.limiterFor(scheduled.watcher)) || !tryStartBatch() {
+						waiting = append(waiting, scheduled)
+						continue
+					}
+					if !r.dispatchBatch(scheduled.watcher, scheduled.ops, false) {
+						r.abandonBatchSlot()
+						waiting = append(waiting, scheduled)
+						continue
+					}
+					for _, op := range scheduled.ops {
+						budget.consume(r.limiterFor(scheduled.watcher), op)
+						r.countDispatched(op, &stats)
+					}
+				}
+				r.scheduled = waiting
+				if r.ordered != nil {
+					for _, scheduled := range waiting {
+						r.ordered.block(scheduled.ops...)
+					}
+				}
+				r.scheduledMutex.Unlock()
+
+				// operations that expired while waiting in the buffer are removed rather than dispatched
+				if atomic.LoadInt32(&r.expiring) == 1 {
+					stats.Expired = r.removeExpired()
+				}
+
+				// if any watcher has a weight, the capacity is divided among the watchers rather than used in the order of the buffer
+				var shares *fairShare
+				if atomic.LoadInt32(&r.weighted) == 1 {
+					shares = newFairShare(budget, r.buffer, r.limiterFor, func(watcher Watcher) bool {
+						return cooling[watcher] || (only != nil && !only[watcher])
+					})
+				}
+				consume := func(watcher Watcher, op Operation) {
+					rl := r.limiterFor(watcher)
+					budget.consume(rl, op)
+					if shares != nil {
+						shares.consume(rl, watcher, op)
+					}
+				}
 
 				// reset the buffer cursor to the top of the buffer
 				op := r.buffer.top()
@@ -525,49 +2454,145 @@ func (r *batcher) Start(ctx context.Context) (err error) {
 						break
 					}
 
+					// leave operations for other watchers when only some are being flushed
+					if only != nil && !only[op.Watcher()] {
+						op = r.skip(op)
+						continue
+					}
+
+					// leave operations behind an earlier operation with the same key; one that is not batchable would be raised by itself
+					if r.ordered != nil && r.ordered.mustWait(op, !op.IsBatchable()) {
+						op = r.skip(op)
+						continue
+					}
+
 					// enforce capacity
-					if enforceCapacity && consumed >= capacity {
+					if enforceCapacity && budget.allSpent() {
+						noteStarved(r.limiterFor(op.Watcher()), op)
 						break
 					}
+					if rl := r.limiterFor(op.Watcher()); budget.spent(rl) {
+						noteStarved(rl, op)
+						op = r.skip(op)
+						continue
+					}
+					if shares != nil && shares.spent(r.limiterFor(op.Watcher()), op.Watcher()) {
+						op = r.skip(op)
+						continue
+					}
+
+					// enforce the zero-cost limit
+					if enforceZeroCost && op.Cost() == 0 && r.zeroCostAllowance < 1 {
+						op = r.skip(op)
+						continue
+					}
+
+					// skip watchers that are cooling down
+					if cooling[op.Watcher()] {
+						op = r.skip(op)
+						continue
+					}
 
 					// batch
 					switch {
 					case op.IsBatchable():
 						watcher := op.Watcher()
-						batch, ok := batches[watcher]
-						if (batch == nil || !ok) && !r.tryReserveBatchSlot() {
-							op = r.buffer.skip()
-							continue // there is no batch slot available
+						key := batchKey{watcher: watcher}
+						if watcher.GroupByKey() {
+							key.key = op.Key()
+						}
+						if held[key] {
+							op = r.skip(op)
+							continue
+						}
+						if watcher.Splitter() != nil {
+							if _, ok := splitting[watcher]; !ok && !tryStartBatch() {
+								op = r.skip(op)
+								continue // a batch cannot be started
+							}
+							consume(watcher, op)
+							splitting[watcher] = append(splitting[watcher], op)
+							if r.ordered != nil {
+								r.ordered.add(op)
+							}
+							op = r.buffer.remove()
+							continue
+						}
+						batch, ok := batches[key]
+						if maxBytes := watcher.MaxBatchBytes(); maxBytes > 0 && len(batch) > 0 && bytes[key]+op.Size() > maxBytes {
+							r.processBatch(watcher, batch, true)
+							batch, bytes[key] = nil, 0
+							batches[key] = nil
+							if r.ordered != nil && r.ordered.mustWait(op, false) {
+								op = r.skip(op)
+								continue // an earlier operation with the same key was just raised
+							}
 						}
-						consumed += op.Cost()
+						if (batch == nil || !ok) && !tryStartBatch() {
+							op = r.skip(op)
+							continue // a batch cannot be started
+						}
+						if batch == nil {
+							batch = r.newBatch()
+						}
+						consume(watcher, op)
+						r.countDispatched(op, &stats)
 						batch = append(batch, op)
+						bytes[key] += op.Size()
+						if r.ordered != nil {
+							r.ordered.add(op)
+						}
 						max := watcher.MaxBatchSize()
 						if max > 0 && len(batch) >= int(max) {
-							r.processBatch(watcher, batch)
-							batches[watcher] = nil
+							r.processBatch(watcher, batch, true)
+							batches[key], bytes[key] = nil, 0
 						} else {
-							batches[watcher] = batch
+							batches[key] = batch
 						}
 						op = r.buffer.remove()
-					case r.tryReserveBatchSlot():
-						consumed += op.Cost()
+					case tryStartBatch():
 						watcher := op.Watcher()
-						r.processBatch(watcher, []Operation{op})
+						consume(watcher, op)
+						r.countDispatched(op, &stats)
+						r.processBatch(watcher, append(r.newBatch(), op), true)
 						op = r.buffer.remove()
 					default:
-						// there is no batch slot available
-						op = r.buffer.skip()
+						// a batch cannot be started
+						op = r.skip(op)
 					}
 
 				}
 
+				// operations that were skipped may now be cancelled
+				r.buffer.release()
+				r.checkWatermarks()
+
 				// flush all batches that were seen
-				for watcher, batch := range batches {
-					r.processBatch(watcher, batch)
+				for key, batch := range batches {
+					r.processBatch(key.watcher, batch, true)
+				}
+				for watcher, ops := range splitting {
+					for _, op := range r.processSplit(watcher, ops, &stats, tryStartBatch) {
+						budget.refund(r.limiterFor(watcher), op)
+					}
+				}
+
+				if starved != nil {
+					r.waitForCapacity(ctx, starved, starvedCost)
 				}
 
+				stats.Consumed = budget.total
+				atomic.StoreInt64(&r.flushLatency, int64(r.clock.Now().Sub(flushStarted)))
+				if r.summary != nil {
+					r.summary.flushDone(stats)
+				}
 				if r.emitFlush {
-					r.Emit(FlushDoneEvent, 0, "", nil)
+					r.Emit(FlushDoneEvent, int(budget.total), "", stats)
+				}
+
+				// stop once Shutdown() has drained everything
+				if r.isDrained() {
+					r.cancel()
 				}
 			}
 		}
@@ -575,24 +2600,96 @@ func (r *batcher) Start(ctx context.Context) (err error) {
 	}()
 
 	// end starting
+	atomic.StoreInt64(&r.startedAt, r.clock.Now().UnixNano())
 	r.phase = phaseStarted
 
 	return
 }
 
-func (r *batcher) shutdown() {
+// Call this method to gracefully stop the Batcher. It stops accepting new Operations (Enqueue() returns BufferIsShutdown, though Watchers
+// may still enqueue Operations that were already attempted so they can be retried), flushes, and waits for the buffer (including batches
+// requeued with RequeueAll()) to be emptied and for the Watchers to finish with every batch. The processing loop then stops and the
+// shutdown event is raised. If the context is done before everything is drained, anything left in the buffer is discarded, the
+// processing loop is stopped anyway, and the context's error is returned. A pause (even one of indefinite duration) is ended so the
+// buffer can be drained, as is the pause of every Watcher (see PauseWatcher()). Cancelling the context provided to Start() still stops
+// the Batcher immediately.
+func (r *batcher) Shutdown(ctx context.Context) error {
 
 	// only allow one phase at a time
+	r.phaseMutex.Lock()
+	if r.phase != phaseStarted && r.phase != phasePaused {
+		r.phaseMutex.Unlock()
+		return ImproperOrderError
+	}
+	if r.phase == phasePaused {
+		r.endPause()
+	}
+	r.phase = phaseDraining
+	r.phaseMutex.Unlock()
+
+	// drain
+	r.resumeAllWatchers()
+	r.Flush()
+	select {
+	case <-r.stopped:
+		return nil
+	case <-ctx.Done():
+		r.cancel()
+		<-r.stopped
+		return ctx.Err()
+	}
+
+}
+
+// This returns true if Shutdown() was called and the processing loop has not stopped yet.
+func (r *batcher) isDraining() bool {
 	r.phaseMutex.Lock()
 	defer r.phaseMutex.Unlock()
+	return r.phase == phaseDraining
+}
+
+// This returns true if Shutdown() was called and there is nothing left in the buffer or being processed by a Watcher. The batches are
+// counted before the buffer since a batch that finishes may put Operations back in the buffer.
+func (r *batcher) isDrained() bool {
+	return r.isDraining() && atomic.LoadInt32(&r.running) == 0 && r.buffer.size() == 0 && r.buffer.overflowed() == 0 &&
+		r.scheduledSize() == 0
+}
+
+func (r *batcher) shutdown() {
+
+	// only allow one phase at a time
+	r.phaseMutex.Lock()
 
 	// clear the buffer
-	r.buffer.shutdown()
+	dropped := r.buffer.shutdown()
+	r.scheduledMutex.Lock()
+	for _, scheduled := range r.scheduled {
+		dropped = append(dropped, scheduled.ops...)
+	}
+	r.scheduled = nil
+	r.scheduledMutex.Unlock()
+	for _, op := range dropped {
+		r.emitOperation(OperationDroppedEvent, int(op.Cost()), "", op)
+	}
 
 	// update the phase
 	r.phase = phaseStopped
 
 	// emit the shutdown event
+	r.Emit(ListenersEvent, r.ListenerCount(), "", nil)
 	r.Emit(ShutdownEvent, 0, "", nil)
+	r.phaseMutex.Unlock()
+
+	// with WithAsyncEvents, the listeners must receive the queued events before they are cleared; the phase is unlocked so a listener
+	// may still call methods such as Pause()
+	r.waitForEvents()
+
+	// clear the listeners (if requested)
+	if r.clearListeners {
+		r.RemoveAllListeners()
+	}
+
+	// release anyone waiting on Shutdown()
+	close(r.stopped)
 
 }
@@ -2,7 +2,13 @@ package batcher_test
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -13,6 +19,9 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // NOTE: mock.AssertExpectations was not used because it iterates all private properties of the mocked object and sometimes
@@ -71,6 +80,89 @@ func TestBatcher_Enqueue_OperationsCannotExceedMaxCapacity_Reserved(t *testing.T
 	assert.Equal(t, gobatcher.TooExpensiveError, err, "expect a too-expensive-error error")
 }
 
+func TestBatcher_Enqueue_ErrorsRaiseEnqueueErrorEvents(t *testing.T) {
+	res := gobatcher.NewSharedResource().
+		WithReservedCapacity(1000)
+	batcher := gobatcher.NewBatcher().
+		WithRateLimiter(res)
+	var costs []int
+	var errs []error
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		if event == gobatcher.EnqueueErrorEvent {
+			costs = append(costs, val)
+			errs = append(errs, metadata.(error))
+		}
+	})
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {})
+	err := batcher.Enqueue(gobatcher.NewOperation(watcher, 2000, struct{}{}, false))
+	assert.Equal(t, gobatcher.TooExpensiveError, err, "expect a too-expensive-error error")
+	err = batcher.EnqueueMany([]gobatcher.Operation{nil, gobatcher.NewOperation(watcher, 100, struct{}{}, false)})
+	assert.Error(t, err, "expecting an error for the nil operation")
+	assert.Equal(t, []int{2000, 0}, costs, "expecting an event for each operation that could not be enqueued")
+	assert.Equal(t, []error{gobatcher.TooExpensiveError, gobatcher.NoOperationError}, errs, "expecting the metadata to be the error")
+}
+
+func TestBatcher_Enqueue_OperationsCannotExceedMaxCapacity_Watcher(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	res := gobatcher.NewSharedResource().
+		WithReservedCapacity(1000)
+	batcher := gobatcher.NewBatcher()
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "expecting no errors on startup")
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {}).
+		WithRateLimiter(res)
+	err = batcher.Enqueue(gobatcher.NewOperation(watcher, 2000, struct{}{}, false))
+	assert.Equal(t, gobatcher.TooExpensiveError, err, "expect a too-expensive-error error from the watcher's rate limiter")
+}
+
+func TestBatcher_Enqueue_OperationsThatExceedMaxCapacityAreSplit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	res := gobatcher.NewSharedResource().
+		WithReservedCapacity(1000)
+	batcher := gobatcher.NewBatcher().
+		WithRateLimiter(res)
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "expecting no errors on startup")
+	var mutex sync.Mutex
+	var costs []uint32
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		for _, op := range batch {
+			costs = append(costs, op.Cost())
+			op.SetResult(gobatcher.Result{Status: gobatcher.ResultSucceeded, ActualCost: op.Cost()})
+		}
+	})
+	operation := gobatcher.NewOperation(watcher, 2500, struct{}{}, true).
+		WithSplit(func(maxCost uint32) []gobatcher.Operation {
+			var fragments []gobatcher.Operation
+			for remaining := uint32(2500); remaining > 0; {
+				cost := remaining
+				if cost > maxCost {
+					cost = maxCost
+				}
+				fragments = append(fragments, gobatcher.NewOperation(watcher, cost, struct{}{}, true))
+				remaining -= cost
+			}
+			return fragments
+		})
+	err = batcher.Enqueue(operation)
+	assert.NoError(t, err, "expecting the operation to be split rather than rejected")
+	select {
+	case <-operation.Done():
+		completed := operation.Result()
+		assert.Equal(t, gobatcher.ResultSucceeded, completed.Status, "expecting the operation to succeed once every fragment has")
+		assert.Equal(t, uint32(2500), completed.ActualCost, "expecting the actual cost of the fragments to be combined")
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "expecting the operation to be completed")
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.ElementsMatch(t, []uint32{1000, 1000, 500}, costs, "expecting the fragments to be processed instead of the operation")
+}
+
 func TestBatcher_Enqueue_OperationsCannotExceedMaxCapacity_SharedAndReserved(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -346,6 +438,49 @@ func TestBatcher_NeedsCapacity_EnsureOperationCostsResultInRequests(t *testing.T
 	assert.Equal(t, 1100, max, "expecting the request to be the sum of the operations")
 }
 
+func TestBatcher_NeedsCapacity_ReportedActualCostsScaleTheRequest(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	res := gobatcher.NewSharedResource().
+		WithReservedCapacity(10000)
+	batcher := gobatcher.NewBatcher().
+		WithRateLimiter(res).
+		WithFlushInterval(1 * time.Millisecond).
+		WithEmitRequest()
+	var mu sync.Mutex
+	var max int
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		switch event {
+		case gobatcher.RequestEvent:
+			mu.Lock()
+			defer mu.Unlock()
+			if val > max {
+				max = val
+			}
+		}
+	})
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		time.Sleep(400 * time.Millisecond)
+	})
+	op := gobatcher.NewOperation(watcher, 0, "0123456789", false).
+		WithCostFunc(func(payload interface{}) uint32 {
+			return uint32(len(payload.(string)) * 100)
+		})
+	assert.Equal(t, uint32(1000), op.Cost(), "expecting the cost to be estimated from the payload")
+	for i := 0; i < 100; i++ {
+		batcher.ReportActualCost(op, 2000)
+	}
+	err := batcher.Enqueue(op)
+	assert.NoError(t, err, "expecting no error on enqueue")
+	assert.Equal(t, uint32(1000), batcher.NeedsCapacity(), "expecting the target to be the estimated cost")
+	err = batcher.Start(ctx)
+	assert.NoError(t, err, "expecting no error on start")
+	time.Sleep(200 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.InDelta(t, 2000, max, 1, "expecting the request to be scaled by the actual cost")
+}
+
 func TestBatcher_NeedsCapacity_EnsureOperationCostsResultInTarget(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -473,6 +608,76 @@ func TestBatcher_Pause_EnsureMultiplePausesDoNotIncreaseTheTime(t *testing.T) {
 	assert.Less(t, len.Milliseconds(), int64(600), "expecting the pause to be under 600 ms")
 }
 
+func TestBatcher_PauseFor_LastsForTheProvidedDuration(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher()
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	paused := make(chan int, 1)
+	resumed := make(chan time.Time, 1)
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		switch event {
+		case gobatcher.PauseEvent:
+			paused <- val
+		case gobatcher.ResumeEvent:
+			resumed <- time.Now()
+		}
+	})
+	started := time.Now()
+	batcher.PauseFor(50 * time.Millisecond)
+	select {
+	case at := <-resumed:
+		assert.Equal(t, 50, <-paused)
+		assert.GreaterOrEqual(t, int64(at.Sub(started)), int64(50*time.Millisecond))
+	case <-time.After(1 * time.Second):
+		assert.FailNow(t, "expected to be resumed before now")
+	}
+}
+
+func TestBatcher_PauseFor_ZeroHoldsUntilResume(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Millisecond)
+	paused := make(chan int, 1)
+	resumed := make(chan struct{}, 1)
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		switch event {
+		case gobatcher.PauseEvent:
+			paused <- val
+		case gobatcher.ResumeEvent:
+			resumed <- struct{}{}
+		}
+	})
+	processed := make(chan struct{}, 1)
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		processed <- struct{}{}
+	})
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	batcher.PauseFor(0)
+	assert.Equal(t, 0, <-paused, "expecting an indefinite pause to be raised with 0")
+	err = batcher.Enqueue(gobatcher.NewOperation(watcher, 0, struct{}{}, false))
+	assert.NoError(t, err, "not expecting an enqueue error")
+	select {
+	case <-processed:
+		assert.FailNow(t, "not expecting processing while paused")
+	case <-resumed:
+		assert.FailNow(t, "not expecting to resume without Resume()")
+	case <-time.After(700 * time.Millisecond):
+		// held longer than the default PauseTime
+	}
+	batcher.Resume()
+	select {
+	case <-processed:
+	case <-time.After(1 * time.Second):
+		assert.FailNow(t, "expected processing after Resume()")
+	}
+	<-resumed
+	batcher.Resume() // resuming when not paused has no effect
+}
+
 func TestBatcher_Pause_EnsureNegativeDurationUses500ms_Default(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -608,6 +813,221 @@ func TestBatcher_Start_EnsureThatMixedOperationsAreBatchedOrNotAsAppropriate(t *
 	assert.Equal(t, uint32(3), count, "expect 3 operations to be completed")
 }
 
+func TestBatcher_Start_EnsureBatchesAreGroupedByKey(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher()
+	var mutex sync.Mutex
+	batches := make(map[string]int)
+	wg := sync.WaitGroup{}
+	wg.Add(3)
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		for _, op := range batch {
+			assert.Equal(t, batch[0].Key(), op.Key(), "expecting every operation in the batch to have the same key")
+		}
+		batches[batch[0].Key()] += len(batch)
+		wg.Done()
+	}).WithGroupByKey()
+	for _, key := range []string{"a", "b", "a", "c", "b", "a"} {
+		err := batcher.Enqueue(gobatcher.NewOperation(watcher, 0, struct{}{}, true).WithKey(key))
+		assert.NoError(t, err, "not expecting an enqueue error")
+	}
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a startup error")
+	wg.Wait()
+	assert.Equal(t, map[string]int{"a": 3, "b": 2, "c": 1}, batches, "expecting one batch per key")
+}
+
+func TestBatcher_Start_EnsureBatchesDoNotExceedMaxBatchBytes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher()
+	var mutex sync.Mutex
+	var sizes [][]uint32
+	wg := sync.WaitGroup{}
+	wg.Add(4)
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		var size []uint32
+		for _, op := range batch {
+			size = append(size, op.Size())
+		}
+		sizes = append(sizes, size)
+		wg.Done()
+	}).WithMaxBatchBytes(1000)
+	for _, size := range []uint32{400, 400, 400, 1500, 100} {
+		err := batcher.Enqueue(gobatcher.NewOperation(watcher, 0, struct{}{}, true).WithSize(size))
+		assert.NoError(t, err, "not expecting an enqueue error")
+	}
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a startup error")
+	wg.Wait()
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.ElementsMatch(t, [][]uint32{{400, 400}, {400}, {1500}, {100}}, sizes, "expecting batches to be split when they would exceed MaxBatchBytes")
+}
+
+func TestBatcher_Start_EnsureSmallBatchesLingerUntilMinBatchSize(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(10 * time.Millisecond)
+	batches := make(chan int, 10)
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		batches <- len(batch)
+	}).
+		WithMinBatchSize(3).
+		WithMaxLinger(300 * time.Millisecond)
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a startup error")
+
+	// fewer than MinBatchSize are held back until they have lingered
+	started := time.Now()
+	for i := 0; i < 2; i++ {
+		err = batcher.Enqueue(gobatcher.NewOperation(watcher, 0, struct{}{}, true))
+		assert.NoError(t, err, "not expecting an enqueue error")
+	}
+	select {
+	case size := <-batches:
+		assert.Equal(t, 2, size, "expecting the lingering operations to be raised together")
+		assert.GreaterOrEqual(t, time.Since(started), 300*time.Millisecond, "expecting the operations to linger for MaxLinger")
+	case <-time.After(1 * time.Second):
+		assert.Fail(t, "expecting the operations to be raised after MaxLinger")
+	}
+
+	// MinBatchSize is raised without lingering
+	started = time.Now()
+	for i := 0; i < 3; i++ {
+		err = batcher.Enqueue(gobatcher.NewOperation(watcher, 0, struct{}{}, true))
+		assert.NoError(t, err, "not expecting an enqueue error")
+	}
+	select {
+	case size := <-batches:
+		assert.Equal(t, 3, size, "expecting a batch of MinBatchSize")
+		assert.Less(t, time.Since(started), 300*time.Millisecond, "expecting the operations to not linger")
+	case <-time.After(1 * time.Second):
+		assert.Fail(t, "expecting the operations to be raised")
+	}
+}
+
+func TestBatcher_Start_EnsureTheSplitterDeterminesTheBatches(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher()
+	var mutex sync.Mutex
+	var batches [][]string
+	wg := sync.WaitGroup{}
+	wg.Add(3)
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		var tenants []string
+		for _, op := range batch {
+			tenants = append(tenants, op.Payload().(string))
+		}
+		batches = append(batches, tenants)
+		wg.Done()
+	}).WithSplitter(func(ops []gobatcher.Operation) [][]gobatcher.Operation {
+		byTenant := make(map[string][]gobatcher.Operation)
+		var order []string
+		for _, op := range ops {
+			tenant := op.Payload().(string)
+			if _, ok := byTenant[tenant]; !ok {
+				order = append(order, tenant)
+			}
+			byTenant[tenant] = append(byTenant[tenant], op)
+		}
+		var split [][]gobatcher.Operation
+		for _, tenant := range order {
+			split = append(split, byTenant[tenant])
+		}
+		return split
+	})
+	for _, tenant := range []string{"contoso", "fabrikam", "contoso", "tailspin"} {
+		err := batcher.Enqueue(gobatcher.NewOperation(watcher, 0, tenant, true))
+		assert.NoError(t, err, "not expecting an enqueue error")
+	}
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a startup error")
+	wg.Wait()
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.ElementsMatch(t, [][]string{{"contoso", "contoso"}, {"fabrikam"}, {"tailspin"}}, batches, "expecting one batch per tenant")
+}
+
+func TestBatcher_Start_EnsureOperationsTheSplitterOmitsArePutBack(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(10 * time.Millisecond)
+	batches := make(chan int, 10)
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		batches <- len(batch)
+	}).WithSplitter(func(ops []gobatcher.Operation) [][]gobatcher.Operation {
+		return [][]gobatcher.Operation{ops[:1]} // one at a time
+	})
+	for i := 0; i < 3; i++ {
+		err := batcher.Enqueue(gobatcher.NewOperation(watcher, 0, struct{}{}, true))
+		assert.NoError(t, err, "not expecting an enqueue error")
+	}
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a startup error")
+	for i := 0; i < 3; i++ {
+		select {
+		case size := <-batches:
+			assert.Equal(t, 1, size, "expecting the splitter to raise one operation at a time")
+		case <-time.After(1 * time.Second):
+			assert.Fail(t, "expecting the omitted operations to be raised by later flushes")
+		}
+	}
+}
+
+func TestBatcher_EmitBatch_DescribesOperationsWithoutPayloads(t *testing.T) {
+	testCases := map[string]struct {
+		operations bool
+	}{
+		"redacted by default":       {operations: false},
+		"operations only by opt-in": {operations: true},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			batcher := gobatcher.NewBatcher()
+			if testCase.operations {
+				batcher.WithEmitBatchOperations()
+			} else {
+				batcher.WithEmitBatch()
+			}
+			metadata := make(chan interface{}, 1)
+			batcher.AddListener(func(event string, val int, msg string, m interface{}) {
+				if event == gobatcher.BatchEvent {
+					metadata <- m
+				}
+			})
+			watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {}).WithLabel("orders")
+			op := gobatcher.NewOperation(watcher, 10, "payload", false).WithKey("pk").WithID("order-1")
+			err := batcher.Enqueue(op)
+			assert.NoError(t, err, "not expecting an enqueue error")
+			err = batcher.Start(ctx)
+			assert.NoError(t, err, "not expecting a startup error")
+			select {
+			case m := <-metadata:
+				if testCase.operations {
+					assert.Equal(t, []gobatcher.Operation{op}, m)
+				} else {
+					assert.Equal(t, []gobatcher.BatchedOperation{{ID: op.ID(), ExternalID: "order-1", Cost: 10, Key: "pk", Watcher: "orders"}}, m)
+				}
+			case <-time.After(1 * time.Second):
+				assert.Fail(t, "expecting a batch event")
+			}
+		})
+	}
+}
+
 func TestBatcher_Start_EnsureFullBatchesAreFlushed(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -638,497 +1058,3121 @@ func TestBatcher_Start_InitializationAfterStartCausesPanic(t *testing.T) {
 	err := batcher.Start(ctx)
 	assert.NoError(t, err, "not expecting a start error")
 	assert.PanicsWithError(t, gobatcher.InitializationOnlyError.Error(), func() { batcher.WithRateLimiter(res) })
-	assert.PanicsWithError(t, gobatcher.InitializationOnlyError.Error(), func() { batcher.WithFlushInterval(1 * time.Millisecond) })
-	assert.PanicsWithError(t, gobatcher.InitializationOnlyError.Error(), func() { batcher.WithCapacityInterval(1000) })
+	assert.PanicsWithError(t, gobatcher.InitializationOnlyError.Error(), func() { batcher.WithRateLimiters(res, res) })
 	assert.PanicsWithError(t, gobatcher.InitializationOnlyError.Error(), func() { batcher.WithAuditInterval(1 * time.Millisecond) })
 	assert.PanicsWithError(t, gobatcher.InitializationOnlyError.Error(), func() { batcher.WithMaxOperationTime(10 * time.Millisecond) })
 	assert.PanicsWithError(t, gobatcher.InitializationOnlyError.Error(), func() { batcher.WithPauseTime(1 * time.Millisecond) })
 	assert.PanicsWithError(t, gobatcher.InitializationOnlyError.Error(), func() { batcher.WithErrorOnFullBuffer() })
 	assert.PanicsWithError(t, gobatcher.InitializationOnlyError.Error(), func() { batcher.WithEmitBatch() })
+	assert.PanicsWithError(t, gobatcher.InitializationOnlyError.Error(), func() { batcher.WithEmitBatchOperations() })
+	assert.PanicsWithError(t, gobatcher.InitializationOnlyError.Error(), func() { batcher.WithMaxBatchesPerFlush(1) })
+	assert.PanicsWithError(t, gobatcher.InitializationOnlyError.Error(), func() { batcher.WithClearListenersOnShutdown() })
+	assert.PanicsWithError(t, gobatcher.InitializationOnlyError.Error(), func() { batcher.WithDeadlineFirst() })
+	assert.PanicsWithError(t, gobatcher.InitializationOnlyError.Error(), func() { batcher.WithCaptureStackOnTimeout() })
+	assert.PanicsWithError(t, gobatcher.InitializationOnlyError.Error(), func() { batcher.WithSummaryInterval(time.Minute) })
+	assert.PanicsWithError(t, gobatcher.InitializationOnlyError.Error(), func() { batcher.WithRequireStarted() })
 }
 
-func TestBatcher_Loop_Shutdown(t *testing.T) {
+func TestBatcher_Flush_EveryRateLimiterMustGrantCapacity(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
-	batcher := gobatcher.NewBatcher()
-	done := make(chan bool)
-	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
-		switch event {
-		case gobatcher.ShutdownEvent:
-			close(done)
-		}
+	defer cancel()
+	requestUnits := gobatcher.NewSharedResource().
+		WithReservedCapacity(10000)
+	requests := gobatcher.NewSharedResource().
+		WithReservedCapacity(1000)
+	batcher := gobatcher.NewBatcher().
+		WithRateLimiters(requestUnits, requests).
+		WithFlushInterval(100 * time.Millisecond)
+	var processed uint32
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		atomic.AddUint32(&processed, uint32(len(batch)))
 	})
-	err := batcher.Start(ctx)
-	assert.NoError(t, err, "not expecting a start error")
-	cancel()
-	select {
-	case <-done:
-		// success
-	case <-time.After(1 * time.Second):
-		// timeout
-		assert.Fail(t, "expected shutdown but didn't see one even after 1 second")
+	err := batcher.Enqueue(gobatcher.NewOperation(watcher, 2000, struct{}{}, false))
+	assert.Equal(t, gobatcher.TooExpensiveError, err, "expecting the smallest max capacity to be enforced")
+	for i := 0; i < 5; i++ {
+		err = batcher.Enqueue(gobatcher.NewOperation(watcher, 100, struct{}{}, false))
+		assert.NoError(t, err, "not expecting an enqueue error")
 	}
+	err = requestUnits.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	err = requests.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	err = batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	time.Sleep(150 * time.Millisecond)
+	assert.Equal(t, uint32(1), atomic.LoadUint32(&processed), "expecting the smallest capacity to limit the flush")
 }
 
-func TestBatcher_Loop_EnsureOperationsAreFlushedInExpectedTimes(t *testing.T) {
-	testCases := map[string]struct {
-		interval time.Duration
-		enqueue  int
-		wait     time.Duration
-		expect   uint32
-	}{
-		"-200ms (default to 100)": {interval: -200 * time.Millisecond, enqueue: 4, wait: 250 * time.Millisecond, expect: 2},
-		"100ms (default)":         {interval: 0 * time.Millisecond, enqueue: 4, wait: 250 * time.Millisecond, expect: 2},
-		"300ms":                   {interval: 300 * time.Millisecond, enqueue: 4, wait: 650 * time.Millisecond, expect: 2},
-	}
-	for testName, testCase := range testCases {
-		t.Run(testName, func(t *testing.T) {
-			ctx, cancel := context.WithCancel(context.Background())
-			defer cancel()
-			res := gobatcher.NewSharedResource().
-				WithReservedCapacity(100)
-			batcher := gobatcher.NewBatcher().
-				WithRateLimiter(res).
-				WithFlushInterval(testCase.interval)
-			var count uint32 = 0
-			watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
-				atomic.AddUint32(&count, uint32(len(batch)))
-			})
-			for i := 0; i < testCase.enqueue; i++ {
-				op := gobatcher.NewOperation(watcher, 100, struct{}{}, false)
-				err := batcher.Enqueue(op)
-				assert.NoError(t, err, "not expecting an enqueue error")
-			}
-			err := batcher.Start(ctx)
-			assert.NoError(t, err, "not expecting a start error")
-			time.Sleep(testCase.wait)
-			assert.Equal(t, testCase.expect, atomic.LoadUint32(&count), "expecting %v operations to be completed given the %v interval and capacity for only a single operation", testCase.interval, testCase.expect)
-		})
-	}
-}
-
-func TestBatcher_Loop_EnsureCapacityRequestsAreRaisedInExpectedTimes(t *testing.T) {
-	testCases := map[string]struct {
-		interval time.Duration
-		wait     time.Duration
-		expect   uint32
-	}{
-		"-200ms (default to 100ms)": {interval: 0 * time.Millisecond, wait: 250 * time.Millisecond, expect: 2},
-		"100ms (default)":           {interval: 0 * time.Millisecond, wait: 250 * time.Millisecond, expect: 2},
-		"300ms":                     {interval: 300 * time.Millisecond, wait: 650 * time.Millisecond, expect: 2},
-	}
-	for testName, testCase := range testCases {
-		t.Run(testName, func(t *testing.T) {
-			ctx, cancel := context.WithCancel(context.Background())
-			defer cancel()
-			mgr := &mockLeaseManager{}
-			mgr.On("RaiseEventsTo", mock.Anything)
-			res := gobatcher.NewSharedResource().
-				WithSharedCapacity(10000, mgr).
-				WithFactor(1000)
-			batcher := gobatcher.NewBatcher().
-				WithRateLimiter(res).
-				WithCapacityInterval(testCase.interval).
-				WithEmitRequest()
-			var count uint32 = 0
-			batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
-				switch event {
-				case gobatcher.RequestEvent:
-					atomic.AddUint32(&count, 1)
-				}
-			})
-			watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {})
-			op := gobatcher.NewOperation(watcher, 800, struct{}{}, false)
-			err := batcher.Enqueue(op)
-			assert.NoError(t, err, "not expecting an enqueue error")
-			err = batcher.Start(ctx)
-			assert.NoError(t, err, "not expecting a start error")
-			time.Sleep(testCase.wait)
-			assert.Equal(t, testCase.expect, atomic.LoadUint32(&count), "expecting %v capacity requests given the %v interval and capacity for only a single operation", testCase.interval, testCase.expect)
-			mgr.AssertNumberOfCalls(t, "RaiseEventsTo", 1)
-		})
-	}
-}
-
-func TestBatcher_Loop_EnsureLongRunningOperationsAreStillMarkedDone_Watcher(t *testing.T) {
+func TestBatcher_Flush_EachDimensionIsLimitedByItsRateLimiter(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	requestUnits := gobatcher.NewSharedResource().
+		WithReservedCapacity(10000)
+	requests := gobatcher.NewSharedResource().
+		WithReservedCapacity(100)
 	batcher := gobatcher.NewBatcher().
-		WithFlushInterval(1 * time.Millisecond)
+		WithRateLimiters(
+			gobatcher.NewDimensionalRateLimiter("ru", requestUnits),
+			gobatcher.NewDimensionalRateLimiter("requests", requests),
+		).
+		WithFlushInterval(100 * time.Millisecond)
+	var processed uint32
 	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
-		// NOTE: simulate a long-running operation
-		time.Sleep(200 * time.Millisecond)
-	}).WithMaxOperationTime(50 * time.Millisecond)
-	op := gobatcher.NewOperation(watcher, 100, struct{}{}, false)
-	err := batcher.Enqueue(op)
-	assert.NoError(t, err, "not expecting an enqueue error")
-	before := batcher.NeedsCapacity()
+		atomic.AddUint32(&processed, uint32(len(batch)))
+	})
+	err := batcher.Enqueue(gobatcher.NewOperationWithCosts(watcher, map[string]uint32{"ru": 20000, "requests": 1}, struct{}{}, false))
+	assert.Equal(t, gobatcher.TooExpensiveError, err, "expecting the max capacity of each dimension to be enforced")
+	for i := 0; i < 20; i++ {
+		op := gobatcher.NewOperationWithCosts(watcher, map[string]uint32{"ru": 50, "requests": 1}, struct{}{}, false)
+		assert.Equal(t, uint32(50), op.Cost(), "expecting the cost to be the largest of the costs")
+		err = batcher.Enqueue(op)
+		assert.NoError(t, err, "not expecting an enqueue error")
+	}
+	err = requestUnits.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	err = requests.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
 	err = batcher.Start(ctx)
 	assert.NoError(t, err, "not expecting a start error")
-	time.Sleep(100 * time.Millisecond)
-	after := batcher.NeedsCapacity()
-	assert.Equal(t, uint32(100), before, "expecting 100 capacity request before starting")
-	assert.Equal(t, uint32(0), after, "expecting 0 capacity request after max-operation-time")
+	time.Sleep(150 * time.Millisecond)
+	assert.Equal(t, uint32(10), atomic.LoadUint32(&processed), "expecting the requests dimension to limit the flush")
 }
 
-func TestBatcher_Loop_EnsureLongRunningOperationsAreStillMarkedDone_Batcher(t *testing.T) {
+func TestBatcher_Flush_HappensAsSoonAsCapacityIsGranted(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	mgr := &mockLeaseManager{}
+	mgr.On("RaiseEventsTo", mock.Anything)
+	mgr.On("Provision", mock.Anything).Return(nil)
+	mgr.On("CreatePartitions", mock.Anything, 10)
+	mgr.On("LeasePartition", mock.Anything, mock.Anything, mock.Anything).Return(15 * time.Second)
+	res := gobatcher.NewSharedResource().
+		WithSharedCapacity(10000, mgr).
+		WithFactor(1000).
+		WithMaxInterval(1)
+	err := res.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	// NOTE: capacity is only requested manually so that the first flush is starved
 	batcher := gobatcher.NewBatcher().
-		WithFlushInterval(1 * time.Millisecond).
-		WithMaxOperationTime(50 * time.Millisecond)
+		WithRateLimiter(res).
+		WithFlushInterval(5 * time.Second).
+		WithCapacityInterval(1 * time.Hour).
+		WithEmitFlush()
+	starved := make(chan struct{}, 1)
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		if event == gobatcher.FlushDoneEvent && metadata.(gobatcher.FlushStats).Capacity == 0 {
+			select {
+			case starved <- struct{}{}:
+			default:
+			}
+		}
+	})
+	done := make(chan struct{})
 	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
-		// NOTE: simulate a long-running operation
-		time.Sleep(200 * time.Millisecond)
+		close(done)
 	})
-	op := gobatcher.NewOperation(watcher, 100, struct{}{}, false)
-	err := batcher.Enqueue(op)
+	err = batcher.Enqueue(gobatcher.NewOperation(watcher, 100, struct{}{}, false))
 	assert.NoError(t, err, "not expecting an enqueue error")
-	before := batcher.NeedsCapacity()
 	err = batcher.Start(ctx)
 	assert.NoError(t, err, "not expecting a start error")
-	time.Sleep(100 * time.Millisecond)
-	after := batcher.NeedsCapacity()
-	assert.Equal(t, uint32(100), before, "expecting 100 capacity request before starting")
-	assert.Equal(t, uint32(0), after, "expecting 0 capacity request after max-operation-time")
+	batcher.Flush()
+	<-starved
+	started := time.Now()
+	res.GiveMe(1000)
+	select {
+	case <-done:
+		assert.Less(t, time.Since(started), 4*time.Second, "expecting the operation to be dispatched before the next flush interval")
+	case <-time.After(4 * time.Second):
+		assert.Fail(t, "expecting the operation to be dispatched as soon as capacity was granted")
+	}
 }
 
-func TestBatcher_Loop_EnsureLongRunningOperationsAreNotMarkedDoneBefore1mDefault(t *testing.T) {
+func TestBatcher_Flush_WatchersDrawFromTheirOwnRateLimiters(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	small := gobatcher.NewSharedResource().
+		WithReservedCapacity(1000)
+	large := gobatcher.NewSharedResource().
+		WithReservedCapacity(10000)
+	err := small.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	err = large.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
 	batcher := gobatcher.NewBatcher().
-		WithFlushInterval(1 * time.Millisecond)
-	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
-		// NOTE: simulate a long-running operation
-		time.Sleep(400 * time.Millisecond)
-	})
-	op := gobatcher.NewOperation(watcher, 100, struct{}{}, false)
-	err := batcher.Enqueue(op)
-	assert.NoError(t, err, "not expecting an enqueue error")
-	before := batcher.NeedsCapacity()
+		WithFlushInterval(100 * time.Millisecond)
+	var fromSmall, fromLarge uint32
+	smallWatcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		atomic.AddUint32(&fromSmall, uint32(len(batch)))
+	}).WithRateLimiter(small)
+	largeWatcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		atomic.AddUint32(&fromLarge, uint32(len(batch)))
+	}).WithRateLimiter(large)
+	for i := 0; i < 5; i++ {
+		err = batcher.Enqueue(gobatcher.NewOperation(smallWatcher, 100, struct{}{}, false))
+		assert.NoError(t, err, "not expecting an enqueue error")
+		err = batcher.Enqueue(gobatcher.NewOperation(largeWatcher, 100, struct{}{}, false))
+		assert.NoError(t, err, "not expecting an enqueue error")
+	}
+	assert.Equal(t, uint32(1000), batcher.NeedsCapacity())
 	err = batcher.Start(ctx)
 	assert.NoError(t, err, "not expecting a start error")
-	time.Sleep(200 * time.Millisecond)
-	after := batcher.NeedsCapacity()
-	assert.Equal(t, uint32(100), before, "expecting 100 capacity request before starting")
-	assert.Equal(t, uint32(100), after, "expecting 100 capacity request after 200 milliseconds")
+	time.Sleep(150 * time.Millisecond)
+	assert.Equal(t, uint32(1), atomic.LoadUint32(&fromSmall), "expecting the small rate limiter to allow 1 operation per flush")
+	assert.Equal(t, uint32(5), atomic.LoadUint32(&fromLarge), "expecting the large rate limiter to allow every operation")
 }
 
-func TestBatcher_Audit_DemonstrateAnAuditPass(t *testing.T) {
+func TestBatcher_Start_IntervalsCanBeChangedAfterStart(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	batcher := gobatcher.NewBatcher().
-		WithFlushInterval(1 * time.Millisecond).
-		WithAuditInterval(1 * time.Millisecond).
-		WithMaxOperationTime(1 * time.Millisecond)
-	var passed, failed uint32
-	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
-		switch event {
-		case gobatcher.AuditPassEvent:
-			atomic.AddUint32(&passed, 1)
-		case gobatcher.AuditFailEvent:
-			atomic.AddUint32(&failed, 1)
-		}
+		WithFlushInterval(1 * time.Hour).
+		WithCapacityInterval(1 * time.Hour)
+	done := make(chan struct{})
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		close(done)
 	})
-	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {})
-	op := gobatcher.NewOperation(watcher, 100, struct{}{}, false)
-	err := batcher.Enqueue(op)
+	err := batcher.Enqueue(gobatcher.NewOperation(watcher, 0, struct{}{}, false))
 	assert.NoError(t, err, "not expecting an enqueue error")
 	err = batcher.Start(ctx)
 	assert.NoError(t, err, "not expecting a start error")
-	time.Sleep(100 * time.Millisecond)
-	assert.Greater(t, atomic.LoadUint32(&passed), uint32(0), "expecting audit-pass because done() was called before max-operation-time (1m default)")
-	assert.Equal(t, uint32(0), atomic.LoadUint32(&failed), "expecting no audit-fail messages")
+	assert.NotPanics(t, func() {
+		batcher.WithFlushInterval(1 * time.Millisecond).WithCapacityInterval(1 * time.Millisecond)
+	})
+	select {
+	case <-done:
+	case <-time.After(1 * time.Second):
+		assert.Fail(t, "expecting the operation to be flushed at the new interval")
+	}
 }
 
-func TestBatcher_Audit_DemonstrateAnAuditFail_Target(t *testing.T) {
-	// NOTE: this sets a batcher max-op-time to 1ms and a watcher max-op-time to 1m allowing for the batch to be around longer than it thinks it should be
+func TestBatcher_Start_MaxConcurrentBatchesCanBeChangedAfterStart(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	batcher := gobatcher.NewBatcher().
 		WithFlushInterval(1 * time.Millisecond).
-		WithAuditInterval(1 * time.Millisecond).
-		WithMaxOperationTime(1 * time.Millisecond)
-	var failed uint32
-	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
-		if event == gobatcher.AuditFailEvent && msg == gobatcher.AuditMsgFailureOnTarget {
-			atomic.AddUint32(&failed, 1)
-		}
-	})
+		WithMaxConcurrentBatches(1)
+	release := make(chan struct{})
+	var started uint32
 	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
-		time.Sleep(20 * time.Millisecond)
-	}).WithMaxOperationTime(1 * time.Minute)
-	op := gobatcher.NewOperation(watcher, 100, struct{}{}, false)
-	err := batcher.Enqueue(op)
-	assert.NoError(t, err, "not expecting an enqueue error")
-	err = batcher.Start(ctx)
+		atomic.AddUint32(&started, 1)
+		<-release
+	})
+	for i := 0; i < 3; i++ {
+		err := batcher.Enqueue(gobatcher.NewOperation(watcher, 0, struct{}{}, false))
+		assert.NoError(t, err, "not expecting an enqueue error")
+	}
+	err := batcher.Start(ctx)
 	assert.NoError(t, err, "not expecting a start error")
-	time.Sleep(100 * time.Millisecond)
-	assert.Greater(t, atomic.LoadUint32(&failed), uint32(0), "expecting an audit failure because done() was not called and max-operation-time was exceeded")
-	assert.Equal(t, uint32(0), batcher.NeedsCapacity())
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, uint32(1), atomic.LoadUint32(&started), "expecting only 1 batch at a time")
+	batcher.WithMaxConcurrentBatches(3)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, uint32(3), atomic.LoadUint32(&started), "expecting the remaining batches to start once the limit was raised")
+	assert.Equal(t, uint32(3), batcher.Inflight())
+	close(release)
 }
 
-func TestBatcher_Audit_DemonstrateAnAuditFail_InFlight(t *testing.T) {
-	// NOTE: this sets a batcher max-op-time to 1ms and a watcher max-op-time to 1m allowing for the batch to be around longer than it thinks it should be
+func TestBatcher_Loop_Shutdown(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	batcher := gobatcher.NewBatcher().
-		WithFlushInterval(1 * time.Millisecond).
-		WithAuditInterval(1 * time.Millisecond).
-		WithMaxOperationTime(1 * time.Millisecond).
-		WithMaxConcurrentBatches(1) // ensures there can be inflight errors
-	var failed uint32
+	batcher := gobatcher.NewBatcher()
+	done := make(chan bool)
 	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
-		if event == gobatcher.AuditFailEvent && msg == gobatcher.AuditMsgFailureOnInflight {
-			atomic.AddUint32(&failed, 1)
+		switch event {
+		case gobatcher.ShutdownEvent:
+			close(done)
 		}
 	})
-	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
-		time.Sleep(20 * time.Millisecond)
-	}).WithMaxOperationTime(1 * time.Minute)
-	op := gobatcher.NewOperation(watcher, 0, struct{}{}, false)
-	err := batcher.Enqueue(op)
-	assert.NoError(t, err, "not expecting an enqueue error")
-	err = batcher.Start(ctx)
+	err := batcher.Start(ctx)
 	assert.NoError(t, err, "not expecting a start error")
-	time.Sleep(100 * time.Millisecond)
-	assert.Greater(t, atomic.LoadUint32(&failed), uint32(0), "expecting an audit failure because done() was not called and max-operation-time was exceeded")
-	assert.Equal(t, uint32(0), batcher.Inflight())
+	cancel()
+	select {
+	case <-done:
+		// success
+	case <-time.After(1 * time.Second):
+		// timeout
+		assert.Fail(t, "expected shutdown but didn't see one even after 1 second")
+	}
 }
 
-func TestBatcher_Audit_DemonstrateAnAuditFail_TargetAndInFlight(t *testing.T) {
-	// NOTE: this sets a batcher max-op-time to 1ms and a watcher max-op-time to 1m allowing for the batch to be around longer than it thinks it should be
+func TestBatcher_Enqueue_RequireStarted(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	batcher := gobatcher.NewBatcher().
-		WithFlushInterval(1 * time.Millisecond).
-		WithAuditInterval(1 * time.Millisecond).
-		WithMaxOperationTime(1 * time.Millisecond).
-		WithMaxConcurrentBatches(1) // ensures there can be inflight errors
-	var failed uint32
+		WithRequireStarted()
+	var preStart uint32
 	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
-		if event == gobatcher.AuditFailEvent && msg == gobatcher.AuditMsgFailureOnTargetAndInflight {
-			atomic.AddUint32(&failed, 1)
+		if event == gobatcher.PreStartEnqueueEvent {
+			atomic.AddUint32(&preStart, 1)
 		}
 	})
-	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
-		time.Sleep(20 * time.Millisecond)
-	}).WithMaxOperationTime(1 * time.Minute)
-	op := gobatcher.NewOperation(watcher, 100, struct{}{}, false)
-	err := batcher.Enqueue(op)
-	assert.NoError(t, err, "not expecting an enqueue error")
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {})
+	err := batcher.Enqueue(gobatcher.NewOperation(watcher, 1, struct{}{}, false))
+	assert.Equal(t, gobatcher.NotStartedError, err)
+	err = batcher.EnqueueMany([]gobatcher.Operation{gobatcher.NewOperation(watcher, 1, struct{}{}, false)})
+	assert.Equal(t, gobatcher.NotStartedError, err)
+	assert.Equal(t, uint32(0), batcher.NeedsCapacity(), "expecting the target to be unchanged")
 	err = batcher.Start(ctx)
 	assert.NoError(t, err, "not expecting a start error")
-	time.Sleep(100 * time.Millisecond)
-	assert.Greater(t, atomic.LoadUint32(&failed), uint32(0), "expecting an audit failure because done() was not called and max-operation-time was exceeded")
-	assert.Equal(t, uint32(0), batcher.NeedsCapacity())
-	assert.Equal(t, uint32(0), batcher.Inflight())
+	err = batcher.Enqueue(gobatcher.NewOperation(watcher, 1, struct{}{}, false))
+	assert.NoError(t, err, "not expecting an enqueue error after start")
+	assert.Equal(t, uint32(0), atomic.LoadUint32(&preStart), "not expecting a pre-start-enqueue event in strict mode")
 }
 
-func TestBatcher_Audit_DemonstrateAnAuditSkip(t *testing.T) {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	batcher := gobatcher.NewBatcher().
-		WithFlushInterval(1 * time.Millisecond).
-		WithAuditInterval(1 * time.Millisecond)
-	var skipped uint32
+func TestBatcher_Enqueue_BeforeStartRaisesEventOnce(t *testing.T) {
+	batcher := gobatcher.NewBatcher()
+	var preStart uint32
 	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
-		switch event {
-		case gobatcher.AuditSkipEvent:
-			atomic.AddUint32(&skipped, 1)
+		if event == gobatcher.PreStartEnqueueEvent {
+			atomic.AddUint32(&preStart, 1)
 		}
 	})
-	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
-		time.Sleep(20 * time.Millisecond)
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {})
+	for i := 0; i < 3; i++ {
+		err := batcher.Enqueue(gobatcher.NewOperation(watcher, 1, struct{}{}, false))
+		assert.NoError(t, err, "not expecting an enqueue error before start")
+	}
+	assert.Equal(t, uint32(1), atomic.LoadUint32(&preStart))
+}
+
+func TestBatcher_EnqueueMany_ReturnsErrorPerOperation(t *testing.T) {
+	batcher := gobatcher.NewBatcherWithBuffer(2).
+		WithErrorOnFullBuffer()
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {})
+	ops := []gobatcher.Operation{
+		gobatcher.NewOperation(watcher, 10, struct{}{}, false),
+		nil,
+		gobatcher.NewOperation(watcher, 20, struct{}{}, false),
+		gobatcher.NewOperation(watcher, 30, struct{}{}, false),
+	}
+	err := batcher.EnqueueMany(ops)
+	var manyErr *gobatcher.EnqueueManyError
+	if assert.ErrorAs(t, err, &manyErr) {
+		assert.Equal(t, []error{nil, gobatcher.NoOperationError, nil, gobatcher.BufferFullError}, manyErr.Errors)
+	}
+	assert.ErrorIs(t, err, gobatcher.NoOperationError, "expecting the first error to be unwrapped")
+	assert.Equal(t, uint32(2), batcher.OperationsInBuffer())
+	assert.Equal(t, uint32(30), batcher.NeedsCapacity(), "expecting the target to only include the enqueued operations")
+}
+
+func TestBatcher_EnqueueMany_AllEnqueued(t *testing.T) {
+	batcher := gobatcher.NewBatcher()
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {})
+	ops := make([]gobatcher.Operation, 100)
+	for i := range ops {
+		ops[i] = gobatcher.NewOperation(watcher, 1, i, true)
+	}
+	err := batcher.EnqueueMany(ops)
+	assert.NoError(t, err, "not expecting an enqueue error")
+	assert.Equal(t, uint32(100), batcher.OperationsInBuffer())
+	assert.Equal(t, uint32(100), batcher.NeedsCapacity())
+}
+
+func TestBatcher_EnqueueWithContext_UnblocksOnCancel(t *testing.T) {
+	batcher := gobatcher.NewBatcherWithBuffer(1)
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {})
+	err := batcher.Enqueue(gobatcher.NewOperation(watcher, 10, struct{}{}, false))
+	assert.NoError(t, err, "not expecting an enqueue error")
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+	go func() {
+		result <- batcher.EnqueueWithContext(ctx, gobatcher.NewOperation(watcher, 20, struct{}{}, false))
+	}()
+	select {
+	case <-result:
+		assert.Fail(t, "expecting the enqueue to block because the buffer is full")
+	case <-time.After(10 * time.Millisecond):
+	}
+	cancel()
+	select {
+	case err := <-result:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(1 * time.Second):
+		assert.Fail(t, "expecting the enqueue to unblock when the context was cancelled")
+	}
+	assert.Equal(t, uint32(10), batcher.NeedsCapacity(), "expecting the target to exclude the operation that was not enqueued")
+}
+
+func TestBatcher_Loop_ListenersEventReportsCount(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	batcher := gobatcher.NewBatcher()
+	counts := make(chan int, 2)
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		if event == gobatcher.ListenersEvent {
+			counts <- val
+		}
 	})
-	var err error
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {})
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	cancel()
+	for i := 0; i < 2; i++ {
+		select {
+		case count := <-counts:
+			assert.Equal(t, 2, count, "expecting both listeners to be counted")
+		case <-time.After(1 * time.Second):
+			assert.Fail(t, "expected a listeners event at start and shutdown")
+		}
+	}
+}
+
+func TestBatcher_Shutdown_DrainsBeforeStopping(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Millisecond)
+	shutdown := make(chan struct{})
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		if event == gobatcher.ShutdownEvent {
+			close(shutdown)
+		}
+	})
+	release := make(chan struct{})
+	var processed uint32
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		<-release
+		atomic.AddUint32(&processed, uint32(len(batch)))
+	})
+	for i := 0; i < 10; i++ {
+		err := batcher.Enqueue(gobatcher.NewOperation(watcher, 0, struct{}{}, false))
+		assert.NoError(t, err, "not expecting an enqueue error")
+	}
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	done := make(chan error, 1)
+	go func() {
+		done <- batcher.Shutdown(context.Background())
+	}()
+
+	// new operations are rejected while draining
+	assert.Eventually(t, func() bool {
+		err := batcher.Enqueue(gobatcher.NewOperation(watcher, 0, struct{}{}, false))
+		return errors.Is(err, gobatcher.BufferIsShutdown)
+	}, 1*time.Second, 1*time.Millisecond, "expecting enqueue to be rejected while draining")
+	select {
+	case <-done:
+		assert.FailNow(t, "not expecting shutdown to finish while batches are inflight")
+	case <-shutdown:
+		assert.FailNow(t, "not expecting a shutdown event while batches are inflight")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// shutdown finishes once the watcher is done
+	close(release)
+	select {
+	case err := <-done:
+		assert.NoError(t, err, "not expecting a shutdown error")
+	case <-time.After(1 * time.Second):
+		assert.FailNow(t, "expected shutdown to finish")
+	}
+	assert.Equal(t, uint32(10), atomic.LoadUint32(&processed), "expecting every operation to be processed")
+	select {
+	case <-shutdown:
+	default:
+		assert.Fail(t, "expected a shutdown event before Shutdown() returned")
+	}
+	assert.ErrorIs(t, batcher.Shutdown(context.Background()), gobatcher.ImproperOrderError)
+}
+
+func TestBatcher_Shutdown_AcceptsRetriesWhileDraining(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Millisecond)
+	release := make(chan struct{})
+	var attempts uint32
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		<-release
+		for _, op := range batch {
+			if atomic.AddUint32(&attempts, 1) == 1 {
+				op.SetResult(gobatcher.Failed(errors.New("transient")))
+				err := batcher.Enqueue(op)
+				assert.NoError(t, err, "expecting a retry to be accepted while draining")
+			}
+		}
+	}).WithMaxAttempts(2)
+	op := gobatcher.NewOperation(watcher, 0, struct{}{}, false)
+	err := batcher.Enqueue(op)
+	assert.NoError(t, err, "not expecting an enqueue error")
+	err = batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	done := make(chan error, 1)
+	go func() {
+		done <- batcher.Shutdown(context.Background())
+	}()
+	assert.Eventually(t, func() bool {
+		err := batcher.Enqueue(gobatcher.NewOperation(watcher, 0, struct{}{}, false))
+		return errors.Is(err, gobatcher.BufferIsShutdown)
+	}, 1*time.Second, 1*time.Millisecond, "expecting new operations to be rejected while draining")
+	close(release)
+	select {
+	case err := <-done:
+		assert.NoError(t, err, "not expecting a shutdown error")
+	case <-time.After(1 * time.Second):
+		assert.FailNow(t, "expected shutdown to finish")
+	}
+	assert.Equal(t, uint32(2), atomic.LoadUint32(&attempts), "expecting the retry to be processed before stopping")
+	assert.Equal(t, gobatcher.ResultSucceeded, op.Result().Status)
+}
+
+func TestBatcher_Shutdown_EndsAnIndefinitePause(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Millisecond)
+	var processed uint32
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		atomic.AddUint32(&processed, uint32(len(batch)))
+	})
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	batcher.PauseFor(0)
+	err = batcher.Enqueue(gobatcher.NewOperation(watcher, 0, struct{}{}, false))
+	assert.NoError(t, err, "not expecting an enqueue error")
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer shutdownCancel()
+	err = batcher.Shutdown(shutdownCtx)
+	assert.NoError(t, err, "expecting the pause to end so the buffer can be drained")
+	assert.Equal(t, uint32(1), atomic.LoadUint32(&processed))
+}
+
+func TestBatcher_Shutdown_ResumesPausedWatchers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Millisecond)
+	var processed uint32
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		atomic.AddUint32(&processed, uint32(len(batch)))
+	})
+	var resumes uint32
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		atomic.AddUint32(&resumes, 1)
+	}, gobatcher.ForWatcher(watcher), gobatcher.OnlyEvents(gobatcher.ResumeEvent))
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	batcher.PauseWatcher(watcher, 0)
+	err = batcher.Enqueue(gobatcher.NewOperation(watcher, 0, struct{}{}, false))
+	assert.NoError(t, err, "not expecting an enqueue error")
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer shutdownCancel()
+	err = batcher.Shutdown(shutdownCtx)
+	assert.NoError(t, err, "expecting the watcher to be resumed so the buffer can be drained")
+	assert.Equal(t, uint32(1), atomic.LoadUint32(&processed))
+	assert.Equal(t, uint32(1), atomic.LoadUint32(&resumes), "expecting a resume event for the watcher")
+}
+
+func TestBatcher_Shutdown_ReturnsContextErrorWhenNotDrained(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Millisecond)
+	var shutdowns uint32
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		if event == gobatcher.ShutdownEvent {
+			atomic.AddUint32(&shutdowns, 1)
+		}
+	})
+	release := make(chan struct{})
+	defer close(release)
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		<-release
+	})
+	err := batcher.Enqueue(gobatcher.NewOperation(watcher, 0, struct{}{}, false))
+	assert.NoError(t, err, "not expecting an enqueue error")
+	err = batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer shutdownCancel()
+	err = batcher.Shutdown(shutdownCtx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, uint32(1), atomic.LoadUint32(&shutdowns), "expecting the batcher to stop anyway")
+}
+
+func TestBatcher_Loop_ClearListenersOnShutdown(t *testing.T) {
+	testCases := map[string]struct {
+		clear  bool
+		expect int
+	}{
+		"cleared":  {clear: true, expect: 0},
+		"retained": {clear: false, expect: 1},
+	}
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			batcher := gobatcher.NewBatcher()
+			if testCase.clear {
+				batcher.WithClearListenersOnShutdown()
+			}
+			done := make(chan struct{})
+			batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+				if event == gobatcher.ShutdownEvent {
+					close(done)
+				}
+			})
+			err := batcher.Start(ctx)
+			assert.NoError(t, err, "not expecting a start error")
+			cancel()
+			select {
+			case <-done:
+			case <-time.After(1 * time.Second):
+				assert.Fail(t, "expected shutdown but didn't see one even after 1 second")
+			}
+			assert.Eventually(t, func() bool {
+				return batcher.ListenerCount() == testCase.expect
+			}, time.Second, 10*time.Millisecond)
+		})
+	}
+}
+
+func TestBatcher_Loop_EnsureOperationsAreFlushedInExpectedTimes(t *testing.T) {
+	testCases := map[string]struct {
+		interval time.Duration
+		enqueue  int
+		wait     time.Duration
+		expect   uint32
+	}{
+		"-200ms (default to 100)": {interval: -200 * time.Millisecond, enqueue: 4, wait: 250 * time.Millisecond, expect: 2},
+		"100ms (default)":         {interval: 0 * time.Millisecond, enqueue: 4, wait: 250 * time.Millisecond, expect: 2},
+		"300ms":                   {interval: 300 * time.Millisecond, enqueue: 4, wait: 650 * time.Millisecond, expect: 2},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			res := gobatcher.NewSharedResource().
+				WithReservedCapacity(100)
+			batcher := gobatcher.NewBatcher().
+				WithRateLimiter(res).
+				WithFlushInterval(testCase.interval)
+			var count uint32 = 0
+			watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+				atomic.AddUint32(&count, uint32(len(batch)))
+			})
+			for i := 0; i < testCase.enqueue; i++ {
+				op := gobatcher.NewOperation(watcher, 100, struct{}{}, false)
+				err := batcher.Enqueue(op)
+				assert.NoError(t, err, "not expecting an enqueue error")
+			}
+			err := batcher.Start(ctx)
+			assert.NoError(t, err, "not expecting a start error")
+			time.Sleep(testCase.wait)
+			assert.Equal(t, testCase.expect, atomic.LoadUint32(&count), "expecting %v operations to be completed given the %v interval and capacity for only a single operation", testCase.interval, testCase.expect)
+		})
+	}
+}
+
+func TestBatcher_Loop_EnsureCapacityRequestsAreRaisedInExpectedTimes(t *testing.T) {
+	testCases := map[string]struct {
+		interval time.Duration
+		wait     time.Duration
+		expect   uint32
+	}{
+		"-200ms (default to 100ms)": {interval: 0 * time.Millisecond, wait: 250 * time.Millisecond, expect: 2},
+		"100ms (default)":           {interval: 0 * time.Millisecond, wait: 250 * time.Millisecond, expect: 2},
+		"300ms":                     {interval: 300 * time.Millisecond, wait: 650 * time.Millisecond, expect: 2},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			mgr := &mockLeaseManager{}
+			mgr.On("RaiseEventsTo", mock.Anything)
+			res := gobatcher.NewSharedResource().
+				WithSharedCapacity(10000, mgr).
+				WithFactor(1000)
+			batcher := gobatcher.NewBatcher().
+				WithRateLimiter(res).
+				WithCapacityInterval(testCase.interval).
+				WithEmitRequest()
+			var count uint32 = 0
+			batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+				switch event {
+				case gobatcher.RequestEvent:
+					atomic.AddUint32(&count, 1)
+				}
+			})
+			watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {})
+			op := gobatcher.NewOperation(watcher, 800, struct{}{}, false)
+			err := batcher.Enqueue(op)
+			assert.NoError(t, err, "not expecting an enqueue error")
+			err = batcher.Start(ctx)
+			assert.NoError(t, err, "not expecting a start error")
+			time.Sleep(testCase.wait)
+			assert.Equal(t, testCase.expect, atomic.LoadUint32(&count), "expecting %v capacity requests given the %v interval and capacity for only a single operation", testCase.interval, testCase.expect)
+			mgr.AssertNumberOfCalls(t, "RaiseEventsTo", 1)
+		})
+	}
+}
+
+func TestBatcher_Loop_EnsureLongRunningOperationsAreStillMarkedDone_Watcher(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Millisecond)
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		// NOTE: simulate a long-running operation
+		time.Sleep(200 * time.Millisecond)
+	}).WithMaxOperationTime(50 * time.Millisecond)
 	op := gobatcher.NewOperation(watcher, 100, struct{}{}, false)
-	err = batcher.Enqueue(op)
+	err := batcher.Enqueue(op)
 	assert.NoError(t, err, "not expecting an enqueue error")
+	before := batcher.NeedsCapacity()
 	err = batcher.Start(ctx)
 	assert.NoError(t, err, "not expecting a start error")
 	time.Sleep(100 * time.Millisecond)
-	assert.Greater(t, atomic.LoadUint32(&skipped), uint32(0), "expect that something in the buffer but max-operation-time is still valid, will cause skips")
+	after := batcher.NeedsCapacity()
+	assert.Equal(t, uint32(100), before, "expecting 100 capacity request before starting")
+	assert.Equal(t, uint32(0), after, "expecting 0 capacity request after max-operation-time")
+}
+
+func TestBatcher_Loop_EnsureLongRunningOperationsAreStillMarkedDone_Batcher(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Millisecond).
+		WithMaxOperationTime(50 * time.Millisecond)
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		// NOTE: simulate a long-running operation
+		time.Sleep(200 * time.Millisecond)
+	})
+	op := gobatcher.NewOperation(watcher, 100, struct{}{}, false)
+	err := batcher.Enqueue(op)
+	assert.NoError(t, err, "not expecting an enqueue error")
+	before := batcher.NeedsCapacity()
+	err = batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	time.Sleep(100 * time.Millisecond)
+	after := batcher.NeedsCapacity()
+	assert.Equal(t, uint32(100), before, "expecting 100 capacity request before starting")
+	assert.Equal(t, uint32(0), after, "expecting 0 capacity request after max-operation-time")
+}
+
+func TestBatcher_Loop_EnsureLongRunningOperationsAreNotMarkedDoneBefore1mDefault(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Millisecond)
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		// NOTE: simulate a long-running operation
+		time.Sleep(400 * time.Millisecond)
+	})
+	op := gobatcher.NewOperation(watcher, 100, struct{}{}, false)
+	err := batcher.Enqueue(op)
+	assert.NoError(t, err, "not expecting an enqueue error")
+	before := batcher.NeedsCapacity()
+	err = batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	time.Sleep(200 * time.Millisecond)
+	after := batcher.NeedsCapacity()
+	assert.Equal(t, uint32(100), before, "expecting 100 capacity request before starting")
+	assert.Equal(t, uint32(100), after, "expecting 100 capacity request after 200 milliseconds")
+}
+
+func TestBatcher_Audit_DemonstrateAnAuditPass(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Millisecond).
+		WithAuditInterval(1 * time.Millisecond).
+		WithMaxOperationTime(1 * time.Millisecond)
+	var passed, failed uint32
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		switch event {
+		case gobatcher.AuditPassEvent:
+			atomic.AddUint32(&passed, 1)
+		case gobatcher.AuditFailEvent:
+			atomic.AddUint32(&failed, 1)
+		}
+	})
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {})
+	op := gobatcher.NewOperation(watcher, 100, struct{}{}, false)
+	err := batcher.Enqueue(op)
+	assert.NoError(t, err, "not expecting an enqueue error")
+	err = batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	time.Sleep(100 * time.Millisecond)
+	assert.Greater(t, atomic.LoadUint32(&passed), uint32(0), "expecting audit-pass because done() was called before max-operation-time (1m default)")
+	assert.Equal(t, uint32(0), atomic.LoadUint32(&failed), "expecting no audit-fail messages")
+}
+
+func TestBatcher_Audit_DemonstrateAnAuditFail_Target(t *testing.T) {
+	// NOTE: this sets a batcher max-op-time to 1ms and a watcher max-op-time to 1m allowing for the batch to be around longer than it thinks it should be
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Millisecond).
+		WithAuditInterval(1 * time.Millisecond).
+		WithMaxOperationTime(1 * time.Millisecond)
+	var failed uint32
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		if event == gobatcher.AuditFailEvent && msg == gobatcher.AuditMsgFailureOnTarget {
+			atomic.AddUint32(&failed, 1)
+		}
+	})
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		time.Sleep(20 * time.Millisecond)
+	}).WithMaxOperationTime(1 * time.Minute)
+	op := gobatcher.NewOperation(watcher, 100, struct{}{}, false)
+	err := batcher.Enqueue(op)
+	assert.NoError(t, err, "not expecting an enqueue error")
+	err = batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	time.Sleep(100 * time.Millisecond)
+	assert.Greater(t, atomic.LoadUint32(&failed), uint32(0), "expecting an audit failure because done() was not called and max-operation-time was exceeded")
+	assert.Equal(t, uint32(0), batcher.NeedsCapacity())
+}
+
+func TestBatcher_Audit_DemonstrateAnAuditFail_InFlight(t *testing.T) {
+	// NOTE: this sets a batcher max-op-time to 1ms and a watcher max-op-time to 1m allowing for the batch to be around longer than it thinks it should be
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Millisecond).
+		WithAuditInterval(1 * time.Millisecond).
+		WithMaxOperationTime(1 * time.Millisecond).
+		WithMaxConcurrentBatches(1) // ensures there can be inflight errors
+	var failed uint32
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		if event == gobatcher.AuditFailEvent && msg == gobatcher.AuditMsgFailureOnInflight {
+			atomic.AddUint32(&failed, 1)
+		}
+	})
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		time.Sleep(20 * time.Millisecond)
+	}).WithMaxOperationTime(1 * time.Minute)
+	op := gobatcher.NewOperation(watcher, 0, struct{}{}, false)
+	err := batcher.Enqueue(op)
+	assert.NoError(t, err, "not expecting an enqueue error")
+	err = batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	time.Sleep(100 * time.Millisecond)
+	assert.Greater(t, atomic.LoadUint32(&failed), uint32(0), "expecting an audit failure because done() was not called and max-operation-time was exceeded")
+	assert.Equal(t, uint32(0), batcher.Inflight())
+}
+
+func TestBatcher_Healthy_RepeatedAuditFailuresAreUnhealthy(t *testing.T) {
+	// NOTE: batches keep exceeding the batcher max-op-time of 1ms so each audit finds a new leak in the inflight count
+	ctx, cancel := context.WithCancel(context.Background())
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Millisecond).
+		WithAuditInterval(10 * time.Millisecond).
+		WithMaxOperationTime(1 * time.Millisecond).
+		WithMaxConcurrentBatches(1000) // ensures there can be inflight errors
+	release := make(chan struct{})
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		<-release
+	}).WithMaxOperationTime(1 * time.Minute)
+	assert.ErrorIs(t, batcher.Healthy(), gobatcher.NotRunningError, "expecting to not be healthy before start")
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	assert.NoError(t, batcher.Healthy(), "expecting to be healthy once started")
+	leaking := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-leaking:
+				return
+			case <-time.After(2 * time.Millisecond):
+				_ = batcher.Enqueue(gobatcher.NewOperation(watcher, 0, struct{}{}, false))
+			}
+		}
+	}()
+	assert.Eventually(t, func() bool {
+		return errors.Is(batcher.Healthy(), gobatcher.AuditFailingError)
+	}, time.Second, time.Millisecond, "expecting to not be healthy while the audit keeps failing")
+	close(leaking)
+	close(release)
+	assert.Eventually(t, func() bool {
+		return batcher.Healthy() == nil
+	}, time.Second, time.Millisecond, "expecting to be healthy once the audit passes")
+	cancel()
+	assert.Eventually(t, func() bool {
+		return errors.Is(batcher.Healthy(), gobatcher.NotRunningError)
+	}, time.Second, time.Millisecond, "expecting to not be healthy once stopped")
+}
+
+func TestProbeHandler_RespondsWithTheCheck(t *testing.T) {
+	var healthErr error
+	handler := gobatcher.ProbeHandler(func() error { return healthErr })
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	healthErr = gobatcher.NotRunningError
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, gobatcher.NotRunningError.Error(), rec.Body.String())
+}
+
+func TestBatcher_Audit_DemonstrateAnAuditFail_TargetAndInFlight(t *testing.T) {
+	// NOTE: this sets a batcher max-op-time to 1ms and a watcher max-op-time to 1m allowing for the batch to be around longer than it thinks it should be
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Millisecond).
+		WithAuditInterval(1 * time.Millisecond).
+		WithMaxOperationTime(1 * time.Millisecond).
+		WithMaxConcurrentBatches(1) // ensures there can be inflight errors
+	var failed uint32
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		if event == gobatcher.AuditFailEvent && msg == gobatcher.AuditMsgFailureOnTargetAndInflight {
+			atomic.AddUint32(&failed, 1)
+		}
+	})
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		time.Sleep(20 * time.Millisecond)
+	}).WithMaxOperationTime(1 * time.Minute)
+	op := gobatcher.NewOperation(watcher, 100, struct{}{}, false)
+	err := batcher.Enqueue(op)
+	assert.NoError(t, err, "not expecting an enqueue error")
+	err = batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	time.Sleep(100 * time.Millisecond)
+	assert.Greater(t, atomic.LoadUint32(&failed), uint32(0), "expecting an audit failure because done() was not called and max-operation-time was exceeded")
+	assert.Equal(t, uint32(0), batcher.NeedsCapacity())
+	assert.Equal(t, uint32(0), batcher.Inflight())
+}
+
+func TestBatcher_Audit_DemonstrateAnAuditSkip(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Millisecond).
+		WithAuditInterval(1 * time.Millisecond)
+	var skipped uint32
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		switch event {
+		case gobatcher.AuditSkipEvent:
+			atomic.AddUint32(&skipped, 1)
+		}
+	})
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		time.Sleep(20 * time.Millisecond)
+	})
+	var err error
+	op := gobatcher.NewOperation(watcher, 100, struct{}{}, false)
+	err = batcher.Enqueue(op)
+	assert.NoError(t, err, "not expecting an enqueue error")
+	err = batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	time.Sleep(100 * time.Millisecond)
+	assert.Greater(t, atomic.LoadUint32(&skipped), uint32(0), "expect that something in the buffer but max-operation-time is still valid, will cause skips")
+}
+
+func TestBatcher_Flush(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var err error
+	batcher := gobatcher.NewBatcher().WithFlushInterval(10 * time.Minute)
+	err = batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	completed := make(chan bool, 1)
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		completed <- true
+	})
+	op := gobatcher.NewOperation(watcher, 100, struct{}{}, false)
+	err = batcher.Enqueue(op)
+	assert.NoError(t, err, "not expecting an enqueue error")
+	batcher.Flush()
+	select {
+	case <-completed:
+	case <-time.After(1 * time.Second):
+		assert.Fail(t, "expected the manual flush to have completed the batch before the timeout")
+	}
+}
+
+type TestMaxConcurrentBatchesSuite struct {
+	suite.Suite
+	batcher  gobatcher.Batcher
+	listener uuid.UUID
+	wg       *sync.WaitGroup
+	cancel   context.CancelFunc
+}
+
+func (s *TestMaxConcurrentBatchesSuite) BeforeTest(suiteName, testName string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.wg = &sync.WaitGroup{}
+	s.batcher = gobatcher.NewBatcher().
+		WithFlushInterval(10 * time.Minute).
+		WithEmitBatch().
+		WithEmitFlush()
+	switch testName {
+	case "TestBatcher_BatchPacking":
+		s.batcher.WithMaxConcurrentBatches(1)
+	default:
+		s.batcher.WithMaxConcurrentBatches(2)
+	}
+	s.listener = s.batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		switch event {
+		case gobatcher.FlushDoneEvent:
+			s.wg.Done()
+		case gobatcher.BatchEvent:
+			s.wg.Add(1)
+		}
+	})
+	err := s.batcher.Start(ctx)
+	s.NoError(err, "not expecting a start error")
+}
+
+func (s *TestMaxConcurrentBatchesSuite) TearDownTest() {
+	s.batcher.RemoveListener(s.listener)
+	s.cancel()
+}
+
+func (s *TestMaxConcurrentBatchesSuite) TestBatcher_ConcurrencyIsEnforced() {
+	var batches uint32
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		atomic.AddUint32(&batches, 1)
+		time.Sleep(15 * time.Millisecond) // NOTE: simulate a long-running operation
+		s.wg.Done()
+	})
+	for i := 0; i < 3; i++ {
+		op := gobatcher.NewOperation(watcher, 0, struct{}{}, false)
+		err := s.batcher.Enqueue(op)
+		s.NoError(err, "not expecting an enqueue error")
+	}
+	s.wg.Add(1)
+	s.batcher.Flush()
+	s.wg.Wait()
+	s.Equal(uint32(2), atomic.LoadUint32(&batches))
+	s.Equal(uint32(1), s.batcher.OperationsInBuffer())
+}
+
+func (s *TestMaxConcurrentBatchesSuite) TestBatcher_ConcurrencyIsEnforcedWithBatchable() {
+	var batches uint32
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		atomic.AddUint32(&batches, 1)
+		time.Sleep(15 * time.Millisecond) // NOTE: simulate a long-running operation
+		s.wg.Done()
+	}).WithMaxBatchSize(2)
+	for i := 0; i < 5; i++ {
+		op := gobatcher.NewOperation(watcher, 0, struct{}{}, true)
+		err := s.batcher.Enqueue(op)
+		s.NoError(err, "not expecting an enqueue error")
+	}
+	s.wg.Add(1)
+	s.batcher.Flush()
+	s.wg.Wait()
+	s.Equal(uint32(2), atomic.LoadUint32(&batches))
+	s.Equal(uint32(1), s.batcher.OperationsInBuffer())
+}
+
+func (s *TestMaxConcurrentBatchesSuite) TestBatcher_SlotsAreAvailableOnDone() {
+	var batches uint32
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		atomic.AddUint32(&batches, 1)
+		time.Sleep(15 * time.Millisecond) // NOTE: simulate a long-running operation
+		s.wg.Done()
+	})
+	for i := 0; i < 5; i++ {
+		op := gobatcher.NewOperation(watcher, 0, struct{}{}, false)
+		err := s.batcher.Enqueue(op)
+		s.NoError(err, "not expecting an enqueue error")
+	}
+	s.wg.Add(1)
+	s.batcher.Flush()
+	s.wg.Wait()
+	s.Equal(uint32(2), atomic.LoadUint32(&batches))
+	s.Equal(uint32(3), s.batcher.OperationsInBuffer())
+	s.wg.Add(1)
+	s.batcher.Flush()
+	s.wg.Wait()
+	s.Equal(uint32(4), atomic.LoadUint32(&batches))
+	s.Equal(uint32(1), s.batcher.OperationsInBuffer())
+}
+
+func (s *TestMaxConcurrentBatchesSuite) TestBatcher_RunningOpHoldsSlot() {
+	var batches uint32
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		atomic.AddUint32(&batches, 1)
+		s.wg.Done()
+		time.Sleep(15 * time.Millisecond) // NOTE: simulate a long-running operation
+	})
+	for i := 0; i < 3; i++ {
+		op := gobatcher.NewOperation(watcher, 0, struct{}{}, false)
+		err := s.batcher.Enqueue(op)
+		s.NoError(err, "not expecting an enqueue error")
+	}
+	s.wg.Add(1)
+	s.batcher.Flush()
+	s.wg.Wait()
+	s.wg.Add(1)
+	s.batcher.Flush()
+	s.wg.Wait()
+	s.Equal(uint32(2), atomic.LoadUint32(&batches))
+	s.Equal(uint32(1), s.batcher.OperationsInBuffer())
+}
+
+func (s *TestMaxConcurrentBatchesSuite) TestBatcher_BatchPacking() {
+	var err error
+	var batches uint32
+	watcher1 := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		s.Equal(2, len(batch))
+		atomic.AddUint32(&batches, 1)
+		s.wg.Done()
+	})
+	watcher2 := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		s.FailNow("expecting never to see this batch raised")
+	})
+	op1 := gobatcher.NewOperation(watcher1, 0, struct{}{}, true)
+	err = s.batcher.Enqueue(op1)
+	s.NoError(err, "not expecting an enqueue error")
+	op2 := gobatcher.NewOperation(watcher2, 0, struct{}{}, true)
+	err = s.batcher.Enqueue(op2)
+	s.NoError(err, "not expecting an enqueue error")
+	op3 := gobatcher.NewOperation(watcher1, 0, struct{}{}, true)
+	err = s.batcher.Enqueue(op3)
+	s.NoError(err, "not expecting an enqueue error")
+	s.wg.Add(1)
+	s.batcher.Flush()
+	s.wg.Wait()
+	s.Equal(uint32(1), atomic.LoadUint32(&batches), "expecting watcher1 to see a batch once with 2 items")
+	s.Equal(uint32(1), s.batcher.OperationsInBuffer())
+}
+
+func TestBatcher_MaxConcurrentBatches(t *testing.T) {
+	suite.Run(t, new(TestMaxConcurrentBatchesSuite))
+}
+
+func TestBatcher_Operation_PayloadIsValid(t *testing.T) {
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {})
+	payload := struct{}{}
+	operation := gobatcher.NewOperation(watcher, 0, payload, false)
+	assert.Equal(t, payload, operation.Payload())
+}
+
+func TestBatcher_Operation_IDAndMetadataAreAvailableToTheWatcher(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher()
+	annotated := make(chan gobatcher.Operation, 1)
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		for _, op := range batch {
+			op.WithMetadata(map[string]interface{}{"region": "westus"})
+		}
+	})
+	op := gobatcher.NewOperation(watcher, 0, struct{}{}, false).
+		WithID("request-1").
+		WithMetadata(map[string]interface{}{"tenant": "contoso", "region": "eastus"}).
+		WithOnComplete(func(op gobatcher.Operation, result gobatcher.Result) {
+			annotated <- op
+		})
+	assert.Equal(t, "request-1", op.ExternalID())
+	metadata := op.Metadata()
+	metadata["tenant"] = "changed"
+	assert.Equal(t, "contoso", op.Metadata()["tenant"], "expecting changes to the copy to not change the operation")
+	err := batcher.Enqueue(op)
+	assert.NoError(t, err, "not expecting an enqueue error")
+	err = batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a startup error")
+	select {
+	case completed := <-annotated:
+		assert.Equal(t, "request-1", completed.ExternalID())
+		assert.Equal(t, map[string]interface{}{"tenant": "contoso", "region": "westus"}, completed.Metadata(), "expecting the watcher to annotate the operation")
+	case <-time.After(1 * time.Second):
+		assert.Fail(t, "expecting the operation to be completed")
+	}
+}
+
+func TestBatcher_Operation_MetadataIsNilWhenNotProvided(t *testing.T) {
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {})
+	op := gobatcher.NewOperation(watcher, 0, struct{}{}, false)
+	assert.Equal(t, "", op.ExternalID())
+	assert.Nil(t, op.Metadata())
+}
+
+func TestBatcher_Operation_DeferredPayloadIsProducedOnceAtDispatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher()
+	var produced uint32
+	done := make(chan interface{}, 1)
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		assert.Equal(t, uint32(1), atomic.LoadUint32(&produced), "expecting the payload to be produced before the watcher sees it")
+		done <- batch[0].Payload()
+	})
+	op := gobatcher.NewDeferredOperation(watcher, 0, func() interface{} {
+		atomic.AddUint32(&produced, 1)
+		return "payload"
+	}, false)
+	err := batcher.Enqueue(op)
+	assert.NoError(t, err, "not expecting an enqueue error")
+	assert.Equal(t, uint32(0), atomic.LoadUint32(&produced), "expecting the payload to not be produced before dispatch")
+	err = batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a startup error")
+	select {
+	case payload := <-done:
+		assert.Equal(t, "payload", payload)
+	case <-time.After(1 * time.Second):
+		assert.Fail(t, "expecting the operation to be dispatched")
+	}
+	assert.Equal(t, uint32(1), atomic.LoadUint32(&produced), "expecting the payload to be produced only once")
+}
+
+func TestBatcher_Result_DefaultsToSucceeded(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Millisecond)
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	completed := make(chan gobatcher.Result, 1)
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {})
+	op := gobatcher.NewOperation(watcher, 100, struct{}{}, false).
+		WithOnComplete(func(op gobatcher.Operation, result gobatcher.Result) {
+			completed <- result
+		})
+	assert.Equal(t, gobatcher.ResultPending, op.Result().Status, "expecting a pending result before processing")
+	err = batcher.Enqueue(op)
+	assert.NoError(t, err, "not expecting an enqueue error")
+	select {
+	case <-op.Done():
+	case <-time.After(1 * time.Second):
+		assert.FailNow(t, "expected the operation to be done before the timeout")
+	}
+	result := <-completed
+	assert.Equal(t, gobatcher.ResultSucceeded, result.Status)
+	assert.Equal(t, "succeeded", result.Status.String())
+	assert.NoError(t, result.Err)
+	assert.Equal(t, uint32(100), result.ActualCost, "expecting actual cost to default to cost")
+	assert.Equal(t, uint32(1), result.Attempt)
+	assert.Equal(t, result, op.Result())
+}
+
+func TestBatcher_Result_WatcherCanAttachResult(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Millisecond)
+	deadLetters := make(chan gobatcher.Operation, 1)
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		if event == gobatcher.DeadLetterEvent {
+			assert.Equal(t, 2, val, "expecting the dead-letter val to be the attempts")
+			assert.Equal(t, "downstream failure", msg)
+			deadLetters <- metadata.(gobatcher.Operation)
+		}
+	})
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	failure := errors.New("downstream failure")
+	var op gobatcher.Operation
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		for _, entry := range batch {
+			entry.SetResult(gobatcher.Result{Status: gobatcher.ResultFailed, Err: failure, ActualCost: 7})
+			if entry.Attempt() < 2 {
+				assert.NoError(t, batcher.Enqueue(entry), "expecting a retry to be allowed")
+			}
+		}
+	}).WithMaxAttempts(2)
+	op = gobatcher.NewOperation(watcher, 100, struct{}{}, true)
+	err = batcher.Enqueue(op)
+	assert.NoError(t, err, "not expecting an enqueue error")
+	select {
+	case dead := <-deadLetters:
+		assert.Equal(t, op, dead)
+	case <-time.After(1 * time.Second):
+		assert.FailNow(t, "expected a dead-letter event before the timeout")
+	}
+	<-op.Done()
+	result := op.Result()
+	assert.Equal(t, gobatcher.ResultFailed, result.Status)
+	assert.Equal(t, failure, result.Err)
+	assert.Equal(t, uint32(7), result.ActualCost)
+	assert.Equal(t, uint32(2), result.Attempt)
+}
+
+func TestBatcher_Panic_RetriesUntilMaxAttemptsThenDeadLetters(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Millisecond)
+	var panics uint32
+	deadLetters := make(chan gobatcher.Operation, 1)
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		switch event {
+		case gobatcher.PanicEvent:
+			atomic.AddUint32(&panics, 1)
+			assert.Equal(t, "poison", metadata, "expecting the metadata to be the recovered value")
+		case gobatcher.DeadLetterEvent:
+			assert.Equal(t, 3, val, "expecting the dead-letter val to be the attempts")
+			deadLetters <- metadata.(gobatcher.Operation)
+		}
+	})
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		panic("poison")
+	}).WithMaxAttempts(3)
+	op := gobatcher.NewOperation(watcher, 100, struct{}{}, true)
+	err = batcher.Enqueue(op)
+	assert.NoError(t, err, "not expecting an enqueue error")
+	select {
+	case dead := <-deadLetters:
+		assert.Equal(t, op, dead)
+	case <-time.After(1 * time.Second):
+		assert.FailNow(t, "expected a dead-letter event before the timeout")
+	}
+	result := op.Result()
+	assert.Equal(t, gobatcher.ResultFailed, result.Status)
+	assert.True(t, errors.Is(result.Err, gobatcher.WatcherPanicError), "expecting the error to wrap WatcherPanicError")
+	assert.Equal(t, uint32(3), result.Attempt)
+	assert.Equal(t, uint32(3), atomic.LoadUint32(&panics))
+	assert.Eventually(t, func() bool { return batcher.NeedsCapacity() == 0 }, time.Second, time.Millisecond, "expecting the target to be released")
+}
+
+func TestBatcher_Panic_WithoutMaxAttemptsDeadLettersImmediately(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Millisecond)
+	deadLetters := make(chan gobatcher.Operation, 2)
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		if event == gobatcher.DeadLetterEvent {
+			deadLetters <- metadata.(gobatcher.Operation)
+		}
+	})
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		batch[0].SetResult(gobatcher.Succeeded())
+		panic(errors.New("poison"))
+	})
+	good := gobatcher.NewOperation(watcher, 100, struct{}{}, true)
+	bad := gobatcher.NewOperation(watcher, 100, struct{}{}, true)
+	assert.NoError(t, batcher.Enqueue(good), "not expecting an enqueue error")
+	assert.NoError(t, batcher.Enqueue(bad), "not expecting an enqueue error")
+	select {
+	case dead := <-deadLetters:
+		assert.Equal(t, bad, dead, "expecting only the operation without a result to fail")
+	case <-time.After(1 * time.Second):
+		assert.FailNow(t, "expected a dead-letter event before the timeout")
+	}
+	<-good.Done()
+	assert.Equal(t, gobatcher.ResultSucceeded, good.Result().Status)
+	assert.Equal(t, uint32(1), bad.Result().Attempt)
+	assert.Len(t, deadLetters, 0)
+}
+
+func TestBatcher_Result_AbandonedAfterMaxOperationTime(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Millisecond)
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		time.Sleep(100 * time.Millisecond)
+	}).WithMaxOperationTime(10 * time.Millisecond).WithMaxAttempts(1)
+	op := gobatcher.NewOperation(watcher, 0, struct{}{}, false)
+	err = batcher.Enqueue(op)
+	assert.NoError(t, err, "not expecting an enqueue error")
+	select {
+	case <-op.Done():
+	case <-time.After(1 * time.Second):
+		assert.FailNow(t, "expected the operation to be done before the timeout")
+	}
+	assert.Equal(t, gobatcher.ResultAbandoned, op.Result().Status)
+}
+
+func TestBatcher_MaxBatchesPerFlush_IsEnforced(t *testing.T) {
+	testCases := map[string]struct {
+		batchable bool
+		remaining uint32
+	}{
+		"not batchable": {batchable: false, remaining: 3},
+		"batchable":     {batchable: true, remaining: 1},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			batcher := gobatcher.NewBatcher().
+				WithFlushInterval(10 * time.Minute).
+				WithMaxBatchesPerFlush(2).
+				WithEmitFlush()
+			flushed := make(chan struct{}, 1)
+			batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+				if event == gobatcher.FlushDoneEvent {
+					flushed <- struct{}{}
+				}
+			})
+			var batches uint32
+			watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+				atomic.AddUint32(&batches, 1)
+			}).WithMaxBatchSize(2)
+			for i := 0; i < 5; i++ {
+				err := batcher.Enqueue(gobatcher.NewOperation(watcher, 0, struct{}{}, testCase.batchable))
+				assert.NoError(t, err, "not expecting an enqueue error")
+			}
+			err := batcher.Start(ctx)
+			assert.NoError(t, err, "not expecting a start error")
+			batcher.Flush()
+			<-flushed
+			assert.Equal(t, testCase.remaining, batcher.OperationsInBuffer(), "expecting operations beyond 2 batches to remain in the buffer")
+			time.Sleep(10 * time.Millisecond)
+			assert.Equal(t, uint32(2), atomic.LoadUint32(&batches), "expecting only 2 batches per flush")
+		})
+	}
+}
+
+func TestBatcher_ZeroCostOpsPerSecond_IsEnforced(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Second).
+		WithZeroCostOpsPerSecond(3).
+		WithEmitFlush()
+	flushed := make(chan gobatcher.FlushStats, 1)
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		if event == gobatcher.FlushDoneEvent {
+			flushed <- metadata.(gobatcher.FlushStats)
+		}
+	})
+	var processed uint32
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		atomic.AddUint32(&processed, uint32(len(batch)))
+	})
+	for i := 0; i < 5; i++ {
+		err := batcher.Enqueue(gobatcher.NewOperation(watcher, 0, struct{}{}, true))
+		assert.NoError(t, err, "not expecting an enqueue error")
+	}
+	err := batcher.Enqueue(gobatcher.NewOperation(watcher, 1, struct{}{}, true))
+	assert.NoError(t, err, "not expecting an enqueue error")
+	err = batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	batcher.Flush()
+	stats := <-flushed
+	assert.Equal(t, 4, stats.Operations, "expecting 3 zero-cost operations and the operation with a cost")
+	assert.Equal(t, 3, stats.ZeroCostOperations)
+	assert.Equal(t, uint32(3), stats.ZeroCostLimit)
+	assert.Equal(t, uint32(1), stats.Consumed)
+	assert.Equal(t, 1.0, stats.Utilization(), "expecting the zero-cost allowance to be fully utilized")
+	assert.Equal(t, uint32(2), batcher.OperationsInBuffer(), "expecting zero-cost operations over the limit to remain in the buffer")
+	assert.Eventually(t, func() bool { return atomic.LoadUint32(&processed) == 4 }, time.Second, time.Millisecond)
+}
+
+func TestBatcher_DeadlineFirst_DispatchesEarliestDeadlinesWhenCapacityIsShort(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	res := gobatcher.NewSharedResource().
+		WithReservedCapacity(2)
+	batcher := gobatcher.NewBatcher().
+		WithRateLimiter(res).
+		WithFlushInterval(1 * time.Second).
+		WithDeadlineFirst().
+		WithEmitFlush()
+	flushed := make(chan gobatcher.FlushStats, 1)
+	missed := make(chan gobatcher.Operation, 3)
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		switch event {
+		case gobatcher.FlushDoneEvent:
+			flushed <- metadata.(gobatcher.FlushStats)
+		case gobatcher.DeadlineMissEvent:
+			missed <- metadata.(gobatcher.Operation)
+		}
+	})
+	raised := make(chan string, 3)
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		for _, op := range batch {
+			raised <- op.Payload().(string)
+		}
+	})
+	late := gobatcher.NewOperation(watcher, 1, "late", false).WithDeadline(time.Now().Add(-1 * time.Second))
+	for _, op := range []gobatcher.Operation{
+		gobatcher.NewOperation(watcher, 1, "none", false),
+		gobatcher.NewOperation(watcher, 1, "later", false).WithDeadline(time.Now().Add(1 * time.Minute)),
+		late,
+	} {
+		err := batcher.Enqueue(op)
+		assert.NoError(t, err, "not expecting an enqueue error")
+	}
+	err := res.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	err = batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	batcher.Flush()
+	stats := <-flushed
+	assert.Equal(t, 2, stats.Operations, "expecting only the capacity for 2 operations")
+	assert.Equal(t, 1, stats.DeadlineMisses)
+	assert.Same(t, late, <-missed)
+	assert.ElementsMatch(t, []string{"late", "later"}, []string{<-raised, <-raised})
+	assert.Equal(t, uint32(1), batcher.OperationsInBuffer(), "expecting the operation without a deadline to wait")
+}
+
+func TestBatcher_Expiry_ExpiredOperationsAreRemovedFromTheBuffer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Minute).
+		WithEmitFlush()
+	flushed := make(chan gobatcher.FlushStats, 1)
+	expired := make(chan gobatcher.Operation, 1)
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		switch event {
+		case gobatcher.FlushDoneEvent:
+			flushed <- metadata.(gobatcher.FlushStats)
+		case gobatcher.ExpiredEvent:
+			expired <- metadata.(gobatcher.Operation)
+		}
+	})
+	raised := make(chan string, 2)
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		for _, op := range batch {
+			raised <- op.Payload().(string)
+		}
+	})
+	var completed gobatcher.Result
+	stale := gobatcher.NewOperation(watcher, 10, "stale", true).
+		WithTTL(10 * time.Millisecond).
+		WithOnComplete(func(op gobatcher.Operation, result gobatcher.Result) {
+			completed = result
+		})
+	fresh := gobatcher.NewOperation(watcher, 10, "fresh", true).WithTTL(1 * time.Minute)
+	for _, op := range []gobatcher.Operation{stale, fresh} {
+		err := batcher.Enqueue(op)
+		assert.NoError(t, err, "not expecting an enqueue error")
+	}
+	assert.Equal(t, uint32(20), batcher.NeedsCapacity())
+	time.Sleep(20 * time.Millisecond)
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	batcher.Flush()
+	stats := <-flushed
+	assert.Equal(t, 1, stats.Expired, "expecting the stale operation to expire")
+	assert.Equal(t, 1, stats.Operations, "expecting only the fresh operation to be dispatched")
+	assert.Equal(t, "fresh", <-raised)
+	assert.Same(t, stale, <-expired)
+	<-stale.Done()
+	assert.Equal(t, gobatcher.ResultExpired, completed.Status, "expecting the completion callback to be called")
+	assert.ErrorIs(t, completed.Err, gobatcher.OperationExpiredError)
+	assert.Equal(t, uint64(1), batcher.Stats().Expired)
+	assert.Eventually(t, func() bool { return batcher.NeedsCapacity() == 0 }, time.Second, time.Millisecond, "expecting the target to be released")
+}
+
+func TestBatcher_Expiry_ExpiredOperationsCannotBeEnqueued(t *testing.T) {
+	batcher := gobatcher.NewBatcher()
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {})
+	op := gobatcher.NewOperation(watcher, 0, struct{}{}, true).WithExpiry(time.Now().Add(-1 * time.Second))
+	err := batcher.Enqueue(op)
+	assert.ErrorIs(t, err, gobatcher.OperationExpiredError, "expecting an expired operation to be rejected")
+	assert.Equal(t, uint32(0), batcher.OperationsInBuffer())
+}
+
+func TestBatcher_Cancel_OperationsInTheBufferAreRemoved(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Minute).
+		WithEmitFlush()
+	flushed := make(chan gobatcher.FlushStats, 1)
+	cancelled := make(chan int, 1)
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		switch event {
+		case gobatcher.FlushDoneEvent:
+			flushed <- metadata.(gobatcher.FlushStats)
+		case gobatcher.CancelledEvent:
+			cancelled <- val
+		}
+	})
+	raised := make(chan string, 2)
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		for _, op := range batch {
+			raised <- op.Payload().(string)
+		}
+	})
+	var completed gobatcher.Result
+	unwanted := gobatcher.NewOperation(watcher, 10, "unwanted", true).
+		WithOnComplete(func(op gobatcher.Operation, result gobatcher.Result) {
+			completed = result
+		})
+	wanted := gobatcher.NewOperation(watcher, 20, "wanted", true)
+	for _, op := range []gobatcher.Operation{unwanted, wanted} {
+		err := batcher.Enqueue(op)
+		assert.NoError(t, err, "not expecting an enqueue error")
+	}
+	assert.True(t, batcher.Cancel(unwanted), "expecting the operation to be cancelled")
+	assert.False(t, batcher.Cancel(unwanted), "expecting an operation to only be cancelled once")
+	assert.Equal(t, 10, <-cancelled, "expecting the cost as the val")
+	<-unwanted.Done()
+	assert.Equal(t, gobatcher.ResultCancelled, completed.Status, "expecting the completion callback to be called")
+	assert.ErrorIs(t, completed.Err, gobatcher.OperationCancelledError)
+	assert.Equal(t, uint32(1), batcher.OperationsInBuffer())
+	assert.Equal(t, uint32(20), batcher.NeedsCapacity(), "expecting the target to be released")
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	batcher.Flush()
+	stats := <-flushed
+	assert.Equal(t, 1, stats.Operations, "expecting only the wanted operation to be dispatched")
+	assert.Equal(t, "wanted", <-raised)
+	assert.False(t, batcher.Cancel(wanted), "expecting a dispatched operation to not be cancelled")
+	assert.Equal(t, uint64(1), batcher.Stats().Cancelled)
+}
+
+func TestBatcher_Cancel_OperationsThatWereNotEnqueuedAreIgnored(t *testing.T) {
+	batcher := gobatcher.NewBatcher()
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {})
+	op := gobatcher.NewOperation(watcher, 0, struct{}{}, true)
+	assert.False(t, batcher.Cancel(op), "expecting an operation that was not enqueued to not be cancelled")
+	assert.False(t, batcher.Cancel(nil), "expecting a nil operation to not be cancelled")
+}
+
+func TestBatcher_Purge_MatchingOperationsAreRemoved(t *testing.T) {
+	batcher := gobatcher.NewBatcher()
+	purged := make(chan gobatcher.Operation, 2)
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		purged <- metadata.(gobatcher.Operation)
+	}, gobatcher.OnlyEvents(gobatcher.PurgedEvent))
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {})
+	var completed []gobatcher.Result
+	var mutex sync.Mutex
+	onComplete := func(op gobatcher.Operation, result gobatcher.Result) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		completed = append(completed, result)
+	}
+	ops := []gobatcher.Operation{
+		gobatcher.NewOperation(watcher, 10, "tenant-a", true).WithOnComplete(onComplete),
+		gobatcher.NewOperation(watcher, 20, "tenant-b", true).WithOnComplete(onComplete),
+		gobatcher.NewOperation(watcher, 30, "tenant-a", true).WithOnComplete(onComplete),
+	}
+	for _, op := range ops {
+		err := batcher.Enqueue(op)
+		assert.NoError(t, err, "not expecting an enqueue error")
+	}
+	count := batcher.Purge(func(op gobatcher.Operation) bool {
+		return op.Payload() == "tenant-a"
+	})
+	assert.Equal(t, 2, count, "expecting both operations for the tenant to be removed")
+	assert.Equal(t, ops[0], <-purged)
+	assert.Equal(t, ops[2], <-purged)
+	<-ops[0].Done()
+	<-ops[2].Done()
+	mutex.Lock()
+	for _, result := range completed {
+		assert.Equal(t, gobatcher.ResultPurged, result.Status, "expecting the completion callback to be called")
+		assert.ErrorIs(t, result.Err, gobatcher.OperationPurgedError)
+	}
+	assert.Len(t, completed, 2)
+	mutex.Unlock()
+	assert.Equal(t, uint32(1), batcher.OperationsInBuffer())
+	assert.Equal(t, uint32(20), batcher.NeedsCapacity(), "expecting the target to be released")
+	assert.Equal(t, uint64(2), batcher.Stats().Purged)
+	assert.Equal(t, 0, batcher.Purge(func(op gobatcher.Operation) bool {
+		return op.Payload() == "tenant-a"
+	}), "expecting nothing left to purge")
+}
+
+func TestBatcher_InspectBuffer_PendingOperationsAreVisited(t *testing.T) {
+	batcher := gobatcher.NewBatcher()
+	orders := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {})
+	emails := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {})
+	before := time.Now()
+	ops := []gobatcher.Operation{
+		gobatcher.NewOperation(orders, 10, "order-1", true),
+		gobatcher.NewOperation(emails, 20, "email-1", true),
+		gobatcher.NewOperation(orders, 30, "order-2", true),
+	}
+	assert.True(t, ops[0].EnqueuedAt().IsZero(), "expecting no enqueue time before the operation is enqueued")
+	for _, op := range ops {
+		err := batcher.Enqueue(op)
+		assert.NoError(t, err, "not expecting an enqueue error")
+	}
+	counts := make(map[gobatcher.Watcher]int)
+	var visited []interface{}
+	var oldest time.Time
+	batcher.InspectBuffer(func(op gobatcher.Operation) bool {
+		counts[op.Watcher()]++
+		visited = append(visited, op.Payload())
+		if oldest.IsZero() || op.EnqueuedAt().Before(oldest) {
+			oldest = op.EnqueuedAt()
+		}
+		return true
+	})
+	assert.Equal(t, []interface{}{"order-1", "email-1", "order-2"}, visited, "expecting the operations from the head of the buffer")
+	assert.Equal(t, 2, counts[orders])
+	assert.Equal(t, 1, counts[emails])
+	assert.Equal(t, ops[0].EnqueuedAt(), oldest, "expecting the first operation to be the oldest")
+	assert.False(t, oldest.Before(before), "expecting the enqueue time to be recorded when enqueued")
+	assert.Equal(t, uint32(3), batcher.OperationsInBuffer(), "expecting the buffer to not be drained")
+	visits := 0
+	batcher.InspectBuffer(func(op gobatcher.Operation) bool {
+		visits++
+		return false
+	})
+	assert.Equal(t, 1, visits, "expecting the inspection to stop when fn returns false")
+}
+
+func TestBatcher_Deduplication_DuplicatesAreRejected(t *testing.T) {
+	batcher := gobatcher.NewBatcher().
+		WithDeduplication(nil)
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {})
+	err := batcher.Enqueue(gobatcher.NewOperation(watcher, 10, "first", true).WithDedupKey("order-1"))
+	assert.NoError(t, err, "not expecting an enqueue error")
+	err = batcher.Enqueue(gobatcher.NewOperation(watcher, 10, "retry", true).WithDedupKey("order-1"))
+	assert.ErrorIs(t, err, gobatcher.DuplicateOperationError, "expecting the retry to be rejected")
+	err = batcher.Enqueue(gobatcher.NewOperation(watcher, 10, "other", true).WithDedupKey("order-2"))
+	assert.NoError(t, err, "not expecting an enqueue error for a different key")
+	err = batcher.Enqueue(gobatcher.NewOperation(watcher, 10, "unkeyed", true))
+	assert.NoError(t, err, "not expecting an enqueue error without a key")
+	assert.Equal(t, uint32(3), batcher.OperationsInBuffer())
+	assert.Equal(t, uint32(30), batcher.NeedsCapacity(), "expecting the target to be restored for the duplicate")
+}
+
+func TestBatcher_Deduplication_DuplicatesAreCoalesced(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	raised := make(chan []string, 1)
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		var payloads []string
+		for _, op := range batch {
+			payloads = append(payloads, op.Payload().(string))
+		}
+		raised <- payloads
+	})
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Minute).
+		WithDeduplication(func(buffered, incoming gobatcher.Operation) gobatcher.Operation {
+			payload := buffered.Payload().(string) + "+" + incoming.Payload().(string)
+			return gobatcher.NewOperation(incoming.Watcher(), buffered.Cost()+incoming.Cost(), payload, true).WithDedupKey(incoming.DedupKey())
+		})
+	coalesced := make(chan gobatcher.Operation, 2)
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		coalesced <- metadata.(gobatcher.Operation)
+	}, gobatcher.OnlyEvents(gobatcher.CoalescedEvent))
+	first := gobatcher.NewOperation(watcher, 10, "a", true).WithDedupKey("order-1")
+	second := gobatcher.NewOperation(watcher, 20, "b", true).WithDedupKey("order-1")
+	third := gobatcher.NewOperation(watcher, 5, "c", true).WithDedupKey("order-1")
+	err := batcher.Enqueue(first)
+	assert.NoError(t, err, "not expecting an enqueue error")
+	err = batcher.EnqueueMany([]gobatcher.Operation{second, third})
+	assert.NoError(t, err, "not expecting an enqueue error")
+	assert.Same(t, second, <-coalesced)
+	assert.Same(t, third, <-coalesced)
+	assert.Equal(t, uint32(1), batcher.OperationsInBuffer(), "expecting the duplicates to be merged")
+	assert.Equal(t, uint32(35), batcher.NeedsCapacity(), "expecting the target to be the cost of the merged operation")
+	assert.Equal(t, uint64(2), batcher.Stats().Coalesced)
+	err = batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	batcher.Flush()
+	select {
+	case payloads := <-raised:
+		assert.Equal(t, []string{"a+b+c"}, payloads)
+	case <-time.After(1 * time.Second):
+		assert.Fail(t, "expecting the merged operation to be dispatched")
+	}
+	for _, op := range []gobatcher.Operation{first, second, third} {
+		select {
+		case <-op.Done():
+			assert.Equal(t, gobatcher.ResultSucceeded, op.Result().Status, "expecting the result of the merged operation")
+		case <-time.After(1 * time.Second):
+			assert.Fail(t, "expecting every merged operation to be completed")
+		}
+	}
+}
+
+func TestBatcher_FullBufferPolicy_OperationsAreDropped(t *testing.T) {
+	testCases := map[string]struct {
+		policy   gobatcher.FullBufferPolicy
+		dropped  string
+		buffered []string
+	}{
+		"newest":          {policy: gobatcher.DropNewest, dropped: "new", buffered: []string{"first", "second"}},
+		"oldest":          {policy: gobatcher.DropOldest, dropped: "first", buffered: []string{"second", "new"}},
+		"lowest-priority": {policy: gobatcher.DropLowestPriority, dropped: "second", buffered: []string{"first", "new"}},
+	}
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			batcher := gobatcher.NewBatcherWithBuffer(2).
+				WithFlushInterval(1 * time.Minute).
+				WithFullBufferPolicy(testCase.policy)
+			dropped := make(chan gobatcher.Operation, 1)
+			batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+				dropped <- metadata.(gobatcher.Operation)
+			}, gobatcher.OnlyEvents(gobatcher.DroppedEvent))
+			raised := make(chan string, 2)
+			watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+				for _, op := range batch {
+					raised <- op.Payload().(string)
+				}
+			})
+			ops := []gobatcher.Operation{
+				gobatcher.NewOperation(watcher, 10, "first", true).WithPriority(2),
+				gobatcher.NewOperation(watcher, 10, "second", true).WithPriority(1),
+				gobatcher.NewOperation(watcher, 10, "new", true).WithPriority(3),
+			}
+			for _, op := range ops {
+				err := batcher.Enqueue(op)
+				assert.NoError(t, err, "expecting a full buffer to drop an operation rather than return an error")
+			}
+			op := <-dropped
+			assert.Equal(t, testCase.dropped, op.Payload())
+			<-op.Done()
+			assert.Equal(t, gobatcher.ResultDropped, op.Result().Status)
+			assert.ErrorIs(t, op.Result().Err, gobatcher.OperationDroppedError)
+			assert.Equal(t, uint32(20), batcher.NeedsCapacity(), "expecting the target to be released")
+			assert.Equal(t, uint64(1), batcher.Stats().Dropped)
+			err := batcher.Start(ctx)
+			assert.NoError(t, err, "not expecting a start error")
+			batcher.Flush()
+			assert.Equal(t, testCase.buffered, []string{<-raised, <-raised})
+		})
+	}
+}
+
+func TestBatcher_FullBufferPolicy_EnqueueManyDropsOperations(t *testing.T) {
+	batcher := gobatcher.NewBatcherWithBuffer(2).
+		WithFullBufferPolicy(gobatcher.DropOldest)
+	var count uint32
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		atomic.AddUint32(&count, 1)
+	}, gobatcher.OnlyEvents(gobatcher.DroppedEvent))
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {})
+	ops := make([]gobatcher.Operation, 0, 5)
+	for i := 0; i < 5; i++ {
+		ops = append(ops, gobatcher.NewOperation(watcher, 10, i, true))
+	}
+	err := batcher.EnqueueMany(ops)
+	assert.NoError(t, err, "expecting a full buffer to drop operations rather than return an error")
+	for _, op := range ops[:3] {
+		assert.Equal(t, gobatcher.ResultDropped, op.Result().Status)
+	}
+	assert.Equal(t, uint32(2), batcher.OperationsInBuffer())
+	assert.Equal(t, uint32(20), batcher.NeedsCapacity(), "expecting the target to be released")
+	assert.Equal(t, uint32(3), atomic.LoadUint32(&count))
+	assert.Equal(t, uint64(5), batcher.Stats().Enqueued)
+}
+
+func TestBatcher_UnboundedBuffer_WatermarksAreRaised(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcherWithUnboundedBuffer().
+		WithMemoryWatermarks(5000, 1000).
+		WithFlushInterval(1 * time.Minute)
+	events := make(chan string, 10)
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		events <- event
+	}, gobatcher.OnlyEvents(gobatcher.HighWatermarkEvent, gobatcher.LowWatermarkEvent))
+	done := make(chan struct{}, 1)
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		done <- struct{}{}
+	})
+	for i := 0; i < 20; i++ {
+		err := batcher.Enqueue(gobatcher.NewOperation(watcher, 0, i, true).WithSize(1000))
+		assert.NoError(t, err, "expecting an unbounded buffer to never be full")
+	}
+	assert.Equal(t, gobatcher.HighWatermarkEvent, <-events)
+	assert.GreaterOrEqual(t, batcher.Stats().EstimatedMemory, uint64(20000))
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	batcher.Flush()
+	<-done
+	assert.Equal(t, gobatcher.LowWatermarkEvent, <-events)
+	assert.Equal(t, uint64(0), batcher.Stats().EstimatedMemory)
+	assert.Len(t, events, 0, "expecting each watermark to only be raised once")
+}
+
+type stringCodec struct{}
+
+func (stringCodec) Marshal(payload interface{}) ([]byte, error) {
+	str, ok := payload.(string)
+	if !ok {
+		return nil, errors.New("the payload is not a string")
+	}
+	return []byte(str), nil
+}
+
+func (stringCodec) Unmarshal(data []byte) (interface{}, error) {
+	return string(data), nil
+}
+
+func TestBatcher_OverflowFile_OperationsAreReplayedInOrder(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "overflow")
+	batcher := gobatcher.NewBatcherWithBuffer(2).
+		WithErrorOnFullBuffer().
+		WithOverflowFile(path, stringCodec{}).
+		WithFlushInterval(1 * time.Millisecond)
+	raised := make(chan string, 10)
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		for _, op := range batch {
+			raised <- op.Payload().(string)
+		}
+	}).WithMaxBatchSize(3)
+	var expected []string
+	for i := 0; i < 10; i++ {
+		payload := fmt.Sprintf("op-%d", i)
+		expected = append(expected, payload)
+		err := batcher.Enqueue(gobatcher.NewOperation(watcher, 0, payload, true))
+		assert.NoError(t, err, "expecting a full buffer to overflow rather than return an error")
+	}
+	assert.Equal(t, uint32(2), batcher.Stats().OperationsInBuffer)
+	assert.Equal(t, uint32(8), batcher.Stats().Overflowed)
+	info, err := os.Stat(path)
+	assert.NoError(t, err, "expecting the overflow file to be created")
+	assert.Equal(t, int64(8*(4+4)), info.Size(), "expecting each payload to be appended")
+	err = batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	var actual []string
+	for range expected {
+		actual = append(actual, <-raised)
+	}
+	assert.Equal(t, expected, actual, "expecting the operations to be raised in the order they were enqueued")
+	assert.Equal(t, uint32(0), batcher.Stats().Overflowed)
+	info, err = os.Stat(path)
+	assert.NoError(t, err, "expecting the overflow file to remain until shutdown")
+	assert.Equal(t, int64(0), info.Size(), "expecting the overflow file to be truncated once replayed")
+	err = batcher.Shutdown(context.Background())
+	assert.NoError(t, err, "not expecting a shutdown error")
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err), "expecting the overflow file to be removed on shutdown")
+}
+
+func TestBatcher_OverflowFile_CodecErrorsAreReturned(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "overflow")
+	batcher := gobatcher.NewBatcherWithBuffer(1).
+		WithOverflowFile(path, stringCodec{})
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {})
+	err := batcher.Enqueue(gobatcher.NewOperation(watcher, 10, 1, true))
+	assert.NoError(t, err, "expecting the first operation to fit in the buffer")
+	err = batcher.Enqueue(gobatcher.NewOperation(watcher, 10, 2, true))
+	assert.EqualError(t, err, "the payload is not a string")
+	assert.Equal(t, uint32(0), batcher.Stats().Overflowed)
+	assert.Equal(t, uint32(10), batcher.NeedsCapacity(), "expecting the target to be restored")
+}
+
+func TestBatcher_BufferStore_OperationsAreRecoveredAfterACrash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buffer")
+
+	// the first process enqueues operations but never processes them
+	crashed := gobatcher.NewBatcher().
+		WithBufferStore(gobatcher.NewFileBufferStore(path), stringCodec{})
+	writer := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {}).WithLabel("writer")
+	for _, payload := range []string{"first", "second", "third"} {
+		err := crashed.Enqueue(gobatcher.NewOperation(writer, 10, payload, true).WithKey("k").WithPriority(2))
+		assert.NoError(t, err, "not expecting an enqueue error")
+	}
+	unlabeled := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {})
+	err := crashed.Enqueue(gobatcher.NewOperation(unlabeled, 10, "unlabeled", true))
+	assert.ErrorIs(t, err, gobatcher.UnlabeledWatcherError)
+
+	// the next process recovers them when it starts
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	raised := make(chan gobatcher.Operation, 3)
+	writer = gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		for _, op := range batch {
+			raised <- op
+		}
+	}).WithLabel("writer")
+	batcher := gobatcher.NewBatcher().
+		WithBufferStore(gobatcher.NewFileBufferStore(path), stringCodec{}, writer).
+		WithFlushInterval(1 * time.Millisecond)
+	recovered := make(chan int, 1)
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		recovered <- val
+	}, gobatcher.OnlyEvents(gobatcher.RecoveredEvent))
+	err = batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	assert.Equal(t, 3, <-recovered)
+	var payloads []string
+	for i := 0; i < 3; i++ {
+		op := <-raised
+		payloads = append(payloads, op.Payload().(string))
+		assert.Equal(t, "k", op.Key())
+		assert.Equal(t, 2, op.Priority())
+		assert.Equal(t, uint32(10), op.Cost())
+	}
+	assert.Equal(t, []string{"first", "second", "third"}, payloads, "expecting the operations in the order they were enqueued")
+
+	// the operations are deleted once they are finished
+	err = batcher.Shutdown(context.Background())
+	assert.NoError(t, err, "not expecting a shutdown error")
+	remaining, err := gobatcher.NewFileBufferStore(path).Load()
+	assert.NoError(t, err, "not expecting a load error")
+	assert.Empty(t, remaining, "expecting finished operations to be deleted from the store")
+}
+
+func TestBatcher_OrderedKeys_OperationsWithTheSameKeyAreRaisedInOrderOneBatchAtATime(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Millisecond).
+		WithMaxConcurrentBatches(10).
+		WithOrderedKeys()
+	var mutex sync.Mutex
+	running := make(map[string]int)
+	concurrent := false
+	applied := make(map[string][]int)
+	done := make(chan struct{}, 12)
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		key := batch[0].Key()
+		mutex.Lock()
+		running[key]++
+		if running[key] > 1 {
+			concurrent = true
+		}
+		mutex.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mutex.Lock()
+		for _, op := range batch {
+			applied[op.Key()] = append(applied[op.Key()], op.Payload().(int))
+		}
+		running[key]--
+		mutex.Unlock()
+		for range batch {
+			done <- struct{}{}
+		}
+	}).WithMaxBatchSize(2)
+	for i := 0; i < 6; i++ {
+		for _, key := range []string{"a", "b"} {
+			// every third operation is not batchable so it would otherwise be raised ahead of the open batch
+			op := gobatcher.NewOperation(watcher, 0, i, i%3 != 2).WithKey(key)
+			err := batcher.Enqueue(op)
+			assert.NoError(t, err, "not expecting an enqueue error")
+		}
+	}
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	for i := 0; i < 12; i++ {
+		select {
+		case <-done:
+		case <-time.After(1 * time.Second):
+			assert.FailNow(t, "expecting every operation to be raised")
+		}
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.False(t, concurrent, "expecting operations with the same key to never be processed concurrently")
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5}, applied["a"], "expecting the operations to be raised in order")
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5}, applied["b"], "expecting the operations to be raised in order")
+}
+
+func TestBatcher_OrderedKeys_KeysAreBusyUntilATimedOutWatcherReturns(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Millisecond).
+		WithMaxOperationTime(10 * time.Millisecond).
+		WithOrderedKeys()
+	release := make(chan struct{})
+	raised := make(chan int, 2)
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		raised <- batch[0].Payload().(int)
+		if batch[0].Payload().(int) == 1 {
+			<-release
+		}
+	})
+	for i := 1; i <= 2; i++ {
+		err := batcher.Enqueue(gobatcher.NewOperation(watcher, 0, i, false).WithKey("a"))
+		assert.NoError(t, err, "not expecting an enqueue error")
+	}
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	assert.Equal(t, 1, <-raised)
+	select {
+	case <-raised:
+		assert.Fail(t, "expecting the second operation to wait for the watcher to return")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case second := <-raised:
+		assert.Equal(t, 2, second)
+	case <-time.After(1 * time.Second):
+		assert.Fail(t, "expecting the second operation to be raised once the watcher returns")
+	}
+}
+
+func TestBatcher_PauseWatcher_OnlyThatWatcherIsHeld(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Millisecond)
+	raised := make(chan string, 2)
+	paused := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		raised <- "paused"
+	})
+	other := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		raised <- "other"
+	})
+	events := make(chan string, 2)
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		events <- event
+	}, gobatcher.ForWatcher(paused), gobatcher.OnlyEvents(gobatcher.PauseEvent, gobatcher.ResumeEvent))
+	batcher.PauseWatcher(paused, 0)
+	assert.Equal(t, gobatcher.PauseEvent, <-events)
+	for _, watcher := range []gobatcher.Watcher{paused, other} {
+		err := batcher.Enqueue(gobatcher.NewOperation(watcher, 0, struct{}{}, false))
+		assert.NoError(t, err, "not expecting an enqueue error")
+	}
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	assert.Equal(t, "other", <-raised, "expecting the other watcher to be raised")
+	select {
+	case <-raised:
+		assert.Fail(t, "expecting the paused watcher to not be raised")
+	case <-time.After(30 * time.Millisecond):
+	}
+	assert.Equal(t, uint32(1), batcher.OperationsInBuffer(), "expecting the operation to stay in the buffer")
+	batcher.ResumeWatcher(paused)
+	assert.Equal(t, gobatcher.ResumeEvent, <-events)
+	select {
+	case watcher := <-raised:
+		assert.Equal(t, "paused", watcher)
+	case <-time.After(1 * time.Second):
+		assert.Fail(t, "expecting the watcher to be raised once resumed")
+	}
+}
+
+func TestBatcher_PauseWatcher_PauseExpires(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Millisecond)
+	raised := make(chan time.Time, 1)
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		raised <- time.Now()
+	})
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	paused := time.Now()
+	batcher.PauseWatcher(watcher, 20*time.Millisecond)
+	err = batcher.Enqueue(gobatcher.NewOperation(watcher, 0, struct{}{}, false))
+	assert.NoError(t, err, "not expecting an enqueue error")
+	select {
+	case at := <-raised:
+		assert.GreaterOrEqual(t, at.Sub(paused), 20*time.Millisecond, "expecting the watcher to be raised after the pause")
+	case <-time.After(1 * time.Second):
+		assert.Fail(t, "expecting the watcher to be raised once the pause expired")
+	}
+}
+
+func TestBatcher_FlushWatcher_OnlyThatWatcherIsFlushed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Minute).
+		WithEmitFlush()
+	flushed := make(chan gobatcher.FlushStats, 2)
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		flushed <- metadata.(gobatcher.FlushStats)
+	}, gobatcher.OnlyEvents(gobatcher.FlushDoneEvent))
+	raised := make(chan string, 4)
+	drained := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		raised <- "drained"
+	}).WithMinBatchSize(10)
+	other := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		raised <- "other"
+	})
+	for _, watcher := range []gobatcher.Watcher{drained, other} {
+		err := batcher.Enqueue(gobatcher.NewOperation(watcher, 0, struct{}{}, true))
+		assert.NoError(t, err, "not expecting an enqueue error")
+	}
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	batcher.FlushWatcher(drained)
+	stats := <-flushed
+	assert.Equal(t, 1, stats.Operations, "expecting only the operation for the watcher to be flushed")
+	assert.Equal(t, "drained", <-raised, "expecting the watcher to not be held back for MinBatchSize")
+	assert.Equal(t, uint32(1), batcher.OperationsInBuffer())
+	batcher.Flush()
+	<-flushed
+	assert.Equal(t, "other", <-raised, "expecting a flush to include every watcher")
+}
+
+func TestBatcher_Weight_CapacityIsDividedAmongWatchers(t *testing.T) {
+	testCases := map[string]struct {
+		weight        uint32
+		chatty, quiet int
+	}{
+		"fifo":     {weight: 0, chatty: 100, quiet: 0},
+		"weighted": {weight: 3, chatty: 30, quiet: 70},
+	}
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			res := gobatcher.NewSharedResource().
+				WithReservedCapacity(1000)
+			err := res.Start(ctx)
+			assert.NoError(t, err, "not expecting a start error")
+			batcher := gobatcher.NewBatcher().
+				WithRateLimiter(res).
+				WithFlushInterval(100 * time.Millisecond).
+				WithEmitBatch().
+				WithEmitFlush()
+			var mutex sync.Mutex
+			consumed := make(map[string]int)
+			flushed := make(chan struct{}, 1)
+			batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+				switch event {
+				case gobatcher.BatchEvent:
+					mutex.Lock()
+					defer mutex.Unlock()
+					for _, op := range metadata.([]gobatcher.BatchedOperation) {
+						consumed[op.Watcher] += int(op.Cost)
+					}
+				case gobatcher.FlushDoneEvent:
+					select {
+					case flushed <- struct{}{}:
+					default:
+					}
+				}
+			})
+			chatty := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {}).WithLabel("chatty")
+			quiet := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {}).WithLabel("quiet").WithWeight(testCase.weight)
+			for _, watcher := range []gobatcher.Watcher{chatty, quiet} {
+				for i := 0; i < 20; i++ {
+					err := batcher.Enqueue(gobatcher.NewOperation(watcher, 10, struct{}{}, false))
+					assert.NoError(t, err, "not expecting an enqueue error")
+				}
+			}
+			err = batcher.Start(ctx)
+			assert.NoError(t, err, "not expecting a start error")
+			select {
+			case <-flushed:
+			case <-time.After(1 * time.Second):
+				assert.FailNow(t, "expecting a flush")
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			assert.Equal(t, testCase.chatty, consumed["chatty"], "expecting the chatty watcher to get its share of the first flush")
+			assert.Equal(t, testCase.quiet, consumed["quiet"], "expecting the quiet watcher to get its share of the first flush")
+		})
+	}
+}
+
+func TestBatcher_Timeout_CapturesWatcherStack(t *testing.T) {
+	testCases := map[string]struct {
+		capture bool
+	}{
+		"captured":     {capture: true},
+		"not-captured": {capture: false},
+	}
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			batcher := gobatcher.NewBatcher().
+				WithFlushInterval(1 * time.Millisecond)
+			if testCase.capture {
+				batcher.WithCaptureStackOnTimeout()
+			}
+			type timeout struct {
+				val   int
+				stack string
+			}
+			timeouts := make(chan timeout, 1)
+			batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+				if event == gobatcher.TimeoutEvent {
+					timeouts <- timeout{val: val, stack: msg}
+				}
+			})
+			release := make(chan struct{})
+			defer close(release)
+			watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+				<-release
+			}).WithMaxOperationTime(10 * time.Millisecond)
+			err := batcher.Enqueue(gobatcher.NewOperation(watcher, 0, struct{}{}, false))
+			assert.NoError(t, err, "not expecting an enqueue error")
+			err = batcher.Start(ctx)
+			assert.NoError(t, err, "not expecting a start error")
+			select {
+			case timeout := <-timeouts:
+				assert.Equal(t, 1, timeout.val)
+				if testCase.capture {
+					assert.Contains(t, timeout.stack, "TestBatcher_Timeout_CapturesWatcherStack", "expecting the stack of the blocked watcher")
+					assert.Contains(t, timeout.stack, "chan receive")
+				} else {
+					assert.Empty(t, timeout.stack)
+				}
+			case <-time.After(1 * time.Second):
+				assert.Fail(t, "expected a timeout event")
+			}
+		})
+	}
+}
+
+func TestBatcher_Summary_AggregatesOverTheInterval(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	res := gobatcher.NewSharedResource().
+		WithReservedCapacity(10000)
+	batcher := gobatcher.NewBatcher().
+		WithRateLimiter(res).
+		WithFlushInterval(1 * time.Millisecond).
+		WithSummaryInterval(50 * time.Millisecond)
+	summaries := make(chan gobatcher.Summary, 100)
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		if event == gobatcher.SummaryEvent {
+			summary := metadata.(gobatcher.Summary)
+			assert.Equal(t, summary.Operations, val)
+			summaries <- summary
+		}
+	})
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		for _, op := range batch {
+			if op.Payload().(int) == 0 {
+				op.SetResult(gobatcher.Failed(errors.New("failed")))
+			}
+		}
+	}).WithMaxAttempts(1)
+	for i := 0; i < 3; i++ {
+		err := batcher.Enqueue(gobatcher.NewOperation(watcher, 1, i, false))
+		assert.NoError(t, err, "not expecting an enqueue error")
+	}
+	err := res.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	err = batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	var total gobatcher.Summary
+	deadline := time.After(1 * time.Second)
+	for total.Operations < 3 {
+		select {
+		case summary := <-summaries:
+			assert.Greater(t, int64(summary.Period), int64(0))
+			total.Batches += summary.Batches
+			total.Operations += summary.Operations
+			total.Failures += summary.Failures
+			total.Consumed += summary.Consumed
+			if summary.Flushes > 0 {
+				assert.Equal(t, uint32(10), summary.Capacity, "expecting 1ms worth of the reserved capacity to be available to every flush")
+			}
+		case <-deadline:
+			assert.FailNow(t, "expected summaries covering every operation")
+		}
+	}
+	assert.Equal(t, 3, total.Batches, "expecting each unbatchable operation to be its own batch")
+	assert.Equal(t, 1, total.Failures)
+	assert.Equal(t, uint64(3), total.Consumed)
+}
+
+func TestBatcher_WatcherWithError_FailsOperationsAndRaisesEvent(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Millisecond)
+	failedBatches := make(chan int, 1)
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		if event == gobatcher.BatchFailedEvent {
+			assert.Equal(t, "downstream unavailable", msg)
+			failedBatches <- val
+		}
+	})
+	failure := errors.New("downstream unavailable")
+	var succeeded gobatcher.Operation
+	watcher := gobatcher.NewWatcherWithError(func(ctx context.Context, batch []gobatcher.Operation) error {
+		for _, op := range batch {
+			if op == succeeded {
+				op.SetResult(gobatcher.Succeeded())
+			}
+		}
+		return failure
+	}).WithMaxAttempts(1).WithMaxBatchSize(2)
+	succeeded = gobatcher.NewOperation(watcher, 0, struct{}{}, true)
+	failed := gobatcher.NewOperation(watcher, 0, struct{}{}, true)
+	err := batcher.EnqueueMany([]gobatcher.Operation{succeeded, failed})
+	assert.NoError(t, err, "not expecting an enqueue error")
+	err = batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	select {
+	case val := <-failedBatches:
+		assert.Equal(t, 2, val)
+	case <-time.After(1 * time.Second):
+		assert.FailNow(t, "expected a batch-failed event")
+	}
+	<-failed.Done()
+	<-succeeded.Done()
+	assert.Equal(t, gobatcher.ResultFailed, failed.Result().Status)
+	assert.ErrorIs(t, failed.Result().Err, failure)
+	assert.Equal(t, gobatcher.ResultSucceeded, succeeded.Result().Status, "expecting a result set by the watcher to be kept")
+}
+
+func TestBatcher_FlushOnCost_FlushesBeforeTheInterval(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Hour).
+		WithFlushOnCost(100)
+	processed := make(chan int, 1)
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		processed <- len(batch)
+	})
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	err = batcher.Enqueue(gobatcher.NewOperation(watcher, 60, struct{}{}, true))
+	assert.NoError(t, err, "not expecting an enqueue error")
+	select {
+	case <-processed:
+		assert.FailNow(t, "not expecting a flush below the cost threshold")
+	case <-time.After(50 * time.Millisecond):
+	}
+	err = batcher.Enqueue(gobatcher.NewOperation(watcher, 40, struct{}{}, true))
+	assert.NoError(t, err, "not expecting an enqueue error")
+	select {
+	case count := <-processed:
+		assert.Equal(t, 2, count, "expecting both operations in the same batch")
+	case <-time.After(1 * time.Second):
+		assert.FailNow(t, "expected the cost threshold to trigger a flush")
+	}
+}
+
+func TestBatcher_FlushOnSize_FlushesAFullBatchBeforeTheInterval(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Hour).
+		WithFlushOnSize()
+	processed := make(chan int, 2)
+	full := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		processed <- len(batch)
+	}).WithMaxBatchSize(3)
+	other := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		processed <- -len(batch)
+	}).WithMaxBatchSize(3)
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	for i := 0; i < 2; i++ {
+		err = batcher.Enqueue(gobatcher.NewOperation(full, 0, struct{}{}, true))
+		assert.NoError(t, err, "not expecting an enqueue error")
+	}
+	err = batcher.Enqueue(gobatcher.NewOperation(other, 0, struct{}{}, true))
+	assert.NoError(t, err, "not expecting an enqueue error")
+	select {
+	case <-processed:
+		assert.FailNow(t, "not expecting a flush before a batch is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+	err = batcher.Enqueue(gobatcher.NewOperation(full, 0, struct{}{}, true))
+	assert.NoError(t, err, "not expecting an enqueue error")
+	select {
+	case count := <-processed:
+		assert.Equal(t, 3, count, "expecting the full batch to be raised")
+	case <-time.After(1 * time.Second):
+		assert.FailNow(t, "expected the full batch to trigger a flush")
+	}
+	select {
+	case <-processed:
+		assert.FailNow(t, "not expecting the other watcher to be flushed")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.Equal(t, uint32(1), batcher.OperationsInBuffer())
+}
+
+func TestBatcher_WatcherCooldown_HoldsBackOnlyTheFailedWatcher(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Millisecond)
+	cooldowns := make(chan int, 1)
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		if event == gobatcher.CooldownEvent {
+			cooldowns <- val
+		}
+	})
+	var calls uint32
+	finished := make(chan time.Time, 2)
+	started := make(chan time.Time, 2)
+	failing := gobatcher.NewWatcherWithError(func(ctx context.Context, batch []gobatcher.Operation) error {
+		started <- time.Now()
+		defer func() { finished <- time.Now() }()
+		if atomic.AddUint32(&calls, 1) == 1 {
+			return errors.New("downstream unavailable")
+		}
+		return nil
+	}).WithCooldown(200 * time.Millisecond)
+	healthy := make(chan time.Time, 1)
+	other := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		healthy <- time.Now()
+	})
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+
+	// fail the first batch
+	err = batcher.Enqueue(gobatcher.NewOperation(failing, 0, struct{}{}, false))
+	assert.NoError(t, err, "not expecting an enqueue error")
+	<-started
+	failedAt := <-finished
+	select {
+	case val := <-cooldowns:
+		assert.Equal(t, 200, val)
+	case <-time.After(1 * time.Second):
+		assert.FailNow(t, "expected a cooldown event")
+	}
+
+	// the failing watcher is held back, but other watchers are not
+	err = batcher.Enqueue(gobatcher.NewOperation(failing, 0, struct{}{}, false))
+	assert.NoError(t, err, "not expecting an enqueue error")
+	err = batcher.Enqueue(gobatcher.NewOperation(other, 0, struct{}{}, false))
+	assert.NoError(t, err, "not expecting an enqueue error")
+	select {
+	case at := <-healthy:
+		assert.Less(t, int64(at.Sub(failedAt)), int64(100*time.Millisecond), "expecting other watchers to keep full speed")
+	case <-time.After(1 * time.Second):
+		assert.FailNow(t, "expected the other watcher to be raised a batch")
+	}
+	select {
+	case at := <-started:
+		assert.GreaterOrEqual(t, int64(at.Sub(failedAt)), int64(190*time.Millisecond), "expecting the cooldown to be honored")
+	case <-time.After(1 * time.Second):
+		assert.FailNow(t, "expected the failing watcher to be raised another batch")
+	}
+}
+
+func TestBatcher_WatcherWithError_ContextIsDoneAtMaxOperationTime(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Millisecond)
+	done := make(chan time.Duration, 1)
+	watcher := gobatcher.NewWatcherWithError(func(ctx context.Context, batch []gobatcher.Operation) error {
+		started := time.Now()
+		<-ctx.Done()
+		done <- time.Since(started)
+		return ctx.Err()
+	}).WithMaxOperationTime(20 * time.Millisecond)
+	err := batcher.Enqueue(gobatcher.NewOperation(watcher, 0, struct{}{}, false))
+	assert.NoError(t, err, "not expecting an enqueue error")
+	err = batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	select {
+	case elapsed := <-done:
+		assert.GreaterOrEqual(t, int64(elapsed), int64(15*time.Millisecond))
+	case <-time.After(1 * time.Second):
+		assert.Fail(t, "expected the context to be done after MaxOperationTime")
+	}
+}
+
+func TestBatcher_RequeueAll_RaisesTheSameBatchAfterDelay(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Millisecond)
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	type raise struct {
+		ops []gobatcher.Operation
+		at  time.Time
+	}
+	raised := make(chan raise, 4)
+	var other gobatcher.Operation
+	watcher := gobatcher.NewBatchWatcher(func(batch gobatcher.Batch) {
+		raised <- raise{ops: batch.Operations(), at: time.Now()}
+		if len(batch.Operations()) == 2 && batch.Operations()[0].Attempt() == 1 {
+			assert.NoError(t, batch.RequeueAll(20*time.Millisecond), "expecting the requeue to be allowed")
+			assert.Equal(t, gobatcher.BatchNotRequeueableError, batch.RequeueAll(0), "expecting a second requeue to fail")
+			assert.NoError(t, batcher.Enqueue(other), "not expecting an enqueue error")
+		}
+	}).WithMaxBatchSize(2)
+	first := gobatcher.NewOperation(watcher, 10, struct{}{}, true)
+	second := gobatcher.NewOperation(watcher, 10, struct{}{}, true)
+	other = gobatcher.NewOperation(watcher, 10, struct{}{}, true)
+	assert.NoError(t, batcher.Enqueue(first), "not expecting an enqueue error")
+	assert.NoError(t, batcher.Enqueue(second), "not expecting an enqueue error")
+	original := <-raised
+	assert.Len(t, original.ops, 2)
+	var again raise
+	for i := 0; i < 2; i++ {
+		select {
+		case r := <-raised:
+			if len(r.ops) == 2 {
+				again = r
+			} else {
+				assert.Same(t, other, r.ops[0], "expecting other operations to not be packed with the requeued batch")
+			}
+		case <-time.After(1 * time.Second):
+			assert.FailNow(t, "expected the batch to be raised again before the timeout")
+		}
+	}
+	if assert.Len(t, again.ops, 2, "expecting the batch to be raised as a unit") {
+		assert.Same(t, first, again.ops[0])
+		assert.Same(t, second, again.ops[1])
+	}
+	assert.GreaterOrEqual(t, int64(again.at.Sub(original.at)), int64(20*time.Millisecond), "expecting the delay to be honored")
+	<-first.Done()
+	assert.Equal(t, uint32(2), first.Attempt(), "expecting attempts to be preserved")
+	assert.Equal(t, gobatcher.ResultSucceeded, first.Result().Status)
+	assert.Eventually(t, func() bool { return batcher.NeedsCapacity() == 0 }, time.Second, time.Millisecond, "expecting the target to be released")
+}
+
+func TestBatcher_RequeueAll_RespectsMaxAttempts(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Millisecond)
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	errs := make(chan error, 2)
+	watcher := gobatcher.NewBatchWatcher(func(batch gobatcher.Batch) {
+		errs <- batch.RequeueAll(0)
+	}).WithMaxAttempts(2)
+	op := gobatcher.NewOperation(watcher, 10, struct{}{}, true)
+	assert.NoError(t, batcher.Enqueue(op), "not expecting an enqueue error")
+	assert.NoError(t, <-errs, "expecting the first requeue to be allowed")
+	assert.Equal(t, gobatcher.TooManyAttemptsError, <-errs, "expecting the second requeue to exceed MaxAttempts")
+	<-op.Done()
+	assert.Equal(t, uint32(2), op.Result().Attempt)
+}
+
+func TestBatch_RequeueAll_IsNotAllowedOutsideOfBatcher(t *testing.T) {
+	var err error
+	watcher := gobatcher.NewBatchWatcher(func(batch gobatcher.Batch) {
+		err = batch.RequeueAll(0)
+	})
+	watcher.ProcessBatch([]gobatcher.Operation{gobatcher.NewOperation(watcher, 10, struct{}{}, true)})
+	assert.Equal(t, gobatcher.BatchNotRequeueableError, err)
+}
+
+func TestBatcher_Reserve_BatchWaitsForCapacitySpentByAnotherConsumer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	res := gobatcher.NewSharedResource().
+		WithReservedCapacity(1000)
+	batcher := gobatcher.NewBatcher().
+		WithRateLimiter(res).
+		WithFlushInterval(10 * time.Millisecond)
+	err := res.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	err = batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	other, err := res.Reserve(1000, time.Minute)
+	assert.NoError(t, err, "expecting another consumer to reserve all capacity")
+	var processed uint32
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		atomic.AddUint32(&processed, uint32(len(batch)))
+	})
+	err = batcher.Enqueue(gobatcher.NewOperation(watcher, 10, struct{}{}, false))
+	assert.NoError(t, err, "not expecting an enqueue error")
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, uint32(0), atomic.LoadUint32(&processed), "expecting no processing while the capacity is reserved elsewhere")
+	assert.Equal(t, uint32(1), batcher.OperationsInBuffer(), "expecting the operation to be put back in the buffer")
+	other.Release()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, uint32(1), atomic.LoadUint32(&processed), "expecting processing once the capacity was released")
+	assert.Equal(t, uint32(0), batcher.OperationsInBuffer())
+}
+
+func TestBatcher_AlignToRenewal_FlushesOnRenewalBoundary(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	res := gobatcher.NewSharedResource().
+		WithReservedCapacity(1000)
+	batcher := gobatcher.NewBatcher().
+		WithRateLimiter(res).
+		WithFlushInterval(10 * time.Minute).
+		WithCapacityInterval(10 * time.Minute).
+		WithAlignToRenewal().
+		WithEmitFlush().
+		WithEmitRequest()
+	flushed := make(chan time.Time, 1)
+	requested := make(chan time.Time, 1)
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		switch event {
+		case gobatcher.FlushStartEvent:
+			select {
+			case flushed <- time.Now():
+			default:
+			}
+		case gobatcher.RequestEvent:
+			select {
+			case requested <- time.Now():
+			default:
+			}
+		}
+	})
+	err := res.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	err = batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	for _, ch := range []chan time.Time{requested, flushed} {
+		select {
+		case at := <-ch:
+			offset := at.Sub(at.Truncate(time.Second))
+			assert.Less(t, int64(offset), int64(100*time.Millisecond), "expecting the event to be aligned to the start of a second")
+		case <-time.After(2 * time.Second):
+			assert.Fail(t, "expected an aligned event before the timeout")
+		}
+	}
+}
+
+func BenchmarkBatcher_Stats_UnderLoad(b *testing.B) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Millisecond)
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {})
+	err := batcher.Start(ctx)
+	assert.NoError(b, err, "not expecting a start error")
+	go func() {
+		for ctx.Err() == nil {
+			_ = batcher.Enqueue(gobatcher.NewOperation(watcher, 1, struct{}{}, true))
+		}
+	}()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_ = batcher.Stats()
+			_ = batcher.OperationsInBuffer()
+			_ = batcher.NeedsCapacity()
+		}
+	})
+}
+
+// Since the getters do not take a lock, Enqueue throughput should not drop when they are called in a tight loop (given spare cores
+// for the readers to run on).
+func BenchmarkBatcher_Enqueue_WithConcurrentStats(b *testing.B) {
+	for _, readers := range []int{0, 4} {
+		b.Run(fmt.Sprintf("readers=%d", readers), func(b *testing.B) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			batcher := gobatcher.NewBatcher().
+				WithFlushInterval(1 * time.Millisecond)
+			watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {})
+			err := batcher.Start(ctx)
+			assert.NoError(b, err, "not expecting a start error")
+			for i := 0; i < readers; i++ {
+				go func() {
+					for ctx.Err() == nil {
+						_ = batcher.Stats()
+					}
+				}()
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_ = batcher.Enqueue(gobatcher.NewOperation(watcher, 1, struct{}{}, true))
+			}
+		})
+	}
+}
+
+func TestBatcher_Workers_BatchesRunOnAFixedNumberOfWorkers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithWorkers(2).
+		WithFlushInterval(1 * time.Millisecond)
+	var running, peak int32
+	release := make(chan struct{})
+	var done sync.WaitGroup
+	done.Add(6)
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		defer done.Done()
+		current := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			before := atomic.LoadInt32(&peak)
+			if current <= before || atomic.CompareAndSwapInt32(&peak, before, current) {
+				break
+			}
+		}
+		<-release
+	})
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	for i := 0; i < 6; i++ {
+		err := batcher.Enqueue(gobatcher.NewOperation(watcher, 0, i, false))
+		assert.NoError(t, err, "not expecting an enqueue error")
+	}
+	assert.Eventually(t, func() bool {
+		return batcher.Stats().Running == 4
+	}, time.Second, time.Millisecond, "expecting a batch on each worker and a batch queued for each")
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&running) == 2
+	}, time.Second, time.Millisecond, "expecting the workers to process batches")
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, uint32(4), batcher.Stats().Running, "expecting no more batches to start while the queue is full")
+	assert.Equal(t, uint32(2), batcher.OperationsInBuffer(), "expecting the rest to stay in the buffer")
+	close(release)
+	done.Wait()
+	assert.Equal(t, int32(2), atomic.LoadInt32(&peak), "expecting no more batches than workers at a time")
+}
+
+func TestBatcher_Workers_ShutdownIsNotBlockedByAFullPool(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithWorkers(1).
+		WithMaxOperationTime(20 * time.Millisecond).
+		WithFlushInterval(1 * time.Millisecond)
+	release := make(chan struct{})
+	defer close(release)
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		<-release
+	})
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	for i := 0; i < 5; i++ {
+		err := batcher.Enqueue(gobatcher.NewOperation(watcher, 0, i, false))
+		assert.NoError(t, err, "not expecting an enqueue error")
+	}
+	time.Sleep(100 * time.Millisecond) // every batch has timed out but the worker is still held by the watcher
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer shutdownCancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- batcher.Shutdown(shutdownCtx)
+	}()
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, context.DeadlineExceeded, "expecting the context error since the buffer could not be drained")
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "expecting Shutdown() to return once its context is done")
+	}
 }
 
-func TestBatcher_Flush(t *testing.T) {
+func TestBatcher_BatchPooling_ReusedSlicesHoldOnlyTheirBatch(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	var err error
-	batcher := gobatcher.NewBatcher().WithFlushInterval(10 * time.Minute)
-	err = batcher.Start(ctx)
-	assert.NoError(t, err, "not expecting a start error")
-	completed := make(chan bool, 1)
+	batcher := gobatcher.NewBatcher().
+		WithBatchPooling().
+		WithFlushInterval(1 * time.Millisecond)
+	raised := make(chan []int, 1)
 	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
-		completed <- true
-	})
-	op := gobatcher.NewOperation(watcher, 100, struct{}{}, false)
-	err = batcher.Enqueue(op)
-	assert.NoError(t, err, "not expecting an enqueue error")
-	batcher.Flush()
-	select {
-	case <-completed:
-	case <-time.After(1 * time.Second):
-		assert.Fail(t, "expected the manual flush to have completed the batch before the timeout")
+		payloads := make([]int, 0, len(batch))
+		for _, op := range batch {
+			payloads = append(payloads, op.Payload().(int))
+		}
+		raised <- payloads
+	}).WithMaxBatchSize(3)
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	for round := 0; round < 10; round++ {
+		size := round%3 + 1
+		ops := make([]gobatcher.Operation, 0, size)
+		expected := make([]int, 0, size)
+		for i := 0; i < size; i++ {
+			ops = append(ops, gobatcher.NewOperation(watcher, 0, round*10+i, true))
+			expected = append(expected, round*10+i)
+		}
+		err := batcher.EnqueueMany(ops)
+		assert.NoError(t, err, "not expecting an enqueue error")
+		assert.Equal(t, expected, <-raised, "expecting each batch to only hold its own operations")
+		for _, op := range ops {
+			<-op.Done()
+		}
 	}
 }
 
-type TestMaxConcurrentBatchesSuite struct {
-	suite.Suite
-	batcher  gobatcher.Batcher
-	listener uuid.UUID
-	wg       *sync.WaitGroup
-	cancel   context.CancelFunc
+func TestBatcher_ShardedBuffer_OperationsFromManyGoroutinesAreProcessed(t *testing.T) {
+	const producers, perProducer = 50, 20
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithBufferImplementation(gobatcher.ShardedBuffer).
+		WithFlushInterval(1 * time.Millisecond)
+	var done sync.WaitGroup
+	done.Add(producers * perProducer)
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		done.Add(-len(batch))
+	})
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	var enqueued sync.WaitGroup
+	for i := 0; i < producers; i++ {
+		enqueued.Add(1)
+		go func() {
+			defer enqueued.Done()
+			for j := 0; j < perProducer; j++ {
+				err := batcher.Enqueue(gobatcher.NewOperation(watcher, 1, struct{}{}, true))
+				assert.NoError(t, err, "not expecting an enqueue error")
+			}
+		}()
+	}
+	enqueued.Wait()
+	done.Wait()
+	assert.Equal(t, uint64(producers*perProducer), batcher.Stats().Enqueued)
 }
 
-func (s *TestMaxConcurrentBatchesSuite) BeforeTest(suiteName, testName string) {
+func TestBatcher_Stats_CountsAreCumulative(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
-	s.cancel = cancel
-	s.wg = &sync.WaitGroup{}
-	s.batcher = gobatcher.NewBatcher().
-		WithFlushInterval(10 * time.Minute).
-		WithEmitBatch().
-		WithEmitFlush()
-	switch testName {
-	case "TestBatcher_BatchPacking":
-		s.batcher.WithMaxConcurrentBatches(1)
-	default:
-		s.batcher.WithMaxConcurrentBatches(2)
+	defer cancel()
+	res := gobatcher.NewSharedResource().
+		WithReservedCapacity(1000)
+	batcher := gobatcher.NewBatcher().
+		WithRateLimiter(res).
+		WithFlushInterval(1 * time.Millisecond)
+	var done sync.WaitGroup
+	done.Add(2)
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		done.Add(-len(batch))
+	}).WithMaxBatchSize(1)
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	for i := 0; i < 2; i++ {
+		err = batcher.Enqueue(gobatcher.NewOperation(watcher, 1, struct{}{}, false))
+		assert.NoError(t, err, "not expecting an enqueue error")
 	}
-	s.listener = s.batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
-		switch event {
-		case gobatcher.FlushDoneEvent:
-			s.wg.Done()
-		case gobatcher.BatchEvent:
-			s.wg.Add(1)
-		}
-	})
-	err := s.batcher.Start(ctx)
-	s.NoError(err, "not expecting a start error")
-}
+	done.Wait()
+	assert.Eventually(t, func() bool {
+		stats := batcher.Stats()
+		return stats.Batches == 2 && stats.Operations == 2
+	}, time.Second, time.Millisecond, "expecting each batch and operation to be counted")
+	stats := batcher.Stats()
+	assert.Equal(t, uint64(2), stats.Enqueued, "expecting each enqueue to be counted")
+	assert.Equal(t, uint64(0), stats.Failed, "expecting no failures")
+	assert.Equal(t, 1.0, stats.AverageBatchSize, "expecting batches of 1")
+	assert.Equal(t, uint32(1), stats.P95BatchSize, "expecting batches of 1")
+	assert.Greater(t, stats.Uptime, time.Duration(0), "expecting the time since start")
 
-func (s *TestMaxConcurrentBatchesSuite) TearDownTest() {
-	s.batcher.RemoveListener(s.listener)
-	s.cancel()
+	var out struct {
+		Batcher      gobatcher.BatcherStats
+		RateLimiters map[string]gobatcher.RateLimiterStats
+	}
+	v := gobatcher.NewExpvar(batcher, map[string]gobatcher.RateLimiter{"reserved": res})
+	err = json.Unmarshal([]byte(v.String()), &out)
+	assert.NoError(t, err, "expecting the expvar to be JSON")
+	assert.Equal(t, uint64(2), out.Batcher.Batches, "expecting the stats of the batcher")
+	assert.Equal(t, uint32(1000), out.RateLimiters["reserved"].MaxCapacity, "expecting the capacity of each rate limiter")
 }
 
-func (s *TestMaxConcurrentBatchesSuite) TestBatcher_ConcurrencyIsEnforced() {
-	var batches uint32
+func TestBatcher_Stats_BatchSizesAndFailures(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Millisecond)
 	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
-		atomic.AddUint32(&batches, 1)
-		time.Sleep(15 * time.Millisecond) // NOTE: simulate a long-running operation
-		s.wg.Done()
-	})
-	for i := 0; i < 3; i++ {
-		op := gobatcher.NewOperation(watcher, 0, struct{}{}, false)
-		err := s.batcher.Enqueue(op)
-		s.NoError(err, "not expecting an enqueue error")
+		batch[0].SetResult(gobatcher.Result{Status: gobatcher.ResultFailed, Err: errors.New("failed")})
+	}).WithMaxBatchSize(10)
+	ops := make([]gobatcher.Operation, 0, 30)
+	for i := 0; i < 30; i++ {
+		ops = append(ops, gobatcher.NewOperation(watcher, 0, struct{}{}, true))
 	}
-	s.wg.Add(1)
-	s.batcher.Flush()
-	s.wg.Wait()
-	s.Equal(uint32(2), atomic.LoadUint32(&batches))
-	s.Equal(uint32(1), s.batcher.OperationsInBuffer())
+	err := batcher.EnqueueMany(ops)
+	assert.NoError(t, err, "not expecting an enqueue error")
+	before := batcher.Stats()
+	assert.NotZero(t, before.EstimatedMemory, "expecting the operations in the buffer to hold memory")
+	before.EstimatedMemory = 0
+	assert.Equal(t, gobatcher.BatcherStats{OperationsInBuffer: 30, Enqueued: 30}, before, "expecting nothing else before start")
+	err = batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	assert.Eventually(t, func() bool {
+		return batcher.Stats().Operations == 30
+	}, time.Second, time.Millisecond, "expecting all operations to be processed")
+	stats := batcher.Stats()
+	assert.Equal(t, uint64(3), stats.Batches, "expecting batches of 10")
+	assert.Equal(t, uint64(3), stats.Failed, "expecting the first operation of each batch to fail")
+	assert.Equal(t, 10.0, stats.AverageBatchSize, "expecting batches of 10")
+	assert.Equal(t, uint32(10), stats.P95BatchSize, "expecting batches of 10")
 }
 
-func (s *TestMaxConcurrentBatchesSuite) TestBatcher_ConcurrencyIsEnforcedWithBatchable() {
-	var batches uint32
-	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
-		atomic.AddUint32(&batches, 1)
-		time.Sleep(15 * time.Millisecond) // NOTE: simulate a long-running operation
-		s.wg.Done()
-	}).WithMaxBatchSize(2)
-	for i := 0; i < 5; i++ {
+func TestBatcher_WithTracerProvider_BatchSpansAreLinkedToEnqueueSpans(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Millisecond).
+		WithTracerProvider(tp)
+	var batchSpan trace.SpanContext
+	done := make(chan struct{})
+	watcher := gobatcher.NewWatcherWithError(func(ctx context.Context, batch []gobatcher.Operation) error {
+		batchSpan = trace.SpanContextFromContext(ctx)
+		close(done)
+		return nil
+	}).WithLabel("writes")
+	requestCtx, request := tp.Tracer("test").Start(ctx, "request")
+	var ops []gobatcher.Operation
+	for i := 0; i < 2; i++ {
 		op := gobatcher.NewOperation(watcher, 0, struct{}{}, true)
-		err := s.batcher.Enqueue(op)
-		s.NoError(err, "not expecting an enqueue error")
+		err := batcher.EnqueueWithContext(requestCtx, op)
+		assert.NoError(t, err, "expecting no error on enqueue")
+		ops = append(ops, op)
+	}
+	request.End()
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "expecting no error on start")
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		assert.Fail(t, "expecting the batch to be raised")
+	}
+	assert.Eventually(t, func() bool {
+		return len(recorder.Ended()) == 4
+	}, time.Second, 10*time.Millisecond, "expecting the request, enqueue, and batch spans to end")
+	var batch sdktrace.ReadOnlySpan
+	var enqueued []trace.SpanContext
+	for _, span := range recorder.Ended() {
+		switch span.Name() {
+		case "gobatcher.enqueue":
+			assert.Equal(t, request.SpanContext().SpanID(), span.Parent().SpanID(), "expecting the enqueue span to be a child of the request")
+			enqueued = append(enqueued, span.SpanContext())
+		case "gobatcher.batch":
+			batch = span
+		}
+	}
+	assert.Equal(t, []trace.SpanContext{ops[0].SpanContext(), ops[1].SpanContext()}, enqueued, "expecting each operation to have its enqueue span")
+	if assert.NotNil(t, batch, "expecting a batch span") {
+		assert.Equal(t, batchSpan, batch.SpanContext(), "expecting the watcher to receive the batch span in its context")
+		assert.NotEqual(t, request.SpanContext().TraceID(), batch.SpanContext().TraceID(), "expecting the batch span to be a new trace")
+		assert.Len(t, batch.Links(), 2, "expecting the batch span to be linked to each operation")
+		for i, link := range batch.Links() {
+			assert.Equal(t, ops[i].SpanContext(), link.SpanContext, "expecting the link to be the enqueue span")
+		}
 	}
-	s.wg.Add(1)
-	s.batcher.Flush()
-	s.wg.Wait()
-	s.Equal(uint32(2), atomic.LoadUint32(&batches))
-	s.Equal(uint32(1), s.batcher.OperationsInBuffer())
 }
 
-func (s *TestMaxConcurrentBatchesSuite) TestBatcher_SlotsAreAvailableOnDone() {
-	var batches uint32
-	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
-		atomic.AddUint32(&batches, 1)
-		time.Sleep(15 * time.Millisecond) // NOTE: simulate a long-running operation
-		s.wg.Done()
+func TestBatcher_AddListener_OnlyEventsFiltersTheEvents(t *testing.T) {
+	batcher := gobatcher.NewBatcher()
+	var events []string
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		events = append(events, event)
+	}, gobatcher.OnlyEvents(gobatcher.AuditFailEvent, gobatcher.BatchEvent))
+	batcher.Emit(gobatcher.RequestEvent, 100, "", nil)
+	batcher.Emit(gobatcher.AuditFailEvent, 0, "", nil)
+	batcher.Emit(gobatcher.CapacityEvent, 100, "", nil)
+	batcher.Emit(gobatcher.BatchEvent, 1, "", nil)
+	assert.Equal(t, []string{gobatcher.AuditFailEvent, gobatcher.BatchEvent}, events, "expecting only the requested events")
+}
+
+func TestBatcher_RemoveListener_ListenersMayRemoveThemselves(t *testing.T) {
+	batcher := gobatcher.NewBatcher()
+	var calls int
+	var id uuid.UUID
+	id = batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		calls++
+		batcher.RemoveListener(id)
 	})
-	for i := 0; i < 5; i++ {
-		op := gobatcher.NewOperation(watcher, 0, struct{}{}, false)
-		err := s.batcher.Enqueue(op)
-		s.NoError(err, "not expecting an enqueue error")
-	}
-	s.wg.Add(1)
-	s.batcher.Flush()
-	s.wg.Wait()
-	s.Equal(uint32(2), atomic.LoadUint32(&batches))
-	s.Equal(uint32(3), s.batcher.OperationsInBuffer())
-	s.wg.Add(1)
-	s.batcher.Flush()
-	s.wg.Wait()
-	s.Equal(uint32(4), atomic.LoadUint32(&batches))
-	s.Equal(uint32(1), s.batcher.OperationsInBuffer())
+	var others int
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		others++
+	})
+	batcher.Emit(gobatcher.AuditFailEvent, 0, "", nil)
+	batcher.Emit(gobatcher.AuditFailEvent, 0, "", nil)
+	assert.Equal(t, 1, calls, "expecting the listener to not be called once it removed itself")
+	assert.Equal(t, 2, others, "expecting the other listeners to be unaffected")
+	assert.Equal(t, 1, batcher.ListenerCount())
 }
 
-func (s *TestMaxConcurrentBatchesSuite) TestBatcher_RunningOpHoldsSlot() {
-	var batches uint32
-	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
-		atomic.AddUint32(&batches, 1)
-		s.wg.Done()
-		time.Sleep(15 * time.Millisecond) // NOTE: simulate a long-running operation
+func TestBatcher_AddListener_ForWatcherOnlyRaisesEventsAboutTheWatcher(t *testing.T) {
+	batcher := gobatcher.NewBatcher()
+	mine := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {}).WithLabel("mine")
+	other := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {}).WithLabel("other")
+	var events []string
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		events = append(events, event)
+	}, gobatcher.ForWatcher(mine), gobatcher.OnlyEvents(gobatcher.BatchEvent, gobatcher.DeadLetterEvent, gobatcher.CooldownEvent,
+		gobatcher.TimeoutEvent, gobatcher.ShutdownEvent))
+	mineOp := gobatcher.NewOperation(mine, 10, struct{}{}, true)
+	otherOp := gobatcher.NewOperation(other, 10, struct{}{}, true)
+	batcher.Emit(gobatcher.BatchEvent, 1, "", []gobatcher.BatchedOperation{{ID: otherOp.ID(), Watcher: "other"}})
+	batcher.Emit(gobatcher.BatchEvent, 1, "", []gobatcher.BatchedOperation{{ID: mineOp.ID(), Watcher: "mine"}})
+	batcher.Emit(gobatcher.DeadLetterEvent, 1, "", otherOp)
+	batcher.Emit(gobatcher.DeadLetterEvent, 1, "", mineOp)
+	batcher.Emit(gobatcher.CooldownEvent, 100, "", other)
+	batcher.Emit(gobatcher.CooldownEvent, 100, "", mine)
+	batcher.Emit(gobatcher.TimeoutEvent, 1, "", []gobatcher.Operation{otherOp})
+	batcher.Emit(gobatcher.TimeoutEvent, 1, "", []gobatcher.Operation{mineOp})
+	batcher.Emit(gobatcher.ShutdownEvent, 0, "", nil)
+	expected := []string{gobatcher.BatchEvent, gobatcher.DeadLetterEvent, gobatcher.CooldownEvent, gobatcher.TimeoutEvent}
+	assert.Equal(t, expected, events, "expecting only the events about the watcher")
+}
+
+func TestBatcher_WithAsyncEvents_SlowListenersDoNotBlockEmit(t *testing.T) {
+	batcher := gobatcher.NewBatcher().
+		WithAsyncEvents(2)
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var vals []int
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		<-release
+		mu.Lock()
+		defer mu.Unlock()
+		vals = append(vals, val)
 	})
-	for i := 0; i < 3; i++ {
-		op := gobatcher.NewOperation(watcher, 0, struct{}{}, false)
-		err := s.batcher.Enqueue(op)
-		s.NoError(err, "not expecting an enqueue error")
+	started := time.Now()
+	for i := 0; i < 10; i++ {
+		batcher.Emit(gobatcher.RequestEvent, i, "", nil)
+	}
+	assert.Less(t, time.Since(started), 100*time.Millisecond, "expecting emit not to wait for the listener")
+	close(release)
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(vals)+int(batcher.DroppedEvents()) == 10
+	}, time.Second, 10*time.Millisecond, "expecting every event to be raised or dropped")
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Greater(t, batcher.DroppedEvents(), uint64(0), "expecting events to be dropped once the buffer is full")
+	for i := 1; i < len(vals); i++ {
+		assert.Less(t, vals[i-1], vals[i], "expecting the events to be raised in order")
 	}
-	s.wg.Add(1)
-	s.batcher.Flush()
-	s.wg.Wait()
-	s.wg.Add(1)
-	s.batcher.Flush()
-	s.wg.Wait()
-	s.Equal(uint32(2), atomic.LoadUint32(&batches))
-	s.Equal(uint32(1), s.batcher.OperationsInBuffer())
 }
 
-func (s *TestMaxConcurrentBatchesSuite) TestBatcher_BatchPacking() {
-	var err error
-	var batches uint32
-	watcher1 := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
-		s.Equal(2, len(batch))
-		atomic.AddUint32(&batches, 1)
-		s.wg.Done()
-	})
-	watcher2 := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
-		s.FailNow("expecting never to see this batch raised")
+func TestBatcher_WithAsyncEvents_ShutdownWaitsForQueuedEvents(t *testing.T) {
+	ctx := context.Background()
+	batcher := gobatcher.NewBatcher().
+		WithAsyncEvents(100).
+		WithClearListenersOnShutdown()
+	var shutdown int32
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		time.Sleep(10 * time.Millisecond)
+		if event == gobatcher.ShutdownEvent {
+			atomic.StoreInt32(&shutdown, 1)
+		}
 	})
-	op1 := gobatcher.NewOperation(watcher1, 0, struct{}{}, true)
-	err = s.batcher.Enqueue(op1)
-	s.NoError(err, "not expecting an enqueue error")
-	op2 := gobatcher.NewOperation(watcher2, 0, struct{}{}, true)
-	err = s.batcher.Enqueue(op2)
-	s.NoError(err, "not expecting an enqueue error")
-	op3 := gobatcher.NewOperation(watcher1, 0, struct{}{}, true)
-	err = s.batcher.Enqueue(op3)
-	s.NoError(err, "not expecting an enqueue error")
-	s.wg.Add(1)
-	s.batcher.Flush()
-	s.wg.Wait()
-	s.Equal(uint32(1), atomic.LoadUint32(&batches), "expecting watcher1 to see a batch once with 2 items")
-	s.Equal(uint32(1), s.batcher.OperationsInBuffer())
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "expecting no error on start")
+	err = batcher.Shutdown(ctx)
+	assert.NoError(t, err, "expecting no error on shutdown")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&shutdown), "expecting the shutdown event to be raised before Shutdown() returns")
+	assert.Equal(t, 0, batcher.ListenerCount(), "expecting the listeners to be cleared")
 }
 
-func TestBatcher_MaxConcurrentBatches(t *testing.T) {
-	suite.Run(t, new(TestMaxConcurrentBatchesSuite))
+func TestBatcher_WithEmitOperations_RaisesEachMilestone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Millisecond).
+		WithEmitOperations()
+	var mu sync.Mutex
+	milestones := make(map[uint64][]string)
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		if op, ok := metadata.(gobatcher.Operation); ok {
+			mu.Lock()
+			defer mu.Unlock()
+			milestones[op.ID()] = append(milestones[op.ID()], event+":"+msg)
+		}
+	})
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {})
+	op1 := gobatcher.NewOperation(watcher, 10, struct{}{}, true)
+	op2 := gobatcher.NewOperation(watcher, 10, struct{}{}, true)
+	err := batcher.EnqueueMany([]gobatcher.Operation{op1, op2})
+	assert.NoError(t, err, "expecting no error on enqueue")
+	err = batcher.Start(ctx)
+	assert.NoError(t, err, "expecting no error on start")
+	<-op1.Done()
+	<-op2.Done()
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []string{"operation-enqueued:", "operation-batched:", "operation-completed:succeeded"}
+	assert.Equal(t, expected, milestones[op1.ID()], "expecting each milestone of the first operation")
+	assert.Equal(t, expected, milestones[op2.ID()], "expecting each milestone of the second operation")
 }
 
-func TestBatcher_Operation_PayloadIsValid(t *testing.T) {
+func TestBatcher_WithEmitOperations_OperationsLeftInTheBufferAreDropped(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Hour).
+		WithEmitOperations()
+	dropped := make(chan gobatcher.Operation, 1)
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		dropped <- metadata.(gobatcher.Operation)
+	}, gobatcher.OnlyEvents(gobatcher.OperationDroppedEvent))
 	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {})
-	payload := struct{}{}
-	operation := gobatcher.NewOperation(watcher, 0, payload, false)
-	assert.Equal(t, payload, operation.Payload())
+	op := gobatcher.NewOperation(watcher, 10, struct{}{}, true)
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "expecting no error on start")
+	err = batcher.Enqueue(op)
+	assert.NoError(t, err, "expecting no error on enqueue")
+	cancel()
+	select {
+	case actual := <-dropped:
+		assert.Equal(t, op.ID(), actual.ID(), "expecting the operation in the buffer to be dropped")
+	case <-time.After(time.Second):
+		assert.Fail(t, "expecting an operation-dropped event")
+	}
 }
@@ -0,0 +1,82 @@
+package batchertest
+
+import (
+	"reflect"
+	"testing"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+)
+
+// This method fails the test unless the watcher was asked to process batches of exactly these sizes. Batches are processed
+// concurrently, so the order does not matter.
+func AssertBatchSizes(t testing.TB, w *CapturingWatcher, sizes ...int) {
+	t.Helper()
+	actual := w.BatchSizes()
+	expected := make([]interface{}, 0, len(sizes))
+	for _, size := range sizes {
+		expected = append(expected, size)
+	}
+	found := make([]interface{}, 0, len(actual))
+	for _, size := range actual {
+		found = append(found, size)
+	}
+	if !sameElements(expected, found) {
+		t.Errorf("expected batches of sizes %v, but they were %v", sizes, actual)
+	}
+}
+
+// This method fails the test unless the watcher was asked to process exactly these payloads. Batches are processed concurrently, so
+// the order does not matter.
+func AssertPayloads(t testing.TB, w *CapturingWatcher, payloads ...interface{}) {
+	t.Helper()
+	if actual := w.Payloads(); !sameElements(payloads, actual) {
+		t.Errorf("expected the payloads %v, but they were %v", payloads, actual)
+	}
+}
+
+// This method fails the test unless the recorder recorded exactly this number of events with the provided name.
+func AssertEventCount(t testing.TB, r *EventRecorder, name string, expected int) {
+	t.Helper()
+	if actual := r.Count(name); actual != expected {
+		t.Errorf("expected %d %s event(s), but there were %d", expected, name, actual)
+	}
+}
+
+// This method fails the test unless the recorder recorded at least one event with the provided name.
+func AssertEventRaised(t testing.TB, r *EventRecorder, name string) {
+	t.Helper()
+	if r.Count(name) == 0 {
+		t.Errorf("expected a %s event, but none was raised", name)
+	}
+}
+
+// This method fails the test unless every Operation has a final result with the provided status.
+func AssertResults(t testing.TB, status gobatcher.ResultStatus, ops ...gobatcher.Operation) {
+	t.Helper()
+	for i, op := range ops {
+		if result := op.Result(); result.Status != status {
+			t.Errorf("expected operation %d to be %v, but it was %v", i, status, result.Status)
+		}
+	}
+}
+
+// This returns true if both slices contain the same elements (compared with reflect.DeepEqual) the same number of times.
+func sameElements(expected, actual []interface{}) bool {
+	if len(expected) != len(actual) {
+		return false
+	}
+	matched := make([]bool, len(actual))
+	for _, e := range expected {
+		found := false
+		for i, a := range actual {
+			if !matched[i] && reflect.DeepEqual(e, a) {
+				matched[i], found = true, true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,138 @@
+// Package batchertest helps you unit test code that uses a Batcher without sleeps or real rate limiters. Batcher only processes when you
+// call Process(), CapturingWatcher records every batch, ScriptedWatcher scripts the outcome of each batch, EventRecorder records every
+// event, FakeLeaseManager leases partitions in memory for a SharedResource, and the Assert functions check what happened. It is not
+// needed at runtime.
+package batchertest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+	"github.com/plasne/go-batcher/v2/testutil"
+)
+
+// DefaultTimeout is how long Process() waits for the batches to finish unless WithTimeout() is used.
+const DefaultTimeout = 5 * time.Second
+
+// Batcher is a gobatcher.Batcher that never flushes on its own; its Clock is a testutil.FakeClock that only moves if you advance it.
+// Instead, call Process() to flush and wait until every batch has finished, so your test can make assertions right after without
+// sleeping. You may configure it with the WithXXXX methods before the first call to Process() (or Start()). Do not call Flush()
+// yourself, since Process() counts the flushes it requested.
+type Batcher struct {
+	gobatcher.Batcher
+	t       testing.TB
+	clock   *testutil.FakeClock
+	timeout time.Duration
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	mutex     sync.Mutex
+	flushes   []int // the number of Operations dispatched by each flush that Process() has not yet waited for
+	completed int   // the number of Operations whose batch has finished
+	changed   chan struct{}
+}
+
+// This method creates a new Batcher that is stopped when the test finishes.
+func NewBatcher(t testing.TB) *Batcher {
+	clock := testutil.NewFakeClock(time.Now())
+	b := &Batcher{
+		Batcher: gobatcher.NewBatcher().
+			WithClock(clock).
+			WithEmitFlush().
+			WithEmitOperations(),
+		t:       t,
+		clock:   clock,
+		timeout: DefaultTimeout,
+		changed: make(chan struct{}),
+	}
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+	t.Cleanup(b.cancel)
+	b.AddListener(b.onEvent, gobatcher.OnlyEvents(gobatcher.FlushDoneEvent, gobatcher.OperationCompletedEvent))
+	return b
+}
+
+// This determines how long Process() waits for the batches to finish before failing the test. The default is 5 seconds.
+func (b *Batcher) WithTimeout(val time.Duration) *Batcher {
+	b.timeout = val
+	return b
+}
+
+// This returns the FakeClock of the Batcher so you can advance it (for instance, to make a batch exceed its MaxOperationTime).
+func (b *Batcher) Clock() *testutil.FakeClock {
+	return b.clock
+}
+
+// This flushes the Batcher and waits until every batch it dispatched has finished, repeating until the buffer is empty or a flush
+// dispatches nothing (for instance, because the rate limiter has no capacity). It returns the number of Operations in the batches that
+// finished. The Batcher is started first if it was not already. If the batches do not finish within the timeout, the test fails.
+func (b *Batcher) Process() int {
+	b.t.Helper()
+	if err := b.Batcher.Start(b.ctx); err != nil && !errors.Is(err, gobatcher.ImproperOrderError) {
+		b.t.Fatalf("the batcher could not be started: %v", err)
+		return 0
+	}
+	b.mutex.Lock()
+	target := b.completed
+	b.mutex.Unlock()
+	var processed int
+	for {
+		b.Batcher.Flush()
+		var dispatched int
+		if !b.waitFor(func() bool {
+			if len(b.flushes) == 0 {
+				return false
+			}
+			dispatched, b.flushes = b.flushes[0], b.flushes[1:]
+			return true
+		}) {
+			b.t.Fatalf("the flush did not finish within %v", b.timeout)
+			return processed
+		}
+		target += dispatched
+		if !b.waitFor(func() bool { return b.completed >= target }) {
+			b.t.Fatalf("the batches did not finish within %v", b.timeout)
+			return processed
+		}
+		processed += dispatched
+		if dispatched == 0 || b.OperationsInBuffer() == 0 {
+			return processed
+		}
+	}
+}
+
+func (b *Batcher) onEvent(event string, val int, msg string, metadata interface{}) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	switch event {
+	case gobatcher.FlushDoneEvent:
+		stats, _ := metadata.(gobatcher.FlushStats)
+		b.flushes = append(b.flushes, stats.Operations)
+	case gobatcher.OperationCompletedEvent:
+		b.completed++
+	}
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// This waits until the condition (which is checked while holding the mutex) is true or the timeout is exceeded.
+func (b *Batcher) waitFor(condition func() bool) bool {
+	expired := time.After(b.timeout)
+	for {
+		b.mutex.Lock()
+		if condition() {
+			b.mutex.Unlock()
+			return true
+		}
+		changed := b.changed
+		b.mutex.Unlock()
+		select {
+		case <-changed:
+		case <-expired:
+			return false
+		}
+	}
+}
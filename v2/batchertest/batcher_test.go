@@ -0,0 +1,90 @@
+package batchertest_test
+
+import (
+	"errors"
+	"testing"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+	"github.com/plasne/go-batcher/v2/batchertest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatcher_ProcessDispatchesEverythingInTheBuffer(t *testing.T) {
+	batcher := batchertest.NewBatcher(t)
+	watcher := batchertest.NewCapturingWatcher(nil).WithMaxBatchSize(2)
+	events := batchertest.NewEventRecorder(batcher, gobatcher.OnlyEvents(gobatcher.BatchEvent))
+	defer events.Close()
+	var ops []gobatcher.Operation
+	for i := 0; i < 5; i++ {
+		op := gobatcher.NewOperation(watcher, 0, i, true)
+		ops = append(ops, op)
+		err := batcher.Enqueue(op)
+		assert.NoError(t, err, "expecting no error on enqueue")
+	}
+	processed := batcher.Process()
+	assert.Equal(t, 5, processed, "expecting every operation to be processed")
+	assert.Equal(t, uint32(0), batcher.OperationsInBuffer(), "expecting the buffer to be empty")
+	batchertest.AssertBatchSizes(t, watcher.(*batchertest.CapturingWatcher), 2, 2, 1)
+	batchertest.AssertPayloads(t, watcher.(*batchertest.CapturingWatcher), 0, 1, 2, 3, 4)
+	batchertest.AssertEventCount(t, events, gobatcher.BatchEvent, 0)
+	batchertest.AssertResults(t, gobatcher.ResultSucceeded, ops...)
+}
+
+func TestBatcher_ProcessWithNothingEnqueued(t *testing.T) {
+	batcher := batchertest.NewBatcher(t)
+	assert.Equal(t, 0, batcher.Process(), "expecting nothing to be processed")
+	assert.Equal(t, 0, batcher.Process(), "expecting process to be callable again")
+}
+
+func TestCapturingWatcher_BatchesAreKeptWithBatchPooling(t *testing.T) {
+	batcher := batchertest.NewBatcher(t)
+	batcher.WithBatchPooling()
+	watcher := batchertest.NewCapturingWatcher(nil).WithMaxBatchSize(3)
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 3; i++ {
+			err := batcher.Enqueue(gobatcher.NewOperation(watcher, 0, round*3+i, true))
+			assert.NoError(t, err, "expecting no error on enqueue")
+		}
+		assert.Equal(t, 3, batcher.Process(), "expecting every operation to be processed")
+	}
+	batchertest.AssertBatchSizes(t, watcher.(*batchertest.CapturingWatcher), 3, 3, 3)
+	batchertest.AssertPayloads(t, watcher.(*batchertest.CapturingWatcher), 0, 1, 2, 3, 4, 5, 6, 7, 8)
+}
+
+func TestCapturingWatcher_FailuresAreRecorded(t *testing.T) {
+	batcher := batchertest.NewBatcher(t)
+	events := batchertest.NewEventRecorder(batcher)
+	defer events.Close()
+	watcher := batchertest.NewCapturingWatcher(func(batch []gobatcher.Operation) {
+		for _, op := range batch {
+			op.SetResult(gobatcher.Result{Status: gobatcher.ResultFailed, Err: errors.New("rejected")})
+		}
+	})
+	op := gobatcher.NewOperation(watcher, 0, "a", false)
+	err := batcher.Enqueue(op)
+	assert.NoError(t, err, "expecting no error on enqueue")
+	batcher.Process()
+	batchertest.AssertResults(t, gobatcher.ResultFailed, op)
+	batchertest.AssertEventRaised(t, events, gobatcher.OperationCompletedEvent)
+	completed := events.Named(gobatcher.OperationCompletedEvent)
+	if assert.Len(t, completed, 1, "expecting one completed operation") {
+		assert.Equal(t, op, completed[0].Metadata, "expecting the operation as the metadata")
+	}
+	events.Reset()
+	assert.Empty(t, events.Events(), "expecting no events after reset")
+}
+
+func TestAssertions_FailWhenExpectationsAreNotMet(t *testing.T) {
+	mock := &testing.T{}
+	watcher := batchertest.NewCapturingWatcher(nil)
+	watcher.ProcessBatch([]gobatcher.Operation{gobatcher.NewOperation(watcher, 0, "a", true)})
+	batchertest.AssertBatchSizes(mock, watcher, 2)
+	assert.True(t, mock.Failed(), "expecting the batch sizes to not match")
+	mock = &testing.T{}
+	batchertest.AssertBatchSizes(mock, watcher, 1)
+	batchertest.AssertPayloads(mock, watcher, "a")
+	assert.False(t, mock.Failed(), "expecting the batch and payloads to match")
+	watcher.Reset()
+	batchertest.AssertBatchSizes(mock, watcher)
+	assert.False(t, mock.Failed(), "expecting no batches after reset")
+}
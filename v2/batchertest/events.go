@@ -0,0 +1,79 @@
+package batchertest
+
+import (
+	"sync"
+
+	"github.com/google/uuid"
+	gobatcher "github.com/plasne/go-batcher/v2"
+)
+
+// Event is a single event recorded by an EventRecorder.
+type Event struct {
+	Name     string
+	Val      int
+	Msg      string
+	Metadata interface{}
+}
+
+// EventRecorder records the events raised by a Batcher or rate limiter so you can assert on them after the fact rather than writing
+// a listener.
+type EventRecorder struct {
+	eventer  gobatcher.Eventer
+	listener uuid.UUID
+
+	mutex  sync.Mutex
+	events []Event
+}
+
+// This method attaches a new EventRecorder to the Batcher or rate limiter. You may provide ListenerOptions (such as
+// gobatcher.OnlyEvents()) to only record some events.
+func NewEventRecorder(eventer gobatcher.Eventer, opts ...gobatcher.ListenerOption) *EventRecorder {
+	r := &EventRecorder{
+		eventer: eventer,
+	}
+	r.listener = eventer.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		r.events = append(r.events, Event{Name: event, Val: val, Msg: msg, Metadata: metadata})
+	}, opts...)
+	return r
+}
+
+// This returns every event that was recorded, in order.
+func (r *EventRecorder) Events() []Event {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	events := make([]Event, len(r.events))
+	copy(events, r.events)
+	return events
+}
+
+// This returns the recorded events with the provided name, in order.
+func (r *EventRecorder) Named(name string) []Event {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var events []Event
+	for _, event := range r.events {
+		if event.Name == name {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// This returns the number of recorded events with the provided name.
+func (r *EventRecorder) Count(name string) int {
+	return len(r.Named(name))
+}
+
+// This forgets every event that was recorded.
+func (r *EventRecorder) Reset() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.events = nil
+}
+
+// This detaches the recorder from the Batcher or rate limiter.
+func (r *EventRecorder) Close() {
+	r.eventer.RemoveListener(r.listener)
+}
@@ -0,0 +1,204 @@
+package batchertest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+)
+
+// fakeLeases is the state shared by a FakeLeaseManager and its peers, as a real datastore would be shared by the instances.
+type fakeLeases struct {
+	mutex      sync.Mutex
+	leaseTime  time.Duration
+	holders    map[uint32]string
+	expires    map[uint32]time.Time
+	denied     map[uint32]bool
+	denyAll    bool
+	provision  error
+	partitions int
+	attempts   int
+}
+
+// FakeLeaseManager is an in-memory LeaseManager so you can test code that uses a SharedResource without a datastore or a bespoke mock.
+// A partition is granted to the first instance that leases it and denied (with a FailedEvent) to other instances until the lease time
+// elapses, it is released, or Expire() is called; Deny() and DenyAll() deny partitions regardless of who holds them. Use Peer() to create a
+// FakeLeaseManager for another SharedResource that competes for the same partitions.
+type FakeLeaseManager struct {
+	leases *fakeLeases
+
+	eventerMutex sync.Mutex
+	eventer      gobatcher.Eventer
+}
+
+// This method creates a new FakeLeaseManager that grants leases for 15 seconds (the same as AzureBlobLeaseManager).
+func NewFakeLeaseManager() *FakeLeaseManager {
+	return &FakeLeaseManager{
+		leases: &fakeLeases{
+			leaseTime: 15 * time.Second,
+			holders:   make(map[uint32]string),
+			expires:   make(map[uint32]time.Time),
+			denied:    make(map[uint32]bool),
+		},
+	}
+}
+
+// This method creates a FakeLeaseManager that shares partitions (and all settings) with this one, so it can be given to another
+// SharedResource to simulate another instance.
+func (m *FakeLeaseManager) Peer() *FakeLeaseManager {
+	return &FakeLeaseManager{
+		leases: m.leases,
+	}
+}
+
+// This determines how long a granted lease lasts, which is also the lease time returned by LeasePartition(). A short lease time lets you
+// test renewals without waiting.
+func (m *FakeLeaseManager) WithLeaseTime(val time.Duration) *FakeLeaseManager {
+	m.leases.mutex.Lock()
+	defer m.leases.mutex.Unlock()
+	m.leases.leaseTime = val
+	return m
+}
+
+// If set, Provision() returns this error.
+func (m *FakeLeaseManager) WithProvisionError(err error) *FakeLeaseManager {
+	m.leases.mutex.Lock()
+	defer m.leases.mutex.Unlock()
+	m.leases.provision = err
+	return m
+}
+
+// This method denies any lease on the provided partitions until Grant() is called.
+func (m *FakeLeaseManager) Deny(indexes ...uint32) {
+	m.leases.mutex.Lock()
+	defer m.leases.mutex.Unlock()
+	for _, index := range indexes {
+		m.leases.denied[index] = true
+	}
+}
+
+// This method denies any lease on every partition until GrantAll() is called.
+func (m *FakeLeaseManager) DenyAll() {
+	m.leases.mutex.Lock()
+	defer m.leases.mutex.Unlock()
+	m.leases.denyAll = true
+}
+
+// This method stops denying leases on the provided partitions.
+func (m *FakeLeaseManager) Grant(indexes ...uint32) {
+	m.leases.mutex.Lock()
+	defer m.leases.mutex.Unlock()
+	for _, index := range indexes {
+		delete(m.leases.denied, index)
+	}
+}
+
+// This method stops denying leases on every partition.
+func (m *FakeLeaseManager) GrantAll() {
+	m.leases.mutex.Lock()
+	defer m.leases.mutex.Unlock()
+	m.leases.denyAll = false
+	m.leases.denied = make(map[uint32]bool)
+}
+
+// This method ends the lease on the provided partitions so that any instance may lease them again.
+func (m *FakeLeaseManager) Expire(indexes ...uint32) {
+	m.leases.mutex.Lock()
+	defer m.leases.mutex.Unlock()
+	for _, index := range indexes {
+		delete(m.leases.holders, index)
+		delete(m.leases.expires, index)
+	}
+}
+
+// This returns the ID of the instance holding the lease on the partition, or "" if the partition is not leased.
+func (m *FakeLeaseManager) Holder(index uint32) string {
+	m.leases.mutex.Lock()
+	defer m.leases.mutex.Unlock()
+	if time.Now().Before(m.leases.expires[index]) {
+		return m.leases.holders[index]
+	}
+	return ""
+}
+
+// This returns the number of partitions requested by the last call to CreatePartitions().
+func (m *FakeLeaseManager) Partitions() int {
+	m.leases.mutex.Lock()
+	defer m.leases.mutex.Unlock()
+	return m.leases.partitions
+}
+
+// This returns how many times LeasePartition() was called across this FakeLeaseManager and its peers.
+func (m *FakeLeaseManager) LeaseAttempts() int {
+	m.leases.mutex.Lock()
+	defer m.leases.mutex.Unlock()
+	return m.leases.attempts
+}
+
+// This is called by SharedResource.WithSharedCapacity().
+func (m *FakeLeaseManager) RaiseEventsTo(e gobatcher.Eventer) {
+	m.eventerMutex.Lock()
+	defer m.eventerMutex.Unlock()
+	m.eventer = e
+}
+
+// This returns the error provided by WithProvisionError(), if any.
+func (m *FakeLeaseManager) Provision(ctx context.Context) error {
+	m.leases.mutex.Lock()
+	defer m.leases.mutex.Unlock()
+	return m.leases.provision
+}
+
+// This records the number of partitions and raises a CreatedBlobEvent for each.
+func (m *FakeLeaseManager) CreatePartitions(ctx context.Context, count int) {
+	m.leases.mutex.Lock()
+	m.leases.partitions = count
+	m.leases.mutex.Unlock()
+	for i := 0; i < count; i++ {
+		m.emit(gobatcher.CreatedBlobEvent, i)
+	}
+}
+
+// This grants the partition to the instance unless it is denied or leased by another instance, in which case a FailedEvent is raised
+// and 0 is returned.
+func (m *FakeLeaseManager) LeasePartition(ctx context.Context, id string, index uint32) time.Duration {
+	m.leases.mutex.Lock()
+	m.leases.attempts++
+	now := time.Now()
+	held := m.leases.holders[index] != id && now.Before(m.leases.expires[index])
+	if m.leases.denyAll || m.leases.denied[index] || held {
+		m.leases.mutex.Unlock()
+		m.emit(gobatcher.FailedEvent, int(index))
+		return 0
+	}
+	leaseTime := m.leases.leaseTime
+	m.leases.holders[index] = id
+	m.leases.expires[index] = now.Add(leaseTime)
+	m.leases.mutex.Unlock()
+	return leaseTime
+}
+
+// This renews the lease on a partition held by the instance, which is the same as leasing it again (and is counted by LeaseAttempts()).
+func (m *FakeLeaseManager) RenewPartition(ctx context.Context, id string, index uint32) time.Duration {
+	return m.LeasePartition(ctx, id, index)
+}
+
+// This releases the lease on a partition if it is still held by the instance.
+func (m *FakeLeaseManager) ReleasePartition(ctx context.Context, id string, index uint32) {
+	m.leases.mutex.Lock()
+	defer m.leases.mutex.Unlock()
+	if m.leases.holders[index] == id {
+		delete(m.leases.holders, index)
+		delete(m.leases.expires, index)
+	}
+}
+
+func (m *FakeLeaseManager) emit(event string, val int) {
+	m.eventerMutex.Lock()
+	eventer := m.eventer
+	m.eventerMutex.Unlock()
+	if eventer != nil {
+		eventer.Emit(event, val, "", nil)
+	}
+}
@@ -0,0 +1,62 @@
+package batchertest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+	"github.com/plasne/go-batcher/v2/batchertest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakeLeaseManager_PeersCompeteForPartitions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mgr1 := batchertest.NewFakeLeaseManager()
+	mgr2 := mgr1.Peer()
+	res1 := gobatcher.NewSharedResource().WithSharedCapacity(10000, mgr1).WithFactor(1000).WithMaxInterval(1)
+	res2 := gobatcher.NewSharedResource().WithSharedCapacity(10000, mgr2).WithFactor(1000).WithMaxInterval(1)
+	assert.NoError(t, res1.Start(ctx), "not expecting a start error")
+	assert.NoError(t, res2.Start(ctx), "not expecting a start error")
+	res1.GiveMe(6000)
+	res2.GiveMe(6000)
+	assert.Eventually(t, func() bool {
+		return res1.Capacity()+res2.Capacity() == 10000
+	}, 5*time.Second, 10*time.Millisecond, "expecting the instances to lease every partition")
+	assert.Equal(t, 10, mgr1.Partitions())
+	for i := uint32(0); i < 10; i++ {
+		assert.NotEmpty(t, mgr1.Holder(i), "expecting every partition to be held")
+	}
+	assert.LessOrEqual(t, res1.Capacity(), uint32(6000))
+	assert.LessOrEqual(t, res2.Capacity(), uint32(6000))
+}
+
+func TestFakeLeaseManager_DeniesAndExpiresLeases(t *testing.T) {
+	mgr := batchertest.NewFakeLeaseManager().WithLeaseTime(time.Minute)
+	var failed []int
+	eventer := &gobatcher.EventerBase{}
+	eventer.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		if event == gobatcher.FailedEvent {
+			failed = append(failed, val)
+		}
+	})
+	mgr.RaiseEventsTo(eventer)
+	peer := mgr.Peer()
+	peer.RaiseEventsTo(&gobatcher.EventerBase{})
+
+	mgr.Deny(2)
+	assert.Equal(t, time.Duration(0), mgr.LeasePartition(context.Background(), "a", 2), "expecting a denied partition to not be leased")
+	assert.Equal(t, []int{2}, failed, "expecting a failed event for the denied partition")
+	mgr.Grant(2)
+	assert.Equal(t, time.Minute, mgr.LeasePartition(context.Background(), "a", 2), "expecting the lease time once granted")
+	assert.Equal(t, time.Duration(0), peer.LeasePartition(context.Background(), "b", 2), "expecting a peer to not lease a held partition")
+	mgr.Expire(2)
+	assert.Equal(t, time.Minute, peer.LeasePartition(context.Background(), "b", 2), "expecting a peer to lease an expired partition")
+	assert.Equal(t, "b", mgr.Holder(2))
+	mgr.DenyAll()
+	assert.Equal(t, time.Duration(0), peer.LeasePartition(context.Background(), "b", 3))
+	mgr.GrantAll()
+	assert.Equal(t, time.Minute, peer.LeasePartition(context.Background(), "b", 3))
+	assert.Equal(t, 6, mgr.LeaseAttempts())
+}
@@ -0,0 +1,254 @@
+package batchertest
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+)
+
+var (
+	ScriptedFailureError = errors.New("the scripted watcher failed the operation.")
+)
+
+// Outcome describes what a ScriptedWatcher does with a single batch. The zero value succeeds immediately.
+type Outcome struct {
+	Latency     time.Duration // how long the batch takes to process
+	Err         error         // if set, the Operations fail with this error
+	FailIndexes []int         // if set, only the Operations at these indexes in the batch fail (partial failure)
+	Panic       interface{}   // if set, the watcher panics with this value after the latency
+}
+
+// This outcome marks every Operation in the batch as succeeded.
+func Succeed() Outcome {
+	return Outcome{}
+}
+
+// This outcome fails every Operation in the batch with the provided error (or ScriptedFailureError if nil).
+func Fail(err error) Outcome {
+	if err == nil {
+		err = ScriptedFailureError
+	}
+	return Outcome{Err: err}
+}
+
+// This outcome fails only the Operations at the provided indexes in the batch with the provided error (or ScriptedFailureError if nil);
+// the rest succeed.
+func FailSome(err error, indexes ...int) Outcome {
+	o := Fail(err)
+	o.FailIndexes = indexes
+	return o
+}
+
+// This outcome panics with the provided value.
+func Panic(val interface{}) Outcome {
+	return Outcome{Panic: val}
+}
+
+// This returns a copy of the outcome that takes the provided time to process the batch.
+func (o Outcome) After(latency time.Duration) Outcome {
+	o.Latency = latency
+	return o
+}
+
+// ScriptedWatcher is a Watcher whose behavior for each batch is scripted, so you can test how your code handles latency, failures,
+// partial failures, and panics without writing a bespoke fake. Each batch uses the next Outcome in the script; once the script is
+// exhausted, the Otherwise() outcome is used (success by default). Failures are reported with Operation.SetResult() so they flow to
+// Operation.Result(), the completion callback, and the dead-letter event exactly as they would for a real Watcher.
+type ScriptedWatcher struct {
+	watcher gobatcher.Watcher
+
+	mutex     sync.Mutex
+	script    []Outcome
+	otherwise Outcome
+	batches   [][]gobatcher.Operation
+}
+
+// This method creates a new ScriptedWatcher that will use the provided outcomes in order.
+func NewScriptedWatcher(outcomes ...Outcome) *ScriptedWatcher {
+	w := &ScriptedWatcher{
+		script: outcomes,
+	}
+	w.watcher = gobatcher.NewWatcher(w.process)
+	return w
+}
+
+// This method appends more outcomes to the script.
+func (w *ScriptedWatcher) Then(outcomes ...Outcome) *ScriptedWatcher {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.script = append(w.script, outcomes...)
+	return w
+}
+
+// This method determines the outcome used for every batch once the script is exhausted.
+func (w *ScriptedWatcher) Otherwise(outcome Outcome) *ScriptedWatcher {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.otherwise = outcome
+	return w
+}
+
+// This returns every batch the watcher has been asked to process, in order.
+func (w *ScriptedWatcher) Batches() [][]gobatcher.Operation {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	batches := make([][]gobatcher.Operation, len(w.batches))
+	copy(batches, w.batches)
+	return batches
+}
+
+// This returns the number of batches the watcher has been asked to process.
+func (w *ScriptedWatcher) Calls() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return len(w.batches)
+}
+
+func (w *ScriptedWatcher) WithMaxAttempts(val uint32) gobatcher.Watcher {
+	w.watcher.WithMaxAttempts(val)
+	return w
+}
+
+func (w *ScriptedWatcher) WithMaxBatchSize(val uint32) gobatcher.Watcher {
+	w.watcher.WithMaxBatchSize(val)
+	return w
+}
+
+func (w *ScriptedWatcher) WithMaxBatchBytes(val uint32) gobatcher.Watcher {
+	w.watcher.WithMaxBatchBytes(val)
+	return w
+}
+
+func (w *ScriptedWatcher) WithMinBatchSize(val uint32) gobatcher.Watcher {
+	w.watcher.WithMinBatchSize(val)
+	return w
+}
+
+func (w *ScriptedWatcher) WithMaxLinger(val time.Duration) gobatcher.Watcher {
+	w.watcher.WithMaxLinger(val)
+	return w
+}
+
+func (w *ScriptedWatcher) WithMaxOperationTime(val time.Duration) gobatcher.Watcher {
+	w.watcher.WithMaxOperationTime(val)
+	return w
+}
+
+func (w *ScriptedWatcher) WithCooldown(val time.Duration) gobatcher.Watcher {
+	w.watcher.WithCooldown(val)
+	return w
+}
+
+func (w *ScriptedWatcher) WithGroupByKey() gobatcher.Watcher {
+	w.watcher.WithGroupByKey()
+	return w
+}
+
+func (w *ScriptedWatcher) WithWeight(val uint32) gobatcher.Watcher {
+	w.watcher.WithWeight(val)
+	return w
+}
+
+func (w *ScriptedWatcher) WithLabel(val string) gobatcher.Watcher {
+	w.watcher.WithLabel(val)
+	return w
+}
+
+func (w *ScriptedWatcher) WithSplitter(fn func(ops []gobatcher.Operation) [][]gobatcher.Operation) gobatcher.Watcher {
+	w.watcher.WithSplitter(fn)
+	return w
+}
+
+func (w *ScriptedWatcher) WithRateLimiter(rl gobatcher.RateLimiter) gobatcher.Watcher {
+	w.watcher.WithRateLimiter(rl)
+	return w
+}
+
+func (w *ScriptedWatcher) MaxAttempts() uint32 {
+	return w.watcher.MaxAttempts()
+}
+
+func (w *ScriptedWatcher) MaxBatchSize() uint32 {
+	return w.watcher.MaxBatchSize()
+}
+
+func (w *ScriptedWatcher) MaxBatchBytes() uint32 {
+	return w.watcher.MaxBatchBytes()
+}
+
+func (w *ScriptedWatcher) MinBatchSize() uint32 {
+	return w.watcher.MinBatchSize()
+}
+
+func (w *ScriptedWatcher) MaxLinger() time.Duration {
+	return w.watcher.MaxLinger()
+}
+
+func (w *ScriptedWatcher) MaxOperationTime() time.Duration {
+	return w.watcher.MaxOperationTime()
+}
+
+func (w *ScriptedWatcher) Cooldown() time.Duration {
+	return w.watcher.Cooldown()
+}
+
+func (w *ScriptedWatcher) GroupByKey() bool {
+	return w.watcher.GroupByKey()
+}
+
+func (w *ScriptedWatcher) Weight() uint32 {
+	return w.watcher.Weight()
+}
+
+func (w *ScriptedWatcher) Label() string {
+	return w.watcher.Label()
+}
+
+func (w *ScriptedWatcher) Splitter() func(ops []gobatcher.Operation) [][]gobatcher.Operation {
+	return w.watcher.Splitter()
+}
+
+func (w *ScriptedWatcher) RateLimiter() gobatcher.RateLimiter {
+	return w.watcher.RateLimiter()
+}
+
+func (w *ScriptedWatcher) ProcessBatch(batch []gobatcher.Operation) {
+	w.watcher.ProcessBatch(batch)
+}
+
+func (w *ScriptedWatcher) next(batch []gobatcher.Operation) Outcome {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	index := len(w.batches)
+	w.batches = append(w.batches, append([]gobatcher.Operation(nil), batch...))
+	if index < len(w.script) {
+		return w.script[index]
+	}
+	return w.otherwise
+}
+
+func (w *ScriptedWatcher) process(batch []gobatcher.Operation) {
+	outcome := w.next(batch)
+	if outcome.Latency > 0 {
+		time.Sleep(outcome.Latency)
+	}
+	if outcome.Panic != nil {
+		panic(outcome.Panic)
+	}
+	if outcome.Err == nil {
+		return // the batcher marks the operations as succeeded
+	}
+	failed := make(map[int]bool)
+	for _, index := range outcome.FailIndexes {
+		failed[index] = true
+	}
+	for i, op := range batch {
+		if len(outcome.FailIndexes) == 0 || failed[i] {
+			op.SetResult(gobatcher.Failed(outcome.Err))
+		} else {
+			op.SetResult(gobatcher.Succeeded())
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package batchertest_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+	"github.com/plasne/go-batcher/v2/batchertest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScriptedWatcher_FollowsScript(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Millisecond)
+	deadLetters := make(chan gobatcher.Operation, 4)
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		if event == gobatcher.DeadLetterEvent {
+			deadLetters <- metadata.(gobatcher.Operation)
+		}
+	})
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+
+	downstream := errors.New("downstream failure")
+	watcher := batchertest.NewScriptedWatcher(
+		batchertest.FailSome(downstream, 1).After(5*time.Millisecond),
+		batchertest.Panic("poison"),
+	)
+	watcher.WithMaxAttempts(1).WithMaxBatchSize(2)
+
+	// the first batch partially fails
+	first := gobatcher.NewOperation(watcher, 1, struct{}{}, true)
+	second := gobatcher.NewOperation(watcher, 1, struct{}{}, true)
+	assert.NoError(t, batcher.Enqueue(first), "not expecting an enqueue error")
+	assert.NoError(t, batcher.Enqueue(second), "not expecting an enqueue error")
+	<-first.Done()
+	<-second.Done()
+	assert.Equal(t, gobatcher.ResultSucceeded, first.Result().Status)
+	assert.Equal(t, gobatcher.ResultFailed, second.Result().Status)
+	assert.Equal(t, downstream, second.Result().Err)
+	assert.GreaterOrEqual(t, int64(second.Result().Duration), int64(5*time.Millisecond), "expecting the latency to be applied")
+	assert.Same(t, second, <-deadLetters)
+
+	// the second batch panics
+	third := gobatcher.NewOperation(watcher, 1, struct{}{}, true)
+	assert.NoError(t, batcher.Enqueue(third), "not expecting an enqueue error")
+	<-third.Done()
+	assert.True(t, errors.Is(third.Result().Err, gobatcher.WatcherPanicError), "expecting the panic to fail the operation")
+	assert.Same(t, third, <-deadLetters)
+
+	// the script is exhausted so it succeeds
+	fourth := gobatcher.NewOperation(watcher, 1, struct{}{}, true)
+	assert.NoError(t, batcher.Enqueue(fourth), "not expecting an enqueue error")
+	<-fourth.Done()
+	assert.Equal(t, gobatcher.ResultSucceeded, fourth.Result().Status)
+	assert.Equal(t, 3, watcher.Calls())
+	assert.Len(t, watcher.Batches()[0], 2)
+}
+
+func TestScriptedWatcher_Otherwise(t *testing.T) {
+	watcher := batchertest.NewScriptedWatcher().
+		Otherwise(batchertest.Fail(nil))
+	op := gobatcher.NewOperation(watcher, 1, struct{}{}, false)
+	watcher.ProcessBatch([]gobatcher.Operation{op})
+	assert.Equal(t, batchertest.ScriptedFailureError, op.Result().Err)
+}
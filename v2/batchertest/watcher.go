@@ -0,0 +1,190 @@
+package batchertest
+
+import (
+	"sync"
+	"time"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+)
+
+// CapturingWatcher is a Watcher that records every batch it is asked to process (and then calls the function provided, if any) so you
+// can assert on how Operations were batched.
+type CapturingWatcher struct {
+	watcher gobatcher.Watcher
+	process func(batch []gobatcher.Operation)
+
+	mutex   sync.Mutex
+	batches [][]gobatcher.Operation
+}
+
+// This method creates a new CapturingWatcher. If you provide a function, it is called with each batch after it is recorded (for
+// instance, to fail some Operations with SetResult()); otherwise, every Operation succeeds.
+func NewCapturingWatcher(process func(batch []gobatcher.Operation)) *CapturingWatcher {
+	w := &CapturingWatcher{
+		process: process,
+	}
+	w.watcher = gobatcher.NewWatcher(w.capture)
+	return w
+}
+
+// This returns every batch the watcher has been asked to process, in the order they started. Batches are processed concurrently, so
+// that order may differ from the order the Operations were enqueued.
+func (w *CapturingWatcher) Batches() [][]gobatcher.Operation {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	batches := make([][]gobatcher.Operation, len(w.batches))
+	copy(batches, w.batches)
+	return batches
+}
+
+// This returns the number of Operations in each batch, in the same order as Batches().
+func (w *CapturingWatcher) BatchSizes() []int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	sizes := make([]int, 0, len(w.batches))
+	for _, batch := range w.batches {
+		sizes = append(sizes, len(batch))
+	}
+	return sizes
+}
+
+// This returns the payload of every Operation the watcher has been asked to process, in the same order as Batches().
+func (w *CapturingWatcher) Payloads() []interface{} {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	var payloads []interface{}
+	for _, batch := range w.batches {
+		for _, op := range batch {
+			payloads = append(payloads, op.Payload())
+		}
+	}
+	return payloads
+}
+
+// This forgets every batch that was recorded.
+func (w *CapturingWatcher) Reset() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.batches = nil
+}
+
+func (w *CapturingWatcher) capture(batch []gobatcher.Operation) {
+	w.mutex.Lock()
+	w.batches = append(w.batches, append([]gobatcher.Operation(nil), batch...))
+	w.mutex.Unlock()
+	if w.process != nil {
+		w.process(batch)
+	}
+}
+
+func (w *CapturingWatcher) WithMaxAttempts(val uint32) gobatcher.Watcher {
+	w.watcher.WithMaxAttempts(val)
+	return w
+}
+
+func (w *CapturingWatcher) WithMaxBatchSize(val uint32) gobatcher.Watcher {
+	w.watcher.WithMaxBatchSize(val)
+	return w
+}
+
+func (w *CapturingWatcher) WithMaxBatchBytes(val uint32) gobatcher.Watcher {
+	w.watcher.WithMaxBatchBytes(val)
+	return w
+}
+
+func (w *CapturingWatcher) WithMinBatchSize(val uint32) gobatcher.Watcher {
+	w.watcher.WithMinBatchSize(val)
+	return w
+}
+
+func (w *CapturingWatcher) WithMaxLinger(val time.Duration) gobatcher.Watcher {
+	w.watcher.WithMaxLinger(val)
+	return w
+}
+
+func (w *CapturingWatcher) WithMaxOperationTime(val time.Duration) gobatcher.Watcher {
+	w.watcher.WithMaxOperationTime(val)
+	return w
+}
+
+func (w *CapturingWatcher) WithCooldown(val time.Duration) gobatcher.Watcher {
+	w.watcher.WithCooldown(val)
+	return w
+}
+
+func (w *CapturingWatcher) WithGroupByKey() gobatcher.Watcher {
+	w.watcher.WithGroupByKey()
+	return w
+}
+
+func (w *CapturingWatcher) WithWeight(val uint32) gobatcher.Watcher {
+	w.watcher.WithWeight(val)
+	return w
+}
+
+func (w *CapturingWatcher) WithLabel(val string) gobatcher.Watcher {
+	w.watcher.WithLabel(val)
+	return w
+}
+
+func (w *CapturingWatcher) WithSplitter(fn func(ops []gobatcher.Operation) [][]gobatcher.Operation) gobatcher.Watcher {
+	w.watcher.WithSplitter(fn)
+	return w
+}
+
+func (w *CapturingWatcher) WithRateLimiter(rl gobatcher.RateLimiter) gobatcher.Watcher {
+	w.watcher.WithRateLimiter(rl)
+	return w
+}
+
+func (w *CapturingWatcher) MaxAttempts() uint32 {
+	return w.watcher.MaxAttempts()
+}
+
+func (w *CapturingWatcher) MaxBatchSize() uint32 {
+	return w.watcher.MaxBatchSize()
+}
+
+func (w *CapturingWatcher) MaxBatchBytes() uint32 {
+	return w.watcher.MaxBatchBytes()
+}
+
+func (w *CapturingWatcher) MinBatchSize() uint32 {
+	return w.watcher.MinBatchSize()
+}
+
+func (w *CapturingWatcher) MaxLinger() time.Duration {
+	return w.watcher.MaxLinger()
+}
+
+func (w *CapturingWatcher) MaxOperationTime() time.Duration {
+	return w.watcher.MaxOperationTime()
+}
+
+func (w *CapturingWatcher) Cooldown() time.Duration {
+	return w.watcher.Cooldown()
+}
+
+func (w *CapturingWatcher) GroupByKey() bool {
+	return w.watcher.GroupByKey()
+}
+
+func (w *CapturingWatcher) Weight() uint32 {
+	return w.watcher.Weight()
+}
+
+func (w *CapturingWatcher) Label() string {
+	return w.watcher.Label()
+}
+
+func (w *CapturingWatcher) Splitter() func(ops []gobatcher.Operation) [][]gobatcher.Operation {
+	return w.watcher.Splitter()
+}
+
+func (w *CapturingWatcher) RateLimiter() gobatcher.RateLimiter {
+	return w.watcher.RateLimiter()
+}
+
+func (w *CapturingWatcher) ProcessBatch(batch []gobatcher.Operation) {
+	w.watcher.ProcessBatch(batch)
+}
@@ -0,0 +1,22 @@
+package batcher
+
+// BufferImplementation determines how the buffer admits Operations from the goroutines that enqueue them (see
+// Batcher.WithBufferImplementation()).
+type BufferImplementation int
+
+const (
+	// Every Operation is added to the buffer while holding its lock. This is the default.
+	LockedBuffer BufferImplementation = iota
+	// Operations are added to one of several shards (each with its own lock) and moved into the buffer at the start of each flush, so
+	// many goroutines can enqueue at the same time without contending for the lock of the buffer.
+	ShardedBuffer
+)
+
+func (i BufferImplementation) String() string {
+	switch i {
+	case ShardedBuffer:
+		return "sharded"
+	default:
+		return "locked"
+	}
+}
@@ -0,0 +1,191 @@
+package batcher
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PayloadCodec converts the payloads of Operations to and from bytes so that they can be written to the overflow file (see
+// Batcher.WithOverflowFile()) or a BufferStore (see Batcher.WithBufferStore()). Unmarshal must return a payload that the Watcher can use
+// in place of the one provided to Marshal.
+type PayloadCodec interface {
+	Marshal(payload interface{}) ([]byte, error)
+	Unmarshal(data []byte) (interface{}, error)
+}
+
+// StoredOperation is what a BufferStore keeps for each Operation. The Watcher is identified by its label and the payload is encoded by
+// the PayloadCodec. The metadata, callbacks, and span context of an Operation are not stored.
+type StoredOperation struct {
+	ID         string            `json:"id"`
+	Watcher    string            `json:"watcher"`
+	Cost       uint32            `json:"cost,omitempty"`
+	Costs      map[string]uint32 `json:"costs,omitempty"`
+	Batchable  bool              `json:"batchable,omitempty"`
+	Payload    []byte            `json:"payload,omitempty"`
+	ExternalID string            `json:"externalId,omitempty"`
+	Key        string            `json:"key,omitempty"`
+	DedupKey   string            `json:"dedupKey,omitempty"`
+	Priority   int               `json:"priority,omitempty"`
+	Size       uint32            `json:"size,omitempty"`
+	Deadline   time.Time         `json:"deadline,omitempty"`
+	Expiry     time.Time         `json:"expiry,omitempty"`
+	Attempt    uint32            `json:"attempt,omitempty"`
+	EnqueuedAt time.Time         `json:"enqueuedAt,omitempty"`
+}
+
+// BufferStore persists the Operations that are enqueued so that they survive a restart (see Batcher.WithBufferStore()). Save is called
+// when an Operation is enqueued, Delete when the Operation has a final Result, and Load when the Batcher is started. Load must return the
+// Operations that have not been deleted in the order they were saved. The methods may be called concurrently.
+type BufferStore interface {
+	Save(op StoredOperation) error
+	Delete(id string) error
+	Load() ([]StoredOperation, error)
+}
+
+// A bufferStore saves each Operation that is enqueued to a BufferStore and deletes it once it has a final Result.
+type bufferStore struct {
+	store    BufferStore
+	codec    PayloadCodec
+	watchers map[string]Watcher
+	eventer  Eventer
+
+	mutex sync.Mutex
+	ids   map[Operation]string // the ID of each Operation that has been saved
+}
+
+func newBufferStore(store BufferStore, codec PayloadCodec, watchers []Watcher, eventer Eventer) *bufferStore {
+	s := &bufferStore{
+		store:    store,
+		codec:    codec,
+		watchers: make(map[string]Watcher, len(watchers)),
+		eventer:  eventer,
+		ids:      make(map[Operation]string),
+	}
+	for _, watcher := range watchers {
+		s.watchers[watcher.Label()] = watcher
+	}
+	return s
+}
+
+// This saves the Operation unless it has already been saved (for instance, when a Watcher enqueues it again to retry it). The payload is
+// produced if it was deferred.
+func (s *bufferStore) save(op Operation) error {
+	s.mutex.Lock()
+	_, saved := s.ids[op]
+	s.mutex.Unlock()
+	if saved {
+		return nil
+	}
+	label := op.Watcher().Label()
+	if label == "" {
+		return UnlabeledWatcherError
+	}
+	payload, err := s.codec.Marshal(op.Payload())
+	if err != nil {
+		return err
+	}
+	id := uuid.NewString()
+	record := StoredOperation{
+		ID:         id,
+		Watcher:    label,
+		Cost:       op.Cost(),
+		Batchable:  op.IsBatchable(),
+		Payload:    payload,
+		ExternalID: op.ExternalID(),
+		Key:        op.Key(),
+		DedupKey:   op.DedupKey(),
+		Priority:   op.Priority(),
+		Size:       op.Size(),
+		Deadline:   op.Deadline(),
+		Expiry:     op.Expiry(),
+		Attempt:    op.Attempt(),
+		EnqueuedAt: op.EnqueuedAt(),
+	}
+	if concrete, ok := op.(*operation); ok && len(concrete.costs) > 0 {
+		record.Costs = concrete.costs
+	}
+	if err := s.store.Save(record); err != nil {
+		return err
+	}
+	s.track(op, id)
+	return nil
+}
+
+// This records the ID of the Operation and deletes it from the store once the Operation has a final Result.
+func (s *bufferStore) track(op Operation, id string) {
+	s.mutex.Lock()
+	s.ids[op] = id
+	s.mutex.Unlock()
+	chain(op, func(Operation, Result) {
+		s.forget(op)
+	})
+}
+
+// This deletes the Operation from the store. An error is raised as an error event since there is nobody to return it to.
+func (s *bufferStore) forget(op Operation) {
+	s.mutex.Lock()
+	id, saved := s.ids[op]
+	delete(s.ids, op)
+	s.mutex.Unlock()
+	if !saved {
+		return
+	}
+	if err := s.store.Delete(id); err != nil {
+		s.eventer.Emit(ErrorEvent, 0, "deleting an operation from the buffer store raised an error", err)
+	}
+}
+
+// This loads the Operations that were saved by a previous process (in the order they were saved). Operations whose Watcher was not
+// provided or whose payload cannot be decoded are left in the store and an error event is raised for each.
+func (s *bufferStore) load() ([]Operation, error) {
+	records, err := s.store.Load()
+	if err != nil {
+		return nil, err
+	}
+	s.mutex.Lock()
+	known := make(map[string]bool, len(s.ids))
+	for _, id := range s.ids {
+		known[id] = true
+	}
+	s.mutex.Unlock()
+
+	ops := make([]Operation, 0, len(records))
+	for _, record := range records {
+		if known[record.ID] {
+			continue
+		}
+		watcher, ok := s.watchers[record.Watcher]
+		if !ok {
+			s.eventer.Emit(ErrorEvent, 0, fmt.Sprintf("the stored operation %s is for an unknown watcher", record.ID), UnknownWatcherError)
+			continue
+		}
+		payload, err := s.codec.Unmarshal(record.Payload)
+		if err != nil {
+			s.eventer.Emit(ErrorEvent, 0, fmt.Sprintf("the payload of the stored operation %s could not be decoded", record.ID), err)
+			continue
+		}
+		var op Operation
+		if len(record.Costs) > 0 {
+			op = NewOperationWithCosts(watcher, record.Costs, payload, record.Batchable)
+		} else {
+			op = NewOperation(watcher, record.Cost, payload, record.Batchable)
+		}
+		op.WithID(record.ExternalID).
+			WithKey(record.Key).
+			WithDedupKey(record.DedupKey).
+			WithPriority(record.Priority).
+			WithSize(record.Size).
+			WithDeadline(record.Deadline).
+			WithExpiry(record.Expiry)
+		op.(*operation).attempt = record.Attempt
+		if !record.EnqueuedAt.IsZero() {
+			markEnqueued(op, record.EnqueuedAt)
+		}
+		s.track(op, record.ID)
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
@@ -1,30 +1,114 @@
 package batcher
 
 import (
+	"context"
 	"errors"
 	"sync"
+	"sync/atomic"
+	"unsafe"
 )
 
 type ibuffer interface {
 	size() uint32
 	max() uint32
+	estimatedMemory() uint64
+	overflowed() uint32
 	top() Operation
 	skip() Operation
 	remove() Operation
+	release()
+	cancel(Operation) bool
 	enqueue(Operation, bool) error
-	shutdown()
+	enqueueWithContext(context.Context, Operation, bool) error
+	enqueueMany([]Operation, bool) []error
+	requeue([]Operation)
+	orderByDeadline()
+	deduplicate(func(buffered, incoming Operation) Operation)
+	dropWhenFull(FullBufferPolicy)
+	overflowTo(path string, codec PayloadCodec)
+	replay() map[Operation]error
+	flushOnCost(uint32, func())
+	flushOnSize(func(Watcher))
+	each(func(Operation))
+	snapshot() []Operation
+	purge(ops map[Operation]bool) []Operation
+	shutdown() []Operation
 }
 
 type buffer struct {
 	// WARNING: internal properties; only use the methods
 	lock       *sync.Mutex
 	notFull    *sync.Cond
-	len        uint32
+	len        uint32 // written with atomics (while holding the lock) so that size() does not need the lock
 	cap        uint32
+	unbounded  bool   // set if the Buffer grows instead of being limited to cap
+	memory     uint64 // the estimated bytes held by the Operations; written with atomics (while holding the lock) like len
+	spilled    uint32 // the Operations in the overflow; written by the overflow with atomics (while holding the lock) like len
 	head       *links
 	tail       *links
 	cursor     *links
 	isShutdown bool
+	byDeadline bool
+	cost       uint64 // the total cost of the Operations in the buffer
+	threshold  uint64 // the cost at which onCrossed is called
+	onCrossed  func()
+	fills      map[batchKey]*batchFill // the batchable Operations for each batch; only set by flushOnSize()
+	onFilled   func(Watcher)
+	dedup      bool // set if Operations with the same dedup key are not buffered twice
+	merge      func(buffered, incoming Operation) Operation
+	keys       map[dedupKey]*links // the link of the Operation with each dedup key
+	policy     FullBufferPolicy    // only set if it drops Operations when the buffer is full
+	overflow   *overflow           // set if Operations that do not fit are written to a file
+}
+
+// Operations are only duplicates when they have the same dedup key for the same Watcher.
+type dedupKey struct {
+	watcher Watcher
+	key     string
+}
+
+// This is returned by the Buffer instead of adding an Operation that was coalesced with an Operation that was already in the Buffer.
+// The merged Operation has replaced the buffered Operation.
+type coalescedError struct {
+	buffered Operation
+	merged   Operation
+}
+
+func (e *coalescedError) Error() string {
+	return "the operation was coalesced with an operation in the buffer."
+}
+
+// Each Operation in the Buffer is estimated to hold its size hint plus the memory of the Operation itself and its link.
+var operationOverhead = uint64(unsafe.Sizeof(operation{}) + unsafe.Sizeof(links{}))
+
+func estimatedMemoryOf(op Operation) uint64 {
+	return operationOverhead + uint64(op.Size())
+}
+
+// This is returned by the Buffer when it was full and an Operation was dropped to make room (or instead of adding the Operation).
+type droppedError struct {
+	dropped Operation
+}
+
+func (e *droppedError) Error() string {
+	return "the operation was dropped because the buffer was full."
+}
+
+// A batchFill counts the batchable Operations in the Buffer that would be packed into the same batch.
+type batchFill struct {
+	count uint32
+	bytes uint32
+}
+
+// This is TRUE if there are enough Operations for a batch of MaxBatchSize or MaxBatchBytes.
+func (f *batchFill) full(watcher Watcher) bool {
+	if max := watcher.MaxBatchSize(); max > 0 && f.count >= max {
+		return true
+	}
+	if max := watcher.MaxBatchBytes(); max > 0 && f.bytes >= max {
+		return true
+	}
+	return false
 }
 
 type links struct {
@@ -45,20 +129,44 @@ func newBuffer(max uint32) ibuffer {
 	}
 }
 
+// This method creates a new buffer like newBuffer() except that it is never full; it grows to hold every Operation that is enqueued.
+func newUnboundedBuffer() ibuffer {
+	lock := &sync.Mutex{}
+	return &buffer{
+		lock:      lock,
+		notFull:   sync.NewCond(lock),
+		unbounded: true,
+	}
+}
+
 // This returns the number of Operations in the buffer.
 func (b *buffer) size() uint32 {
-	b.lock.Lock()
-	defer b.lock.Unlock()
-	return b.len
+	return atomic.LoadUint32(&b.len)
 }
 
-// This returns the maximum number of Operations that can be held in the buffer.
+// This returns the maximum number of Operations that can be held in the buffer (0 if it is unbounded).
 func (b *buffer) max() uint32 {
 	b.lock.Lock()
 	defer b.lock.Unlock()
 	return b.cap
 }
 
+// This returns the estimated bytes held by the Operations in the Buffer, which is their size hints (see Operation.WithSize()) plus the
+// memory of each Operation.
+func (b *buffer) estimatedMemory() uint64 {
+	return atomic.LoadUint64(&b.memory)
+}
+
+// This returns the number of Operations waiting in the overflow file to be replayed into the Buffer.
+func (b *buffer) overflowed() uint32 {
+	return atomic.LoadUint32(&b.spilled)
+}
+
+// This is TRUE if there is no room for another Operation. The lock must be held.
+func (b *buffer) full() bool {
+	return !b.unbounded && b.len >= b.cap
+}
+
 // This sets the cursor position to the top of the Buffer and returns the head Operation. This method will return nil if there
 // is no head Operation. Batcher's main processing loop runs on a single thread so having a single cursor is appropriate.
 func (b *buffer) top() Operation {
@@ -91,6 +199,10 @@ func (b *buffer) skip() Operation {
 func (b *buffer) remove() Operation {
 	b.lock.Lock()
 	defer b.lock.Unlock()
+	var removed Operation
+	if b.cursor != nil {
+		removed = b.cursor.op
+	}
 
 	switch {
 	case b.cursor == nil:
@@ -121,8 +233,12 @@ func (b *buffer) remove() Operation {
 		// NOTE: There should be no way to reach this panic unless there was a coding error
 		panic(errors.New("removing from empty buffer is not allowed"))
 	}
+	b.unindex(removed)
+	b.unfill(removed)
 	b.notFull.Signal()
-	b.len--
+	atomic.AddUint32(&b.len, ^uint32(0))
+	b.cost -= uint64(removed.Cost())
+	atomic.AddUint64(&b.memory, ^(estimatedMemoryOf(removed) - 1))
 
 	if b.cursor == nil {
 		return nil
@@ -130,49 +246,577 @@ func (b *buffer) remove() Operation {
 	return b.cursor.op
 }
 
+// This clears the cursor position once the processing loop is done moving through the Buffer so that cancel() may remove any Operation.
+func (b *buffer) release() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.cursor = nil
+}
+
+// This removes the Operation from the Buffer without moving the cursor and returns true if it was found. An Operation at the cursor
+// position is being considered by the processing loop, so it is not removed and false is returned. This checks each Operation in the
+// Buffer (and the overflow), so it is much slower than remove().
+func (b *buffer) cancel(op Operation) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for link := b.head; link != nil; link = link.nxt {
+		if link.op != op {
+			continue
+		}
+		if link == b.cursor {
+			return false
+		}
+		b.unlink(link)
+		b.notFull.Signal()
+		return true
+	}
+	return b.overflow != nil && b.overflow.remove(op)
+}
+
+// This removes the provided Operations from the Buffer and the overflow and returns the ones that were removed (in the order they were
+// found). Like cancel(), it does not remove the Operation at the cursor position.
+func (b *buffer) purge(ops map[Operation]bool) []Operation {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	var removed []Operation
+	for link := b.head; link != nil; {
+		next := link.nxt
+		if ops[link.op] && link != b.cursor {
+			b.unlink(link)
+			removed = append(removed, link.op)
+		}
+		link = next
+	}
+	if len(removed) > 0 {
+		b.notFull.Broadcast()
+	}
+	if b.overflow != nil {
+		removed = append(removed, b.overflow.purge(ops)...)
+	}
+	return removed
+}
+
+// This removes the link from the Buffer. The lock must be held and the link must not be at the cursor position.
+func (b *buffer) unlink(link *links) {
+	if link.prv != nil {
+		link.prv.nxt = link.nxt
+	} else {
+		b.head = link.nxt
+	}
+	if link.nxt != nil {
+		link.nxt.prv = link.prv
+	} else {
+		b.tail = link.prv
+	}
+	b.unindex(link.op)
+	b.unfill(link.op)
+	atomic.AddUint32(&b.len, ^uint32(0))
+	b.cost -= uint64(link.op.Cost())
+	atomic.AddUint64(&b.memory, ^(estimatedMemoryOf(link.op) - 1))
+}
+
 // This allows you to add an Operation to the tail of the Buffer. If the Buffer is full and errorOnFull is false, this method
 // is blocking until the Operation can be added. If the Buffer is full and errorOnFull is true, this method returns BufferFullError.
+// If the Buffer drops Operations when it is full (see dropWhenFull()), neither happens; instead, a *droppedError is returned with the
+// Operation that was dropped, which may be the Operation provided (in which case it was not added). If the Buffer has an overflow file
+// (see overflowTo()), the Operation is added to the overflow instead, which takes precedence over dropping.
 func (b *buffer) enqueue(op Operation, errorOnFull bool) error {
+	return b.enqueueWithContext(context.Background(), op, errorOnFull)
+}
+
+// This is the same as enqueue() except that if the Buffer is full and errorOnFull is false, it stops blocking and returns the
+// context's error when the context is done.
+func (b *buffer) enqueueWithContext(ctx context.Context, op Operation, errorOnFull bool) error {
 	b.lock.Lock()
 	defer b.lock.Unlock()
 
 	if b.isShutdown {
 		return BufferIsShutdown
 	}
+	if coalesced, err := b.coalesce(op); coalesced {
+		return err
+	}
+	if b.overflows() {
+		return b.overflow.push(op)
+	}
+	if b.full() && b.policy.drops() {
+		return b.drop(op)
+	}
+
+	// wake the waiters if the context is done while waiting
+	if b.full() && !errorOnFull && ctx.Done() != nil {
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-ctx.Done():
+				b.lock.Lock()
+				b.notFull.Broadcast()
+				b.lock.Unlock()
+			case <-stop:
+			}
+		}()
+	}
 
-	for b.len >= b.cap {
+	for b.full() {
 		if errorOnFull {
 			return BufferFullError
 		}
+		if err := ctx.Err(); err != nil {
+			// pass on a signal that might have been meant for another waiter
+			b.notFull.Signal()
+			return err
+		}
 		b.notFull.Wait()
 	}
 
+	b.link(op)
+
+	return nil
+}
+
+// This allows you to add several Operations to the tail of the Buffer (in the order provided) while acquiring the lock once. If the
+// Buffer fills up and errorOnFull is true, BufferFullError is returned for each of the remaining Operations; otherwise, this method
+// blocks until there is room for each (other Operations may be enqueued while it waits). The returned errors are in the same order as
+// the Operations and are nil if every Operation was added.
+func (b *buffer) enqueueMany(ops []Operation, errorOnFull bool) []error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	var errs []error
+	fail := func(i int, err error) {
+		if errs == nil {
+			errs = make([]error, len(ops))
+		}
+		for ; i < len(ops); i++ {
+			errs[i] = err
+		}
+	}
+
+	for i, op := range ops {
+		if !b.isShutdown {
+			if coalesced, err := b.coalesce(op); coalesced {
+				if errs == nil {
+					errs = make([]error, len(ops))
+				}
+				errs[i] = err
+				continue
+			}
+		}
+		if !b.isShutdown && b.overflows() {
+			if err := b.overflow.push(op); err != nil {
+				if errs == nil {
+					errs = make([]error, len(ops))
+				}
+				errs[i] = err
+			}
+			continue
+		}
+		if !b.isShutdown && b.full() && b.policy.drops() {
+			if errs == nil {
+				errs = make([]error, len(ops))
+			}
+			errs[i] = b.drop(op)
+			continue
+		}
+		for !b.isShutdown && b.full() && !errorOnFull {
+			b.notFull.Wait()
+		}
+		switch {
+		case b.isShutdown:
+			fail(i, BufferIsShutdown)
+			return errs
+		case b.full():
+			fail(i, BufferFullError)
+			return errs
+		}
+		b.link(op)
+	}
+
+	return errs
+}
+
+// This links the Operation into the Buffer. The lock must be held and there must be room.
+func (b *buffer) link(op Operation) {
+	var link *links
 	switch {
 	case b.head == nil:
-		link := &links{op: op}
+		link = &links{op: op}
 		b.head = link
 		b.tail = link
 	case b.tail == nil:
 		// NOTE: There should be no way to reach this panic unless there was a coding error
 		panic(errors.New("a buffer tail was not found"))
+	case b.byDeadline && !op.Deadline().IsZero():
+		link = b.insertByDeadline(op)
 	default:
-		link := &links{prv: b.tail, op: op}
+		link = &links{prv: b.tail, op: op}
 		b.tail.nxt = link
 		b.tail = link
 	}
+	b.index(link)
+	atomic.AddUint32(&b.len, 1)
 
-	b.len++
+	atomic.AddUint64(&b.memory, estimatedMemoryOf(op))
 
-	return nil
+	// raise when the cost crosses the threshold
+	before := b.cost
+	b.cost += uint64(op.Cost())
+	if b.threshold > 0 && before < b.threshold && b.cost >= b.threshold {
+		b.onCrossed()
+	}
+
+	// raise when there are enough Operations for a full batch
+	if watcher, filled := b.fill(op); filled {
+		b.onFilled(watcher)
+	}
+}
+
+// This inserts the Operation after the last Operation with the same or an earlier deadline. Operations without a deadline are always
+// after those with one, so the Buffer stays ordered by deadline (and by enqueue time for the same deadline). It returns the new link.
+// The lock must be held.
+func (b *buffer) insertByDeadline(op Operation) *links {
+	deadline := op.Deadline()
+	prv := b.tail
+	for prv != nil {
+		if d := prv.op.Deadline(); !d.IsZero() && !d.After(deadline) {
+			break
+		}
+		prv = prv.prv
+	}
+	link := &links{prv: prv, op: op}
+	if prv == nil {
+		link.nxt = b.head
+		b.head.prv = link
+		b.head = link
+		return link
+	}
+	link.nxt = prv.nxt
+	if prv.nxt != nil {
+		prv.nxt.prv = link
+	} else {
+		b.tail = link
+	}
+	prv.nxt = link
+	return link
+}
+
+// This puts Operations that were removed back at the head of the Buffer (in the order provided) so they are the first considered
+// by the next flush. This never blocks; the Buffer may briefly hold more than its max since these Operations were just in it.
+func (b *buffer) requeue(ops []Operation) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.isShutdown {
+		return
+	}
+
+	for i := len(ops) - 1; i >= 0; i-- {
+		link := &links{op: ops[i], nxt: b.head}
+		if b.head != nil {
+			b.head.prv = link
+		} else {
+			b.tail = link
+		}
+		b.head = link
+		if key, ok := b.keyOf(ops[i]); ok && b.keys[key] == nil {
+			b.keys[key] = link
+		}
+		atomic.AddUint32(&b.len, 1)
+		b.cost += uint64(ops[i].Cost())
+		atomic.AddUint64(&b.memory, estimatedMemoryOf(ops[i]))
+
+		// these were just in the Buffer (and may have been left behind by a flush), so they do not trigger another flush
+		b.fill(ops[i])
+	}
+}
+
+// This causes Operations with a deadline to be enqueued ahead of Operations with a later deadline (or no deadline) instead of at the
+// tail. Operations already in the Buffer are not reordered.
+func (b *buffer) orderByDeadline() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.byDeadline = true
+}
+
+// This causes an Operation with the same dedup key (see Operation.WithDedupKey()) for the same Watcher as an Operation already in the
+// Buffer to not be added. If merge is nil, DuplicateOperationError is returned. Otherwise, the Operation returned by merge replaces
+// the buffered Operation (in the same position) and a *coalescedError is returned. If merge returns nil, DuplicateOperationError is
+// returned. merge is called while the lock is held, so it must not block or call back into the Buffer.
+func (b *buffer) deduplicate(merge func(buffered, incoming Operation) Operation) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.dedup = true
+	b.merge = merge
+	b.keys = make(map[dedupKey]*links)
+}
+
+// This returns the dedup key of the Operation and whether it can be deduplicated.
+func (b *buffer) keyOf(op Operation) (dedupKey, bool) {
+	if !b.dedup || op == nil || op.DedupKey() == "" {
+		return dedupKey{}, false
+	}
+	return dedupKey{watcher: op.Watcher(), key: op.DedupKey()}, true
+}
+
+// This records the link as the one holding its dedup key. The lock must be held.
+func (b *buffer) index(link *links) {
+	if key, ok := b.keyOf(link.op); ok {
+		b.keys[key] = link
+	}
+}
+
+// This forgets the dedup key of an Operation that is no longer in the Buffer. The lock must be held.
+func (b *buffer) unindex(op Operation) {
+	if key, ok := b.keyOf(op); ok && b.keys[key] != nil && b.keys[key].op == op {
+		delete(b.keys, key)
+	}
 }
 
-// This clears the Buffer allowing all Operations to be garbage collected. Once shutdown, it cannot be used any longer
-func (b *buffer) shutdown() {
+// This causes the Buffer to drop an Operation according to the policy rather than blocking or returning BufferFullError when it is
+// full. Policies that do not drop Operations are ignored.
+func (b *buffer) dropWhenFull(policy FullBufferPolicy) {
 	b.lock.Lock()
 	defer b.lock.Unlock()
+	if policy.drops() {
+		b.policy = policy
+	} else {
+		b.policy = BlockWhenFull
+	}
+}
+
+// This causes Operations that are enqueued while the Buffer is full to be added to an overflow whose payloads are written to the file
+// at path (see overflow) rather than blocking, returning BufferFullError, or being dropped. Once there are Operations in the overflow,
+// Operations are added to it until it has been replayed so that they stay in the order they were enqueued.
+func (b *buffer) overflowTo(path string, codec PayloadCodec) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.overflow = newOverflow(path, codec, &b.spilled)
+}
+
+// This is TRUE if an Operation being enqueued should be added to the overflow. The lock must be held.
+func (b *buffer) overflows() bool {
+	return b.overflow != nil && (b.full() || b.overflow.size() > 0)
+}
+
+// This moves Operations from the overflow into the Buffer (in the order they were enqueued) while there is room. It returns the
+// Operations whose payloads could not be read back, which are not added, with the error for each.
+func (b *buffer) replay() map[Operation]error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.overflow == nil || b.isShutdown {
+		return nil
+	}
+	var failed map[Operation]error
+	for b.overflow.size() > 0 && !b.full() {
+		op, err := b.overflow.pop()
+		if err != nil {
+			if failed == nil {
+				failed = make(map[Operation]error)
+			}
+			failed[op] = err
+			continue
+		}
+		b.link(op)
+	}
+	return failed
+}
+
+// This makes room for the Operation in the full Buffer by dropping an Operation according to the policy and returns a *droppedError
+// with the Operation that was dropped. If that is the Operation provided, it is not added. An Operation at the cursor position is being
+// considered by the processing loop, so it is never dropped. The lock must be held.
+func (b *buffer) drop(op Operation) error {
+	var victim *links
+	switch b.policy {
+	case DropOldest:
+		for link := b.head; link != nil; link = link.nxt {
+			if link != b.cursor {
+				victim = link
+				break
+			}
+		}
+	case DropLowestPriority:
+		// ties go to the Operation closest to the head; the Operation provided is only dropped if it is lower than all of them
+		for link := b.head; link != nil; link = link.nxt {
+			if link != b.cursor && (victim == nil || link.op.Priority() < victim.op.Priority()) {
+				victim = link
+			}
+		}
+		if victim != nil && op.Priority() < victim.op.Priority() {
+			victim = nil
+		}
+	}
+	if victim == nil {
+		return &droppedError{dropped: op}
+	}
+	b.unlink(victim)
+	b.link(op)
+	return &droppedError{dropped: victim.op}
+}
+
+// This returns true if the Operation is a duplicate of an Operation in the Buffer, along with the error to return for it. An Operation
+// at the cursor position is being considered by the processing loop, so it is not a duplicate of anything. The lock must be held.
+func (b *buffer) coalesce(op Operation) (bool, error) {
+	key, ok := b.keyOf(op)
+	if !ok {
+		return false, nil
+	}
+	link := b.keys[key]
+	if link == nil || link == b.cursor {
+		return false, nil
+	}
+	if b.merge == nil {
+		return true, DuplicateOperationError
+	}
+	buffered := link.op
+	merged := b.merge(buffered, op)
+	if merged == nil {
+		return true, DuplicateOperationError
+	}
+	link.op = merged
+	delete(b.keys, key)
+	b.index(link)
+	b.cost = b.cost - uint64(buffered.Cost()) + uint64(merged.Cost())
+	b.unfill(buffered)
+	b.fill(merged)
+	atomic.AddUint64(&b.memory, estimatedMemoryOf(merged)-estimatedMemoryOf(buffered))
+
+	// the Operations that were replaced are completed with the Result of the merged Operation
+	var followers []Operation
+	for _, replaced := range []Operation{buffered, op} {
+		if replaced != merged {
+			followers = append(followers, replaced)
+		}
+	}
+	follow(merged, followers)
+
+	return true, &coalescedError{buffered: buffered, merged: merged}
+}
+
+// This calls fn for each Operation in the Buffer (from head to tail) without moving the cursor. fn is called while the lock is held, so
+// it must not block or call back into the Buffer.
+func (b *buffer) each(fn func(Operation)) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for link := b.head; link != nil; link = link.nxt {
+		fn(link.op)
+	}
+}
+
+// This returns the Operations in the Buffer (from head to tail) followed by those in the overflow (in the order they were enqueued).
+func (b *buffer) snapshot() []Operation {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	ops := make([]Operation, 0, b.len)
+	for link := b.head; link != nil; link = link.nxt {
+		ops = append(ops, link.op)
+	}
+	if b.overflow != nil {
+		for _, entry := range b.overflow.entries {
+			ops = append(ops, entry.op)
+		}
+	}
+	return ops
+}
+
+// This causes onCrossed to be called whenever an Operation is enqueued that brings the total cost of the Operations in the Buffer from
+// below the threshold to at or above it. onCrossed is called while the lock is held, so it must not block or call back into the Buffer.
+func (b *buffer) flushOnCost(threshold uint32, onCrossed func()) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.threshold = uint64(threshold)
+	b.onCrossed = onCrossed
+}
+
+// This causes onFilled to be called whenever an Operation is enqueued that brings the batchable Operations for a Watcher (for a key, if
+// the Watcher groups by key) in the Buffer to MaxBatchSize or MaxBatchBytes. onFilled is called while the lock is held, so it must not
+// block or call back into the Buffer.
+func (b *buffer) flushOnSize(onFilled func(Watcher)) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.fills = make(map[batchKey]*batchFill)
+	b.onFilled = onFilled
+}
+
+// This returns the batch that the Operation would be packed into and whether it is counted by flushOnSize().
+func (b *buffer) fillKeyOf(op Operation) (batchKey, bool) {
+	if b.fills == nil || !op.IsBatchable() {
+		return batchKey{}, false
+	}
+	watcher := op.Watcher()
+	if watcher.MaxBatchSize() == 0 && watcher.MaxBatchBytes() == 0 {
+		return batchKey{}, false
+	}
+	key := batchKey{watcher: watcher}
+	if watcher.GroupByKey() {
+		key.key = op.Key()
+	}
+	return key, true
+}
+
+// This counts the Operation toward its batch and returns its Watcher and true if that just filled the batch. The lock must be held.
+func (b *buffer) fill(op Operation) (Watcher, bool) {
+	key, ok := b.fillKeyOf(op)
+	if !ok {
+		return nil, false
+	}
+	f := b.fills[key]
+	if f == nil {
+		f = &batchFill{}
+		b.fills[key] = f
+	}
+	wasFull := f.full(key.watcher)
+	f.count++
+	f.bytes += op.Size()
+	return key.watcher, !wasFull && f.full(key.watcher)
+}
+
+// This stops counting an Operation that is no longer in the Buffer toward its batch. The lock must be held.
+func (b *buffer) unfill(op Operation) {
+	key, ok := b.fillKeyOf(op)
+	if !ok {
+		return
+	}
+	f := b.fills[key]
+	if f == nil {
+		return
+	}
+	if f.count <= 1 {
+		delete(b.fills, key)
+		return
+	}
+	f.count--
+	if f.bytes > op.Size() {
+		f.bytes -= op.Size()
+	} else {
+		f.bytes = 0
+	}
+}
+
+// This clears the Buffer allowing all Operations to be garbage collected and returns the Operations that were in it. Once shutdown, it
+// cannot be used any longer
+func (b *buffer) shutdown() []Operation {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	var dropped []Operation
+	for link := b.head; link != nil; link = link.nxt {
+		dropped = append(dropped, link.op)
+	}
 	b.head = nil
 	b.tail = nil
 	b.cursor = nil
-	b.len = 0
+	atomic.StoreUint32(&b.len, 0)
+	b.cost = 0
+	atomic.StoreUint64(&b.memory, 0)
+	if b.fills != nil {
+		b.fills = make(map[batchKey]*batchFill)
+	}
+	if b.overflow != nil {
+		dropped = append(dropped, b.overflow.close()...)
+	}
+	if b.dedup {
+		b.keys = make(map[dedupKey]*links)
+	}
 	b.isShutdown = true
+	return dropped
 }
@@ -1,6 +1,8 @@
 package batcher
 
 import (
+	"context"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
@@ -212,3 +214,228 @@ func TestBuffer_Shutdown(t *testing.T) {
 	err = buffer.enqueue(op, false)
 	assert.Equal(t, BufferIsShutdown, err, "expecting an error when enqueue after shutdown")
 }
+
+func TestBuffer_RequeuePutsOperationsAtTheHead(t *testing.T) {
+	buffer := newBuffer(10)
+	watcher := NewWatcher(func(batch []Operation) {})
+	op1 := NewOperation(watcher, 1, struct{}{}, false)
+	op2 := NewOperation(watcher, 2, struct{}{}, false)
+	op3 := NewOperation(watcher, 3, struct{}{}, false)
+	err := buffer.enqueue(op3, false)
+	assert.NoError(t, err, "expecting no error on enqueue")
+	buffer.requeue([]Operation{op1, op2})
+	assert.Equal(t, uint32(3), buffer.size())
+	assert.Equal(t, op1, buffer.top())
+	assert.Equal(t, op2, buffer.skip())
+	assert.Equal(t, op3, buffer.skip())
+	assert.Nil(t, buffer.skip())
+}
+
+func TestBuffer_OrderByDeadline(t *testing.T) {
+	buffer := newBuffer(10)
+	buffer.orderByDeadline()
+	watcher := NewWatcher(func(batch []Operation) {})
+	now := time.Now()
+	none := NewOperation(watcher, 0, struct{}{}, false)
+	later := NewOperation(watcher, 0, struct{}{}, false).WithDeadline(now.Add(2 * time.Minute))
+	sooner := NewOperation(watcher, 0, struct{}{}, false).WithDeadline(now.Add(1 * time.Minute))
+	tied := NewOperation(watcher, 0, struct{}{}, false).WithDeadline(now.Add(1 * time.Minute))
+	for _, op := range []Operation{none, later, sooner, tied} {
+		err := buffer.enqueue(op, false)
+		assert.NoError(t, err, "expecting no error on enqueue")
+	}
+	assert.Equal(t, uint32(4), buffer.size())
+	assert.Same(t, sooner, buffer.top())
+	assert.Same(t, tied, buffer.skip(), "expecting the same deadline to be in enqueue order")
+	assert.Same(t, later, buffer.skip())
+	assert.Same(t, none, buffer.skip(), "expecting operations without a deadline to be last")
+	assert.Nil(t, buffer.skip())
+}
+
+func TestBuffer_FlushOnCostIsCalledWhenTheThresholdIsCrossed(t *testing.T) {
+	buffer := newBuffer(10)
+	var crossed int
+	buffer.flushOnCost(100, func() { crossed++ })
+	watcher := NewWatcher(func(batch []Operation) {})
+	enqueue := func(cost uint32) {
+		err := buffer.enqueue(NewOperation(watcher, cost, struct{}{}, false), false)
+		assert.NoError(t, err, "expecting no error on enqueue")
+	}
+	enqueue(60)
+	assert.Equal(t, 0, crossed)
+	enqueue(40)
+	assert.Equal(t, 1, crossed, "expecting the threshold to be crossed")
+	enqueue(10)
+	assert.Equal(t, 1, crossed, "expecting no call while the cost is still above the threshold")
+	buffer.top()
+	buffer.remove()
+	buffer.remove()
+	enqueue(90)
+	assert.Equal(t, 2, crossed, "expecting a call once the cost drops below and crosses again")
+}
+
+func TestBuffer_BlockOnFullUntilContextIsDone(t *testing.T) {
+	buffer := newBuffer(1)
+	watcher := NewWatcher(func(batch []Operation) {})
+	err := buffer.enqueue(NewOperation(watcher, 0, struct{}{}, false), false)
+	assert.NoError(t, err, "expecting no error on enqueue")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	err = buffer.enqueueWithContext(ctx, NewOperation(watcher, 0, struct{}{}, false), false)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.GreaterOrEqual(t, int64(time.Since(started)), int64(10*time.Millisecond), "expecting to block until the deadline")
+	assert.Equal(t, uint32(1), buffer.size())
+}
+
+func TestBuffer_EnqueueManyErrorsWhenFull(t *testing.T) {
+	buffer := newBuffer(2)
+	watcher := NewWatcher(func(batch []Operation) {})
+	op1 := NewOperation(watcher, 0, struct{}{}, false)
+	op2 := NewOperation(watcher, 0, struct{}{}, false)
+	op3 := NewOperation(watcher, 0, struct{}{}, false)
+	errs := buffer.enqueueMany([]Operation{op1, op2, op3}, true)
+	assert.Equal(t, []error{nil, nil, BufferFullError}, errs)
+	assert.Equal(t, uint32(2), buffer.size())
+	assert.Equal(t, op1, buffer.top())
+	assert.Equal(t, op2, buffer.skip())
+	assert.Nil(t, buffer.enqueueMany([]Operation{}, true), "expecting no errors when there is nothing to enqueue")
+}
+
+func TestBuffer_DeduplicateOnlyConsidersOperationsStillInTheBuffer(t *testing.T) {
+	buffer := newBuffer(10)
+	buffer.deduplicate(nil)
+	watcher := NewWatcher(func(batch []Operation) {})
+	first := NewOperation(watcher, 0, struct{}{}, false).WithDedupKey("k")
+	err := buffer.enqueue(first, false)
+	assert.NoError(t, err, "expecting no error on enqueue")
+	err = buffer.enqueue(NewOperation(watcher, 0, struct{}{}, false).WithDedupKey("k"), false)
+	assert.ErrorIs(t, err, DuplicateOperationError, "expecting the duplicate to be rejected")
+	other := NewWatcher(func(batch []Operation) {})
+	err = buffer.enqueue(NewOperation(other, 0, struct{}{}, false).WithDedupKey("k"), false)
+	assert.NoError(t, err, "expecting the same key for another watcher to not be a duplicate")
+
+	// the operation at the cursor is being considered by the processing loop
+	assert.Equal(t, first, buffer.top())
+	second := NewOperation(watcher, 0, struct{}{}, false).WithDedupKey("k")
+	err = buffer.enqueue(second, false)
+	assert.NoError(t, err, "expecting the operation at the cursor to not be a duplicate")
+	buffer.remove()
+	buffer.release()
+	err = buffer.enqueue(NewOperation(watcher, 0, struct{}{}, false).WithDedupKey("k"), false)
+	assert.ErrorIs(t, err, DuplicateOperationError, "expecting the operation enqueued after the cursor to be the one deduplicated against")
+	assert.True(t, buffer.cancel(second))
+	err = buffer.enqueue(NewOperation(watcher, 0, struct{}{}, false).WithDedupKey("k"), false)
+	assert.NoError(t, err, "expecting the key to be forgotten once the operation is removed")
+	assert.Equal(t, uint32(2), buffer.size())
+}
+
+func TestBuffer_DropWhenFullNeverDropsTheOperationAtTheCursor(t *testing.T) {
+	buffer := newBuffer(2)
+	buffer.dropWhenFull(DropLowestPriority)
+	watcher := NewWatcher(func(batch []Operation) {})
+	low := NewOperation(watcher, 0, "low", false).WithPriority(1)
+	high := NewOperation(watcher, 0, "high", false).WithPriority(5)
+	for _, op := range []Operation{low, high} {
+		err := buffer.enqueue(op, false)
+		assert.NoError(t, err, "expecting no error on enqueue")
+	}
+
+	// the lowest priority operation is at the cursor, so the next lowest is dropped
+	assert.Equal(t, low, buffer.top())
+	urgent := NewOperation(watcher, 0, "urgent", false).WithPriority(9)
+	var dropped *droppedError
+	err := buffer.enqueue(urgent, false)
+	assert.ErrorAs(t, err, &dropped)
+	assert.Equal(t, high, dropped.dropped)
+
+	// an operation lower than everything that could be dropped is dropped itself
+	lowest := NewOperation(watcher, 0, "lowest", false).WithPriority(0)
+	err = buffer.enqueue(lowest, false)
+	assert.ErrorAs(t, err, &dropped)
+	assert.Equal(t, lowest, dropped.dropped)
+	buffer.release()
+	assert.Equal(t, uint32(2), buffer.size())
+	assert.Equal(t, low, buffer.top())
+	assert.Equal(t, urgent, buffer.skip())
+}
+
+func TestBuffer_UnboundedGrowsAndTracksEstimatedMemory(t *testing.T) {
+	buffer := newUnboundedBuffer()
+	watcher := NewWatcher(func(batch []Operation) {})
+	for i := 0; i < 100; i++ {
+		err := buffer.enqueue(NewOperation(watcher, 0, struct{}{}, false).WithSize(1000), true)
+		assert.NoError(t, err, "expecting an unbounded buffer to never be full")
+	}
+	assert.Equal(t, uint32(100), buffer.size())
+	assert.Equal(t, 100*(operationOverhead+1000), buffer.estimatedMemory())
+	buffer.top()
+	buffer.remove()
+	buffer.release()
+	assert.Equal(t, 99*(operationOverhead+1000), buffer.estimatedMemory())
+	buffer.shutdown()
+	assert.Equal(t, uint64(0), buffer.estimatedMemory())
+}
+
+func TestBuffer_FlushOnSizeTriggersOnceForEachFullBatch(t *testing.T) {
+	buffer := newBuffer(10)
+	var filled []Watcher
+	buffer.flushOnSize(func(watcher Watcher) {
+		filled = append(filled, watcher)
+	})
+	watcher := NewWatcher(func(batch []Operation) {}).WithMaxBatchSize(2)
+	enqueue := func() Operation {
+		op := NewOperation(watcher, 0, struct{}{}, true)
+		err := buffer.enqueue(op, false)
+		assert.NoError(t, err, "expecting no error on enqueue")
+		return op
+	}
+	enqueue()
+	assert.Empty(t, filled, "expecting no trigger before the batch is full")
+	enqueue()
+	enqueue()
+	assert.Equal(t, []Watcher{watcher}, filled, "expecting a single trigger once the batch is full")
+	var removed []Operation
+	for op := buffer.top(); op != nil; op = buffer.remove() {
+		removed = append(removed, op)
+	}
+	buffer.requeue(removed)
+	assert.Len(t, filled, 1, "expecting requeued operations to not trigger")
+	removed = removed[:0]
+	for op := buffer.top(); op != nil; op = buffer.remove() {
+		removed = append(removed, op)
+	}
+	assert.Len(t, removed, 3)
+	enqueue()
+	enqueue()
+	assert.Len(t, filled, 2, "expecting another trigger once the batch is full again")
+}
+
+type bytesCodec struct{}
+
+func (bytesCodec) Marshal(payload interface{}) ([]byte, error) {
+	return payload.([]byte), nil
+}
+
+func (bytesCodec) Unmarshal(data []byte) (interface{}, error) {
+	return data, nil
+}
+
+func TestBuffer_OverflowedIsCountedWithoutTheLock(t *testing.T) {
+	buffer := newBuffer(1).(*buffer)
+	buffer.overflowTo(filepath.Join(t.TempDir(), "overflow"), bytesCodec{})
+	watcher := NewWatcher(func(batch []Operation) {})
+	for i := 0; i < 3; i++ {
+		err := buffer.enqueue(NewOperation(watcher, 0, []byte{byte(i)}, false), false)
+		assert.NoError(t, err, "expecting no error on enqueue")
+	}
+	buffer.lock.Lock()
+	assert.Equal(t, uint32(2), buffer.overflowed(), "expecting the count to be readable while the lock is held")
+	buffer.lock.Unlock()
+	buffer.top()
+	buffer.remove()
+	failed := buffer.replay()
+	assert.Empty(t, failed, "expecting every payload to be read back")
+	assert.Equal(t, uint32(1), buffer.size(), "expecting the replayed operation in the buffer")
+	assert.Equal(t, uint32(1), buffer.overflowed(), "expecting the count to drop on replay")
+}
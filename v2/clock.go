@@ -0,0 +1,65 @@
+package batcher
+
+import "time"
+
+// Clock is the source of time for a Batcher or SharedResource. By default, they use the time package; provide a fake Clock (such as
+// testutil.FakeClock) with WithClock() to control time in tests rather than sleeping.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	NewTicker(d time.Duration) Ticker
+	NewTimer(d time.Duration) Timer
+	Sleep(d time.Duration)
+}
+
+// Ticker is the equivalent of *time.Ticker for a Clock.
+type Ticker interface {
+	C() <-chan time.Time
+	Reset(d time.Duration)
+	Stop()
+}
+
+// Timer is the equivalent of *time.Timer for a Clock.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// realClock is the default Clock, which uses the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ThrottledError = errors.New("the request was throttled by cosmos.")
+)
+
+// document is what is written to the container. The container must use "/pk" as its partition key path.
+type document struct {
+	ID           string    `json:"id"`
+	PartitionKey string    `json:"pk"`
+	Value        int       `json:"value"`
+	Created      time.Time `json:"created"`
+}
+
+// cosmosClient upserts documents using the Cosmos DB REST API with the account's master key, so the example has no dependencies
+// beyond the standard library.
+type cosmosClient struct {
+	endpoint  string
+	key       []byte
+	database  string
+	container string
+	http      *http.Client
+}
+
+func newCosmosClient(endpoint, key, database, container string) (*cosmosClient, error) {
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("the cosmos key must be base64: %w", err)
+	}
+	return &cosmosClient{
+		endpoint:  strings.TrimSuffix(endpoint, "/"),
+		key:       decoded,
+		database:  database,
+		container: container,
+		http:      &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// This signs the request as described in "Access control in the Azure Cosmos DB SQL API".
+func (c *cosmosClient) sign(verb, resourceType, resourceLink, date string) string {
+	payload := strings.ToLower(verb) + "\n" + strings.ToLower(resourceType) + "\n" + resourceLink + "\n" + strings.ToLower(date) + "\n\n"
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(payload))
+	sig := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return url.QueryEscape("type=master&ver=1.0&sig=" + sig)
+}
+
+// This upserts the document and returns the request charge (in RU). If Cosmos throttled the request, the error wraps ThrottledError.
+func (c *cosmosClient) upsert(ctx context.Context, doc document) (float64, error) {
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return 0, err
+	}
+	partitionKey, err := json.Marshal([]string{doc.PartitionKey})
+	if err != nil {
+		return 0, err
+	}
+	link := fmt.Sprintf("dbs/%s/colls/%s", c.database, c.container)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint+"/"+link+"/docs", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	date := time.Now().UTC().Format(http.TimeFormat)
+	req.Header.Set("Authorization", c.sign(http.MethodPost, "docs", link, date))
+	req.Header.Set("x-ms-date", date)
+	req.Header.Set("x-ms-version", "2018-12-31")
+	req.Header.Set("x-ms-documentdb-partitionkey", string(partitionKey))
+	req.Header.Set("x-ms-documentdb-is-upsert", "True")
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	charge, _ := strconv.ParseFloat(resp.Header.Get("x-ms-request-charge"), 64)
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return charge, fmt.Errorf("%w: retry after %sms", ThrottledError, resp.Header.Get("x-ms-retry-after-ms"))
+	case resp.StatusCode >= 300:
+		return charge, fmt.Errorf("cosmos returned %d when upserting %s", resp.StatusCode, doc.ID)
+	default:
+		return charge, nil
+	}
+}
@@ -0,0 +1,193 @@
+// This example writes documents to a Cosmos DB container without exceeding its provisioned throughput (RU/s). Each document is an
+// Operation whose cost is the estimated request charge; once written, the actual request charge reported by Cosmos is recorded on the
+// Result. Throttled writes pause the Batcher and are enqueued again. If a storage account is provided, the RU/s are shared by every
+// instance of the writer via AzureBlobLeaseManager; otherwise they are reserved for this instance.
+//
+// Metrics are published at http://<metrics-addr>/debug/vars and an interrupt drains the Batcher before exiting.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+	"github.com/plasne/go-batcher/v2/cmd/examples/internal/metrics"
+)
+
+type config struct {
+	Endpoint        string
+	Key             string
+	Database        string
+	Container       string
+	RequestUnits    uint32 // provisioned RU/s of the container
+	Cost            uint32 // estimated RU of a single write
+	Documents       int
+	LeaseAccount    string // optional; shares the RU/s across instances
+	LeaseKey        string
+	LeaseContainer  string
+	MetricsAddr     string
+	ShutdownTimeout time.Duration
+}
+
+type stats struct {
+	Written       uint64
+	Failed        uint64
+	RequestCharge float64
+}
+
+func main() {
+	var cfg config
+	var ru, cost uint
+	flag.StringVar(&cfg.Endpoint, "endpoint", os.Getenv("COSMOS_ENDPOINT"), "The Cosmos DB account endpoint (for instance, https://account.documents.azure.com).")
+	flag.StringVar(&cfg.Key, "key", os.Getenv("COSMOS_KEY"), "The Cosmos DB master key.")
+	flag.StringVar(&cfg.Database, "database", "batcher", "The database name.")
+	flag.StringVar(&cfg.Container, "container", "documents", "The container name; it must use /pk as the partition key path.")
+	flag.UintVar(&ru, "ru", 1000, "The provisioned RU/s of the container.")
+	flag.UintVar(&cost, "cost", 10, "The estimated RU of writing a single document.")
+	flag.IntVar(&cfg.Documents, "documents", 10000, "The number of documents to write.")
+	flag.StringVar(&cfg.LeaseAccount, "lease-account", os.Getenv("AZBLOB_ACCOUNT"), "The storage account used to share the RU/s across instances (optional).")
+	flag.StringVar(&cfg.LeaseKey, "lease-key", os.Getenv("AZBLOB_KEY"), "The storage account key.")
+	flag.StringVar(&cfg.LeaseContainer, "lease-container", "cosmos-writer", "The storage container used to share the RU/s across instances.")
+	flag.StringVar(&cfg.MetricsAddr, "metrics-addr", ":8080", "The address to serve metrics on; empty disables them.")
+	flag.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", 30*time.Second, "How long to wait for buffered documents to be written when stopping.")
+	flag.Parse()
+	cfg.RequestUnits, cfg.Cost = uint32(ru), uint32(cost)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	result, err := run(ctx, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("wrote %d documents (%d failed) for %.2f RU.", result.Written, result.Failed, result.RequestCharge)
+}
+
+// This writes the documents until they are all written or the context is done, then drains the Batcher.
+func run(ctx context.Context, cfg config) (stats, error) {
+	var result stats
+	client, err := newCosmosClient(cfg.Endpoint, cfg.Key, cfg.Database, cfg.Container)
+	if err != nil {
+		return result, err
+	}
+
+	// serve metrics
+	m := metrics.New("cosmos-writer")
+	go func() {
+		if err := metrics.Serve(ctx, cfg.MetricsAddr); err != nil {
+			log.Printf("metrics are not available: %v", err)
+		}
+	}()
+
+	// the RU/s are reserved for this instance unless a storage account is provided to share them
+	res := gobatcher.NewSharedResource()
+	if cfg.LeaseAccount != "" {
+		res = res.
+			WithSharedCapacity(cfg.RequestUnits, gobatcher.NewAzureBlobLeaseManager(cfg.LeaseAccount, cfg.LeaseContainer, cfg.LeaseKey)).
+			WithFactor(100)
+	} else {
+		res = res.WithReservedCapacity(cfg.RequestUnits)
+	}
+	batcher := gobatcher.NewBatcher().
+		WithRateLimiter(res)
+	m.WatchRateLimiter(res)
+	m.WatchBatcher(batcher)
+
+	// the batcher and rate limiter keep running until the batcher is drained
+	runCtx, stop := context.WithCancel(context.Background())
+	defer stop()
+	if err := res.Start(runCtx); err != nil {
+		return result, err
+	}
+	if err := batcher.Start(runCtx); err != nil {
+		return result, err
+	}
+
+	// the watcher and the completion callback run concurrently
+	var mutex sync.Mutex
+
+	// write each document in the batch; throttled documents are enqueued again after pausing
+	watcher := gobatcher.NewWatcherWithError(func(ctx context.Context, batch []gobatcher.Operation) error {
+		var wg sync.WaitGroup
+		for _, op := range batch {
+			wg.Add(1)
+			go func(op gobatcher.Operation) {
+				defer wg.Done()
+				charge, err := client.upsert(ctx, op.Payload().(document))
+				m.AddFloat("request-charge", charge)
+				mutex.Lock()
+				result.RequestCharge += charge
+				mutex.Unlock()
+				actual := uint32(math.Ceil(charge))
+				switch {
+				case errors.Is(err, ThrottledError):
+					m.Add("throttled", 1)
+					op.SetResult(gobatcher.Result{Status: gobatcher.ResultFailed, Err: err, ActualCost: actual})
+					batcher.Pause()
+					if err := batcher.Enqueue(op); err != nil {
+						log.Printf("document %s could not be retried: %v", op.Payload().(document).ID, err)
+					}
+				case err != nil:
+					op.SetResult(gobatcher.Result{Status: gobatcher.ResultFailed, Err: err, ActualCost: actual})
+				default:
+					op.SetResult(gobatcher.Result{Status: gobatcher.ResultSucceeded, ActualCost: actual})
+				}
+			}(op)
+		}
+		wg.Wait()
+		return nil
+	}).
+		WithMaxAttempts(3).
+		WithMaxBatchSize(25)
+
+	// count the final result of each document
+	onComplete := func(op gobatcher.Operation, r gobatcher.Result) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if r.Status == gobatcher.ResultSucceeded {
+			result.Written++
+			m.Add("written", 1)
+		} else {
+			result.Failed++
+			m.Add("failed", 1)
+		}
+	}
+
+	// enqueue the documents until they are all enqueued or the context is done
+	for i := 0; i < cfg.Documents; i++ {
+		if ctx.Err() != nil {
+			log.Printf("stopped enqueuing after %d documents.", i)
+			break
+		}
+		doc := document{
+			ID:           fmt.Sprintf("doc-%d-%d", time.Now().UnixNano(), i),
+			PartitionKey: fmt.Sprintf("pk-%d", i%10),
+			Value:        i,
+			Created:      time.Now().UTC(),
+		}
+		op := gobatcher.NewOperation(watcher, cfg.Cost, doc, true).WithOnComplete(onComplete)
+		if err := batcher.EnqueueWithContext(ctx, op); err != nil {
+			log.Printf("stopped enqueuing after %d documents: %v", i, err)
+			break
+		}
+	}
+
+	// drain whatever is still buffered or being written
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	err = batcher.Shutdown(shutdownCtx)
+	mutex.Lock()
+	defer mutex.Unlock()
+	if err != nil {
+		return result, fmt.Errorf("not every document was written before the shutdown timeout: %w", err)
+	}
+	return result, nil
+}
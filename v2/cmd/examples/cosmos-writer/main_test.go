@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun_WritesEveryDocumentAndRetriesThrottled(t *testing.T) {
+	var mutex sync.Mutex
+	written := make(map[string]bool)
+	throttled := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/dbs/db/colls/coll/docs", r.URL.Path)
+		assert.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "type%3Dmaster"), "expecting a master key signature")
+		var doc document
+		err := json.NewDecoder(r.Body).Decode(&doc)
+		assert.NoError(t, err, "expecting a document")
+		mutex.Lock()
+		defer mutex.Unlock()
+		w.Header().Set("x-ms-request-charge", "7.62")
+		if !throttled {
+			throttled = true
+			w.Header().Set("x-ms-retry-after-ms", "10")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		written[doc.ID] = true
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result, err := run(ctx, config{
+		Endpoint:        server.URL,
+		Key:             base64.StdEncoding.EncodeToString([]byte("key")),
+		Database:        "db",
+		Container:       "coll",
+		RequestUnits:    10000,
+		Cost:            10,
+		Documents:       50,
+		ShutdownTimeout: 5 * time.Second,
+	})
+	assert.NoError(t, err, "not expecting a run error")
+	assert.Equal(t, uint64(50), result.Written)
+	assert.Equal(t, uint64(0), result.Failed)
+	assert.InDelta(t, 51*7.62, result.RequestCharge, 0.01, "expecting the throttled request to be charged too")
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Len(t, written, 50)
+}
@@ -0,0 +1,78 @@
+// Package metrics wires the events raised by Batcher and SharedResource into expvar so the example applications can be observed
+// at /debug/vars while they run. It is only meant for the examples; a real service would forward the same events to its own metrics
+// system.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"net/http"
+	"time"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+)
+
+// Metrics is a named set of counters and gauges published with expvar.
+type Metrics struct {
+	vars *expvar.Map
+}
+
+// This method creates (or reuses) the expvar map with the provided name.
+func New(name string) *Metrics {
+	if existing, ok := expvar.Get(name).(*expvar.Map); ok {
+		return &Metrics{vars: existing}
+	}
+	return &Metrics{vars: expvar.NewMap(name)}
+}
+
+// This method adds delta to the counter with the provided name.
+func (m *Metrics) Add(name string, delta int64) {
+	m.vars.Add(name, delta)
+}
+
+// This method adds delta to the floating-point counter with the provided name (for instance, request units consumed).
+func (m *Metrics) AddFloat(name string, delta float64) {
+	m.vars.AddFloat(name, delta)
+}
+
+// This method counts every event raised by the Batcher (as "batcher.<event>") and publishes gauges for the operations in the buffer,
+// the inflight batches, and the capacity needed.
+func (m *Metrics) WatchBatcher(batcher gobatcher.Batcher) {
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		m.vars.Add("batcher."+event, 1)
+	})
+	m.vars.Set("batcher.buffered", expvar.Func(func() interface{} { return batcher.OperationsInBuffer() }))
+	m.vars.Set("batcher.inflight", expvar.Func(func() interface{} { return batcher.Inflight() }))
+	m.vars.Set("batcher.needs-capacity", expvar.Func(func() interface{} { return batcher.NeedsCapacity() }))
+}
+
+// This method counts every event raised by the RateLimiter (as "ratelimiter.<event>") and publishes gauges for its capacity.
+func (m *Metrics) WatchRateLimiter(rl gobatcher.RateLimiter) {
+	rl.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		m.vars.Add("ratelimiter."+event, 1)
+	})
+	m.vars.Set("ratelimiter.capacity", expvar.Func(func() interface{} { return rl.Capacity() }))
+	m.vars.Set("ratelimiter.max-capacity", expvar.Func(func() interface{} { return rl.MaxCapacity() }))
+}
+
+// This method serves expvar at /debug/vars on the provided address until the context is done. Nothing is served if the address is
+// empty.
+func Serve(ctx context.Context, addr string) error {
+	if addr == "" {
+		return nil
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+// This example runs two instances that share the same capacity through AzureBlobLeaseManager, backed by Azurite. The first instance
+// has a steady workload and the second is idle until halfway through the run and then bursts, so you can watch the partitions (and
+// therefore the capacity) move between them. Azurite is started with docker unless AZURITE_BLOB_ENDPOINT is set.
+//
+// Metrics for each instance are published at http://<metrics-addr>/debug/vars and an interrupt drains both Batchers before exiting.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+	"github.com/plasne/go-batcher/v2/cmd/examples/internal/metrics"
+	"github.com/plasne/go-batcher/v2/testutil"
+)
+
+type config struct {
+	SharedCapacity  uint32
+	Factor          uint32
+	Cost            uint32        // the cost of each operation
+	Steady          uint32        // operations per second enqueued by the first instance
+	Burst           uint32        // operations per second enqueued by the second instance after half of the duration
+	Latency         time.Duration // how long the simulated datastore takes to write a batch
+	Duration        time.Duration
+	Container       string
+	MetricsAddr     string
+	ShutdownTimeout time.Duration
+}
+
+type instanceReport struct {
+	Processed       uint64
+	AverageCapacity float64
+}
+
+type instance struct {
+	name      string
+	resource  gobatcher.SharedResource
+	batcher   gobatcher.Batcher
+	processed uint64
+}
+
+func main() {
+	var cfg config
+	var capacity, factor, cost, steady, burst uint
+	flag.UintVar(&capacity, "capacity", 10000, "The shared capacity.")
+	flag.UintVar(&factor, "factor", 1000, "The capacity of each partition.")
+	flag.UintVar(&cost, "cost", 10, "The cost of each operation.")
+	flag.UintVar(&steady, "steady", 300, "The operations per second enqueued by the first instance.")
+	flag.UintVar(&burst, "burst", 800, "The operations per second enqueued by the second instance after half of the duration.")
+	flag.DurationVar(&cfg.Latency, "latency", 50*time.Millisecond, "How long the simulated datastore takes to write a batch.")
+	flag.DurationVar(&cfg.Duration, "duration", 2*time.Minute, "How long to run.")
+	flag.StringVar(&cfg.Container, "container", fmt.Sprintf("demo%d", time.Now().Unix()), "The container to lease partitions from.")
+	flag.StringVar(&cfg.MetricsAddr, "metrics-addr", ":8080", "The address to serve metrics on; empty disables them.")
+	flag.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", 30*time.Second, "How long to wait for buffered operations when stopping.")
+	flag.Parse()
+	cfg.SharedCapacity, cfg.Factor, cfg.Cost, cfg.Steady, cfg.Burst = uint32(capacity), uint32(factor), uint32(cost), uint32(steady), uint32(burst)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	azurite, err := testutil.StartAzurite(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer azurite.Stop()
+	reports, err := run(ctx, cfg, azurite)
+	if err != nil {
+		log.Print(err)
+	}
+	for i, report := range reports {
+		log.Printf("instance-%d processed %d operations with an average capacity of %.0f.", i+1, report.Processed, report.AverageCapacity)
+	}
+}
+
+// This runs both instances for the duration (or until the context is done), then drains them.
+func run(ctx context.Context, cfg config, azurite *testutil.Azurite) ([]instanceReport, error) {
+	ctx, cancel := context.WithTimeout(ctx, cfg.Duration)
+	defer cancel()
+
+	// serve metrics
+	go func() {
+		if err := metrics.Serve(ctx, cfg.MetricsAddr); err != nil {
+			log.Printf("metrics are not available: %v", err)
+		}
+	}()
+
+	// the resources and batchers keep running until the batchers are drained
+	runCtx, stop := context.WithCancel(context.Background())
+	defer stop()
+	instances := make([]*instance, 2)
+	for i := range instances {
+		inst := &instance{name: fmt.Sprintf("instance-%d", i+1)}
+		inst.resource = azurite.NewSharedResource(cfg.Container, cfg.SharedCapacity, cfg.Factor)
+		inst.batcher = gobatcher.NewBatcher().
+			WithRateLimiter(inst.resource)
+		m := metrics.New(inst.name)
+		m.WatchRateLimiter(inst.resource)
+		m.WatchBatcher(inst.batcher)
+		if err := inst.resource.Start(runCtx); err != nil {
+			return nil, err
+		}
+		if err := inst.batcher.Start(runCtx); err != nil {
+			return nil, err
+		}
+		instances[i] = inst
+	}
+
+	// the first instance is steady and the second bursts after half of the duration
+	started := time.Now()
+	workloads := []func(elapsed time.Duration) uint32{
+		func(elapsed time.Duration) uint32 { return cfg.Steady },
+		func(elapsed time.Duration) uint32 {
+			if elapsed < cfg.Duration/2 {
+				return 0
+			}
+			return cfg.Burst
+		},
+	}
+	var wg sync.WaitGroup
+	for i, inst := range instances {
+		wg.Add(1)
+		go func(inst *instance, workload func(elapsed time.Duration) uint32) {
+			defer wg.Done()
+			produce(ctx, cfg, started, inst, workload)
+		}(inst, workloads[i])
+	}
+
+	// report the capacity of each instance every second
+	samples := 0
+	sums := make([]float64, len(instances))
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-ticker.C:
+			samples++
+			line := fmt.Sprintf("%3.0fs:", time.Since(started).Seconds())
+			for i, inst := range instances {
+				capacity := inst.resource.Capacity()
+				sums[i] += float64(capacity)
+				line += fmt.Sprintf(" %s has %5d capacity and needs %5d;", inst.name, capacity, inst.batcher.NeedsCapacity())
+			}
+			log.Print(line)
+		}
+	}
+	wg.Wait()
+
+	// drain both instances
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer shutdownCancel()
+	var shutdownErr error
+	reports := make([]instanceReport, len(instances))
+	for i, inst := range instances {
+		if err := inst.batcher.Shutdown(shutdownCtx); err != nil {
+			shutdownErr = fmt.Errorf("%s was not drained before the shutdown timeout: %w", inst.name, err)
+		}
+		reports[i].Processed = atomic.LoadUint64(&inst.processed)
+		if samples > 0 {
+			reports[i].AverageCapacity = sums[i] / float64(samples)
+		}
+	}
+	return reports, shutdownErr
+}
+
+// This enqueues operations at the rate given by the workload until the context is done. The watcher simulates a datastore that takes
+// the configured latency to write each batch.
+func produce(ctx context.Context, cfg config, started time.Time, inst *instance, workload func(elapsed time.Duration) uint32) {
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		time.Sleep(cfg.Latency)
+		atomic.AddUint64(&inst.processed, uint64(len(batch)))
+	}).
+		WithMaxBatchSize(100)
+	tick := 10 * time.Millisecond
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	var owed float64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			owed += float64(workload(time.Since(started))) * tick.Seconds()
+			for ; owed >= 1; owed-- {
+				op := gobatcher.NewOperation(watcher, cfg.Cost, struct{}{}, true)
+				if err := inst.batcher.EnqueueWithContext(ctx, op); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/plasne/go-batcher/v2/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun_BothInstancesProcessAndDrain(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	azurite, err := testutil.StartAzurite(ctx)
+	if err != nil {
+		t.Skipf("azurite is not available: %v", err)
+	}
+	defer azurite.Stop()
+	reports, err := run(ctx, config{
+		SharedCapacity:  10000,
+		Factor:          1000,
+		Cost:            10,
+		Steady:          300,
+		Burst:           800,
+		Latency:         10 * time.Millisecond,
+		Duration:        10 * time.Second,
+		Container:       fmt.Sprintf("test%d", time.Now().UnixNano()),
+		ShutdownTimeout: 30 * time.Second,
+	}, azurite)
+	assert.NoError(t, err, "expecting both instances to drain")
+	assert.Len(t, reports, 2)
+	for i, report := range reports {
+		assert.Greater(t, report.Processed, uint64(0), "expecting instance-%d to process operations", i+1)
+		assert.Greater(t, report.AverageCapacity, float64(0), "expecting instance-%d to be allocated capacity", i+1)
+	}
+}
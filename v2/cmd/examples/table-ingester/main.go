@@ -0,0 +1,171 @@
+// This example ingests sensor readings into Azure Table storage without exceeding the scalability target of a table partition. The
+// rate limiter is a golang.org/x/time/rate limiter adapted with the xrate package and every entity costs 1. Readings use the typed
+// package so the Watcher receives entities rather than interface{} payloads. If the service reports that it is busy, the reading is
+// enqueued again and the Watcher cools down before it is given another batch.
+//
+// Metrics are published at http://<metrics-addr>/debug/vars and an interrupt drains the Batcher before exiting.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+	"github.com/plasne/go-batcher/v2/cmd/examples/internal/metrics"
+	"github.com/plasne/go-batcher/v2/typed"
+	"github.com/plasne/go-batcher/v2/xrate"
+	"golang.org/x/time/rate"
+)
+
+type config struct {
+	Endpoint        string
+	Account         string
+	Key             string
+	Table           string
+	Rate            int // entities per second
+	Sensors         int // each sensor is a partition
+	Readings        int
+	MetricsAddr     string
+	ShutdownTimeout time.Duration
+}
+
+type stats struct {
+	Written uint64
+	Failed  uint64
+}
+
+func main() {
+	var cfg config
+	flag.StringVar(&cfg.Endpoint, "endpoint", os.Getenv("TABLE_ENDPOINT"), "The table endpoint (for instance, https://account.table.core.windows.net).")
+	flag.StringVar(&cfg.Account, "account", os.Getenv("TABLE_ACCOUNT"), "The storage account name.")
+	flag.StringVar(&cfg.Key, "key", os.Getenv("TABLE_KEY"), "The storage account key.")
+	flag.StringVar(&cfg.Table, "table", "readings", "The table name; it is created if it does not exist.")
+	flag.IntVar(&cfg.Rate, "rate", 2000, "The maximum number of entities to write per second.")
+	flag.IntVar(&cfg.Sensors, "sensors", 10, "The number of sensors (partitions) to generate readings for.")
+	flag.IntVar(&cfg.Readings, "readings", 10000, "The number of readings to ingest.")
+	flag.StringVar(&cfg.MetricsAddr, "metrics-addr", ":8080", "The address to serve metrics on; empty disables them.")
+	flag.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", 30*time.Second, "How long to wait for buffered readings to be written when stopping.")
+	flag.Parse()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	result, err := run(ctx, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("wrote %d readings (%d failed).", result.Written, result.Failed)
+}
+
+// This ingests the readings until they are all ingested or the context is done, then drains the Batcher.
+func run(ctx context.Context, cfg config) (stats, error) {
+	var result stats
+	client, err := newTableClient(cfg.Endpoint, cfg.Account, cfg.Key, cfg.Table)
+	if err != nil {
+		return result, err
+	}
+	if err := client.createTable(ctx); err != nil {
+		return result, err
+	}
+
+	// serve metrics
+	m := metrics.New("table-ingester")
+	go func() {
+		if err := metrics.Serve(ctx, cfg.MetricsAddr); err != nil {
+			log.Printf("metrics are not available: %v", err)
+		}
+	}()
+
+	// the burst allows a full second of entities so that every flush can be dispatched
+	limiter := xrate.NewRateLimiter(rate.NewLimiter(rate.Limit(cfg.Rate), cfg.Rate))
+	untyped := gobatcher.NewBatcher().
+		WithRateLimiter(limiter)
+	batcher := typed.Wrap[entity](untyped)
+	m.WatchRateLimiter(limiter)
+	m.WatchBatcher(untyped)
+
+	// the batcher keeps running until it is drained
+	runCtx, stop := context.WithCancel(context.Background())
+	defer stop()
+	if err := batcher.Start(runCtx); err != nil {
+		return result, err
+	}
+
+	// write each entity in the batch; readings that could not be written because the service was busy are enqueued again
+	watcher := typed.NewWatcher(func(batch []*typed.Operation[entity]) {
+		var wg sync.WaitGroup
+		for _, op := range batch {
+			wg.Add(1)
+			go func(op *typed.Operation[entity]) {
+				defer wg.Done()
+				err := client.upsert(runCtx, op.Payload())
+				switch {
+				case errors.Is(err, ServerBusyError):
+					m.Add("busy", 1)
+					op.SetResult(gobatcher.Failed(err))
+					if err := batcher.Enqueue(op); err != nil {
+						log.Printf("reading %s/%s could not be retried: %v", op.Payload().PartitionKey, op.Payload().RowKey, err)
+					}
+				case err != nil:
+					op.SetResult(gobatcher.Failed(err))
+				}
+			}(op)
+		}
+		wg.Wait()
+	}).
+		WithMaxAttempts(5).
+		WithMaxBatchSize(100).
+		WithCooldown(1 * time.Second)
+
+	// count the final result of each reading
+	var mutex sync.Mutex
+	onComplete := func(op *typed.Operation[entity], r gobatcher.Result) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if r.Status == gobatcher.ResultSucceeded {
+			result.Written++
+			m.Add("written", 1)
+		} else {
+			result.Failed++
+			m.Add("failed", 1)
+		}
+	}
+
+	// enqueue the readings until they are all enqueued or the context is done
+	for i := 0; i < cfg.Readings; i++ {
+		if ctx.Err() != nil {
+			log.Printf("stopped enqueuing after %d readings.", i)
+			break
+		}
+		reading := entity{
+			PartitionKey: fmt.Sprintf("sensor-%03d", i%cfg.Sensors),
+			RowKey:       fmt.Sprintf("%019d-%06d", time.Now().UnixNano(), i),
+			Reading:      rand.Float64() * 100,
+			Recorded:     time.Now().UTC(),
+		}
+		op := typed.NewOperation(watcher, 1, reading, true).WithOnComplete(onComplete)
+		if err := batcher.EnqueueWithContext(ctx, op); err != nil {
+			log.Printf("stopped enqueuing after %d readings: %v", i, err)
+			break
+		}
+	}
+
+	// drain whatever is still buffered or being written
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	err = batcher.Shutdown(shutdownCtx)
+	mutex.Lock()
+	defer mutex.Unlock()
+	if err != nil {
+		return result, fmt.Errorf("not every reading was written before the shutdown timeout: %w", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun_IngestsEveryReadingAndRetriesWhenBusy(t *testing.T) {
+	var mutex sync.Mutex
+	written := make(map[string]bool)
+	busy := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "SharedKeyLite account:"), "expecting a shared key signature")
+		mutex.Lock()
+		defer mutex.Unlock()
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/Tables":
+			w.WriteHeader(http.StatusConflict) // the table already exists
+		case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/readings("):
+			if !busy {
+				busy = true
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			written[r.URL.Path] = true
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			assert.Failf(t, "unexpected request", "%s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result, err := run(ctx, config{
+		Endpoint:        server.URL,
+		Account:         "account",
+		Key:             base64.StdEncoding.EncodeToString([]byte("key")),
+		Table:           "readings",
+		Rate:            10000,
+		Sensors:         3,
+		Readings:        100,
+		ShutdownTimeout: 5 * time.Second,
+	})
+	assert.NoError(t, err, "not expecting a run error")
+	assert.Equal(t, uint64(100), result.Written)
+	assert.Equal(t, uint64(0), result.Failed)
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Len(t, written, 100)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	ServerBusyError = errors.New("the table service is busy.")
+)
+
+// entity is what is written to the table.
+type entity struct {
+	PartitionKey string    `json:"PartitionKey"`
+	RowKey       string    `json:"RowKey"`
+	Reading      float64   `json:"Reading"`
+	Recorded     time.Time `json:"Recorded"`
+}
+
+// tableClient writes entities using the Table service REST API with SharedKeyLite authorization, so the example has no dependencies
+// beyond the standard library. The endpoint may be https://<account>.table.core.windows.net or a path-style emulator endpoint such as
+// http://127.0.0.1:10002/devstoreaccount1.
+type tableClient struct {
+	endpoint string
+	account  string
+	key      []byte
+	table    string
+	http     *http.Client
+}
+
+func newTableClient(endpoint, account, key, table string) (*tableClient, error) {
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("the storage account key must be base64: %w", err)
+	}
+	return &tableClient{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		account:  account,
+		key:      decoded,
+		table:    table,
+		http:     &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// This sends the request signed as described in "Authorize with Shared Key" (SharedKeyLite for the Table service).
+func (c *tableClient) do(ctx context.Context, method, path string, body interface{}) (*http.Response, error) {
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.endpoint+path, bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	date := time.Now().UTC().Format(http.TimeFormat)
+	u, err := url.Parse(c.endpoint + path)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(date + "\n/" + c.account + u.EscapedPath()))
+	req.Header.Set("Authorization", "SharedKeyLite "+c.account+":"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	req.Header.Set("x-ms-date", date)
+	req.Header.Set("x-ms-version", "2019-02-02")
+	req.Header.Set("Accept", "application/json;odata=nometadata")
+	req.Header.Set("Content-Type", "application/json")
+	return c.http.Do(req)
+}
+
+// This creates the table if it does not already exist.
+func (c *tableClient) createTable(ctx context.Context) error {
+	resp, err := c.do(ctx, http.MethodPost, "/Tables", map[string]string{"TableName": c.table})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusConflict {
+		return fmt.Errorf("the table service returned %d when creating table %s", resp.StatusCode, c.table)
+	}
+	return nil
+}
+
+// This inserts the entity or replaces it if it already exists. If the service is too busy, the error wraps ServerBusyError.
+func (c *tableClient) upsert(ctx context.Context, e entity) error {
+	path := fmt.Sprintf("/%s(PartitionKey='%s',RowKey='%s')", c.table, url.PathEscape(e.PartitionKey), url.PathEscape(e.RowKey))
+	resp, err := c.do(ctx, http.MethodPut, path, e)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusInternalServerError:
+		return fmt.Errorf("%w: status %d", ServerBusyError, resp.StatusCode)
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("the table service returned %d when writing %s/%s", resp.StatusCode, e.PartitionKey, e.RowKey)
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,100 @@
+package batcher
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// compositeRateLimiter allows a Batcher to be limited by several RateLimiters at once (for instance, a request unit limiter and a
+// requests-per-second limiter). Every RateLimiter is charged the full cost of each batch, so a batch is only dispatched if all of them
+// grant the capacity.
+type compositeRateLimiter struct {
+	EventerBase
+	limiters []RateLimiter
+}
+
+func newCompositeRateLimiter(limiters ...RateLimiter) *compositeRateLimiter {
+	return &compositeRateLimiter{
+		limiters: limiters,
+	}
+}
+
+// This returns the smallest MaxCapacity of the RateLimiters since an Operation that costs more could never be granted by all of them.
+func (r *compositeRateLimiter) MaxCapacity() uint32 {
+	var max uint32 = math.MaxUint32
+	for _, rl := range r.limiters {
+		if val := rl.MaxCapacity(); val < max {
+			max = val
+		}
+	}
+	return max
+}
+
+// This returns the smallest Capacity of the RateLimiters.
+func (r *compositeRateLimiter) Capacity() uint32 {
+	var capacity uint32 = math.MaxUint32
+	for _, rl := range r.limiters {
+		if val := rl.Capacity(); val < capacity {
+			capacity = val
+		}
+	}
+	return capacity
+}
+
+// Every RateLimiter is asked for the target.
+func (r *compositeRateLimiter) GiveMe(target uint32) {
+	for _, rl := range r.limiters {
+		rl.GiveMe(target)
+	}
+}
+
+// This reserves the cost from every RateLimiter. If any of them cannot grant it, the reservations already made are released and that
+// error is returned.
+func (r *compositeRateLimiter) Reserve(cost uint32, ttl time.Duration) (ReservationHandle, error) {
+	handles := make(compositeReservation, 0, len(r.limiters))
+	for _, rl := range r.limiters {
+		handle, err := rl.Reserve(cost, ttl)
+		if err != nil {
+			handles.Release()
+			return nil, err
+		}
+		handles = append(handles, handle)
+	}
+	return handles, nil
+}
+
+// This waits for every RateLimiter to have the capacity, stopping at the first error.
+func (r *compositeRateLimiter) WaitForCapacity(ctx context.Context, cost uint32) error {
+	for _, rl := range r.limiters {
+		if err := rl.WaitForCapacity(ctx, cost); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// This starts every RateLimiter, stopping at the first error.
+func (r *compositeRateLimiter) Start(ctx context.Context) error {
+	for _, rl := range r.limiters {
+		if err := rl.Start(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type compositeReservation []ReservationHandle
+
+func (c compositeReservation) Cost() uint32 {
+	if len(c) == 0 {
+		return 0
+	}
+	return c[0].Cost()
+}
+
+func (c compositeReservation) Release() {
+	for _, handle := range c {
+		handle.Release()
+	}
+}
@@ -0,0 +1,353 @@
+package batcher
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the settings of a Batcher (and a SharedResource for it) so they can be loaded from the environment or a file rather than
+// chained in code. A zero value for any setting means the default is used. In a JSON or YAML file, each setting is named by its config
+// tag (for instance, "flushInterval"); in the environment, it is the prefix followed by the name in upper snake case (for instance,
+// "BATCHER_FLUSH_INTERVAL"). Durations are strings such as "100ms" or "1m".
+type Config struct {
+	BufferSize           uint32        `config:"bufferSize"`           // see NewBatcherWithBuffer()
+	FlushInterval        time.Duration `config:"flushInterval"`        // see WithFlushInterval()
+	CapacityInterval     time.Duration `config:"capacityInterval"`     // see WithCapacityInterval()
+	AuditInterval        time.Duration `config:"auditInterval"`        // see WithAuditInterval()
+	MaxOperationTime     time.Duration `config:"maxOperationTime"`     // see WithMaxOperationTime()
+	PauseTime            time.Duration `config:"pauseTime"`            // see WithPauseTime()
+	SummaryInterval      time.Duration `config:"summaryInterval"`      // see WithSummaryInterval()
+	MaxConcurrentBatches uint32        `config:"maxConcurrentBatches"` // see WithMaxConcurrentBatches()
+	MaxBatchesPerFlush   uint32        `config:"maxBatchesPerFlush"`   // see WithMaxBatchesPerFlush()
+	ZeroCostOpsPerSecond uint32        `config:"zeroCostOpsPerSecond"` // see WithZeroCostOpsPerSecond()
+	FlushOnCost          uint32        `config:"flushOnCost"`          // see WithFlushOnCost()
+	ErrorOnFullBuffer    bool          `config:"errorOnFullBuffer"`    // see WithErrorOnFullBuffer()
+	RequireStarted       bool          `config:"requireStarted"`       // see WithRequireStarted()
+	EmitBatch            bool          `config:"emitBatch"`            // see WithEmitBatch()
+	EmitFlush            bool          `config:"emitFlush"`            // see WithEmitFlush()
+	EmitRequest          bool          `config:"emitRequest"`          // see WithEmitRequest()
+
+	// used by NewSharedResourceFromConfig()
+	ReservedCapacity uint32 `config:"reservedCapacity"` // see SharedResource.WithReservedCapacity()
+	SharedCapacity   uint32 `config:"sharedCapacity"`   // see SharedResource.WithSharedCapacity()
+	Factor           uint32 `config:"factor"`           // see SharedResource.WithFactor()
+	MaxInterval      uint32 `config:"maxInterval"`      // see SharedResource.WithMaxInterval()
+}
+
+// ConfigError is returned when a Config cannot be loaded or is not valid. Errors has an entry for each problem so they can all be fixed
+// at once. It unwraps to InvalidConfigError.
+type ConfigError struct {
+	Errors []error
+}
+
+func (e *ConfigError) Error() string {
+	problems := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		problems = append(problems, err.Error())
+	}
+	return fmt.Sprintf("the configuration is not valid: %s", strings.Join(problems, "; "))
+}
+
+func (e *ConfigError) Unwrap() error {
+	return InvalidConfigError
+}
+
+// This returns a *ConfigError listing every problem with the Config or nil if it is valid.
+func (c Config) Validate() error {
+	var errs []error
+	forEachConfigField(&c, func(key string, field reflect.Value) {
+		if d, ok := field.Interface().(time.Duration); ok && d < 0 {
+			errs = append(errs, fmt.Errorf("%s: must not be negative", key))
+		}
+	})
+	if c.ReservedCapacity == 0 && c.SharedCapacity == 0 && (c.Factor > 0 || c.MaxInterval > 0) {
+		errs = append(errs, fmt.Errorf("factor and maxInterval: require reservedCapacity or sharedCapacity"))
+	}
+	if len(errs) > 0 {
+		return &ConfigError{Errors: errs}
+	}
+	return nil
+}
+
+// This method creates a new Batcher from the Config after validating it. The rate limiter is not attached; use
+// NewSharedResourceFromConfig() and WithRateLimiter() if the Config includes capacity.
+func NewBatcherFromConfig(cfg Config) (Batcher, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	bufferSize := cfg.BufferSize
+	if bufferSize == 0 {
+		bufferSize = 10000
+	}
+	b := NewBatcherWithBuffer(bufferSize)
+	if cfg.FlushInterval > 0 {
+		b.WithFlushInterval(cfg.FlushInterval)
+	}
+	if cfg.CapacityInterval > 0 {
+		b.WithCapacityInterval(cfg.CapacityInterval)
+	}
+	if cfg.AuditInterval > 0 {
+		b.WithAuditInterval(cfg.AuditInterval)
+	}
+	if cfg.MaxOperationTime > 0 {
+		b.WithMaxOperationTime(cfg.MaxOperationTime)
+	}
+	if cfg.PauseTime > 0 {
+		b.WithPauseTime(cfg.PauseTime)
+	}
+	if cfg.SummaryInterval > 0 {
+		b.WithSummaryInterval(cfg.SummaryInterval)
+	}
+	if cfg.MaxConcurrentBatches > 0 {
+		b.WithMaxConcurrentBatches(cfg.MaxConcurrentBatches)
+	}
+	if cfg.MaxBatchesPerFlush > 0 {
+		b.WithMaxBatchesPerFlush(cfg.MaxBatchesPerFlush)
+	}
+	if cfg.ZeroCostOpsPerSecond > 0 {
+		b.WithZeroCostOpsPerSecond(cfg.ZeroCostOpsPerSecond)
+	}
+	if cfg.FlushOnCost > 0 {
+		b.WithFlushOnCost(cfg.FlushOnCost)
+	}
+	if cfg.ErrorOnFullBuffer {
+		b.WithErrorOnFullBuffer()
+	}
+	if cfg.RequireStarted {
+		b.WithRequireStarted()
+	}
+	if cfg.EmitBatch {
+		b.WithEmitBatch()
+	}
+	if cfg.EmitFlush {
+		b.WithEmitFlush()
+	}
+	if cfg.EmitRequest {
+		b.WithEmitRequest()
+	}
+	return b, nil
+}
+
+// This applies the settings of the Config that may be changed after Start() (FlushInterval, CapacityInterval, MaxConcurrentBatches,
+// and ErrorOnFullBuffer) together, so a sidecar watching the configuration can retune throughput without a restart. The other settings
+// are ignored. As when creating a Batcher, a zero value means the default (for instance, a MaxConcurrentBatches of 0 removes the limit).
+// If any setting changed, a config-changed event is raised. An invalid Config returns a *ConfigError and nothing is changed.
+func (r *batcher) ApplyConfig(cfg Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	flushInterval, capacityInterval := cfg.FlushInterval, cfg.CapacityInterval
+	if flushInterval == 0 {
+		flushInterval = 100 * time.Millisecond
+	}
+	if capacityInterval == 0 {
+		capacityInterval = 100 * time.Millisecond
+	}
+	var errorOnFullBuffer uint32
+	if cfg.ErrorOnFullBuffer {
+		errorOnFullBuffer = 1
+	}
+
+	// swap every setting while holding the phase so ApplyConfig() calls do not interleave
+	r.phaseMutex.Lock()
+	var changed []string
+	if atomic.SwapInt64(&r.flushInterval, int64(flushInterval)) != int64(flushInterval) {
+		changed = append(changed, "flushInterval")
+	}
+	if atomic.SwapInt64(&r.capacityInterval, int64(capacityInterval)) != int64(capacityInterval) {
+		changed = append(changed, "capacityInterval")
+	}
+	if atomic.SwapUint32(&r.maxConcurrentBatches, cfg.MaxConcurrentBatches) != cfg.MaxConcurrentBatches {
+		changed = append(changed, "maxConcurrentBatches")
+	}
+	if atomic.SwapUint32(&r.errorOnFullBuffer, errorOnFullBuffer) != errorOnFullBuffer {
+		changed = append(changed, "errorOnFullBuffer")
+	}
+	if len(changed) > 0 {
+		r.reconfigure()
+	}
+	r.phaseMutex.Unlock()
+
+	if len(changed) > 0 {
+		r.Emit(ConfigChangedEvent, len(changed), strings.Join(changed, ","), cfg)
+	}
+	return nil
+}
+
+// This method creates a new SharedResource from the capacity settings of the Config after validating it. The LeaseManager is only
+// required (and used) if there is a SharedCapacity.
+func NewSharedResourceFromConfig(cfg Config, mgr LeaseManager) (SharedResource, error) {
+	err := cfg.Validate()
+	if cfg.SharedCapacity > 0 && mgr == nil {
+		problem := fmt.Errorf("sharedCapacity: requires a LeaseManager")
+		if cerr, ok := err.(*ConfigError); ok {
+			cerr.Errors = append(cerr.Errors, problem)
+		} else {
+			err = &ConfigError{Errors: []error{problem}}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	res := NewSharedResource()
+	if cfg.ReservedCapacity > 0 {
+		res.WithReservedCapacity(cfg.ReservedCapacity)
+	}
+	if cfg.SharedCapacity > 0 {
+		res.WithSharedCapacity(cfg.SharedCapacity, mgr)
+	}
+	if cfg.Factor > 0 {
+		res.WithFactor(cfg.Factor)
+	}
+	if cfg.MaxInterval > 0 {
+		res.WithMaxInterval(cfg.MaxInterval)
+	}
+	return res, nil
+}
+
+// This reads a Config from environment variables named by the prefix and each setting in upper snake case (for instance, with a
+// prefix of "BATCHER_", the FlushInterval is read from "BATCHER_FLUSH_INTERVAL"). Variables that are not set use the default.
+func LoadConfigFromEnv(prefix string) (Config, error) {
+	values := make(map[string]string)
+	forEachConfigField(&Config{}, func(key string, _ reflect.Value) {
+		if val, ok := os.LookupEnv(prefix + envName(key)); ok {
+			values[key] = val
+		}
+	})
+	return configFromValues(values, nil)
+}
+
+// This reads a Config from a JSON or YAML file, determined by its extension (".json", ".yaml", or ".yml").
+func LoadConfigFromFile(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return ParseConfigJSON(data)
+	case ".yaml", ".yml":
+		return ParseConfigYAML(data)
+	default:
+		return Config{}, &ConfigError{Errors: []error{fmt.Errorf("%s: must be a .json, .yaml, or .yml file", path)}}
+	}
+}
+
+// This parses a Config from a JSON object.
+func ParseConfigJSON(data []byte) (Config, error) {
+	var raw map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		return Config{}, &ConfigError{Errors: []error{err}}
+	}
+	return configFromRaw(raw)
+}
+
+// This parses a Config from a YAML mapping.
+func ParseConfigYAML(data []byte) (Config, error) {
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return Config{}, &ConfigError{Errors: []error{err}}
+	}
+	return configFromRaw(raw)
+}
+
+// This converts each scalar in a decoded file to a string so files and the environment are parsed the same way.
+func configFromRaw(raw map[string]interface{}) (Config, error) {
+	values := make(map[string]string, len(raw))
+	var errs []error
+	for key, val := range raw {
+		switch val.(type) {
+		case map[string]interface{}, []interface{}, nil:
+			errs = append(errs, fmt.Errorf("%s: must be a single value", key))
+		default:
+			values[key] = fmt.Sprint(val)
+		}
+	}
+	return configFromValues(values, errs)
+}
+
+// This sets each field from its value, then validates the result. Every problem (including any provided) is returned together.
+func configFromValues(values map[string]string, errs []error) (Config, error) {
+	var cfg Config
+	known := make(map[string]bool)
+	forEachConfigField(&cfg, func(key string, field reflect.Value) {
+		known[key] = true
+		val, ok := values[key]
+		if !ok {
+			return
+		}
+		if err := setConfigField(field, strings.TrimSpace(val)); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	})
+	for key := range values {
+		if !known[key] {
+			errs = append(errs, fmt.Errorf("%s: is not a known setting", key))
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		errs = append(errs, err.(*ConfigError).Errors...)
+	}
+	if len(errs) > 0 {
+		sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+		return cfg, &ConfigError{Errors: errs}
+	}
+	return cfg, nil
+}
+
+func forEachConfigField(cfg *Config, fn func(key string, field reflect.Value)) {
+	v := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		fn(v.Type().Field(i).Tag.Get("config"), v.Field(i))
+	}
+}
+
+func setConfigField(field reflect.Value, val string) error {
+	switch field.Interface().(type) {
+	case time.Duration:
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return fmt.Errorf("must be a duration such as 100ms or 1m")
+		}
+		field.SetInt(int64(d))
+	case uint32:
+		n, err := strconv.ParseUint(val, 10, 32)
+		if err != nil {
+			return fmt.Errorf("must be a whole number up to %d", uint32(1<<32-1))
+		}
+		field.SetUint(n)
+	case bool:
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return fmt.Errorf("must be true or false")
+		}
+		field.SetBool(b)
+	}
+	return nil
+}
+
+// This converts a setting such as "flushInterval" to "FLUSH_INTERVAL".
+func envName(key string) string {
+	var name strings.Builder
+	for i, r := range key {
+		if unicode.IsUpper(r) && i > 0 {
+			name.WriteByte('_')
+		}
+		name.WriteRune(unicode.ToUpper(r))
+	}
+	return name.String()
+}
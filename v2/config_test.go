@@ -0,0 +1,121 @@
+package batcher_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConfigFromEnv_ReadsPrefixedVariables(t *testing.T) {
+	t.Setenv("BATCHER_FLUSH_INTERVAL", "50ms")
+	t.Setenv("BATCHER_MAX_CONCURRENT_BATCHES", "4")
+	t.Setenv("BATCHER_ERROR_ON_FULL_BUFFER", "true")
+	t.Setenv("BATCHER_RESERVED_CAPACITY", "2000")
+	cfg, err := gobatcher.LoadConfigFromEnv("BATCHER_")
+	assert.NoError(t, err, "not expecting a load error")
+	assert.Equal(t, gobatcher.Config{
+		FlushInterval:        50 * time.Millisecond,
+		MaxConcurrentBatches: 4,
+		ErrorOnFullBuffer:    true,
+		ReservedCapacity:     2000,
+	}, cfg)
+}
+
+func TestLoadConfigFromFile_ParsesYAMLAndJSON(t *testing.T) {
+	dir := t.TempDir()
+	yamlPath := filepath.Join(dir, "batcher.yaml")
+	err := os.WriteFile(yamlPath, []byte("bufferSize: 100\nflushInterval: 1s\nemitFlush: true\n"), 0600)
+	assert.NoError(t, err)
+	jsonPath := filepath.Join(dir, "batcher.json")
+	err = os.WriteFile(jsonPath, []byte(`{"bufferSize": 100, "flushInterval": "1s", "emitFlush": true}`), 0600)
+	assert.NoError(t, err)
+	expected := gobatcher.Config{BufferSize: 100, FlushInterval: time.Second, EmitFlush: true}
+	for _, path := range []string{yamlPath, jsonPath} {
+		cfg, err := gobatcher.LoadConfigFromFile(path)
+		assert.NoError(t, err, "not expecting a load error for %v", path)
+		assert.Equal(t, expected, cfg, "expecting the settings from %v", path)
+	}
+}
+
+func TestParseConfigJSON_ListsEveryProblem(t *testing.T) {
+	_, err := gobatcher.ParseConfigJSON([]byte(`{"flushInterval": 100, "pauseTime": "-1s", "maxConcurrentBatches": "lots", "unknown": 1}`))
+	assert.ErrorIs(t, err, gobatcher.InvalidConfigError, "expecting the config to be invalid")
+	var cerr *gobatcher.ConfigError
+	if assert.True(t, errors.As(err, &cerr), "expecting a ConfigError") {
+		assert.Len(t, cerr.Errors, 4, "expecting every problem to be listed")
+		assert.Contains(t, err.Error(), "flushInterval: must be a duration")
+		assert.Contains(t, err.Error(), "maxConcurrentBatches: must be a whole number")
+		assert.Contains(t, err.Error(), "pauseTime: must not be negative")
+		assert.Contains(t, err.Error(), "unknown: is not a known setting")
+	}
+}
+
+func TestNewBatcherFromConfig_AppliesTheSettings(t *testing.T) {
+	batcher, err := gobatcher.NewBatcherFromConfig(gobatcher.Config{BufferSize: 1, ErrorOnFullBuffer: true})
+	assert.NoError(t, err, "not expecting a config error")
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {})
+	err = batcher.Enqueue(gobatcher.NewOperation(watcher, 0, struct{}{}, false))
+	assert.NoError(t, err, "not expecting an enqueue error")
+	err = batcher.Enqueue(gobatcher.NewOperation(watcher, 0, struct{}{}, false))
+	assert.ErrorIs(t, err, gobatcher.BufferFullError, "expecting the buffer size and full buffer error to be applied")
+
+	_, err = gobatcher.NewBatcherFromConfig(gobatcher.Config{FlushInterval: -1})
+	assert.ErrorIs(t, err, gobatcher.InvalidConfigError, "expecting an invalid config to be rejected")
+}
+
+func TestNewSharedResourceFromConfig_RequiresALeaseManagerForSharedCapacity(t *testing.T) {
+	res, err := gobatcher.NewSharedResourceFromConfig(gobatcher.Config{ReservedCapacity: 2000}, nil)
+	assert.NoError(t, err, "not expecting a config error")
+	assert.Equal(t, uint32(2000), res.MaxCapacity())
+	_, err = gobatcher.NewSharedResourceFromConfig(gobatcher.Config{SharedCapacity: 10000}, nil)
+	assert.ErrorIs(t, err, gobatcher.InvalidConfigError, "expecting shared capacity without a lease manager to be rejected")
+}
+
+func TestBatcher_ApplyConfig_SwapsTunableSettings(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcherWithBuffer(1).
+		WithFlushInterval(1 * time.Hour)
+	var changes []string
+	var mutex sync.Mutex
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		changes = append(changes, msg)
+	}, gobatcher.OnlyEvents(gobatcher.ConfigChangedEvent))
+	processed := make(chan struct{}, 1)
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		processed <- struct{}{}
+	})
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+
+	// the buffer errors once it is full and the new flush interval processes the operation
+	err = batcher.ApplyConfig(gobatcher.Config{FlushInterval: 1 * time.Hour, ErrorOnFullBuffer: true})
+	assert.NoError(t, err, "not expecting a config error")
+	err = batcher.Enqueue(gobatcher.NewOperation(watcher, 0, struct{}{}, false))
+	assert.NoError(t, err, "not expecting an enqueue error")
+	err = batcher.Enqueue(gobatcher.NewOperation(watcher, 0, struct{}{}, false))
+	assert.ErrorIs(t, err, gobatcher.BufferFullError, "expecting the buffer error mode to be applied")
+	err = batcher.ApplyConfig(gobatcher.Config{FlushInterval: 1 * time.Millisecond, ErrorOnFullBuffer: true})
+	assert.NoError(t, err, "not expecting a config error")
+	select {
+	case <-processed:
+	case <-time.After(1 * time.Second):
+		assert.Fail(t, "expected the new flush interval to be applied")
+	}
+
+	// an invalid config changes nothing
+	err = batcher.ApplyConfig(gobatcher.Config{FlushInterval: -1})
+	assert.ErrorIs(t, err, gobatcher.InvalidConfigError, "expecting an invalid config to be rejected")
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Equal(t, []string{"errorOnFullBuffer", "flushInterval"}, changes, "expecting an event naming the settings that changed")
+}
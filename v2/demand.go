@@ -0,0 +1,46 @@
+package batcher
+
+import (
+	"context"
+	"time"
+)
+
+// A LeaseManager may optionally implement DemandStore to allow every SharedResource sharing the same partitions to publish how much
+// capacity it wants. This gives visibility into whether the fleet is under-provisioned or just unevenly allocated.
+type DemandStore interface {
+	WriteDemand(ctx context.Context, instance string, target uint32) error
+	ReadDemand(ctx context.Context, since time.Time) (map[string]uint32, error)
+}
+
+// A LeaseManager that implements DemandStore may also implement LendingStore to allow every SharedResource sharing the same partitions
+// to publish how much of its reserved capacity it is lending to the shared pool (see SharedResource.WithCapacityLending()).
+type LendingStore interface {
+	WriteLent(ctx context.Context, instance string, lent uint32) error
+	ReadLent(ctx context.Context, since time.Time) (map[string]uint32, error)
+}
+
+// A LeaseManager that implements DemandStore may also implement PolicyStore to allow a FleetPolicy to be shared by every SharedResource
+// sharing the same partitions (see SharedResource.WithCapacityFloor()). ReadPolicy() returns the zero FleetPolicy if none was written.
+type PolicyStore interface {
+	WritePolicy(ctx context.Context, policy FleetPolicy) error
+	ReadPolicy(ctx context.Context) (FleetPolicy, error)
+}
+
+// FleetPolicy describes rules that every SharedResource sharing the same partitions follows when obtaining partitions.
+type FleetPolicy struct {
+	MinPartitionsPerInstance uint32 // every live instance is guaranteed this many partitions before any instance exceeds its fair share
+}
+
+// FleetDemand describes the shared capacity requested by every live instance compared to the shared capacity that is available.
+type FleetDemand struct {
+	Instances      int
+	Demand         uint64
+	SharedCapacity uint32
+	AsOf           time.Time
+}
+
+// This returns true if the fleet is asking for more shared capacity than exists. If this is false but instances are still short on
+// capacity, the capacity is simply unevenly allocated.
+func (d FleetDemand) IsUnderProvisioned() bool {
+	return d.Demand > uint64(d.SharedCapacity)
+}
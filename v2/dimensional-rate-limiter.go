@@ -0,0 +1,80 @@
+package batcher
+
+import "time"
+
+// A DimensionalRateLimiter is a RateLimiter that is charged the cost of each Operation in a single dimension (see
+// NewOperationWithCosts()).
+type DimensionalRateLimiter interface {
+	RateLimiter
+	Dimension() string
+}
+
+type dimensionalRateLimiter struct {
+	RateLimiter
+	dimension string
+}
+
+// Some datastores enforce more than one quota at the same time (for instance, Cosmos enforces both request units and requests per
+// second). This method binds a RateLimiter to a dimension so that it is charged Operation.CostOf(dimension) rather than Operation.Cost().
+// You would typically provide one DimensionalRateLimiter per dimension to Batcher.WithRateLimiters(). Every call is passed through to the
+// provided RateLimiter, so you should only bind a RateLimiter once and use what is returned everywhere.
+func NewDimensionalRateLimiter(dimension string, rl RateLimiter) DimensionalRateLimiter {
+	return &dimensionalRateLimiter{
+		RateLimiter: rl,
+		dimension:   dimension,
+	}
+}
+
+// This returns the dimension the RateLimiter is charged in.
+func (r *dimensionalRateLimiter) Dimension() string {
+	return r.dimension
+}
+
+// This returns the cost of the Operation for the RateLimiter, which is the cost in its dimension if it is a DimensionalRateLimiter.
+func costFor(rl RateLimiter, op Operation) uint32 {
+	if d, ok := rl.(DimensionalRateLimiter); ok {
+		return op.CostOf(d.Dimension())
+	}
+	return op.Cost()
+}
+
+// This returns the RateLimiters that must each grant the cost of an Operation; a composite RateLimiter is made up of several.
+func limiterParts(rl RateLimiter) []RateLimiter {
+	if c, ok := rl.(*compositeRateLimiter); ok {
+		return c.limiters
+	}
+	return []RateLimiter{rl}
+}
+
+// This is TRUE if every part of the RateLimiter could grant the cost of the Operation.
+func fitsMaxCapacity(rl RateLimiter, op Operation) bool {
+	for _, part := range limiterParts(rl) {
+		if costFor(part, op) > part.MaxCapacity() {
+			return false
+		}
+	}
+	return true
+}
+
+// This reserves the cost of the Operations (multiplied by the scale) from every part of the RateLimiter. If any of them cannot grant it,
+// the reservations already made are released and that error is returned.
+func reserveFor(rl RateLimiter, ops []Operation, ttl time.Duration, scale float64) (ReservationHandle, error) {
+	parts := limiterParts(rl)
+	handles := make(compositeReservation, 0, len(parts))
+	for _, part := range parts {
+		var cost uint32
+		for _, op := range ops {
+			cost += costFor(part, op)
+		}
+		handle, err := part.Reserve(scaleCost(cost, scale), ttl)
+		if err != nil {
+			handles.Release()
+			return nil, err
+		}
+		handles = append(handles, handle)
+	}
+	if len(handles) == 1 {
+		return handles[0], nil
+	}
+	return handles, nil
+}
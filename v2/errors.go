@@ -1,6 +1,9 @@
 package batcher
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 const (
 	AuditMsgFailureOnTargetAndInflight = "an audit revealed that the target and inflight should both be zero but neither was."
@@ -18,4 +21,49 @@ var (
 	NoOperationError             = errors.New("no operation was provided.")
 	InitializationOnlyError      = errors.New("this property can only be set before Start() is called.")
 	SharedCapacityNotProvisioned = errors.New("shared capacity cannot be set if it was not provisioned.")
+	InsufficientCapacityError    = errors.New("there is not enough capacity available to reserve.")
+	DemandNotSupportedError      = errors.New("the lease manager does not support sharing demand.")
+	PolicyNotSupportedError      = errors.New("the lease manager does not support sharing a fleet policy.")
+	WatcherPanicError            = errors.New("the watcher panicked while processing the batch.")
+	BatchNotRequeueableError     = errors.New("the batch can only be requeued once while the watcher is processing it.")
+	NotStartedError              = errors.New("operations cannot be enqueued until Start() is called.")
+	InvalidConnectionStringError = errors.New("the connection string must include an AccountKey or SharedAccessSignature and an AccountName or BlobEndpoint.")
+	NotRunningError              = errors.New("the processing loop is not running.")
+	AuditFailingError            = errors.New("the audit has failed repeatedly.")
+	LeaseManagerUnreachableError = errors.New("the lease manager has not responded successfully since it raised an error.")
+	InvalidConfigError           = errors.New("the configuration is not valid.")
+	OperationExpiredError        = errors.New("the operation expired before it was dispatched.")
+	OperationCancelledError      = errors.New("the operation was cancelled before it was dispatched.")
+	DuplicateOperationError      = errors.New("an operation with the same dedup key is already in the buffer.")
+	OperationDroppedError        = errors.New("the operation was dropped because the buffer was full.")
+	UnlabeledWatcherError        = errors.New("the watcher must have a label for its operations to be stored.")
+	UnknownWatcherError          = errors.New("no watcher with that label was provided.")
+	OperationPurgedError         = errors.New("the operation was purged before it was dispatched.")
+	TooManyPartitionsError       = errors.New("the shared capacity would need more than 500 partitions at the factor.")
 )
+
+// EnqueueManyError is returned by EnqueueMany() when one or more of the Operations could not be enqueued. Errors has an entry for each
+// Operation provided (in the same order) which is nil if that Operation was enqueued. It unwraps to the first error so you can use
+// errors.Is() to check for errors such as BufferFullError.
+type EnqueueManyError struct {
+	Errors []error
+}
+
+func (e *EnqueueManyError) Error() string {
+	failed := 0
+	for _, err := range e.Errors {
+		if err != nil {
+			failed++
+		}
+	}
+	return fmt.Sprintf("%d of %d operations could not be enqueued; the first error was: %v", failed, len(e.Errors), e.Unwrap())
+}
+
+func (e *EnqueueManyError) Unwrap() error {
+	for _, err := range e.Errors {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,62 +2,118 @@ package batcher
 
 import (
 	"sync"
+	"sync/atomic"
 
 	"github.com/google/uuid"
 )
 
 type EventerBase struct {
-	listenerMutex sync.RWMutex
-	listeners     map[uuid.UUID]func(event string, val int, msg string, metadata interface{})
+	listenerMutex sync.Mutex   // held while the listeners are changed
+	listeners     atomic.Value // a []registeredListener that is replaced (never modified) whenever the listeners are changed
+
+	// only used when events are dispatched asynchronously (see async-events.go)
+	queue        chan queuedEvent
+	dropped      uint64
+	pending      int64      // the events that were emitted and not yet raised or dropped; written with atomics
+	pendingMutex sync.Mutex // only held to wait for (or announce) no pending events
+	pendingCond  *sync.Cond
+}
+
+type registeredListener struct {
+	id uuid.UUID
+	listener
 }
 
 type Eventer interface {
-	AddListener(fn func(event string, val int, msg string, metadata interface{})) uuid.UUID
+	AddListener(fn func(event string, val int, msg string, metadata interface{}), opts ...ListenerOption) uuid.UUID
 	RemoveListener(id uuid.UUID)
 	Emit(event string, val int, msg string, metadata interface{})
 }
 
-// You can add a listener to catch events that are raised by Batcher or a RateLimiter.
-func (r *EventerBase) AddListener(fn func(event string, val int, msg string, metadata interface{})) uuid.UUID {
+// You can add a listener to catch events that are raised by Batcher or a RateLimiter. By default the listener is called for every event;
+// you may provide options such as OnlyEvents() or ForWatcher() so that it is only called for the events you care about.
+func (r *EventerBase) AddListener(fn func(event string, val int, msg string, metadata interface{}), opts ...ListenerOption) uuid.UUID {
 
 	// lock
 	r.listenerMutex.Lock()
 	defer r.listenerMutex.Unlock()
 
-	// allocate
-	if r.listeners == nil {
-		r.listeners = make(map[uuid.UUID]func(event string, val int, msg string, metadata interface{}))
-	}
-
-	// add a new listener
+	// add a new listener to a copy of the listeners
 	id := uuid.New()
-	r.listeners[id] = fn
+	l := listener{fn: fn}
+	for _, opt := range opts {
+		opt(&l)
+	}
+	current := r.loadListeners()
+	next := make([]registeredListener, 0, len(current)+1)
+	next = append(next, current...)
+	r.listeners.Store(append(next, registeredListener{id: id, listener: l}))
 
 	return id
 }
 
 // If you no longer need to catch events that are raised by Batcher or a RateLimiter, you can use this method to remove the listener.
+// Events are raised without a lock, so the listener may still be called by an event that was being raised at the same time.
 func (r *EventerBase) RemoveListener(id uuid.UUID) {
 
 	// lock
 	r.listenerMutex.Lock()
 	defer r.listenerMutex.Unlock()
 
+	// remove from a copy of the listeners
+	current := r.loadListeners()
+	next := make([]registeredListener, 0, len(current))
+	for _, l := range current {
+		if l.id != id {
+			next = append(next, l)
+		}
+	}
+	r.listeners.Store(next)
+
+}
+
+// This returns the number of listeners that are currently attached.
+func (r *EventerBase) ListenerCount() int {
+	return len(r.loadListeners())
+}
+
+// This returns the current listeners. The slice is never modified, so it can be used without a lock.
+func (r *EventerBase) loadListeners() []registeredListener {
+	listeners, _ := r.listeners.Load().([]registeredListener)
+	return listeners
+}
+
+// This method removes every listener. It is useful when the dependencies the listeners reference (loggers, metrics, channels, etc.)
+// are being torn down so that no stale callback can be raised by events that are raised afterwards.
+func (r *EventerBase) RemoveAllListeners() {
+
+	// lock
+	r.listenerMutex.Lock()
+	defer r.listenerMutex.Unlock()
+
 	// remove
-	delete(r.listeners, id)
+	r.listeners.Store([]registeredListener(nil))
 
 }
 
 // To raise an event, you may emit a unique string for the event along with val, msg, and metadata as appropriate to describe the event.
 func (r *EventerBase) Emit(event string, val int, msg string, metadata interface{}) {
+	if r.queue != nil {
+		r.emitAsync(queuedEvent{event: event, val: val, msg: msg, metadata: metadata})
+		return
+	}
+	r.dispatch(event, val, msg, metadata)
+}
 
-	// lock
-	r.listenerMutex.RLock()
-	defer r.listenerMutex.RUnlock()
+// This calls every listener that accepts the event. The listeners are loaded without a lock, so raising an event never waits for a
+// listener to be added or removed (or for another event).
+func (r *EventerBase) dispatch(event string, val int, msg string, metadata interface{}) {
 
 	// emit
-	for _, fn := range r.listeners {
-		fn(event, val, msg, metadata)
+	for _, l := range r.loadListeners() {
+		if l.accepts(event, metadata) {
+			l.fn(event, val, msg, metadata)
+		}
 	}
 
 }
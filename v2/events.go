@@ -1,26 +1,56 @@
 package batcher
 
 const (
-	BatchEvent             = "batch"
-	PauseEvent             = "pause"
-	ResumeEvent            = "resume"
-	ShutdownEvent          = "shutdown"
-	AuditPassEvent         = "audit-pass"
-	AuditFailEvent         = "audit-fail"
-	AuditSkipEvent         = "audit-skip"
-	RequestEvent           = "request"
-	CapacityEvent          = "capacity"
-	ReleasedEvent          = "released"
-	AllocatedEvent         = "allocated"
-	TargetEvent            = "target"
-	VerifiedContainerEvent = "verified-container"
-	CreatedContainerEvent  = "created-container"
-	ProvisionStartEvent    = "provision-start"
-	ProvisionDoneEvent     = "provision-done"
-	VerifiedBlobEvent      = "verified-blob"
-	CreatedBlobEvent       = "created-blob"
-	FailedEvent            = "failed"
-	ErrorEvent             = "error"
-	FlushStartEvent        = "flush-start"
-	FlushDoneEvent         = "flush-done"
+	BatchEvent              = "batch"
+	PauseEvent              = "pause"
+	ResumeEvent             = "resume"
+	ShutdownEvent           = "shutdown"
+	AuditPassEvent          = "audit-pass"
+	AuditFailEvent          = "audit-fail"
+	AuditSkipEvent          = "audit-skip"
+	RequestEvent            = "request"
+	CapacityEvent           = "capacity"
+	ReleasedEvent           = "released"
+	AllocatedEvent          = "allocated"
+	TargetEvent             = "target"
+	VerifiedContainerEvent  = "verified-container"
+	CreatedContainerEvent   = "created-container"
+	ProvisionStartEvent     = "provision-start"
+	ProvisionDoneEvent      = "provision-done"
+	VerifiedBlobEvent       = "verified-blob"
+	CreatedBlobEvent        = "created-blob"
+	FailedEvent             = "failed"
+	ErrorEvent              = "error"
+	FlushStartEvent         = "flush-start"
+	FlushDoneEvent          = "flush-done"
+	DeadLetterEvent         = "dead-letter"
+	DemandEvent             = "demand"
+	PanicEvent              = "panic"
+	ClockSkewEvent          = "clock-skew"
+	ListenersEvent          = "listeners"
+	DeadlineMissEvent       = "deadline-miss"
+	TimeoutEvent            = "timeout"
+	SummaryEvent            = "summary"
+	PreStartEnqueueEvent    = "pre-start-enqueue"
+	BatchFailedEvent        = "batch-failed"
+	LendingEvent            = "lending"
+	CooldownEvent           = "cooldown"
+	GapEvent                = "gap"
+	FactorEvent             = "factor"
+	RenewedEvent            = "renewed"
+	ContentionEvent         = "contention"
+	EnqueueErrorEvent       = "enqueue-error"
+	OperationEnqueuedEvent  = "operation-enqueued"
+	OperationBatchedEvent   = "operation-batched"
+	OperationCompletedEvent = "operation-completed"
+	OperationDroppedEvent   = "operation-dropped"
+	ConfigChangedEvent      = "config-changed"
+	ExpiredEvent            = "expired"
+	CancelledEvent          = "cancelled"
+	CoalescedEvent          = "coalesced"
+	DroppedEvent            = "dropped"
+	HighWatermarkEvent      = "high-watermark"
+	LowWatermarkEvent       = "low-watermark"
+	RecoveredEvent          = "recovered"
+	PurgedEvent             = "purged"
 )
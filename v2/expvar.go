@@ -0,0 +1,33 @@
+package batcher
+
+import "expvar"
+
+// RateLimiterStats is how a rate limiter is reported by NewExpvar().
+type RateLimiterStats struct {
+	Capacity    uint32 // the same as Capacity()
+	MaxCapacity uint32 // the same as MaxCapacity()
+}
+
+// This returns an expvar.Var that reports the Stats() of the Batcher and the capacity of each rate limiter (by the name provided) as
+// JSON whenever it is read. It does not publish itself, so you would typically call `expvar.Publish("batcher", NewExpvar(...))` once
+// and view it at /debug/vars. This is meant for lightweight debugging when you do not have a metrics stack.
+func NewExpvar(batcher Batcher, limiters map[string]RateLimiter) expvar.Var {
+	return expvar.Func(func() interface{} {
+		out := struct {
+			Batcher      BatcherStats
+			RateLimiters map[string]RateLimiterStats `json:",omitempty"`
+		}{
+			Batcher: batcher.Stats(),
+		}
+		if len(limiters) > 0 {
+			out.RateLimiters = make(map[string]RateLimiterStats, len(limiters))
+			for name, rl := range limiters {
+				out.RateLimiters[name] = RateLimiterStats{
+					Capacity:    rl.Capacity(),
+					MaxCapacity: rl.MaxCapacity(),
+				}
+			}
+		}
+		return out
+	})
+}
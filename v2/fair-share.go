@@ -0,0 +1,124 @@
+package batcher
+
+// A fairShare divides the capacity a flush has left in each rate limiter among the Watchers whose Operations are waiting for it, in
+// proportion to their weights (see Watcher.WithWeight()). A Watcher that needs less than its share gets what it needs and the rest is
+// divided among the others. Rate limiters with enough capacity for everything that is waiting are not divided. Like flushBudget, a
+// Watcher has spent its share once it has consumed at least that much, so the last Operation may go over.
+type fairShare struct {
+	budget    *flushBudget
+	allowance map[RateLimiter]map[Watcher]uint32
+	consumed  map[RateLimiter]map[Watcher]uint32
+}
+
+// This divides the capacity left in the budget among the Operations in the buffer. Operations are not counted if skip returns TRUE for
+// their Watcher.
+func newFairShare(budget *flushBudget, buffer ibuffer, limiterFor func(Watcher) RateLimiter, skip func(Watcher) bool) *fairShare {
+	demand := make(map[RateLimiter]map[Watcher]uint32)
+	buffer.each(func(op Operation) {
+		watcher := op.Watcher()
+		rl := limiterFor(watcher)
+		if rl == nil || skip(watcher) {
+			return
+		}
+		for _, part := range limiterParts(rl) {
+			if demand[part] == nil {
+				demand[part] = make(map[Watcher]uint32)
+			}
+			demand[part][watcher] += budget.cost(part, op)
+		}
+	})
+	s := &fairShare{
+		budget:    budget,
+		allowance: make(map[RateLimiter]map[Watcher]uint32),
+		consumed:  make(map[RateLimiter]map[Watcher]uint32),
+	}
+	for part, watchers := range demand {
+		budget.add(part)
+		var available uint32
+		if budget.consumed[part] < budget.capacity[part] {
+			available = budget.capacity[part] - budget.consumed[part]
+		}
+		var total uint64
+		for _, cost := range watchers {
+			total += uint64(cost)
+		}
+		if total > uint64(available) {
+			s.allowance[part] = divide(available, watchers)
+			s.consumed[part] = make(map[Watcher]uint32)
+		}
+	}
+	return s
+}
+
+// This divides the available capacity among the Watchers in proportion to their weights such that no Watcher is allowed more than it
+// needs. Every Watcher is allowed at least 1 so that it can always dispatch an Operation.
+func divide(available uint32, demand map[Watcher]uint32) map[Watcher]uint32 {
+	allowance := make(map[Watcher]uint32, len(demand))
+	active := make(map[Watcher]uint32, len(demand))
+	for watcher, cost := range demand {
+		active[watcher] = cost
+	}
+	remaining := uint64(available)
+	for len(active) > 0 {
+		var totalWeight uint64
+		for watcher := range active {
+			totalWeight += uint64(weightOf(watcher))
+		}
+
+		// any watcher that needs less than its share gets what it needs and the rest is divided again
+		var satisfied []Watcher
+		for watcher, cost := range active {
+			if uint64(cost) <= remaining*uint64(weightOf(watcher))/totalWeight {
+				satisfied = append(satisfied, watcher)
+			}
+		}
+		if len(satisfied) == 0 {
+			for watcher := range active {
+				share := remaining * uint64(weightOf(watcher)) / totalWeight
+				if share < 1 {
+					share = 1
+				}
+				allowance[watcher] = uint32(share)
+			}
+			break
+		}
+		for _, watcher := range satisfied {
+			allowance[watcher] = active[watcher]
+			remaining -= uint64(active[watcher])
+			delete(active, watcher)
+		}
+	}
+	return allowance
+}
+
+// A Watcher without a weight has a weight of 1.
+func weightOf(watcher Watcher) uint32 {
+	if weight := watcher.Weight(); weight > 0 {
+		return weight
+	}
+	return 1
+}
+
+// This is TRUE if the Watcher has consumed its share of the rate limiter (or any of its parts).
+func (s *fairShare) spent(rl RateLimiter, watcher Watcher) bool {
+	if rl == nil {
+		return false
+	}
+	for _, part := range limiterParts(rl) {
+		if allowance, ok := s.allowance[part][watcher]; ok && s.consumed[part][watcher] >= allowance {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *fairShare) consume(rl RateLimiter, watcher Watcher, op Operation) {
+	if rl == nil {
+		return
+	}
+	for _, part := range limiterParts(rl) {
+		if consumed, ok := s.consumed[part]; ok {
+			consumed[watcher] += s.budget.cost(part, op)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package batcher
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFairShare_DivideRedistributesWhatIsNotNeeded(t *testing.T) {
+	small := NewWatcher(func(batch []Operation) {})
+	large := NewWatcher(func(batch []Operation) {})
+	heavy := NewWatcher(func(batch []Operation) {}).WithWeight(2)
+	allowance := divide(100, map[Watcher]uint32{small: 10, large: 100, heavy: 100})
+	assert.Equal(t, uint32(10), allowance[small], "expecting a watcher that needs less than its share to get what it needs")
+	assert.Equal(t, uint32(30), allowance[large], "expecting the rest to be divided by weight")
+	assert.Equal(t, uint32(60), allowance[heavy], "expecting the rest to be divided by weight")
+}
+
+func TestFairShare_DivideAllowsEveryWatcherSomething(t *testing.T) {
+	light := NewWatcher(func(batch []Operation) {})
+	heavy := NewWatcher(func(batch []Operation) {}).WithWeight(1000)
+	allowance := divide(10, map[Watcher]uint32{light: 50, heavy: 50})
+	assert.Equal(t, uint32(1), allowance[light], "expecting a watcher to always be allowed to dispatch an operation")
+	assert.Equal(t, uint32(9), allowance[heavy])
+}
@@ -0,0 +1,150 @@
+package batcher
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+type fileBufferStore struct {
+	path  string
+	mutex sync.Mutex
+	file  *os.File
+}
+
+// Each line of the file is a JSON object that either saves an Operation or deletes one by ID.
+type fileBufferStoreEntry struct {
+	Save   *StoredOperation `json:"save,omitempty"`
+	Delete string           `json:"delete,omitempty"`
+}
+
+// This method creates a new BufferStore that appends each saved and deleted Operation to the file at path (which is created if it does
+// not exist). When the Operations are loaded, the file is rewritten with only the Operations that have not been deleted so that it does
+// not keep growing. Writes are not synced to the disk, so the Operations survive the process crashing but may not survive the host
+// crashing. Only one Batcher should use the file at a time.
+func NewFileBufferStore(path string) BufferStore {
+	return &fileBufferStore{
+		path: path,
+	}
+}
+
+func (s *fileBufferStore) Save(op StoredOperation) error {
+	return s.append(fileBufferStoreEntry{Save: &op})
+}
+
+func (s *fileBufferStore) Delete(id string) error {
+	return s.append(fileBufferStoreEntry{Delete: id})
+}
+
+// This returns the Operations that were saved and not deleted (in the order they were saved) and then compacts the file. A line that
+// cannot be parsed (for instance, because the process crashed while writing it) is ignored.
+func (s *fileBufferStore) Load() ([]StoredOperation, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// read every entry
+	var ops []StoredOperation
+	index := make(map[string]int)
+	file, err := os.Open(s.path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		// nothing has been saved
+	case err != nil:
+		return nil, err
+	default:
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 64<<20)
+		for scanner.Scan() {
+			var entry fileBufferStoreEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				continue
+			}
+			switch {
+			case entry.Save != nil:
+				index[entry.Save.ID] = len(ops)
+				ops = append(ops, *entry.Save)
+			case entry.Delete != "":
+				if i, ok := index[entry.Delete]; ok {
+					ops[i].ID = ""
+					delete(index, entry.Delete)
+				}
+			}
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	remaining := make([]StoredOperation, 0, len(index))
+	for _, op := range ops {
+		if op.ID != "" {
+			remaining = append(remaining, op)
+		}
+	}
+
+	// rewrite the file with only the remaining entries
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+	tmp := s.path + ".tmp"
+	compacted, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	writer := bufio.NewWriter(compacted)
+	for i := range remaining {
+		if err = writeFileBufferStoreEntry(writer, fileBufferStoreEntry{Save: &remaining[i]}); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if cerr := compacted.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, s.path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return nil, err
+	}
+	return remaining, nil
+}
+
+func (s *fileBufferStore) append(entry fileBufferStoreEntry) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.file == nil {
+		file, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0600)
+		if err != nil {
+			return err
+		}
+
+		// finish a partial line left by a crash so that it does not swallow the next entry
+		if info, err := file.Stat(); err == nil && info.Size() > 0 {
+			last := make([]byte, 1)
+			if _, err := file.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+				_, _ = file.Write([]byte{'\n'})
+			}
+		}
+		s.file = file
+	}
+	return writeFileBufferStoreEntry(s.file, entry)
+}
+
+// This writes the entry as a single line so that a crash can at most leave a partial last line.
+func writeFileBufferStoreEntry(w io.Writer, entry fileBufferStoreEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(line, '\n'))
+	return err
+}
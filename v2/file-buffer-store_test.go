@@ -0,0 +1,62 @@
+package batcher_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileBufferStore_LoadReturnsOperationsThatWereNotDeleted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buffer")
+	store := gobatcher.NewFileBufferStore(path)
+	ops, err := store.Load()
+	assert.NoError(t, err, "expecting a missing file to be empty")
+	assert.Empty(t, ops)
+	for _, id := range []string{"a", "b", "c"} {
+		err := store.Save(gobatcher.StoredOperation{ID: id, Watcher: "writer", Payload: []byte(id)})
+		assert.NoError(t, err, "not expecting a save error")
+	}
+	assert.NoError(t, store.Delete("b"))
+
+	// a crash while writing leaves a partial line
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	assert.NoError(t, err)
+	_, err = file.WriteString(`{"save":{"id":"d"`)
+	assert.NoError(t, err)
+	file.Close()
+
+	reopened := gobatcher.NewFileBufferStore(path)
+	assert.NoError(t, reopened.Save(gobatcher.StoredOperation{ID: "e", Watcher: "writer"}))
+	ops, err = reopened.Load()
+	assert.NoError(t, err, "not expecting a load error")
+	var ids []string
+	for _, op := range ops {
+		ids = append(ids, op.ID)
+	}
+	assert.Equal(t, []string{"a", "c", "e"}, ids, "expecting the remaining operations in the order they were saved")
+	assert.Equal(t, []byte("a"), ops[0].Payload)
+
+	// the file only contains the remaining operations once they are loaded
+	assert.NoError(t, reopened.Delete("a"))
+	ops, err = gobatcher.NewFileBufferStore(path).Load()
+	assert.NoError(t, err, "not expecting a load error")
+	assert.Len(t, ops, 2)
+	raw, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(splitLines(raw)), "expecting the file to be compacted")
+}
+
+func splitLines(raw []byte) [][]byte {
+	var lines [][]byte
+	start := 0
+	for i, b := range raw {
+		if b == '\n' {
+			lines = append(lines, raw[start:i])
+			start = i + 1
+		}
+	}
+	return lines
+}
@@ -0,0 +1,233 @@
+package batcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// If a process crashes while holding the lock file of a partition, the lock file is considered stale after this long and removed.
+const fileLockStaleAfter = 10 * time.Second
+
+type fileLeaseManager struct {
+
+	// configuration items that should not change after Provision()
+	eventer Eventer
+	dir     string
+}
+
+// This method creates a new FileLeaseManager to allow the SharedResource to use files in a directory to manage leases across processes
+// on the same host (or on hosts that share the directory, such as an NFS volume). Each partition is a file named "partition-<index>"
+// that contains the ID of the instance holding the lease and when it expires. The file is only changed while holding a lock file
+// ("partition-<index>.lock") that is created exclusively, so you should use a directory that is unique to the capacity being shared.
+func NewFileLeaseManager(dir string) LeaseManager {
+	return &fileLeaseManager{
+		dir: dir,
+	}
+}
+
+// Events raised by FileLeaseManager must be raised to an Eventer. Specifically the SharedResource it is associated with will be used as
+// the Eventer. This method is called in SharedResource.WithSharedCapacity().
+func (m *fileLeaseManager) RaiseEventsTo(e Eventer) {
+	m.eventer = e
+}
+
+// This is called by SharedResource to create the directory if it does not exist. Any error returned is a *LeaseError.
+func (m *fileLeaseManager) Provision(ctx context.Context) error {
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return newLeaseError(LeaseOperationProvision, -1, err)
+	}
+	m.eventer.Emit(VerifiedContainerEvent, 0, m.dir, nil)
+	return nil
+}
+
+// This is called by SharedResource when the partition files should be created or verified.
+func (m *fileLeaseManager) CreatePartitions(ctx context.Context, count int) {
+	for i := 0; i < count; i++ {
+		file, err := os.OpenFile(m.partitionPath(uint32(i)), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		switch {
+		case errors.Is(err, os.ErrExist):
+			m.eventer.Emit(VerifiedBlobEvent, i, "", nil)
+		case err != nil:
+			m.eventer.Emit(ErrorEvent, 0, "creating partitions raised an error", newLeaseError(LeaseOperationCreatePartition, i, err))
+		default:
+			file.Close()
+			m.eventer.Emit(CreatedBlobEvent, i, "", nil)
+		}
+	}
+}
+
+// This is called by SharedResource when it needs to lease partitions for capacity. The partition can be leased if it has no holder, its
+// lease has expired, or it is already held by this instance (in which case it is renewed).
+func (m *fileLeaseManager) LeasePartition(ctx context.Context, id string, index uint32) (leaseTime time.Duration) {
+	secondsToLease := 15
+	path := m.partitionPath(index)
+
+	// lock the partition; if another process holds the lock, try again in a bit
+	lock := path + ".lock"
+	token, err := acquireFileLock(lock)
+	if errors.Is(err, os.ErrExist) {
+		breakStaleFileLock(lock)
+		m.eventer.Emit(FailedEvent, int(index), "", nil)
+		return
+	} else if err != nil {
+		m.raiseLeaseError(index, err)
+		return
+	}
+	defer releaseFileLock(lock, token)
+
+	// you cannot allocate a lease that is held by another instance; try again in a bit
+	now := time.Now()
+	raw, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		m.raiseLeaseError(index, err)
+		return
+	}
+	if holder, expiry, ok := parseFileLease(string(raw)); ok && holder != id && now.Before(expiry) {
+		m.eventer.Emit(FailedEvent, int(index), "", nil)
+		return
+	}
+
+	// write the lease to a temporary file and rename it so the partition file is never partially written
+	expiry := now.Add(time.Duration(secondsToLease) * time.Second)
+	tmp := path + ".tmp"
+	content := fmt.Sprintf("%s\n%s\n", id, expiry.UTC().Format(time.RFC3339Nano))
+	if err := os.WriteFile(tmp, []byte(content), 0644); err != nil {
+		m.raiseLeaseError(index, err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		m.raiseLeaseError(index, err)
+		return
+	}
+
+	// return the lease time
+	leaseTime = time.Duration(secondsToLease) * time.Second
+
+	return
+}
+
+// This is called by SharedResource to renew the lease on a partition it still needs. Since LeasePartition() renews a partition that is
+// already held by the same instance, this is the same as leasing it again.
+func (m *fileLeaseManager) RenewPartition(ctx context.Context, id string, index uint32) time.Duration {
+	return m.LeasePartition(ctx, id, index)
+}
+
+// This is called by SharedResource to release the lease on a partition it no longer needs so that another instance can lease it without
+// waiting for the lease to expire. The partition file is only emptied if the lease is still held by this instance; if another process
+// holds the lock file, the lease is left to expire.
+func (m *fileLeaseManager) ReleasePartition(ctx context.Context, id string, index uint32) {
+	path := m.partitionPath(index)
+
+	// lock the partition
+	lock := path + ".lock"
+	token, err := acquireFileLock(lock)
+	if errors.Is(err, os.ErrExist) {
+		return
+	} else if err != nil {
+		m.raiseReleaseError(index, err)
+		return
+	}
+	defer releaseFileLock(lock, token)
+
+	// empty the partition file if this instance holds the lease
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			m.raiseReleaseError(index, err)
+		}
+		return
+	}
+	if holder, _, ok := parseFileLease(string(raw)); !ok || holder != id {
+		return
+	}
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		m.raiseReleaseError(index, err)
+	}
+}
+
+func (m *fileLeaseManager) raiseLeaseError(index uint32, err error) {
+	lerr := newLeaseError(LeaseOperationAcquireLease, int(index), err)
+	m.eventer.Emit(ErrorEvent, 0, lerr.Error(), lerr)
+}
+
+func (m *fileLeaseManager) raiseReleaseError(index uint32, err error) {
+	lerr := newLeaseError(LeaseOperationReleaseLease, int(index), err)
+	m.eventer.Emit(ErrorEvent, 0, lerr.Error(), lerr)
+}
+
+func (m *fileLeaseManager) partitionPath(index uint32) string {
+	return filepath.Join(m.dir, fmt.Sprintf("partition-%d", index))
+}
+
+// A lock file contains a token that is unique to the process that created it. The token is written to a temporary file that is then
+// linked to the lock file (which fails if the lock file exists) so the lock file is never seen without its token.
+func acquireFileLock(lock string) (token string, err error) {
+	token = uuid.New().String()
+	tmp := lock + "." + token
+	if err = os.WriteFile(tmp, []byte(token), 0644); err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp)
+	if err = os.Link(tmp, lock); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// The lock file is only removed if it still has the token, so a lock that was broken as stale (and then taken by another process) is
+// left alone.
+func releaseFileLock(lock, token string) {
+	removeFileLockIf(lock, func(content []byte, info os.FileInfo) bool {
+		return string(content) == token
+	})
+}
+
+// If a process crashed while holding the lock file, the lock file is removed once it is stale. Another process may have broken the same
+// stale lock and taken a new one since it was checked, so the lock file is only removed if it is still the stale one once moved aside.
+func breakStaleFileLock(lock string) {
+	stale, err := os.Stat(lock)
+	if err != nil || time.Since(stale.ModTime()) <= fileLockStaleAfter {
+		return
+	}
+	removeFileLockIf(lock, func(content []byte, info os.FileInfo) bool {
+		return os.SameFile(stale, info)
+	})
+}
+
+// This atomically moves the lock file to a unique name (so no other process can change or remove it while it is checked) and removes
+// it if it matches; otherwise, the lock file is put back. It returns true if the lock file was removed.
+func removeFileLockIf(lock string, matches func(content []byte, info os.FileInfo) bool) bool {
+	moved := lock + "." + uuid.New().String() + ".broken"
+	if err := os.Rename(lock, moved); err != nil {
+		return false
+	}
+	defer os.Remove(moved)
+	content, rerr := os.ReadFile(moved)
+	info, serr := os.Stat(moved)
+	if rerr == nil && serr == nil && matches(content, info) {
+		return true
+	}
+	_ = os.Link(moved, lock)
+	return false
+}
+
+// A partition file contains the holder on the first line and the expiry (RFC3339) on the second. An empty or unreadable file has no
+// holder.
+func parseFileLease(content string) (holder string, expiry time.Time, ok bool) {
+	lines := strings.Split(strings.TrimSpace(content), "\n")
+	if len(lines) != 2 {
+		return
+	}
+	expiry, err := time.Parse(time.RFC3339Nano, lines[1])
+	if err != nil {
+		return
+	}
+	return lines[0], expiry, true
+}
@@ -0,0 +1,115 @@
+package batcher_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func holderOf(t *testing.T, path string) string {
+	raw, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	return strings.Split(string(raw), "\n")[0]
+}
+
+func TestFileLeaseManager_LeasePartition_OnlyOneInstanceHoldsTheLease(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "capacity")
+	mgr := gobatcher.NewFileLeaseManager(dir)
+	eventer := &gobatcher.EventerBase{}
+	var created, failed []int
+	eventer.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		switch event {
+		case gobatcher.CreatedBlobEvent:
+			created = append(created, val)
+		case gobatcher.FailedEvent:
+			failed = append(failed, val)
+		}
+	})
+	mgr.RaiseEventsTo(eventer)
+	assert.NoError(t, mgr.Provision(context.Background()))
+	mgr.CreatePartitions(context.Background(), 4)
+	assert.Equal(t, []int{0, 1, 2, 3}, created, "expecting a file to be created for each partition")
+
+	path := filepath.Join(dir, "partition-3")
+	assert.Equal(t, 15*time.Second, mgr.LeasePartition(context.Background(), "instance-a", 3), "expecting the first instance to get the lease")
+	assert.Equal(t, "instance-a", holderOf(t, path))
+	assert.Equal(t, time.Duration(0), mgr.LeasePartition(context.Background(), "instance-b", 3), "expecting the lease to already be held")
+	assert.Equal(t, []int{3}, failed, "expecting a failed event for the partition")
+	assert.Equal(t, 15*time.Second, mgr.LeasePartition(context.Background(), "instance-a", 3), "expecting the holder to be able to renew")
+	expired := "instance-a\n" + time.Now().Add(-1*time.Second).UTC().Format(time.RFC3339Nano) + "\n"
+	assert.NoError(t, os.WriteFile(path, []byte(expired), 0644))
+	assert.Equal(t, 15*time.Second, mgr.LeasePartition(context.Background(), "instance-b", 3), "expecting the lease once it expired")
+	assert.Equal(t, "instance-b", holderOf(t, path))
+}
+
+func TestFileLeaseManager_LeasePartition_WaitsForTheLockFile(t *testing.T) {
+	dir := t.TempDir()
+	mgr := gobatcher.NewFileLeaseManager(dir)
+	mgr.RaiseEventsTo(&gobatcher.EventerBase{})
+	assert.NoError(t, mgr.Provision(context.Background()))
+	lock := filepath.Join(dir, "partition-1.lock")
+	assert.NoError(t, os.WriteFile(lock, nil, 0644))
+	assert.Equal(t, time.Duration(0), mgr.LeasePartition(context.Background(), "instance-a", 1), "expecting no lease while another process holds the lock")
+	stale := time.Now().Add(-1 * time.Minute)
+	assert.NoError(t, os.Chtimes(lock, stale, stale))
+	assert.Equal(t, time.Duration(0), mgr.LeasePartition(context.Background(), "instance-a", 1), "expecting the stale lock to be removed")
+	assert.Equal(t, 15*time.Second, mgr.LeasePartition(context.Background(), "instance-a", 1), "expecting the lease once the stale lock was removed")
+}
+
+func TestFileLeaseManager_LeasePartition_CompetingManagersBreakTheStaleLockOnce(t *testing.T) {
+	dir := t.TempDir()
+	lock := filepath.Join(dir, "partition-0.lock")
+	assert.NoError(t, os.WriteFile(lock, nil, 0644))
+	stale := time.Now().Add(-1 * time.Minute)
+	assert.NoError(t, os.Chtimes(lock, stale, stale))
+	var mutex sync.Mutex
+	leased := make(map[string]int)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		mgr := gobatcher.NewFileLeaseManager(dir)
+		mgr.RaiseEventsTo(&gobatcher.EventerBase{})
+		assert.NoError(t, mgr.Provision(context.Background()))
+		id := fmt.Sprintf("instance-%v", i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				if mgr.LeasePartition(context.Background(), id, 0) > 0 {
+					mutex.Lock()
+					leased[id]++
+					mutex.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, leased, 1, "expecting only one manager to ever hold the lease")
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	for _, entry := range entries {
+		assert.Equal(t, "partition-0", entry.Name(), "expecting every lock file to be removed")
+	}
+}
+
+func TestFileLeaseManager_ReleasePartition_OnlyReleasesItsOwnLease(t *testing.T) {
+	dir := t.TempDir()
+	mgr := gobatcher.NewFileLeaseManager(dir)
+	mgr.RaiseEventsTo(&gobatcher.EventerBase{})
+	assert.NoError(t, mgr.Provision(context.Background()))
+	releaser := mgr.(gobatcher.LeaseReleaser)
+	path := filepath.Join(dir, "partition-0")
+	assert.Equal(t, 15*time.Second, mgr.LeasePartition(context.Background(), "instance-a", 0))
+	releaser.ReleasePartition(context.Background(), "instance-b", 0)
+	assert.Equal(t, "instance-a", holderOf(t, path), "expecting another instance to not release the lease")
+	releaser.ReleasePartition(context.Background(), "instance-a", 0)
+	assert.Equal(t, "", holderOf(t, path), "expecting the lease to be released")
+	assert.Equal(t, 15*time.Second, mgr.LeasePartition(context.Background(), "instance-b", 0), "expecting another instance to lease it right away")
+}
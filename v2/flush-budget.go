@@ -0,0 +1,116 @@
+package batcher
+
+import "time"
+
+// A flushBudget tracks the capacity a single flush may consume from each rate limiter. Operations for Watchers without a rate limiter
+// (the nil rate limiter) are counted towards the total but are never limited. A composite rate limiter is limited by each of its parts,
+// which are charged the cost of each Operation in their own dimension (see DimensionalRateLimiter). The costs charged are multiplied by
+// the scale (see Batcher.ReportActualCost()), but the total is not.
+type flushBudget struct {
+	interval time.Duration
+	scale    float64
+	limits   map[RateLimiter]uint32
+	capacity map[RateLimiter]uint32
+	consumed map[RateLimiter]uint32
+	total    uint32
+}
+
+// This creates a budget for the flush with the capacity of each rate limiter for the interval.
+func newFlushBudget(interval time.Duration, scale float64, limiters ...RateLimiter) *flushBudget {
+	b := &flushBudget{
+		interval: interval,
+		scale:    scale,
+		limits:   make(map[RateLimiter]uint32),
+		capacity: make(map[RateLimiter]uint32),
+		consumed: make(map[RateLimiter]uint32),
+	}
+	for _, rl := range limiters {
+		b.add(rl)
+	}
+	return b
+}
+
+func (b *flushBudget) add(rl RateLimiter) {
+	if _, ok := b.limits[rl]; ok || rl == nil {
+		return
+	}
+	b.limits[rl] = b.forInterval(rl.Capacity())
+	for _, part := range limiterParts(rl) {
+		if _, ok := b.capacity[part]; !ok {
+			b.capacity[part] = b.forInterval(part.Capacity())
+		}
+	}
+}
+
+func (b *flushBudget) forInterval(capacity uint32) uint32 {
+	return uint32(float64(capacity) / 1000.0 * float64(b.interval.Milliseconds()))
+}
+
+// This returns the sum of the capacity of every rate limiter.
+func (b *flushBudget) totalCapacity() uint32 {
+	var total uint32
+	for _, capacity := range b.limits {
+		total += capacity
+	}
+	return total
+}
+
+// This is TRUE if the flush has consumed all the capacity of the rate limiter (or any of its parts).
+func (b *flushBudget) spent(rl RateLimiter) bool {
+	if rl == nil {
+		return false
+	}
+	b.add(rl)
+	for _, part := range limiterParts(rl) {
+		if b.consumed[part] >= b.capacity[part] {
+			return true
+		}
+	}
+	return false
+}
+
+// This is TRUE if the flush has consumed all the capacity of every rate limiter.
+func (b *flushBudget) allSpent() bool {
+	for rl := range b.limits {
+		if !b.spent(rl) {
+			return false
+		}
+	}
+	return true
+}
+
+// This returns the part of the rate limiter that has no capacity for the flush and what the Operation costs in it, or nil if every
+// part has some capacity.
+func (b *flushBudget) starved(rl RateLimiter, op Operation) (RateLimiter, uint32) {
+	if rl == nil {
+		return nil, 0
+	}
+	for _, part := range limiterParts(rl) {
+		if cost := b.cost(part, op); cost > 0 && b.capacity[part] == 0 {
+			return part, cost
+		}
+	}
+	return nil, 0
+}
+
+func (b *flushBudget) consume(rl RateLimiter, op Operation) {
+	if rl != nil {
+		for _, part := range limiterParts(rl) {
+			b.consumed[part] += b.cost(part, op)
+		}
+	}
+	b.total += op.Cost()
+}
+
+func (b *flushBudget) refund(rl RateLimiter, op Operation) {
+	if rl != nil {
+		for _, part := range limiterParts(rl) {
+			b.consumed[part] -= b.cost(part, op)
+		}
+	}
+	b.total -= op.Cost()
+}
+
+func (b *flushBudget) cost(rl RateLimiter, op Operation) uint32 {
+	return scaleCost(costFor(rl, op), b.scale)
+}
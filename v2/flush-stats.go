@@ -0,0 +1,53 @@
+package batcher
+
+import "time"
+
+// BatcherStats is a snapshot of the Batcher returned by Stats().
+type BatcherStats struct {
+	OperationsInBuffer uint32        // the number of Operations waiting in the buffer
+	EstimatedMemory    uint64        // the estimated bytes held by the Operations in the buffer (their size hints plus their overhead)
+	Overflowed         uint32        // the number of Operations waiting in the overflow file to be replayed (see WithOverflowFile)
+	NeedsCapacity      uint32        // the capacity needed to process everything that is in the buffer and inflight (the target)
+	Inflight           uint32        // the number of batches holding a slot provided by WithMaxConcurrentBatches
+	Running            uint32        // the number of batches the Watchers have not finished with
+	Enqueued           uint64        // the number of Operations added to the buffer since the Batcher was created
+	Batches            uint64        // the number of batches dispatched since the Batcher was created
+	Operations         uint64        // the number of Operations in batches that finished (or timed out) and were not requeued
+	Failed             uint64        // the number of those Operations that failed or were abandoned (including any that will be retried)
+	AuditFailures      uint64        // the number of audits that failed
+	Expired            uint64        // the number of Operations that expired in the buffer (see Operation.WithExpiry)
+	Cancelled          uint64        // the number of Operations that were removed from the buffer by Cancel()
+	Coalesced          uint64        // the number of Operations that were merged into an Operation in the buffer (see WithDeduplication)
+	Dropped            uint64        // the number of Operations that were dropped because the buffer was full (see WithFullBufferPolicy)
+	Purged             uint64        // the number of Operations that were removed from the buffer by Purge()
+	AverageBatchSize   float64       // the average number of Operations in the recent batches (up to 256)
+	P95BatchSize       uint32        // the 95th percentile of the number of Operations in the recent batches (up to 256)
+	FlushLatency       time.Duration // how long the last flush took
+	Uptime             time.Duration // the time since Start() (0 if it has not been started)
+}
+
+// FlushStats describes what a single flush dispatched. It is the metadata of the flush-done event.
+type FlushStats struct {
+	Operations         int    // the number of Operations dispatched in batches
+	Capacity           uint32 // the capacity available to the flush (0 if there is no rate limiter)
+	Consumed           uint32 // the cost of the Operations dispatched
+	ZeroCostOperations int    // the number of dispatched Operations with a cost of 0
+	ZeroCostLimit      uint32 // the number of zero-cost Operations the flush was allowed (0 if ZeroCostOpsPerSecond is not set)
+	DeadlineMisses     int    // the number of dispatched Operations whose deadline had already passed
+	Expired            int    // the number of Operations that expired in the buffer and were removed
+}
+
+// This returns the fraction of the flush's allowance that was used. If ZeroCostOpsPerSecond is set, zero-cost Operations are
+// included, so the result is the greater of the capacity and zero-cost utilization.
+func (s FlushStats) Utilization() float64 {
+	var utilization float64
+	if s.Capacity > 0 {
+		utilization = float64(s.Consumed) / float64(s.Capacity)
+	}
+	if s.ZeroCostLimit > 0 {
+		if zero := float64(s.ZeroCostOperations) / float64(s.ZeroCostLimit); zero > utilization {
+			utilization = zero
+		}
+	}
+	return utilization
+}
@@ -0,0 +1,37 @@
+package batcher
+
+// FullBufferPolicy determines what Enqueue() does when the buffer is full (see Batcher.WithFullBufferPolicy()).
+type FullBufferPolicy int
+
+const (
+	// Enqueue() blocks until there is room in the buffer. This is the default.
+	BlockWhenFull FullBufferPolicy = iota
+	// Enqueue() returns BufferFullError (the same as Batcher.WithErrorOnFullBuffer()).
+	ErrorWhenFull
+	// The Operation being enqueued is dropped.
+	DropNewest
+	// The Operation at the head of the buffer is dropped to make room.
+	DropOldest
+	// The Operation with the lowest priority (see Operation.WithPriority()) is dropped to make room.
+	DropLowestPriority
+)
+
+func (p FullBufferPolicy) String() string {
+	switch p {
+	case ErrorWhenFull:
+		return "error"
+	case DropNewest:
+		return "drop-newest"
+	case DropOldest:
+		return "drop-oldest"
+	case DropLowestPriority:
+		return "drop-lowest-priority"
+	default:
+		return "block"
+	}
+}
+
+// This is TRUE if the policy drops an Operation rather than blocking or returning an error.
+func (p FullBufferPolicy) drops() bool {
+	return p == DropNewest || p == DropOldest || p == DropLowestPriority
+}
@@ -0,0 +1,404 @@
+package batcher
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	kubernetesTokenFile  = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	kubernetesCAFile     = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+	kubernetesMicroTime  = "2006-01-02T15:04:05.000000Z07:00"
+	kubernetesLeaseGroup = "coordination.k8s.io/v1"
+)
+
+type kubernetesLeaseManager struct {
+
+	// configuration items that should not change after Provision()
+	eventer     Eventer
+	endpoint    *string
+	tokenFile   string
+	namespace   string
+	leasePrefix string
+
+	// internal properties
+	client     *http.Client
+	tokenMutex sync.Mutex
+	token      string // re-read from the tokenFile (if any) for every request
+}
+
+type kubernetesLease struct {
+	APIVersion string               `json:"apiVersion"`
+	Kind       string               `json:"kind"`
+	Metadata   kubernetesObjectMeta `json:"metadata"`
+	Spec       kubernetesLeaseSpec  `json:"spec"`
+}
+
+type kubernetesObjectMeta struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace,omitempty"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+type kubernetesLeaseSpec struct {
+	HolderIdentity       *string `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds *int32  `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          *string `json:"acquireTime,omitempty"`
+	RenewTime            *string `json:"renewTime,omitempty"`
+	LeaseTransitions     *int32  `json:"leaseTransitions,omitempty"`
+}
+
+// This method creates a new KubernetesLeaseManager to allow the SharedResource to use coordination.k8s.io/v1 Lease objects to manage
+// leases across pods. It uses the service account of the pod (so it must run in the cluster) and the Lease objects are named
+// "<leasePrefix>-<index>" in the provided namespace. The service account needs permission to get, create, and update leases. The
+// service account token is read again for every request, since projected tokens are rotated by the kubelet.
+func NewKubernetesLeaseManager(namespace, leasePrefix string) LeaseManager {
+	return &kubernetesLeaseManager{
+		namespace:   namespace,
+		leasePrefix: leasePrefix,
+	}
+}
+
+// This method creates a new KubernetesLeaseManager that talks to the Kubernetes API at a custom endpoint with a bearer token rather
+// than using the service account of the pod. This is useful outside of the cluster (for instance, with `kubectl proxy`, in which case
+// the token can be empty).
+func NewKubernetesLeaseManagerWithEndpoint(endpoint, token, namespace, leasePrefix string) LeaseManager {
+	return &kubernetesLeaseManager{
+		endpoint:    &endpoint,
+		token:       token,
+		namespace:   namespace,
+		leasePrefix: leasePrefix,
+	}
+}
+
+// This method creates a new KubernetesLeaseManager that talks to the Kubernetes API at a custom endpoint with a bearer token that is
+// read from the provided file (for instance, a projected service account token) for every request, so a rotated token is used as soon
+// as it is written. If the file cannot be read, the last token that was read is used.
+func NewKubernetesLeaseManagerWithTokenFile(endpoint, tokenFile, namespace, leasePrefix string) LeaseManager {
+	return &kubernetesLeaseManager{
+		endpoint:    &endpoint,
+		tokenFile:   tokenFile,
+		namespace:   namespace,
+		leasePrefix: leasePrefix,
+	}
+}
+
+// Events raised by KubernetesLeaseManager must be raised to an Eventer. Specifically the SharedResource it is associated with will be
+// used as the Eventer. This method is called in SharedResource.WithSharedCapacity().
+func (m *kubernetesLeaseManager) RaiseEventsTo(e Eventer) {
+	m.eventer = e
+}
+
+// This is called by SharedResource to configure the client and verify that leases can be read in the namespace. Any error returned
+// is a *LeaseError.
+func (m *kubernetesLeaseManager) Provision(ctx context.Context) (err error) {
+	defer func() {
+		if err != nil {
+			err = newLeaseError(LeaseOperationProvision, -1, err)
+		}
+	}()
+
+	// use the service account of the pod unless an endpoint was provided
+	if m.endpoint == nil {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return errors.New("KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT must be set when running in the cluster")
+		}
+		endpoint := "https://" + net.JoinHostPort(host, port)
+		m.endpoint = &endpoint
+		m.tokenFile = kubernetesTokenFile
+		var ca []byte
+		if ca, err = os.ReadFile(kubernetesCAFile); err != nil {
+			return
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return errors.New("the service account CA certificate could not be parsed")
+		}
+		m.client = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	}
+	if m.client == nil {
+		m.client = &http.Client{}
+	}
+
+	// the token must be readable now even though it is read again for every request
+	if m.tokenFile != "" {
+		var token []byte
+		if token, err = os.ReadFile(m.tokenFile); err != nil {
+			return
+		}
+		m.setToken(strings.TrimSpace(string(token)))
+	}
+
+	// verify the leases can be read
+	ref := fmt.Sprintf("%s/apis/%s/namespaces/%s/leases", strings.TrimSuffix(*m.endpoint, "/"), kubernetesLeaseGroup, m.namespace)
+	var resp *http.Response
+	resp, err = m.do(ctx, http.MethodGet, ref+"?limit=1", nil)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return kubernetesStatusError(resp)
+	}
+	m.eventer.Emit(VerifiedContainerEvent, 0, ref, nil)
+
+	return
+}
+
+// This is called by SharedResource when the Lease objects (partitions) should be created or verified.
+func (m *kubernetesLeaseManager) CreatePartitions(ctx context.Context, count int) {
+	for i := 0; i < count; i++ {
+		resp, err := m.do(ctx, http.MethodPost, m.leasesURL(), m.newLease(i))
+		if err != nil {
+			m.eventer.Emit(ErrorEvent, 0, "creating partitions raised an error", newLeaseError(LeaseOperationCreatePartition, i, err))
+			continue
+		}
+		resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusCreated, http.StatusOK:
+			m.eventer.Emit(CreatedBlobEvent, i, "", nil)
+		case http.StatusConflict:
+			m.eventer.Emit(VerifiedBlobEvent, i, "", nil)
+		default:
+			lerr := newLeaseError(LeaseOperationCreatePartition, i, kubernetesStatusError(resp))
+			lerr.setResponse(resp)
+			m.eventer.Emit(ErrorEvent, 0, "creating partitions raised an error", lerr)
+		}
+	}
+}
+
+// This is called by SharedResource when it needs to lease partitions for capacity. The partition can be leased if the Lease object has
+// no holder, its lease has expired, or it is already held by this instance (in which case it is renewed). The update is conditional on
+// the resourceVersion that was read, so if another instance updates the Lease object first, this one fails to lease it.
+func (m *kubernetesLeaseManager) LeasePartition(ctx context.Context, id string, index uint32) (leaseTime time.Duration) {
+	secondsToLease := int32(15)
+
+	// read the current lease
+	resp, err := m.do(ctx, http.MethodGet, m.leaseURL(int(index)), nil)
+	if err != nil {
+		m.raiseLeaseError(int(index), nil, err)
+		return
+	}
+	var lease kubernetesLease
+	switch resp.StatusCode {
+	case http.StatusOK:
+		err = json.NewDecoder(resp.Body).Decode(&lease)
+		resp.Body.Close()
+		if err != nil {
+			m.raiseLeaseError(int(index), nil, err)
+			return
+		}
+	case http.StatusNotFound:
+		resp.Body.Close()
+		lease = *m.newLease(int(index))
+	default:
+		resp.Body.Close()
+		m.raiseLeaseError(int(index), resp, kubernetesStatusError(resp))
+		return
+	}
+
+	// you cannot allocate a lease that is held by another instance; try again in a bit
+	now := time.Now()
+	holder := lease.Spec.HolderIdentity
+	if holder != nil && *holder != "" && *holder != id && !kubernetesLeaseExpired(lease.Spec, now) {
+		m.eventer.Emit(FailedEvent, int(index), "", nil)
+		return
+	}
+
+	// take (or renew) the lease
+	stamp := now.UTC().Format(kubernetesMicroTime)
+	if holder == nil || *holder != id {
+		transitions := int32(0)
+		if lease.Spec.LeaseTransitions != nil {
+			transitions = *lease.Spec.LeaseTransitions + 1
+		}
+		lease.Spec.HolderIdentity = &id
+		lease.Spec.AcquireTime = &stamp
+		lease.Spec.LeaseTransitions = &transitions
+	}
+	lease.Spec.LeaseDurationSeconds = &secondsToLease
+	lease.Spec.RenewTime = &stamp
+	method, ref := http.MethodPut, m.leaseURL(int(index))
+	if lease.Metadata.ResourceVersion == "" {
+		method, ref = http.MethodPost, m.leasesURL()
+	}
+	resp, err = m.do(ctx, method, ref, &lease)
+	if err != nil {
+		m.raiseLeaseError(int(index), nil, err)
+		return
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+	case http.StatusConflict:
+		// another instance updated the lease first
+		m.eventer.Emit(FailedEvent, int(index), "", nil)
+		return
+	default:
+		m.raiseLeaseError(int(index), resp, kubernetesStatusError(resp))
+		return
+	}
+
+	// return the lease time
+	leaseTime = time.Duration(secondsToLease) * time.Second
+
+	return
+}
+
+// This is called by SharedResource to renew the lease on a partition it still needs. Since LeasePartition() renews a Lease object that is
+// already held by the same instance, this is the same as leasing it again.
+func (m *kubernetesLeaseManager) RenewPartition(ctx context.Context, id string, index uint32) time.Duration {
+	return m.LeasePartition(ctx, id, index)
+}
+
+// This is called by SharedResource to release the lease on a partition it no longer needs so that another instance can lease it without
+// waiting for the lease to expire. The Lease object is only changed if it is still held by this instance.
+func (m *kubernetesLeaseManager) ReleasePartition(ctx context.Context, id string, index uint32) {
+
+	// read the current lease
+	resp, err := m.do(ctx, http.MethodGet, m.leaseURL(int(index)), nil)
+	if err != nil {
+		m.raiseReleaseError(int(index), nil, err)
+		return
+	}
+	var lease kubernetesLease
+	switch resp.StatusCode {
+	case http.StatusOK:
+		err = json.NewDecoder(resp.Body).Decode(&lease)
+		resp.Body.Close()
+		if err != nil {
+			m.raiseReleaseError(int(index), nil, err)
+			return
+		}
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return
+	default:
+		resp.Body.Close()
+		m.raiseReleaseError(int(index), resp, kubernetesStatusError(resp))
+		return
+	}
+
+	// there is nothing to release if another instance holds the lease
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != id {
+		return
+	}
+
+	// clear the holder; a conflict means another instance already took the lease
+	holder, secondsToLease := "", int32(1)
+	lease.Spec.HolderIdentity = &holder
+	lease.Spec.LeaseDurationSeconds = &secondsToLease
+	resp, err = m.do(ctx, http.MethodPut, m.leaseURL(int(index)), &lease)
+	if err != nil {
+		m.raiseReleaseError(int(index), nil, err)
+		return
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusConflict, http.StatusNotFound:
+	default:
+		m.raiseReleaseError(int(index), resp, kubernetesStatusError(resp))
+	}
+
+}
+
+func (m *kubernetesLeaseManager) raiseReleaseError(index int, resp *http.Response, err error) {
+	lerr := newLeaseError(LeaseOperationReleaseLease, index, err)
+	lerr.setResponse(resp)
+	m.eventer.Emit(ErrorEvent, 0, lerr.Error(), lerr)
+}
+
+func (m *kubernetesLeaseManager) raiseLeaseError(index int, resp *http.Response, err error) {
+	lerr := newLeaseError(LeaseOperationAcquireLease, index, err)
+	lerr.setResponse(resp)
+	m.eventer.Emit(ErrorEvent, 0, lerr.Error(), lerr)
+}
+
+func (m *kubernetesLeaseManager) leasesURL() string {
+	return fmt.Sprintf("%s/apis/%s/namespaces/%s/leases", strings.TrimSuffix(*m.endpoint, "/"), kubernetesLeaseGroup, m.namespace)
+}
+
+func (m *kubernetesLeaseManager) leaseURL(index int) string {
+	return fmt.Sprintf("%s/%s-%d", m.leasesURL(), m.leasePrefix, index)
+}
+
+func (m *kubernetesLeaseManager) newLease(index int) *kubernetesLease {
+	return &kubernetesLease{
+		APIVersion: kubernetesLeaseGroup,
+		Kind:       "Lease",
+		Metadata: kubernetesObjectMeta{
+			Name:      fmt.Sprintf("%s-%d", m.leasePrefix, index),
+			Namespace: m.namespace,
+		},
+	}
+}
+
+func (m *kubernetesLeaseManager) do(ctx context.Context, method, ref string, lease *kubernetesLease) (*http.Response, error) {
+	var body io.Reader
+	if lease != nil {
+		raw, err := json.Marshal(lease)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(raw)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, ref, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token := m.bearerToken(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return m.client.Do(req)
+}
+
+// This returns the token from the tokenFile (if there is one), which is read every time so that a rotated token is used right away.
+func (m *kubernetesLeaseManager) bearerToken() string {
+	if m.tokenFile != "" {
+		if token, err := os.ReadFile(m.tokenFile); err == nil {
+			m.setToken(strings.TrimSpace(string(token)))
+		}
+	}
+	m.tokenMutex.Lock()
+	defer m.tokenMutex.Unlock()
+	return m.token
+}
+
+func (m *kubernetesLeaseManager) setToken(token string) {
+	m.tokenMutex.Lock()
+	defer m.tokenMutex.Unlock()
+	m.token = token
+}
+
+// A lease without a renewTime or duration is treated as expired.
+func kubernetesLeaseExpired(spec kubernetesLeaseSpec, now time.Time) bool {
+	if spec.RenewTime == nil || spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	renewed, err := time.Parse(kubernetesMicroTime, *spec.RenewTime)
+	if err != nil {
+		return true
+	}
+	return !now.Before(renewed.Add(time.Duration(*spec.LeaseDurationSeconds) * time.Second))
+}
+
+func kubernetesStatusError(resp *http.Response) error {
+	return fmt.Errorf("the kubernetes api returned %s", resp.Status)
+}
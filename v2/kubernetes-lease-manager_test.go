@@ -0,0 +1,226 @@
+package batcher_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeKubernetes serves the Lease endpoints of the Kubernetes API from memory, including the resourceVersion conflict check.
+type fakeKubernetes struct {
+	mutex   sync.Mutex
+	leases  map[string]map[string]interface{}
+	version int
+	status  int
+	token   string // if set, requests must have this bearer token
+}
+
+func newFakeKubernetes() *fakeKubernetes {
+	return &fakeKubernetes{leases: make(map[string]map[string]interface{})}
+}
+
+func (k *fakeKubernetes) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if k.status != 0 {
+		w.WriteHeader(k.status)
+		return
+	}
+	if k.token != "" && r.Header.Get("Authorization") != "Bearer "+k.token {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	const prefix = "/apis/coordination.k8s.io/v1/namespaces/ns/leases"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	var body map[string]interface{}
+	if r.Body != nil {
+		_ = json.NewDecoder(r.Body).Decode(&body)
+	}
+	switch {
+	case r.Method == http.MethodGet && name == "":
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodGet:
+		lease, ok := k.leases[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(lease)
+	case r.Method == http.MethodPost:
+		name = body["metadata"].(map[string]interface{})["name"].(string)
+		if _, ok := k.leases[name]; ok {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		k.store(name, body)
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut:
+		existing, ok := k.leases[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if body["metadata"].(map[string]interface{})["resourceVersion"] != existing["metadata"].(map[string]interface{})["resourceVersion"] {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		k.store(name, body)
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (k *fakeKubernetes) store(name string, lease map[string]interface{}) {
+	k.version++
+	lease["metadata"].(map[string]interface{})["resourceVersion"] = strconv.Itoa(k.version)
+	k.leases[name] = lease
+}
+
+func (k *fakeKubernetes) holder(name string) interface{} {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	return k.leases[name]["spec"].(map[string]interface{})["holderIdentity"]
+}
+
+func (k *fakeKubernetes) rotate(token string) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.token = token
+}
+
+func (k *fakeKubernetes) expire(name string) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	spec := k.leases[name]["spec"].(map[string]interface{})
+	spec["renewTime"] = time.Now().Add(-1 * time.Minute).UTC().Format("2006-01-02T15:04:05.000000Z07:00")
+}
+
+func TestKubernetesLeaseManager_LeasePartition_OnlyOneInstanceHoldsTheLease(t *testing.T) {
+	api := newFakeKubernetes()
+	server := httptest.NewServer(api)
+	defer server.Close()
+	mgr := gobatcher.NewKubernetesLeaseManagerWithEndpoint(server.URL, "", "ns", "capacity")
+	eventer := &gobatcher.EventerBase{}
+	var created, failed []int
+	eventer.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		switch event {
+		case gobatcher.CreatedBlobEvent:
+			created = append(created, val)
+		case gobatcher.FailedEvent:
+			failed = append(failed, val)
+		}
+	})
+	mgr.RaiseEventsTo(eventer)
+	assert.NoError(t, mgr.Provision(context.Background()))
+	mgr.CreatePartitions(context.Background(), 4)
+	assert.Equal(t, []int{0, 1, 2, 3}, created, "expecting a lease object to be created for each partition")
+
+	assert.Equal(t, 15*time.Second, mgr.LeasePartition(context.Background(), "instance-a", 3), "expecting the first instance to get the lease")
+	assert.Equal(t, "instance-a", api.holder("capacity-3"))
+	assert.Equal(t, time.Duration(0), mgr.LeasePartition(context.Background(), "instance-b", 3), "expecting the lease to already be held")
+	assert.Equal(t, []int{3}, failed, "expecting a failed event for the partition")
+	assert.Equal(t, 15*time.Second, mgr.LeasePartition(context.Background(), "instance-a", 3), "expecting the holder to be able to renew")
+	api.expire("capacity-3")
+	assert.Equal(t, 15*time.Second, mgr.LeasePartition(context.Background(), "instance-b", 3), "expecting the lease once it expired")
+	assert.Equal(t, "instance-b", api.holder("capacity-3"))
+}
+
+func TestKubernetesLeaseManager_ReleasePartition_OnlyReleasesItsOwnLease(t *testing.T) {
+	api := newFakeKubernetes()
+	server := httptest.NewServer(api)
+	defer server.Close()
+	mgr := gobatcher.NewKubernetesLeaseManagerWithEndpoint(server.URL, "", "ns", "capacity")
+	mgr.RaiseEventsTo(&gobatcher.EventerBase{})
+	assert.NoError(t, mgr.Provision(context.Background()))
+	releaser := mgr.(gobatcher.LeaseReleaser)
+	assert.Equal(t, 15*time.Second, mgr.LeasePartition(context.Background(), "instance-a", 0))
+	releaser.ReleasePartition(context.Background(), "instance-b", 0)
+	assert.Equal(t, "instance-a", api.holder("capacity-0"), "expecting another instance to not release the lease")
+	releaser.ReleasePartition(context.Background(), "instance-a", 0)
+	assert.Equal(t, "", api.holder("capacity-0"), "expecting the lease to be released")
+	assert.Equal(t, 15*time.Second, mgr.LeasePartition(context.Background(), "instance-b", 0), "expecting another instance to lease it right away")
+}
+
+func TestKubernetesLeaseManager_RaisesErrors(t *testing.T) {
+	api := newFakeKubernetes()
+	server := httptest.NewServer(api)
+	defer server.Close()
+	mgr := gobatcher.NewKubernetesLeaseManagerWithEndpoint(server.URL, "", "ns", "capacity")
+	eventer := &gobatcher.EventerBase{}
+	var lerr *gobatcher.LeaseError
+	eventer.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		if event == gobatcher.ErrorEvent {
+			lerr = metadata.(*gobatcher.LeaseError)
+		}
+	})
+	mgr.RaiseEventsTo(eventer)
+	api.status = http.StatusForbidden
+	err := mgr.Provision(context.Background())
+	var perr *gobatcher.LeaseError
+	if assert.ErrorAs(t, err, &perr, "expecting provision to fail without access to leases") {
+		assert.Equal(t, gobatcher.LeaseOperationProvision, perr.Operation)
+	}
+	assert.Equal(t, time.Duration(0), mgr.LeasePartition(context.Background(), "instance-a", 1))
+	if assert.NotNil(t, lerr, "expecting an error event") {
+		assert.Equal(t, gobatcher.LeaseOperationAcquireLease, lerr.Operation)
+		assert.Equal(t, 1, lerr.Index)
+		assert.Equal(t, http.StatusForbidden, lerr.StatusCode)
+	}
+}
+
+func TestKubernetesLeaseManager_RotatedTokensAreUsed(t *testing.T) {
+	api := newFakeKubernetes()
+	server := httptest.NewServer(api)
+	defer server.Close()
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	assert.NoError(t, os.WriteFile(tokenFile, []byte("token-1\n"), 0600))
+	api.rotate("token-1")
+	mgr := gobatcher.NewKubernetesLeaseManagerWithTokenFile(server.URL, tokenFile, "ns", "capacity")
+	var errs int
+	eventer := &gobatcher.EventerBase{}
+	eventer.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		if event == gobatcher.ErrorEvent {
+			errs++
+		}
+	})
+	mgr.RaiseEventsTo(eventer)
+	assert.NoError(t, mgr.Provision(context.Background()))
+	mgr.CreatePartitions(context.Background(), 1)
+	assert.Equal(t, 15*time.Second, mgr.LeasePartition(context.Background(), "instance-a", 0), "expecting the lease with the first token")
+
+	// the kubelet writes a new token and the old one stops being accepted
+	assert.NoError(t, os.WriteFile(tokenFile, []byte("token-2\n"), 0600))
+	api.rotate("token-2")
+	assert.Equal(t, 15*time.Second, mgr.LeasePartition(context.Background(), "instance-a", 0), "expecting the lease with the rotated token")
+	assert.Equal(t, 0, errs, "expecting no errors")
+
+	// if the file cannot be read, the last token is still used
+	assert.NoError(t, os.Remove(tokenFile))
+	assert.Equal(t, 15*time.Second, mgr.LeasePartition(context.Background(), "instance-a", 0), "expecting the last token to be used")
+}
+
+func TestKubernetesLeaseManager_Provision_FailsWithoutATokenFile(t *testing.T) {
+	api := newFakeKubernetes()
+	server := httptest.NewServer(api)
+	defer server.Close()
+	mgr := gobatcher.NewKubernetesLeaseManagerWithTokenFile(server.URL, filepath.Join(t.TempDir(), "missing"), "ns", "capacity")
+	mgr.RaiseEventsTo(&gobatcher.EventerBase{})
+	err := mgr.Provision(context.Background())
+	assert.ErrorIs(t, err, os.ErrNotExist, "expecting provision to fail if the token cannot be read")
+}
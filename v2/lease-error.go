@@ -0,0 +1,74 @@
+package batcher
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+const (
+	LeaseOperationProvision       = "provision"
+	LeaseOperationCreatePartition = "create-partition"
+	LeaseOperationAcquireLease    = "acquire-lease"
+	LeaseOperationRefreshToken    = "refresh-token"
+	LeaseOperationRenewLease      = "renew-lease"
+	LeaseOperationReleaseLease    = "release-lease"
+)
+
+// LeaseError describes a failure that a LeaseManager encountered while talking to the service that hosts the leases. It is returned
+// from Provision() and is raised as the metadata of the ErrorEvent so that the operation, partition, HTTP status, and request ID are
+// available to whoever is logging the failure. On Go 1.21+ it implements slog.LogValuer.
+type LeaseError struct {
+	Operation   string
+	Index       int // -1 when the failure is not specific to a partition
+	StatusCode  int
+	ServiceCode string
+	RequestID   string
+	Err         error
+}
+
+func newLeaseError(operation string, index int, err error) *LeaseError {
+	lerr := &LeaseError{
+		Operation: operation,
+		Index:     index,
+		Err:       err,
+	}
+	var rerr *azcore.ResponseError
+	if errors.As(err, &rerr) {
+		lerr.ServiceCode = rerr.ErrorCode
+		lerr.setResponse(rerr.RawResponse)
+	}
+	return lerr
+}
+
+func (e *LeaseError) setResponse(resp *http.Response) {
+	if resp == nil {
+		return
+	}
+	e.StatusCode = resp.StatusCode
+	e.RequestID = resp.Header.Get("x-ms-request-id")
+}
+
+func (e *LeaseError) Error() string {
+	msg := fmt.Sprintf("lease %s failed", e.Operation)
+	if e.Index >= 0 {
+		msg += fmt.Sprintf(" on partition %d", e.Index)
+	}
+	if e.StatusCode > 0 {
+		msg += fmt.Sprintf(" (status %d)", e.StatusCode)
+	}
+	if e.RequestID != "" {
+		msg += fmt.Sprintf(" (request-id %s)", e.RequestID)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// This allows errors.Is() and errors.As() to inspect the underlying error.
+func (e *LeaseError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,29 @@
+//go:build go1.21
+// +build go1.21
+
+package batcher
+
+import "log/slog"
+
+// This allows a LeaseError to be logged with log/slog as a group of attributes rather than a single string.
+func (e *LeaseError) LogValue() slog.Value {
+	attrs := []slog.Attr{
+		slog.String("operation", e.Operation),
+	}
+	if e.Index >= 0 {
+		attrs = append(attrs, slog.Int("partition", e.Index))
+	}
+	if e.StatusCode > 0 {
+		attrs = append(attrs, slog.Int("status", e.StatusCode))
+	}
+	if e.ServiceCode != "" {
+		attrs = append(attrs, slog.String("service-code", e.ServiceCode))
+	}
+	if e.RequestID != "" {
+		attrs = append(attrs, slog.String("request-id", e.RequestID))
+	}
+	if e.Err != nil {
+		attrs = append(attrs, slog.String("error", e.Err.Error()))
+	}
+	return slog.GroupValue(attrs...)
+}
@@ -0,0 +1,32 @@
+//go:build go1.21
+// +build go1.21
+
+package batcher
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeaseError_LogValue(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	lerr := &LeaseError{
+		Operation:  LeaseOperationAcquireLease,
+		Index:      3,
+		StatusCode: 409,
+		RequestID:  "my-request-id",
+		Err:        errors.New("conflict"),
+	}
+	logger.Error("lease failed", "err", lerr)
+	out := buf.String()
+	assert.Contains(t, out, "err.operation=acquire-lease")
+	assert.Contains(t, out, "err.partition=3")
+	assert.Contains(t, out, "err.status=409")
+	assert.Contains(t, out, "err.request-id=my-request-id")
+	assert.Contains(t, out, "err.error=conflict")
+}
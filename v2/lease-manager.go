@@ -11,3 +11,15 @@ type LeaseManager interface {
 	CreatePartitions(ctx context.Context, count int)
 	LeasePartition(ctx context.Context, id string, index uint32) (leaseTime time.Duration)
 }
+
+// A LeaseManager may optionally implement LeaseRenewer to allow SharedResource to renew the leases on partitions it still needs rather
+// than letting them expire and competing to lease them again. RenewPartition() should return 0 if the lease could not be renewed.
+type LeaseRenewer interface {
+	RenewPartition(ctx context.Context, id string, index uint32) (leaseTime time.Duration)
+}
+
+// A LeaseManager may optionally implement LeaseReleaser to allow SharedResource to release the leases on partitions it no longer needs
+// (when GiveMe() lowers the target) so other instances can obtain them right away rather than when the leases expire.
+type LeaseReleaser interface {
+	ReleasePartition(ctx context.Context, id string, index uint32)
+}
@@ -0,0 +1,61 @@
+package batcher
+
+// A ListenerOption limits the events a listener provided to AddListener() is called for.
+type ListenerOption func(l *listener)
+
+type listener struct {
+	fn      func(event string, val int, msg string, metadata interface{})
+	events  map[string]bool
+	watcher Watcher
+}
+
+// The listener is only called for the provided events (for instance, `OnlyEvents(BatchEvent, AuditFailEvent)`). This is much cheaper
+// than ignoring the events in the listener since high-frequency events such as request and capacity are never passed to it.
+func OnlyEvents(events ...string) ListenerOption {
+	return func(l *listener) {
+		if l.events == nil {
+			l.events = make(map[string]bool, len(events))
+		}
+		for _, event := range events {
+			l.events[event] = true
+		}
+	}
+}
+
+// The listener is only called for events about the provided Watcher. These are the events whose metadata is the Watcher (cooldown), an
+// Operation for the Watcher (dead-letter, deadline-miss, expired, cancelled, coalesced, dropped, purged), or the Operations of a batch
+// for the Watcher (batch, batch-failed, timeout). Since WithEmitBatch describes the Operations in a batch by the label of their Watcher,
+// the Watcher must have a label (see Watcher.WithLabel()) to receive batch events in that case. Events that are not about a Watcher are
+// never passed to the listener.
+func ForWatcher(watcher Watcher) ListenerOption {
+	return func(l *listener) {
+		l.watcher = watcher
+	}
+}
+
+// This is TRUE if the listener should be called for the event.
+func (l *listener) accepts(event string, metadata interface{}) bool {
+	if l.events != nil && !l.events[event] {
+		return false
+	}
+	if l.watcher != nil && !isAboutWatcher(l.watcher, metadata) {
+		return false
+	}
+	return true
+}
+
+// This is TRUE if the metadata of an event refers to the Watcher.
+func isAboutWatcher(watcher Watcher, metadata interface{}) bool {
+	switch m := metadata.(type) {
+	case Watcher:
+		return m == watcher
+	case Operation:
+		return m.Watcher() == watcher
+	case []Operation:
+		return len(m) > 0 && m[0].Watcher() == watcher
+	case []BatchedOperation:
+		return len(m) > 0 && watcher.Label() != "" && m[0].Watcher == watcher.Label()
+	default:
+		return false
+	}
+}
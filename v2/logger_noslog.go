@@ -0,0 +1,9 @@
+//go:build !go1.21
+// +build !go1.21
+
+package batcher
+
+// Before Go 1.21 there is no log/slog, so Batcher and SharedResource do not have WithLogger().
+type batcherLogging interface{}
+
+type sharedResourceLogging interface{}
@@ -0,0 +1,87 @@
+//go:build go1.21
+// +build go1.21
+
+package batcher
+
+import (
+	"context"
+	"log/slog"
+)
+
+// On Go 1.21+, Batcher and SharedResource can log their events to a *slog.Logger.
+type batcherLogging interface {
+	WithLogger(logger *slog.Logger) Batcher
+}
+
+type sharedResourceLogging interface {
+	WithLogger(logger *slog.Logger) SharedResource
+}
+
+// If you provide a logger, every event raised by Batcher is logged to it so you have operational visibility without writing a
+// listener. Shutdown, pause, resume, summary, low-watermark, and recovered events are logged at Info; audit failures, failed batches,
+// timeouts, dead letters, deadline misses, cooldowns, enqueue errors, expired or dropped Operations, and high watermarks at Warn; panics
+// and errors at Error; everything else (such as the batch and flush events) at Debug. The Operations in the metadata of an event are
+// never logged. The logger is added as a listener, so it is removed by RemoveAllListeners() (and by WithClearListenersOnShutdown).
+func (r *batcher) WithLogger(logger *slog.Logger) Batcher {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.phase != phaseUninitialized {
+		panic(InitializationOnlyError)
+	}
+	r.AddListener(newLogListener(logger.With(slog.String("component", "batcher"))))
+	return r
+}
+
+// If you provide a logger, every event raised by SharedResource (and its LeaseManager) is logged to it. Shutdown, provisioning, and
+// factor events are logged at Info; errors (including any *LeaseError, as a group of attributes) at Error; everything else (such as the
+// capacity and allocated events) at Debug. The logger is added as a listener, so it is removed by RemoveAllListeners().
+func (r *sharedResource) WithLogger(logger *slog.Logger) SharedResource {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.phase != phaseUninitialized {
+		panic(InitializationOnlyError)
+	}
+	r.AddListener(newLogListener(logger.With(slog.String("component", "shared-resource"))))
+	return r
+}
+
+// This returns the level an event is logged at.
+func logLevel(event string) slog.Level {
+	switch event {
+	case ErrorEvent, PanicEvent:
+		return slog.LevelError
+	case AuditFailEvent, BatchFailedEvent, TimeoutEvent, DeadLetterEvent, DeadlineMissEvent, CooldownEvent, EnqueueErrorEvent, ExpiredEvent,
+		DroppedEvent, HighWatermarkEvent:
+		return slog.LevelWarn
+	case ShutdownEvent, PauseEvent, ResumeEvent, SummaryEvent, ProvisionStartEvent, ProvisionDoneEvent, FactorEvent,
+		CreatedContainerEvent, PreStartEnqueueEvent, ConfigChangedEvent, LowWatermarkEvent,
+		RecoveredEvent:
+		return slog.LevelInfo
+	default:
+		return slog.LevelDebug
+	}
+}
+
+// This returns a listener that logs each event with the event name as the message and the msg of the event as the detail. The metadata
+// is only logged if it is an error or a Summary, since other metadata may contain Operations (and their payloads).
+func newLogListener(logger *slog.Logger) func(event string, val int, msg string, metadata interface{}) {
+	return func(event string, val int, msg string, metadata interface{}) {
+		ctx := context.Background()
+		level := logLevel(event)
+		if !logger.Enabled(ctx, level) {
+			return
+		}
+		attrs := []slog.Attr{slog.Int("val", val)}
+		err, isError := metadata.(error)
+		if msg != "" && (!isError || msg != err.Error()) {
+			attrs = append(attrs, slog.String("detail", msg))
+		}
+		switch m := metadata.(type) {
+		case error:
+			attrs = append(attrs, slog.Any("error", m))
+		case Summary:
+			attrs = append(attrs, slog.Any("summary", m))
+		}
+		logger.LogAttrs(ctx, level, event, attrs...)
+	}
+}
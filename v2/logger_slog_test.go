@@ -0,0 +1,75 @@
+//go:build go1.21
+// +build go1.21
+
+package batcher_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// The processing loop logs on its own goroutine, so the buffer must be locked.
+type lockedBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
+}
+
+func TestBatcher_WithLogger_EventsAreLoggedAtTheirLevel(t *testing.T) {
+	var buf lockedBuffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	ctx, cancel := context.WithCancel(context.Background())
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Millisecond).
+		WithEmitFlush().
+		WithLogger(logger)
+	err := batcher.Enqueue(nil)
+	assert.ErrorIs(t, err, gobatcher.NoOperationError, "expecting an error for a nil operation")
+	err = batcher.Start(ctx)
+	assert.NoError(t, err, "expecting no error on start")
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	time.Sleep(20 * time.Millisecond)
+	out := buf.String()
+	assert.Contains(t, out, `level=WARN msg=enqueue-error component=batcher val=0 error="no operation was provided."`, "expecting enqueue errors at warn")
+	assert.Contains(t, out, "level=INFO msg=shutdown", "expecting the shutdown at info")
+	assert.NotContains(t, out, "flush-done", "expecting flushes to be logged below info")
+}
+
+func TestSharedResource_WithLogger_LeaseErrorsAreLoggedAsErrors(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	res := gobatcher.NewSharedResource().
+		WithLogger(logger)
+	lerr := &gobatcher.LeaseError{
+		Operation: gobatcher.LeaseOperationAcquireLease,
+		Index:     3,
+		Err:       errors.New("forbidden"),
+	}
+	res.Emit(gobatcher.ErrorEvent, 0, lerr.Error(), lerr)
+	res.Emit(gobatcher.CapacityEvent, 1000, "", nil)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 1, "expecting only the error to be logged at the default level")
+	assert.Contains(t, lines[0], "level=ERROR msg=error", "expecting the error at error")
+	assert.Contains(t, lines[0], "component=shared-resource val=0 error.operation=acquire-lease error.partition=3", "expecting the lease error as attributes")
+}
@@ -0,0 +1,221 @@
+// Package metrics exposes what a Batcher (and its rate limiters) are doing as Prometheus metrics. The metrics are fed by the events the
+// Batcher and rate limiters already raise, so you only need to create a Collector and register it.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	gobatcher "github.com/plasne/go-batcher/v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// If no namespace is provided to NewCollector(), the metrics are named "gobatcher_*".
+const DefaultNamespace = "gobatcher"
+
+// Collector is a prometheus.Collector for a single Batcher. It reports the following metrics (without the namespace):
+//
+//   - buffer_operations (gauge): the number of Operations waiting in the buffer.
+//   - buffer_memory_bytes (gauge): the estimated bytes held by the Operations in the buffer.
+//   - needs_capacity (gauge): the capacity needed to process everything that is in the buffer and inflight.
+//   - inflight_batches (gauge): the number of batches the Watchers have not finished with.
+//   - requested_capacity (gauge): the capacity last requested of the rate limiter (requires Batcher.WithEmitRequest).
+//   - batch_size (histogram): the number of Operations in each batch (requires Batcher.WithEmitBatch).
+//   - flush_duration_seconds (histogram): how long each flush took (requires Batcher.WithEmitFlush).
+//   - audit_failures_total (counter): the number of audits that failed.
+//   - enqueue_errors_total (counter): the number of Operations that could not be enqueued, labeled by "reason".
+//   - rate_limiter_capacity (gauge): the capacity of each rate limiter provided to WithRateLimiter(), labeled by "limiter".
+//   - rate_limiter_target (gauge): the partitions each rate limiter is trying to obtain, labeled by "limiter".
+type Collector struct {
+	batcher gobatcher.Batcher
+
+	bufferOperations *prometheus.Desc
+	bufferMemory     *prometheus.Desc
+	needsCapacity    *prometheus.Desc
+	inflightBatches  *prometheus.Desc
+	requested        prometheus.Gauge
+	batchSize        prometheus.Histogram
+	flushDuration    prometheus.Histogram
+	auditFailures    prometheus.Counter
+	enqueueErrors    *prometheus.CounterVec
+	capacity         *prometheus.GaugeVec
+	target           *prometheus.GaugeVec
+
+	mutex      sync.Mutex
+	flushStart time.Time
+	listeners  map[gobatcher.Eventer]uuid.UUID
+}
+
+// This method creates a Collector that listens to the events raised by the Batcher. You must still register it (for instance, with
+// prometheus.MustRegister()). If you have more than one Batcher, give each Collector a different namespace.
+func NewCollector(namespace string, batcher gobatcher.Batcher) *Collector {
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+	c := &Collector{
+		batcher: batcher,
+		bufferOperations: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "buffer_operations"),
+			"The number of Operations waiting in the buffer.", nil, nil),
+		bufferMemory: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "buffer_memory_bytes"),
+			"The estimated bytes held by the Operations in the buffer.", nil, nil),
+		needsCapacity: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "needs_capacity"),
+			"The capacity needed to process everything that is in the buffer and inflight.", nil, nil),
+		inflightBatches: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "inflight_batches"),
+			"The number of batches the Watchers have not finished with.", nil, nil),
+		requested: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "requested_capacity",
+			Help:      "The capacity last requested of the rate limiter.",
+		}),
+		batchSize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "batch_size",
+			Help:      "The number of Operations in each batch.",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 11),
+		}),
+		flushDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "flush_duration_seconds",
+			Help:      "How long each flush took.",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 2, 15),
+		}),
+		auditFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "audit_failures_total",
+			Help:      "The number of audits that failed.",
+		}),
+		enqueueErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "enqueue_errors_total",
+			Help:      "The number of Operations that could not be enqueued.",
+		}, []string{"reason"}),
+		capacity: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "rate_limiter_capacity",
+			Help:      "The capacity of the rate limiter.",
+		}, []string{"limiter"}),
+		target: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "rate_limiter_target",
+			Help:      "The number of partitions the rate limiter is trying to obtain.",
+		}, []string{"limiter"}),
+		listeners: make(map[gobatcher.Eventer]uuid.UUID),
+	}
+	c.listeners[batcher] = batcher.AddListener(c.onBatcherEvent)
+	return c
+}
+
+// This method reports the capacity and target of a rate limiter (such as a SharedResource) labeled by the name provided. You would
+// typically provide each rate limiter given to the Batcher.
+func (c *Collector) WithRateLimiter(name string, rl gobatcher.Eventer) *Collector {
+	id := rl.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		switch event {
+		case gobatcher.CapacityEvent:
+			c.capacity.WithLabelValues(name).Set(float64(val))
+		case gobatcher.TargetEvent:
+			c.target.WithLabelValues(name).Set(float64(val))
+		}
+	}, gobatcher.OnlyEvents(gobatcher.CapacityEvent, gobatcher.TargetEvent))
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.listeners[rl] = id
+	return c
+}
+
+// This method removes the listeners from the Batcher and rate limiters. The metrics keep their last values.
+func (c *Collector) Close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for eventer, id := range c.listeners {
+		eventer.RemoveListener(id)
+	}
+	c.listeners = make(map[gobatcher.Eventer]uuid.UUID)
+}
+
+func (c *Collector) onBatcherEvent(event string, val int, msg string, metadata interface{}) {
+	switch event {
+	case gobatcher.RequestEvent:
+		// requests for the rate limiter of a Watcher include that rate limiter as the metadata
+		if metadata == nil {
+			c.requested.Set(float64(val))
+		}
+	case gobatcher.BatchEvent:
+		c.batchSize.Observe(float64(val))
+	case gobatcher.FlushStartEvent:
+		c.mutex.Lock()
+		c.flushStart = time.Now()
+		c.mutex.Unlock()
+	case gobatcher.FlushDoneEvent:
+		c.mutex.Lock()
+		start := c.flushStart
+		c.mutex.Unlock()
+		if !start.IsZero() {
+			c.flushDuration.Observe(time.Since(start).Seconds())
+		}
+	case gobatcher.AuditFailEvent:
+		c.auditFailures.Inc()
+	case gobatcher.EnqueueErrorEvent:
+		err, _ := metadata.(error)
+		c.enqueueErrors.WithLabelValues(reason(err)).Inc()
+	}
+}
+
+// This returns a label for the error raised by enqueue-error.
+func reason(err error) string {
+	switch {
+	case errors.Is(err, gobatcher.BufferFullError):
+		return "buffer-full"
+	case errors.Is(err, gobatcher.BufferIsShutdown):
+		return "shutdown"
+	case errors.Is(err, gobatcher.TooExpensiveError):
+		return "too-expensive"
+	case errors.Is(err, gobatcher.TooManyAttemptsError):
+		return "too-many-attempts"
+	case errors.Is(err, gobatcher.NotStartedError):
+		return "not-started"
+	case errors.Is(err, gobatcher.OperationExpiredError):
+		return "expired"
+	case errors.Is(err, gobatcher.DuplicateOperationError):
+		return "duplicate"
+	case errors.Is(err, gobatcher.NoOperationError), errors.Is(err, gobatcher.NoWatcherError):
+		return "invalid"
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return "context"
+	default:
+		return "other"
+	}
+}
+
+// This is called by the Prometheus registry.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.bufferOperations
+	ch <- c.bufferMemory
+	ch <- c.needsCapacity
+	ch <- c.inflightBatches
+	c.requested.Describe(ch)
+	c.batchSize.Describe(ch)
+	c.flushDuration.Describe(ch)
+	c.auditFailures.Describe(ch)
+	c.enqueueErrors.Describe(ch)
+	c.capacity.Describe(ch)
+	c.target.Describe(ch)
+}
+
+// This is called by the Prometheus registry. The buffer, capacity, and inflight gauges are read from Batcher.Stats() at the time.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.batcher.Stats()
+	ch <- prometheus.MustNewConstMetric(c.bufferOperations, prometheus.GaugeValue, float64(stats.OperationsInBuffer))
+	ch <- prometheus.MustNewConstMetric(c.bufferMemory, prometheus.GaugeValue, float64(stats.EstimatedMemory))
+	ch <- prometheus.MustNewConstMetric(c.needsCapacity, prometheus.GaugeValue, float64(stats.NeedsCapacity))
+	ch <- prometheus.MustNewConstMetric(c.inflightBatches, prometheus.GaugeValue, float64(stats.Running))
+	c.requested.Collect(ch)
+	c.batchSize.Collect(ch)
+	c.flushDuration.Collect(ch)
+	c.auditFailures.Collect(ch)
+	c.enqueueErrors.Collect(ch)
+	c.capacity.Collect(ch)
+	c.target.Collect(ch)
+}
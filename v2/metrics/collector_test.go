@@ -0,0 +1,78 @@
+package metrics_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+	"github.com/plasne/go-batcher/v2/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+func gather(t *testing.T, reg *prometheus.Registry) map[string]*dto.MetricFamily {
+	families, err := reg.Gather()
+	assert.NoError(t, err, "expecting no error on gather")
+	byName := make(map[string]*dto.MetricFamily)
+	for _, family := range families {
+		byName[family.GetName()] = family
+	}
+	return byName
+}
+
+func TestCollector_EventsAreReportedAsMetrics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	res := gobatcher.NewSharedResource().
+		WithReservedCapacity(1000)
+	batcher := gobatcher.NewBatcher().
+		WithRateLimiter(res).
+		WithFlushInterval(100 * time.Millisecond).
+		WithEmitBatch().
+		WithEmitFlush()
+	collector := metrics.NewCollector("", batcher).
+		WithRateLimiter("reserved", res)
+	defer collector.Close()
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(collector)
+
+	var once sync.Once
+	done := make(chan struct{})
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		once.Do(func() { close(done) })
+	})
+	for i := 0; i < 3; i++ {
+		err := batcher.Enqueue(gobatcher.NewOperation(watcher, 10, struct{}{}, true))
+		assert.NoError(t, err, "expecting no error on enqueue")
+	}
+	err := batcher.Enqueue(nil)
+	assert.ErrorIs(t, err, gobatcher.NoOperationError, "expecting an error for a nil operation")
+	err = res.Start(ctx)
+	assert.NoError(t, err, "expecting no error on start")
+	err = batcher.Start(ctx)
+	assert.NoError(t, err, "expecting no error on start")
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		assert.Fail(t, "expecting the batch to be raised")
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	families := gather(t, reg)
+	batchSize := families["gobatcher_batch_size"].GetMetric()[0].GetHistogram()
+	assert.Equal(t, uint64(1), batchSize.GetSampleCount(), "expecting a single batch")
+	assert.Equal(t, float64(3), batchSize.GetSampleSum(), "expecting the batch to contain every operation")
+	assert.Greater(t, families["gobatcher_flush_duration_seconds"].GetMetric()[0].GetHistogram().GetSampleCount(), uint64(0),
+		"expecting flushes to be timed")
+	enqueueErrors := families["gobatcher_enqueue_errors_total"].GetMetric()[0]
+	assert.Equal(t, "invalid", enqueueErrors.GetLabel()[0].GetValue(), "expecting the reason to be labeled")
+	assert.Equal(t, float64(1), enqueueErrors.GetCounter().GetValue(), "expecting a single enqueue error")
+	capacity := families["gobatcher_rate_limiter_capacity"].GetMetric()[0]
+	assert.Equal(t, "reserved", capacity.GetLabel()[0].GetValue(), "expecting the limiter to be labeled")
+	assert.Equal(t, float64(1000), capacity.GetGauge().GetValue(), "expecting the capacity of the rate limiter")
+	assert.Equal(t, float64(0), families["gobatcher_buffer_operations"].GetMetric()[0].GetGauge().GetValue(),
+		"expecting the buffer to be empty")
+}
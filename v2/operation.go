@@ -1,42 +1,384 @@
 package batcher
 
 import (
+	"sync"
 	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Operation interface {
+	WithOnComplete(fn func(op Operation, result Result)) Operation
+	WithDeadline(deadline time.Time) Operation
+	WithExpiry(expiry time.Time) Operation
+	WithTTL(ttl time.Duration) Operation
+	WithKey(key string) Operation
+	WithSize(val uint32) Operation
+	WithSplit(fn func(maxCost uint32) []Operation) Operation
+	WithCostFunc(fn func(payload interface{}) uint32) Operation
+	WithSpanContext(sc trace.SpanContext) Operation
+	WithID(id string) Operation
+	WithMetadata(metadata map[string]interface{}) Operation
+	WithDedupKey(key string) Operation
+	WithPriority(priority int) Operation
+	Deadline() time.Time
+	Expiry() time.Time
+	Key() string
+	DedupKey() string
+	Priority() int
+	EnqueuedAt() time.Time
+	Size() uint32
+	SpanContext() trace.SpanContext
+	Split(maxCost uint32) []Operation
+	ID() uint64
+	ExternalID() string
+	Metadata() map[string]interface{}
 	Payload() interface{}
 	Attempt() uint32
 	Cost() uint32
+	CostOf(dimension string) uint32
 	Watcher() Watcher
 	IsBatchable() bool
 	MakeAttempt()
+	SetResult(result Result)
+	Result() Result
+	Done() <-chan struct{}
+	Complete(result Result, final bool)
 }
 
+// every Operation is assigned the next ID when it is created
+var lastOperationID uint64
+
 type operation struct {
-	cost      uint32
-	attempt   uint32
-	batchable bool
-	watcher   Watcher
-	payload   interface{}
+	id         uint64
+	externalID string
+	cost       uint32
+	costs      map[string]uint32
+	attempt    uint32
+	enqueuedAt int64 // unix nanoseconds; written once with atomics when the Operation is first enqueued
+	batchable  bool
+	deadline   time.Time
+	expiry     time.Time
+	key        string
+	dedupKey   string
+	priority   int
+	size       uint32
+	split      func(maxCost uint32) []Operation
+	span       trace.SpanContext
+	watcher    Watcher
+	onComplete func(op Operation, result Result)
+
+	// the payload may be produced or read back from the overflow file by whichever goroutine needs it first
+	payloadMutex sync.Mutex
+	payload      interface{}
+	produce      func() interface{}
+
+	// the metadata may be annotated by callbacks while the Operation is in flight
+	metadataMutex sync.Mutex
+	metadata      map[string]interface{}
+
+	// the result is written by the Watcher and read by Batcher
+	resultMutex sync.Mutex
+	result      Result
+	done        chan struct{}
 }
 
 // This method creates a new Operation with a Watcher, cost, payload, and a flag determining whether or not the Operation is batchable.
 // An Operation will be Enqueued into a Batcher.
 func NewOperation(watcher Watcher, cost uint32, payload interface{}, batchable bool) Operation {
 	return &operation{
+		id:        atomic.AddUint64(&lastOperationID, 1),
 		watcher:   watcher,
 		cost:      cost,
 		payload:   payload,
 		batchable: batchable,
+		done:      make(chan struct{}),
+	}
+}
+
+// This method creates a new Operation like NewOperation() except that it costs a different amount in each dimension (for instance,
+// `map[string]uint32{"ru": 10, "requests": 1}`) for datastores that enforce more than one quota. A rate limiter bound to a dimension with
+// NewDimensionalRateLimiter() is charged the cost in that dimension (0 if the dimension is not in the map). Any other rate limiter is
+// charged Cost(), which is the largest of the costs.
+func NewOperationWithCosts(watcher Watcher, costs map[string]uint32, payload interface{}, batchable bool) Operation {
+	op := &operation{
+		id:        atomic.AddUint64(&lastOperationID, 1),
+		watcher:   watcher,
+		costs:     make(map[string]uint32, len(costs)),
+		payload:   payload,
+		batchable: batchable,
+		done:      make(chan struct{}),
 	}
+	for dimension, cost := range costs {
+		op.costs[dimension] = cost
+		if cost > op.cost {
+			op.cost = cost
+		}
+	}
+	return op
+}
+
+// This method creates a new Operation like NewOperation() except that the payload is produced by calling the provided function the
+// first time it is needed, which is when the Operation is dispatched in a batch. This allows expensive payload construction (for
+// instance, serialization or snapshotting) to be skipped for Operations that are never dispatched. The function is called at most once.
+func NewDeferredOperation(watcher Watcher, cost uint32, produce func() interface{}, batchable bool) Operation {
+	return &operation{
+		id:        atomic.AddUint64(&lastOperationID, 1),
+		watcher:   watcher,
+		cost:      cost,
+		produce:   produce,
+		batchable: batchable,
+		done:      make(chan struct{}),
+	}
+}
+
+// You may provide a function that is called once the Operation has a final Result. A Result is final when the Operation succeeded or
+// when it failed (or was abandoned) and has no attempts remaining per the Watcher's MaxAttempts. If the Watcher has no MaxAttempts,
+// a failed Operation is never considered final since it may always be enqueued again.
+func (o *operation) WithOnComplete(fn func(op Operation, result Result)) Operation {
+	o.onComplete = fn
+	return o
+}
+
+// You may provide a time by which the Operation should be dispatched. When Batcher is created with WithDeadlineFirst(), Operations with
+// the earliest deadlines are dispatched first when there is not enough capacity for everything. Whenever an Operation is dispatched
+// after its deadline, a deadline-miss event is raised. This should be set before the Operation is enqueued.
+func (o *operation) WithDeadline(deadline time.Time) Operation {
+	o.deadline = deadline
+	return o
+}
+
+// You may provide a time after which the Operation is no longer worth processing. If it is still in the buffer at that time, it is
+// removed without being dispatched: its Result has a Status of ResultExpired and an Err of OperationExpiredError, the completion callback
+// is called, and an expired event is raised. An Operation that has already expired cannot be enqueued. This should be set before the
+// Operation is enqueued.
+func (o *operation) WithExpiry(expiry time.Time) Operation {
+	o.expiry = expiry
+	return o
+}
+
+// This is the same as WithExpiry() except that the Operation expires once the TTL has elapsed from now, so it should be called right
+// before the Operation is enqueued. If the Batcher uses a Clock (see Batcher.WithClock()), use WithExpiry() with the time of that Clock
+// instead.
+func (o *operation) WithTTL(ttl time.Duration) Operation {
+	o.expiry = time.Now().Add(ttl)
+	return o
+}
+
+// You may provide a key (for instance, a partition key) for the Operation. If the Watcher was created with WithGroupByKey(), every batch
+// raised to it only contains Operations with the same key, which is required by transactional batch APIs. This should be set before
+// the Operation is enqueued.
+func (o *operation) WithKey(key string) Operation {
+	o.key = key
+	return o
+}
+
+// This returns the key provided by WithKey() or an empty string if there is none.
+func (o *operation) Key() string {
+	return o.key
+}
+
+// You may provide a key that identifies what the Operation writes (for instance, the ID of the entity). If the Batcher was created with
+// WithDeduplication(), enqueuing an Operation with the same dedup key (for the same Watcher) as an Operation that is still in the
+// buffer either merges them or is rejected with DuplicateOperationError. This should be set before the Operation is enqueued.
+func (o *operation) WithDedupKey(key string) Operation {
+	o.dedupKey = key
+	return o
+}
+
+// This returns the dedup key provided by WithDedupKey() or an empty string if there is none.
+func (o *operation) DedupKey() string {
+	return o.dedupKey
+}
+
+// You may provide a priority for the Operation; higher is more important. If the Batcher was created with
+// WithFullBufferPolicy(DropLowestPriority), the Operation with the lowest priority is dropped when the buffer is full. This should be
+// set before the Operation is enqueued.
+func (o *operation) WithPriority(priority int) Operation {
+	o.priority = priority
+	return o
+}
+
+// This returns the priority provided by WithPriority() or 0 if there is none.
+func (o *operation) Priority() int {
+	return o.priority
+}
+
+// This returns when the Operation was first enqueued (according to the Clock of the Batcher) or a zero time if it has not been enqueued.
+// Enqueuing it again (for instance, to retry it) does not change it.
+func (o *operation) EnqueuedAt() time.Time {
+	if nanos := atomic.LoadInt64(&o.enqueuedAt); nanos != 0 {
+		return time.Unix(0, nanos)
+	}
+	return time.Time{}
+}
+
+// This records when the Operation was first enqueued.
+func markEnqueued(op Operation, at time.Time) {
+	if o, ok := op.(*operation); ok {
+		atomic.CompareAndSwapInt64(&o.enqueuedAt, 0, at.UnixNano())
+	}
+}
+
+// You may provide a hint of how many bytes the Operation will take on the wire. If the Watcher has a MaxBatchBytes, the sizes of the
+// Operations in a batch will not add up to more than that. This should be set before the Operation is enqueued.
+func (o *operation) WithSize(val uint32) Operation {
+	o.size = val
+	return o
+}
+
+// This returns the size hint provided by WithSize() or 0 if there is none.
+func (o *operation) Size() uint32 {
+	return o.size
+}
+
+// You may provide a function that divides the Operation into smaller Operations that each cost no more than maxCost. If the Operation
+// costs more than the rate limiter's MaxCapacity, Batcher calls this function and enqueues the fragments it returns instead of returning
+// `TooExpensiveError`. This Operation is then completed (see WithOnComplete() and Done()) once every fragment has a final Result; it
+// succeeds if every fragment succeeded, otherwise it has the Result of the first fragment that did not.
+func (o *operation) WithSplit(fn func(maxCost uint32) []Operation) Operation {
+	o.split = fn
+	return o
+}
+
+// You may provide a function that estimates the cost of the Operation from its payload (for instance, from the size of a document). The
+// function is called right away and its result replaces the cost (including any costs provided to NewOperationWithCosts()), so this
+// should be set before the Operation is enqueued. If the Operation was created with NewDeferredOperation(), this produces the payload.
+// Use Batcher.ReportActualCost() once you know what the Operation really cost.
+func (o *operation) WithCostFunc(fn func(payload interface{}) uint32) Operation {
+	o.cost = fn(o.Payload())
+	o.costs = nil
+	return o
 }
 
-// This will return the payload object for the Operation.
+// This is used internally by Batcher to divide the Operation using the function provided by WithSplit(). It returns nil if there is no
+// function. The fragments are linked to this Operation so that it is completed once they all are.
+func (o *operation) Split(maxCost uint32) []Operation {
+	if o.split == nil {
+		return nil
+	}
+	fragments := o.split(maxCost)
+	if len(fragments) == 0 {
+		return nil
+	}
+	var mutex sync.Mutex
+	remaining := len(fragments)
+	combined := Succeeded()
+	var actualCost uint32
+	onFragment := func(_ Operation, result Result) {
+		mutex.Lock()
+		if result.Status != ResultSucceeded && combined.Status == ResultSucceeded {
+			combined = result
+		}
+		actualCost += result.ActualCost
+		remaining--
+		done, final := remaining == 0, combined
+		mutex.Unlock()
+		if done {
+			final.ActualCost = actualCost
+			o.Complete(final, true)
+		}
+	}
+	for _, fragment := range fragments {
+		if f, ok := fragment.(*operation); ok && f.onComplete != nil {
+			previous := f.onComplete
+			f.onComplete = func(op Operation, result Result) {
+				previous(op, result)
+				onFragment(op, result)
+			}
+		} else {
+			fragment.WithOnComplete(onFragment)
+		}
+	}
+	return fragments
+}
+
+// This returns the deadline provided by WithDeadline() or the zero time if there is none.
+func (o *operation) Deadline() time.Time {
+	return o.deadline
+}
+
+// This returns the expiry provided by WithExpiry() (or WithTTL()) or the zero time if there is none.
+func (o *operation) Expiry() time.Time {
+	return o.expiry
+}
+
+// This returns an ID that is unique to the Operation within the process. It is assigned when the Operation is created.
+func (o *operation) ID() uint64 {
+	return o.id
+}
+
+// You may provide your own ID for the Operation (for instance, a request ID or the ID of the entity being written) so that completion
+// callbacks, listeners, and dead-letter handlers can identify it without inspecting the payload. Unlike ID(), it is not required to be
+// unique. When the Batcher has a TracerProvider, it is recorded on the enqueue span so the Operation can be correlated with other
+// systems. This should be set before the Operation is enqueued.
+func (o *operation) WithID(id string) Operation {
+	o.externalID = id
+	return o
+}
+
+// This returns the ID provided by WithID() or an empty string if there is none.
+func (o *operation) ExternalID() string {
+	return o.externalID
+}
+
+// You may annotate the Operation with metadata that is not part of the payload (for instance, the tenant or the source of the request).
+// The values are merged into any metadata that was already provided, replacing values with the same key, so a Watcher or callback may
+// call this to record what happened to the Operation. It is safe to call at any time.
+func (o *operation) WithMetadata(metadata map[string]interface{}) Operation {
+	o.metadataMutex.Lock()
+	defer o.metadataMutex.Unlock()
+	if o.metadata == nil {
+		o.metadata = make(map[string]interface{}, len(metadata))
+	}
+	for key, val := range metadata {
+		o.metadata[key] = val
+	}
+	return o
+}
+
+// This returns a copy of the metadata provided by WithMetadata() or nil if there is none. Changing the copy does not change the
+// Operation.
+func (o *operation) Metadata() map[string]interface{} {
+	o.metadataMutex.Lock()
+	defer o.metadataMutex.Unlock()
+	if o.metadata == nil {
+		return nil
+	}
+	metadata := make(map[string]interface{}, len(o.metadata))
+	for key, val := range o.metadata {
+		metadata[key] = val
+	}
+	return metadata
+}
+
+// This will return the payload object for the Operation. If the Operation was created with NewDeferredOperation(), the payload is
+// produced the first time this is called. If the payload was written to the overflow file (see Batcher.WithOverflowFile()), it is read
+// back.
 func (o *operation) Payload() interface{} {
+	o.payloadMutex.Lock()
+	defer o.payloadMutex.Unlock()
+	if o.produce != nil {
+		o.payload = o.produce()
+		o.produce = nil
+	}
 	return o.payload
 }
 
+// This releases the payload so it can be garbage collected and reads it back with the provided function the next time it is needed.
+// It returns false (and does nothing) if the payload has not been produced yet, since there is nothing to release.
+func (o *operation) spill(read func() interface{}) bool {
+	o.payloadMutex.Lock()
+	defer o.payloadMutex.Unlock()
+	if o.produce != nil {
+		return false
+	}
+	o.payload = nil
+	o.produce = read
+	return true
+}
+
 // This will return the number of times this Operation has been returned to its Watcher (for instance, the first time a Watcher sees the
 // Operation in a batch, Attempt() will be equal to 1). This is used by MaxAttempts on a Watcher to ensure that the Operation is not retried
 // more times than is allowed.
@@ -48,6 +390,9 @@ func (o *operation) Attempt() uint32 {
 // it for unit tests.
 func (o *operation) MakeAttempt() {
 	atomic.AddUint32(&o.attempt, 1)
+	o.resultMutex.Lock()
+	defer o.resultMutex.Unlock()
+	o.result = Result{}
 }
 
 // This is the cost of the Operation. The cost of a single Operation cannot exceed the rate limiter's MaxCapacity or a `TooExpensiveError`
@@ -56,6 +401,29 @@ func (o *operation) Cost() uint32 {
 	return o.cost
 }
 
+// This is the cost of the Operation in the provided dimension. If the Operation was not created with NewOperationWithCosts(), it costs
+// the same in every dimension, so this returns Cost().
+func (o *operation) CostOf(dimension string) uint32 {
+	if o.costs == nil {
+		return o.cost
+	}
+	return o.costs[dimension]
+}
+
+// You may provide the span context the Operation originated from. When the Batcher has a TracerProvider (see
+// Batcher.WithTracerProvider()), the span for the batch the Operation is dispatched in is linked to it. Batcher.EnqueueWithContext()
+// sets this to the span it creates for the enqueue unless it was already set, so you only need this for Operations enqueued without a
+// context. This should be set before the Operation is enqueued.
+func (o *operation) WithSpanContext(sc trace.SpanContext) Operation {
+	o.span = sc
+	return o
+}
+
+// This returns the span context provided by WithSpanContext() or an invalid span context if there is none.
+func (o *operation) SpanContext() trace.SpanContext {
+	return o.span
+}
+
 // This is the Watcher associated with this Operation. Operations are batched by Watcher.
 func (o *operation) Watcher() Watcher {
 	return o.watcher
@@ -65,3 +433,74 @@ func (o *operation) Watcher() Watcher {
 func (o *operation) IsBatchable() bool {
 	return o.batchable
 }
+
+// The Watcher may call this method to record the outcome of processing the Operation (for instance, `op.SetResult(Failed(err))`). If
+// the Watcher does not set a Result, the Operation is considered to have succeeded when the Watcher returns.
+func (o *operation) SetResult(result Result) {
+	o.resultMutex.Lock()
+	defer o.resultMutex.Unlock()
+	o.result = result
+}
+
+// This returns the Result of the most recent attempt. While the Operation is in the buffer or is being processed, the Status will
+// be ResultPending unless the Watcher has already called SetResult().
+func (o *operation) Result() Result {
+	o.resultMutex.Lock()
+	defer o.resultMutex.Unlock()
+	return o.result
+}
+
+// This returns a channel that is closed once the Operation has a final Result (see WithOnComplete). This allows the code that
+// enqueued the Operation to wait for it to be processed.
+func (o *operation) Done() <-chan struct{} {
+	return o.done
+}
+
+// This is used internally by Batcher to record the Result once a batch is done. You should generally not call this method, but you might
+// mock it for unit tests.
+func (o *operation) Complete(result Result, final bool) {
+	o.resultMutex.Lock()
+	o.result = result
+	if !final {
+		o.resultMutex.Unlock()
+		return
+	}
+	select {
+	case <-o.done:
+		// already final
+		o.resultMutex.Unlock()
+		return
+	default:
+		close(o.done)
+	}
+	o.resultMutex.Unlock()
+	if o.onComplete != nil {
+		o.onComplete(o, result)
+	}
+}
+
+// This completes each follower with the final Result of the leader, so that the callers of Operations that were merged into the leader
+// see the Result of the Operation that was dispatched in their place. The leader must not be completing concurrently.
+func follow(leader Operation, followers []Operation) {
+	if len(followers) == 0 {
+		return
+	}
+	chain(leader, func(_ Operation, result Result) {
+		for _, follower := range followers {
+			follower.Complete(result, true)
+		}
+	})
+}
+
+// This adds fn to be called after any completion callback the Operation already has. The Operation must not be completing concurrently.
+func chain(op Operation, fn func(op Operation, result Result)) {
+	if o, ok := op.(*operation); ok && o.onComplete != nil {
+		previous := o.onComplete
+		o.onComplete = func(op Operation, result Result) {
+			previous(op, result)
+			fn(op, result)
+		}
+	} else {
+		op.WithOnComplete(fn)
+	}
+}
@@ -0,0 +1,117 @@
+package batcher
+
+import "sync"
+
+// orderedKeys tracks which keys (see Operation.WithKey()) may be dispatched when WithOrderedKeys() is used. A key is busy while a batch
+// containing it has not been finished by its Watcher. During a flush, a key is blocked once an Operation with that key is left in the
+// buffer so that none of the Operations after it are dispatched ahead of it, and a key is open while it is in a batch that has not been
+// raised yet.
+type orderedKeys struct {
+	mutex sync.Mutex
+	busy  map[batchKey]int
+
+	// only used by the processing loop
+	blocked map[batchKey]bool
+	open    map[batchKey]bool
+}
+
+func newOrderedKeys() *orderedKeys {
+	return &orderedKeys{
+		busy: make(map[batchKey]int),
+	}
+}
+
+// Operations are ordered per Watcher and key; Operations without a key are not ordered.
+func orderingKey(op Operation) (batchKey, bool) {
+	key := op.Key()
+	if key == "" {
+		return batchKey{}, false
+	}
+	return batchKey{watcher: op.Watcher(), key: key}, true
+}
+
+// This is called at the start of each flush to forget which keys were blocked or open in the previous flush.
+func (k *orderedKeys) startFlush() {
+	k.blocked = make(map[batchKey]bool)
+	k.open = make(map[batchKey]bool)
+}
+
+// This marks the keys of the Operations as busy and returns them so they can be released once the Watcher has finished.
+func (k *orderedKeys) acquire(ops []Operation) []batchKey {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	var keys []batchKey
+	for _, op := range ops {
+		key, ok := orderingKey(op)
+		if !ok {
+			continue
+		}
+		duplicate := false
+		for _, existing := range keys {
+			if existing == key {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			keys = append(keys, key)
+			k.busy[key]++
+		}
+	}
+	return keys
+}
+
+// This releases the keys returned by acquire().
+func (k *orderedKeys) release(keys []batchKey) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	for _, key := range keys {
+		if k.busy[key] <= 1 {
+			delete(k.busy, key)
+		} else {
+			k.busy[key]--
+		}
+	}
+}
+
+// This prevents the rest of the Operations with the same keys from being dispatched in the current flush.
+func (k *orderedKeys) block(ops ...Operation) {
+	for _, op := range ops {
+		if key, ok := orderingKey(op); ok {
+			k.blocked[key] = true
+		}
+	}
+}
+
+// This records that the Operation is in a batch that has not been raised yet.
+func (k *orderedKeys) add(op Operation) {
+	if key, ok := orderingKey(op); ok {
+		k.open[key] = true
+	}
+}
+
+// This is TRUE if the Operation must be left in the buffer because an Operation with the same key is in a batch that the Watcher has
+// not finished with or was left in the buffer by the current flush. If alone is TRUE, the Operation would be raised in a batch by itself,
+// so it must also wait for a batch with the same key that has not been raised yet.
+func (k *orderedKeys) mustWait(op Operation, alone bool) bool {
+	key, ok := orderingKey(op)
+	if !ok {
+		return false
+	}
+	if k.blocked[key] || (alone && k.open[key]) {
+		return true
+	}
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	return k.busy[key] > 0
+}
+
+// This is TRUE if any of the Operations must wait (see mustWait()).
+func (k *orderedKeys) anyMustWait(ops []Operation) bool {
+	for _, op := range ops {
+		if k.mustWait(op, false) {
+			return true
+		}
+	}
+	return false
+}
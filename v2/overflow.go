@@ -0,0 +1,173 @@
+package batcher
+
+import (
+	"encoding/binary"
+	"os"
+	"sync/atomic"
+)
+
+// An overflow holds the Operations that were enqueued while the Buffer was full, in the order they were enqueued. The Operations stay in
+// memory (so their callbacks and Done() still work), but their payloads are appended to the file and only read back when the Operations
+// are replayed into the Buffer. Once every Operation has been replayed, the file is truncated. It is only used while the lock of the
+// Buffer is held.
+type overflow struct {
+	path    string
+	codec   PayloadCodec
+	file    *os.File
+	offset  int64
+	entries []*overflowEntry
+	count   *uint32 // owned by the Buffer and written with atomics so that it can be read without the lock
+}
+
+type overflowEntry struct {
+	op  Operation
+	err error // set if the payload could not be read back
+}
+
+func newOverflow(path string, codec PayloadCodec, count *uint32) *overflow {
+	return &overflow{
+		path:  path,
+		codec: codec,
+		count: count,
+	}
+}
+
+func (o *overflow) size() uint32 {
+	return atomic.LoadUint32(o.count)
+}
+
+// This appends the payload of the Operation to the file (which is created the first time it is needed) and adds the Operation to the
+// tail of the overflow. Only payloads of Operations created by this package are written; any other Operation keeps its payload in
+// memory, but still waits its turn.
+func (o *overflow) push(op Operation) error {
+	entry := &overflowEntry{op: op}
+	if concrete, ok := op.(*operation); ok {
+		if err := o.write(concrete, entry); err != nil {
+			return err
+		}
+	}
+	o.entries = append(o.entries, entry)
+	atomic.AddUint32(o.count, 1)
+	return nil
+}
+
+func (o *overflow) write(op *operation, entry *overflowEntry) error {
+	op.payloadMutex.Lock()
+	deferred := op.produce != nil
+	payload := op.payload
+	op.payloadMutex.Unlock()
+	if deferred {
+		return nil
+	}
+	data, err := o.codec.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if o.file == nil {
+		file, err := os.OpenFile(o.path, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		o.file = file
+	}
+	record := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(record, uint32(len(data)))
+	copy(record[4:], data)
+	if _, err := o.file.WriteAt(record, o.offset); err != nil {
+		return err
+	}
+	offset := o.offset + 4
+	o.offset += int64(len(record))
+
+	// the payload is read back the first time it is needed, whether by the replay or by the caller
+	file, codec, length := o.file, o.codec, len(data)
+	op.spill(func() interface{} {
+		data := make([]byte, length)
+		if _, err := file.ReadAt(data, offset); err != nil {
+			entry.err = err
+			return nil
+		}
+		payload, err := codec.Unmarshal(data)
+		if err != nil {
+			entry.err = err
+		}
+		return payload
+	})
+	return nil
+}
+
+// This removes the Operation at the head of the overflow and reads its payload back. It returns the error if the payload could not be
+// read back.
+func (o *overflow) pop() (Operation, error) {
+	entry := o.entries[0]
+	o.entries[0] = nil
+	o.entries = o.entries[1:]
+	atomic.AddUint32(o.count, ^uint32(0))
+	entry.op.Payload()
+	o.truncateIfEmpty()
+	return entry.op, entry.err
+}
+
+// This removes the Operation from the overflow (reading its payload back) and returns true if it was found.
+func (o *overflow) remove(op Operation) bool {
+	for i, entry := range o.entries {
+		if entry.op != op {
+			continue
+		}
+		o.entries = append(o.entries[:i], o.entries[i+1:]...)
+		atomic.AddUint32(o.count, ^uint32(0))
+		op.Payload()
+		o.truncateIfEmpty()
+		return true
+	}
+	return false
+}
+
+// This removes the provided Operations from the overflow (reading their payloads back) and returns the ones that were removed.
+func (o *overflow) purge(ops map[Operation]bool) []Operation {
+	var removed []Operation
+	kept := o.entries[:0]
+	for _, entry := range o.entries {
+		if !ops[entry.op] {
+			kept = append(kept, entry)
+			continue
+		}
+		entry.op.Payload()
+		removed = append(removed, entry.op)
+		atomic.AddUint32(o.count, ^uint32(0))
+	}
+	for i := len(kept); i < len(o.entries); i++ {
+		o.entries[i] = nil
+	}
+	o.entries = kept
+	o.truncateIfEmpty()
+	return removed
+}
+
+// Once every payload has been read back, the file is emptied so that it does not keep growing.
+func (o *overflow) truncateIfEmpty() {
+	if len(o.entries) > 0 || o.file == nil || o.offset == 0 {
+		return
+	}
+	if err := o.file.Truncate(0); err == nil {
+		o.offset = 0
+	}
+}
+
+// This reads every payload back, removes the file, and returns the Operations that were in the overflow.
+func (o *overflow) close() []Operation {
+	ops := make([]Operation, 0, len(o.entries))
+	for _, entry := range o.entries {
+		entry.op.Payload()
+		ops = append(ops, entry.op)
+	}
+	o.entries = nil
+	atomic.StoreUint32(o.count, 0)
+	if o.file != nil {
+		_ = o.file.Close()
+		_ = os.Remove(o.path)
+		o.file = nil
+		o.offset = 0
+	}
+	return ops
+}
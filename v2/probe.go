@@ -0,0 +1,19 @@
+package batcher
+
+import "net/http"
+
+// This wraps a health check (such as Batcher.Healthy or SharedResource.Ready) as an http.HandlerFunc for a Kubernetes probe. It responds
+// with 200 (OK) if the check returns nil and with 503 (Service Unavailable) and the error otherwise. For example,
+// `mux.Handle("/readyz", ProbeHandler(res.Ready))`.
+func ProbeHandler(check func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if err := check(); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok"))
+	}
+}
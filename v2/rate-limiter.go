@@ -1,11 +1,30 @@
 package batcher
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 type RateLimiter interface {
 	Eventer
 	MaxCapacity() uint32
 	Capacity() uint32
 	GiveMe(target uint32)
+	Reserve(cost uint32, ttl time.Duration) (ReservationHandle, error)
+	WaitForCapacity(ctx context.Context, cost uint32) error
 	Start(ctx context.Context) error
 }
+
+// A ReservationHandle represents capacity that was set aside by RateLimiter.Reserve(). The capacity is returned to the RateLimiter
+// when Release() is called or the reservation expires, whichever comes first.
+type ReservationHandle interface {
+	Cost() uint32
+	Release()
+}
+
+// A RateLimiter may optionally implement RenewingRateLimiter to expose when its capacity renews. Batcher can then align its capacity
+// requests and flushes to those boundaries (see Batcher.WithAlignToRenewal()).
+type RenewingRateLimiter interface {
+	RateLimiter
+	NextRenewal() time.Time
+}
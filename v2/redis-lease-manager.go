@@ -0,0 +1,130 @@
+package batcher
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// RedisClient describes the only Redis commands that RedisLeaseManager needs so that this package does not depend on a Redis client.
+// SetNX must set the key to the value with the ttl only if the key does not exist (`SET key value NX PX ttl`) and return TRUE if it was
+// set. Eval must run the Lua script with the keys and args (`EVAL script numkeys key... arg...`) and return its reply; it is used to
+// renew and release leases only if they are still held by this instance. For example, with github.com/redis/go-redis/v9 the adapters
+// are `return c.SetNX(ctx, key, value, ttl).Result()` and `return c.Eval(ctx, script, keys, args...).Result()`.
+type RedisClient interface {
+	SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error)
+	Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error)
+}
+
+// This script extends the TTL of the key only if it is still held by the instance.
+const redisRenewScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("PEXPIRE", KEYS[1], ARGV[2]) else return 0 end`
+
+// This script deletes the key only if it is still held by the instance.
+const redisReleaseScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) else return 0 end`
+
+type redisLeaseManager struct {
+
+	// configuration items that should not change after Provision()
+	eventer   Eventer
+	client    RedisClient
+	keyPrefix string
+}
+
+// This method creates a new RedisLeaseManager to allow the SharedResource to use Redis to manage leases across instances. Each
+// partition is a key named "<keyPrefix>:<index>" that is leased by setting it (if it does not exist) to the ID of the instance with
+// a TTL, so you should use a keyPrefix that is unique to the capacity being shared.
+func NewRedisLeaseManager(client RedisClient, keyPrefix string) LeaseManager {
+	return &redisLeaseManager{
+		client:    client,
+		keyPrefix: keyPrefix,
+	}
+}
+
+// Events raised by RedisLeaseManager must be raised to an Eventer. Specifically the SharedResource it is associated with will be used as
+// the Eventer. This method is called in SharedResource.WithSharedCapacity().
+func (m *redisLeaseManager) RaiseEventsTo(e Eventer) {
+	m.eventer = e
+}
+
+// There is nothing to provision since the keys are created when they are leased.
+func (m *redisLeaseManager) Provision(ctx context.Context) error {
+	return nil
+}
+
+// There is nothing to create since the keys are created when they are leased.
+func (m *redisLeaseManager) CreatePartitions(ctx context.Context, count int) {}
+
+// This is called by SharedResource when it needs to lease partitions for capacity.
+func (m *redisLeaseManager) LeasePartition(ctx context.Context, id string, index uint32) (leaseTime time.Duration) {
+	secondsToLease := 15
+
+	// attempt to allocate the partition
+	key := m.partitionKey(index)
+	ok, err := m.client.SetNX(ctx, key, id, time.Duration(secondsToLease)*time.Second)
+	switch {
+	case err != nil:
+		lerr := newLeaseError(LeaseOperationAcquireLease, int(index), err)
+		m.eventer.Emit(ErrorEvent, 0, lerr.Error(), lerr)
+		return
+	case !ok:
+		// you cannot allocate a lease that is already assigned; try again in a bit
+		m.eventer.Emit(FailedEvent, int(index), "", nil)
+		return
+	}
+
+	// return the lease time
+	leaseTime = time.Duration(secondsToLease) * time.Second
+
+	return
+}
+
+// This is called by SharedResource to renew the lease on a partition it still needs. The TTL is only extended if the key is still held
+// by this instance; otherwise a FailedEvent is raised and the partition must be leased again.
+func (m *redisLeaseManager) RenewPartition(ctx context.Context, id string, index uint32) (leaseTime time.Duration) {
+	secondsToLease := 15
+
+	// attempt to extend the lease
+	reply, err := m.client.Eval(ctx, redisRenewScript, []string{m.partitionKey(index)}, id, secondsToLease*1000)
+	switch {
+	case err != nil:
+		lerr := newLeaseError(LeaseOperationRenewLease, int(index), err)
+		m.eventer.Emit(ErrorEvent, 0, lerr.Error(), lerr)
+		return
+	case !isRedisOne(reply):
+		// the lease expired or is held by another instance
+		m.eventer.Emit(FailedEvent, int(index), "", nil)
+		return
+	}
+
+	// return the lease time
+	leaseTime = time.Duration(secondsToLease) * time.Second
+
+	return
+}
+
+// This is called by SharedResource to release the lease on a partition it no longer needs so that another instance can lease it without
+// waiting for the lease to expire. The key is only deleted if it is still held by this instance.
+func (m *redisLeaseManager) ReleasePartition(ctx context.Context, id string, index uint32) {
+	if _, err := m.client.Eval(ctx, redisReleaseScript, []string{m.partitionKey(index)}, id); err != nil {
+		lerr := newLeaseError(LeaseOperationReleaseLease, int(index), err)
+		m.eventer.Emit(ErrorEvent, 0, lerr.Error(), lerr)
+	}
+}
+
+func (m *redisLeaseManager) partitionKey(index uint32) string {
+	return fmt.Sprintf("%s:%d", m.keyPrefix, index)
+}
+
+// Redis replies to the scripts with an integer, which clients may decode as any integer type.
+func isRedisOne(reply interface{}) bool {
+	switch v := reply.(type) {
+	case int64:
+		return v == 1
+	case int:
+		return v == 1
+	case uint64:
+		return v == 1
+	default:
+		return false
+	}
+}
@@ -0,0 +1,173 @@
+package batcher_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeRedis struct {
+	mutex   sync.Mutex
+	values  map[string]string
+	expires map[string]time.Time
+	err     error
+}
+
+func newFakeRedis() *fakeRedis {
+	return &fakeRedis{
+		values:  make(map[string]string),
+		expires: make(map[string]time.Time),
+	}
+}
+
+func (r *fakeRedis) SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.err != nil {
+		return false, r.err
+	}
+	if _, ok := r.values[key]; ok && time.Now().Before(r.expires[key]) {
+		return false, nil
+	}
+	r.values[key] = value
+	r.expires[key] = time.Now().Add(ttl)
+	return true, nil
+}
+
+// Eval interprets the compare-and-pexpire and compare-and-del scripts that RedisLeaseManager uses.
+func (r *fakeRedis) Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.err != nil {
+		return nil, r.err
+	}
+	key := keys[0]
+	if value, ok := r.values[key]; !ok || !time.Now().Before(r.expires[key]) || value != args[0].(string) {
+		return int64(0), nil
+	}
+	switch {
+	case strings.Contains(script, "PEXPIRE"):
+		r.expires[key] = time.Now().Add(time.Duration(args[1].(int)) * time.Millisecond)
+	case strings.Contains(script, "DEL"):
+		delete(r.values, key)
+		delete(r.expires, key)
+	}
+	return int64(1), nil
+}
+
+func (r *fakeRedis) expire(key string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.expires[key] = time.Now()
+}
+
+func TestRedisLeaseManager_LeasePartition_OnlyOneInstanceHoldsTheLease(t *testing.T) {
+	client := newFakeRedis()
+	mgr := gobatcher.NewRedisLeaseManager(client, "capacity")
+	eventer := &gobatcher.EventerBase{}
+	var failed []int
+	eventer.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		if event == gobatcher.FailedEvent {
+			failed = append(failed, val)
+		}
+	})
+	mgr.RaiseEventsTo(eventer)
+	assert.NoError(t, mgr.Provision(context.Background()))
+	mgr.CreatePartitions(context.Background(), 10)
+
+	assert.Equal(t, 15*time.Second, mgr.LeasePartition(context.Background(), "instance-a", 3), "expecting the first instance to get the lease")
+	assert.Equal(t, "instance-a", client.values["capacity:3"])
+	assert.Equal(t, time.Duration(0), mgr.LeasePartition(context.Background(), "instance-b", 3), "expecting the lease to already be held")
+	assert.Equal(t, []int{3}, failed, "expecting a failed event for the partition")
+	client.expire("capacity:3")
+	assert.Equal(t, 15*time.Second, mgr.LeasePartition(context.Background(), "instance-b", 3), "expecting the lease once it expired")
+	assert.Equal(t, "instance-b", client.values["capacity:3"])
+}
+
+func TestRedisLeaseManager_LeasePartition_RaisesErrors(t *testing.T) {
+	client := newFakeRedis()
+	client.err = errors.New("connection refused")
+	mgr := gobatcher.NewRedisLeaseManager(client, "capacity")
+	eventer := &gobatcher.EventerBase{}
+	var lerr *gobatcher.LeaseError
+	eventer.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		if event == gobatcher.ErrorEvent {
+			lerr = metadata.(*gobatcher.LeaseError)
+		}
+	})
+	mgr.RaiseEventsTo(eventer)
+	assert.Equal(t, time.Duration(0), mgr.LeasePartition(context.Background(), "instance-a", 1))
+	if assert.NotNil(t, lerr, "expecting an error event") {
+		assert.Equal(t, gobatcher.LeaseOperationAcquireLease, lerr.Operation)
+		assert.Equal(t, 1, lerr.Index)
+		assert.ErrorIs(t, lerr, client.err)
+	}
+}
+
+func TestRedisLeaseManager_RenewPartition_OnlyRenewsItsOwnLease(t *testing.T) {
+	client := newFakeRedis()
+	mgr := gobatcher.NewRedisLeaseManager(client, "capacity")
+	eventer := &gobatcher.EventerBase{}
+	var failed []int
+	eventer.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		if event == gobatcher.FailedEvent {
+			failed = append(failed, val)
+		}
+	})
+	mgr.RaiseEventsTo(eventer)
+	renewer, ok := mgr.(gobatcher.LeaseRenewer)
+	if !assert.True(t, ok, "expecting RedisLeaseManager to implement LeaseRenewer") {
+		return
+	}
+
+	assert.Equal(t, 15*time.Second, mgr.LeasePartition(context.Background(), "instance-a", 2))
+	client.expires["capacity:2"] = time.Now().Add(time.Second)
+	assert.Equal(t, 15*time.Second, renewer.RenewPartition(context.Background(), "instance-a", 2), "expecting the holder to renew")
+	assert.WithinDuration(t, time.Now().Add(15*time.Second), client.expires["capacity:2"], time.Second, "expecting the TTL to be extended")
+	assert.Equal(t, time.Duration(0), renewer.RenewPartition(context.Background(), "instance-b", 2), "expecting another instance not to renew")
+	assert.Equal(t, "instance-a", client.values["capacity:2"])
+	client.expire("capacity:2")
+	assert.Equal(t, time.Duration(0), renewer.RenewPartition(context.Background(), "instance-a", 2), "expecting an expired lease not to renew")
+	assert.Equal(t, []int{2, 2}, failed, "expecting a failed event for each renewal that did not happen")
+}
+
+func TestRedisLeaseManager_ReleasePartition_OnlyReleasesItsOwnLease(t *testing.T) {
+	client := newFakeRedis()
+	mgr := gobatcher.NewRedisLeaseManager(client, "capacity")
+	mgr.RaiseEventsTo(&gobatcher.EventerBase{})
+	releaser, ok := mgr.(gobatcher.LeaseReleaser)
+	if !assert.True(t, ok, "expecting RedisLeaseManager to implement LeaseReleaser") {
+		return
+	}
+
+	assert.Equal(t, 15*time.Second, mgr.LeasePartition(context.Background(), "instance-a", 4))
+	releaser.ReleasePartition(context.Background(), "instance-b", 4)
+	assert.Equal(t, "instance-a", client.values["capacity:4"], "expecting another instance not to release the lease")
+	releaser.ReleasePartition(context.Background(), "instance-a", 4)
+	_, held := client.values["capacity:4"]
+	assert.False(t, held, "expecting the holder to release the lease")
+	assert.Equal(t, 15*time.Second, mgr.LeasePartition(context.Background(), "instance-b", 4), "expecting the lease to be available right away")
+}
+
+func TestRedisLeaseManager_RenewAndRelease_RaiseErrors(t *testing.T) {
+	client := newFakeRedis()
+	client.err = errors.New("connection refused")
+	mgr := gobatcher.NewRedisLeaseManager(client, "capacity")
+	eventer := &gobatcher.EventerBase{}
+	var operations []string
+	eventer.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		if event == gobatcher.ErrorEvent {
+			operations = append(operations, metadata.(*gobatcher.LeaseError).Operation)
+		}
+	})
+	mgr.RaiseEventsTo(eventer)
+	assert.Equal(t, time.Duration(0), mgr.(gobatcher.LeaseRenewer).RenewPartition(context.Background(), "instance-a", 1))
+	mgr.(gobatcher.LeaseReleaser).ReleasePartition(context.Background(), "instance-a", 1)
+	assert.Equal(t, []string{gobatcher.LeaseOperationRenewLease, gobatcher.LeaseOperationReleaseLease}, operations)
+}
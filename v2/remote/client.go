@@ -0,0 +1,88 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+)
+
+// knownErrors allows the errors raised by the Server to be returned as the same sentinel errors by the Client.
+var knownErrors = []error{
+	gobatcher.BufferFullError,
+	gobatcher.BufferIsShutdown,
+	gobatcher.TooExpensiveError,
+	gobatcher.TooManyAttemptsError,
+	UnknownWatcherError,
+	InvalidRequestError,
+}
+
+// RemoteError is returned by the Client when the Server responded with an error that is not one of the sentinel errors.
+type RemoteError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("the server responded with %d: %s", e.StatusCode, e.Message)
+}
+
+// Client enqueues Operations into a Batcher exposed by a Server.
+type Client struct {
+	url        string
+	httpClient *http.Client
+}
+
+// This method creates a new Client for the Server at the provided URL (for instance, "http://localhost:8080" if the Server is mounted
+// at the root).
+func NewClient(url string) *Client {
+	return &Client{
+		url:        strings.TrimSuffix(url, "/") + EnqueuePath,
+		httpClient: http.DefaultClient,
+	}
+}
+
+// This allows you to provide the *http.Client used to make requests (for instance, to set a timeout or transport).
+func (c *Client) WithHTTPClient(httpClient *http.Client) *Client {
+	c.httpClient = httpClient
+	return c
+}
+
+// This enqueues an Operation for the Watcher registered under the label. It returns once the Operation is in the buffer. Errors raised
+// by the Batcher (for instance, BufferFullError) are returned as the same errors; use ctx to limit how long to wait on a full buffer.
+func (c *Client) Enqueue(ctx context.Context, watcher string, cost uint32, payload []byte, batchable bool) error {
+	body, err := json.Marshal(EnqueueRequest{
+		Watcher:   watcher,
+		Cost:      cost,
+		Batchable: batchable,
+		Payload:   payload,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusAccepted {
+		return nil
+	}
+	var errResp errorResponse
+	_ = json.NewDecoder(resp.Body).Decode(&errResp)
+	for _, known := range knownErrors {
+		if errResp.Error == known.Error() {
+			return known
+		}
+	}
+	return &RemoteError{StatusCode: resp.StatusCode, Message: errResp.Error}
+}
@@ -0,0 +1,69 @@
+package remote_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+	"github.com/plasne/go-batcher/v2/remote"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient_EnqueuesIntoServerBatcher(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := gobatcher.NewBatcher().
+		WithFlushInterval(1 * time.Millisecond)
+	payloads := make(chan []byte, 1)
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		for _, op := range batch {
+			payloads <- op.Payload().([]byte)
+		}
+	})
+	server := httptest.NewServer(remote.NewServer(batcher).WithWatcher("writes", watcher))
+	defer server.Close()
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	client := remote.NewClient(server.URL)
+	err = client.Enqueue(ctx, "writes", 1, []byte("hello"), true)
+	assert.NoError(t, err, "not expecting an enqueue error")
+	select {
+	case payload := <-payloads:
+		assert.Equal(t, []byte("hello"), payload)
+	case <-time.After(1 * time.Second):
+		assert.Fail(t, "expected the payload to be raised to the watcher")
+	}
+}
+
+func TestClient_ReturnsSentinelErrors(t *testing.T) {
+	batcher := gobatcher.NewBatcherWithBuffer(1).
+		WithErrorOnFullBuffer()
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {})
+	server := httptest.NewServer(remote.NewServer(batcher).WithWatcher("writes", watcher))
+	defer server.Close()
+	client := remote.NewClient(server.URL + "/")
+	err := client.Enqueue(context.Background(), "unknown", 1, nil, true)
+	assert.Equal(t, remote.UnknownWatcherError, err)
+	err = client.Enqueue(context.Background(), "writes", 1, nil, true)
+	assert.NoError(t, err, "not expecting an enqueue error")
+	err = client.Enqueue(context.Background(), "writes", 1, nil, true)
+	assert.Equal(t, gobatcher.BufferFullError, err)
+}
+
+func TestServer_RejectsBadRequests(t *testing.T) {
+	server := httptest.NewServer(remote.NewServer(gobatcher.NewBatcher()))
+	defer server.Close()
+	resp, err := http.Get(server.URL + remote.EnqueuePath)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+	resp, err = http.Post(server.URL+remote.EnqueuePath, "application/json", nil)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+}
@@ -0,0 +1,123 @@
+// Package remote exposes Enqueue() of an in-process Batcher over HTTP so that sidecar processes or scripts can feed Operations to a
+// Batcher owned by the main service without linking the whole application. Payloads are opaque bytes; the Watcher is selected by a
+// label that the service registers.
+package remote
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+)
+
+const (
+	// The Client appends this path to the URL it is given, so mount the Server at this path (for instance, mux.Handle(remote.EnqueuePath, server)).
+	EnqueuePath = "/enqueue"
+
+	defaultMaxBodySize = 1 << 20
+)
+
+var (
+	UnknownWatcherError = errors.New("no watcher is registered with that label.")
+	InvalidRequestError = errors.New("the enqueue request could not be read.")
+)
+
+// EnqueueRequest is the body of a request to the Server. The Payload is sent as base64 in JSON and is provided to the Watcher as a
+// []byte.
+type EnqueueRequest struct {
+	Watcher   string `json:"watcher"`
+	Cost      uint32 `json:"cost"`
+	Batchable bool   `json:"batchable"`
+	Payload   []byte `json:"payload"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Server is an http.Handler that enqueues the Operations it receives into a Batcher.
+type Server struct {
+	batcher     gobatcher.Batcher
+	maxBodySize int64
+
+	watcherMutex sync.RWMutex
+	watchers     map[string]gobatcher.Watcher
+}
+
+// This method creates a new Server that enqueues into the provided Batcher. You must register at least one Watcher with WithWatcher()
+// before any Operation can be enqueued.
+func NewServer(batcher gobatcher.Batcher) *Server {
+	return &Server{
+		batcher:     batcher,
+		maxBodySize: defaultMaxBodySize,
+		watchers:    make(map[string]gobatcher.Watcher),
+	}
+}
+
+// This registers a Watcher under a label. Remote clients refer to the Watcher by this label and every Operation they enqueue with it is
+// raised to this Watcher with a []byte payload.
+func (s *Server) WithWatcher(label string, watcher gobatcher.Watcher) *Server {
+	s.watcherMutex.Lock()
+	defer s.watcherMutex.Unlock()
+	s.watchers[label] = watcher
+	return s
+}
+
+// This determines the largest request body (in bytes) that the Server will accept. The default is 1 MiB.
+func (s *Server) WithMaxBodySize(val int64) *Server {
+	s.maxBodySize = val
+	return s
+}
+
+// This handles a POST of an EnqueueRequest. The Operation is enqueued with the request's context, so if the buffer is full the request
+// blocks until there is room or the client gives up. It responds with 202 (Accepted) once the Operation is in the buffer.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, errors.New(http.StatusText(http.StatusMethodNotAllowed)))
+		return
+	}
+
+	// read the request
+	var req EnqueueRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, s.maxBodySize)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, InvalidRequestError)
+		return
+	}
+
+	// find the watcher
+	s.watcherMutex.RLock()
+	watcher, ok := s.watchers[req.Watcher]
+	s.watcherMutex.RUnlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, UnknownWatcherError)
+		return
+	}
+
+	// enqueue
+	op := gobatcher.NewOperation(watcher, req.Cost, req.Payload, req.Batchable)
+	if err := s.batcher.EnqueueWithContext(r.Context(), op); err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func statusFor(err error) int {
+	switch {
+	case errors.Is(err, gobatcher.BufferFullError), errors.Is(err, gobatcher.BufferIsShutdown):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, gobatcher.TooExpensiveError), errors.Is(err, gobatcher.TooManyAttemptsError):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(errorResponse{Error: err.Error()})
+}
@@ -0,0 +1,66 @@
+package batcher
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// reservations tracks the capacity that has been reserved from a RateLimiter but not yet released or expired.
+type reservations struct {
+	mutex    sync.Mutex
+	reserved uint32
+}
+
+type reservation struct {
+	owner    *reservations
+	cost     uint32
+	released uint32
+	timer    *time.Timer
+}
+
+// This reserves the cost if the capacity already reserved is less than the capacity available over the ttl (capacity is per
+// second). Like a flush, a single reservation may exceed what remains so that an Operation costing more than the remainder is not
+// starved. The reservation is automatically released after the ttl.
+func (r *reservations) reserve(capacity, cost uint32, ttl time.Duration) (ReservationHandle, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	available := float64(capacity) * ttl.Seconds()
+	if cost > 0 && float64(r.reserved) >= available {
+		return nil, InsufficientCapacityError
+	}
+	r.reserved += cost
+	h := &reservation{owner: r, cost: cost}
+	h.timer = time.AfterFunc(ttl, h.expire)
+	return h, nil
+}
+
+func (r *reservations) release(cost uint32) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.reserved >= cost {
+		r.reserved -= cost
+	} else {
+		r.reserved = 0
+	}
+}
+
+// This returns the capacity that was reserved.
+func (h *reservation) Cost() uint32 {
+	return h.cost
+}
+
+// This returns the reserved capacity to the RateLimiter. It is safe to call more than once.
+func (h *reservation) Release() {
+	if atomic.CompareAndSwapUint32(&h.released, 0, 1) {
+		h.timer.Stop()
+		h.owner.release(h.cost)
+	}
+}
+
+// NOTE: the timer must not be touched here since it may not have been assigned yet when a short ttl fires
+func (h *reservation) expire() {
+	if atomic.CompareAndSwapUint32(&h.released, 0, 1) {
+		h.owner.release(h.cost)
+	}
+}
@@ -0,0 +1,67 @@
+package batcher
+
+import "time"
+
+type ResultStatus int
+
+const (
+	// The Operation has not been raised to a Watcher or the Watcher has not finished with it yet.
+	ResultPending ResultStatus = iota
+	// The Watcher finished with the Operation without reporting a failure.
+	ResultSucceeded
+	// The Watcher reported that the Operation failed.
+	ResultFailed
+	// The Watcher did not finish the batch before MaxOperationTime.
+	ResultAbandoned
+	// The Operation expired in the buffer before it was dispatched (see Operation.WithExpiry).
+	ResultExpired
+	// The Operation was removed from the buffer by Batcher.Cancel() before it was dispatched.
+	ResultCancelled
+	// The Operation was dropped because the buffer was full (see Batcher.WithFullBufferPolicy).
+	ResultDropped
+	// The Operation was removed from the buffer by Batcher.Purge() before it was dispatched.
+	ResultPurged
+)
+
+func (s ResultStatus) String() string {
+	switch s {
+	case ResultSucceeded:
+		return "succeeded"
+	case ResultFailed:
+		return "failed"
+	case ResultAbandoned:
+		return "abandoned"
+	case ResultExpired:
+		return "expired"
+	case ResultCancelled:
+		return "cancelled"
+	case ResultDropped:
+		return "dropped"
+	case ResultPurged:
+		return "purged"
+	default:
+		return "pending"
+	}
+}
+
+// Result describes the outcome of a single attempt at processing an Operation. The Watcher may attach a Result to each Operation
+// in a batch by calling SetResult() (typically with Status, Err, and optionally ActualCost). When the batch is done, Batcher fills
+// in the rest (Duration, Attempt, and defaults for anything not provided) and the Result is then available from Operation.Result(),
+// the completion callback, and the dead-letter event.
+type Result struct {
+	Status     ResultStatus
+	Err        error
+	ActualCost uint32
+	Duration   time.Duration
+	Attempt    uint32
+}
+
+// This is a convenience method for creating a successful Result.
+func Succeeded() Result {
+	return Result{Status: ResultSucceeded}
+}
+
+// This is a convenience method for creating a failed Result with the provided error.
+func Failed(err error) Result {
+	return Result{Status: ResultFailed, Err: err}
+}
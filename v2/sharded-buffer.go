@@ -0,0 +1,372 @@
+package batcher
+
+import (
+	"context"
+	"math/rand"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// A shardedBuffer is a Buffer whose Operations are enqueued without acquiring its lock. Each Operation is appended to one of several
+// shards (each with its own lock) and the shards are collected into the Buffer (in the order the Operations were enqueued) whenever the
+// processing loop looks at the Buffer, so producers only contend with the producers that chose the same shard. Room in the Buffer is
+// handed out to the shards as a quota each time they are collected, and Operations are reserved against the quota of their shard so that
+// the Buffer never holds more than its max without producers sharing a counter. Features that must see the Buffer to decide what to do
+// with an Operation (deduplication, drop policies, the overflow, and flushing on cost or size) are not compatible with that, so when any
+// of them is configured, Operations are enqueued while holding the lock as usual.
+type shardedBuffer struct {
+	*buffer
+	shards []bufferShard
+	seq    uint64 // the sequence of the last Operation appended to a shard; written with atomics while holding the lock of the shard
+	closed uint32 // set to 1 (before the shards are collected for the last time) once the Buffer is shutdown
+	bypass uint32 // set to 1 if the configuration requires Operations to be enqueued while holding the lock
+}
+
+type bufferShard struct {
+	mutex  sync.Mutex
+	ops    []shardedOperation
+	state  uint64   // the Operations reserved for the shard (low 32 bits) and its quota (high 32 bits); written with atomics
+	memory uint64   // the estimated bytes held by the Operations in the shard; written with atomics
+	_      [64]byte // keep the shards on separate cache lines
+}
+
+type shardedOperation struct {
+	seq uint64
+	op  Operation
+}
+
+// This method creates a shardedBuffer that holds the Operations of the provided Buffer and has its configuration. There is a shard for
+// each processor that can execute goroutines at the same time (see runtime.GOMAXPROCS()).
+func newShardedBuffer(b *buffer) *shardedBuffer {
+	s := &shardedBuffer{
+		buffer: b,
+		shards: make([]bufferShard, runtime.GOMAXPROCS(0)),
+	}
+	s.configured()
+	s.collectShards()
+	return s
+}
+
+// This determines whether the configuration of the Buffer allows Operations to be enqueued without holding the lock. It must be called
+// after anything is configured.
+func (s *shardedBuffer) configured() {
+	s.buffer.lock.Lock()
+	defer s.buffer.lock.Unlock()
+	if s.buffer.dedup || s.buffer.policy.drops() || s.buffer.overflow != nil || s.buffer.threshold > 0 ||
+		s.buffer.fills != nil {
+		atomic.StoreUint32(&s.bypass, 1)
+	} else {
+		atomic.StoreUint32(&s.bypass, 0)
+	}
+}
+
+// This returns the number of Operations reserved for the shards, which includes those that are being appended.
+func (s *shardedBuffer) pending() uint32 {
+	var pending uint32
+	for i := range s.shards {
+		pending += uint32(atomic.LoadUint64(&s.shards[i].state))
+	}
+	return pending
+}
+
+// This returns the number of Operations in the Buffer and its shards. The shards are loaded before the length of the Buffer and a
+// collection links the Operations into the Buffer before they stop being reserved, so an Operation is never counted as neither.
+func (s *shardedBuffer) size() uint32 {
+	pending := s.pending()
+	return pending + s.buffer.size()
+}
+
+// This returns the estimated bytes held by the Operations in the Buffer and its shards.
+func (s *shardedBuffer) estimatedMemory() uint64 {
+	memory := s.buffer.estimatedMemory()
+	for i := range s.shards {
+		memory += atomic.LoadUint64(&s.shards[i].memory)
+	}
+	return memory
+}
+
+// This reserves room for an Operation in one of the shards (starting with a random one so producers spread out) and returns that shard,
+// or nil if every shard has used its quota. If so, the shards are collected to hand out the room made since the last collection and
+// the reservation is tried once more before the Buffer is considered full.
+func (s *shardedBuffer) reserve() *bufferShard {
+	if shard := s.tryReserve(); shard != nil {
+		return shard
+	}
+	if s.buffer.unbounded {
+		return nil
+	}
+	s.collectShards()
+	return s.tryReserve()
+}
+
+func (s *shardedBuffer) tryReserve() *bufferShard {
+	start := rand.Intn(len(s.shards))
+	for i := range s.shards {
+		shard := &s.shards[(start+i)%len(s.shards)]
+		if s.buffer.unbounded {
+			atomic.AddUint64(&shard.state, 1)
+			return shard
+		}
+		for {
+			state := atomic.LoadUint64(&shard.state)
+			if uint32(state) >= uint32(state>>32) {
+				break
+			}
+			if atomic.CompareAndSwapUint64(&shard.state, state, state+1) {
+				return shard
+			}
+		}
+	}
+	return nil
+}
+
+// This gives back the room reserved in the shard for an Operation that was not appended and wakes anyone waiting for it.
+func (s *shardedBuffer) unreserve(shard *bufferShard) {
+	atomic.AddUint64(&shard.state, ^uint64(0))
+	s.buffer.lock.Lock()
+	s.buffer.notFull.Broadcast()
+	s.buffer.lock.Unlock()
+}
+
+// This appends the Operation to the shard in which room was reserved for it. The sequence is taken while holding the lock of the shard
+// and the shards are collected while holding all of their locks, so every Operation with an earlier sequence is collected first.
+func (s *shardedBuffer) push(shard *bufferShard, op Operation) error {
+	shard.mutex.Lock()
+	if atomic.LoadUint32(&s.closed) == 1 {
+		shard.mutex.Unlock()
+		s.unreserve(shard)
+		return BufferIsShutdown
+	}
+	seq := atomic.AddUint64(&s.seq, 1)
+	shard.ops = append(shard.ops, shardedOperation{seq: seq, op: op})
+	atomic.AddUint64(&shard.memory, estimatedMemoryOf(op))
+	shard.mutex.Unlock()
+	return nil
+}
+
+// This blocks until there might be room in the Buffer. It returns BufferIsShutdown if the Buffer is shutdown or the context's error
+// when the context is done.
+func (s *shardedBuffer) wait(ctx context.Context) error {
+	s.buffer.lock.Lock()
+	defer s.buffer.lock.Unlock()
+
+	// wake the waiters if the context is done while waiting
+	if ctx.Done() != nil {
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-ctx.Done():
+				s.buffer.lock.Lock()
+				s.buffer.notFull.Broadcast()
+				s.buffer.lock.Unlock()
+			case <-stop:
+			}
+		}()
+	}
+
+	for !s.buffer.isShutdown && s.size() >= s.buffer.cap {
+		if err := ctx.Err(); err != nil {
+			// pass on a signal that might have been meant for another waiter
+			s.buffer.notFull.Signal()
+			return err
+		}
+		s.buffer.notFull.Wait()
+	}
+	if s.buffer.isShutdown {
+		return BufferIsShutdown
+	}
+	return nil
+}
+
+// This moves the Operations in the shards into the Buffer in the order they were enqueued and hands out the room left in the Buffer to
+// the shards as their quotas. The lock must be held.
+func (s *shardedBuffer) collectLocked() {
+	for i := range s.shards {
+		s.shards[i].mutex.Lock()
+	}
+	var collected []shardedOperation
+	for i := range s.shards {
+		collected = append(collected, s.shards[i].ops...)
+	}
+	sort.Slice(collected, func(i, j int) bool {
+		return collected[i].seq < collected[j].seq
+	})
+	for _, entry := range collected {
+		s.buffer.link(entry.op)
+	}
+
+	// the Operations are in the Buffer before they stop being reserved so that they are always counted; the quota of each shard is
+	// frozen at what it has reserved (for Operations that are still being appended) until the room left is handed out
+	var reserved uint32
+	for i := range s.shards {
+		shard := &s.shards[i]
+		var memory uint64
+		for j := range shard.ops {
+			memory += estimatedMemoryOf(shard.ops[j].op)
+			shard.ops[j] = shardedOperation{}
+		}
+		collectedFromShard := uint32(len(shard.ops))
+		shard.ops = shard.ops[:0]
+		atomic.AddUint64(&shard.memory, ^(memory - 1))
+		for {
+			state := atomic.LoadUint64(&shard.state)
+			count := uint32(state) - collectedFromShard
+			if atomic.CompareAndSwapUint64(&shard.state, state, uint64(count)<<32|uint64(count)) {
+				reserved += count
+				break
+			}
+		}
+	}
+	if !s.buffer.unbounded {
+		var room uint32
+		if used := s.buffer.size() + reserved; used < s.buffer.cap {
+			room = s.buffer.cap - used
+		}
+		for i := range s.shards {
+			share := room / uint32(len(s.shards))
+			if uint32(i) < room%uint32(len(s.shards)) {
+				share++
+			}
+			atomic.AddUint64(&s.shards[i].state, uint64(share)<<32)
+		}
+	}
+
+	for i := range s.shards {
+		s.shards[i].mutex.Unlock()
+	}
+}
+
+func (s *shardedBuffer) collectShards() {
+	s.buffer.lock.Lock()
+	defer s.buffer.lock.Unlock()
+	s.collectLocked()
+}
+
+func (s *shardedBuffer) enqueue(op Operation, errorOnFull bool) error {
+	return s.enqueueWithContext(context.Background(), op, errorOnFull)
+}
+
+// This is the same as buffer.enqueueWithContext() except that the Operation is appended to a shard rather than the Buffer (unless the
+// configuration requires the lock).
+func (s *shardedBuffer) enqueueWithContext(ctx context.Context, op Operation, errorOnFull bool) error {
+	if atomic.LoadUint32(&s.bypass) == 1 {
+		s.collectShards()
+		return s.buffer.enqueueWithContext(ctx, op, errorOnFull)
+	}
+	if atomic.LoadUint32(&s.closed) == 1 {
+		return BufferIsShutdown
+	}
+	shard := s.reserve()
+	for shard == nil {
+		if errorOnFull {
+			return BufferFullError
+		}
+		if err := s.wait(ctx); err != nil {
+			return err
+		}
+		shard = s.reserve()
+	}
+	return s.push(shard, op)
+}
+
+// This is the same as buffer.enqueueMany() except that each Operation is appended to a shard rather than the Buffer (unless the
+// configuration requires the lock).
+func (s *shardedBuffer) enqueueMany(ops []Operation, errorOnFull bool) []error {
+	if atomic.LoadUint32(&s.bypass) == 1 {
+		s.collectShards()
+		return s.buffer.enqueueMany(ops, errorOnFull)
+	}
+	for i, op := range ops {
+		if err := s.enqueue(op, errorOnFull); err != nil {
+			errs := make([]error, len(ops))
+			for ; i < len(ops); i++ {
+				errs[i] = err
+			}
+			return errs
+		}
+	}
+	return nil
+}
+
+// The processing loop collects the shards whenever it starts to move through the Buffer.
+func (s *shardedBuffer) top() Operation {
+	s.collectShards()
+	return s.buffer.top()
+}
+
+func (s *shardedBuffer) cancel(op Operation) bool {
+	s.collectShards()
+	return s.buffer.cancel(op)
+}
+
+func (s *shardedBuffer) each(fn func(Operation)) {
+	s.collectShards()
+	s.buffer.each(fn)
+}
+
+func (s *shardedBuffer) snapshot() []Operation {
+	s.collectShards()
+	return s.buffer.snapshot()
+}
+
+func (s *shardedBuffer) purge(ops map[Operation]bool) []Operation {
+	s.collectShards()
+	return s.buffer.purge(ops)
+}
+
+func (s *shardedBuffer) replay() map[Operation]error {
+	s.collectShards()
+	return s.buffer.replay()
+}
+
+func (s *shardedBuffer) orderByDeadline() {
+	s.collectShards()
+	s.buffer.orderByDeadline()
+}
+
+func (s *shardedBuffer) deduplicate(merge func(buffered, incoming Operation) Operation) {
+	s.collectShards()
+	s.buffer.deduplicate(merge)
+	s.configured()
+}
+
+func (s *shardedBuffer) dropWhenFull(policy FullBufferPolicy) {
+	s.collectShards()
+	s.buffer.dropWhenFull(policy)
+	s.configured()
+}
+
+func (s *shardedBuffer) overflowTo(path string, codec PayloadCodec) {
+	s.collectShards()
+	s.buffer.overflowTo(path, codec)
+	s.configured()
+}
+
+func (s *shardedBuffer) flushOnCost(threshold uint32, onCrossed func()) {
+	s.collectShards()
+	s.buffer.flushOnCost(threshold, onCrossed)
+	s.configured()
+}
+
+func (s *shardedBuffer) flushOnSize(onFilled func(Watcher)) {
+	s.collectShards()
+	s.buffer.flushOnSize(onFilled)
+	s.configured()
+}
+
+// The Operations in the shards are returned along with those in the Buffer. Once the shards are closed, nothing more can be appended to
+// them.
+func (s *shardedBuffer) shutdown() []Operation {
+	s.buffer.lock.Lock()
+	atomic.StoreUint32(&s.closed, 1)
+	s.collectLocked()
+	s.buffer.lock.Unlock()
+	dropped := s.buffer.shutdown()
+	s.buffer.lock.Lock()
+	s.buffer.notFull.Broadcast()
+	s.buffer.lock.Unlock()
+	return dropped
+}
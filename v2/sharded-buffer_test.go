@@ -0,0 +1,158 @@
+package batcher
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShardedBuffer_OperationsAreInTheOrderTheyWereEnqueued(t *testing.T) {
+	buffer := newShardedBuffer(newBuffer(3).(*buffer))
+	watcher := NewWatcher(func(batch []Operation) {})
+	ops := []Operation{
+		NewOperation(watcher, 1, struct{}{}, false),
+		NewOperation(watcher, 2, struct{}{}, false),
+		NewOperation(watcher, 3, struct{}{}, false),
+	}
+	for _, op := range ops {
+		err := buffer.enqueue(op, true)
+		assert.NoError(t, err, "expecting no error on enqueue")
+	}
+	assert.Equal(t, uint32(3), buffer.size(), "expecting the operations in the shards to be counted")
+	assert.NotZero(t, buffer.estimatedMemory(), "expecting the operations in the shards to be estimated")
+	err := buffer.enqueue(NewOperation(watcher, 4, struct{}{}, false), true)
+	assert.Equal(t, BufferFullError, err, "expecting the buffer is full because it has a size of 3")
+	var collected []Operation
+	for op := buffer.top(); op != nil; op = buffer.remove() {
+		collected = append(collected, op)
+	}
+	assert.Equal(t, ops, collected)
+	assert.Equal(t, uint32(0), buffer.size())
+}
+
+func TestShardedBuffer_ConcurrentProducersNeverExceedTheMax(t *testing.T) {
+	const producers, perProducer, max = 50, 100, 64
+	buffer := newShardedBuffer(newBuffer(max).(*buffer))
+	watcher := NewWatcher(func(batch []Operation) {})
+	var wg sync.WaitGroup
+	for i := 0; i < producers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perProducer; j++ {
+				err := buffer.enqueue(NewOperation(watcher, 1, struct{}{}, false), false)
+				assert.NoError(t, err, "expecting no error on enqueue")
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	var received int
+	for received < producers*perProducer {
+		assert.LessOrEqual(t, buffer.size(), uint32(max), "expecting the buffer to never exceed its max")
+		for op := buffer.top(); op != nil; op = buffer.remove() {
+			received++
+		}
+	}
+	<-done
+	assert.Equal(t, producers*perProducer, received, "expecting every operation to be collected")
+}
+
+func TestShardedBuffer_OperationsAreCollectedInTheOrderTheyWereAppended(t *testing.T) {
+	buffer := newShardedBuffer(newUnboundedBuffer().(*buffer))
+	if len(buffer.shards) < 2 {
+		buffer.shards = make([]bufferShard, 2)
+	}
+	watcher := NewWatcher(func(batch []Operation) {})
+	first, second := NewOperation(watcher, 1, struct{}{}, false), NewOperation(watcher, 2, struct{}{}, false)
+
+	// the first Operation has room reserved before the second but is appended after it
+	a, b := &buffer.shards[0], &buffer.shards[1]
+	atomic.AddUint64(&a.state, 1)
+	atomic.AddUint64(&b.state, 1)
+	a.mutex.Lock()
+	appended := make(chan error)
+	go func() {
+		appended <- buffer.push(a, first)
+	}()
+	assert.NoError(t, buffer.push(b, second), "expecting no error on push")
+	a.mutex.Unlock()
+	assert.NoError(t, <-appended, "expecting no error on push")
+
+	var collected []Operation
+	for op := buffer.top(); op != nil; op = buffer.remove() {
+		collected = append(collected, op)
+	}
+	assert.Equal(t, []Operation{second, first}, collected, "expecting the operations in the order they were appended")
+}
+
+func TestShardedBuffer_RoomMadeByRemovingIsHandedOutToTheShards(t *testing.T) {
+	buffer := newShardedBuffer(newBuffer(2).(*buffer))
+	watcher := NewWatcher(func(batch []Operation) {})
+	for i := 0; i < 2; i++ {
+		err := buffer.enqueue(NewOperation(watcher, 1, struct{}{}, false), true)
+		assert.NoError(t, err, "expecting no error on enqueue")
+	}
+	var quota uint32
+	for i := range buffer.shards {
+		state := atomic.LoadUint64(&buffer.shards[i].state)
+		quota += uint32(state>>32) - uint32(state)
+	}
+	assert.Zero(t, quota, "expecting no room left in the shards")
+	buffer.top()
+	buffer.remove()
+	err := buffer.enqueue(NewOperation(watcher, 1, struct{}{}, false), true)
+	assert.NoError(t, err, "expecting the room made by removing an operation to be used")
+	err = buffer.enqueue(NewOperation(watcher, 1, struct{}{}, false), true)
+	assert.Equal(t, BufferFullError, err, "expecting the buffer to be full again")
+	assert.Equal(t, uint32(2), buffer.size())
+}
+
+func TestShardedBuffer_DeduplicationEnqueuesWithTheLock(t *testing.T) {
+	buffer := newShardedBuffer(newBuffer(10).(*buffer))
+	buffer.deduplicate(nil)
+	watcher := NewWatcher(func(batch []Operation) {})
+	err := buffer.enqueue(NewOperation(watcher, 1, struct{}{}, false).WithDedupKey("a"), false)
+	assert.NoError(t, err, "expecting no error on enqueue")
+	err = buffer.enqueue(NewOperation(watcher, 1, struct{}{}, false).WithDedupKey("a"), false)
+	assert.Equal(t, DuplicateOperationError, err, "expecting the duplicate to be rejected when it is enqueued")
+}
+
+func TestShardedBuffer_ShutdownReturnsTheOperationsInTheShards(t *testing.T) {
+	buffer := newShardedBuffer(newBuffer(10).(*buffer))
+	watcher := NewWatcher(func(batch []Operation) {})
+	op := NewOperation(watcher, 1, struct{}{}, false)
+	err := buffer.enqueue(op, false)
+	assert.NoError(t, err, "expecting no error on enqueue")
+	assert.Equal(t, []Operation{op}, buffer.shutdown())
+	assert.Equal(t, uint32(0), buffer.size())
+	err = buffer.enqueue(NewOperation(watcher, 1, struct{}{}, false), false)
+	assert.Equal(t, BufferIsShutdown, err, "expecting no enqueue after shutdown")
+}
+
+// With many goroutines enqueuing at the same time, the sharded buffer should not be slowed by contention for the lock of the buffer.
+func BenchmarkBuffer_Enqueue_Parallel(b *testing.B) {
+	for _, impl := range []BufferImplementation{LockedBuffer, ShardedBuffer} {
+		b.Run(impl.String(), func(b *testing.B) {
+			locked := newUnboundedBuffer()
+			target := locked
+			if impl == ShardedBuffer {
+				target = newShardedBuffer(locked.(*buffer))
+			}
+			watcher := NewWatcher(func(batch []Operation) {})
+			op := NewOperation(watcher, 1, struct{}{}, false)
+			b.SetParallelism(8)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					_ = target.enqueue(op, false)
+				}
+			})
+		})
+	}
+}
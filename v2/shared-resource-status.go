@@ -0,0 +1,29 @@
+package batcher
+
+import "time"
+
+// SharedResourceStatus is a snapshot of the SharedResource returned by Status().
+type SharedResourceStatus struct {
+	Phase         string    // "uninitialized", "started", or "stopped"
+	Partitions    uint32    // the number of partitions currently leased by this instance
+	Target        uint32    // the number of partitions this instance is trying to lease
+	Capacity      uint32    // the same as Capacity()
+	LastLease     time.Time // when a partition was last leased or renewed (zero if never)
+	LastError     error     // the last error raised by the LeaseManager (nil if none)
+	LastErrorTime time.Time // when LastError was raised (zero if never)
+}
+
+func phaseName(phase int) string {
+	switch phase {
+	case phaseUninitialized:
+		return "uninitialized"
+	case phaseStarted:
+		return "started"
+	case phasePaused:
+		return "paused"
+	case phaseDraining:
+		return "draining"
+	default:
+		return "stopped"
+	}
+}
@@ -14,14 +14,31 @@ import (
 
 const (
 	maxPartitions = 500
+
+	// after consecutive failures to lease a partition, the interval between attempts grows to at most 2^maxBackoffExponent times the
+	// MaxInterval
+	maxBackoffExponent = 4
 )
 
 type SharedResource interface {
 	RateLimiter
+	sharedResourceLogging
 	WithFactor(val uint32) SharedResource
 	WithReservedCapacity(val uint32) SharedResource
 	WithSharedCapacity(val uint32, mgr LeaseManager) SharedResource
 	WithMaxInterval(val uint32) SharedResource
+	WithDemandInterval(val time.Duration) SharedResource
+	WithClockSkewMargin(val time.Duration) SharedResource
+	WithCapacityLending() SharedResource
+	WithMaxLentCapacity(val uint32) SharedResource
+	WithGapInterval(val time.Duration) SharedResource
+	WithCapacityFloor(partitions uint32) SharedResource
+	WithMaxPartitionsPerInstance(partitions uint32) SharedResource
+	WithClock(clock Clock) SharedResource
+	AggregateDemand() (FleetDemand, error)
+	Status() SharedResourceStatus
+	Healthy() bool
+	Ready() error
 	SetReservedCapacity(capacity uint32)
 	SetSharedCapacity(capacity uint32) error
 }
@@ -31,9 +48,18 @@ type sharedResource struct {
 
 	// configuration items that should not change after Start()
 	factor           uint32
+	factorScaled     bool
 	maxInterval      uint32
 	sharedCapacity   uint32
 	reservedCapacity uint32
+	demandInterval   time.Duration
+	clockSkewMargin  time.Duration
+	gapInterval      time.Duration
+	capacityFloor    uint32
+	maxPerInstance   uint32
+	lending          bool
+	maxLent          uint32
+	clock            Clock
 
 	// used for internal operations
 	leaseManager LeaseManager
@@ -42,14 +68,45 @@ type sharedResource struct {
 	phaseMutex sync.Mutex
 	phase      int
 	provision  chan struct{}
+	release    chan struct{}
 
 	// capacity and target needs to be threadsafe and changes frequently
-	capacity uint32
-	target   uint32
+	capacity  uint32
+	target    uint32
+	requested uint32
+
+	// the demand across the fleet (if supported by the lease manager)
+	instance    string
+	demandMutex sync.RWMutex
+	fleetDemand FleetDemand
+
+	// the fleet policy and the number of live instances it applies to (if supported by the lease manager)
+	minPartitions uint32
+	liveInstances uint32
+
+	// reserved capacity lent to the shared pool (if lending is enabled); lendMutex keeps lending and reclaiming consistent
+	lendMutex sync.Mutex
+	wanted    uint32
+	lent      uint32
+	pooled    uint32
+
+	// closed (and replaced) whenever the capacity is recalculated so that WaitForCapacity() does not need to poll
+	capacityMutex   sync.Mutex
+	capacityChanged chan struct{}
 
 	// partitions need to be threadsafe and should use the partlock
 	partlock   sync.RWMutex
 	partitions []*string
+
+	// capacity set aside by Reserve()
+	reservations reservations
+
+	// the outcome of lease operations (for Status() and Healthy())
+	statusMutex   sync.Mutex
+	lastLease     time.Time
+	lastResponse  time.Time
+	lastError     error
+	lastErrorTime time.Time
 }
 
 // This function should be called to create a new SharedResource. The accountName and containerName refer to the details
@@ -57,13 +114,18 @@ type sharedResource struct {
 // capacity, they should all point to the same container. Commonly after calling NewSharedResource() you will chain some WithXXXX methods, for instance...
 // `NewSharedResource().WithMasterKey(key)`.
 func NewSharedResource() SharedResource {
-	res := &sharedResource{}
+	res := &sharedResource{
+		instance: uuid.New().String(),
+		release:  make(chan struct{}, 1),
+		clock:    realClock{},
+	}
 	return res
 }
 
 // You may provide a factor that determines how much capacity each partition is worth. For instance, if you provision a Cosmos database
 // with 20k RU, you might use a factor of 1000, meaning 20 partitions would be created, each worth 1k RU. If not provided, the factor
-// defaults to `1`. There is a limit of 500 partitions, so if you have a shared capacity in excess of 500, you must provide a factor.
+// defaults to `1`. There is a limit of 500 partitions, so if the shared capacity divided by the factor exceeds 500, the factor is
+// increased to the shared capacity divided by 500 (rounded up) and FactorEvent is raised when Start() is called.
 func (r *sharedResource) WithFactor(val uint32) SharedResource {
 	r.phaseMutex.Lock()
 	defer r.phaseMutex.Unlock()
@@ -71,6 +133,7 @@ func (r *sharedResource) WithFactor(val uint32) SharedResource {
 		panic(InitializationOnlyError)
 	}
 	r.factor = val
+	r.scaleFactor()
 	return r
 }
 
@@ -101,9 +164,20 @@ func (r *sharedResource) WithSharedCapacity(val uint32, mgr LeaseManager) Shared
 	atomic.StoreUint32(&r.sharedCapacity, val)
 	mgr.RaiseEventsTo(r)
 	r.leaseManager = mgr
+	r.scaleFactor()
 	return r
 }
 
+// This increases the factor if the shared capacity would otherwise need more than the max partitions. Since the factor is only derived
+// from the configuration, every instance configured the same way scales it the same way. The phaseMutex must be held.
+func (r *sharedResource) scaleFactor() {
+	min := uint32(math.Ceil(float64(atomic.LoadUint32(&r.sharedCapacity)) / maxPartitions))
+	r.factorScaled = r.factor < min && min > 1
+	if r.factorScaled {
+		r.factor = min
+	}
+}
+
 // The rate limiter will attempt to obtain an exclusive lease on a partition (when needed) every so often. The interval is random to
 // reduce the number of collisions and to provide an equal opportunity for processes to compete for partitions. This setting determines
 // the maximum amount of time between intervals. It defaults to `500` and is measured in milliseconds.
@@ -117,6 +191,241 @@ func (r *sharedResource) WithMaxInterval(val uint32) SharedResource {
 	return r
 }
 
+// Partition ownership assumes that the local clock and the clock of the service holding the leases agree. If they do not, another
+// instance could obtain a lease on a partition while this instance still believes it holds it, briefly double-counting capacity. This
+// margin is subtracted from the lease duration so leases are treated as expired early locally. It must be less than the lease
+// duration (15s for AzureBlobLeaseManager); the measured skew is raised as a "clock-skew" event by LeaseManagers that support it.
+func (r *sharedResource) WithClockSkewMargin(val time.Duration) SharedResource {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.phase != phaseUninitialized {
+		panic(InitializationOnlyError)
+	}
+	r.clockSkewMargin = val
+	return r
+}
+
+// Reserved capacity is always available to this instance, even when it is idle. If the LeaseManager supports it (see LendingStore),
+// setting this option lends the reserved capacity this instance is not requesting (in whole multiples of Factor) to the shared pool at
+// every DemandInterval. Every instance sharing the partitions adds the capacity lent across the fleet to its SharedCapacity, so other
+// instances can obtain it. As soon as GiveMe() requests more than the reserved capacity that was not lent, the loan is reclaimed
+// locally, but other instances may continue to use it until they read the new amount (up to a DemandInterval) and their leases
+// expire, so the fleet can briefly exceed its provisioned capacity. Every instance sharing the partitions should use the same setting.
+func (r *sharedResource) WithCapacityLending() SharedResource {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.phase != phaseUninitialized {
+		panic(InitializationOnlyError)
+	}
+	r.lending = true
+	return r
+}
+
+// If WithCapacityLending() is set, this limits how much of the reserved capacity this instance will lend to the shared pool at once
+// (rounded down to a whole multiple of Factor), so some reserved capacity always stays with this instance for bursts. The default is `0`,
+// which means all the reserved capacity that is not requested can be lent.
+func (r *sharedResource) WithMaxLentCapacity(val uint32) SharedResource {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.phase != phaseUninitialized {
+		panic(InitializationOnlyError)
+	}
+	r.maxLent = val
+	return r
+}
+
+// If the LeaseManager supports it (see DemandStore), this determines how often the SharedResource publishes the shared capacity
+// it is requesting and reads what every other instance is requesting. The default is `10s`. Instances that have not published
+// in 3 intervals are no longer counted.
+func (r *sharedResource) WithDemandInterval(val time.Duration) SharedResource {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.phase != phaseUninitialized {
+		panic(InitializationOnlyError)
+	}
+	r.demandInterval = val
+	return r
+}
+
+// Setting this option raises a gap event at the provided interval (for instance, every 10 seconds) with the shared capacity this
+// instance is requesting but has not obtained (the target less the capacity, or 0 if the target is met). An instance that persistently
+// reports a gap cannot obtain the capacity it is asking for, which is an early sign that the fleet is over-subscribed.
+func (r *sharedResource) WithGapInterval(val time.Duration) SharedResource {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.phase != phaseUninitialized {
+		panic(InitializationOnlyError)
+	}
+	r.gapInterval = val
+	return r
+}
+
+// Normally an instance obtains as many partitions as it needs, so a newly scaled-out instance may have to wait for the leases of
+// other instances to expire before it can obtain any. If the LeaseManager supports it (see PolicyStore), setting this option writes a
+// FleetPolicy that guarantees every live instance this many partitions before any instance may exceed its fair share (the partitions
+// divided by the live instances). Every instance sharing the partitions reads and follows the policy (whether or not it sets this option)
+// at every DemandInterval. If the LeaseManager does not support it, Start() returns `PolicyNotSupportedError`.
+func (r *sharedResource) WithCapacityFloor(partitions uint32) SharedResource {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.phase != phaseUninitialized {
+		panic(InitializationOnlyError)
+	}
+	r.capacityFloor = partitions
+	return r
+}
+
+// Normally an instance obtains as many partitions as it needs (per GiveMe()), so under contention a single busy instance can hold nearly
+// every partition while its peers starve. Setting this option caps the number of partitions this instance will hold at once, regardless
+// of how much capacity it requests. Unlike WithCapacityFloor(), it does not require any support from the LeaseManager, but every instance
+// sharing the partitions should use the same setting. The default is `0`, which means there is no cap.
+func (r *sharedResource) WithMaxPartitionsPerInstance(partitions uint32) SharedResource {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.phase != phaseUninitialized {
+		panic(InitializationOnlyError)
+	}
+	r.maxPerInstance = partitions
+	return r
+}
+
+// Normally the SharedResource uses the time package to wait between lease attempts, hold leases, and run its intervals. Setting this
+// option uses the provided Clock instead, which is intended for tests that need to control time (see testutil.FakeClock). The
+// LeaseManager still uses its own clock for the leases themselves.
+func (r *sharedResource) WithClock(clock Clock) SharedResource {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.phase != phaseUninitialized {
+		panic(InitializationOnlyError)
+	}
+	r.clock = clock
+	return r
+}
+
+// This returns the shared capacity requested by every live instance compared to the shared capacity available as of the last
+// DemandInterval. If the LeaseManager does not implement DemandStore, `DemandNotSupportedError` is returned.
+func (r *sharedResource) AggregateDemand() (FleetDemand, error) {
+	if _, ok := r.leaseManager.(DemandStore); !ok {
+		return FleetDemand{}, DemandNotSupportedError
+	}
+	r.demandMutex.RLock()
+	defer r.demandMutex.RUnlock()
+	return r.fleetDemand, nil
+}
+
+func (r *sharedResource) reportGap(ctx context.Context) {
+	ticker := r.clock.NewTicker(r.gapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			var gap uint32
+			requested, capacity := atomic.LoadUint32(&r.requested), atomic.LoadUint32(&r.capacity)
+			if requested > capacity {
+				gap = requested - capacity
+			}
+			r.Emit(GapEvent, int(gap), "", nil)
+		}
+	}
+}
+
+func (r *sharedResource) shareDemand(ctx context.Context, store DemandStore) {
+	ticker := r.clock.NewTicker(r.demandInterval)
+	defer ticker.Stop()
+	lender, _ := store.(LendingStore)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+
+			// lend unused reserved capacity (if requested and supported)
+			if r.lending && lender != nil {
+				r.shareLending(ctx, lender)
+			}
+
+			// publish what this instance is requesting
+			if err := store.WriteDemand(ctx, r.instance, atomic.LoadUint32(&r.requested)); err != nil {
+				r.Emit(ErrorEvent, 0, "publishing demand raised an error", err)
+				continue
+			}
+
+			// read what every live instance is requesting
+			demands, err := store.ReadDemand(ctx, r.clock.Now().Add(-3*r.demandInterval))
+			if err != nil {
+				r.Emit(ErrorEvent, 0, "reading demand raised an error", err)
+				continue
+			}
+			fleet := FleetDemand{
+				Instances:      len(demands),
+				SharedCapacity: atomic.LoadUint32(&r.sharedCapacity),
+				AsOf:           r.clock.Now(),
+			}
+			for _, demand := range demands {
+				fleet.Demand += uint64(demand)
+			}
+			r.demandMutex.Lock()
+			r.fleetDemand = fleet
+			r.demandMutex.Unlock()
+			atomic.StoreUint32(&r.liveInstances, uint32(fleet.Instances))
+			r.Emit(DemandEvent, int(fleet.Demand), "", fleet)
+
+			// read the fleet policy (if supported)
+			if policies, ok := store.(PolicyStore); ok {
+				policy, err := policies.ReadPolicy(ctx)
+				if err != nil {
+					r.Emit(ErrorEvent, 0, "reading the fleet policy raised an error", err)
+					continue
+				}
+				atomic.StoreUint32(&r.minPartitions, policy.MinPartitionsPerInstance)
+			}
+
+		}
+	}
+}
+
+func (r *sharedResource) shareLending(ctx context.Context, store LendingStore) {
+
+	// lend whole partitions of the reserved capacity that is not wanted
+	r.lendMutex.Lock()
+	reserved, wanted := atomic.LoadUint32(&r.reservedCapacity), atomic.LoadUint32(&r.wanted)
+	var lend uint32
+	if wanted < reserved {
+		lend = reserved - wanted
+	}
+	if r.maxLent > 0 && lend > r.maxLent {
+		lend = r.maxLent
+	}
+	lend = lend / r.factor * r.factor
+	atomic.StoreUint32(&r.lent, lend)
+	r.lendMutex.Unlock()
+
+	// publish what this instance is lending and read what the fleet is lending
+	if err := store.WriteLent(ctx, r.instance, lend); err != nil {
+		r.Emit(ErrorEvent, 0, "publishing lent capacity raised an error", err)
+		return
+	}
+	loans, err := store.ReadLent(ctx, r.clock.Now().Add(-3*r.demandInterval))
+	if err != nil {
+		r.Emit(ErrorEvent, 0, "reading lent capacity raised an error", err)
+		return
+	}
+	var pooled uint32
+	for _, loan := range loans {
+		pooled += loan
+	}
+
+	// the partitions must be provisioned again if the pool changed size
+	if atomic.SwapUint32(&r.pooled, pooled) != pooled {
+		r.scheduleProvision()
+	}
+	r.calc()
+	r.Emit(LendingEvent, int(lend), "", pooled)
+
+}
+
 // This returns the maximum capacity that could ever be obtained by the rate limiter. It is `SharedCapacity + ReservedCapacity`. This reflects
 // the limit of 500 partitions.
 func (r *sharedResource) MaxCapacity() uint32 {
@@ -128,16 +437,153 @@ func (r *sharedResource) MaxCapacity() uint32 {
 	return sharedCapacity + atomic.LoadUint32(&r.reservedCapacity)
 }
 
-// This returns the current allocated capacity. It is `NumberOfPartitionsControlled x Factor + ReservedCapacity`.
+// Capacity is per second and renews on each whole second of the wall clock. This returns the next time that happens.
+func (r *sharedResource) NextRenewal() time.Time {
+	return r.clock.Now().Truncate(time.Second).Add(time.Second)
+}
+
+// This returns a snapshot of the phase, partitions, and the outcome of the most recent lease operations, which is useful for readiness
+// probes and diagnostics.
+func (r *sharedResource) Status() SharedResourceStatus {
+	r.phaseMutex.Lock()
+	phase := r.phase
+	r.phaseMutex.Unlock()
+	r.partlock.RLock()
+	var partitions uint32
+	for _, id := range r.partitions {
+		if id != nil {
+			partitions++
+		}
+	}
+	r.partlock.RUnlock()
+	r.statusMutex.Lock()
+	defer r.statusMutex.Unlock()
+	return SharedResourceStatus{
+		Phase:         phaseName(phase),
+		Partitions:    partitions,
+		Target:        atomic.LoadUint32(&r.target),
+		Capacity:      r.Capacity(),
+		LastLease:     r.lastLease,
+		LastError:     r.lastError,
+		LastErrorTime: r.lastErrorTime,
+	}
+}
+
+// This returns TRUE if the SharedResource is started and the LeaseManager has responded successfully since it last raised an error. A
+// lease that is denied because another instance holds it is a successful response.
+func (r *sharedResource) Healthy() bool {
+	return r.Ready() == nil
+}
+
+// This returns nil under the same conditions as Healthy(), which is suitable for a readiness probe (see ProbeHandler()). Otherwise, it
+// returns NotRunningError if the SharedResource is not started or LeaseManagerUnreachableError (wrapping the last error raised by the
+// LeaseManager).
+func (r *sharedResource) Ready() error {
+	r.phaseMutex.Lock()
+	phase := r.phase
+	r.phaseMutex.Unlock()
+	if phase != phaseStarted {
+		return NotRunningError
+	}
+	r.statusMutex.Lock()
+	defer r.statusMutex.Unlock()
+	if r.lastError == nil || r.lastResponse.After(r.lastErrorTime) {
+		return nil
+	}
+	return fmt.Errorf("%w: %v", LeaseManagerUnreachableError, r.lastError)
+}
+
+// Events raised by the SharedResource and its LeaseManager are inspected to keep the Status() before they are raised to listeners.
+func (r *sharedResource) Emit(event string, val int, msg string, metadata interface{}) {
+	switch event {
+	case AllocatedEvent, RenewedEvent:
+		r.statusMutex.Lock()
+		r.lastLease = r.clock.Now()
+		r.lastResponse = r.lastLease
+		r.statusMutex.Unlock()
+	case FailedEvent, DemandEvent:
+		r.statusMutex.Lock()
+		r.lastResponse = r.clock.Now()
+		r.statusMutex.Unlock()
+	case ErrorEvent:
+		if err, ok := metadata.(error); ok {
+			r.statusMutex.Lock()
+			r.lastError = err
+			r.lastErrorTime = r.clock.Now()
+			r.statusMutex.Unlock()
+		}
+	}
+	r.EventerBase.Emit(event, val, msg, metadata)
+}
+
+// This returns the current allocated capacity. It is `NumberOfPartitionsControlled x Factor + ReservedCapacity` (less any reserved capacity
+// that is lent to the shared pool).
 func (r *sharedResource) Capacity() uint32 {
-	return atomic.LoadUint32(&r.capacity) + atomic.LoadUint32(&r.reservedCapacity)
+	reserved := atomic.LoadUint32(&r.reservedCapacity)
+	if lent := atomic.LoadUint32(&r.lent); lent < reserved {
+		reserved -= lent
+	} else {
+		reserved = 0
+	}
+	return atomic.LoadUint32(&r.capacity) + reserved
 }
 
-// This allows you to set the SharedCapacity to a different value after the RateLimiter has started.
+// Call this method to set aside capacity for work you are about to do over the ttl. Since Capacity() is per second, the capacity
+// available to reservations is `Capacity() x ttl`; for instance, with 1,000 capacity and a ttl of 100ms, 100 can be reserved. If the
+// outstanding reservations already meet that, `InsufficientCapacityError` is returned. Release() the reservation if the work is not
+// done so that another consumer of this SharedResource can use the capacity.
+func (r *sharedResource) Reserve(cost uint32, ttl time.Duration) (ReservationHandle, error) {
+	return r.reservations.reserve(r.Capacity(), cost, ttl)
+}
+
+// This blocks until Capacity() is at least the cost (for instance, once enough partitions have been leased), which allows you to act as
+// soon as capacity is granted rather than checking Capacity() on an interval. If the cost exceeds MaxCapacity(), TooExpensiveError is
+// returned since it could never be granted. If the context is done first, its error is returned.
+func (r *sharedResource) WaitForCapacity(ctx context.Context, cost uint32) error {
+	if cost > r.MaxCapacity() {
+		return TooExpensiveError
+	}
+	for {
+		changed := r.whenCapacityChanges()
+		if r.Capacity() >= cost {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// This returns a channel that is closed the next time the capacity is recalculated.
+func (r *sharedResource) whenCapacityChanges() <-chan struct{} {
+	r.capacityMutex.Lock()
+	defer r.capacityMutex.Unlock()
+	if r.capacityChanged == nil {
+		r.capacityChanged = make(chan struct{})
+	}
+	return r.capacityChanged
+}
+
+// This allows you to set the SharedCapacity to a different value after the RateLimiter has started. Before Start(), the factor is
+// increased if needed (see WithFactor()). After Start(), the factor cannot change since the partitions that are leased are worth it, so
+// this returns TooManyPartitionsError (and the SharedCapacity is not changed) if the capacity would need more than 500 partitions.
 func (r *sharedResource) SetSharedCapacity(capacity uint32) error {
 	if r.leaseManager == nil {
 		return SharedCapacityNotProvisioned
 	}
+	r.phaseMutex.Lock()
+	if r.phase == phaseUninitialized {
+		atomic.StoreUint32(&r.sharedCapacity, capacity)
+		r.scaleFactor()
+		r.phaseMutex.Unlock()
+		return nil
+	}
+	r.phaseMutex.Unlock()
+	if uint64(capacity) > uint64(r.factor)*maxPartitions {
+		return TooManyPartitionsError
+	}
 	atomic.StoreUint32(&r.sharedCapacity, capacity)
 	r.scheduleProvision()
 	return nil
@@ -169,6 +615,14 @@ func (r *sharedResource) calc() {
 	// set the capacity variable
 	atomic.StoreUint32(&r.capacity, total)
 
+	// wake anything waiting for capacity
+	r.capacityMutex.Lock()
+	if r.capacityChanged != nil {
+		close(r.capacityChanged)
+		r.capacityChanged = nil
+	}
+	r.capacityMutex.Unlock()
+
 	// emit the capacity change
 	r.Emit(CapacityEvent, int(r.Capacity()), "", nil)
 
@@ -180,6 +634,22 @@ func (r *sharedResource) calc() {
 // capacity you need.
 func (r *sharedResource) GiveMe(target uint32) {
 
+	// reclaim any lent capacity that is now needed
+	if r.lending {
+		r.lendMutex.Lock()
+		atomic.StoreUint32(&r.wanted, target)
+		lent, reserved := atomic.LoadUint32(&r.lent), atomic.LoadUint32(&r.reservedCapacity)
+		reclaim := lent > 0 && (lent >= reserved || target > reserved-lent)
+		if reclaim {
+			atomic.StoreUint32(&r.lent, 0)
+		}
+		r.lendMutex.Unlock()
+		if reclaim {
+			r.Emit(LendingEvent, 0, "", atomic.LoadUint32(&r.pooled))
+			r.calc()
+		}
+	}
+
 	// reduce capacity request by reserved capacity
 	reservedCapacity := atomic.LoadUint32(&r.reservedCapacity)
 	if target >= reservedCapacity {
@@ -190,6 +660,7 @@ func (r *sharedResource) GiveMe(target uint32) {
 
 	// determine the number of partitions needed
 	actual := math.Ceil(float64(target) / float64(r.factor))
+	atomic.StoreUint32(&r.requested, target)
 
 	// raise event
 	r.Emit(TargetEvent, int(target), "", nil)
@@ -197,6 +668,33 @@ func (r *sharedResource) GiveMe(target uint32) {
 	// store
 	atomic.StoreUint32(&r.target, uint32(actual))
 
+	// release any surplus partitions (if supported by the lease manager)
+	select {
+	case r.release <- struct{}{}:
+	default:
+	}
+
+}
+
+// An instance may never hold more than WithMaxPartitionsPerInstance() partitions. Per the FleetPolicy, an instance may obtain partitions
+// up to its fair share, but beyond that it must leave enough partitions for every other live instance to obtain the minimum.
+func (r *sharedResource) mayObtainAnotherPartition(count uint32) bool {
+	if r.maxPerInstance > 0 && count >= r.maxPerInstance {
+		return false
+	}
+	min, instances := atomic.LoadUint32(&r.minPartitions), atomic.LoadUint32(&r.liveInstances)
+	if min == 0 || instances <= 1 {
+		return true
+	}
+	r.partlock.RLock()
+	partitions := uint32(len(r.partitions))
+	r.partlock.RUnlock()
+	fairShare := (partitions + instances - 1) / instances
+	if count < fairShare {
+		return true
+	}
+	reserved := min * (instances - 1)
+	return reserved < partitions && count+1 <= partitions-reserved
 }
 
 func (r *sharedResource) scheduleProvision() {
@@ -250,7 +748,8 @@ func (r *sharedResource) setPartitionId(index uint32, id string) {
 
 }
 
-func (r *sharedResource) clearPartitionId(index uint32) {
+// This returns FALSE if the partition was already released (it is no longer tracked with the same lease).
+func (r *sharedResource) clearPartitionId(index uint32, id string) bool {
 
 	// get a write lock
 	r.partlock.Lock()
@@ -258,9 +757,14 @@ func (r *sharedResource) clearPartitionId(index uint32) {
 
 	// clear the id
 	// NOTE: clearing happens outside the Loop, so the partition could have already been truncated making the index is too high
-	if int(index) < len(r.partitions) {
-		r.partitions[index] = nil
+	if int(index) >= len(r.partitions) {
+		return true
+	}
+	if r.partitions[index] == nil || *r.partitions[index] != id {
+		return false
 	}
+	r.partitions[index] = nil
+	return true
 
 }
 
@@ -270,8 +774,8 @@ func (r *sharedResource) provisionBlobs(ctx context.Context) {
 	r.partlock.Lock()
 	defer r.partlock.Unlock()
 
-	// make 1 partition per factor
-	sharedCapacity := atomic.LoadUint32(&r.sharedCapacity)
+	// make 1 partition per factor (including any capacity lent to the shared pool)
+	sharedCapacity := atomic.LoadUint32(&r.sharedCapacity) + atomic.LoadUint32(&r.pooled)
 	count := int(math.Ceil(float64(sharedCapacity) / float64(r.factor)))
 	if count > maxPartitions {
 		r.Emit(ErrorEvent, count, "only 500 partitions were created as this is the max supported", nil)
@@ -295,6 +799,7 @@ func (r *sharedResource) provisionBlobs(ctx context.Context) {
 }
 
 func (r *sharedResource) loop(ctx context.Context) {
+	var failures uint32
 	for {
 
 		// check for a stop
@@ -309,43 +814,158 @@ func (r *sharedResource) loop(ctx context.Context) {
 			// continue
 		}
 
-		// sleep for a bit before trying to obtain a new lease
-		interval := rand.Intn(int(r.maxInterval))
-		time.Sleep(time.Duration(interval) * time.Millisecond)
+		// sleep for a bit before trying to obtain a new lease; the interval backs off exponentially (with jitter) while attempts fail
+		interval := rand.Intn(int(r.maxInterval) << backoffExponent(failures))
+		r.clock.Sleep(time.Duration(interval) * time.Millisecond)
 
 		// see how many partitions are allocated and if there any that can be allocated
 		count, index, err := r.getAllocatedAndRandomUnallocatedPartition()
 		target := atomic.LoadUint32(&r.target)
-		if err == nil && count < target {
+		if err == nil && count < target && r.mayObtainAnotherPartition(count) {
 
 			// attempt to allocate the partition
 			id := fmt.Sprint(uuid.New())
 			leaseTime := r.leaseManager.LeasePartition(ctx, id, index)
 			if leaseTime == 0 {
+				failures++
+				r.Emit(ContentionEvent, int(failures), "", nil)
 				continue
 			}
+			failures = 0
 
-			// clear the partition after the lease
-			go func(i uint32) {
-				select {
-				case <-ctx.Done():
-				case <-time.After(leaseTime):
-					r.clearPartitionId(i)
-					r.Emit(ReleasedEvent, int(index), "", nil)
-					r.calc()
-				}
-			}(index)
+			// treat the lease as expiring early to tolerate clock skew
+			leaseTime -= r.clockSkewMargin
+			if leaseTime <= 0 {
+				continue
+			}
+
+			// renew the lease while the partition is needed and clear the partition after the lease
+			go r.holdPartition(ctx, index, id, leaseTime)
 
 			// mark the partition as allocated
 			r.setPartitionId(index, id)
 			r.Emit(AllocatedEvent, int(index), "", nil)
 			r.calc()
 
+		} else {
+			failures = 0
 		}
 
 	}
 }
 
+func backoffExponent(failures uint32) uint32 {
+	if failures > maxBackoffExponent {
+		return maxBackoffExponent
+	}
+	return failures
+}
+
+// This keeps the partition until the lease expires. If the LeaseManager is a LeaseRenewer, the lease is renewed once 2/3 of it has
+// elapsed for as long as the partition is still needed, so granted capacity stays stable rather than expiring and being leased again.
+func (r *sharedResource) holdPartition(ctx context.Context, index uint32, id string, leaseTime time.Duration) {
+	renewer, _ := r.leaseManager.(LeaseRenewer)
+	for {
+
+		// wait until the lease should be renewed (or expires)
+		wait := leaseTime
+		if renewer != nil {
+			wait = leaseTime * 2 / 3
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.clock.After(wait):
+		}
+
+		// renew the lease if the partition is still needed
+		if renewer != nil {
+			if r.stillNeedsPartition(index, id) {
+				if renewed := renewer.RenewPartition(ctx, id, index) - r.clockSkewMargin; renewed > 0 {
+					leaseTime = renewed
+					r.Emit(RenewedEvent, int(index), "", nil)
+					continue
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-r.clock.After(leaseTime - wait):
+			}
+		}
+
+		// clear the partition (unless it was already released)
+		if r.clearPartitionId(index, id) {
+			r.Emit(ReleasedEvent, int(index), "", nil)
+			r.calc()
+		}
+		return
+	}
+}
+
+// When GiveMe() lowers the target, this stops using the surplus partitions and releases their leases right away (rather than when they
+// expire) so that other instances can obtain them.
+func (r *sharedResource) releaseSurplusPartitions(ctx context.Context, releaser LeaseReleaser) {
+	type held struct {
+		index uint32
+		id    string
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.release:
+		}
+
+		// stop tracking the surplus partitions
+		var surplus []held
+		target := atomic.LoadUint32(&r.target)
+		r.partlock.Lock()
+		var count uint32
+		for i := 0; i < len(r.partitions); i++ {
+			if r.partitions[i] != nil {
+				count++
+			}
+		}
+		for i := len(r.partitions) - 1; i >= 0 && count > target; i-- {
+			if r.partitions[i] != nil {
+				surplus = append(surplus, held{index: uint32(i), id: *r.partitions[i]})
+				r.partitions[i] = nil
+				count--
+			}
+		}
+		r.partlock.Unlock()
+		if len(surplus) == 0 {
+			continue
+		}
+
+		// the capacity is reduced before the leases are released so it is never used by two instances at once
+		r.calc()
+		for _, p := range surplus {
+			releaser.ReleasePartition(ctx, p.id, p.index)
+			r.Emit(ReleasedEvent, int(p.index), "", nil)
+		}
+	}
+}
+
+// A partition is still needed if it is still tracked with the same lease and it would be obtained again if it were not held.
+func (r *sharedResource) stillNeedsPartition(index uint32, id string) bool {
+	r.partlock.RLock()
+	if int(index) >= len(r.partitions) || r.partitions[index] == nil || *r.partitions[index] != id {
+		r.partlock.RUnlock()
+		return false
+	}
+	var count uint32
+	for i := 0; i < len(r.partitions); i++ {
+		if r.partitions[i] != nil {
+			count++
+		}
+	}
+	r.partlock.RUnlock()
+	others := count - 1
+	return others < atomic.LoadUint32(&r.target) && r.mayObtainAnotherPartition(others)
+}
+
 // Call this method to start the processing loop. The processing loop runs on a random interval not to exceed MaxInterval and
 // attempts to obtain an exclusive lease on blob partitions to fulfill the capacity requests.
 func (r *sharedResource) Start(ctx context.Context) (err error) {
@@ -362,9 +982,15 @@ func (r *sharedResource) Start(ctx context.Context) (err error) {
 	if r.factor == 0 {
 		r.factor = 1 // assume 1:1
 	}
+	if r.factorScaled {
+		r.Emit(FactorEvent, int(r.factor), fmt.Sprintf("the factor was increased so there are no more than %d partitions", maxPartitions), nil)
+	}
 	if r.maxInterval == 0 {
 		r.maxInterval = 500 // default to 500ms
 	}
+	if r.demandInterval <= 0 {
+		r.demandInterval = 10 * time.Second
+	}
 
 	// init flowcontrol chans
 	r.provision = make(chan struct{}, 1)
@@ -374,8 +1000,28 @@ func (r *sharedResource) Start(ctx context.Context) (err error) {
 		if err = r.leaseManager.Provision(ctx); err != nil {
 			return
 		}
+		if r.capacityFloor > 0 {
+			policies, ok := r.leaseManager.(PolicyStore)
+			if !ok {
+				err = PolicyNotSupportedError
+				return
+			}
+			if err = policies.WritePolicy(ctx, FleetPolicy{MinPartitionsPerInstance: r.capacityFloor}); err != nil {
+				return
+			}
+			atomic.StoreUint32(&r.minPartitions, r.capacityFloor)
+		}
 		r.scheduleProvision()
 		go r.loop(ctx)
+		if releaser, ok := r.leaseManager.(LeaseReleaser); ok {
+			go r.releaseSurplusPartitions(ctx, releaser)
+		}
+		if store, ok := r.leaseManager.(DemandStore); ok {
+			go r.shareDemand(ctx, store)
+		}
+		if r.gapInterval > 0 {
+			go r.reportGap(ctx)
+		}
 	} else {
 		r.calc()
 		go func() {
@@ -35,6 +35,65 @@ func (mgr *mockLeaseManager) LeasePartition(ctx context.Context, id string, inde
 	return args.Get(0).(time.Duration)
 }
 
+type mockDemandLeaseManager struct {
+	mockLeaseManager
+}
+
+func (mgr *mockDemandLeaseManager) WriteDemand(ctx context.Context, instance string, target uint32) error {
+	args := mgr.Called(ctx, instance, target)
+	return args.Error(0)
+}
+
+func (mgr *mockDemandLeaseManager) ReadDemand(ctx context.Context, since time.Time) (map[string]uint32, error) {
+	args := mgr.Called(ctx, since)
+	return args.Get(0).(map[string]uint32), args.Error(1)
+}
+
+type mockLendingLeaseManager struct {
+	mockDemandLeaseManager
+}
+
+func (mgr *mockLendingLeaseManager) WriteLent(ctx context.Context, instance string, lent uint32) error {
+	args := mgr.Called(ctx, instance, lent)
+	return args.Error(0)
+}
+
+func (mgr *mockLendingLeaseManager) ReadLent(ctx context.Context, since time.Time) (map[string]uint32, error) {
+	args := mgr.Called(ctx, since)
+	return args.Get(0).(map[string]uint32), args.Error(1)
+}
+
+type mockPolicyLeaseManager struct {
+	mockDemandLeaseManager
+}
+
+func (mgr *mockPolicyLeaseManager) WritePolicy(ctx context.Context, policy gobatcher.FleetPolicy) error {
+	args := mgr.Called(ctx, policy)
+	return args.Error(0)
+}
+
+func (mgr *mockPolicyLeaseManager) ReadPolicy(ctx context.Context) (gobatcher.FleetPolicy, error) {
+	args := mgr.Called(ctx)
+	return args.Get(0).(gobatcher.FleetPolicy), args.Error(1)
+}
+
+type mockRenewingLeaseManager struct {
+	mockLeaseManager
+}
+
+func (mgr *mockRenewingLeaseManager) RenewPartition(ctx context.Context, id string, index uint32) (leaseTime time.Duration) {
+	args := mgr.Called(ctx, id, index)
+	return args.Get(0).(time.Duration)
+}
+
+type mockReleasingLeaseManager struct {
+	mockLeaseManager
+}
+
+func (mgr *mockReleasingLeaseManager) ReleasePartition(ctx context.Context, id string, index uint32) {
+	mgr.Called(ctx, id, index)
+}
+
 func TestSharedResource_Start_CorrectNumberOfPartitions(t *testing.T) {
 	testCases := map[string]struct {
 		sharedCapacity uint32
@@ -42,9 +101,10 @@ func TestSharedResource_Start_CorrectNumberOfPartitions(t *testing.T) {
 		partitions     int
 		waits          int
 	}{
-		"factor defaults to 1":  {sharedCapacity: 10, partitions: 10, waits: 1},
-		"max of 500 partitions": {sharedCapacity: 10000, factor: 1, partitions: 500, waits: 2},
-		"partial rounds up":     {sharedCapacity: 10050, factor: 1000, partitions: 11, waits: 1},
+		"factor defaults to 1": {sharedCapacity: 10, partitions: 10, waits: 1},
+		"factor scales to 500": {sharedCapacity: 10000, factor: 1, partitions: 500, waits: 2},
+		"factor scales up":     {sharedCapacity: 1000000, factor: 100, partitions: 500, waits: 2},
+		"partial rounds up":    {sharedCapacity: 10050, factor: 1000, partitions: 11, waits: 1},
 	}
 	for testName, testCase := range testCases {
 		t.Run(testName, func(t *testing.T) {
@@ -62,8 +122,8 @@ func TestSharedResource_Start_CorrectNumberOfPartitions(t *testing.T) {
 				switch event {
 				case gobatcher.ProvisionDoneEvent:
 					wg.Done()
-				case gobatcher.ErrorEvent:
-					assert.Equal(t, 10000, val) // only raised on "max of 500 partitions"
+				case gobatcher.FactorEvent:
+					assert.Equal(t, int(testCase.sharedCapacity/500), val) // only raised when the factor scales
 					wg.Done()
 				}
 			})
@@ -90,7 +150,7 @@ func TestSharedResource_MaxCapacity_EqualToSharedPlusReserved(t *testing.T) {
 	mgr.AssertNumberOfCalls(t, "RaiseEventsTo", 1)
 }
 
-func TestSharedResource_MaxCapacity_CapsAt500Partitions(t *testing.T) {
+func TestSharedResource_MaxCapacity_FactorScalesToAllowAllSharedCapacity(t *testing.T) {
 	mgr := &mockLeaseManager{}
 	mgr.On("RaiseEventsTo", mock.Anything)
 	res := gobatcher.NewSharedResource().
@@ -98,7 +158,7 @@ func TestSharedResource_MaxCapacity_CapsAt500Partitions(t *testing.T) {
 		WithSharedCapacity(10000, mgr).
 		WithFactor(1)
 	max := res.MaxCapacity()
-	assert.Equal(t, uint32(2500), max)
+	assert.Equal(t, uint32(12000), max)
 	mgr.AssertNumberOfCalls(t, "RaiseEventsTo", 1)
 }
 
@@ -377,6 +437,8 @@ func TestSharedResource_Start_InitializationAfterStartCausesPanic(t *testing.T)
 	assert.PanicsWithError(t, gobatcher.InitializationOnlyError.Error(), func() { res.WithReservedCapacity(1000) })
 	assert.PanicsWithError(t, gobatcher.InitializationOnlyError.Error(), func() { res.WithFactor(10) })
 	assert.PanicsWithError(t, gobatcher.InitializationOnlyError.Error(), func() { res.WithMaxInterval(10) })
+	assert.PanicsWithError(t, gobatcher.InitializationOnlyError.Error(), func() { res.WithMaxPartitionsPerInstance(1) })
+	assert.PanicsWithError(t, gobatcher.InitializationOnlyError.Error(), func() { res.WithMaxLentCapacity(1000) })
 }
 
 func TestSharedResource_Start_AnnouncesStartingCapacity(t *testing.T) {
@@ -631,6 +693,41 @@ func TestSharedResource_SetSharedCapacity(t *testing.T) {
 	mgr.AssertNumberOfCalls(t, "CreatePartitions", 2)
 }
 
+func TestSharedResource_SetSharedCapacity_BeyondThePartitionLimitOfTheFactor(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mgr := &mockLeaseManager{}
+	mgr.On("RaiseEventsTo", mock.Anything)
+	mgr.On("Provision", mock.Anything).Return(nil)
+	mgr.On("CreatePartitions", mock.Anything, 500)
+	res := gobatcher.NewSharedResource().
+		WithSharedCapacity(10000, mgr).
+		WithFactor(10)
+
+	// before Start() the factor scales with the shared capacity
+	err := res.SetSharedCapacity(100000)
+	assert.NoError(t, err, "not expecting an error before start")
+	assert.Equal(t, uint32(100000), res.MaxCapacity(), "expecting the factor to scale to 200")
+
+	var wg sync.WaitGroup
+	res.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		if event == gobatcher.ProvisionDoneEvent {
+			wg.Done()
+		}
+	})
+	wg.Add(1)
+	err = res.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	wg.Wait()
+
+	// after Start() the factor is fixed so the shared capacity is not truncated
+	err = res.SetSharedCapacity(100001)
+	assert.Equal(t, gobatcher.TooManyPartitionsError, err, "expecting an error since 501 partitions would be needed")
+	assert.Equal(t, uint32(100000), res.MaxCapacity(), "expecting the shared capacity to be unchanged")
+	mgr.AssertNumberOfCalls(t, "CreatePartitions", 1)
+}
+
 func TestSharedResource_SetReservedCapacity(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -930,3 +1027,598 @@ func TestSharedResource_Loop_ShutdownWithSharedCapacity(t *testing.T) {
 	mgr.AssertNumberOfCalls(t, "Provision", 1)
 	mgr.AssertNumberOfCalls(t, "CreatePartitions", 1)
 }
+
+func TestSharedResource_WaitForCapacity_BlocksUntilCapacityIsGranted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mgr := &mockLeaseManager{}
+	mgr.On("RaiseEventsTo", mock.Anything)
+	mgr.On("Provision", mock.Anything).Return(nil)
+	mgr.On("CreatePartitions", mock.Anything, 10)
+	mgr.On("LeasePartition", mock.Anything, mock.Anything, mock.Anything).Return(15 * time.Second)
+
+	res := gobatcher.NewSharedResource().
+		WithSharedCapacity(10000, mgr).
+		WithFactor(1000).
+		WithMaxInterval(1)
+	err := res.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+
+	waited := make(chan error, 1)
+	go func() {
+		waited <- res.WaitForCapacity(ctx, 3000)
+	}()
+	select {
+	case <-waited:
+		assert.FailNow(t, "not expecting the wait to end before capacity is granted")
+	case <-time.After(50 * time.Millisecond):
+	}
+	res.GiveMe(3000)
+	select {
+	case err := <-waited:
+		assert.NoError(t, err, "expecting the wait to end once capacity is granted")
+		assert.GreaterOrEqual(t, res.Capacity(), uint32(3000))
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "expecting the wait to end once capacity is granted")
+	}
+
+	assert.Equal(t, gobatcher.TooExpensiveError, res.WaitForCapacity(ctx, 20000), "expecting a cost over max capacity to never be granted")
+	expired, stop := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer stop()
+	assert.Equal(t, context.DeadlineExceeded, res.WaitForCapacity(expired, 9000), "expecting the wait to end with the context")
+}
+
+func TestSharedResource_Reserve_CannotExceedCapacity(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	res := gobatcher.NewSharedResource().
+		WithReservedCapacity(1000)
+	err := res.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	first, err := res.Reserve(600, time.Second)
+	assert.NoError(t, err, "expecting the first reservation to fit")
+	assert.Equal(t, uint32(600), first.Cost())
+	_, err = res.Reserve(600, time.Second)
+	assert.NoError(t, err, "expecting the second reservation to be allowed since some capacity remained")
+	_, err = res.Reserve(1, time.Second)
+	assert.Equal(t, gobatcher.InsufficientCapacityError, err, "expecting the third reservation to exceed capacity")
+	first.Release()
+	first.Release() // releasing twice should not release twice
+	_, err = res.Reserve(600, time.Second)
+	assert.NoError(t, err, "expecting the reservation to fit after release")
+	_, err = res.Reserve(1, time.Second)
+	assert.Equal(t, gobatcher.InsufficientCapacityError, err, "expecting a double release did not return extra capacity")
+}
+
+func TestSharedResource_Reserve_IsProportionalToTTL(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	res := gobatcher.NewSharedResource().
+		WithReservedCapacity(1000)
+	err := res.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	_, err = res.Reserve(100, 100*time.Millisecond)
+	assert.NoError(t, err, "expecting the reservation to fit")
+	_, err = res.Reserve(1, 100*time.Millisecond)
+	assert.Equal(t, gobatcher.InsufficientCapacityError, err, "expecting only 100 capacity to be available in 100ms")
+	_, err = res.Reserve(0, 100*time.Millisecond)
+	assert.NoError(t, err, "expecting zero-cost reservations to always be allowed")
+}
+
+func TestSharedResource_Reserve_ExpiresAfterTTL(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	res := gobatcher.NewSharedResource().
+		WithReservedCapacity(1000)
+	err := res.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	_, err = res.Reserve(1000, 20*time.Millisecond)
+	assert.NoError(t, err, "expecting the reservation to fit")
+	_, err = res.Reserve(1, 20*time.Millisecond)
+	assert.Equal(t, gobatcher.InsufficientCapacityError, err)
+	time.Sleep(50 * time.Millisecond)
+	_, err = res.Reserve(1, 20*time.Millisecond)
+	assert.NoError(t, err, "expecting the first reservation to have expired")
+}
+
+func TestSharedResource_AggregateDemand_NotSupported(t *testing.T) {
+	mgr := &mockLeaseManager{}
+	mgr.On("RaiseEventsTo", mock.Anything)
+	res := gobatcher.NewSharedResource().
+		WithSharedCapacity(2000, mgr)
+	_, err := res.AggregateDemand()
+	assert.Equal(t, gobatcher.DemandNotSupportedError, err)
+}
+
+func TestSharedResource_AggregateDemand_SumsFleetDemand(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mgr := &mockDemandLeaseManager{}
+	mgr.On("RaiseEventsTo", mock.Anything)
+	mgr.On("Provision", mock.Anything).Return(nil)
+	mgr.On("CreatePartitions", mock.Anything, mock.Anything)
+	mgr.On("LeasePartition", mock.Anything, mock.Anything, mock.Anything).Return(time.Duration(0))
+	mgr.On("WriteDemand", mock.Anything, mock.Anything, uint32(3000)).Return(nil)
+	mgr.On("ReadDemand", mock.Anything, mock.Anything).Return(map[string]uint32{"a": 3000, "b": 1500}, nil)
+
+	res := gobatcher.NewSharedResource().
+		WithSharedCapacity(4000, mgr).
+		WithReservedCapacity(1000).
+		WithFactor(1000).
+		WithDemandInterval(10 * time.Millisecond)
+	done := make(chan gobatcher.FleetDemand, 1)
+	res.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		if event == gobatcher.DemandEvent {
+			select {
+			case done <- metadata.(gobatcher.FleetDemand):
+			default:
+			}
+		}
+	})
+	res.GiveMe(4000)
+	err := res.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+
+	select {
+	case fleet := <-done:
+		assert.Equal(t, 2, fleet.Instances)
+		assert.Equal(t, uint64(4500), fleet.Demand)
+		assert.Equal(t, uint32(4000), fleet.SharedCapacity)
+		assert.True(t, fleet.IsUnderProvisioned())
+	case <-time.After(1 * time.Second):
+		assert.Fail(t, "expected a demand event within 1 second")
+	}
+	fleet, err := res.AggregateDemand()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(4500), fleet.Demand)
+	mgr.AssertCalled(t, "WriteDemand", mock.Anything, mock.Anything, uint32(3000))
+}
+
+func TestSharedResource_GapInterval_RaisesTheCapacityNotObtained(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mgr := &mockLeaseManager{}
+	mgr.On("RaiseEventsTo", mock.Anything)
+	mgr.On("Provision", mock.Anything).Return(nil)
+	mgr.On("CreatePartitions", mock.Anything, mock.Anything)
+	mgr.On("LeasePartition", mock.Anything, mock.Anything, uint32(0)).Return(15 * time.Second)
+	mgr.On("LeasePartition", mock.Anything, mock.Anything, mock.Anything).Return(time.Duration(0))
+
+	res := gobatcher.NewSharedResource().
+		WithSharedCapacity(4000, mgr).
+		WithReservedCapacity(1000).
+		WithFactor(1000).
+		WithMaxInterval(1).
+		WithGapInterval(50 * time.Millisecond)
+	gaps := make(chan int, 100)
+	res.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		if event == gobatcher.GapEvent {
+			gaps <- val
+		}
+	})
+	res.GiveMe(4000)
+	err := res.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+
+	// only partition 0 can be leased, so 2000 of the 3000 shared capacity requested is never obtained
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case gap := <-gaps:
+			if gap == 2000 {
+				return
+			}
+			assert.Equal(t, 3000, gap, "expecting the gap to be the capacity not yet obtained")
+		case <-timeout:
+			assert.Fail(t, "expected a gap event of 2000 within 2 seconds")
+			return
+		}
+	}
+}
+
+func TestSharedResource_CapacityFloor_LeavesTheMinimumForOtherInstances(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mgr := &mockPolicyLeaseManager{}
+	mgr.On("RaiseEventsTo", mock.Anything)
+	mgr.On("Provision", mock.Anything).Return(nil)
+	mgr.On("CreatePartitions", mock.Anything, mock.Anything)
+	mgr.On("LeasePartition", mock.Anything, mock.Anything, mock.Anything).Return(15 * time.Second)
+	mgr.On("WriteDemand", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mgr.On("ReadDemand", mock.Anything, mock.Anything).Return(map[string]uint32{"a": 10, "b": 0}, nil)
+	policy := gobatcher.FleetPolicy{MinPartitionsPerInstance: 4}
+	mgr.On("WritePolicy", mock.Anything, policy).Return(nil).Once()
+	mgr.On("ReadPolicy", mock.Anything).Return(policy, nil)
+
+	res := gobatcher.NewSharedResource().
+		WithSharedCapacity(10, mgr).
+		WithMaxInterval(1).
+		WithDemandInterval(10 * time.Millisecond).
+		WithCapacityFloor(4)
+	demand := make(chan struct{}, 1)
+	res.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		if event == gobatcher.DemandEvent {
+			select {
+			case demand <- struct{}{}:
+			default:
+			}
+		}
+	})
+	err := res.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	select {
+	case <-demand:
+	case <-time.After(1 * time.Second):
+		assert.Fail(t, "expected a demand event within 1 second")
+	}
+
+	// the fair share is 5 and the other instance must be able to obtain 4, so only 6 of the 10 partitions can be obtained
+	res.GiveMe(10)
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, uint32(6), res.Capacity())
+	mgr.AssertNumberOfCalls(t, "WritePolicy", 1)
+}
+
+func TestSharedResource_MaxPartitionsPerInstance_CapsThePartitionsHeld(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mgr := &mockLeaseManager{}
+	mgr.On("RaiseEventsTo", mock.Anything)
+	mgr.On("Provision", mock.Anything).Return(nil)
+	mgr.On("CreatePartitions", mock.Anything, 10)
+	mgr.On("LeasePartition", mock.Anything, mock.Anything, mock.Anything).Return(15 * time.Second)
+	res := gobatcher.NewSharedResource().
+		WithSharedCapacity(10000, mgr).
+		WithFactor(1000).
+		WithMaxInterval(1).
+		WithMaxPartitionsPerInstance(3)
+	err := res.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	res.GiveMe(10000)
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, uint32(3000), res.Capacity(), "expecting no more than 3 partitions to be held")
+	mgr.AssertNumberOfCalls(t, "LeasePartition", 3)
+}
+
+func TestSharedResource_CapacityFloor_RequiresPolicyStore(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mgr := &mockDemandLeaseManager{}
+	mgr.On("RaiseEventsTo", mock.Anything)
+	mgr.On("Provision", mock.Anything).Return(nil)
+	res := gobatcher.NewSharedResource().
+		WithSharedCapacity(10, mgr).
+		WithCapacityFloor(4)
+	err := res.Start(ctx)
+	assert.Equal(t, gobatcher.PolicyNotSupportedError, err)
+}
+
+func TestSharedResource_CapacityLending_LendsAndReclaimsReservedCapacity(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mgr := &mockLendingLeaseManager{}
+	mgr.On("RaiseEventsTo", mock.Anything)
+	mgr.On("Provision", mock.Anything).Return(nil)
+	mgr.On("CreatePartitions", mock.Anything, mock.Anything)
+	mgr.On("LeasePartition", mock.Anything, mock.Anything, mock.Anything).Return(time.Duration(0))
+	mgr.On("WriteDemand", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mgr.On("ReadDemand", mock.Anything, mock.Anything).Return(map[string]uint32{}, nil)
+	mgr.On("WriteLent", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mgr.On("ReadLent", mock.Anything, mock.Anything).Return(map[string]uint32{"a": 1000, "b": 1000}, nil)
+
+	res := gobatcher.NewSharedResource().
+		WithSharedCapacity(4000, mgr).
+		WithReservedCapacity(2000).
+		WithFactor(1000).
+		WithDemandInterval(10 * time.Millisecond).
+		WithCapacityLending()
+	lending := make(chan uint32, 1)
+	var partitions int32
+	res.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		switch {
+		case event == gobatcher.LendingEvent && val > 0:
+			select {
+			case lending <- metadata.(uint32):
+			default:
+			}
+		case event == gobatcher.ProvisionStartEvent:
+			atomic.StoreInt32(&partitions, int32(val))
+		}
+	})
+	res.GiveMe(500)
+	err := res.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+
+	select {
+	case pooled := <-lending:
+		assert.Equal(t, uint32(2000), pooled)
+	case <-time.After(1 * time.Second):
+		assert.FailNow(t, "expected a lending event within 1 second")
+	}
+	mgr.AssertCalled(t, "WriteLent", mock.Anything, mock.Anything, uint32(1000))
+	assert.Equal(t, uint32(1000), res.Capacity(), "expecting only whole partitions of the unwanted reserved capacity to be lent")
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&partitions) == 6
+	}, time.Second, 10*time.Millisecond, "expecting the shared pool to grow by the capacity lent across the fleet")
+
+	res.GiveMe(1500)
+	assert.Equal(t, uint32(2000), res.Capacity(), "expecting the loan to be reclaimed immediately")
+}
+
+func TestSharedResource_CapacityLending_LendsNoMoreThanMaxLentCapacity(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mgr := &mockLendingLeaseManager{}
+	mgr.On("RaiseEventsTo", mock.Anything)
+	mgr.On("Provision", mock.Anything).Return(nil)
+	mgr.On("CreatePartitions", mock.Anything, mock.Anything)
+	mgr.On("LeasePartition", mock.Anything, mock.Anything, mock.Anything).Return(time.Duration(0))
+	mgr.On("WriteDemand", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mgr.On("ReadDemand", mock.Anything, mock.Anything).Return(map[string]uint32{}, nil)
+	mgr.On("WriteLent", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mgr.On("ReadLent", mock.Anything, mock.Anything).Return(map[string]uint32{"a": 2000}, nil)
+
+	res := gobatcher.NewSharedResource().
+		WithSharedCapacity(4000, mgr).
+		WithReservedCapacity(5000).
+		WithFactor(1000).
+		WithDemandInterval(10 * time.Millisecond).
+		WithCapacityLending().
+		WithMaxLentCapacity(2500)
+	lending := make(chan int, 1)
+	res.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		if event == gobatcher.LendingEvent && val > 0 {
+			select {
+			case lending <- val:
+			default:
+			}
+		}
+	})
+	err := res.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+
+	select {
+	case lent := <-lending:
+		assert.Equal(t, 2000, lent, "expecting whole partitions up to the max to be lent")
+	case <-time.After(1 * time.Second):
+		assert.FailNow(t, "expected a lending event within 1 second")
+	}
+	assert.Equal(t, uint32(3000), res.Capacity(), "expecting the reserved capacity that was not lent to be kept")
+}
+
+func TestSharedResource_Loop_ClockSkewMarginExpiresLeasesEarly(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mgr := &mockLeaseManager{}
+	mgr.On("RaiseEventsTo", mock.Anything)
+	mgr.On("Provision", mock.Anything).Return(nil)
+	mgr.On("CreatePartitions", mock.Anything, 1)
+	mgr.On("LeasePartition", mock.Anything, mock.Anything, mock.Anything).Return(10 * time.Second)
+	res := gobatcher.NewSharedResource().
+		WithSharedCapacity(1000, mgr).
+		WithFactor(1000).
+		WithMaxInterval(1).
+		WithClockSkewMargin(9950 * time.Millisecond)
+
+	allocated := make(chan time.Time, 1)
+	released := make(chan time.Time, 1)
+	res.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		switch event {
+		case gobatcher.AllocatedEvent:
+			select {
+			case allocated <- time.Now():
+			default:
+			}
+		case gobatcher.ReleasedEvent:
+			select {
+			case released <- time.Now():
+			default:
+			}
+		}
+	})
+
+	err := res.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	res.GiveMe(1000)
+	start := <-allocated
+	select {
+	case end := <-released:
+		assert.Less(t, int64(end.Sub(start)), int64(time.Second), "expecting the lease to be treated as expired 9.95s early")
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "expected the lease to be released before the timeout")
+	}
+}
+
+func TestSharedResource_Loop_ClockSkewMarginLongerThanLeaseDoesNotAllocate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mgr := &mockLeaseManager{}
+	mgr.On("RaiseEventsTo", mock.Anything)
+	mgr.On("Provision", mock.Anything).Return(nil)
+	mgr.On("CreatePartitions", mock.Anything, 1)
+	mgr.On("LeasePartition", mock.Anything, mock.Anything, mock.Anything).Return(1 * time.Second)
+	res := gobatcher.NewSharedResource().
+		WithSharedCapacity(1000, mgr).
+		WithFactor(1000).
+		WithMaxInterval(1).
+		WithClockSkewMargin(1 * time.Second)
+
+	var allocated uint32
+	res.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		if event == gobatcher.AllocatedEvent {
+			atomic.AddUint32(&allocated, 1)
+		}
+	})
+
+	err := res.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	res.GiveMe(1000)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, uint32(0), atomic.LoadUint32(&allocated), "expecting no allocation since the lease would already be expired")
+	assert.Equal(t, uint32(0), res.Capacity())
+}
+
+func TestSharedResource_Loop_LeasesAreRenewedWhileNeeded(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mgr := &mockRenewingLeaseManager{}
+	mgr.On("RaiseEventsTo", mock.Anything)
+	mgr.On("Provision", mock.Anything).Return(nil)
+	mgr.On("CreatePartitions", mock.Anything, 1)
+	mgr.On("LeasePartition", mock.Anything, mock.Anything, mock.Anything).Return(30 * time.Millisecond)
+	mgr.On("RenewPartition", mock.Anything, mock.Anything, mock.Anything).Return(30 * time.Millisecond)
+	res := gobatcher.NewSharedResource().
+		WithSharedCapacity(1000, mgr).
+		WithFactor(1000).
+		WithMaxInterval(1)
+	var renewed, released uint32
+	res.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		switch event {
+		case gobatcher.RenewedEvent:
+			atomic.AddUint32(&renewed, 1)
+		case gobatcher.ReleasedEvent:
+			atomic.AddUint32(&released, 1)
+		}
+	})
+	err := res.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	res.GiveMe(1000)
+	time.Sleep(200 * time.Millisecond)
+	assert.GreaterOrEqual(t, atomic.LoadUint32(&renewed), uint32(3), "expecting the lease to be renewed several times")
+	assert.Equal(t, uint32(0), atomic.LoadUint32(&released), "expecting the lease to never be released while it is needed")
+	assert.Equal(t, uint32(1000), res.Capacity())
+	mgr.AssertNumberOfCalls(t, "LeasePartition", 1)
+
+	res.GiveMe(0)
+	assert.Eventually(t, func() bool {
+		return atomic.LoadUint32(&released) == 1
+	}, time.Second, 5*time.Millisecond, "expecting the lease to be released once it is no longer needed")
+	assert.Equal(t, uint32(0), res.Capacity())
+}
+
+func TestSharedResource_GiveMe_SurplusPartitionsAreReleasedImmediately(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mgr := &mockReleasingLeaseManager{}
+	mgr.On("RaiseEventsTo", mock.Anything)
+	mgr.On("Provision", mock.Anything).Return(nil)
+	mgr.On("CreatePartitions", mock.Anything, 10)
+	mgr.On("LeasePartition", mock.Anything, mock.Anything, mock.Anything).Return(time.Minute)
+	mgr.On("ReleasePartition", mock.Anything, mock.Anything, mock.Anything)
+	res := gobatcher.NewSharedResource().
+		WithSharedCapacity(10000, mgr).
+		WithFactor(1000).
+		WithMaxInterval(1)
+	var released uint32
+	res.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		switch event {
+		case gobatcher.ReleasedEvent:
+			atomic.AddUint32(&released, 1)
+		}
+	})
+	err := res.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	res.GiveMe(4000)
+	assert.Eventually(t, func() bool {
+		return res.Capacity() == 4000
+	}, time.Second, 5*time.Millisecond, "expecting the partitions to be leased")
+
+	res.GiveMe(1000)
+	assert.Eventually(t, func() bool {
+		return res.Capacity() == 1000
+	}, time.Second, 5*time.Millisecond, "expecting the surplus partitions to be released without waiting for the leases to expire")
+	assert.Eventually(t, func() bool {
+		return atomic.LoadUint32(&released) == 3
+	}, time.Second, 5*time.Millisecond, "expecting a released event for each surplus partition")
+	mgr.AssertNumberOfCalls(t, "ReleasePartition", 3)
+}
+
+func TestSharedResource_Loop_FailedLeasesRaiseContentionEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mgr := &mockLeaseManager{}
+	mgr.On("RaiseEventsTo", mock.Anything)
+	mgr.On("Provision", mock.Anything).Return(nil)
+	mgr.On("CreatePartitions", mock.Anything, 10)
+	mgr.On("LeasePartition", mock.Anything, mock.Anything, mock.Anything).Return(time.Duration(0))
+	res := gobatcher.NewSharedResource().
+		WithSharedCapacity(10000, mgr).
+		WithFactor(1000).
+		WithMaxInterval(1)
+	contention := make(chan int, 100)
+	res.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		if event == gobatcher.ContentionEvent {
+			select {
+			case contention <- val:
+			default:
+			}
+		}
+	})
+	err := res.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	res.GiveMe(1000)
+	for expected := 1; expected <= 5; expected++ {
+		select {
+		case val := <-contention:
+			assert.Equal(t, expected, val, "expecting the count of consecutive failures")
+		case <-time.After(time.Second):
+			assert.Fail(t, "expected a contention event within 1 second")
+			return
+		}
+	}
+}
+
+func TestSharedResource_Status_ReportsPartitionsAndLeaseErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mgr := &mockLeaseManager{}
+	mgr.On("RaiseEventsTo", mock.Anything)
+	mgr.On("Provision", mock.Anything).Return(nil)
+	mgr.On("CreatePartitions", mock.Anything, 10)
+	mgr.On("LeasePartition", mock.Anything, mock.Anything, mock.Anything).Return(15 * time.Second)
+	res := gobatcher.NewSharedResource().
+		WithSharedCapacity(10000, mgr).
+		WithFactor(1000).
+		WithMaxInterval(1)
+	assert.Equal(t, "uninitialized", res.Status().Phase)
+	assert.False(t, res.Healthy(), "expecting to not be healthy before start")
+	err := res.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	assert.True(t, res.Healthy(), "expecting to be healthy once started")
+
+	res.GiveMe(2000)
+	assert.Eventually(t, func() bool {
+		return res.Status().Partitions == 2
+	}, time.Second, 5*time.Millisecond, "expecting 2 partitions to be leased")
+	status := res.Status()
+	assert.Equal(t, "started", status.Phase)
+	assert.Equal(t, uint32(2), status.Target)
+	assert.Equal(t, uint32(2000), status.Capacity)
+	assert.False(t, status.LastLease.IsZero(), "expecting the time of the last lease")
+	assert.Nil(t, status.LastError)
+
+	lerr := errors.New("the datastore is unavailable")
+	res.Emit(gobatcher.ErrorEvent, 0, lerr.Error(), lerr)
+	assert.False(t, res.Healthy(), "expecting to not be healthy after a lease error")
+	err = res.Ready()
+	assert.ErrorIs(t, err, gobatcher.LeaseManagerUnreachableError, "expecting to not be ready after a lease error")
+	assert.Contains(t, err.Error(), lerr.Error(), "expecting the lease error to be included")
+	assert.Equal(t, lerr, res.Status().LastError)
+	res.GiveMe(3000)
+	assert.Eventually(t, res.Healthy, time.Second, 5*time.Millisecond, "expecting to be healthy once a partition is leased")
+
+	cancel()
+	assert.Eventually(t, func() bool {
+		return res.Status().Phase == "stopped"
+	}, time.Second, 5*time.Millisecond, "expecting to be stopped")
+	assert.False(t, res.Healthy(), "expecting to not be healthy once stopped")
+	assert.ErrorIs(t, res.Ready(), gobatcher.NotRunningError, "expecting to not be ready once stopped")
+}
@@ -0,0 +1,154 @@
+package simulation
+
+import (
+	"sync"
+	"time"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+)
+
+// virtualClock is the gobatcher.Clock of a dry run. Unlike testutil.FakeClock, the dry run fires one waiter at a time (see next() and
+// fire()) so that it can wait for the Batcher to finish handling each tick before the next, which keeps the results deterministic.
+type virtualClock struct {
+	mutex   sync.Mutex
+	now     time.Time
+	seq     uint64
+	waiters []*virtualWaiter
+}
+
+// virtualWaiter is a pending timer, ticker, After(), or Sleep(). A period of 0 means it only fires once.
+type virtualWaiter struct {
+	clock  *virtualClock
+	seq    uint64
+	due    time.Time
+	period time.Duration
+	ch     chan time.Time
+}
+
+func newVirtualClock(now time.Time) *virtualClock {
+	return &virtualClock{now: now}
+}
+
+func (c *virtualClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *virtualClock) After(d time.Duration) <-chan time.Time {
+	return c.add(d, 0).ch
+}
+
+func (c *virtualClock) NewTicker(d time.Duration) gobatcher.Ticker {
+	return &virtualTicker{c.add(d, d)}
+}
+
+func (c *virtualClock) NewTimer(d time.Duration) gobatcher.Timer {
+	return &virtualTimer{c.add(d, 0)}
+}
+
+func (c *virtualClock) Sleep(d time.Duration) {
+	<-c.After(d)
+}
+
+// This returns the waiter that is due first; waiters that are due at the same time are returned in the order they were added.
+func (c *virtualClock) next() (*virtualWaiter, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var first *virtualWaiter
+	for _, w := range c.waiters {
+		if first == nil || w.due.Before(first.due) || (w.due.Equal(first.due) && w.seq < first.seq) {
+			first = w
+		}
+	}
+	return first, first != nil
+}
+
+// This moves the clock to the provided time without firing anything.
+func (c *virtualClock) set(now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = now
+}
+
+// This fires the waiter (which must be due) and returns true if it is a ticker, since the processing loop of the Batcher reads every
+// ticker it has.
+func (c *virtualClock) fire(w *virtualWaiter) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	select {
+	case w.ch <- w.due:
+	default:
+		// the previous tick was not read
+	}
+	if w.period > 0 {
+		w.due = w.due.Add(w.period)
+		return true
+	}
+	c.removeLocked(w)
+	return false
+}
+
+func (c *virtualClock) add(d, period time.Duration) *virtualWaiter {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.seq++
+	w := &virtualWaiter{clock: c, seq: c.seq, due: c.now.Add(d), period: period, ch: make(chan time.Time, 1)}
+	c.waiters = append(c.waiters, w)
+	return w
+}
+
+// This removes the waiter and returns true if it was still waiting.
+func (c *virtualClock) removeLocked(w *virtualWaiter) bool {
+	for i, waiter := range c.waiters {
+		if waiter == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type virtualTicker struct {
+	w *virtualWaiter
+}
+
+func (t *virtualTicker) C() <-chan time.Time {
+	return t.w.ch
+}
+
+func (t *virtualTicker) Reset(d time.Duration) {
+	c := t.w.clock
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	t.w.period = d
+	t.w.due = c.now.Add(d)
+	for _, waiter := range c.waiters {
+		if waiter == t.w {
+			return
+		}
+	}
+	c.waiters = append(c.waiters, t.w)
+}
+
+func (t *virtualTicker) Stop() {
+	c := t.w.clock
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.removeLocked(t.w)
+}
+
+type virtualTimer struct {
+	w *virtualWaiter
+}
+
+func (t *virtualTimer) C() <-chan time.Time {
+	return t.w.ch
+}
+
+func (t *virtualTimer) Stop() bool {
+	c := t.w.clock
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.removeLocked(t.w)
+}
@@ -0,0 +1,253 @@
+package simulation
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+)
+
+// If the Batcher does not finish handling a tick within this much real time, the dry run fails with StalledError.
+const stallTimeout = 10 * time.Second
+
+var StalledError = errors.New("the dry run stalled waiting for the batcher.")
+
+// DryRunConfig describes a single Batcher, the SharedResource it draws capacity from, and the workload it receives. Only Duration and
+// Workload are required, though without SharedCapacity or ReservedCapacity nothing can be processed. Dry runs with the same config
+// (including the Seed) report the same results.
+type DryRunConfig struct {
+	Duration         time.Duration // the virtual time to simulate; a dry run usually takes a fraction of this
+	SharedCapacity   uint32
+	ReservedCapacity uint32
+	Factor           uint32        // defaults to 1
+	MaxInterval      uint32        // defaults to 500 (ms) to match SharedResource
+	FlushInterval    time.Duration // defaults to the Batcher's default
+	Resolution       time.Duration // how often the workload enqueues; defaults to 10ms
+	OperationCost    uint32        // defaults to 1
+	Seed             int64         // seeds the intervals between lease attempts
+	Workload         Workload      // called with an instance of 0
+
+	Configure        func(batcher gobatcher.Batcher) // optional hook to further configure the Batcher
+	ConfigureWatcher func(watcher gobatcher.Watcher) // optional hook to configure the Watcher (for instance, WithMaxBatchSize())
+}
+
+// DryRunReport projects what the configuration would achieve. Every duration and rate is in virtual time.
+type DryRunReport struct {
+	Elapsed          time.Duration
+	Enqueued         uint64
+	Rejected         uint64 // Operations that could not be enqueued (for instance, because the buffer was full)
+	Processed        uint64
+	Backlog          uint32  // Operations still in the buffer at the end
+	Throughput       float64 // Operations processed per second
+	Flushes          uint64
+	Batches          uint64
+	BatchSizes       map[int]uint64 // the number of batches of each size
+	AverageBatchSize float64
+	P50BatchSize     int
+	P95BatchSize     int
+	MaxBatchSize     int
+	AverageCapacity  float64 // the capacity of the rate limiter, averaged across flushes
+	Consumed         uint64  // the capacity consumed by every flush
+	Utilization      float64 // the fraction of the capacity available to the flushes that was consumed
+}
+
+// This method runs a single Batcher against a virtual clock for DryRunConfig.Duration and reports the projected throughput, batch sizes,
+// and capacity utilization. Time only moves when the Batcher has finished handling the previous tick (and its batches have finished),
+// so a dry run is deterministic and usually takes far less than the Duration. The Watcher succeeds immediately, so the projection does
+// not include the latency of your datastore. Unlike Run(), capacity is not contended by other instances.
+func DryRun(ctx context.Context, cfg DryRunConfig) (*DryRunReport, error) {
+
+	// validate
+	if cfg.Duration <= 0 {
+		return nil, NoDurationError
+	}
+	cfg.applyDefaults()
+
+	// the batcher is stopped when the dry run is done
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// create the batcher
+	start := time.Now()
+	clock := newVirtualClock(start)
+	resource := newModeledResource(cfg, start)
+	batcher := gobatcher.NewBatcher().
+		WithClock(clock).
+		WithRateLimiter(resource).
+		WithErrorOnFullBuffer().
+		WithEmitBatch().
+		WithEmitFlush()
+	if cfg.FlushInterval > 0 {
+		batcher.WithFlushInterval(cfg.FlushInterval)
+	}
+	if cfg.Configure != nil {
+		cfg.Configure(batcher)
+	}
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {})
+	if cfg.ConfigureWatcher != nil {
+		cfg.ConfigureWatcher(watcher)
+	}
+
+	// record the outcome of each tick; the processing loop raises exactly 1 of these events (or asks the rate limiter for capacity) per tick
+	report := &DryRunReport{BatchSizes: make(map[int]uint64)}
+	var mutex sync.Mutex
+	var handled uint64
+	var capacity uint64
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		switch event {
+		case gobatcher.BatchEvent:
+			report.Batches++
+			report.BatchSizes[val]++
+		case gobatcher.FlushDoneEvent:
+			stats, _ := metadata.(gobatcher.FlushStats)
+			report.Flushes++
+			report.Consumed += uint64(stats.Consumed)
+			capacity += uint64(stats.Capacity)
+			report.AverageCapacity += float64(resource.Capacity())
+			atomic.AddUint64(&handled, 1)
+		case gobatcher.AuditPassEvent, gobatcher.AuditFailEvent, gobatcher.AuditSkipEvent, gobatcher.SummaryEvent:
+			atomic.AddUint64(&handled, 1)
+		}
+	})
+	ticks := func() uint64 {
+		return atomic.LoadUint64(&handled) + resource.requested()
+	}
+
+	// start
+	if err := batcher.Start(ctx); err != nil {
+		return nil, err
+	}
+
+	// step through virtual time; when things happen at the same time, the workload enqueues first, then the rate limiter attempts a
+	// lease, then the clock fires in the order its waiters were added
+	end := start.Add(cfg.Duration)
+	nextEnqueue := start.Add(cfg.Resolution)
+	var owed float64
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		now := nextEnqueue
+		if attempt := resource.next(); attempt.Before(now) {
+			now = attempt
+		}
+		waiter, waiting := clock.next()
+		if waiting && waiter.due.Before(now) {
+			now = waiter.due
+		}
+		if now.After(end) {
+			break
+		}
+		clock.set(now)
+		switch {
+		case now.Equal(nextEnqueue):
+			owed += float64(cfg.Workload(0, now.Sub(start)-cfg.Resolution)) * cfg.Resolution.Seconds()
+			for ; owed >= 1; owed-- {
+				op := gobatcher.NewOperation(watcher, cfg.OperationCost, struct{}{}, true)
+				if err := batcher.Enqueue(op); err != nil {
+					report.Rejected++
+					continue
+				}
+				report.Enqueued++
+			}
+			nextEnqueue = nextEnqueue.Add(cfg.Resolution)
+		case now.Equal(resource.next()):
+			resource.attempt()
+		default:
+			before := ticks()
+			if !clock.fire(waiter) {
+				continue // a one-time waiter, such as the MaxOperationTime of a batch that already finished
+			}
+			if !settle(func() bool { return ticks() > before && batcher.Stats().Running == 0 }) {
+				return nil, StalledError
+			}
+		}
+	}
+
+	// report
+	stats := batcher.Stats()
+	mutex.Lock()
+	defer mutex.Unlock()
+	report.Elapsed = cfg.Duration
+	report.Processed = stats.Operations
+	report.Backlog = stats.OperationsInBuffer
+	report.Throughput = float64(report.Processed) / cfg.Duration.Seconds()
+	if report.Flushes > 0 {
+		report.AverageCapacity /= float64(report.Flushes)
+	}
+	if capacity > 0 {
+		report.Utilization = float64(report.Consumed) / float64(capacity)
+	}
+	report.summarizeBatchSizes()
+
+	return report, nil
+}
+
+func (c *DryRunConfig) applyDefaults() {
+	if c.Factor == 0 {
+		c.Factor = 1
+	}
+	if c.MaxInterval == 0 {
+		c.MaxInterval = 500
+	}
+	if c.Resolution <= 0 {
+		c.Resolution = 10 * time.Millisecond
+	}
+	if c.OperationCost == 0 {
+		c.OperationCost = 1
+	}
+	if c.Workload == nil {
+		c.Workload = ConstantWorkload(0)
+	}
+}
+
+// This yields to the Batcher's goroutines until the condition is true. It returns false if that takes longer than the stallTimeout.
+func settle(condition func() bool) bool {
+	deadline := time.Now().Add(stallTimeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		runtime.Gosched()
+	}
+	return true
+}
+
+// This calculates the average, percentiles, and max from the distribution of batch sizes.
+func (r *DryRunReport) summarizeBatchSizes() {
+	if r.Batches == 0 {
+		return
+	}
+	sizes := make([]int, 0, len(r.BatchSizes))
+	var total uint64
+	for size, count := range r.BatchSizes {
+		sizes = append(sizes, size)
+		total += uint64(size) * count
+	}
+	sort.Ints(sizes)
+	r.AverageBatchSize = float64(total) / float64(r.Batches)
+	r.MaxBatchSize = sizes[len(sizes)-1]
+	percentile := func(p float64) int {
+		rank := uint64(p*float64(r.Batches) + 0.5)
+		if rank < 1 {
+			rank = 1
+		}
+		var seen uint64
+		for _, size := range sizes {
+			seen += r.BatchSizes[size]
+			if seen >= rank {
+				return size
+			}
+		}
+		return r.MaxBatchSize
+	}
+	r.P50BatchSize = percentile(0.50)
+	r.P95BatchSize = percentile(0.95)
+}
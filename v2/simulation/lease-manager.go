@@ -0,0 +1,78 @@
+package simulation
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+)
+
+type lease struct {
+	id      string
+	expires time.Time
+}
+
+// leaseStore is the in-memory stand-in for the blob container that all instances in a simulation compete for.
+type leaseStore struct {
+	mutex         sync.Mutex
+	leaseDuration time.Duration
+	partitions    []lease
+}
+
+func newLeaseStore(leaseDuration time.Duration) *leaseStore {
+	return &leaseStore{
+		leaseDuration: leaseDuration,
+	}
+}
+
+func (s *leaseStore) createPartitions(count int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if count > len(s.partitions) {
+		partitions := make([]lease, count)
+		copy(partitions, s.partitions)
+		s.partitions = partitions
+	}
+}
+
+func (s *leaseStore) acquire(id string, index uint32) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if int(index) >= len(s.partitions) {
+		return false
+	}
+	now := time.Now()
+	if s.partitions[index].id != "" && now.Before(s.partitions[index].expires) {
+		return false
+	}
+	s.partitions[index] = lease{id: id, expires: now.Add(s.leaseDuration)}
+	return true
+}
+
+// leaseManager is the per-instance view of the leaseStore; each SharedResource needs its own because events are raised to a
+// single Eventer.
+type leaseManager struct {
+	store   *leaseStore
+	eventer gobatcher.Eventer
+}
+
+func (m *leaseManager) RaiseEventsTo(e gobatcher.Eventer) {
+	m.eventer = e
+}
+
+func (m *leaseManager) Provision(ctx context.Context) (err error) {
+	return nil
+}
+
+func (m *leaseManager) CreatePartitions(ctx context.Context, count int) {
+	m.store.createPartitions(count)
+}
+
+func (m *leaseManager) LeasePartition(ctx context.Context, id string, index uint32) (leaseTime time.Duration) {
+	if !m.store.acquire(id, index) {
+		m.eventer.Emit(gobatcher.FailedEvent, int(index), "", nil)
+		return 0
+	}
+	return m.store.leaseDuration
+}
@@ -0,0 +1,156 @@
+package simulation
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+)
+
+// The most partitions a SharedResource will create.
+const maxPartitions = 500
+
+// modeledResource is the RateLimiter of a dry run. It grants capacity the way a SharedResource (with a LeaseManager that renews and
+// releases leases, such as AzureBlobLeaseManager) would for a single instance: reserved capacity is always available, each partition of
+// shared capacity is worth Factor, a lease is attempted after a random interval of up to MaxInterval (drawn from a seeded source), and
+// surplus partitions are released as soon as the target drops. Rather than running its own goroutine, it is driven by the dry run.
+type modeledResource struct {
+	gobatcher.EventerBase
+	reserved    uint32
+	factor      uint32
+	partitions  uint32
+	maxInterval uint32
+	random      *rand.Rand
+
+	mutex       sync.Mutex
+	held        uint32
+	target      uint32
+	nextAttempt time.Time
+	requests    uint64 // the number of times GiveMe() was called
+}
+
+func newModeledResource(cfg DryRunConfig, now time.Time) *modeledResource {
+	partitions := uint32(math.Ceil(float64(cfg.SharedCapacity) / float64(cfg.Factor)))
+	if partitions > maxPartitions {
+		partitions = maxPartitions
+	}
+	r := &modeledResource{
+		reserved:    cfg.ReservedCapacity,
+		factor:      cfg.Factor,
+		partitions:  partitions,
+		maxInterval: cfg.MaxInterval,
+		random:      rand.New(rand.NewSource(cfg.Seed)),
+	}
+	r.nextAttempt = now.Add(r.interval())
+	return r
+}
+
+// This returns how long the SharedResource would sleep before its next attempt to obtain a partition.
+func (r *modeledResource) interval() time.Duration {
+	return time.Duration(r.random.Intn(int(r.maxInterval))) * time.Millisecond
+}
+
+// This returns when the next lease will be attempted.
+func (r *modeledResource) next() time.Time {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.nextAttempt
+}
+
+// This obtains a partition if more are needed (there is no contention with a single instance) and schedules the next attempt. Attempts
+// that would happen at the same time are made 1ms apart so that time always advances.
+func (r *modeledResource) attempt() {
+	r.mutex.Lock()
+	obtained := r.held < r.target && r.held < r.partitions
+	if obtained {
+		r.held++
+	}
+	interval := r.interval()
+	if interval <= 0 {
+		interval = time.Millisecond
+	}
+	r.nextAttempt = r.nextAttempt.Add(interval)
+	capacity := r.capacityLocked()
+	r.mutex.Unlock()
+	if obtained {
+		r.Emit(gobatcher.CapacityEvent, int(capacity), "", nil)
+	}
+}
+
+func (r *modeledResource) capacityLocked() uint32 {
+	return r.held*r.factor + r.reserved
+}
+
+func (r *modeledResource) MaxCapacity() uint32 {
+	return r.partitions*r.factor + r.reserved
+}
+
+func (r *modeledResource) Capacity() uint32 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.capacityLocked()
+}
+
+// This sets the number of partitions needed for the target (beyond the reserved capacity) and releases any surplus right away.
+func (r *modeledResource) GiveMe(target uint32) {
+	r.mutex.Lock()
+	r.requests++
+	if target > r.reserved {
+		target -= r.reserved
+	} else {
+		target = 0
+	}
+	r.target = uint32(math.Ceil(float64(target) / float64(r.factor)))
+	released := r.held > r.target
+	if released {
+		r.held = r.target
+	}
+	capacity := r.capacityLocked()
+	r.mutex.Unlock()
+	r.Emit(gobatcher.TargetEvent, int(target), "", nil)
+	if released {
+		r.Emit(gobatcher.CapacityEvent, int(capacity), "", nil)
+	}
+}
+
+// This returns the number of times GiveMe() was called.
+func (r *modeledResource) requested() uint64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.requests
+}
+
+// Batcher does not reserve capacity when it flushes, so this only checks the cost against the current capacity.
+func (r *modeledResource) Reserve(cost uint32, ttl time.Duration) (gobatcher.ReservationHandle, error) {
+	if float64(cost) > float64(r.Capacity())*ttl.Seconds() {
+		return nil, gobatcher.InsufficientCapacityError
+	}
+	return reservation(cost), nil
+}
+
+// Batcher calls this to flush early once a starved Operation could be afforded. In a dry run, flushes only happen on the FlushInterval
+// so that they happen at the same virtual time in every run; this waits until the context is done.
+func (r *modeledResource) WaitForCapacity(ctx context.Context, cost uint32) error {
+	if cost > r.MaxCapacity() {
+		return gobatcher.TooExpensiveError
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// There is nothing to start; the dry run drives the resource.
+func (r *modeledResource) Start(ctx context.Context) error {
+	return nil
+}
+
+// reservation is the handle returned by Reserve(); the modeled resource does not track reservations.
+type reservation uint32
+
+func (h reservation) Cost() uint32 {
+	return uint32(h)
+}
+
+func (h reservation) Release() {}
@@ -0,0 +1,262 @@
+// Package simulation runs several SharedResource and Batcher instances inside a single process, all competing for the same
+// in-memory partitions, so that settings such as Factor and MaxInterval can be tuned before they are used in production. DryRun()
+// instead runs a single Batcher against a virtual clock, so it can quickly and deterministically project the throughput, batch sizes,
+// and capacity utilization of settings such as Factor and FlushInterval for a synthetic workload.
+package simulation
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+)
+
+var (
+	NoInstancesError = errors.New("the simulation requires at least 1 instance.")
+	NoDurationError  = errors.New("the simulation requires a duration.")
+)
+
+// Workload returns how many operations per second the instance should enqueue at the elapsed time in the simulation.
+type Workload func(instance int, elapsed time.Duration) uint32
+
+// This workload enqueues the same number of operations per second for every instance for the entire simulation.
+func ConstantWorkload(opsPerSecond uint32) Workload {
+	return func(instance int, elapsed time.Duration) uint32 {
+		return opsPerSecond
+	}
+}
+
+// Config describes the fleet being simulated. Only Instances, Duration, SharedCapacity, and Workload are required.
+type Config struct {
+	Instances        int
+	Duration         time.Duration
+	SharedCapacity   uint32
+	ReservedCapacity uint32
+	Factor           uint32
+	MaxInterval      uint32
+	LeaseDuration    time.Duration // defaults to 15s to match AzureBlobLeaseManager
+	SampleInterval   time.Duration // defaults to 100ms
+	Tolerance        float64       // fraction of the achievable capacity considered converged; defaults to 0.9
+	OperationCost    uint32        // defaults to 1
+	Workload         Workload
+	Configure        func(instance int, batcher gobatcher.Batcher) // optional hook to further configure each Batcher
+}
+
+// InstanceReport describes what a single instance experienced during the simulation.
+type InstanceReport struct {
+	Enqueued        uint64
+	Processed       uint64
+	Rejected        uint64
+	Allocations     uint64
+	Releases        uint64
+	Failures        uint64
+	AverageCapacity float64 // shared capacity only, averaged across samples
+}
+
+// Report summarizes the simulation.
+type Report struct {
+	Elapsed         time.Duration
+	Converged       bool
+	ConvergenceTime time.Duration // time until the fleet first held the achievable capacity (see Config.Tolerance)
+	Fairness        float64       // Jain's fairness index of the average shared capacity held by each instance (1.0 is perfectly fair)
+	Thrash          uint64        // total number of partition allocations and releases across the fleet
+	ThrashPerSecond float64
+	Instances       []InstanceReport
+}
+
+type instance struct {
+	resource    gobatcher.SharedResource
+	batcher     gobatcher.Batcher
+	enqueued    uint64
+	processed   uint64
+	rejected    uint64
+	allocations uint64
+	releases    uint64
+	failures    uint64
+	capacitySum float64
+}
+
+func (c *Config) applyDefaults() {
+	if c.Factor == 0 {
+		c.Factor = 1
+	}
+	if c.MaxInterval == 0 {
+		c.MaxInterval = 500
+	}
+	if c.LeaseDuration <= 0 {
+		c.LeaseDuration = 15 * time.Second
+	}
+	if c.SampleInterval <= 0 {
+		c.SampleInterval = 100 * time.Millisecond
+	}
+	if c.Tolerance <= 0 || c.Tolerance > 1 {
+		c.Tolerance = 0.9
+	}
+	if c.OperationCost == 0 {
+		c.OperationCost = 1
+	}
+	if c.Workload == nil {
+		c.Workload = ConstantWorkload(0)
+	}
+}
+
+// This method runs the simulation for Config.Duration (or until the context is cancelled) and then reports on it.
+func Run(ctx context.Context, cfg Config) (*Report, error) {
+
+	// validate
+	if cfg.Instances < 1 {
+		return nil, NoInstancesError
+	}
+	if cfg.Duration <= 0 {
+		return nil, NoDurationError
+	}
+	cfg.applyDefaults()
+
+	// the simulation stops when the duration is exceeded
+	ctx, cancel := context.WithTimeout(ctx, cfg.Duration)
+	defer cancel()
+
+	// create the instances
+	store := newLeaseStore(cfg.LeaseDuration)
+	instances := make([]*instance, cfg.Instances)
+	for i := range instances {
+		inst := &instance{}
+		inst.resource = gobatcher.NewSharedResource().
+			WithSharedCapacity(cfg.SharedCapacity, &leaseManager{store: store}).
+			WithReservedCapacity(cfg.ReservedCapacity).
+			WithFactor(cfg.Factor).
+			WithMaxInterval(cfg.MaxInterval)
+		inst.resource.AddListener(func(event string, val int, msg string, metadata interface{}) {
+			switch event {
+			case gobatcher.AllocatedEvent:
+				atomic.AddUint64(&inst.allocations, 1)
+			case gobatcher.ReleasedEvent:
+				atomic.AddUint64(&inst.releases, 1)
+			case gobatcher.FailedEvent:
+				atomic.AddUint64(&inst.failures, 1)
+			}
+		})
+		inst.batcher = gobatcher.NewBatcher().
+			WithRateLimiter(inst.resource).
+			WithErrorOnFullBuffer()
+		if cfg.Configure != nil {
+			cfg.Configure(i, inst.batcher)
+		}
+		instances[i] = inst
+	}
+
+	// start everything
+	for _, inst := range instances {
+		if err := inst.resource.Start(ctx); err != nil {
+			return nil, err
+		}
+		if err := inst.batcher.Start(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	// drive the workloads
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i, inst := range instances {
+		wg.Add(1)
+		go func(i int, inst *instance) {
+			defer wg.Done()
+			drive(ctx, cfg, start, i, inst)
+		}(i, inst)
+	}
+
+	// sample
+	report := &Report{}
+	samples := 0
+	ticker := time.NewTicker(cfg.SampleInterval)
+	defer ticker.Stop()
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-ticker.C:
+			samples++
+			var held, wanted uint64
+			for _, inst := range instances {
+				shared := inst.resource.Capacity() - cfg.ReservedCapacity
+				inst.capacitySum += float64(shared)
+				held += uint64(shared)
+				need := uint64(inst.batcher.NeedsCapacity())
+				if need > uint64(cfg.ReservedCapacity) {
+					wanted += uint64(math.Ceil(float64(need-uint64(cfg.ReservedCapacity))/float64(cfg.Factor))) * uint64(cfg.Factor)
+				}
+			}
+			achievable := uint64(cfg.SharedCapacity)
+			if wanted < achievable {
+				achievable = wanted
+			}
+			if !report.Converged && achievable > 0 && float64(held) >= float64(achievable)*cfg.Tolerance {
+				report.Converged = true
+				report.ConvergenceTime = time.Since(start)
+			}
+		}
+	}
+	wg.Wait()
+
+	// report
+	report.Elapsed = time.Since(start)
+	var sum, sumOfSquares float64
+	for _, inst := range instances {
+		ir := InstanceReport{
+			Enqueued:    atomic.LoadUint64(&inst.enqueued),
+			Processed:   atomic.LoadUint64(&inst.processed),
+			Rejected:    atomic.LoadUint64(&inst.rejected),
+			Allocations: atomic.LoadUint64(&inst.allocations),
+			Releases:    atomic.LoadUint64(&inst.releases),
+			Failures:    atomic.LoadUint64(&inst.failures),
+		}
+		if samples > 0 {
+			ir.AverageCapacity = inst.capacitySum / float64(samples)
+		}
+		sum += ir.AverageCapacity
+		sumOfSquares += ir.AverageCapacity * ir.AverageCapacity
+		report.Thrash += ir.Allocations + ir.Releases
+		report.Instances = append(report.Instances, ir)
+	}
+	if sumOfSquares > 0 {
+		report.Fairness = (sum * sum) / (float64(len(instances)) * sumOfSquares)
+	}
+	if report.Elapsed > 0 {
+		report.ThrashPerSecond = float64(report.Thrash) / report.Elapsed.Seconds()
+	}
+
+	return report, nil
+}
+
+func drive(ctx context.Context, cfg Config, start time.Time, i int, inst *instance) {
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		atomic.AddUint64(&inst.processed, uint64(len(batch)))
+	})
+	tick := 10 * time.Millisecond
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	var owed float64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rate := cfg.Workload(i, time.Since(start))
+			owed += float64(rate) * tick.Seconds()
+			for ; owed >= 1; owed-- {
+				op := gobatcher.NewOperation(watcher, cfg.OperationCost, struct{}{}, true)
+				if err := inst.batcher.Enqueue(op); err != nil {
+					atomic.AddUint64(&inst.rejected, 1)
+					continue
+				}
+				atomic.AddUint64(&inst.enqueued, 1)
+			}
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package simulation_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+	"github.com/plasne/go-batcher/v2/simulation"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun_RequiresInstancesAndDuration(t *testing.T) {
+	_, err := simulation.Run(context.Background(), simulation.Config{Duration: time.Second})
+	assert.Equal(t, simulation.NoInstancesError, err)
+	_, err = simulation.Run(context.Background(), simulation.Config{Instances: 1})
+	assert.Equal(t, simulation.NoDurationError, err)
+}
+
+func TestRun_FleetConvergesOnSharedCapacity(t *testing.T) {
+	report, err := simulation.Run(context.Background(), simulation.Config{
+		Instances:      2,
+		Duration:       1500 * time.Millisecond,
+		SharedCapacity: 4000,
+		Factor:         1000,
+		MaxInterval:    10,
+		LeaseDuration:  300 * time.Millisecond,
+		Workload:       simulation.ConstantWorkload(5000),
+	})
+	assert.NoError(t, err, "not expecting a simulation error")
+	assert.True(t, report.Converged, "expecting the fleet to obtain all shared capacity")
+	assert.Less(t, report.ConvergenceTime, 1500*time.Millisecond)
+	assert.Greater(t, report.Fairness, 0.5, "expecting both instances to get a reasonable share")
+	assert.LessOrEqual(t, report.Fairness, 1.0)
+	assert.Greater(t, report.Thrash, uint64(0), "expecting partitions to be allocated and released")
+	assert.Len(t, report.Instances, 2)
+	for _, instance := range report.Instances {
+		assert.Greater(t, instance.Processed, uint64(0), "expecting each instance to process operations")
+		assert.Greater(t, instance.Allocations, uint64(0), "expecting each instance to obtain partitions")
+	}
+}
+
+func TestDryRun_RequiresDuration(t *testing.T) {
+	_, err := simulation.DryRun(context.Background(), simulation.DryRunConfig{})
+	assert.Equal(t, simulation.NoDurationError, err)
+}
+
+func TestDryRun_ProjectsThroughputAndBatchSizes(t *testing.T) {
+	cfg := simulation.DryRunConfig{
+		Duration:       10 * time.Second,
+		SharedCapacity: 10000,
+		Factor:         1000,
+		MaxInterval:    100,
+		FlushInterval:  100 * time.Millisecond,
+		Workload:       simulation.ConstantWorkload(2000),
+		ConfigureWatcher: func(watcher gobatcher.Watcher) {
+			watcher.WithMaxBatchSize(50)
+		},
+	}
+	started := time.Now()
+	report, err := simulation.DryRun(context.Background(), cfg)
+	assert.NoError(t, err, "not expecting a dry run error")
+	assert.Less(t, time.Since(started), cfg.Duration, "expecting the dry run to take less than the virtual duration")
+	assert.Equal(t, cfg.Duration, report.Elapsed)
+	assert.Equal(t, uint64(20000), report.Enqueued+report.Rejected, "expecting the workload to enqueue 2000 per second")
+	assert.Greater(t, report.Throughput, 1800.0, "expecting nearly all of the workload to be processed")
+	assert.LessOrEqual(t, report.Throughput, 2000.0)
+	assert.Equal(t, uint64(100), report.Flushes, "expecting a flush every 100ms")
+	assert.Equal(t, 50, report.MaxBatchSize, "expecting batches to be limited by the watcher")
+	assert.LessOrEqual(t, report.P50BatchSize, report.P95BatchSize)
+	assert.Greater(t, report.AverageBatchSize, 0.0)
+	var batches uint64
+	for _, count := range report.BatchSizes {
+		batches += count
+	}
+	assert.Equal(t, report.Batches, batches, "expecting the distribution to include every batch")
+	assert.Greater(t, report.AverageCapacity, 0.0)
+	assert.LessOrEqual(t, report.AverageCapacity, 10000.0)
+	assert.Greater(t, report.Utilization, 0.5, "expecting most of the capacity that was obtained to be used")
+	assert.LessOrEqual(t, report.Utilization, 1.0)
+}
+
+func TestDryRun_IsDeterministic(t *testing.T) {
+	cfg := simulation.DryRunConfig{
+		Duration:       5 * time.Second,
+		SharedCapacity: 5000,
+		Factor:         500,
+		FlushInterval:  50 * time.Millisecond,
+		Seed:           42,
+		Workload: func(instance int, elapsed time.Duration) uint32 {
+			if elapsed < 2*time.Second {
+				return 3000
+			}
+			return 500
+		},
+	}
+	first, err := simulation.DryRun(context.Background(), cfg)
+	assert.NoError(t, err, "not expecting a dry run error")
+	second, err := simulation.DryRun(context.Background(), cfg)
+	assert.NoError(t, err, "not expecting a dry run error")
+	assert.Equal(t, first, second, "expecting the same report for the same config and seed")
+}
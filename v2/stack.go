@@ -0,0 +1,51 @@
+package batcher
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+const maxStackBufferSize = 64 << 20
+
+// This returns the ID of the calling goroutine as it appears in the output of runtime.Stack() (for instance, "goroutine 42 [running]:").
+// Go intentionally does not expose this ID, so it is only used to find the goroutine in a later dump of all stacks.
+func currentGoroutineID() uint64 {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	buf = bytes.TrimPrefix(buf, []byte("goroutine "))
+	if i := bytes.IndexByte(buf, ' '); i > 0 {
+		buf = buf[:i]
+	}
+	id, err := strconv.ParseUint(string(buf), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return id
+}
+
+// This dumps the stacks of all goroutines and returns the stack of the goroutine with the provided ID or an empty string if it is
+// no longer running.
+func goroutineStack(id uint64) string {
+	if id == 0 {
+		return ""
+	}
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= maxStackBufferSize {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	prefix := fmt.Sprintf("goroutine %d ", id)
+	for _, stack := range strings.Split(string(buf), "\n\n") {
+		if strings.HasPrefix(stack, prefix) {
+			return stack
+		}
+	}
+	return ""
+}
@@ -0,0 +1,183 @@
+// Package statsd converts the events raised by a Batcher (and its rate limiters) into StatsD metrics, so you get dashboards (for
+// instance, in Datadog) without writing listeners yourself.
+package statsd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	gobatcher "github.com/plasne/go-batcher/v2"
+)
+
+// If WithPrefix() is not used, every metric is named "gobatcher.*".
+const DefaultPrefix = "gobatcher."
+
+// Emitter writes a StatsD metric for each event it understands. It reports the following metrics (without the prefix):
+//
+//   - batches (counter) and operations (counter): the number of batches raised and the Operations in them (requires Batcher.WithEmitBatch).
+//   - flush (timer): how long each flush took (requires Batcher.WithEmitFlush).
+//   - consumed (counter): the capacity consumed by flushes (requires Batcher.WithEmitFlush).
+//   - requested (gauge): the capacity last requested of the rate limiter (requires Batcher.WithEmitRequest).
+//   - audit.failures, batch.failures, timeouts, panics, dead_letters, expired, cancelled, coalesced, and dropped (counters): the number of each of those events.
+//   - enqueue.errors (counter): the number of Operations that could not be enqueued, tagged by "reason" if tags are enabled.
+//   - capacity (gauge) and target (gauge): the capacity and target partitions of a rate limiter.
+//   - lease.failures (counter): the number of partitions a rate limiter failed to lease.
+type Emitter struct {
+	prefix string
+	tags   []string
+	tagged bool
+
+	writerMutex sync.Mutex
+	writer      io.Writer
+
+	mutex      sync.Mutex
+	flushStart time.Time
+	listeners  map[gobatcher.Eventer]uuid.UUID
+}
+
+// This method creates an Emitter that writes each metric to the provided writer, which is typically a UDP connection to the StatsD agent
+// (for instance, `net.Dial("udp", "127.0.0.1:8125")`). Each metric is a separate write so that it is a separate datagram. Errors writing
+// are ignored, the same as StatsD clients generally do.
+func NewEmitter(w io.Writer) *Emitter {
+	return &Emitter{
+		prefix:    DefaultPrefix,
+		writer:    w,
+		listeners: make(map[gobatcher.Eventer]uuid.UUID),
+	}
+}
+
+// This determines the prefix of every metric name. It should end with a "." unless it is empty.
+func (e *Emitter) WithPrefix(prefix string) *Emitter {
+	e.prefix = prefix
+	return e
+}
+
+// This adds tags (for instance, "env:prod") to every metric using the DogStatsD extension ("|#tag1,tag2"). Calling this (even with no
+// tags) also enables the tags of individual metrics, such as the "reason" of enqueue.errors. Do not use this with a StatsD server that
+// does not understand tags.
+func (e *Emitter) WithTags(tags ...string) *Emitter {
+	e.tags = append(e.tags, tags...)
+	e.tagged = true
+	return e
+}
+
+// This method adds a listener to the Batcher or rate limiter so its events are written as metrics. You may watch several.
+func (e *Emitter) Watch(eventer gobatcher.Eventer) *Emitter {
+	id := eventer.AddListener(e.onEvent)
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.listeners[eventer] = id
+	return e
+}
+
+// This method removes the listeners that were added by Watch().
+func (e *Emitter) Close() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for eventer, id := range e.listeners {
+		eventer.RemoveListener(id)
+	}
+	e.listeners = make(map[gobatcher.Eventer]uuid.UUID)
+}
+
+func (e *Emitter) onEvent(event string, val int, msg string, metadata interface{}) {
+	switch event {
+	case gobatcher.BatchEvent:
+		e.write("batches", 1, "c")
+		e.write("operations", val, "c")
+	case gobatcher.FlushStartEvent:
+		e.mutex.Lock()
+		e.flushStart = time.Now()
+		e.mutex.Unlock()
+	case gobatcher.FlushDoneEvent:
+		e.mutex.Lock()
+		start := e.flushStart
+		e.mutex.Unlock()
+		if !start.IsZero() {
+			e.write("flush", int(time.Since(start).Milliseconds()), "ms")
+		}
+		e.write("consumed", val, "c")
+	case gobatcher.RequestEvent:
+		// requests for the rate limiter of a Watcher include that rate limiter as the metadata
+		if metadata == nil {
+			e.write("requested", val, "g")
+		}
+	case gobatcher.AuditFailEvent:
+		e.write("audit.failures", 1, "c")
+	case gobatcher.BatchFailedEvent:
+		e.write("batch.failures", 1, "c")
+	case gobatcher.TimeoutEvent:
+		e.write("timeouts", 1, "c")
+	case gobatcher.PanicEvent:
+		e.write("panics", 1, "c")
+	case gobatcher.DeadLetterEvent:
+		e.write("dead_letters", 1, "c")
+	case gobatcher.ExpiredEvent:
+		e.write("expired", 1, "c")
+	case gobatcher.CancelledEvent:
+		e.write("cancelled", 1, "c")
+	case gobatcher.CoalescedEvent:
+		e.write("coalesced", 1, "c")
+	case gobatcher.DroppedEvent:
+		e.write("dropped", 1, "c")
+	case gobatcher.PurgedEvent:
+		e.write("purged", 1, "c")
+	case gobatcher.EnqueueErrorEvent:
+		err, _ := metadata.(error)
+		e.write("enqueue.errors", 1, "c", "reason:"+reason(err))
+	case gobatcher.CapacityEvent:
+		e.write("capacity", val, "g")
+	case gobatcher.TargetEvent:
+		e.write("target", val, "g")
+	case gobatcher.FailedEvent:
+		e.write("lease.failures", 1, "c")
+	}
+}
+
+// This writes a single metric, such as "gobatcher.batches:1|c|#env:prod".
+func (e *Emitter) write(name string, val int, kind string, tags ...string) {
+	var line strings.Builder
+	fmt.Fprintf(&line, "%s%s:%d|%s", e.prefix, name, val, kind)
+	if e.tagged {
+		all := append(append([]string{}, e.tags...), tags...)
+		if len(all) > 0 {
+			line.WriteString("|#")
+			line.WriteString(strings.Join(all, ","))
+		}
+	}
+	e.writerMutex.Lock()
+	defer e.writerMutex.Unlock()
+	_, _ = io.WriteString(e.writer, line.String())
+}
+
+// This returns a tag value for the error raised by enqueue-error.
+func reason(err error) string {
+	switch {
+	case errors.Is(err, gobatcher.BufferFullError):
+		return "buffer-full"
+	case errors.Is(err, gobatcher.BufferIsShutdown):
+		return "shutdown"
+	case errors.Is(err, gobatcher.TooExpensiveError):
+		return "too-expensive"
+	case errors.Is(err, gobatcher.TooManyAttemptsError):
+		return "too-many-attempts"
+	case errors.Is(err, gobatcher.NotStartedError):
+		return "not-started"
+	case errors.Is(err, gobatcher.OperationExpiredError):
+		return "expired"
+	case errors.Is(err, gobatcher.DuplicateOperationError):
+		return "duplicate"
+	case errors.Is(err, gobatcher.NoOperationError), errors.Is(err, gobatcher.NoWatcherError):
+		return "invalid"
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return "context"
+	default:
+		return "other"
+	}
+}
@@ -0,0 +1,63 @@
+package statsd_test
+
+import (
+	"sync"
+	"testing"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+	"github.com/plasne/go-batcher/v2/statsd"
+	"github.com/stretchr/testify/assert"
+)
+
+// Each write is a datagram, so the writer records each separately.
+type datagrams struct {
+	mutex sync.Mutex
+	lines []string
+}
+
+func (d *datagrams) Write(p []byte) (int, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.lines = append(d.lines, string(p))
+	return len(p), nil
+}
+
+func TestEmitter_EventsAreWrittenAsMetrics(t *testing.T) {
+	var out datagrams
+	batcher := gobatcher.NewBatcher()
+	res := gobatcher.NewSharedResource()
+	emitter := statsd.NewEmitter(&out).
+		Watch(batcher).
+		Watch(res)
+	batcher.Emit(gobatcher.BatchEvent, 5, "", nil)
+	batcher.Emit(gobatcher.AuditFailEvent, 0, "", nil)
+	batcher.Emit(gobatcher.EnqueueErrorEvent, 10, "", gobatcher.BufferFullError)
+	res.Emit(gobatcher.CapacityEvent, 1000, "", nil)
+	emitter.Close()
+	batcher.Emit(gobatcher.BatchEvent, 5, "", nil)
+	expected := []string{
+		"gobatcher.batches:1|c",
+		"gobatcher.operations:5|c",
+		"gobatcher.audit.failures:1|c",
+		"gobatcher.enqueue.errors:1|c",
+		"gobatcher.capacity:1000|g",
+	}
+	assert.Equal(t, expected, out.lines, "expecting a metric for each event until the emitter is closed")
+}
+
+func TestEmitter_WithPrefixAndTags(t *testing.T) {
+	var out datagrams
+	batcher := gobatcher.NewBatcher()
+	emitter := statsd.NewEmitter(&out).
+		WithPrefix("writer.").
+		WithTags("env:prod").
+		Watch(batcher)
+	defer emitter.Close()
+	batcher.Emit(gobatcher.RequestEvent, 800, "", nil)
+	batcher.Emit(gobatcher.EnqueueErrorEvent, 10, "", gobatcher.TooExpensiveError)
+	expected := []string{
+		"writer.requested:800|g|#env:prod",
+		"writer.enqueue.errors:1|c|#env:prod,reason:too-expensive",
+	}
+	assert.Equal(t, expected, out.lines, "expecting the prefix and tags on each metric")
+}
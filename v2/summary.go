@@ -0,0 +1,83 @@
+package batcher
+
+import (
+	"sync"
+	"time"
+)
+
+// Summary aggregates what a Batcher did over a period. It is the metadata of the summary event.
+type Summary struct {
+	Period         time.Duration // the time covered by the summary
+	Batches        int           // the number of batches that finished (or timed out)
+	Operations     int           // the number of Operations in those batches
+	Failures       int           // the number of those Operations that failed or were abandoned
+	AverageLatency time.Duration // the average time it took a batch to finish
+	Flushes        int           // the number of flushes
+	Capacity       uint32        // the average capacity available to a flush (0 if there is no rate limiter)
+	Consumed       uint64        // the total cost of the Operations dispatched
+	Utilization    float64       // the fraction of the capacity available to all flushes that was consumed
+}
+
+// summarizer accumulates the counts for a Summary. Batches finish on their own goroutines so it must be threadsafe.
+type summarizer struct {
+	clock      Clock
+	mutex      sync.Mutex
+	started    time.Time
+	batches    int
+	operations int
+	failures   int
+	latency    time.Duration
+	flushes    int
+	capacity   uint64
+	consumed   uint64
+}
+
+func newSummarizer(clock Clock) *summarizer {
+	return &summarizer{clock: clock, started: clock.Now()}
+}
+
+func (s *summarizer) batchDone(operations, failures int, latency time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.batches++
+	s.operations += operations
+	s.failures += failures
+	s.latency += latency
+}
+
+func (s *summarizer) flushDone(stats FlushStats) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.flushes++
+	s.capacity += uint64(stats.Capacity)
+	s.consumed += uint64(stats.Consumed)
+}
+
+// This returns the Summary of everything since the last reset and then starts a new period.
+func (s *summarizer) reset() Summary {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := s.clock.Now()
+	summary := Summary{
+		Period:     now.Sub(s.started),
+		Batches:    s.batches,
+		Operations: s.operations,
+		Failures:   s.failures,
+		Flushes:    s.flushes,
+		Consumed:   s.consumed,
+	}
+	if s.batches > 0 {
+		summary.AverageLatency = s.latency / time.Duration(s.batches)
+	}
+	if s.flushes > 0 {
+		summary.Capacity = uint32(s.capacity / uint64(s.flushes))
+	}
+	if s.capacity > 0 {
+		summary.Utilization = float64(s.consumed) / float64(s.capacity)
+	}
+	s.started = now
+	s.batches, s.operations, s.failures, s.flushes = 0, 0, 0, 0
+	s.latency = 0
+	s.capacity, s.consumed = 0, 0
+	return summary
+}
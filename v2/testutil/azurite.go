@@ -0,0 +1,145 @@
+// Package testutil contains fakes and helpers for testing code that uses Batcher and SharedResource, including running integration
+// tests against real (emulated) infrastructure. It is not needed at runtime.
+package testutil
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+)
+
+const (
+	// These are the well-known development credentials that Azurite always accepts.
+	AzuriteAccountName = "devstoreaccount1"
+	AzuriteAccountKey  = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+
+	// Set this environment variable to the blob endpoint of an Azurite instance you started yourself (for instance, with
+	// docker compose in CI) to skip starting a container. For example, "http://127.0.0.1:10000/devstoreaccount1".
+	AzuriteEndpointEnvVar = "AZURITE_BLOB_ENDPOINT"
+
+	azuriteImage = "mcr.microsoft.com/azure-storage/azurite"
+)
+
+var (
+	DockerNotAvailableError = errors.New("docker is not available and no Azurite endpoint was provided.")
+)
+
+// Azurite describes a running Azurite blob service.
+type Azurite struct {
+	Endpoint    string
+	AccountName string
+	AccountKey  string
+	containerID string
+}
+
+// This method starts Azurite in a container using the docker CLI and waits until the blob service accepts connections. If the
+// AZURITE_BLOB_ENDPOINT environment variable is set, that endpoint is used instead and no container is started. You must call
+// Stop() when you are done to remove the container.
+func StartAzurite(ctx context.Context) (*Azurite, error) {
+
+	// use a provided endpoint
+	if endpoint := os.Getenv(AzuriteEndpointEnvVar); endpoint != "" {
+		a := &Azurite{
+			Endpoint:    strings.TrimSuffix(endpoint, "/"),
+			AccountName: AzuriteAccountName,
+			AccountKey:  AzuriteAccountKey,
+		}
+		return a, a.waitForReady(ctx)
+	}
+
+	// make sure docker is available
+	if _, err := exec.LookPath("docker"); err != nil {
+		return nil, DockerNotAvailableError
+	}
+
+	// start the container on a random port
+	id, err := docker(ctx, "run", "-d", "--rm", "-p", "127.0.0.1::10000", azuriteImage,
+		"azurite-blob", "--blobHost", "0.0.0.0", "--loose", "--skipApiVersionCheck")
+	if err != nil {
+		return nil, err
+	}
+	a := &Azurite{
+		AccountName: AzuriteAccountName,
+		AccountKey:  AzuriteAccountKey,
+		containerID: id,
+	}
+
+	// discover the port
+	hostPort, err := docker(ctx, "port", id, "10000/tcp")
+	if err != nil {
+		a.Stop()
+		return nil, err
+	}
+	hostPort = strings.Split(hostPort, "\n")[0]
+	a.Endpoint = fmt.Sprintf("http://%s/%s", hostPort, AzuriteAccountName)
+
+	// wait for it to come up
+	if err := a.waitForReady(ctx); err != nil {
+		a.Stop()
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func docker(ctx context.Context, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("docker %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+func (a *Azurite) waitForReady(ctx context.Context) error {
+	u, err := url.Parse(a.Endpoint)
+	if err != nil {
+		return err
+	}
+	for {
+		conn, err := net.DialTimeout("tcp", u.Host, 500*time.Millisecond)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("azurite at %s never became ready: %w", a.Endpoint, ctx.Err())
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// This method removes the Azurite container if one was started by StartAzurite(). It is safe to call more than once.
+func (a *Azurite) Stop() {
+	if a.containerID == "" {
+		return
+	}
+	_, _ = docker(context.Background(), "rm", "-f", a.containerID)
+	a.containerID = ""
+}
+
+// This method creates a LeaseManager that stores its partitions in the provided container on this Azurite instance.
+func (a *Azurite) NewLeaseManager(containerName string) gobatcher.LeaseManager {
+	return gobatcher.NewAzureBlobLeaseManagerWithEndpoint(a.Endpoint, a.AccountName, containerName, a.AccountKey)
+}
+
+// This method creates a SharedResource whose shared capacity is coordinated through the provided container on this Azurite
+// instance. Every SharedResource created with the same containerName competes for the same partitions, so you can create several
+// to simulate multiple instances. You may chain additional WithXXXX methods before calling Start().
+func (a *Azurite) NewSharedResource(containerName string, sharedCapacity, factor uint32) gobatcher.SharedResource {
+	return gobatcher.NewSharedResource().
+		WithSharedCapacity(sharedCapacity, a.NewLeaseManager(containerName)).
+		WithFactor(factor)
+}
@@ -0,0 +1,67 @@
+package testutil_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+	"github.com/plasne/go-batcher/v2/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAzurite_MultipleInstancesShareCapacity(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	azurite, err := testutil.StartAzurite(ctx)
+	if err != nil {
+		t.Skipf("azurite is not available: %v", err)
+	}
+	defer azurite.Stop()
+	container := fmt.Sprintf("test%d", time.Now().UnixNano())
+	res1 := azurite.NewSharedResource(container, 10000, 1000).WithMaxInterval(1)
+	res2 := azurite.NewSharedResource(container, 10000, 1000).WithMaxInterval(1)
+	rec1 := testutil.NewPartitionRecorder(res1)
+	defer rec1.Close()
+	rec2 := testutil.NewPartitionRecorder(res2)
+	defer rec2.Close()
+	rec1.WatchPeers(rec2)
+	rec2.WatchPeers(rec1)
+	err = res1.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	err = res2.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	res1.GiveMe(6000)
+	res2.GiveMe(6000)
+	testutil.AssertPartitionsHeldEventually(t, 10, 30*time.Second, rec1, rec2)
+	testutil.AssertNoPartitionOverlap(t, rec1, rec2)
+	assert.Equal(t, uint32(10000), res1.Capacity()+res2.Capacity())
+}
+
+func TestPartitionRecorder_TracksAllocatedAndReleased(t *testing.T) {
+	res := gobatcher.NewSharedResource()
+	rec := testutil.NewPartitionRecorder(res)
+	defer rec.Close()
+	res.Emit(gobatcher.AllocatedEvent, 2, "", nil)
+	res.Emit(gobatcher.AllocatedEvent, 0, "", nil)
+	assert.Equal(t, []int{0, 2}, rec.Partitions())
+	res.Emit(gobatcher.ReleasedEvent, 2, "", nil)
+	assert.Equal(t, []int{0}, rec.Partitions())
+	assert.True(t, rec.Holds(0))
+}
+
+func TestPartitionRecorder_DetectsOverlap(t *testing.T) {
+	res1 := gobatcher.NewSharedResource()
+	res2 := gobatcher.NewSharedResource()
+	rec1 := testutil.NewPartitionRecorder(res1)
+	rec2 := testutil.NewPartitionRecorder(res2)
+	rec1.WatchPeers(rec2)
+	rec2.WatchPeers(rec1)
+	res1.Emit(gobatcher.AllocatedEvent, 4, "", nil)
+	res2.Emit(gobatcher.AllocatedEvent, 4, "", nil)
+	assert.Equal(t, 1, rec2.Overlaps())
+	mock := &testing.T{}
+	testutil.AssertNoPartitionOverlap(mock, rec1, rec2)
+	assert.True(t, mock.Failed(), "expecting the overlap to fail the assertion")
+}
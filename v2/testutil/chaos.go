@@ -0,0 +1,233 @@
+package testutil
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+)
+
+// This is the error wrapped by the LeaseError of every failure injected by ChaosLeaseManager.
+var ChaosError = errors.New("the failure was injected by ChaosLeaseManager.")
+
+// ChaosLeaseManager wraps another LeaseManager (such as AzureBlobLeaseManager or batchertest.FakeLeaseManager) and injects failures,
+// latency, and lease steals so you can validate how your code behaves when capacity is hard to obtain or keep. An injected failure raises
+// an ErrorEvent with a *LeaseError wrapping ChaosError (as a real outage would) and the call is not passed to the wrapped LeaseManager. A
+// stolen lease cannot be renewed and raises a FailedEvent, as if another instance had broken it.
+//
+// ChaosLeaseManager always implements LeaseRenewer and LeaseReleaser. If the wrapped LeaseManager does not, renewals fail quietly (so the
+// lease runs out as it would have) and releases do nothing.
+type ChaosLeaseManager struct {
+	inner gobatcher.LeaseManager
+
+	mutex       sync.Mutex
+	random      *rand.Rand
+	failureRate float64
+	stealRate   float64
+	latency     time.Duration
+	jitter      time.Duration
+	outage      bool
+	stolen      map[uint32]bool
+	failures    int
+	steals      int
+
+	eventerMutex sync.Mutex
+	eventer      gobatcher.Eventer
+}
+
+// This method creates a ChaosLeaseManager that passes every call to the provided LeaseManager until failures, latency, or steals are
+// configured.
+func NewChaosLeaseManager(inner gobatcher.LeaseManager) *ChaosLeaseManager {
+	return &ChaosLeaseManager{
+		inner:  inner,
+		random: rand.New(rand.NewSource(time.Now().UnixNano())),
+		stolen: make(map[uint32]bool),
+	}
+}
+
+// This determines the fraction (0 to 1) of calls that fail. The default is 0.
+func (m *ChaosLeaseManager) WithFailureRate(val float64) *ChaosLeaseManager {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.failureRate = val
+	return m
+}
+
+// This determines the fraction (0 to 1) of renewals that find the lease was stolen. The default is 0.
+func (m *ChaosLeaseManager) WithStealRate(val float64) *ChaosLeaseManager {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.stealRate = val
+	return m
+}
+
+// This delays every call by the latency plus a random amount up to the jitter (or until the context is done). The default is no delay.
+func (m *ChaosLeaseManager) WithLatency(latency, jitter time.Duration) *ChaosLeaseManager {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.latency = latency
+	m.jitter = jitter
+	return m
+}
+
+// This seeds the random decisions so that the same calls fail each time the test is run. By default, the seed is the current time.
+func (m *ChaosLeaseManager) WithSeed(seed int64) *ChaosLeaseManager {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.random = rand.New(rand.NewSource(seed))
+	return m
+}
+
+// This method makes every call fail until EndOutage() is called, as if the datastore were unreachable.
+func (m *ChaosLeaseManager) StartOutage() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.outage = true
+}
+
+// This method ends the outage started by StartOutage().
+func (m *ChaosLeaseManager) EndOutage() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.outage = false
+}
+
+// This method steals the leases on the provided partitions so that the next renewal of each fails.
+func (m *ChaosLeaseManager) Steal(indexes ...uint32) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, index := range indexes {
+		m.stolen[index] = true
+	}
+}
+
+// This returns the number of failures that were injected.
+func (m *ChaosLeaseManager) Failures() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.failures
+}
+
+// This returns the number of renewals that failed because the lease was stolen.
+func (m *ChaosLeaseManager) Steals() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.steals
+}
+
+// This is called by SharedResource.WithSharedCapacity().
+func (m *ChaosLeaseManager) RaiseEventsTo(e gobatcher.Eventer) {
+	m.eventerMutex.Lock()
+	m.eventer = e
+	m.eventerMutex.Unlock()
+	m.inner.RaiseEventsTo(e)
+}
+
+// This returns a *LeaseError (that is also raised as an ErrorEvent) if a failure is injected.
+func (m *ChaosLeaseManager) Provision(ctx context.Context) error {
+	if err := m.inject(ctx, gobatcher.LeaseOperationProvision, -1); err != nil {
+		return err
+	}
+	return m.inner.Provision(ctx)
+}
+
+// If a failure is injected, no partitions are created.
+func (m *ChaosLeaseManager) CreatePartitions(ctx context.Context, count int) {
+	if m.inject(ctx, gobatcher.LeaseOperationCreatePartition, -1) != nil {
+		return
+	}
+	m.inner.CreatePartitions(ctx, count)
+}
+
+// If a failure is injected, 0 is returned.
+func (m *ChaosLeaseManager) LeasePartition(ctx context.Context, id string, index uint32) time.Duration {
+	if m.inject(ctx, gobatcher.LeaseOperationAcquireLease, int(index)) != nil {
+		return 0
+	}
+	leaseTime := m.inner.LeasePartition(ctx, id, index)
+	if leaseTime > 0 {
+		m.mutex.Lock()
+		delete(m.stolen, index)
+		m.mutex.Unlock()
+	}
+	return leaseTime
+}
+
+// If a failure is injected or the lease was stolen, 0 is returned.
+func (m *ChaosLeaseManager) RenewPartition(ctx context.Context, id string, index uint32) time.Duration {
+	if m.inject(ctx, gobatcher.LeaseOperationRenewLease, int(index)) != nil {
+		return 0
+	}
+	m.mutex.Lock()
+	stolen := m.stolen[index] || m.roll(m.stealRate)
+	if stolen {
+		delete(m.stolen, index)
+		m.steals++
+	}
+	m.mutex.Unlock()
+	if stolen {
+		m.emit(gobatcher.FailedEvent, int(index), "", nil)
+		return 0
+	}
+	if renewer, ok := m.inner.(gobatcher.LeaseRenewer); ok {
+		return renewer.RenewPartition(ctx, id, index)
+	}
+	return 0
+}
+
+// If a failure is injected, the lease is not released.
+func (m *ChaosLeaseManager) ReleasePartition(ctx context.Context, id string, index uint32) {
+	if m.inject(ctx, gobatcher.LeaseOperationReleaseLease, int(index)) != nil {
+		return
+	}
+	if releaser, ok := m.inner.(gobatcher.LeaseReleaser); ok {
+		releaser.ReleasePartition(ctx, id, index)
+	}
+}
+
+// This waits for the latency and then decides whether the call fails. If it does, an ErrorEvent is raised and the error is returned.
+func (m *ChaosLeaseManager) inject(ctx context.Context, operation string, index int) error {
+	m.mutex.Lock()
+	delay := m.latency
+	if m.jitter > 0 {
+		delay += time.Duration(m.random.Int63n(int64(m.jitter)))
+	}
+	m.mutex.Unlock()
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+		}
+	}
+	m.mutex.Lock()
+	fail := m.outage || m.roll(m.failureRate)
+	if fail {
+		m.failures++
+	}
+	m.mutex.Unlock()
+	if !fail {
+		return nil
+	}
+	lerr := &gobatcher.LeaseError{Operation: operation, Index: index, Err: ChaosError}
+	m.emit(gobatcher.ErrorEvent, 0, lerr.Error(), lerr)
+	return lerr
+}
+
+// This returns true with the provided probability. The mutex must be held.
+func (m *ChaosLeaseManager) roll(rate float64) bool {
+	return rate > 0 && m.random.Float64() < rate
+}
+
+func (m *ChaosLeaseManager) emit(event string, val int, msg string, metadata interface{}) {
+	m.eventerMutex.Lock()
+	eventer := m.eventer
+	m.eventerMutex.Unlock()
+	if eventer != nil {
+		eventer.Emit(event, val, msg, metadata)
+	}
+}
@@ -0,0 +1,98 @@
+package testutil_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+	"github.com/plasne/go-batcher/v2/batchertest"
+	"github.com/plasne/go-batcher/v2/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChaosLeaseManager_OutagesFailEveryCall(t *testing.T) {
+	ctx := context.Background()
+	mgr := testutil.NewChaosLeaseManager(batchertest.NewFakeLeaseManager().WithLeaseTime(time.Minute))
+	var errs []error
+	eventer := &gobatcher.EventerBase{}
+	eventer.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		if event == gobatcher.ErrorEvent {
+			errs = append(errs, metadata.(error))
+		}
+	})
+	mgr.RaiseEventsTo(eventer)
+	mgr.StartOutage()
+	err := mgr.Provision(ctx)
+	assert.ErrorIs(t, err, testutil.ChaosError, "expecting provisioning to fail during the outage")
+	assert.Equal(t, time.Duration(0), mgr.LeasePartition(ctx, "a", 1), "expecting no lease during the outage")
+	if assert.Len(t, errs, 2, "expecting an error event for each failure") {
+		var lerr *gobatcher.LeaseError
+		assert.True(t, errors.As(errs[1], &lerr), "expecting a lease error")
+		assert.Equal(t, gobatcher.LeaseOperationAcquireLease, lerr.Operation)
+		assert.Equal(t, 1, lerr.Index)
+	}
+	mgr.EndOutage()
+	assert.NoError(t, mgr.Provision(ctx), "expecting provisioning once the outage is over")
+	assert.Equal(t, time.Minute, mgr.LeasePartition(ctx, "a", 1), "expecting the lease once the outage is over")
+	assert.Equal(t, 2, mgr.Failures())
+}
+
+func TestChaosLeaseManager_StolenLeasesCannotBeRenewed(t *testing.T) {
+	ctx := context.Background()
+	mgr := testutil.NewChaosLeaseManager(batchertest.NewFakeLeaseManager().WithLeaseTime(time.Minute))
+	var failed []int
+	eventer := &gobatcher.EventerBase{}
+	eventer.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		if event == gobatcher.FailedEvent {
+			failed = append(failed, val)
+		}
+	})
+	mgr.RaiseEventsTo(eventer)
+	assert.Equal(t, time.Minute, mgr.LeasePartition(ctx, "a", 3))
+	mgr.Steal(3)
+	assert.Equal(t, time.Duration(0), mgr.RenewPartition(ctx, "a", 3), "expecting a stolen lease to not be renewed")
+	assert.Equal(t, []int{3}, failed, "expecting a failed event for the stolen lease")
+	assert.Equal(t, time.Minute, mgr.RenewPartition(ctx, "a", 3), "expecting only the next renewal to fail")
+	assert.Equal(t, 1, mgr.Steals())
+}
+
+func TestChaosLeaseManager_FailureRateIsRepeatableWithASeed(t *testing.T) {
+	ctx := context.Background()
+	results := func() []bool {
+		mgr := testutil.NewChaosLeaseManager(batchertest.NewFakeLeaseManager()).WithFailureRate(0.5).WithSeed(7)
+		mgr.RaiseEventsTo(&gobatcher.EventerBase{})
+		var leased []bool
+		for i := uint32(0); i < 20; i++ {
+			leased = append(leased, mgr.LeasePartition(ctx, "a", i) > 0)
+		}
+		return leased
+	}
+	first := results()
+	assert.Contains(t, first, true, "expecting some leases to succeed")
+	assert.Contains(t, first, false, "expecting some leases to fail")
+	assert.Equal(t, first, results(), "expecting the same failures with the same seed")
+}
+
+func TestChaosLeaseManager_LatencyDelaysCalls(t *testing.T) {
+	mgr := testutil.NewChaosLeaseManager(batchertest.NewFakeLeaseManager()).WithLatency(20*time.Millisecond, 0)
+	mgr.RaiseEventsTo(&gobatcher.EventerBase{})
+	started := time.Now()
+	mgr.LeasePartition(context.Background(), "a", 0)
+	assert.GreaterOrEqual(t, time.Since(started), 20*time.Millisecond, "expecting the call to be delayed")
+}
+
+func TestChaosLeaseManager_SharedResourceLosesCapacityDuringAnOutage(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mgr := testutil.NewChaosLeaseManager(batchertest.NewFakeLeaseManager().WithLeaseTime(300 * time.Millisecond))
+	res := gobatcher.NewSharedResource().WithSharedCapacity(4000, mgr).WithFactor(1000).WithMaxInterval(1)
+	assert.NoError(t, res.Start(ctx), "not expecting a start error")
+	res.GiveMe(4000)
+	assert.Eventually(t, func() bool { return res.Capacity() == 4000 }, 5*time.Second, 10*time.Millisecond, "expecting all capacity")
+	mgr.StartOutage()
+	assert.Eventually(t, func() bool { return res.Capacity() == 0 }, 5*time.Second, 10*time.Millisecond, "expecting capacity to be lost")
+	mgr.EndOutage()
+	assert.Eventually(t, func() bool { return res.Capacity() == 4000 }, 5*time.Second, 10*time.Millisecond, "expecting capacity to recover")
+}
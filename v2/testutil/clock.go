@@ -0,0 +1,165 @@
+package testutil
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+)
+
+// FakeClock is a gobatcher.Clock that only moves when Advance() is called, so tests can drive the intervals and timeouts of a Batcher or
+// SharedResource (see WithClock()) without sleeping. Like the time package, a ticker that is not read in time drops ticks.
+type FakeClock struct {
+	mutex   sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+	added   *sync.Cond
+}
+
+// fakeWaiter is a pending timer, ticker, After(), or Sleep(). A period of 0 means it only fires once.
+type fakeWaiter struct {
+	clock  *FakeClock
+	due    time.Time
+	period time.Duration
+	ch     chan time.Time
+}
+
+// This method creates a new FakeClock set to the provided time.
+func NewFakeClock(now time.Time) *FakeClock {
+	c := &FakeClock{now: now}
+	c.added = sync.NewCond(&c.mutex)
+	return c
+}
+
+// This returns the current time of the FakeClock.
+func (c *FakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+// This returns a channel that receives the time once the FakeClock has been advanced by the duration.
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	return c.add(d, 0).ch
+}
+
+// This returns a Ticker that fires each time the FakeClock is advanced past the period.
+func (c *FakeClock) NewTicker(d time.Duration) gobatcher.Ticker {
+	return &fakeTicker{c.add(d, d)}
+}
+
+// This returns a Timer that fires once the FakeClock has been advanced by the duration.
+func (c *FakeClock) NewTimer(d time.Duration) gobatcher.Timer {
+	return &fakeTimer{c.add(d, 0)}
+}
+
+// This blocks until the FakeClock has been advanced by the duration.
+func (c *FakeClock) Sleep(d time.Duration) {
+	<-c.After(d)
+}
+
+// This moves the FakeClock forward by the duration, firing every timer, ticker, After(), and Sleep() that comes due (in order, at the
+// time each was due).
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	end := c.now.Add(d)
+	for {
+		sort.SliceStable(c.waiters, func(i, j int) bool { return c.waiters[i].due.Before(c.waiters[j].due) })
+		if len(c.waiters) == 0 || c.waiters[0].due.After(end) {
+			break
+		}
+		w := c.waiters[0]
+		c.now = w.due
+		select {
+		case w.ch <- w.due:
+		default:
+			// the previous tick was not read
+		}
+		if w.period > 0 {
+			w.due = w.due.Add(w.period)
+		} else {
+			c.waiters = c.waiters[1:]
+		}
+	}
+	c.now = end
+}
+
+// This returns the number of timers, tickers, After(), and Sleep() calls that are waiting on the FakeClock.
+func (c *FakeClock) Waiters() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.waiters)
+}
+
+// This blocks until at least the provided number of timers, tickers, After(), and Sleep() calls are waiting on the FakeClock. Use this
+// before Advance() to ensure that a goroutine has started waiting, otherwise advancing would not wake it.
+func (c *FakeClock) BlockUntil(waiters int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for len(c.waiters) < waiters {
+		c.added.Wait()
+	}
+}
+
+func (c *FakeClock) add(d, period time.Duration) *fakeWaiter {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	w := &fakeWaiter{clock: c, due: c.now.Add(d), period: period, ch: make(chan time.Time, 1)}
+	c.waiters = append(c.waiters, w)
+	c.added.Broadcast()
+	return w
+}
+
+// This removes the waiter and returns true if it was still waiting.
+func (c *FakeClock) remove(w *fakeWaiter) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for i, waiter := range c.waiters {
+		if waiter == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTicker struct {
+	w *fakeWaiter
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.w.ch
+}
+
+func (t *fakeTicker) Reset(d time.Duration) {
+	c := t.w.clock
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	t.w.period = d
+	t.w.due = c.now.Add(d)
+	for _, waiter := range c.waiters {
+		if waiter == t.w {
+			return
+		}
+	}
+	c.waiters = append(c.waiters, t.w)
+	c.added.Broadcast()
+}
+
+func (t *fakeTicker) Stop() {
+	t.w.clock.remove(t.w)
+}
+
+type fakeTimer struct {
+	w *fakeWaiter
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.w.ch
+}
+
+func (t *fakeTimer) Stop() bool {
+	return t.w.clock.remove(t.w)
+}
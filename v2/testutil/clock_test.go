@@ -0,0 +1,69 @@
+package testutil_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+	"github.com/plasne/go-batcher/v2/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakeClock_FiresWaitersInOrder(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := testutil.NewFakeClock(start)
+	ticker := clock.NewTicker(2 * time.Second)
+	timer := clock.NewTimer(3 * time.Second)
+	after := clock.After(5 * time.Second)
+	clock.Advance(2 * time.Second)
+	assert.Equal(t, start.Add(2*time.Second), <-ticker.C(), "expecting the first tick")
+	clock.Advance(2 * time.Second)
+	assert.Equal(t, start.Add(3*time.Second), <-timer.C(), "expecting the timer")
+	assert.Equal(t, start.Add(4*time.Second), <-ticker.C(), "expecting the second tick")
+	assert.False(t, timer.Stop(), "expecting the timer to have already fired")
+	ticker.Stop()
+	clock.Advance(10 * time.Second)
+	assert.Equal(t, start.Add(5*time.Second), <-after, "expecting after to fire at the time it was due")
+	assert.Equal(t, start.Add(14*time.Second), clock.Now())
+	assert.Equal(t, 0, clock.Waiters(), "expecting nothing to be waiting")
+}
+
+func TestFakeClock_DrivesTheBatcher(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := testutil.NewFakeClock(time.Now())
+	timeouts := make(chan int, 1)
+	batcher := gobatcher.NewBatcher().
+		WithClock(clock).
+		WithFlushInterval(1 * time.Second).
+		WithMaxOperationTime(1 * time.Minute)
+	batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		timeouts <- val
+	}, gobatcher.OnlyEvents(gobatcher.TimeoutEvent))
+	batches := make(chan int, 1)
+	release := make(chan struct{})
+	defer close(release)
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		batches <- len(batch)
+		<-release
+	})
+	err := batcher.Enqueue(gobatcher.NewOperation(watcher, 0, struct{}{}, true))
+	assert.NoError(t, err, "not expecting an enqueue error")
+	err = batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+
+	// nothing is flushed until the clock reaches the flush interval
+	select {
+	case <-batches:
+		assert.Fail(t, "not expecting a flush before the flush interval")
+	default:
+	}
+	clock.Advance(1 * time.Second)
+	assert.Equal(t, 1, <-batches, "expecting the operation to be flushed")
+
+	// the batch times out once the clock reaches the max operation time (the capacity, flush, and audit tickers are also waiting)
+	clock.BlockUntil(4)
+	clock.Advance(1 * time.Minute)
+	assert.Equal(t, 1, <-timeouts, "expecting the batch to time out")
+}
@@ -0,0 +1,160 @@
+package testutil
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	gobatcher "github.com/plasne/go-batcher/v2"
+)
+
+// PartitionRecorder listens to the events raised by a SharedResource and keeps track of the partitions it currently holds. Use
+// one recorder per SharedResource to make assertions about how partitions are distributed across instances.
+type PartitionRecorder struct {
+	mutex    sync.Mutex
+	resource gobatcher.SharedResource
+	listener uuid.UUID
+	held     map[int]struct{}
+	overlaps int
+	peers    []*PartitionRecorder
+}
+
+// This method attaches a new PartitionRecorder to the SharedResource. It should be called before the SharedResource is started.
+func NewPartitionRecorder(res gobatcher.SharedResource) *PartitionRecorder {
+	r := &PartitionRecorder{
+		resource: res,
+		held:     make(map[int]struct{}),
+	}
+	r.listener = res.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		switch event {
+		case gobatcher.AllocatedEvent:
+			r.allocated(val)
+		case gobatcher.ReleasedEvent:
+			r.released(val)
+		}
+	})
+	return r
+}
+
+// This method tells the recorder about the recorders of other instances sharing the same partitions. Whenever this instance
+// allocates a partition that a peer still believes it holds, an overlap is counted.
+func (r *PartitionRecorder) WatchPeers(peers ...*PartitionRecorder) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, peer := range peers {
+		if peer != r {
+			r.peers = append(r.peers, peer)
+		}
+	}
+}
+
+func (r *PartitionRecorder) allocated(index int) {
+	r.mutex.Lock()
+	peers := r.peers
+	r.held[index] = struct{}{}
+	r.mutex.Unlock()
+	for _, peer := range peers {
+		if peer.Holds(index) {
+			r.mutex.Lock()
+			r.overlaps++
+			r.mutex.Unlock()
+		}
+	}
+}
+
+func (r *PartitionRecorder) released(index int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.held, index)
+}
+
+// This returns true if the SharedResource currently holds the partition.
+func (r *PartitionRecorder) Holds(index int) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	_, ok := r.held[index]
+	return ok
+}
+
+// This returns the sorted indexes of the partitions the SharedResource currently holds.
+func (r *PartitionRecorder) Partitions() []int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	list := make([]int, 0, len(r.held))
+	for index := range r.held {
+		list = append(list, index)
+	}
+	sort.Ints(list)
+	return list
+}
+
+// This returns the number of times a partition was allocated while a peer still held it.
+func (r *PartitionRecorder) Overlaps() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.overlaps
+}
+
+// This detaches the recorder from the SharedResource.
+func (r *PartitionRecorder) Close() {
+	r.resource.RemoveListener(r.listener)
+}
+
+// This method polls until the rate limiter reports exactly the expected capacity or the timeout is exceeded, in which case the
+// test fails.
+func AssertCapacityEventually(t testing.TB, rl gobatcher.RateLimiter, expected uint32, timeout time.Duration) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		actual := rl.Capacity()
+		if actual == expected {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Errorf("expected capacity of %d within %v, but it was %d", expected, timeout, actual)
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// This method polls until the recorders collectively hold the expected number of partitions or the timeout is exceeded, in
+// which case the test fails.
+func AssertPartitionsHeldEventually(t testing.TB, expected int, timeout time.Duration, recorders ...*PartitionRecorder) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		actual := 0
+		for _, recorder := range recorders {
+			actual += len(recorder.Partitions())
+		}
+		if actual == expected {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Errorf("expected %d partitions to be held within %v, but %d were held", expected, timeout, actual)
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// This method fails the test if any partition is currently held by more than one recorder or if any overlap was ever recorded.
+func AssertNoPartitionOverlap(t testing.TB, recorders ...*PartitionRecorder) {
+	t.Helper()
+	owners := make(map[int]int)
+	for i, recorder := range recorders {
+		if overlaps := recorder.Overlaps(); overlaps > 0 {
+			t.Errorf("recorder %d allocated a partition held by a peer %d time(s)", i, overlaps)
+		}
+		for _, index := range recorder.Partitions() {
+			if owner, ok := owners[index]; ok {
+				t.Errorf("partition %d is held by both recorder %d and recorder %d", index, owner, i)
+				continue
+			}
+			owners[index] = i
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package batcher
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+type TokenBucketLimiter interface {
+	RateLimiter
+	SetRefillRate(val uint32)
+	SetBurst(val uint32)
+}
+
+// tokenBucketLimiter is a RateLimiter for a single process that does not need a LeaseManager. The bucket holds up to burst tokens and
+// refills at a rate of tokens per second. The cost of each batch is taken from the bucket when the batch is dispatched.
+type tokenBucketLimiter struct {
+	EventerBase
+
+	mutex      sync.Mutex
+	refillRate uint32
+	burst      uint32
+	tokens     float64
+	last       time.Time
+}
+
+// This method creates a new TokenBucketLimiter that refills at refillRate tokens per second and holds up to burst tokens. The bucket
+// starts full. The Capacity is the refillRate and the MaxCapacity is the burst, so an Operation may cost up to the burst. Since a batch
+// takes its tokens at once, the burst should be at least the refillRate multiplied by the Batcher's FlushInterval.
+func NewTokenBucketLimiter(refillRate, burst uint32) TokenBucketLimiter {
+	return &tokenBucketLimiter{
+		refillRate: refillRate,
+		burst:      burst,
+		tokens:     float64(burst),
+		last:       time.Now(),
+	}
+}
+
+// This allows you to change the refill rate (tokens per second) after the TokenBucketLimiter has started.
+func (r *tokenBucketLimiter) SetRefillRate(val uint32) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.refill(time.Now())
+	r.refillRate = val
+}
+
+// This allows you to change the burst after the TokenBucketLimiter has started. If the bucket holds more tokens than the new burst,
+// the excess is discarded.
+func (r *tokenBucketLimiter) SetBurst(val uint32) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.refill(time.Now())
+	r.burst = val
+	r.tokens = math.Min(r.tokens, float64(val))
+}
+
+// This returns the burst, which is the most tokens the bucket can ever hold.
+func (r *tokenBucketLimiter) MaxCapacity() uint32 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.burst
+}
+
+// This returns the refill rate in tokens per second.
+func (r *tokenBucketLimiter) Capacity() uint32 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.refillRate
+}
+
+// The bucket does not change its refill rate based on demand, so the target is only raised as a request event.
+func (r *tokenBucketLimiter) GiveMe(target uint32) {
+	r.Emit(RequestEvent, int(target), "", nil)
+}
+
+// This takes cost tokens from the bucket if they are available now. If they are not, no tokens are taken and InsufficientCapacityError
+// is returned so the batch waits for a later flush. Tokens are spent once taken, so releasing the reservation does not return them.
+func (r *tokenBucketLimiter) Reserve(cost uint32, ttl time.Duration) (ReservationHandle, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.refill(time.Now())
+	if float64(cost) > r.tokens {
+		return nil, InsufficientCapacityError
+	}
+	r.tokens -= float64(cost)
+	return spentTokens(cost), nil
+}
+
+// This blocks until the bucket holds cost tokens, without taking them. If the cost exceeds the burst, TooExpensiveError is returned since
+// the bucket could never hold that many. If the context is done first, its error is returned.
+func (r *tokenBucketLimiter) WaitForCapacity(ctx context.Context, cost uint32) error {
+	for {
+		r.mutex.Lock()
+		if cost > r.burst {
+			r.mutex.Unlock()
+			return TooExpensiveError
+		}
+		now := time.Now()
+		r.refill(now)
+		missing := float64(cost) - r.tokens
+		rate := r.refillRate
+		r.mutex.Unlock()
+		if missing <= 0 {
+			return nil
+		}
+
+		// if there is no refill, check again in case the rate is changed
+		delay := time.Second
+		if rate > 0 {
+			delay = time.Duration(math.Ceil(missing / float64(rate) * float64(time.Second)))
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// There is nothing to start; the bucket refills whenever it is used. This announces the capacity.
+func (r *tokenBucketLimiter) Start(ctx context.Context) error {
+	r.Emit(CapacityEvent, int(r.Capacity()), "", nil)
+	return nil
+}
+
+// This adds the tokens that accumulated since the last refill. The mutex must be held.
+func (r *tokenBucketLimiter) refill(now time.Time) {
+	elapsed := now.Sub(r.last).Seconds()
+	r.last = now
+	if elapsed <= 0 {
+		return
+	}
+	r.tokens = math.Min(r.tokens+elapsed*float64(r.refillRate), float64(r.burst))
+}
+
+type spentTokens uint32
+
+func (s spentTokens) Cost() uint32 {
+	return uint32(s)
+}
+
+func (s spentTokens) Release() {}
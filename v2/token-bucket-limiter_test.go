@@ -0,0 +1,77 @@
+package batcher_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucketLimiter_CapacityIsRefillRateAndMaxCapacityIsBurst(t *testing.T) {
+	rl := gobatcher.NewTokenBucketLimiter(100, 20)
+	assert.Equal(t, uint32(100), rl.Capacity())
+	assert.Equal(t, uint32(20), rl.MaxCapacity())
+	rl.SetRefillRate(200)
+	rl.SetBurst(40)
+	assert.Equal(t, uint32(200), rl.Capacity())
+	assert.Equal(t, uint32(40), rl.MaxCapacity())
+}
+
+func TestTokenBucketLimiter_ReserveTakesTokensOnlyWhenAvailable(t *testing.T) {
+	rl := gobatcher.NewTokenBucketLimiter(1, 10)
+	handle, err := rl.Reserve(8, time.Second)
+	assert.NoError(t, err, "expecting the full bucket to cover the reservation")
+	assert.Equal(t, uint32(8), handle.Cost())
+	handle.Release()
+	_, err = rl.Reserve(8, time.Second)
+	assert.Equal(t, gobatcher.InsufficientCapacityError, err, "expecting releasing to not return the tokens")
+	_, err = rl.Reserve(2, time.Second)
+	assert.NoError(t, err, "expecting the failed reservation to leave the tokens in place")
+}
+
+func TestTokenBucketLimiter_WaitForCapacityWaitsForTheRefill(t *testing.T) {
+	rl := gobatcher.NewTokenBucketLimiter(100, 10)
+	_, err := rl.Reserve(10, time.Second)
+	assert.NoError(t, err, "expecting the full bucket to cover the reservation")
+	started := time.Now()
+	err = rl.WaitForCapacity(context.Background(), 5)
+	assert.NoError(t, err, "expecting the tokens to be refilled")
+	assert.GreaterOrEqual(t, time.Since(started), 40*time.Millisecond, "expecting to wait for 5 tokens at 100 per second")
+	_, err = rl.Reserve(5, time.Second)
+	assert.NoError(t, err, "expecting the wait to leave the tokens in place")
+	err = rl.WaitForCapacity(context.Background(), 20)
+	assert.Equal(t, gobatcher.TooExpensiveError, err, "expecting a cost over the burst to never be available")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = rl.WaitForCapacity(ctx, 10)
+	assert.Equal(t, context.DeadlineExceeded, err, "expecting the wait to end with the context")
+}
+
+func TestTokenBucketLimiter_BatcherIsLimited(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rl := gobatcher.NewTokenBucketLimiter(1000, 100)
+	batcher := gobatcher.NewBatcher().
+		WithRateLimiter(rl).
+		WithFlushInterval(10 * time.Millisecond)
+	var processed uint32
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		atomic.AddUint32(&processed, uint32(len(batch)))
+	})
+	err := batcher.Enqueue(gobatcher.NewOperation(watcher, 101, struct{}{}, false))
+	assert.Equal(t, gobatcher.TooExpensiveError, err, "expecting the burst to be the max cost")
+	for i := 0; i < 10; i++ {
+		err := batcher.Enqueue(gobatcher.NewOperation(watcher, 100, struct{}{}, false))
+		assert.NoError(t, err, "not expecting an enqueue error")
+	}
+	err = rl.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	started := time.Now()
+	err = batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	assert.Eventually(t, func() bool { return atomic.LoadUint32(&processed) == 10 }, 3*time.Second, 10*time.Millisecond)
+	assert.GreaterOrEqual(t, time.Since(started), 800*time.Millisecond, "expecting 900 tokens to take at least 900ms to refill")
+}
@@ -0,0 +1,64 @@
+package batcher
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// This is the name of the tracer Batcher gets from the TracerProvider provided to WithTracerProvider().
+const tracerName = "github.com/plasne/go-batcher/v2"
+
+// This starts the span for a batch, linked to the span context of each Operation. If there is no tracer, the span does nothing.
+func (r *batcher) startBatchSpan(watcher Watcher, ops []Operation) (context.Context, trace.Span) {
+	ctx := context.Background()
+	if r.tracer == nil {
+		return ctx, trace.SpanFromContext(ctx)
+	}
+	var cost int64
+	links := make([]trace.Link, 0, len(ops))
+	type spanKey struct {
+		trace trace.TraceID
+		span  trace.SpanID
+	}
+	linked := make(map[spanKey]bool)
+	for _, op := range ops {
+		cost += int64(op.Cost())
+		sc := op.SpanContext()
+		if key := (spanKey{sc.TraceID(), sc.SpanID()}); sc.IsValid() && !linked[key] {
+			linked[key] = true
+			links = append(links, trace.Link{SpanContext: sc})
+		}
+	}
+	return r.tracer.Start(ctx, "gobatcher.batch",
+		trace.WithNewRoot(),
+		trace.WithLinks(links...),
+		trace.WithAttributes(
+			attribute.String("gobatcher.watcher", watcher.Label()),
+			attribute.Int("gobatcher.batch.size", len(ops)),
+			attribute.Int64("gobatcher.batch.cost", cost),
+		),
+	)
+}
+
+// This ends the span for a batch once it has been completed (or requeued).
+func endBatchSpan(span trace.Span, completed, requeued bool, panicked error, failures int) {
+	span.SetAttributes(
+		attribute.Bool("gobatcher.batch.timeout", !completed),
+		attribute.Bool("gobatcher.batch.requeued", requeued),
+		attribute.Int("gobatcher.batch.failures", failures),
+	)
+	switch {
+	case panicked != nil:
+		span.RecordError(panicked)
+		span.SetStatus(codes.Error, panicked.Error())
+	case !completed:
+		span.SetStatus(codes.Error, "the batch exceeded the maximum operation time.")
+	case failures > 0:
+		span.SetStatus(codes.Error, fmt.Sprintf("%d operations failed.", failures))
+	}
+	span.End()
+}
@@ -0,0 +1,177 @@
+package typed
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	gobatcher "github.com/plasne/go-batcher/v2"
+)
+
+// Batcher is backed by an untyped Batcher and only accepts Operations with a payload of type T. Configure the untyped Batcher (with
+// the WithXXXX methods) before wrapping it or by calling Untyped() before Start().
+type Batcher[T any] struct {
+	batcher gobatcher.Batcher
+}
+
+// This method creates a new Batcher with the default buffer size. You may configure it by calling Untyped() before Start(), for
+// instance... `typed.NewBatcher[Order]().Untyped().WithRateLimiter(limiter)`.
+func NewBatcher[T any]() *Batcher[T] {
+	return Wrap[T](gobatcher.NewBatcher())
+}
+
+// This method wraps a Batcher that you have already configured, for instance... `typed.Wrap[Order](gobatcher.NewBatcher().WithRateLimiter(limiter))`.
+func Wrap[T any](batcher gobatcher.Batcher) *Batcher[T] {
+	return &Batcher[T]{
+		batcher: batcher,
+	}
+}
+
+// This method adds the Operation to the buffer. See Batcher.Enqueue() for details.
+func (b *Batcher[T]) Enqueue(op *Operation[T]) error {
+	return b.batcher.Enqueue(op.op)
+}
+
+// This method is the same as Enqueue() except that it stops blocking on a full buffer when the context is done. See
+// Batcher.EnqueueWithContext() for details.
+func (b *Batcher[T]) EnqueueWithContext(ctx context.Context, op *Operation[T]) error {
+	return b.batcher.EnqueueWithContext(ctx, op.op)
+}
+
+// This method removes an Operation that is still waiting in the buffer and returns true if it was cancelled in time. See
+// Batcher.Cancel() for details.
+func (b *Batcher[T]) Cancel(op *Operation[T]) bool {
+	if op == nil {
+		return false
+	}
+	return b.batcher.Cancel(op.op)
+}
+
+// This method calls fn for each Operation of type T waiting in the buffer until fn returns false. See Batcher.InspectBuffer() for
+// details.
+func (b *Batcher[T]) InspectBuffer(fn func(op *Operation[T]) bool) {
+	b.batcher.InspectBuffer(func(op gobatcher.Operation) bool {
+		if typed, ok := op.Payload().(*Operation[T]); ok {
+			return fn(typed)
+		}
+		return true
+	})
+}
+
+// This method removes every Operation of type T waiting in the buffer for which predicate returns true and returns how many were
+// removed. See Batcher.Purge() for details.
+func (b *Batcher[T]) Purge(predicate func(op *Operation[T]) bool) int {
+	return b.batcher.Purge(func(op gobatcher.Operation) bool {
+		typed, ok := op.Payload().(*Operation[T])
+		return ok && predicate(typed)
+	})
+}
+
+// This method adds several Operations into the buffer while only acquiring the buffer's lock once. See Batcher.EnqueueMany() for
+// details.
+func (b *Batcher[T]) EnqueueMany(ops []*Operation[T]) error {
+	untyped := make([]gobatcher.Operation, len(ops))
+	for i, op := range ops {
+		if op != nil {
+			untyped[i] = op.op
+		}
+	}
+	return b.batcher.EnqueueMany(untyped)
+}
+
+// Call this method to start the processing loop.
+func (b *Batcher[T]) Start(ctx context.Context) error {
+	return b.batcher.Start(ctx)
+}
+
+// Call this method to stop accepting Operations, drain the buffer, and wait for every batch to finish before stopping the processing
+// loop. See Batcher.Shutdown() for details.
+func (b *Batcher[T]) Shutdown(ctx context.Context) error {
+	return b.batcher.Shutdown(ctx)
+}
+
+// This method pauses the processing loop for the PauseTime.
+func (b *Batcher[T]) Pause() {
+	b.batcher.Pause()
+}
+
+// This method pauses the processing loop for the provided duration or, if it is 0 or less, until Resume() is called.
+func (b *Batcher[T]) PauseFor(val time.Duration) {
+	b.batcher.PauseFor(val)
+}
+
+// This method ends a pause immediately.
+func (b *Batcher[T]) Resume() {
+	b.batcher.Resume()
+}
+
+// This method stops batches from being raised to the Watcher for the provided duration. See Batcher.PauseWatcher() for details.
+func (b *Batcher[T]) PauseWatcher(watcher *Watcher[T], val time.Duration) {
+	b.batcher.PauseWatcher(watcher.Untyped(), val)
+}
+
+// This method ends a pause of the Watcher immediately.
+func (b *Batcher[T]) ResumeWatcher(watcher *Watcher[T]) {
+	b.batcher.ResumeWatcher(watcher.Untyped())
+}
+
+// This method asks the processing loop to flush as soon as possible.
+func (b *Batcher[T]) Flush() {
+	b.batcher.Flush()
+}
+
+// This method asks the processing loop to flush only the Operations for the Watcher. See Batcher.FlushWatcher() for details.
+func (b *Batcher[T]) FlushWatcher(watcher *Watcher[T]) {
+	b.batcher.FlushWatcher(watcher.Untyped())
+}
+
+// This returns the number of batches that are currently being processed.
+func (b *Batcher[T]) Inflight() uint32 {
+	return b.batcher.Inflight()
+}
+
+// This applies the settings of the Config that may be changed after Start().
+func (b *Batcher[T]) ApplyConfig(cfg gobatcher.Config) error {
+	return b.batcher.ApplyConfig(cfg)
+}
+
+// This returns nil if the Batcher is healthy.
+func (b *Batcher[T]) Healthy() error {
+	return b.batcher.Healthy()
+}
+
+// This returns a snapshot of the Batcher without taking any locks.
+func (b *Batcher[T]) Stats() gobatcher.BatcherStats {
+	return b.batcher.Stats()
+}
+
+// This returns the number of Operations waiting in the buffer.
+func (b *Batcher[T]) OperationsInBuffer() uint32 {
+	return b.batcher.OperationsInBuffer()
+}
+
+// This returns the capacity needed to process everything that is in the buffer and inflight.
+func (b *Batcher[T]) NeedsCapacity() uint32 {
+	return b.batcher.NeedsCapacity()
+}
+
+// When the real cost of an Operation is known, you may report it so the capacity requested is corrected. See
+// Batcher.ReportActualCost() for details.
+func (b *Batcher[T]) ReportActualCost(op *Operation[T], actual uint32) {
+	b.batcher.ReportActualCost(op.Untyped(), actual)
+}
+
+// You can add a listener to catch events that are raised by the Batcher. See Batcher.AddListener() for the options.
+func (b *Batcher[T]) AddListener(fn func(event string, val int, msg string, metadata interface{}), opts ...gobatcher.ListenerOption) uuid.UUID {
+	return b.batcher.AddListener(fn, opts...)
+}
+
+// This method removes a listener that was added with AddListener().
+func (b *Batcher[T]) RemoveListener(id uuid.UUID) {
+	b.batcher.RemoveListener(id)
+}
+
+// This returns the untyped Batcher that backs this Batcher.
+func (b *Batcher[T]) Untyped() gobatcher.Batcher {
+	return b.batcher
+}
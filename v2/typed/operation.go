@@ -0,0 +1,255 @@
+// Package typed wraps Batcher, Watcher, and Operation with generics so that the payload of every Operation has the type T. This
+// removes the type assertion that every Watcher callback would otherwise need to make on Operation.Payload().
+package typed
+
+import (
+	"sync"
+	"time"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Operation is backed by an untyped Operation whose payload is this Operation, so batches raised by Batcher can be mapped back
+// without the caller making any type assertions.
+type Operation[T any] struct {
+	op       gobatcher.Operation
+	payload  T
+	produce  func() T
+	produced sync.Once
+}
+
+// This method creates a new Operation with a Watcher, cost, payload, and a flag determining whether or not the Operation is batchable.
+// The Operation can be Enqueued into a Batcher of the same type.
+func NewOperation[T any](watcher *Watcher[T], cost uint32, payload T, batchable bool) *Operation[T] {
+	o := &Operation[T]{
+		payload: payload,
+	}
+	o.op = gobatcher.NewOperation(watcher.Untyped(), cost, o, batchable)
+	return o
+}
+
+// This method creates a new Operation that costs a different amount in each dimension. See NewOperationWithCosts() in the gobatcher
+// package for details.
+func NewOperationWithCosts[T any](watcher *Watcher[T], costs map[string]uint32, payload T, batchable bool) *Operation[T] {
+	o := &Operation[T]{
+		payload: payload,
+	}
+	o.op = gobatcher.NewOperationWithCosts(watcher.Untyped(), costs, o, batchable)
+	return o
+}
+
+// This method creates a new Operation whose payload is produced by calling the provided function when the Operation is dispatched. See
+// NewDeferredOperation() in the gobatcher package for details.
+func NewDeferredOperation[T any](watcher *Watcher[T], cost uint32, produce func() T, batchable bool) *Operation[T] {
+	o := &Operation[T]{
+		produce: produce,
+	}
+	o.op = gobatcher.NewDeferredOperation(watcher.Untyped(), cost, func() interface{} {
+		o.Payload()
+		return o
+	}, batchable)
+	return o
+}
+
+// You may provide a function that is called once the Operation has a final Result. See Operation.WithOnComplete() for details.
+func (o *Operation[T]) WithOnComplete(fn func(op *Operation[T], result gobatcher.Result)) *Operation[T] {
+	o.op.WithOnComplete(func(_ gobatcher.Operation, result gobatcher.Result) {
+		fn(o, result)
+	})
+	return o
+}
+
+// You may provide a time by which the Operation should be dispatched. See Operation.WithDeadline() for details.
+func (o *Operation[T]) WithDeadline(deadline time.Time) *Operation[T] {
+	o.op.WithDeadline(deadline)
+	return o
+}
+
+// You may provide a time after which the Operation is no longer worth processing. See Operation.WithExpiry() for details.
+func (o *Operation[T]) WithExpiry(expiry time.Time) *Operation[T] {
+	o.op.WithExpiry(expiry)
+	return o
+}
+
+// You may provide how long from now the Operation is worth processing. See Operation.WithTTL() for details.
+func (o *Operation[T]) WithTTL(ttl time.Duration) *Operation[T] {
+	o.op.WithTTL(ttl)
+	return o
+}
+
+// You may provide a key (for instance, a partition key) for the Operation. See Operation.WithKey() for details.
+func (o *Operation[T]) WithKey(key string) *Operation[T] {
+	o.op.WithKey(key)
+	return o
+}
+
+// You may provide a hint of how many bytes the Operation will take on the wire. See Operation.WithSize() for details.
+func (o *Operation[T]) WithSize(val uint32) *Operation[T] {
+	o.op.WithSize(val)
+	return o
+}
+
+// You may provide a function that divides the Operation into smaller Operations when it costs more than the rate limiter's MaxCapacity.
+// See Operation.WithSplit() for details.
+func (o *Operation[T]) WithSplit(fn func(maxCost uint32) []*Operation[T]) *Operation[T] {
+	o.op.WithSplit(func(maxCost uint32) []gobatcher.Operation {
+		fragments := fn(maxCost)
+		untyped := make([]gobatcher.Operation, len(fragments))
+		for i, fragment := range fragments {
+			untyped[i] = fragment.op
+		}
+		return untyped
+	})
+	return o
+}
+
+// You may provide a key that identifies what the Operation writes. See Operation.WithDedupKey() for details.
+func (o *Operation[T]) WithDedupKey(key string) *Operation[T] {
+	o.op.WithDedupKey(key)
+	return o
+}
+
+// You may provide a priority for the Operation. See Operation.WithPriority() for details.
+func (o *Operation[T]) WithPriority(priority int) *Operation[T] {
+	o.op.WithPriority(priority)
+	return o
+}
+
+// You may provide the span context the Operation originated from. See Operation.WithSpanContext() for details.
+func (o *Operation[T]) WithSpanContext(sc trace.SpanContext) *Operation[T] {
+	o.op.WithSpanContext(sc)
+	return o
+}
+
+// You may provide your own ID for the Operation. See Operation.WithID() for details.
+func (o *Operation[T]) WithID(id string) *Operation[T] {
+	o.op.WithID(id)
+	return o
+}
+
+// You may annotate the Operation with metadata that is not part of the payload. See Operation.WithMetadata() for details.
+func (o *Operation[T]) WithMetadata(metadata map[string]interface{}) *Operation[T] {
+	o.op.WithMetadata(metadata)
+	return o
+}
+
+// This returns an ID that is unique to the Operation within the process.
+func (o *Operation[T]) ID() uint64 {
+	return o.op.ID()
+}
+
+// This returns the ID provided by WithID() or an empty string if there is none.
+func (o *Operation[T]) ExternalID() string {
+	return o.op.ExternalID()
+}
+
+// This returns a copy of the metadata provided by WithMetadata() or nil if there is none.
+func (o *Operation[T]) Metadata() map[string]interface{} {
+	return o.op.Metadata()
+}
+
+// This will return the payload object for the Operation.
+func (o *Operation[T]) Payload() T {
+	o.produced.Do(func() {
+		if o.produce != nil {
+			o.payload = o.produce()
+			o.produce = nil
+		}
+	})
+	return o.payload
+}
+
+// This will return the number of times this Operation has been returned to its Watcher.
+func (o *Operation[T]) Attempt() uint32 {
+	return o.op.Attempt()
+}
+
+// This is the cost of the Operation.
+func (o *Operation[T]) Cost() uint32 {
+	return o.op.Cost()
+}
+
+// This is the cost of the Operation in the provided dimension.
+func (o *Operation[T]) CostOf(dimension string) uint32 {
+	return o.op.CostOf(dimension)
+}
+
+// This is TRUE if the Operation can be batched with other Operations.
+func (o *Operation[T]) IsBatchable() bool {
+	return o.op.IsBatchable()
+}
+
+// This returns the deadline provided by WithDeadline() or the zero time if there is none.
+func (o *Operation[T]) Deadline() time.Time {
+	return o.op.Deadline()
+}
+
+// This returns the expiry provided by WithExpiry() (or WithTTL()) or the zero time if there is none.
+func (o *Operation[T]) Expiry() time.Time {
+	return o.op.Expiry()
+}
+
+// The Watcher may call this method to record the outcome of processing the Operation.
+func (o *Operation[T]) SetResult(result gobatcher.Result) {
+	o.op.SetResult(result)
+}
+
+// This returns the Result of the most recent attempt.
+func (o *Operation[T]) Result() gobatcher.Result {
+	return o.op.Result()
+}
+
+// This returns a channel that is closed once the Operation has a final Result.
+func (o *Operation[T]) Done() <-chan struct{} {
+	return o.op.Done()
+}
+
+// This returns the dedup key provided by WithDedupKey() or an empty string if there is none.
+func (o *Operation[T]) DedupKey() string {
+	return o.op.DedupKey()
+}
+
+// This returns the priority provided by WithPriority() or 0 if there is none.
+func (o *Operation[T]) Priority() int {
+	return o.op.Priority()
+}
+
+// This returns when the Operation was first enqueued or a zero time if it has not been enqueued.
+func (o *Operation[T]) EnqueuedAt() time.Time {
+	return o.op.EnqueuedAt()
+}
+
+// This returns the untyped Operation that backs this Operation. Its payload is this Operation.
+func (o *Operation[T]) Untyped() gobatcher.Operation {
+	return o.op
+}
+
+func toTypedOperations[T any](batch []gobatcher.Operation) []*Operation[T] {
+	ops := make([]*Operation[T], 0, len(batch))
+	for _, op := range batch {
+		if typed, ok := op.Payload().(*Operation[T]); ok {
+			ops = append(ops, typed)
+		}
+	}
+	return ops
+}
+
+// This converts a function that merges Operations of type T into one that can be provided to Batcher.WithDeduplication(), for
+// instance... `gobatcher.NewBatcher().WithDeduplication(typed.Merge(mergeOrders))`. Duplicates that are not of type T are rejected.
+func Merge[T any](fn func(buffered, incoming *Operation[T]) *Operation[T]) func(buffered, incoming gobatcher.Operation) gobatcher.Operation {
+	return func(buffered, incoming gobatcher.Operation) gobatcher.Operation {
+		b, ok := buffered.Payload().(*Operation[T])
+		if !ok {
+			return nil
+		}
+		i, ok := incoming.Payload().(*Operation[T])
+		if !ok {
+			return nil
+		}
+		if merged := fn(b, i); merged != nil {
+			return merged.op
+		}
+		return nil
+	}
+}
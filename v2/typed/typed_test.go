@@ -0,0 +1,96 @@
+package typed_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+	"github.com/plasne/go-batcher/v2/typed"
+	"github.com/stretchr/testify/assert"
+)
+
+type order struct {
+	id int
+}
+
+func TestBatcher_RaisesTypedPayloads(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := typed.Wrap[order](gobatcher.NewBatcher().WithFlushInterval(1 * time.Millisecond))
+	batches := make(chan []*typed.Operation[order], 1)
+	watcher := typed.NewWatcher(func(batch []*typed.Operation[order]) {
+		batches <- batch
+	}).WithMaxBatchSize(10)
+	for i := 0; i < 3; i++ {
+		err := batcher.Enqueue(typed.NewOperation(watcher, 1, order{id: i}, true))
+		assert.NoError(t, err, "not expecting an enqueue error")
+	}
+	err := batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	select {
+	case batch := <-batches:
+		assert.Len(t, batch, 3)
+		for i, op := range batch {
+			assert.Equal(t, i, op.Payload().id, "expecting the payload without a type assertion")
+			assert.Equal(t, uint32(1), op.Attempt())
+		}
+	case <-time.After(1 * time.Second):
+		assert.Fail(t, "expected a batch before the timeout")
+	}
+}
+
+func TestWatcherWithError_ContextIsDoneAtMaxOperationTime(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := typed.NewBatcher[order]()
+	batcher.Untyped().WithFlushInterval(1 * time.Millisecond)
+	aborted := make(chan error, 1)
+	watcher := typed.NewWatcherWithError(func(ctx context.Context, batch []*typed.Operation[order]) error {
+		<-ctx.Done()
+		aborted <- ctx.Err()
+		return ctx.Err()
+	}).WithMaxOperationTime(20 * time.Millisecond)
+	err := batcher.Enqueue(typed.NewOperation(watcher, 1, order{id: 1}, false))
+	assert.NoError(t, err, "not expecting an enqueue error")
+	err = batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	select {
+	case err := <-aborted:
+		assert.ErrorIs(t, err, context.DeadlineExceeded, "expecting the context to be done at MaxOperationTime")
+	case <-time.After(1 * time.Second):
+		assert.Fail(t, "expected the context to be done before the timeout")
+	}
+}
+
+func TestOperation_OnCompleteReceivesTypedOperation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batcher := typed.NewBatcher[string]()
+	batcher.Untyped().WithFlushInterval(1 * time.Millisecond)
+	failure := errors.New("failed")
+	watcher := typed.NewWatcher(func(batch []*typed.Operation[string]) {
+		for _, op := range batch {
+			op.SetResult(gobatcher.Failed(failure))
+		}
+	}).WithMaxAttempts(1)
+	completed := make(chan string, 1)
+	op := typed.NewOperation(watcher, 1, "payload", false).
+		WithOnComplete(func(op *typed.Operation[string], result gobatcher.Result) {
+			completed <- op.Payload()
+		})
+	err := batcher.Enqueue(op)
+	assert.NoError(t, err, "not expecting an enqueue error")
+	err = batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	select {
+	case payload := <-completed:
+		assert.Equal(t, "payload", payload)
+		assert.Equal(t, gobatcher.ResultFailed, op.Result().Status)
+		assert.ErrorIs(t, op.Result().Err, failure)
+	case <-time.After(1 * time.Second):
+		assert.Fail(t, "expected the operation to complete before the timeout")
+	}
+	assert.Same(t, op, op.Untyped().Payload(), "expecting the untyped payload to be the typed operation")
+}
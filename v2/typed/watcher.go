@@ -0,0 +1,126 @@
+package typed
+
+import (
+	"context"
+	"time"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+)
+
+// Watcher is backed by an untyped Watcher; the callback receives the typed Operations.
+type Watcher[T any] struct {
+	watcher gobatcher.Watcher
+}
+
+// This method creates a new Watcher with a callback function that is called whenever a batch of Operations is ready to be processed.
+// See NewWatcher() in the batcher package for details.
+func NewWatcher[T any](onReady func(batch []*Operation[T])) *Watcher[T] {
+	return &Watcher[T]{
+		watcher: gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+			onReady(toTypedOperations[T](batch))
+		}),
+	}
+}
+
+// This method creates a new Watcher whose callback function receives a context that is done when MaxOperationTime is exceeded and
+// returns an error. See NewWatcherWithError() in the batcher package for details.
+func NewWatcherWithError[T any](onReady func(ctx context.Context, batch []*Operation[T]) error) *Watcher[T] {
+	return &Watcher[T]{
+		watcher: gobatcher.NewWatcherWithError(func(ctx context.Context, batch []gobatcher.Operation) error {
+			return onReady(ctx, toTypedOperations[T](batch))
+		}),
+	}
+}
+
+// This determines how many times an Operation may be attempted. See Watcher.WithMaxAttempts() for details.
+func (w *Watcher[T]) WithMaxAttempts(val uint32) *Watcher[T] {
+	w.watcher.WithMaxAttempts(val)
+	return w
+}
+
+// This determines the maximum number of Operations that will be raised in a single batch.
+func (w *Watcher[T]) WithMaxBatchSize(val uint32) *Watcher[T] {
+	w.watcher.WithMaxBatchSize(val)
+	return w
+}
+
+// This determines the maximum number of bytes (per the size hints of the Operations) that will be raised in a single batch. See
+// Watcher.WithMaxBatchBytes() for details.
+func (w *Watcher[T]) WithMaxBatchBytes(val uint32) *Watcher[T] {
+	w.watcher.WithMaxBatchBytes(val)
+	return w
+}
+
+// This holds back batchable Operations until there are at least this many or they have lingered for MaxLinger. See
+// Watcher.WithMinBatchSize() for details.
+func (w *Watcher[T]) WithMinBatchSize(val uint32) *Watcher[T] {
+	w.watcher.WithMinBatchSize(val)
+	return w
+}
+
+// This determines the longest that Operations are held back waiting for MinBatchSize. See Watcher.WithMaxLinger() for details.
+func (w *Watcher[T]) WithMaxLinger(val time.Duration) *Watcher[T] {
+	w.watcher.WithMaxLinger(val)
+	return w
+}
+
+// This determines how long the system should wait for the callback function to be completed on the batch.
+func (w *Watcher[T]) WithMaxOperationTime(val time.Duration) *Watcher[T] {
+	w.watcher.WithMaxOperationTime(val)
+	return w
+}
+
+// This determines how long Batcher waits before raising another batch to this Watcher after a batch fails. See Watcher.WithCooldown()
+// for details.
+func (w *Watcher[T]) WithCooldown(val time.Duration) *Watcher[T] {
+	w.watcher.WithCooldown(val)
+	return w
+}
+
+// This ensures every batch only contains Operations with the same key. See Watcher.WithGroupByKey() for details.
+func (w *Watcher[T]) WithGroupByKey() *Watcher[T] {
+	w.watcher.WithGroupByKey()
+	return w
+}
+
+// You may provide a weight that determines this Watcher's share of the capacity when there is not enough for every Watcher. See
+// Watcher.WithWeight() for details.
+func (w *Watcher[T]) WithWeight(val uint32) *Watcher[T] {
+	w.watcher.WithWeight(val)
+	return w
+}
+
+// You may provide a label that identifies this Watcher in the batch event. See Watcher.WithLabel() for details.
+func (w *Watcher[T]) WithLabel(val string) *Watcher[T] {
+	w.watcher.WithLabel(val)
+	return w
+}
+
+// This provides a function that partitions the Operations a flush can dispatch to this Watcher into batches. See Watcher.WithSplitter()
+// for details.
+func (w *Watcher[T]) WithSplitter(fn func(ops []*Operation[T]) [][]*Operation[T]) *Watcher[T] {
+	w.watcher.WithSplitter(func(ops []gobatcher.Operation) [][]gobatcher.Operation {
+		batches := fn(toTypedOperations[T](ops))
+		untyped := make([][]gobatcher.Operation, len(batches))
+		for i, batch := range batches {
+			untyped[i] = make([]gobatcher.Operation, len(batch))
+			for j, op := range batch {
+				untyped[i][j] = op.op
+			}
+		}
+		return untyped
+	})
+	return w
+}
+
+// This causes Operations for this Watcher to draw capacity from the provided rate limiter instead of the Batcher's. See
+// Watcher.WithRateLimiter() for details.
+func (w *Watcher[T]) WithRateLimiter(rl gobatcher.RateLimiter) *Watcher[T] {
+	w.watcher.WithRateLimiter(rl)
+	return w
+}
+
+// This returns the untyped Watcher that backs this Watcher.
+func (w *Watcher[T]) Untyped() gobatcher.Watcher {
+	return w.watcher
+}
@@ -0,0 +1,194 @@
+// Package v1compat exposes the v1 API (IOperation, IWatcher, IBatcher, and struct errors such as BufferFullError{}) on top of the v2
+// implementation. Code written against v1 can switch its import to this package, get every v2 fix and feature, and then move to v2
+// one piece at a time using the AsV2XXXX functions.
+package v1compat
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	gobatcher "github.com/plasne/go-batcher/v2"
+)
+
+type IBatcher interface {
+	AddListener(fn func(event string, val int, msg string, metadata interface{})) uuid.UUID
+	RemoveListener(id uuid.UUID)
+	WithRateLimiter(rl RateLimiter) IBatcher
+	WithFlushInterval(val time.Duration) IBatcher
+	WithCapacityInterval(val time.Duration) IBatcher
+	WithAuditInterval(val time.Duration) IBatcher
+	WithMaxOperationTime(val time.Duration) IBatcher
+	WithPauseTime(val time.Duration) IBatcher
+	WithErrorOnFullBuffer() IBatcher
+	WithEmitBatch() IBatcher
+	Enqueue(op IOperation) error
+	Pause()
+	Flush()
+	OperationsInBuffer() uint32
+	NeedsCapacity() uint32
+	Start() (err error)
+	Stop()
+}
+
+// Batcher is backed by a v2 Batcher. Since v2 is stopped by cancelling the context provided to Start(), Batcher manages that context.
+type Batcher struct {
+	batcher gobatcher.Batcher
+
+	phaseMutex sync.Mutex
+	started    bool
+	stopped    bool
+	cancel     context.CancelFunc
+	shutdown   chan struct{}
+}
+
+func NewBatcher() IBatcher {
+	return NewBatcherWithBuffer(10000)
+}
+
+func NewBatcherWithBuffer(maxBufferSize uint32) IBatcher {
+	return &Batcher{
+		batcher:  gobatcher.NewBatcherWithBuffer(maxBufferSize),
+		shutdown: make(chan struct{}),
+	}
+}
+
+// This returns the v2 Batcher behind a v1 Batcher so that code can be migrated incrementally.
+func AsV2Batcher(batcher IBatcher) gobatcher.Batcher {
+	if b, ok := batcher.(*Batcher); ok {
+		return b.batcher
+	}
+	return nil
+}
+
+// Listeners receive the same events as v2 except that the metadata of the "batch" event is a slice of IOperation.
+func (r *Batcher) AddListener(fn func(event string, val int, msg string, metadata interface{})) uuid.UUID {
+	return r.batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		if batch, ok := metadata.([]gobatcher.Operation); ok && event == gobatcher.BatchEvent {
+			metadata = toV1Operations(batch)
+		}
+		fn(event, val, msg, metadata)
+	})
+}
+
+func (r *Batcher) RemoveListener(id uuid.UUID) {
+	r.batcher.RemoveListener(id)
+}
+
+func (r *Batcher) WithRateLimiter(rl RateLimiter) IBatcher {
+	r.batcher.WithRateLimiter(toV2RateLimiter(rl))
+	return r
+}
+
+func (r *Batcher) WithFlushInterval(val time.Duration) IBatcher {
+	r.batcher.WithFlushInterval(val)
+	return r
+}
+
+func (r *Batcher) WithCapacityInterval(val time.Duration) IBatcher {
+	r.batcher.WithCapacityInterval(val)
+	return r
+}
+
+func (r *Batcher) WithAuditInterval(val time.Duration) IBatcher {
+	r.batcher.WithAuditInterval(val)
+	return r
+}
+
+func (r *Batcher) WithMaxOperationTime(val time.Duration) IBatcher {
+	r.batcher.WithMaxOperationTime(val)
+	return r
+}
+
+func (r *Batcher) WithPauseTime(val time.Duration) IBatcher {
+	r.batcher.WithPauseTime(val)
+	return r
+}
+
+func (r *Batcher) WithErrorOnFullBuffer() IBatcher {
+	r.batcher.WithErrorOnFullBuffer()
+	return r
+}
+
+// In v1, the metadata of the "batch" event is the Operations in the batch, so this uses WithEmitBatchOperations() in v2.
+func (r *Batcher) WithEmitBatch() IBatcher {
+	r.batcher.WithEmitBatchOperations()
+	return r
+}
+
+// Call this method to add an Operation into the buffer. Errors are the v1 struct errors.
+func (r *Batcher) Enqueue(op IOperation) error {
+
+	// ensure an operation was provided
+	if op == nil {
+		return NoOperationError{}
+	}
+
+	// ensure there is a watcher associated with the call
+	watcher := op.Watcher()
+	if watcher == nil {
+		return NoWatcherError{}
+	}
+
+	// ensure there are not too many attempts; this is checked here since Operations not created by NewOperation() count their own
+	maxAttempts := watcher.MaxAttempts()
+	if maxAttempts > 0 && op.Attempt() >= maxAttempts {
+		return TooManyAttemptsError{}
+	}
+
+	return toV1Error(r.batcher.Enqueue(AsV2Operation(op)))
+}
+
+func (r *Batcher) Pause() {
+	r.batcher.Pause()
+}
+
+func (r *Batcher) Flush() {
+	r.batcher.Flush()
+}
+
+func (r *Batcher) OperationsInBuffer() uint32 {
+	return r.batcher.OperationsInBuffer()
+}
+
+func (r *Batcher) NeedsCapacity() uint32 {
+	return r.batcher.NeedsCapacity()
+}
+
+// Call this method to start the processing loop.
+func (r *Batcher) Start() (err error) {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.started || r.stopped {
+		return BatcherImproperOrderError{}
+	}
+	id := r.batcher.AddListener(func(event string, val int, msg string, metadata interface{}) {
+		if event == gobatcher.ShutdownEvent {
+			close(r.shutdown)
+		}
+	})
+	var ctx context.Context
+	ctx, r.cancel = context.WithCancel(context.Background())
+	if err = r.batcher.Start(ctx); err != nil {
+		r.batcher.RemoveListener(id)
+		r.cancel()
+		return toV1Error(err)
+	}
+	r.started = true
+	return nil
+}
+
+// Call this method to stop the processing loop. You may not restart after stopping.
+func (r *Batcher) Stop() {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.stopped {
+		return
+	}
+	r.stopped = true
+	if r.started {
+		r.cancel()
+		<-r.shutdown
+	}
+}
@@ -0,0 +1,124 @@
+package v1compat
+
+import gobatcher "github.com/plasne/go-batcher/v2"
+
+// These are the struct errors from v1. Each one that has a v2 equivalent unwraps to the v2 sentinel error so that
+// errors.Is(err, gobatcher.BufferFullError) works as well as a v1-style type switch.
+
+type UndefinedLeaseManagerError struct{}
+
+func (e UndefinedLeaseManagerError) Error() string {
+	return "a lease manager must be assigned."
+}
+
+type UndefinedSharedCapacityError struct{}
+
+func (e UndefinedSharedCapacityError) Error() string {
+	return "you must define a SharedCapacity."
+}
+
+type NoWatcherError struct{}
+
+func (e NoWatcherError) Error() string {
+	return gobatcher.NoWatcherError.Error()
+}
+
+func (e NoWatcherError) Unwrap() error {
+	return gobatcher.NoWatcherError
+}
+
+type TooManyAttemptsError struct{}
+
+func (e TooManyAttemptsError) Error() string {
+	return gobatcher.TooManyAttemptsError.Error()
+}
+
+func (e TooManyAttemptsError) Unwrap() error {
+	return gobatcher.TooManyAttemptsError
+}
+
+type TooExpensiveError struct{}
+
+func (e TooExpensiveError) Error() string {
+	return gobatcher.TooExpensiveError.Error()
+}
+
+func (e TooExpensiveError) Unwrap() error {
+	return gobatcher.TooExpensiveError
+}
+
+type BufferFullError struct{}
+
+func (e BufferFullError) Error() string {
+	return gobatcher.BufferFullError.Error()
+}
+
+func (e BufferFullError) Unwrap() error {
+	return gobatcher.BufferFullError
+}
+
+type BufferNotAllocated struct{}
+
+func (e BufferNotAllocated) Error() string {
+	return "the buffer was never allocated, make sure to create a Batcher by calling NewBatcher()."
+}
+
+type RateLimiterImproperOrderError struct{}
+
+func (e RateLimiterImproperOrderError) Error() string {
+	return "methods can only be called in this order Provision() > Start() > Stop()."
+}
+
+func (e RateLimiterImproperOrderError) Unwrap() error {
+	return gobatcher.ImproperOrderError
+}
+
+type BatcherImproperOrderError struct{}
+
+func (e BatcherImproperOrderError) Error() string {
+	return gobatcher.ImproperOrderError.Error()
+}
+
+func (e BatcherImproperOrderError) Unwrap() error {
+	return gobatcher.ImproperOrderError
+}
+
+type NoOperationError struct{}
+
+func (e NoOperationError) Error() string {
+	return gobatcher.NoOperationError.Error()
+}
+
+func (e NoOperationError) Unwrap() error {
+	return gobatcher.NoOperationError
+}
+
+type PartitionsOutOfRangeError struct {
+	MaxCapacity    uint32
+	Factor         uint32
+	PartitionCount int
+}
+
+func (e PartitionsOutOfRangeError) Error() string {
+	return "you must have between 1 and 500 partitions."
+}
+
+// This converts the sentinel errors returned by v2 into their v1 struct equivalents. Errors without an equivalent are returned as-is.
+func toV1Error(err error) error {
+	switch err {
+	case gobatcher.NoWatcherError:
+		return NoWatcherError{}
+	case gobatcher.TooManyAttemptsError:
+		return TooManyAttemptsError{}
+	case gobatcher.TooExpensiveError:
+		return TooExpensiveError{}
+	case gobatcher.BufferFullError:
+		return BufferFullError{}
+	case gobatcher.ImproperOrderError:
+		return BatcherImproperOrderError{}
+	case gobatcher.NoOperationError:
+		return NoOperationError{}
+	default:
+		return err
+	}
+}
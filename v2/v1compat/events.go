@@ -0,0 +1,25 @@
+package v1compat
+
+import gobatcher "github.com/plasne/go-batcher/v2"
+
+// These are the events that v1 raised; they have the same names in v2.
+const (
+	BatchEvent             = gobatcher.BatchEvent
+	PauseEvent             = gobatcher.PauseEvent
+	ResumeEvent            = gobatcher.ResumeEvent
+	ShutdownEvent          = gobatcher.ShutdownEvent
+	AuditPassEvent         = gobatcher.AuditPassEvent
+	AuditFailEvent         = gobatcher.AuditFailEvent
+	AuditSkipEvent         = gobatcher.AuditSkipEvent
+	RequestEvent           = gobatcher.RequestEvent
+	CapacityEvent          = gobatcher.CapacityEvent
+	ReleasedEvent          = gobatcher.ReleasedEvent
+	AllocatedEvent         = gobatcher.AllocatedEvent
+	TargetEvent            = gobatcher.TargetEvent
+	VerifiedContainerEvent = gobatcher.VerifiedContainerEvent
+	CreatedContainerEvent  = gobatcher.CreatedContainerEvent
+	VerifiedBlobEvent      = gobatcher.VerifiedBlobEvent
+	CreatedBlobEvent       = gobatcher.CreatedBlobEvent
+	FailedEvent            = gobatcher.FailedEvent
+	ErrorEvent             = gobatcher.ErrorEvent
+)
@@ -0,0 +1,76 @@
+package v1compat
+
+import gobatcher "github.com/plasne/go-batcher/v2"
+
+type IOperation interface {
+	Payload() interface{}
+	Attempt() uint32
+	Cost() uint32
+	Watcher() IWatcher
+	IsBatchable() bool
+	MakeAttempt()
+}
+
+// Operation is backed by a v2 Operation whose payload is this Operation, so batches raised by v2 can be mapped back.
+type Operation struct {
+	op      gobatcher.Operation
+	watcher IWatcher
+	payload interface{}
+}
+
+func NewOperation(watcher IWatcher, cost uint32, payload interface{}, batchable bool) IOperation {
+	o := &Operation{
+		watcher: watcher,
+		payload: payload,
+	}
+	o.op = gobatcher.NewOperation(toV2Watcher(watcher), cost, o, batchable)
+	return o
+}
+
+func (o *Operation) Payload() interface{} {
+	return o.payload
+}
+
+func (o *Operation) Attempt() uint32 {
+	return o.op.Attempt()
+}
+
+func (o *Operation) MakeAttempt() {
+	o.op.MakeAttempt()
+}
+
+func (o *Operation) Cost() uint32 {
+	return o.op.Cost()
+}
+
+func (o *Operation) Watcher() IWatcher {
+	return o.watcher
+}
+
+func (o *Operation) IsBatchable() bool {
+	return o.op.IsBatchable()
+}
+
+// This returns the v2 Operation behind a v1 Operation so that code can be migrated incrementally. Operations that were not created
+// by NewOperation() (for instance, mocks) are wrapped in a new v2 Operation.
+func AsV2Operation(op IOperation) gobatcher.Operation {
+	if o, ok := op.(*Operation); ok {
+		return o.op
+	}
+	return gobatcher.NewOperation(toV2Watcher(op.Watcher()), op.Cost(), op, op.IsBatchable())
+}
+
+func toV1Operations(batch []gobatcher.Operation) []IOperation {
+	ops := make([]IOperation, 0, len(batch))
+	for _, op := range batch {
+		switch v1 := op.Payload().(type) {
+		case *Operation:
+			ops = append(ops, v1)
+		case IOperation:
+			// NOTE: v1 counted an attempt when the batch was raised; Operations not created by NewOperation() keep their own count
+			v1.MakeAttempt()
+			ops = append(ops, v1)
+		}
+	}
+	return ops
+}
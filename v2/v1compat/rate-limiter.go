@@ -0,0 +1,251 @@
+package v1compat
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	gobatcher "github.com/plasne/go-batcher/v2"
+)
+
+type RateLimiter interface {
+	Provision(ctx context.Context) error
+	MaxCapacity() uint32
+	Capacity() uint32
+	GiveMe(target uint32)
+	Start(ctx context.Context) error
+	Stop()
+}
+
+// rateLimiterAdapter allows a v1 RateLimiter that is not provided by this package to be used by v2. v1 rate limiters have no concept
+// of reservations, so every reservation succeeds and capacity is only enforced by the flush.
+type rateLimiterAdapter struct {
+	gobatcher.EventerBase
+	rl RateLimiter
+}
+
+type noReservation struct {
+	cost uint32
+}
+
+func (n noReservation) Cost() uint32 {
+	return n.cost
+}
+
+func (n noReservation) Release() {}
+
+func (a *rateLimiterAdapter) MaxCapacity() uint32 {
+	return a.rl.MaxCapacity()
+}
+
+func (a *rateLimiterAdapter) Capacity() uint32 {
+	return a.rl.Capacity()
+}
+
+func (a *rateLimiterAdapter) GiveMe(target uint32) {
+	a.rl.GiveMe(target)
+}
+
+func (a *rateLimiterAdapter) Reserve(cost uint32, ttl time.Duration) (gobatcher.ReservationHandle, error) {
+	return noReservation{cost: cost}, nil
+}
+
+// v1 rate limiters cannot signal when capacity changes, so this checks Capacity() every 100ms.
+func (a *rateLimiterAdapter) WaitForCapacity(ctx context.Context, cost uint32) error {
+	if cost > a.rl.MaxCapacity() {
+		return gobatcher.TooExpensiveError
+	}
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for a.rl.Capacity() < cost {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (a *rateLimiterAdapter) Start(ctx context.Context) error {
+	return a.rl.Start(ctx)
+}
+
+func toV2RateLimiter(rl RateLimiter) gobatcher.RateLimiter {
+	switch r := rl.(type) {
+	case nil:
+		return nil
+	case *sharedResource:
+		return r.resource
+	case *ProvisionedResource:
+		return r.resource
+	case *AzureSharedResource:
+		return r.resource
+	default:
+		return &rateLimiterAdapter{rl: rl}
+	}
+}
+
+// sharedResource adapts a v2 SharedResource to the v1 RateLimiter lifecycle of Provision() > Start() > Stop(). In v2, provisioning
+// happens as part of Start() and stopping happens when the context is cancelled.
+type sharedResource struct {
+	resource gobatcher.SharedResource
+
+	phaseMutex  sync.Mutex
+	provisioned bool
+	started     bool
+	cancel      context.CancelFunc
+}
+
+func (r *sharedResource) AddListener(fn func(event string, val int, msg string, metadata interface{}), opts ...gobatcher.ListenerOption) uuid.UUID {
+	return r.resource.AddListener(fn, opts...)
+}
+
+func (r *sharedResource) RemoveListener(id uuid.UUID) {
+	r.resource.RemoveListener(id)
+}
+
+func (r *sharedResource) Provision(ctx context.Context) error {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.provisioned {
+		return RateLimiterImproperOrderError{}
+	}
+	r.provisioned = true
+	return nil
+}
+
+func (r *sharedResource) MaxCapacity() uint32 {
+	return r.resource.MaxCapacity()
+}
+
+func (r *sharedResource) Capacity() uint32 {
+	return r.resource.Capacity()
+}
+
+func (r *sharedResource) GiveMe(target uint32) {
+	r.resource.GiveMe(target)
+}
+
+func (r *sharedResource) Start(ctx context.Context) error {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if !r.provisioned || r.started {
+		return RateLimiterImproperOrderError{}
+	}
+	ctx, r.cancel = context.WithCancel(ctx)
+	if err := r.resource.Start(ctx); err != nil {
+		r.cancel()
+		return toV1Error(err)
+	}
+	r.started = true
+	return nil
+}
+
+func (r *sharedResource) Stop() {
+	r.phaseMutex.Lock()
+	defer r.phaseMutex.Unlock()
+	if r.cancel != nil {
+		r.cancel()
+	}
+}
+
+// ProvisionedResource is a RateLimiter with a fixed capacity. It is backed by a v2 SharedResource with only ReservedCapacity.
+type ProvisionedResource struct {
+	sharedResource
+}
+
+func NewProvisionedResource(capacity uint32) *ProvisionedResource {
+	r := &ProvisionedResource{}
+	r.resource = gobatcher.NewSharedResource().
+		WithReservedCapacity(capacity)
+	return r
+}
+
+// AzureSharedResource is a RateLimiter that shares capacity across processes using Azure Blob Storage. It is backed by a v2
+// SharedResource and AzureBlobLeaseManager, so the container and partitions are provisioned when Start() is called.
+type AzureSharedResource struct {
+	sharedResource
+	accountName    string
+	containerName  string
+	masterKey      string
+	credential     gobatcher.TokenCredential
+	sasToken       string
+	connString     string
+	sharedCapacity uint32
+}
+
+func NewAzureSharedResource(accountName, containerName string, sharedCapacity uint32) *AzureSharedResource {
+	r := &AzureSharedResource{
+		accountName:    accountName,
+		containerName:  containerName,
+		sharedCapacity: sharedCapacity,
+	}
+	r.resource = gobatcher.NewSharedResource()
+	return r
+}
+
+func (r *AzureSharedResource) WithMasterKey(val string) *AzureSharedResource {
+	r.masterKey = val
+	return r
+}
+
+// This authenticates with Azure AD tokens from the provided credential rather than the master key. It is not part of the v1 API.
+func (r *AzureSharedResource) WithTokenCredential(val gobatcher.TokenCredential) *AzureSharedResource {
+	r.credential = val
+	return r
+}
+
+// This authenticates with a shared access signature rather than the master key. It is not part of the v1 API.
+func (r *AzureSharedResource) WithSASToken(val string) *AzureSharedResource {
+	r.sasToken = val
+	return r
+}
+
+// This uses the endpoint and credential (AccountKey or SharedAccessSignature) from an Azure Storage connection string rather than the
+// accountName and master key. It is not part of the v1 API.
+func (r *AzureSharedResource) WithConnectionString(val string) *AzureSharedResource {
+	r.connString = val
+	return r
+}
+
+func (r *AzureSharedResource) WithFactor(val uint32) *AzureSharedResource {
+	r.resource.WithFactor(val)
+	return r
+}
+
+func (r *AzureSharedResource) WithReservedCapacity(val uint32) *AzureSharedResource {
+	r.resource.WithReservedCapacity(val)
+	return r
+}
+
+func (r *AzureSharedResource) WithMaxInterval(val uint32) *AzureSharedResource {
+	r.resource.WithMaxInterval(val)
+	return r
+}
+
+func (r *AzureSharedResource) Provision(ctx context.Context) error {
+	if r.sharedCapacity == 0 {
+		return UndefinedSharedCapacityError{}
+	}
+	if err := r.sharedResource.Provision(ctx); err != nil {
+		return err
+	}
+	mgr := gobatcher.NewAzureBlobLeaseManager(r.accountName, r.containerName, r.masterKey)
+	switch {
+	case r.connString != "":
+		mgr = gobatcher.NewAzureBlobLeaseManagerWithConnectionString(r.connString, r.containerName)
+	case r.sasToken != "":
+		mgr = gobatcher.NewAzureBlobLeaseManagerWithSASToken(r.accountName, r.containerName, r.sasToken)
+	case r.credential != nil:
+		mgr = gobatcher.NewAzureBlobLeaseManagerWithTokenCredential(r.accountName, r.containerName, r.credential)
+	}
+	r.resource.WithSharedCapacity(r.sharedCapacity, mgr)
+	return nil
+}
+
+// This returns the v2 RateLimiter behind a v1 RateLimiter so that code can be migrated incrementally.
+func AsV2RateLimiter(rl RateLimiter) gobatcher.RateLimiter {
+	return toV2RateLimiter(rl)
+}
@@ -0,0 +1,107 @@
+package v1compat_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+	"github.com/plasne/go-batcher/v2/v1compat"
+	"github.com/stretchr/testify/assert"
+)
+
+type payload struct {
+	id int
+}
+
+func TestBatcher_ProcessesV1Operations(t *testing.T) {
+	batcher := v1compat.NewBatcher().
+		WithFlushInterval(1 * time.Millisecond)
+	batches := make(chan []v1compat.IOperation, 1)
+	watcher := v1compat.NewWatcher(func(batch []v1compat.IOperation) {
+		batches <- batch
+	}).WithMaxBatchSize(10)
+	for i := 0; i < 3; i++ {
+		err := batcher.Enqueue(v1compat.NewOperation(watcher, 10, payload{id: i}, true))
+		assert.NoError(t, err, "not expecting an enqueue error")
+	}
+	err := batcher.Start()
+	assert.NoError(t, err, "not expecting a start error")
+	defer batcher.Stop()
+	select {
+	case batch := <-batches:
+		assert.Len(t, batch, 3)
+		for i, op := range batch {
+			assert.Equal(t, payload{id: i}, op.Payload(), "expecting the v1 payload rather than the v2 operation")
+			assert.Equal(t, uint32(1), op.Attempt())
+			assert.Equal(t, watcher, op.Watcher())
+		}
+	case <-time.After(1 * time.Second):
+		assert.Fail(t, "expected a batch before the timeout")
+	}
+}
+
+func TestBatcher_ErrorsAreV1Structs(t *testing.T) {
+	batcher := v1compat.NewBatcherWithBuffer(1).
+		WithErrorOnFullBuffer()
+	watcher := v1compat.NewWatcher(func(batch []v1compat.IOperation) {})
+	err := batcher.Enqueue(nil)
+	assert.Equal(t, v1compat.NoOperationError{}, err)
+	err = batcher.Enqueue(v1compat.NewOperation(nil, 0, struct{}{}, false))
+	assert.Equal(t, v1compat.NoWatcherError{}, err)
+	err = batcher.Enqueue(v1compat.NewOperation(watcher, 0, struct{}{}, false))
+	assert.NoError(t, err, "not expecting an enqueue error")
+	err = batcher.Enqueue(v1compat.NewOperation(watcher, 0, struct{}{}, false))
+	if _, ok := err.(v1compat.BufferFullError); !ok {
+		assert.Fail(t, "expecting a v1 BufferFullError")
+	}
+	assert.True(t, errors.Is(err, gobatcher.BufferFullError), "expecting the v1 error to unwrap to the v2 sentinel")
+	assert.NoError(t, batcher.Start(), "not expecting a start error")
+	assert.Equal(t, v1compat.BatcherImproperOrderError{}, batcher.Start())
+	batcher.Stop()
+	batcher.Stop() // stopping twice is allowed
+}
+
+type mockWatcher struct {
+	processed uint32
+}
+
+func (w *mockWatcher) WithMaxAttempts(val uint32) v1compat.IWatcher             { return w }
+func (w *mockWatcher) WithMaxBatchSize(val uint32) v1compat.IWatcher            { return w }
+func (w *mockWatcher) WithMaxOperationTime(val time.Duration) v1compat.IWatcher { return w }
+func (w *mockWatcher) MaxAttempts() uint32                                      { return 1 }
+func (w *mockWatcher) MaxBatchSize() uint32                                     { return 0 }
+func (w *mockWatcher) MaxOperationTime() time.Duration                          { return 0 }
+func (w *mockWatcher) ProcessBatch(ops []v1compat.IOperation) {
+	atomic.AddUint32(&w.processed, uint32(len(ops)))
+}
+
+func TestBatcher_SupportsCustomWatchers(t *testing.T) {
+	batcher := v1compat.NewBatcher().
+		WithFlushInterval(1 * time.Millisecond)
+	watcher := &mockWatcher{}
+	op := v1compat.NewOperation(watcher, 0, struct{}{}, true)
+	assert.NoError(t, batcher.Enqueue(op), "not expecting an enqueue error")
+	assert.NoError(t, batcher.Start(), "not expecting a start error")
+	defer batcher.Stop()
+	assert.Eventually(t, func() bool { return atomic.LoadUint32(&watcher.processed) == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, v1compat.TooManyAttemptsError{}, batcher.Enqueue(op), "expecting MaxAttempts of the custom watcher to be enforced")
+}
+
+func TestProvisionedResource_RateLimitsBatcher(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	res := v1compat.NewProvisionedResource(1000)
+	assert.Equal(t, v1compat.RateLimiterImproperOrderError{}, res.Start(ctx), "expecting Provision() to be required first")
+	assert.NoError(t, res.Provision(ctx), "not expecting a provision error")
+	assert.NoError(t, res.Start(ctx), "not expecting a start error")
+	defer res.Stop()
+	batcher := v1compat.NewBatcher().
+		WithRateLimiter(res)
+	watcher := v1compat.NewWatcher(func(batch []v1compat.IOperation) {})
+	err := batcher.Enqueue(v1compat.NewOperation(watcher, 2000, struct{}{}, false))
+	assert.Equal(t, v1compat.TooExpensiveError{}, err)
+	assert.Equal(t, uint32(1000), v1compat.AsV2RateLimiter(res).MaxCapacity())
+}
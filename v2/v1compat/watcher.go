@@ -0,0 +1,211 @@
+package v1compat
+
+import (
+	"sync"
+	"time"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+)
+
+type IWatcher interface {
+	WithMaxAttempts(val uint32) IWatcher
+	WithMaxBatchSize(val uint32) IWatcher
+	WithMaxOperationTime(val time.Duration) IWatcher
+	MaxAttempts() uint32
+	MaxBatchSize() uint32
+	MaxOperationTime() time.Duration
+	ProcessBatch(ops []IOperation)
+}
+
+// Watcher is backed by a v2 Watcher; the callback receives the v1 Operations.
+type Watcher struct {
+	watcher gobatcher.Watcher
+	onReady func(ops []IOperation)
+}
+
+func NewWatcher(onReady func(batch []IOperation)) IWatcher {
+	w := &Watcher{
+		onReady: onReady,
+	}
+	w.watcher = gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		w.onReady(toV1Operations(batch))
+	})
+	return w
+}
+
+func (w *Watcher) WithMaxAttempts(val uint32) IWatcher {
+	w.watcher.WithMaxAttempts(val)
+	return w
+}
+
+func (w *Watcher) WithMaxBatchSize(val uint32) IWatcher {
+	w.watcher.WithMaxBatchSize(val)
+	return w
+}
+
+func (w *Watcher) WithMaxOperationTime(val time.Duration) IWatcher {
+	w.watcher.WithMaxOperationTime(val)
+	return w
+}
+
+func (w *Watcher) MaxAttempts() uint32 {
+	return w.watcher.MaxAttempts()
+}
+
+func (w *Watcher) MaxBatchSize() uint32 {
+	return w.watcher.MaxBatchSize()
+}
+
+func (w *Watcher) MaxOperationTime() time.Duration {
+	return w.watcher.MaxOperationTime()
+}
+
+func (w *Watcher) ProcessBatch(batch []IOperation) {
+	w.onReady(batch)
+}
+
+// watcherAdapter allows an IWatcher that was not created by NewWatcher() (for instance, a mock) to be used by v2.
+type watcherAdapter struct {
+	watcher    IWatcher
+	cooldown   time.Duration
+	groupByKey bool
+	weight     uint32
+	label      string
+	maxBytes   uint32
+	minSize    uint32
+	maxLinger  time.Duration
+	splitter   func(ops []gobatcher.Operation) [][]gobatcher.Operation
+	limiter    gobatcher.RateLimiter
+}
+
+func (a *watcherAdapter) WithMaxAttempts(val uint32) gobatcher.Watcher {
+	a.watcher.WithMaxAttempts(val)
+	return a
+}
+
+func (a *watcherAdapter) WithMaxBatchSize(val uint32) gobatcher.Watcher {
+	a.watcher.WithMaxBatchSize(val)
+	return a
+}
+
+func (a *watcherAdapter) WithMaxBatchBytes(val uint32) gobatcher.Watcher {
+	a.maxBytes = val
+	return a
+}
+
+func (a *watcherAdapter) WithMinBatchSize(val uint32) gobatcher.Watcher {
+	a.minSize = val
+	return a
+}
+
+func (a *watcherAdapter) WithMaxLinger(val time.Duration) gobatcher.Watcher {
+	a.maxLinger = val
+	return a
+}
+
+func (a *watcherAdapter) WithMaxOperationTime(val time.Duration) gobatcher.Watcher {
+	a.watcher.WithMaxOperationTime(val)
+	return a
+}
+
+func (a *watcherAdapter) WithCooldown(val time.Duration) gobatcher.Watcher {
+	a.cooldown = val
+	return a
+}
+
+func (a *watcherAdapter) WithGroupByKey() gobatcher.Watcher {
+	a.groupByKey = true
+	return a
+}
+
+func (a *watcherAdapter) WithWeight(val uint32) gobatcher.Watcher {
+	a.weight = val
+	return a
+}
+
+func (a *watcherAdapter) WithLabel(val string) gobatcher.Watcher {
+	a.label = val
+	return a
+}
+
+func (a *watcherAdapter) WithSplitter(fn func(ops []gobatcher.Operation) [][]gobatcher.Operation) gobatcher.Watcher {
+	a.splitter = fn
+	return a
+}
+
+func (a *watcherAdapter) WithRateLimiter(rl gobatcher.RateLimiter) gobatcher.Watcher {
+	a.limiter = rl
+	return a
+}
+
+func (a *watcherAdapter) MaxAttempts() uint32 {
+	return a.watcher.MaxAttempts()
+}
+
+func (a *watcherAdapter) MaxBatchSize() uint32 {
+	return a.watcher.MaxBatchSize()
+}
+
+func (a *watcherAdapter) MaxBatchBytes() uint32 {
+	return a.maxBytes
+}
+
+func (a *watcherAdapter) MinBatchSize() uint32 {
+	return a.minSize
+}
+
+func (a *watcherAdapter) MaxLinger() time.Duration {
+	return a.maxLinger
+}
+
+func (a *watcherAdapter) MaxOperationTime() time.Duration {
+	return a.watcher.MaxOperationTime()
+}
+
+func (a *watcherAdapter) Cooldown() time.Duration {
+	return a.cooldown
+}
+
+func (a *watcherAdapter) GroupByKey() bool {
+	return a.groupByKey
+}
+
+func (a *watcherAdapter) Weight() uint32 {
+	return a.weight
+}
+
+func (a *watcherAdapter) Label() string {
+	return a.label
+}
+
+func (a *watcherAdapter) Splitter() func(ops []gobatcher.Operation) [][]gobatcher.Operation {
+	return a.splitter
+}
+
+func (a *watcherAdapter) RateLimiter() gobatcher.RateLimiter {
+	return a.limiter
+}
+
+func (a *watcherAdapter) ProcessBatch(batch []gobatcher.Operation) {
+	a.watcher.ProcessBatch(toV1Operations(batch))
+}
+
+// Batcher groups batches by Watcher, so the same IWatcher must always map to the same adapter.
+var adapters sync.Map
+
+// This returns the v2 Watcher behind a v1 Watcher so that code can be migrated incrementally.
+func AsV2Watcher(watcher IWatcher) gobatcher.Watcher {
+	return toV2Watcher(watcher)
+}
+
+func toV2Watcher(watcher IWatcher) gobatcher.Watcher {
+	switch w := watcher.(type) {
+	case nil:
+		return nil
+	case *Watcher:
+		return w.watcher
+	default:
+		adapter, _ := adapters.LoadOrStore(watcher, &watcherAdapter{watcher: watcher})
+		return adapter.(*watcherAdapter)
+	}
+}
@@ -1,22 +1,69 @@
 package batcher
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 type Watcher interface {
 	WithMaxAttempts(val uint32) Watcher
 	WithMaxBatchSize(val uint32) Watcher
+	WithMaxBatchBytes(val uint32) Watcher
+	WithMinBatchSize(val uint32) Watcher
+	WithMaxLinger(val time.Duration) Watcher
 	WithMaxOperationTime(val time.Duration) Watcher
+	WithCooldown(val time.Duration) Watcher
+	WithGroupByKey() Watcher
+	WithWeight(val uint32) Watcher
+	WithLabel(val string) Watcher
+	WithSplitter(fn func(ops []Operation) [][]Operation) Watcher
+	WithRateLimiter(rl RateLimiter) Watcher
 	MaxAttempts() uint32
 	MaxBatchSize() uint32
+	MaxBatchBytes() uint32
+	MinBatchSize() uint32
+	MaxLinger() time.Duration
 	MaxOperationTime() time.Duration
+	Cooldown() time.Duration
+	GroupByKey() bool
+	Weight() uint32
+	Label() string
+	Splitter() func(ops []Operation) [][]Operation
+	RateLimiter() RateLimiter
 	ProcessBatch(ops []Operation)
 }
 
+// A Watcher may optionally implement BatchWatcher to receive each batch as a Batch rather than as a slice of Operations. Batcher will
+// call ProcessWholeBatch() instead of ProcessBatch() for these Watchers.
+type BatchWatcher interface {
+	Watcher
+	ProcessWholeBatch(batch Batch)
+}
+
+// A Watcher may optionally implement ContextWatcher to receive a context that is done when MaxOperationTime is exceeded and to report
+// that processing the batch failed by returning an error. Batcher will call ProcessBatchWithContext() instead of ProcessWholeBatch() or
+// ProcessBatch() for these Watchers.
+type ContextWatcher interface {
+	Watcher
+	ProcessBatchWithContext(ctx context.Context, batch Batch) error
+}
+
 type watcher struct {
 	maxAttempts      uint32
 	maxBatchSize     uint32
+	maxBatchBytes    uint32
+	minBatchSize     uint32
+	maxLinger        time.Duration
 	maxOperationTime time.Duration
+	cooldown         time.Duration
+	groupByKey       bool
+	weight           uint32
+	label            string
+	splitter         func(ops []Operation) [][]Operation
+	ratelimiter      RateLimiter
 	onReady          func(ops []Operation)
+	onBatch          func(batch Batch)
+	onReadyWithError func(ctx context.Context, ops []Operation) error
 }
 
 // This method creates a new Watcher with a callback function. This function will be called whenever a batch of Operations is ready to be
@@ -30,6 +77,24 @@ func NewWatcher(onReady func(batch []Operation)) Watcher {
 	}
 }
 
+// This method creates a new Watcher whose callback function receives a Batch. Other than allowing the whole batch to be requeued with
+// Batch.RequeueAll(), it behaves the same as a Watcher created with NewWatcher().
+func NewBatchWatcher(onReady func(batch Batch)) Watcher {
+	return &watcher{
+		onBatch: onReady,
+	}
+}
+
+// This method creates a new Watcher whose callback function receives a context and returns an error. The context is done when
+// MaxOperationTime is exceeded, so the callback can abandon work that will no longer be waited on. If the callback returns an error,
+// every Operation in the batch that does not already have a Result is failed with that error and a batch-failed event is raised.
+// Otherwise, it behaves the same as a Watcher created with NewWatcher().
+func NewWatcherWithError(onReady func(ctx context.Context, batch []Operation) error) Watcher {
+	return &watcher{
+		onReadyWithError: onReady,
+	}
+}
+
 // If there are transient errors, you can enqueue the same Operation again. If you do not provide MaxAttempts, it will allow you to enqueue
 // as many times as you like. Instead, if you specify MaxAttempts, the Enqueue() method will return `TooManyAttemptsError` if you attempt
 // to enqueue it too many times.
@@ -45,6 +110,29 @@ func (w *watcher) WithMaxBatchSize(val uint32) Watcher {
 	return w
 }
 
+// This determines the maximum number of bytes that will be raised in a single batch, as the sum of the size hints provided by
+// Operation.WithSize(). This allows batches to respect the wire-size limits of the datastore (for instance, 4 MB for an Azure Table
+// batch) rather than guessing a conservative MaxBatchSize. An Operation that is larger than this on its own is raised in a batch by itself.
+func (w *watcher) WithMaxBatchBytes(val uint32) Watcher {
+	w.maxBatchBytes = val
+	return w
+}
+
+// Under light load, each flush would raise whatever batchable Operations have arrived since the last one, resulting in many tiny batches.
+// Setting this option holds back batchable Operations for this Watcher (and key, if grouping by key) until there are at least this many
+// in the buffer or they have lingered for MaxLinger. Operations that are not batchable are not held back.
+func (w *watcher) WithMinBatchSize(val uint32) Watcher {
+	w.minBatchSize = val
+	return w
+}
+
+// This determines the longest that Operations are held back waiting for MinBatchSize, measured from the first flush that held them back.
+// If it is not provided, Operations are held back until there are MinBatchSize of them (or Shutdown() is draining the buffer).
+func (w *watcher) WithMaxLinger(val time.Duration) Watcher {
+	w.maxLinger = val
+	return w
+}
+
 // This determines how long the system should wait for the callback function to be completed on the batch before it assumes it is done and
 // decreases the Target anyway. It is critical that the Target reflect the current cost of outstanding Operations. The MaxOperationTime
 // ensures that a batch isn't orphaned and continues reserving capacity long after it is no longer needed. If MaxOperationTime is not provided
@@ -54,6 +142,56 @@ func (w *watcher) WithMaxOperationTime(val time.Duration) Watcher {
 	return w
 }
 
+// This determines how long Batcher waits before raising another batch to this Watcher after a batch fails (any Operation in it failed,
+// was abandoned, or the Watcher panicked). Unlike Pause(), only this Watcher is held back; Operations for other Watchers continue to be
+// raised at full speed. The default is 0 (no cooldown).
+func (w *watcher) WithCooldown(val time.Duration) Watcher {
+	w.cooldown = val
+	return w
+}
+
+// Normally batchable Operations for a Watcher are packed into the same batch regardless of their key. Setting this option ensures
+// every batch only contains Operations with the same key (see Operation.WithKey()), for instance, so that a batch can be written to a
+// single Azure Table or Cosmos partition in one transaction. Operations with different keys are raised in separate batches.
+func (w *watcher) WithGroupByKey() Watcher {
+	w.groupByKey = true
+	return w
+}
+
+// Normally a flush dispatches Operations in the order they are in the buffer until it runs out of capacity, so a Watcher with many
+// Operations can starve the others. If any Watcher has a weight, a flush that does not have enough capacity for every Operation divides
+// the capacity of each rate limiter among the Watchers waiting for it in proportion to their weights (a Watcher without a weight has a
+// weight of 1). A Watcher that needs less than its share gets what it needs and the rest is divided among the others.
+func (w *watcher) WithWeight(val uint32) Watcher {
+	w.weight = val
+	return w
+}
+
+// You may provide a label (for instance, the name of the datastore) that identifies this Watcher in the batch event.
+func (w *watcher) WithLabel(val string) Watcher {
+	w.label = val
+	return w
+}
+
+// Normally each flush packs the batchable Operations for a Watcher into batches per MaxBatchSize, MaxBatchBytes, and GroupByKey. Setting
+// this option instead collects every batchable Operation the flush can dispatch to this Watcher and calls the provided function to
+// partition them into batches (for instance, by tenant). Each non-empty batch it returns is raised separately, subject to
+// MaxConcurrentBatches and MaxBatchesPerFlush; any batch that cannot be raised and any Operation that is not returned is put back in the
+// buffer for the next flush. The function is called by the processing loop, so it should be fast and must not block.
+func (w *watcher) WithSplitter(fn func(ops []Operation) [][]Operation) Watcher {
+	w.splitter = fn
+	return w
+}
+
+// Normally every Watcher draws capacity from the Batcher's rate limiter. Setting this option causes Operations for this Watcher to draw
+// from the provided rate limiter instead, so that Watchers sharing one Batcher can use different capacity pools (for instance, separate
+// Cosmos containers). The Batcher asks this rate limiter for the capacity needed by this Watcher's Operations and enforces its
+// MaxCapacity on Enqueue(). As with the Batcher's rate limiter, you must Start() it yourself.
+func (w *watcher) WithRateLimiter(rl RateLimiter) Watcher {
+	w.ratelimiter = rl
+	return w
+}
+
 // If there are transient errors, you can enqueue the same Operation again. If you do not provide MaxAttempts, it will allow you to enqueue
 // as many times as you like. Instead, if you specify MaxAttempts, the Enqueue() method will return `TooManyAttemptsError` if you attempt
 // to enqueue it too many times.
@@ -67,6 +205,21 @@ func (w *watcher) MaxBatchSize() uint32 {
 	return w.maxBatchSize
 }
 
+// This determines the maximum number of bytes (per the size hints of the Operations) that will be raised in a single batch.
+func (w *watcher) MaxBatchBytes() uint32 {
+	return w.maxBatchBytes
+}
+
+// This determines how many batchable Operations must be in the buffer before they are raised (unless they have lingered for MaxLinger).
+func (w *watcher) MinBatchSize() uint32 {
+	return w.minBatchSize
+}
+
+// This determines the longest that Operations are held back waiting for MinBatchSize.
+func (w *watcher) MaxLinger() time.Duration {
+	return w.maxLinger
+}
+
 // This determines how long the system should wait for the callback function to be completed on the batch before it assumes it is done and
 // decreases the Target anyway. It is critical that the Target reflect the current cost of outstanding Operations. The MaxOperationTime
 // ensures that a batch isn't orphaned and continues reserving capacity long after it is no longer needed. If MaxOperationTime is not provided
@@ -75,8 +228,65 @@ func (w *watcher) MaxOperationTime() time.Duration {
 	return w.maxOperationTime
 }
 
+// This determines how long Batcher waits before raising another batch to this Watcher after a batch fails.
+func (w *watcher) Cooldown() time.Duration {
+	return w.cooldown
+}
+
+// This is TRUE if batches raised to this Watcher only contain Operations with the same key.
+func (w *watcher) GroupByKey() bool {
+	return w.groupByKey
+}
+
+// This returns the weight provided by WithWeight() or 0 if there is none.
+func (w *watcher) Weight() uint32 {
+	return w.weight
+}
+
+// This returns the label provided by WithLabel() or an empty string if there is none.
+func (w *watcher) Label() string {
+	return w.label
+}
+
+// This returns the function provided by WithSplitter() or nil if there is none.
+func (w *watcher) Splitter() func(ops []Operation) [][]Operation {
+	return w.splitter
+}
+
+// This returns the rate limiter provided by WithRateLimiter() or nil if the Batcher's rate limiter is used.
+func (w *watcher) RateLimiter() RateLimiter {
+	return w.ratelimiter
+}
+
 // This is used internally by Batcher to process a batch of Operations using the callback function. You should generally not call this method,
 // but you might mock it for unit tests.
-func (w *watcher) ProcessBatch(batch []Operation) {
-	w.onReady(batch)
+func (w *watcher) ProcessBatch(ops []Operation) {
+	switch {
+	case w.onBatch != nil:
+		w.onBatch(&batch{ops: ops})
+	case w.onReadyWithError != nil:
+		_ = w.onReadyWithError(context.Background(), ops)
+	default:
+		w.onReady(ops)
+	}
+}
+
+// This is used internally by Batcher to process a Batch using the callback function. You should generally not call this method, but you
+// might mock it for unit tests.
+func (w *watcher) ProcessWholeBatch(batch Batch) {
+	if w.onBatch != nil {
+		w.onBatch(batch)
+		return
+	}
+	w.ProcessBatch(batch.Operations())
+}
+
+// This is used internally by Batcher to process a Batch using the callback function and return any error it raised. You should generally
+// not call this method, but you might mock it for unit tests.
+func (w *watcher) ProcessBatchWithContext(ctx context.Context, batch Batch) error {
+	if w.onReadyWithError != nil {
+		return w.onReadyWithError(ctx, batch.Operations())
+	}
+	w.ProcessWholeBatch(batch)
+	return nil
 }
@@ -0,0 +1,98 @@
+package batcher
+
+import "sync"
+
+// A workerJob is the whole lifecycle of a batch. It is given a function that runs the Watcher alongside the job (so the job can give up
+// on it after MaxOperationTime) without starting a goroutine.
+type workerJob func(spawn func(fn func()))
+
+// A workerPool runs jobs (in the order they were submitted) on a fixed number of long-lived goroutines rather than starting a goroutine
+// for each. Each worker has a long-lived helper goroutine that runs what its jobs spawn; spawning waits until the helper is free, so a
+// Watcher that outlives MaxOperationTime keeps its worker busy. The queue holds at most one job for each worker. A place in the queue
+// is reserved with tryReserve() before a batch is started so the processing loop never blocks on a busy pool; if none is left, the
+// batch is not started and its Operations stay in the buffer.
+type workerPool struct {
+	mutex    sync.Mutex
+	ready    *sync.Cond
+	queue    []workerJob
+	reserved int
+	capacity int
+	stopped  bool
+}
+
+// This method creates a workerPool and starts its workers.
+func newWorkerPool(workers uint32) *workerPool {
+	p := &workerPool{capacity: int(workers)}
+	p.ready = sync.NewCond(&p.mutex)
+	for i := uint32(0); i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// This reserves a place in the queue for a job that will be submitted (or unreserved) later. It returns false if the queue is full.
+func (p *workerPool) tryReserve() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if len(p.queue)+p.reserved >= p.capacity {
+		return false
+	}
+	p.reserved++
+	return true
+}
+
+// This gives back a place reserved with tryReserve() for a job that will not be submitted.
+func (p *workerPool) unreserve() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.reserved > 0 {
+		p.reserved--
+	}
+}
+
+// This queues the job in the place reserved for it with tryReserve(); it never blocks.
+func (p *workerPool) submit(job workerJob) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.reserved > 0 {
+		p.reserved--
+	}
+	p.queue = append(p.queue, job)
+	p.ready.Signal()
+}
+
+// The workers finish the jobs that were already submitted and then exit.
+func (p *workerPool) stop() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.stopped = true
+	p.ready.Broadcast()
+}
+
+func (p *workerPool) work() {
+	spawned := make(chan func())
+	defer close(spawned)
+	go func() {
+		for fn := range spawned {
+			fn()
+		}
+	}()
+	spawn := func(fn func()) {
+		spawned <- fn
+	}
+	for {
+		p.mutex.Lock()
+		for len(p.queue) == 0 && !p.stopped {
+			p.ready.Wait()
+		}
+		if len(p.queue) == 0 {
+			p.mutex.Unlock()
+			return
+		}
+		job := p.queue[0]
+		p.queue[0] = nil
+		p.queue = p.queue[1:]
+		p.mutex.Unlock()
+		job(spawn)
+	}
+}
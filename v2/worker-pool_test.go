@@ -0,0 +1,71 @@
+package batcher
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkerPool_JobsRunInOrderAndFinishAfterStop(t *testing.T) {
+	pool := newWorkerPool(1)
+	var mutex sync.Mutex
+	var order []int
+	var done sync.WaitGroup
+	done.Add(5)
+	for i := 0; i < 5; i++ {
+		i := i
+		pool.submit(func(spawn func(fn func())) {
+			defer done.Done()
+			mutex.Lock()
+			defer mutex.Unlock()
+			order = append(order, i)
+		})
+	}
+	pool.stop()
+	done.Wait()
+	assert.Equal(t, []int{0, 1, 2, 3, 4}, order, "expecting the jobs to run in the order they were submitted")
+}
+
+func TestWorkerPool_ReserveFailsWhenTheQueueIsFull(t *testing.T) {
+	pool := newWorkerPool(1)
+	defer pool.stop()
+	release := make(chan struct{})
+	defer close(release)
+	blocked := func(spawn func(fn func())) {
+		<-release
+	}
+	assert.True(t, pool.tryReserve(), "expecting a place in an empty queue")
+	pool.submit(blocked) // picked up by the worker
+	assert.Eventually(t, func() bool {
+		pool.mutex.Lock()
+		defer pool.mutex.Unlock()
+		return len(pool.queue) == 0
+	}, time.Second, time.Millisecond, "expecting the worker to pick up the first job")
+	assert.True(t, pool.tryReserve(), "expecting a place while the worker is busy")
+	assert.False(t, pool.tryReserve(), "expecting the reserved place to fill the queue")
+	pool.unreserve()
+	assert.True(t, pool.tryReserve(), "expecting an unreserved place to be available again")
+	pool.submit(blocked) // waits in the queue
+	assert.False(t, pool.tryReserve(), "expecting the queued job to fill the queue")
+}
+
+func TestWorkerPool_SpawnedFunctionsRunOnTheHelper(t *testing.T) {
+	pool := newWorkerPool(1)
+	defer pool.stop()
+	ran := make(chan struct{})
+	pool.submit(func(spawn func(fn func())) {
+		finished := make(chan struct{})
+		spawn(func() {
+			close(finished)
+		})
+		<-finished
+		close(ran)
+	})
+	select {
+	case <-ran:
+	case <-time.After(time.Second):
+		assert.Fail(t, "expecting the spawned function to run alongside the job")
+	}
+}
@@ -0,0 +1,104 @@
+// Package xrate allows a *rate.Limiter from golang.org/x/time/rate to be used as the RateLimiter for a Batcher so that an existing
+// limiter configuration (and the tests built around it) can be reused.
+package xrate
+
+import (
+	"context"
+	"math"
+	"time"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+	"golang.org/x/time/rate"
+)
+
+// RateLimiter adapts a *rate.Limiter to the RateLimiter interface. The cost of an Operation is the number of tokens it takes from
+// the limiter. The Capacity is the limit (tokens per second) and the MaxCapacity is the burst, since a *rate.Limiter can never allow
+// more tokens than its burst at once. Each batch takes its tokens at once, so the burst should be at least the limit multiplied by
+// the Batcher's FlushInterval or batches that are larger than the burst will never be dispatched.
+type RateLimiter struct {
+	gobatcher.EventerBase
+	limiter *rate.Limiter
+}
+
+// This method creates a RateLimiter that takes tokens from the provided *rate.Limiter. The limiter may still be used (or have its
+// limit and burst changed) elsewhere; Batcher will only dispatch batches for which the limiter has tokens available.
+func NewRateLimiter(limiter *rate.Limiter) *RateLimiter {
+	return &RateLimiter{
+		limiter: limiter,
+	}
+}
+
+// This returns the burst of the limiter (or the largest possible capacity if the limit is infinite).
+func (r *RateLimiter) MaxCapacity() uint32 {
+	if r.limiter.Limit() == rate.Inf {
+		return math.MaxUint32
+	}
+	return uint32(r.limiter.Burst())
+}
+
+// This returns the limit of the limiter in tokens per second (or the largest possible capacity if the limit is infinite).
+func (r *RateLimiter) Capacity() uint32 {
+	limit := r.limiter.Limit()
+	if limit == rate.Inf || float64(limit) >= math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(limit)
+}
+
+// The limiter does not change its limit based on demand, so the target is only raised as a request event.
+func (r *RateLimiter) GiveMe(target uint32) {
+	r.Emit(gobatcher.RequestEvent, int(target), "", nil)
+}
+
+// This takes cost tokens from the limiter if they are available now. If they are not, no tokens are taken and InsufficientCapacityError
+// is returned so the batch waits for a later flush. Tokens are spent once taken, so releasing the reservation does not return them.
+func (r *RateLimiter) Reserve(cost uint32, ttl time.Duration) (gobatcher.ReservationHandle, error) {
+	now := time.Now()
+	reservation := r.limiter.ReserveN(now, int(cost))
+	if !reservation.OK() || reservation.DelayFrom(now) > 0 {
+		reservation.CancelAt(now)
+		return nil, gobatcher.InsufficientCapacityError
+	}
+	return spent(cost), nil
+}
+
+// This blocks until the limiter will have cost tokens available, without taking them. If the cost exceeds the burst,
+// TooExpensiveError is returned since the tokens could never be available at once. If the context is done first, its error is returned.
+func (r *RateLimiter) WaitForCapacity(ctx context.Context, cost uint32) error {
+	now := time.Now()
+	reservation := r.limiter.ReserveN(now, int(cost))
+	if !reservation.OK() {
+		return gobatcher.TooExpensiveError
+	}
+	delay := reservation.DelayFrom(now)
+	reservation.CancelAt(now)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// There is nothing to start; this only exists to satisfy the RateLimiter interface.
+func (r *RateLimiter) Start(ctx context.Context) error {
+	return nil
+}
+
+// This returns the *rate.Limiter that is being adapted.
+func (r *RateLimiter) Limiter() *rate.Limiter {
+	return r.limiter
+}
+
+type spent uint32
+
+func (s spent) Cost() uint32 {
+	return uint32(s)
+}
+
+func (s spent) Release() {}
@@ -0,0 +1,73 @@
+package xrate_test
+
+import (
+	"context"
+	"math"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gobatcher "github.com/plasne/go-batcher/v2"
+	"github.com/plasne/go-batcher/v2/xrate"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+)
+
+func TestRateLimiter_CapacityIsLimitAndMaxCapacityIsBurst(t *testing.T) {
+	rl := xrate.NewRateLimiter(rate.NewLimiter(100, 20))
+	assert.Equal(t, uint32(100), rl.Capacity())
+	assert.Equal(t, uint32(20), rl.MaxCapacity())
+	inf := xrate.NewRateLimiter(rate.NewLimiter(rate.Inf, 0))
+	assert.Equal(t, uint32(math.MaxUint32), inf.Capacity())
+	assert.Equal(t, uint32(math.MaxUint32), inf.MaxCapacity())
+}
+
+func TestRateLimiter_ReserveTakesTokensOnlyWhenAvailable(t *testing.T) {
+	limiter := rate.NewLimiter(1, 10)
+	rl := xrate.NewRateLimiter(limiter)
+	handle, err := rl.Reserve(8, time.Second)
+	assert.NoError(t, err, "expecting the burst to cover the reservation")
+	assert.Equal(t, uint32(8), handle.Cost())
+	handle.Release()
+	_, err = rl.Reserve(8, time.Second)
+	assert.Equal(t, gobatcher.InsufficientCapacityError, err)
+	assert.InDelta(t, 2.0, limiter.Tokens(), 0.1, "expecting the failed reservation to leave the tokens in place")
+}
+
+func TestRateLimiter_WaitForCapacityDoesNotTakeTokens(t *testing.T) {
+	limiter := rate.NewLimiter(100, 10)
+	rl := xrate.NewRateLimiter(limiter)
+	_, err := rl.Reserve(10, time.Second)
+	assert.NoError(t, err, "expecting the burst to cover the reservation")
+	started := time.Now()
+	err = rl.WaitForCapacity(context.Background(), 5)
+	assert.NoError(t, err, "expecting the tokens to become available")
+	assert.GreaterOrEqual(t, time.Since(started), 40*time.Millisecond, "expecting to wait for 5 tokens at 100 per second")
+	assert.InDelta(t, 5.0, limiter.Tokens(), 1.0, "expecting the tokens to be left in place")
+	err = rl.WaitForCapacity(context.Background(), 20)
+	assert.Equal(t, gobatcher.TooExpensiveError, err, "expecting a cost over the burst to never be available")
+}
+
+func TestRateLimiter_BatcherIsLimited(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rl := xrate.NewRateLimiter(rate.NewLimiter(1000, 5))
+	batcher := gobatcher.NewBatcher().
+		WithRateLimiter(rl).
+		WithFlushInterval(10 * time.Millisecond)
+	var processed uint32
+	watcher := gobatcher.NewWatcher(func(batch []gobatcher.Operation) {
+		atomic.AddUint32(&processed, uint32(len(batch)))
+	})
+	err := batcher.Enqueue(gobatcher.NewOperation(watcher, 6, struct{}{}, false))
+	assert.Equal(t, gobatcher.TooExpensiveError, err, "expecting the burst to be the max cost")
+	for i := 0; i < 10; i++ {
+		err := batcher.Enqueue(gobatcher.NewOperation(watcher, 5, struct{}{}, false))
+		assert.NoError(t, err, "not expecting an enqueue error")
+	}
+	err = rl.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	err = batcher.Start(ctx)
+	assert.NoError(t, err, "not expecting a start error")
+	assert.Eventually(t, func() bool { return atomic.LoadUint32(&processed) == 10 }, time.Second, 10*time.Millisecond)
+}